@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
 	"github.com/p2p-vpn/p2p-vpn/ui/desktop"
 	"github.com/p2p-vpn/p2p-vpn/ui/desktop/common"
 )
@@ -65,6 +66,7 @@ func main() {
 		Theme:          *theme,
 		StartMinimized: *minimized,
 		AutoStart:      false, // Será definido mais tarde se necessário
+		PendingPeersFile: discovery.PendingStorePath(*configPath),
 		Assets: common.UIAssets{
 			IconPath:            filepath.Join(assetsDir, "icons", "app_icon.png"),
 			TrayIconPath:        filepath.Join(assetsDir, "icons", "tray_disconnected.png"),
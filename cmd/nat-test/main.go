@@ -11,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/p2p-vpn/p2p-vpn/core"
 	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
 )
 
@@ -24,9 +25,12 @@ func main() {
 
 	// Flags para o comando "diagnose"
 	diagnoseStunServer := diagnoseCmd.String("stun", "stun.l.google.com:19302", "Servidor STUN para diagnóstico")
+	diagnoseLifetime := diagnoseCmd.Duration("lifetime", 0, "Maior ociosidade testada ao medir o tempo de vida do mapeamento (0 para não medir; requer servidor RFC 5780)")
 	
 	// Flags para o comando "server"
 	serverPort := serverCmd.Int("port", 8888, "Porta para escutar conexões")
+	serverSTUN := serverCmd.String("stun", "", "Também executar um servidor STUN neste endereço (ex: :3478)")
+	serverSTUNAlternate := serverCmd.String("stun-alternate", "", "Endereço alternativo (IP:porta) do servidor STUN, para os testes RFC 5780")
 	
 	// Flags para o comando "client"
 	clientTarget := clientCmd.String("target", "", "Endereço do servidor (host:porta)")
@@ -43,6 +47,10 @@ func main() {
 	simulateInternalNet := simulateCmd.String("internal", "192.168.0.0/24", "Rede interna simulada (CIDR)")
 	simulateExternalPort := simulateCmd.Int("extport", 11000, "Porta externa para o simulador")
 	simulateInternalPort := simulateCmd.Int("intport", 11001, "Porta interna para o simulador")
+	simulateTimeout := simulateCmd.Duration("timeout", nattraversal.DefaultNATMappingTimeout, "Inatividade após a qual um mapeamento expira")
+	simulateLoss := simulateCmd.Float64("loss", 0, "Fração dos pacotes descartados ao atravessar o NAT (0 a 1)")
+	simulateLatency := simulateCmd.Duration("latency", 0, "Atraso de cada travessia do NAT")
+	simulateJitter := simulateCmd.Duration("jitter", 0, "Variação máxima do atraso")
 
 	// Verificar se foi passado um subcomando
 	if len(os.Args) < 2 {
@@ -54,10 +62,10 @@ func main() {
 	switch os.Args[1] {
 	case "diagnose":
 		diagnoseCmd.Parse(os.Args[2:])
-		runDiagnose(*diagnoseStunServer)
+		runDiagnose(*diagnoseStunServer, *diagnoseLifetime)
 	case "server":
 		serverCmd.Parse(os.Args[2:])
-		runServer(*serverPort)
+		runServer(*serverPort, *serverSTUN, *serverSTUNAlternate)
 	case "client":
 		clientCmd.Parse(os.Args[2:])
 		if *clientTarget == "" {
@@ -78,7 +86,8 @@ func main() {
 		simulateCmd.Parse(os.Args[2:])
 		simType := nattraversal.NATSimulatorType(*simulateType)
 		runSimulator(simType, *simulateExternalIP, *simulateInternalNet, 
-			*simulateExternalPort, *simulateInternalPort)
+			*simulateExternalPort, *simulateInternalPort,
+			*simulateTimeout, *simulateLoss, *simulateLatency, *simulateJitter)
 	default:
 		printUsage()
 		os.Exit(1)
@@ -100,7 +109,7 @@ func printUsage() {
 }
 
 // runDiagnose executa o diagnóstico de NAT
-func runDiagnose(stunServerAddr string) {
+func runDiagnose(stunServerAddr string, lifetime time.Duration) {
 	fmt.Println("Iniciando diagnóstico de NAT...")
 	fmt.Printf("Usando servidor STUN: %s\n", stunServerAddr)
 	
@@ -119,6 +128,9 @@ func runDiagnose(stunServerAddr string) {
 	
 	// Executar diagnóstico
 	diagnostic := nattraversal.NewNATDiagnostic([]nattraversal.STUNServer{stunServer})
+	if lifetime > 0 {
+		diagnostic.SetMappingProbe(nattraversal.DefaultMappingProbeMin, lifetime)
+	}
 	result, err := diagnostic.RunDiagnosis()
 	if err != nil {
 		fmt.Printf("Erro durante o diagnóstico: %v\n", err)
@@ -130,6 +142,16 @@ func runDiagnose(stunServerAddr string) {
 	fmt.Printf("IP Local: %s:%d\n", result.LocalIP, result.LocalPort)
 	fmt.Printf("IP Público: %s:%d\n", result.PublicIP, result.PublicPort)
 	fmt.Printf("Tipo de NAT: %s\n", result.NATType)
+	if result.Mapping != nattraversal.BehaviorUnknown {
+		fmt.Printf("Mapeamento: %s\n", result.Mapping)
+		fmt.Printf("Filtragem: %s\n", result.Filtering)
+		fmt.Printf("Hairpinning: %v\n", result.Hairpinning)
+	}
+	if result.MappingLifetime > 0 {
+		fmt.Printf("Tempo de vida do mapeamento: pelo menos %s\n", result.MappingLifetime)
+		fmt.Printf("Keepalive sugerido: %ds\n",
+			core.KeepaliveInterval(result.NATType != nattraversal.NATOpen, result.MappingLifetime))
+	}
 	fmt.Printf("Servidor STUN: %s\n", result.STUNServer)
 	fmt.Printf("Data/Hora: %s\n", result.TestTime.Format(time.RFC1123))
 	
@@ -152,9 +174,19 @@ func runDiagnose(stunServerAddr string) {
 }
 
 // runServer inicia um servidor de teste para NAT traversal
-func runServer(port int) {
+func runServer(port int, stunListen, stunAlternate string) {
 	fmt.Printf("Iniciando servidor de teste na porta %d...\n", port)
 	
+	// Servidor STUN opcional, para diagnosticar sem depender de servidores públicos
+	if stunListen != "" {
+		stunService := nattraversal.NewSTUNService(stunListen, stunAlternate)
+		if err := stunService.Start(); err != nil {
+			fmt.Printf("Erro ao iniciar servidor STUN: %v\n", err)
+			os.Exit(1)
+		}
+		defer stunService.Stop()
+	}
+	
 	// Criar o servidor de teste
 	server, err := nattraversal.NewTestServer(port)
 	if err != nil {
@@ -331,7 +363,7 @@ func runPunchTest(rendezvousServer, id string, localPort int) {
 
 // runSimulator executa um simulador de NAT
 func runSimulator(natType nattraversal.NATSimulatorType, externalIP, internalNet string, 
-	externalPort, internalPort int) {
+	externalPort, internalPort int, timeout time.Duration, loss float64, latency, jitter time.Duration) {
 	
 	// Criar o simulador de NAT
 	natTypeStr := ""
@@ -358,6 +390,12 @@ func runSimulator(natType nattraversal.NATSimulatorType, externalIP, internalNet
 		fmt.Printf("Erro ao criar simulador: %v\n", err)
 		os.Exit(1)
 	}
+	simulator.SetMappingTimeout(timeout)
+	simulator.SetPacketLoss(loss)
+	simulator.SetLatency(latency, jitter)
+	if loss > 0 || latency > 0 || jitter > 0 {
+		fmt.Printf("Perda: %.1f%%, atraso: %s, variação: %s\n", loss*100, latency, jitter)
+	}
 	
 	// Iniciar o simulador
 	err = simulator.Start(externalPort, internalPort)
@@ -393,7 +431,8 @@ func runSimulator(natType nattraversal.NATSimulatorType, externalIP, internalNet
 	fmt.Println("  list     - Lista os mapeamentos de NAT ativos")
 	fmt.Println("  help     - Mostra esta ajuda")
 	fmt.Println("  quit     - Encerra o simulador")
-	fmt.Println("Pressione Ctrl+C para encerrar.\n")
+	fmt.Println("Pressione Ctrl+C para encerrar.")
+	fmt.Println()
 	
 	// Loop principal
 	running := true
@@ -435,7 +474,8 @@ func runSimulator(natType nattraversal.NATSimulatorType, externalIP, internalNet
 				fmt.Println("\nComandos disponíveis:")
 				fmt.Println("  list     - Lista os mapeamentos de NAT ativos")
 				fmt.Println("  help     - Mostra esta ajuda")
-				fmt.Println("  quit     - Encerra o simulador\n")
+				fmt.Println("  quit     - Encerra o simulador")
+				fmt.Println()
 				
 			case "":
 				// Ignorar linha vazia
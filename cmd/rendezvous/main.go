@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+func main() {
+	// Configurações via linha de comando
+	port := flag.Int("port", discovery.DefaultRendezvousPort, "Porta UDP para o serviço de rendezvous")
+	keyPath := flag.String("key", "", "Arquivo com a chave de assinatura do servidor (criado se não existir)")
+	allow := flag.String("allow", "", "Chaves WireGuard autorizadas a se registrar, separadas por vírgula (vazio permite qualquer nó)")
+	flag.Parse()
+
+	signingKey, err := loadSigningKey(*keyPath)
+	if err != nil {
+		fmt.Printf("Erro ao carregar chave de assinatura: %v\n", err)
+		os.Exit(1)
+	}
+
+	server, err := discovery.NewRendezvousServer(*port, signingKey)
+	if err != nil {
+		fmt.Printf("Erro ao criar servidor de rendezvous: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *allow != "" {
+		var keys []string
+		for _, key := range strings.Split(*allow, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		server.SetAllowedKeys(keys)
+		fmt.Printf("Registro restrito a %d chaves autorizadas\n", len(keys))
+	}
+
+	if err := server.Start(); err != nil {
+		fmt.Printf("Erro ao iniciar servidor de rendezvous: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Servidor de rendezvous escutando na porta %d\n", *port)
+	fmt.Printf("Chave de assinatura do servidor: %s\n", server.PublicKey())
+	fmt.Printf("Configure os nós com: %s@<host>:%d\n", server.PublicKey(), *port)
+	fmt.Println("Pressione Ctrl+C para encerrar.")
+
+	// Aguardar sinal de encerramento
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	fmt.Println("\nEncerrando servidor de rendezvous...")
+	if err := server.Stop(); err != nil {
+		fmt.Printf("Erro ao encerrar servidor: %v\n", err)
+	}
+}
+
+// loadSigningKey lê a semente Ed25519 do arquivo, criando-a se necessário.
+// Sem arquivo, uma chave temporária é gerada a cada execução.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("arquivo de chave inválido: %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(key.Seed()) + "\n"
+	if err := ioutil.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, fmt.Errorf("erro ao salvar chave: %w", err)
+	}
+
+	return key, nil
+}
@@ -32,6 +32,73 @@ type Config struct {
 	
 	// Configuração da interface
 	InterfaceName string `yaml:"interfaceName,omitempty"` // Nome da interface (padrão: wg0)
+	
+	// Configuração da descoberta de peers
+	Discovery    DiscoveryConfig `yaml:"discovery,omitempty"`
+	
+	// Configuração de NAT traversal
+	NAT          NATConfig `yaml:"nat,omitempty"`
+}
+
+// NATConfig contém as opções de detecção de NAT e do servidor STUN embutido
+// NATConfig contains the NAT detection and embedded STUN server options
+// NATConfig contiene las opciones de detección de NAT y del servidor STUN integrado
+type NATConfig struct {
+	// Servidores STUN (host:porta) consultados em ordem; outros nós da rede que executam o
+	// servidor embutido podem ser listados aqui. Vazio usa os servidores públicos padrão.
+	STUNServers   []string `yaml:"stunServers,omitempty"`
+	
+	// Servidor STUN embutido: endereço de escuta (ex: ":3478"), vazio para desativar
+	STUNListen    string `yaml:"stunListen,omitempty"`
+	// Endereço alternativo (IP:porta) com outro IP e outra porta, que ativa os testes RFC 5780
+	STUNAlternate string `yaml:"stunAlternate,omitempty"`
+	
+	// Protocolos de mapeamento de porta no gateway (pcp, natpmp, upnp) na ordem de tentativa.
+	// Vazio usa pcp, natpmp e upnp; ["none"] desativa o mapeamento.
+	PortMapping   []string `yaml:"portMapping,omitempty"`
+	
+	// Servidores TURN ("usuário:senha@host:porta") usados como relay quando não há caminho
+	// direto até um peer, como atrás de NAT simétrico
+	TURNServers   []string `yaml:"turnServers,omitempty"`
+	
+	// Relay entre peers: endereço de escuta (ex: ":3479") para operar um relay que repassa o
+	// tráfego dos peers confiáveis sem caminho direto; vazio para não operar relay
+	RelayListen   string `yaml:"relayListen,omitempty"`
+}
+
+// DiscoveryConfig contém as opções do serviço de descoberta de peers
+// DiscoveryConfig contains the peer discovery service options
+// DiscoveryConfig contiene las opciones del servicio de descubrimiento de pares
+type DiscoveryConfig struct {
+	// Backends de descoberta ativos além dos ativados pelas opções abaixo
+	// (static, multicast, rendezvous, dht, dns). O backend static está sempre ativo.
+	Backends      []string `yaml:"backends,omitempty"`
+	
+	Multicast     bool `yaml:"multicast,omitempty"`     // Descobrir nós da mesma LAN via multicast
+	MulticastPort int  `yaml:"multicastPort,omitempty"` // Porta dos grupos multicast (padrão: 51822)
+	
+	// Servidores de rendezvous (host:porta) usados para encontrar peers atrás de NAT
+	RendezvousServers []string `yaml:"rendezvousServers,omitempty"`
+	
+	// DHT Kademlia sobre o socket de descoberta, sem depender de servidores
+	DHT          bool     `yaml:"dht,omitempty"`
+	DHTBootstrap []string `yaml:"dhtBootstrap,omitempty"` // Endereços de descoberta (host:porta) para entrar no DHT
+	
+	// Descoberta por registros SRV/TXT assinados sob um domínio, para redes que só liberam DNS
+	DNSDomain    string          `yaml:"dnsDomain,omitempty"`
+	DNSServer    string          `yaml:"dnsServer,omitempty"` // Servidor DNS (host:porta); vazio usa o do sistema
+	DNSUpdate    DNSUpdateConfig `yaml:"dnsUpdate,omitempty"`
+}
+
+// DNSUpdateConfig contém as opções de publicação do próprio registro via atualização dinâmica (RFC 2136)
+// DNSUpdateConfig contains the options to publish the node record via dynamic update (RFC 2136)
+// DNSUpdateConfig contiene las opciones para publicar el registro del nodo mediante actualización dinámica (RFC 2136)
+type DNSUpdateConfig struct {
+	Server        string `yaml:"server,omitempty"`        // Servidor primário da zona (host:porta)
+	Zone          string `yaml:"zone,omitempty"`          // Zona atualizada (padrão: o domínio de descoberta)
+	TSIGKeyName   string `yaml:"tsigKeyName,omitempty"`   // Nome da chave TSIG
+	TSIGSecret    string `yaml:"tsigSecret,omitempty"`    // Segredo TSIG em base64
+	TSIGAlgorithm string `yaml:"tsigAlgorithm,omitempty"` // hmac-sha256 (padrão) ou hmac-sha512
 }
 
 // TrustedPeer representa um peer remoto confiável
@@ -47,6 +114,9 @@ type TrustedPeer struct {
 	// Campos adicionais para WireGuard
 	AllowedIPs  []string `yaml:"allowedIps,omitempty"`  // IPs permitidos através deste peer
 	KeepAlive   int      `yaml:"keepAlive,omitempty"`   // Intervalo de keepalive em segundos
+
+	// Chave Ed25519 (base64) que assina os anúncios de descoberta deste peer
+	SigningKey  string   `yaml:"signingKey,omitempty"`
 }
 
 // LoadConfig carrega a configuração a partir de um arquivo YAML
@@ -133,6 +203,58 @@ func GenerateDefaultConfig(path string) *Config {
 	return config
 }
 
+// CopyTrustedPeers retorna uma cópia dos peers confiáveis, incluindo as listas de cada peer
+// CopyTrustedPeers returns a copy of the trusted peers, including each peer's lists
+// CopyTrustedPeers devuelve una copia de los peers confiables, incluidas las listas de cada peer
+func (c *Config) CopyTrustedPeers() []TrustedPeer {
+	peers := make([]TrustedPeer, len(c.TrustedPeers))
+	for i, peer := range c.TrustedPeers {
+		peers[i] = peer.clone()
+	}
+	return peers
+}
+
+// clone copia o peer sem compartilhar as listas com a configuração
+func (p TrustedPeer) clone() TrustedPeer {
+	p.Endpoints = append([]string(nil), p.Endpoints...)
+	p.AllowedIPs = append([]string(nil), p.AllowedIPs...)
+	return p
+}
+
+// maxPeerEndpoints limita quantos endpoints um peer guarda na configuração
+const maxPeerEndpoints = 8
+
+// RecordEndpoint registra um endpoint observado como o mais recente do peer. O primeiro
+// endpoint (o configurado) é mantido; os demais ficam sem repetição e, acima do limite,
+// os mais antigos são descartados.
+// RecordEndpoint records an observed endpoint as the peer's most recent one. The first
+// (configured) endpoint is kept; the others are deduplicated and, past the limit, the
+// oldest ones are dropped.
+// RecordEndpoint registra un endpoint observado como el más reciente del peer. El primer
+// endpoint (el configurado) se mantiene; los demás no se repiten y, por encima del límite,
+// se descartan los más antiguos.
+func (p *TrustedPeer) RecordEndpoint(endpoint string) {
+	if len(p.Endpoints) == 0 {
+		p.Endpoints = []string{endpoint}
+		return
+	}
+	if p.Endpoints[0] == endpoint {
+		return
+	}
+
+	learned := make([]string, 0, len(p.Endpoints))
+	for _, ep := range p.Endpoints[1:] {
+		if ep != endpoint {
+			learned = append(learned, ep)
+		}
+	}
+	learned = append(learned, endpoint)
+	if len(learned) > maxPeerEndpoints-1 {
+		learned = learned[len(learned)-(maxPeerEndpoints-1):]
+	}
+	p.Endpoints = append([]string{p.Endpoints[0]}, learned...)
+}
+
 // AddTrustedPeer adiciona um peer confiável à configuração
 func (c *Config) AddTrustedPeer(peer TrustedPeer) {
 	// Verificar se o peer já existe
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// signingKeyContext separa a derivação da chave de assinatura de outros usos da chave WireGuard
+const signingKeyContext = "p2p-vpn/discovery-signing-key/v1"
+
+// SigningKey deriva a chave Ed25519 usada para assinar mensagens de descoberta
+// SigningKey derives the Ed25519 key used to sign discovery messages
+// SigningKey deriva la clave Ed25519 usada para firmar mensajes de descubrimiento
+//
+// A chave Curve25519 do WireGuard não pode assinar mensagens, então derivamos
+// deterministicamente uma chave Ed25519 a partir da chave privada do nó.
+// Assim nós existentes ganham uma identidade de assinatura sem migrar a configuração.
+func (c *Config) SigningKey() (ed25519.PrivateKey, error) {
+	privateKeyBytes, err := base64.StdEncoding.DecodeString(c.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar chave privada: %w", err)
+	}
+	if len(privateKeyBytes) != 32 {
+		return nil, fmt.Errorf("chave privada com tamanho inválido: %d bytes", len(privateKeyBytes))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(signingKeyContext))
+	h.Write(privateKeyBytes)
+	seed := h.Sum(nil)
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// SigningPublicKey retorna a chave pública de assinatura do nó codificada em base64
+// SigningPublicKey returns the node's signing public key encoded in base64
+// SigningPublicKey devuelve la clave pública de firma del nodo codificada en base64
+func (c *Config) SigningPublicKey() (string, error) {
+	key, err := c.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), nil
+}
+
+// ParseSigningPublicKey decodifica e valida uma chave pública de assinatura em base64, como a
+// informada em 'peer add --signing-key'
+// ParseSigningPublicKey decodes and validates a base64 signing public key
+// ParseSigningPublicKey decodifica y valida una clave pública de firma en base64
+func ParseSigningPublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar chave de assinatura: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("chave de assinatura com tamanho inválido: %d bytes", len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/platform"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultKeepalive é o keepalive, em segundos, de um caminho atrás de NAT enquanto o tempo
+// de vida do mapeamento não foi medido; abaixo dos timeouts UDP mais curtos dos roteadores
+const DefaultKeepalive = 25
+
+// KeepaliveInterval calcula o keepalive, em segundos, de um caminho até um peer. Caminhos que
+// não atravessam NAT ficam sem keepalive (zero), poupando bateria em dispositivos móveis. Atrás
+// de NAT, o keepalive é metade do tempo de vida medido do mapeamento, para que a perda de um
+// keepalive ainda não deixe o mapeamento expirar, ou DefaultKeepalive sem medição.
+// KeepaliveInterval computes the keepalive, in seconds, of a path to a peer
+// KeepaliveInterval calcula el keepalive, en segundos, de un camino hacia un peer
+func KeepaliveInterval(behindNAT bool, mappingLifetime time.Duration) int {
+	if !behindNAT {
+		return 0
+	}
+	if mappingLifetime <= 0 {
+		return DefaultKeepalive
+	}
+
+	interval := int(mappingLifetime / 2 / time.Second)
+	if interval < 1 {
+		interval = 1
+	}
+	return interval
+}
+
+// UpdatePeerKeepalive ajusta o keepalive WireGuard do peer ao caminho escolhido pela travessia
+// de NAT, aplicando-o na interface em execução. Um KeepAlive configurado no peer prevalece.
+// UpdatePeerKeepalive adjusts the peer's WireGuard keepalive to the path chosen by NAT traversal
+// UpdatePeerKeepalive ajusta el keepalive WireGuard del peer al camino elegido por el NAT traversal
+func (v *VPNCore) UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	peer, ok := findTrustedPeer(v.config, nodeID)
+	if !ok {
+		return fmt.Errorf("peer %s não encontrado", nodeID)
+	}
+
+	interval := KeepaliveInterval(behindNAT, mappingLifetime)
+	if current, ok := v.activeKeepalives[nodeID]; ok && current == interval {
+		return nil
+	}
+	v.activeKeepalives[nodeID] = interval
+
+	if peer.KeepAlive > 0 || !v.running {
+		return nil
+	}
+	return v.updateWireGuardPeerKeepalive(peer, interval)
+}
+
+// UpdatePeerKeepalive ajusta o keepalive WireGuard do peer ao caminho escolhido pela travessia
+// de NAT, reaplicando o peer na interface em execução. Um KeepAlive configurado no peer prevalece.
+// UpdatePeerKeepalive adjusts the peer's WireGuard keepalive to the path chosen by NAT traversal
+// UpdatePeerKeepalive ajusta el keepalive WireGuard del peer al camino elegido por el NAT traversal
+func (v *VPNCoreMulti) UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	peer, ok := findTrustedPeer(v.config, nodeID)
+	if !ok {
+		return fmt.Errorf("peer %s não encontrado", nodeID)
+	}
+
+	interval := KeepaliveInterval(behindNAT, mappingLifetime)
+	if current, ok := v.activeKeepalives[nodeID]; ok && current == interval {
+		return nil
+	}
+	v.activeKeepalives[nodeID] = interval
+
+	if peer.KeepAlive > 0 || !v.running {
+		return nil
+	}
+	if err := v.addPeer(peer); err != nil {
+		return err
+	}
+
+	fmt.Printf("Keepalive do peer %s ajustado para %s\n", nodeID, describeKeepalive(interval))
+	return nil
+}
+
+// peerKeepalive retorna o keepalive a aplicar ao peer em AddPeer da plataforma: o configurado
+// no peer, o ajustado pela travessia de NAT (platform.KeepaliveOff se desativado) ou zero,
+// que mantém o atual
+func (v *VPNCoreMulti) peerKeepalive(peer TrustedPeer) int {
+	if peer.KeepAlive > 0 {
+		return peer.KeepAlive
+	}
+	interval, ok := v.activeKeepalives[peer.NodeID]
+	if !ok {
+		return 0
+	}
+	if interval == 0 {
+		return platform.KeepaliveOff
+	}
+	return interval
+}
+
+// updateWireGuardPeerKeepalive altera apenas o keepalive de um peer na interface; zero desativa
+// updateWireGuardPeerKeepalive changes only a peer's keepalive on the interface
+// updateWireGuardPeerKeepalive cambia solo el keepalive de un peer en la interfaz
+func (v *VPNCore) updateWireGuardPeerKeepalive(peer TrustedPeer, interval int) error {
+	if !v.running {
+		return fmt.Errorf("o serviço de VPN não está em execução")
+	}
+
+	// Decodificar a chave pública do peer
+	peerPublicKeyBytes, err := base64.StdEncoding.DecodeString(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("erro ao decodificar chave pública do peer: %w", err)
+	}
+
+	var peerPublicKey wgtypes.Key
+	copy(peerPublicKey[:], peerPublicKeyBytes)
+
+	keepalive := time.Duration(interval) * time.Second
+	deviceConfig := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   peerPublicKey,
+			UpdateOnly:                  true,
+			PersistentKeepaliveInterval: &keepalive,
+		}},
+	}
+
+	if err := v.wgClient.ConfigureDevice(v.interfaceName, deviceConfig); err != nil {
+		return fmt.Errorf("erro ao atualizar keepalive do peer: %w", err)
+	}
+
+	fmt.Printf("Keepalive do peer %s ajustado para %s\n", peer.NodeID, describeKeepalive(interval))
+	return nil
+}
+
+// findTrustedPeer retorna uma cópia do peer confiável com o nodeID informado. Deve ser
+// chamada com o mutex do provedor travado.
+func findTrustedPeer(config *Config, nodeID string) (TrustedPeer, bool) {
+	for _, peer := range config.TrustedPeers {
+		if peer.NodeID == nodeID {
+			return peer.clone(), true
+		}
+	}
+	return TrustedPeer{}, false
+}
+
+// describeKeepalive descreve um keepalive em segundos para os logs
+func describeKeepalive(interval int) string {
+	if interval == 0 {
+		return "desativado"
+	}
+	return fmt.Sprintf("%ds", interval)
+}
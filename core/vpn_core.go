@@ -25,6 +25,12 @@ type VPNCore struct {
 	linkAttrs     *netlink.LinkAttrs // Atributos da interface
 	wgClient      *wgctrl.Client    // Cliente para controlar a interface WireGuard
 	
+	// Endpoints escolhidos em execução (ex: proxy do relay TURN), por nodeID
+	activeEndpoints map[string]string
+	
+	// Keepalives em segundos ajustados pela travessia de NAT, por nodeID; zero desativa
+	activeKeepalives map[string]int
+	
 	// Controle de status e sincronização
 	running  bool
 	mutex    sync.Mutex
@@ -45,6 +51,8 @@ func NewVPNCore(config *Config, listenPort int) (*VPNCore, error) {
 		config:        config,
 		listenPort:    listenPort,
 		interfaceName: interfaceName,
+		activeEndpoints: make(map[string]string),
+		activeKeepalives: make(map[string]int),
 		running:       false,
 		stopChan:      make(chan struct{}),
 	}
@@ -277,6 +285,8 @@ func (v *VPNCore) RemovePeer(nodeID string) error {
 	if !v.config.RemoveTrustedPeer(nodeID) {
 		return fmt.Errorf("peer %s não encontrado", nodeID)
 	}
+	delete(v.activeEndpoints, nodeID)
+	delete(v.activeKeepalives, nodeID)
 	
 	// Se estiver em execução, atualizar a configuração WireGuard
 	if v.running {
@@ -305,18 +315,19 @@ func (v *VPNCore) UpdatePeerEndpoint(nodeID string, endpoint string) error {
 		return fmt.Errorf("peer %s não encontrado", nodeID)
 	}
 	
-	// Verificar se o endpoint já existe
-	endpointExists := false
-	for _, ep := range targetPeer.Endpoints {
-		if ep == endpoint {
-			endpointExists = true
-			break
+	// Vazio volta ao primeiro endpoint configurado
+	if endpoint == "" {
+		delete(v.activeEndpoints, nodeID)
+		if v.running && len(targetPeer.Endpoints) > 0 {
+			return v.updateWireGuardPeerEndpoint(*targetPeer, targetPeer.Endpoints[0])
 		}
+		return nil
 	}
+	v.activeEndpoints[nodeID] = endpoint
 	
-	// Adicionar novo endpoint se necessário
-	if !endpointExists {
-		targetPeer.Endpoints = append(targetPeer.Endpoints, endpoint)
+	// Proxies locais (ex: relay TURN) só existem nesta execução e não vão para a configuração
+	if !isLoopbackEndpoint(endpoint) {
+		targetPeer.RecordEndpoint(endpoint)
 	}
 	
 	// Atualizar lastSeen
@@ -324,8 +335,7 @@ func (v *VPNCore) UpdatePeerEndpoint(nodeID string, endpoint string) error {
 	
 	// Se estiver em execução, atualizar a configuração WireGuard
 	if v.running {
-		// Aqui atualizaríamos a configuração do WireGuard em tempo real
-		fmt.Printf("Simulando a atualização do endpoint %s para o peer %s...\n", endpoint, nodeID)
+		return v.updateWireGuardPeerEndpoint(*targetPeer, endpoint)
 	}
 	
 	return nil
@@ -333,14 +343,18 @@ func (v *VPNCore) UpdatePeerEndpoint(nodeID string, endpoint string) error {
 
 // GetPeers retorna a lista de peers configurados
 func (v *VPNCore) GetPeers() []TrustedPeer {
+	return v.TrustedPeers()
+}
+
+// TrustedPeers retorna uma cópia dos peers confiáveis
+// TrustedPeers returns a copy of the trusted peers
+// TrustedPeers devuelve una copia de los peers confiables
+func (v *VPNCore) TrustedPeers() []TrustedPeer {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 	
 	// Fazer uma cópia para evitar problemas de concorrência
-	peers := make([]TrustedPeer, len(v.config.TrustedPeers))
-	copy(peers, v.config.TrustedPeers)
-	
-	return peers
+	return v.config.CopyTrustedPeers()
 }
 
 // GetNodeInfo retorna as informações do nó local
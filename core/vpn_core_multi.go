@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
@@ -19,6 +20,13 @@ type VPNCoreMulti struct {
 	interfaceName string
 	platform      platform.VPNPlatform
 	
+	// Endpoints escolhidos em execução (ex: proxy do relay TURN), por nodeID, que valem
+	// no lugar do primeiro endpoint configurado
+	activeEndpoints map[string]string
+	
+	// Keepalives em segundos ajustados pela travessia de NAT, por nodeID; zero desativa
+	activeKeepalives map[string]int
+	
 	// Controle de status e sincronização
 	running  bool
 	mutex    sync.Mutex
@@ -48,6 +56,8 @@ func NewVPNCoreMulti(config *Config, listenPort int) (*VPNCoreMulti, error) {
 		listenPort:    listenPort,
 		interfaceName: interfaceName,
 		platform:      plat,
+		activeEndpoints: make(map[string]string),
+		activeKeepalives: make(map[string]int),
 		running:       false,
 		stopChan:      make(chan struct{}),
 	}
@@ -200,6 +210,8 @@ func (v *VPNCoreMulti) RemovePeer(nodeID string) error {
 	
 	// Remover do registro de peers
 	v.config.RemoveTrustedPeer(nodeID)
+	delete(v.activeEndpoints, nodeID)
+	delete(v.activeKeepalives, nodeID)
 	
 	// Se o serviço não estiver em execução, apenas remover da configuração
 	if !v.running {
@@ -210,17 +222,66 @@ func (v *VPNCoreMulti) RemovePeer(nodeID string) error {
 	return v.platform.RemovePeer(v.interfaceName, peerToRemove.PublicKey)
 }
 
+// UpdatePeerEndpoint aponta o endpoint WireGuard do peer para endpoint, sem alterar os
+// endpoints configurados; vazio volta ao primeiro endpoint configurado
+// UpdatePeerEndpoint points the peer's WireGuard endpoint at endpoint without changing
+// the configured endpoints; empty restores the first configured endpoint
+// UpdatePeerEndpoint apunta el endpoint WireGuard del peer a endpoint sin cambiar los
+// endpoints configurados; vacío vuelve al primer endpoint configurado
+func (v *VPNCoreMulti) UpdatePeerEndpoint(nodeID string, endpoint string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	
+	var target *TrustedPeer
+	for i := range v.config.TrustedPeers {
+		if v.config.TrustedPeers[i].NodeID == nodeID {
+			target = &v.config.TrustedPeers[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("peer %s não encontrado", nodeID)
+	}
+	
+	if endpoint == "" {
+		delete(v.activeEndpoints, nodeID)
+	} else {
+		v.activeEndpoints[nodeID] = endpoint
+	}
+	
+	// Fora de execução o endpoint vale a partir do próximo Start
+	if !v.running {
+		return nil
+	}
+	
+	// Reaplicar o peer atualiza o endpoint na interface
+	return v.addPeer(*target)
+}
+
 // GetConfig retorna a configuração atual da VPN
 // GetConfig returns the current VPN configuration
 // GetConfig devuelve la configuración actual de la VPN
 func (v *VPNCoreMulti) GetConfig() *Config {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
 	return v.config
 }
 
+// TrustedPeers retorna uma cópia dos peers confiáveis
+// TrustedPeers returns a copy of the trusted peers
+// TrustedPeers devuelve una copia de los peers confiables
+func (v *VPNCoreMulti) TrustedPeers() []TrustedPeer {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.config.CopyTrustedPeers()
+}
+
 // SaveConfig salva a configuração em disco
 // SaveConfig saves the configuration to disk
 // SaveConfig guarda la configuración en disco
 func (v *VPNCoreMulti) SaveConfig(path string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
 	return v.config.SaveConfig(path)
 }
 
@@ -237,9 +298,11 @@ func (v *VPNCoreMulti) addPeer(peer TrustedPeer) error {
 		allowedIPs = peer.AllowedIPs[0]
 	}
 	
-	// Usar o primeiro endpoint, se disponível
+	// Usar o endpoint escolhido em execução ou o primeiro configurado, se disponível
 	endpoint := ""
-	if len(peer.Endpoints) > 0 {
+	if active, ok := v.activeEndpoints[peer.NodeID]; ok {
+		endpoint = active
+	} else if len(peer.Endpoints) > 0 {
 		endpoint = peer.Endpoints[0]
 		// Verificar se a porta está especificada
 		if endpoint != "" && !ContainsPort(endpoint) {
@@ -248,7 +311,7 @@ func (v *VPNCoreMulti) addPeer(peer TrustedPeer) error {
 	}
 	
 	// Adicionar peer usando a implementação de plataforma
-	err := v.platform.AddPeer(v.interfaceName, peer.PublicKey, allowedIPs, endpoint, peer.KeepAlive)
+	err := v.platform.AddPeer(v.interfaceName, peer.PublicKey, allowedIPs, endpoint, v.peerKeepalive(peer))
 	if err != nil {
 		return fmt.Errorf("erro ao adicionar peer: %w", err)
 	}
@@ -308,3 +371,13 @@ func ParseCIDR(ip, cidr string) CIDRParts {
 func ContainsPort(addr string) bool {
 	return len(addr) > 0 && addr[len(addr)-1] >= '0' && addr[len(addr)-1] <= '9'
 }
+
+// isLoopbackEndpoint verifica se o endpoint (host:porta) aponta para a própria máquina
+func isLoopbackEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -53,9 +53,14 @@ func (v *VPNCore) addWireGuardPeer(peer TrustedPeer) error {
 
 	// Configurar endpoints (se houver)
 	var endpoint *net.UDPAddr
-	if len(peer.Endpoints) > 0 {
+	endpoints := peer.Endpoints
+	if active, ok := v.activeEndpoints[peer.NodeID]; ok {
+		// O endpoint escolhido em execução (ex: proxy do relay TURN) tem prioridade
+		endpoints = append([]string{active}, peer.Endpoints...)
+	}
+	if len(endpoints) > 0 {
 		// Usar o primeiro endpoint disponível
-		endpointStr := peer.Endpoints[0]
+		endpointStr := endpoints[0]
 		if !strings.Contains(endpointStr, ":") {
 			// Adicionar a porta padrão se não especificada
 			endpointStr = fmt.Sprintf("%s:51820", endpointStr)
@@ -67,8 +72,8 @@ func (v *VPNCore) addWireGuardPeer(peer TrustedPeer) error {
 			endpoint = nil
 			
 			// Tentar outros endpoints se disponíveis
-			for i := 1; i < len(peer.Endpoints) && endpoint == nil; i++ {
-				endpointStr = peer.Endpoints[i]
+			for i := 1; i < len(endpoints) && endpoint == nil; i++ {
+				endpointStr = endpoints[i]
 				if !strings.Contains(endpointStr, ":") {
 					endpointStr = fmt.Sprintf("%s:51820", endpointStr)
 				}
@@ -82,12 +87,16 @@ func (v *VPNCore) addWireGuardPeer(peer TrustedPeer) error {
 		}
 	}
 
-	// Definir configuração do keepalive (se especificado)
+	// Definir configuração do keepalive (se especificado ou ajustado pela travessia de NAT)
 	var persistentKeepalive *time.Duration
 	if peer.KeepAlive > 0 {
 		// Converter de segundos para time.Duration
 		keepaliveValue := time.Duration(peer.KeepAlive) * time.Second
 		persistentKeepalive = &keepaliveValue
+	} else if interval, ok := v.activeKeepalives[peer.NodeID]; ok {
+		// Zero desativa o keepalive em caminhos que não atravessam NAT
+		keepaliveValue := time.Duration(interval) * time.Second
+		persistentKeepalive = &keepaliveValue
 	}
 
 	// Criar configuração do peer
@@ -1,5 +1,7 @@
 package core
 
+import "time"
+
 // VPNProvider define a interface comum para todos os provedores de VPN
 // VPNProvider defines the common interface for all VPN providers
 // VPNProvider define la interfaz común para todos los proveedores de VPN
@@ -22,9 +24,21 @@ type VPNProvider interface {
 	// GetConfig retorna a configuração atual da VPN
 	GetConfig() *Config
 	
+	// TrustedPeers retorna uma cópia dos peers confiáveis, que pode ser percorrida enquanto
+	// AddPeer e RemovePeer alteram a configuração
+	TrustedPeers() []TrustedPeer
+	
 	// SaveConfig salva a configuração em disco
 	SaveConfig(path string) error
 	
+	// UpdatePeerEndpoint aponta o endpoint WireGuard do peer para endpoint (host:porta), como
+	// o proxy local de um relay; vazio volta ao endpoint configurado
+	UpdatePeerEndpoint(nodeID string, endpoint string) error
+	
+	// UpdatePeerKeepalive ajusta o keepalive WireGuard do peer ao caminho escolhido pela
+	// travessia de NAT: desativado fora de NAT, metade do tempo de vida do mapeamento atrás dele
+	UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error
+	
 	// GetNodeInfo retorna as informações do nó local (nodeID, publicKey, virtualIP)
 	GetNodeInfo() (string, string, string)
 }
@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+)
+
+// Backends de descoberta embutidos
+const (
+	BackendStatic     = "static"     // Anúncios unicast para os nós conhecidos e peers configurados
+	BackendMulticast  = "multicast"  // Anúncios nos grupos multicast da LAN
+	BackendRendezvous = "rendezvous" // Registro e apresentação via servidores de rendezvous
+	BackendDHT        = "dht"        // Registros assinados no DHT Kademlia
+	BackendDNS        = "dns"        // Registros SRV/TXT assinados sob um domínio
+)
+
+// backendResultsBuffer é a capacidade do canal de resultados dos backends embutidos
+const backendResultsBuffer = 64
+
+// DiscoveryResult é um anúncio de peer encontrado por um backend
+// DiscoveryResult is a peer announcement found by a backend
+// DiscoveryResult es un anuncio de par encontrado por un backend
+type DiscoveryResult struct {
+	Backend string         // Nome do backend que encontrou o peer
+	Message *SignedMessage // Anúncio assinado pelo próprio peer
+	Addr    *net.UDPAddr   // Endereço de onde o anúncio foi observado
+
+	// Endereço de descoberta informado por terceiros (ex: observado pelo rendezvous).
+	// Vazio usa o IP de Addr com a porta de descoberta anunciada.
+	DiscoveryAddr string
+
+	// done é fechado depois que o resultado foi processado
+	done chan struct{}
+}
+
+// DiscoveryBackend é um mecanismo de descoberta de peers. Os anúncios encontrados são
+// entregues pelo canal de Results e validados pelo serviço de descoberta.
+// DiscoveryBackend is a peer discovery mechanism. Announcements it finds are delivered
+// through the Results channel and validated by the discovery service.
+// DiscoveryBackend es un mecanismo de descubrimiento de pares. Los anuncios encontrados se
+// entregan por el canal Results y los valida el servicio de descubrimiento.
+type DiscoveryBackend interface {
+	Name() string
+	Start() error
+	Stop() error
+	Announce() error
+	Results() <-chan DiscoveryResult
+}
+
+// NetworkChangeHandler é implementado pelos backends que dependem das interfaces de rede
+// locais e precisam se ajustar quando elas mudam
+// NetworkChangeHandler is implemented by backends that depend on the local network interfaces
+// NetworkChangeHandler lo implementan los backends que dependen de las interfaces de red locales
+type NetworkChangeHandler interface {
+	HandleNetworkChange()
+}
+
+// BackendManager executa vários backends ao mesmo tempo e repassa os resultados de todos
+// a um único handler
+// BackendManager runs several backends at once and forwards all their results to a single handler
+// BackendManager ejecuta varios backends a la vez y entrega todos sus resultados a un único handler
+type BackendManager struct {
+	backends []DiscoveryBackend
+	active   []DiscoveryBackend
+	handler  func(DiscoveryResult)
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewBackendManager cria um gerenciador que entrega os resultados ao handler
+func NewBackendManager(handler func(DiscoveryResult)) *BackendManager {
+	return &BackendManager{
+		handler:  handler,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Add registra um backend. Deve ser chamado antes de Start.
+func (m *BackendManager) Add(backend DiscoveryBackend) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running {
+		return fmt.Errorf("não é possível adicionar backends com a descoberta em execução")
+	}
+	for _, existing := range m.backends {
+		if existing.Name() == backend.Name() {
+			return fmt.Errorf("backend de descoberta duplicado: %s", backend.Name())
+		}
+	}
+
+	m.backends = append(m.backends, backend)
+	return nil
+}
+
+// Start inicia os backends registrados. Backends que falham são desativados sem
+// impedir os demais.
+func (m *BackendManager) Start() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running {
+		return fmt.Errorf("o gerenciador de backends já está em execução")
+	}
+
+	for _, backend := range m.backends {
+		if err := backend.Start(); err != nil {
+			fmt.Printf("Aviso: backend de descoberta %s desativado: %v\n", backend.Name(), err)
+			continue
+		}
+		m.active = append(m.active, backend)
+		go m.forward(backend)
+	}
+
+	m.running = true
+	return nil
+}
+
+// Stop para todos os backends ativos
+func (m *BackendManager) Stop() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	close(m.stopChan)
+
+	for _, backend := range m.active {
+		if err := backend.Stop(); err != nil {
+			fmt.Printf("Erro ao parar backend de descoberta %s: %v\n", backend.Name(), err)
+		}
+	}
+	m.active = nil
+
+	m.running = false
+	return nil
+}
+
+// Announce anuncia o nó imediatamente em todos os backends ativos
+func (m *BackendManager) Announce() {
+	for _, backend := range m.Active() {
+		if err := backend.Announce(); err != nil {
+			fmt.Printf("Erro ao anunciar via %s: %v\n", backend.Name(), err)
+		}
+	}
+}
+
+// HandleNetworkChange avisa da mudança de rede os backends ativos que dependem das interfaces
+func (m *BackendManager) HandleNetworkChange() {
+	for _, backend := range m.Active() {
+		if handler, ok := backend.(NetworkChangeHandler); ok {
+			handler.HandleNetworkChange()
+		}
+	}
+}
+
+// Active retorna os backends em execução
+func (m *BackendManager) Active() []DiscoveryBackend {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]DiscoveryBackend(nil), m.active...)
+}
+
+// forward repassa os resultados de um backend ao handler até o gerenciador parar
+func (m *BackendManager) forward(backend DiscoveryBackend) {
+	results := backend.Results()
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return
+			}
+			if result.Backend == "" {
+				result.Backend = backend.Name()
+			}
+			m.handler(result)
+			if result.done != nil {
+				close(result.done)
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// EnabledBackends lista os backends de uma configuração: os indicados em Backends e os
+// ativados pelas opções de cada mecanismo. O backend static está sempre ativo, pois é
+// pelo socket de descoberta que chegam os anúncios diretos.
+func EnabledBackends(config core.DiscoveryConfig) ([]string, error) {
+	names := []string{BackendStatic}
+
+	for _, name := range config.Backends {
+		switch name {
+		case BackendStatic, BackendMulticast, BackendRendezvous, BackendDHT, BackendDNS:
+			names = appendUnique(names, name)
+		default:
+			return nil, fmt.Errorf("backend de descoberta desconhecido: %s", name)
+		}
+	}
+
+	if config.Multicast {
+		names = appendUnique(names, BackendMulticast)
+	}
+	if len(config.RendezvousServers) > 0 {
+		names = appendUnique(names, BackendRendezvous)
+	}
+	if config.DHT {
+		names = appendUnique(names, BackendDHT)
+	}
+	if config.DNSDomain != "" {
+		names = appendUnique(names, BackendDNS)
+	}
+
+	return names, nil
+}
+
+// publishResult entrega um resultado pelo canal do backend, desistindo se ele parar
+func publishResult(results chan<- DiscoveryResult, result DiscoveryResult, stopChan <-chan struct{}) {
+	select {
+	case results <- result:
+	case <-stopChan:
+	}
+}
@@ -0,0 +1,734 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Parâmetros do DHT (Kademlia)
+const (
+	DHTIDSize          = sha256.Size      // Tamanho dos identificadores em bytes
+	DHTBucketSize      = 8                // k: contatos por bucket e réplicas por registro
+	DHTAlpha           = 3                // Consultas paralelas por rodada de busca
+	DHTRequestTimeout  = 1 * time.Second  // Espera máxima por uma resposta
+	DHTRecordTTL       = 30 * time.Minute // Validade de um registro publicado
+	DHTRefreshInterval = 5 * time.Minute  // Intervalo de republicação e busca de peers
+
+	dhtMaxFailures  = 3
+	dhtStaleContact = 15 * time.Minute
+
+	// dhtStoreProofContext separa a prova de armazenamento de outros usos do segredo compartilhado
+	dhtStoreProofContext = "p2p-vpn/dht-store/v1"
+)
+
+// Erros do DHT
+var (
+	ErrDHTNotFound = errors.New("registro não encontrado no DHT")
+	ErrDHTTimeout  = errors.New("tempo esgotado aguardando resposta do DHT")
+)
+
+// DHTID identifica nós e registros no espaço de chaves do DHT
+type DHTID [DHTIDSize]byte
+
+// DHTKey calcula o identificador DHT de uma chave pública WireGuard (base64)
+func DHTKey(publicKey string) (DHTID, error) {
+	var id DHTID
+
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(raw) != 32 {
+		return id, fmt.Errorf("%w: chave pública inválida", ErrInvalidMessage)
+	}
+
+	return DHTID(sha256.Sum256(raw)), nil
+}
+
+// String retorna o identificador em hexadecimal
+func (id DHTID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// distance calcula a distância XOR entre dois identificadores
+func (id DHTID) distance(other DHTID) DHTID {
+	var result DHTID
+	for i := range id {
+		result[i] = id[i] ^ other[i]
+	}
+	return result
+}
+
+// bucketIndex retorna o bucket de um contato: a quantidade de bits iniciais em comum
+func (id DHTID) bucketIndex(other DHTID) int {
+	d := id.distance(other)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(d)*8 - 1
+}
+
+// closer informa se a está mais próximo do alvo do que b
+func (id DHTID) closer(a, b DHTID) bool {
+	da, db := id.distance(a), id.distance(b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// DHTContact é um contato trocado nas respostas do DHT
+type DHTContact struct {
+	NodeKey string `json:"key"`  // Chave WireGuard do nó
+	Addr    string `json:"addr"` // Endereço de descoberta
+}
+
+// DHTMessage é o payload comum das mensagens do DHT
+type DHTMessage struct {
+	RequestID string       `json:"rid"`
+	NodeKey   string       `json:"nodeKey"` // Chave WireGuard do remetente
+	Target    string       `json:"target,omitempty"`
+	Nodes     []DHTContact `json:"nodes,omitempty"`
+	Record    []byte       `json:"record,omitempty"`   // Anúncio assinado pelo dono do registro
+	Observed  string       `json:"observed,omitempty"` // Endereço de onde o registro foi publicado
+	Proof     []byte       `json:"proof,omitempty"`    // Posse da chave WireGuard do registro, para o destinatário
+}
+
+// dhtContact é uma entrada da tabela de roteamento
+type dhtContact struct {
+	id       DHTID
+	nodeKey  string
+	addr     *net.UDPAddr
+	lastSeen time.Time
+	failures int
+}
+
+// dhtRecord é um registro armazenado localmente
+type dhtRecord struct {
+	raw      []byte
+	signer   string
+	observed string
+	expires  time.Time
+}
+
+// dhtRequest aguarda a resposta de uma consulta
+type dhtRequest struct {
+	addr     *net.UDPAddr
+	response chan *DHTMessage
+}
+
+// DHT implementa uma tabela hash distribuída no estilo Kademlia sobre o socket de descoberta.
+// Cada nó publica seu anúncio assinado sob o hash da própria chave WireGuard, provando a cada
+// nó que guarda o registro que possui a chave privada correspondente.
+type DHT struct {
+	id          DHTID
+	nodeKey     string
+	exchangeKey *ecdh.PrivateKey // Chave privada WireGuard, para as provas de posse
+	signingKey  ed25519.PrivateKey
+	send        func(data []byte, addr *net.UDPAddr) error
+
+	buckets    [DHTIDSize * 8][]*dhtContact
+	tableMutex sync.Mutex
+
+	records      map[DHTID]*dhtRecord
+	recordsMutex sync.Mutex
+
+	pending      map[string]*dhtRequest
+	pendingMutex sync.Mutex
+}
+
+// NewDHT cria o DHT de um nó a partir da sua chave privada WireGuard (base64). send envia um
+// pacote pelo socket de descoberta.
+func NewDHT(privateKey string, signingKey ed25519.PrivateKey, send func([]byte, *net.UDPAddr) error) (*DHT, error) {
+	exchangeKey, err := parseExchangePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	nodeKey := base64.StdEncoding.EncodeToString(exchangeKey.PublicKey().Bytes())
+
+	id, err := DHTKey(nodeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DHT{
+		id:          id,
+		nodeKey:     nodeKey,
+		exchangeKey: exchangeKey,
+		signingKey:  signingKey,
+		send:        send,
+		records:     make(map[DHTID]*dhtRecord),
+		pending:     make(map[string]*dhtRequest),
+	}, nil
+}
+
+// ID retorna o identificador do nó local
+func (d *DHT) ID() DHTID {
+	return d.id
+}
+
+// Size retorna a quantidade de contatos na tabela de roteamento
+func (d *DHT) Size() int {
+	d.tableMutex.Lock()
+	defer d.tableMutex.Unlock()
+
+	total := 0
+	for _, bucket := range d.buckets {
+		total += len(bucket)
+	}
+	return total
+}
+
+// AddContact inclui um nó na tabela de roteamento
+func (d *DHT) AddContact(nodeKey string, addr *net.UDPAddr) {
+	id, err := DHTKey(nodeKey)
+	if err != nil || id == d.id {
+		return
+	}
+
+	d.tableMutex.Lock()
+	defer d.tableMutex.Unlock()
+
+	index := d.id.bucketIndex(id)
+	bucket := d.buckets[index]
+
+	for i, contact := range bucket {
+		if contact.id == id {
+			// Contato visto de novo vai para o fim (mais recente)
+			contact.addr = addr
+			contact.lastSeen = time.Now()
+			contact.failures = 0
+			d.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), contact)
+			return
+		}
+	}
+
+	contact := &dhtContact{id: id, nodeKey: nodeKey, addr: addr, lastSeen: time.Now()}
+
+	if len(bucket) < DHTBucketSize {
+		d.buckets[index] = append(bucket, contact)
+		return
+	}
+
+	// Bucket cheio: substituir o contato mais antigo apenas se ele parecer inativo
+	oldest := bucket[0]
+	if oldest.failures > 0 || time.Since(oldest.lastSeen) > dhtStaleContact {
+		d.buckets[index] = append(bucket[1:len(bucket):len(bucket)], contact)
+	}
+}
+
+// markFailure registra uma consulta sem resposta e remove contatos que falham repetidamente
+func (d *DHT) markFailure(id DHTID) {
+	d.tableMutex.Lock()
+	defer d.tableMutex.Unlock()
+
+	index := d.id.bucketIndex(id)
+	bucket := d.buckets[index]
+
+	for i, contact := range bucket {
+		if contact.id != id {
+			continue
+		}
+		contact.failures++
+		if contact.failures >= dhtMaxFailures {
+			d.buckets[index] = append(bucket[:i:i], bucket[i+1:]...)
+		}
+		return
+	}
+}
+
+// closest retorna até count contatos conhecidos mais próximos do alvo
+func (d *DHT) closest(target DHTID, count int) []*dhtContact {
+	d.tableMutex.Lock()
+	var contacts []*dhtContact
+	for _, bucket := range d.buckets {
+		for _, contact := range bucket {
+			copied := *contact
+			contacts = append(contacts, &copied)
+		}
+	}
+	d.tableMutex.Unlock()
+
+	sort.Slice(contacts, func(i, j int) bool {
+		return target.closer(contacts[i].id, contacts[j].id)
+	})
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+	return contacts
+}
+
+// HandleMessage processa uma mensagem do DHT recebida pelo socket de descoberta
+func (d *DHT) HandleMessage(msg *SignedMessage, addr *net.UDPAddr) {
+	var message DHTMessage
+	if err := msg.Decode(&message); err != nil {
+		fmt.Printf("Mensagem DHT inválida de %s: %v\n", addr.String(), err)
+		return
+	}
+
+	senderID, err := DHTKey(message.NodeKey)
+	if err != nil || senderID == d.id {
+		return
+	}
+
+	// Todo nó que fala conosco é um contato em potencial
+	d.AddContact(message.NodeKey, addr)
+
+	switch msg.Type {
+	case MsgDHTFindNode:
+		target, err := parseDHTID(message.Target)
+		if err != nil {
+			return
+		}
+		d.reply(MsgDHTNodes, message.RequestID, d.contactsFor(target, senderID), addr)
+
+	case MsgDHTFindValue:
+		target, err := parseDHTID(message.Target)
+		if err != nil {
+			return
+		}
+		if record := d.localRecord(target); record != nil {
+			d.sendMessage(MsgDHTValue, DHTMessage{
+				RequestID: message.RequestID,
+				Record:    record.raw,
+				Observed:  record.observed,
+			}, addr)
+			return
+		}
+		d.reply(MsgDHTNodes, message.RequestID, d.contactsFor(target, senderID), addr)
+
+	case MsgDHTStore:
+		if err := d.verifyStore(&message, msg.SignerKey); err != nil {
+			fmt.Printf("Registro DHT recusado de %s: %v\n", addr.String(), err)
+			return
+		}
+		if err := d.storeRecord(message.Record, message.NodeKey, msg.Signer(), addr.String()); err != nil {
+			fmt.Printf("Registro DHT recusado de %s: %v\n", addr.String(), err)
+		}
+
+	case MsgDHTNodes, MsgDHTValue:
+		d.deliver(&message, addr)
+	}
+}
+
+// contactsFor lista os contatos mais próximos do alvo, sem incluir quem perguntou
+func (d *DHT) contactsFor(target, requester DHTID) []DHTContact {
+	var result []DHTContact
+	for _, contact := range d.closest(target, DHTBucketSize+1) {
+		if contact.id == requester {
+			continue
+		}
+		result = append(result, DHTContact{NodeKey: contact.nodeKey, Addr: contact.addr.String()})
+		if len(result) == DHTBucketSize {
+			break
+		}
+	}
+	return result
+}
+
+// reply responde a uma consulta com uma lista de contatos
+func (d *DHT) reply(msgType MessageType, requestID string, nodes []DHTContact, addr *net.UDPAddr) {
+	d.sendMessage(msgType, DHTMessage{RequestID: requestID, Nodes: nodes}, addr)
+}
+
+// sendMessage assina e envia uma mensagem do DHT
+func (d *DHT) sendMessage(msgType MessageType, message DHTMessage, addr *net.UDPAddr) error {
+	message.NodeKey = d.nodeKey
+
+	data, err := EncodeMessage(msgType, message, d.signingKey)
+	if err != nil {
+		return err
+	}
+	return d.send(data, addr)
+}
+
+// request envia uma consulta e aguarda a resposta correspondente
+func (d *DHT) request(msgType MessageType, target DHTID, addr *net.UDPAddr) (*DHTMessage, error) {
+	var rid [8]byte
+	if _, err := rand.Read(rid[:]); err != nil {
+		return nil, err
+	}
+	requestID := hex.EncodeToString(rid[:])
+
+	req := &dhtRequest{addr: addr, response: make(chan *DHTMessage, 1)}
+
+	d.pendingMutex.Lock()
+	d.pending[requestID] = req
+	d.pendingMutex.Unlock()
+
+	defer func() {
+		d.pendingMutex.Lock()
+		delete(d.pending, requestID)
+		d.pendingMutex.Unlock()
+	}()
+
+	if err := d.sendMessage(msgType, DHTMessage{RequestID: requestID, Target: target.String()}, addr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-req.response:
+		return response, nil
+	case <-time.After(DHTRequestTimeout):
+		return nil, ErrDHTTimeout
+	}
+}
+
+// deliver entrega uma resposta à consulta pendente de mesmo ID e endereço
+func (d *DHT) deliver(message *DHTMessage, addr *net.UDPAddr) {
+	d.pendingMutex.Lock()
+	req, exists := d.pending[message.RequestID]
+	d.pendingMutex.Unlock()
+
+	if !exists || !req.addr.IP.Equal(addr.IP) || req.addr.Port != addr.Port {
+		return
+	}
+
+	select {
+	case req.response <- message:
+	default:
+	}
+}
+
+// iterativeFind executa a busca iterativa do Kademlia pelos nós mais próximos do alvo.
+// Se findValue for verdadeiro, a busca termina no primeiro registro encontrado.
+func (d *DHT) iterativeFind(target DHTID, findValue bool) ([]*dhtContact, *DHTMessage) {
+	shortlist := d.closest(target, DHTBucketSize)
+	queried := make(map[DHTID]bool)
+	seen := make(map[DHTID]bool)
+	for _, contact := range shortlist {
+		seen[contact.id] = true
+	}
+
+	msgType := MsgDHTFindNode
+	if findValue {
+		msgType = MsgDHTFindValue
+	}
+
+	for {
+		// Selecionar os alpha contatos mais próximos ainda não consultados
+		var round []*dhtContact
+		for _, contact := range shortlist {
+			if !queried[contact.id] {
+				round = append(round, contact)
+				queried[contact.id] = true
+				if len(round) == DHTAlpha {
+					break
+				}
+			}
+		}
+		if len(round) == 0 {
+			return shortlist, nil
+		}
+
+		var (
+			wg      sync.WaitGroup
+			mutex   sync.Mutex
+			found   *DHTMessage
+			learned []*dhtContact
+		)
+
+		for _, contact := range round {
+			wg.Add(1)
+			go func(contact *dhtContact) {
+				defer wg.Done()
+
+				response, err := d.request(msgType, target, contact.addr)
+				if err != nil {
+					d.markFailure(contact.id)
+					return
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if len(response.Record) > 0 && found == nil {
+					found = response
+				}
+				for _, node := range response.Nodes {
+					id, err := DHTKey(node.NodeKey)
+					if err != nil || id == d.id {
+						continue
+					}
+					addr, err := net.ResolveUDPAddr("udp", node.Addr)
+					if err != nil {
+						continue
+					}
+					learned = append(learned, &dhtContact{id: id, nodeKey: node.NodeKey, addr: addr})
+				}
+			}(contact)
+		}
+		wg.Wait()
+
+		if found != nil {
+			return shortlist, found
+		}
+
+		for _, contact := range learned {
+			if !seen[contact.id] {
+				seen[contact.id] = true
+				shortlist = append(shortlist, contact)
+			}
+		}
+
+		sort.Slice(shortlist, func(i, j int) bool {
+			return target.closer(shortlist[i].id, shortlist[j].id)
+		})
+		if len(shortlist) > DHTBucketSize {
+			shortlist = shortlist[:DHTBucketSize]
+		}
+	}
+}
+
+// Bootstrap entra na rede consultando os endereços informados e buscando o próprio ID
+func (d *DHT) Bootstrap(addrs []*net.UDPAddr) {
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr *net.UDPAddr) {
+			defer wg.Done()
+			// A resposta registra o nó de bootstrap como contato em HandleMessage
+			d.request(MsgDHTFindNode, d.id, addr)
+		}(addr)
+	}
+	wg.Wait()
+
+	d.iterativeFind(d.id, false)
+}
+
+// Refresh busca IDs aleatórios nos buckets incompletos mais distantes que o vizinho mais próximo.
+// Nós que entraram cedo na rede só conhecem a própria vizinhança; sem isso, buscas
+// por chaves na outra metade do espaço podem não encontrar caminho até elas.
+func (d *DHT) Refresh() {
+	nearest := d.closest(d.id, 1)
+	if len(nearest) == 0 {
+		return
+	}
+	limit := d.id.bucketIndex(nearest[0].id)
+
+	for index := 0; index < limit; index++ {
+		d.tableMutex.Lock()
+		full := len(d.buckets[index]) >= DHTBucketSize
+		d.tableMutex.Unlock()
+
+		if !full {
+			d.iterativeFind(d.id.randomInBucket(index), false)
+		}
+	}
+}
+
+// randomInBucket gera um ID aleatório que cai no bucket informado em relação a este ID
+func (id DHTID) randomInBucket(index int) DHTID {
+	var result DHTID
+	rand.Read(result[:])
+
+	// Manter os bits iniciais em comum, inverter o bit do bucket e sortear o restante
+	for bit := 0; bit <= index; bit++ {
+		mask := byte(0x80) >> uint(bit%8)
+		value := id[bit/8] & mask
+		if bit == index {
+			value ^= mask
+		}
+		result[bit/8] = result[bit/8]&^mask | value
+	}
+	return result
+}
+
+// Publish armazena o anúncio assinado do nó local nos nós mais próximos da sua chave.
+// Retorna a quantidade de nós remotos que receberam o registro.
+func (d *DHT) Publish(record []byte) (int, error) {
+	if err := d.storeRecord(record, d.nodeKey, base64.StdEncoding.EncodeToString(d.signingKey.Public().(ed25519.PublicKey)), ""); err != nil {
+		return 0, err
+	}
+
+	// Atualizar os buckets distantes antes de buscar os vizinhos que guardarão o registro
+	d.Refresh()
+
+	contacts, _ := d.iterativeFind(d.id, false)
+
+	msg, _ := DecodeMessage(record)
+	var announcement Announcement
+	msg.Decode(&announcement)
+
+	stored := 0
+	for _, contact := range contacts {
+		// A prova é calculada com a chave de cada nó que guardará o registro
+		proof, err := d.storeProof(announcement, contact.nodeKey)
+		if err != nil {
+			continue
+		}
+		if err := d.sendMessage(MsgDHTStore, DHTMessage{Record: record, Proof: proof}, contact.addr); err == nil {
+			stored++
+		}
+	}
+
+	return stored, nil
+}
+
+// storeProof prova ao nó com a chave WireGuard informada que este nó possui a chave do registro
+func (d *DHT) storeProof(announcement Announcement, nodeKey string) ([]byte, error) {
+	contactKey, err := parseExchangePublicKey(nodeKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := d.exchangeKey.ECDH(contactKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao calcular segredo com %s: %w", nodeKey, err)
+	}
+	return keyProof(dhtStoreProofContext, shared, d.signingKey.Public().(ed25519.PublicKey), announcement), nil
+}
+
+// verifyStore confere que quem pede o armazenamento possui a chave WireGuard do registro,
+// para que ninguém ocupe a chave de outro nó antes dele
+func (d *DHT) verifyStore(message *DHTMessage, signer ed25519.PublicKey) error {
+	msg, err := DecodeMessage(message.Record)
+	if err != nil {
+		return err
+	}
+	var announcement Announcement
+	if err := msg.Decode(&announcement); err != nil {
+		return err
+	}
+	if announcement.PublicKey != message.NodeKey {
+		return fmt.Errorf("registro publicado por outro nó")
+	}
+	return verifyKeyProof(dhtStoreProofContext, d.exchangeKey, message.Proof, signer, announcement)
+}
+
+// Lookup busca o anúncio assinado publicado sob uma chave WireGuard.
+// Retorna o anúncio e o endereço de onde ele foi publicado, se conhecido.
+func (d *DHT) Lookup(publicKey string) (*SignedMessage, string, error) {
+	target, err := DHTKey(publicKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	raw, observed := []byte(nil), ""
+	if record := d.localRecord(target); record != nil {
+		raw, observed = record.raw, record.observed
+	} else {
+		_, response := d.iterativeFind(target, true)
+		if response == nil {
+			return nil, "", ErrDHTNotFound
+		}
+		raw, observed = response.Record, response.Observed
+	}
+
+	msg, key, err := validateDHTRecord(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	if key != target {
+		return nil, "", fmt.Errorf("%w: registro não corresponde à chave buscada", ErrInvalidMessage)
+	}
+
+	return msg, observed, nil
+}
+
+// storeRecord valida e armazena um registro. Cada nó só pode publicar o próprio anúncio.
+func (d *DHT) storeRecord(raw []byte, nodeKey, signer, observed string) error {
+	msg, key, err := validateDHTRecord(raw)
+	if err != nil {
+		return err
+	}
+
+	var announcement Announcement
+	msg.Decode(&announcement)
+	if announcement.PublicKey != nodeKey || msg.Signer() != signer {
+		return fmt.Errorf("registro publicado por outro nó")
+	}
+
+	d.recordsMutex.Lock()
+	defer d.recordsMutex.Unlock()
+
+	if existing, exists := d.records[key]; exists && time.Now().Before(existing.expires) {
+		// A chave de assinatura fica vinculada ao registro enquanto ele for válido
+		if existing.signer != signer {
+			return fmt.Errorf("chave de assinatura difere da registrada")
+		}
+		current, _ := DecodeMessage(existing.raw)
+		if current != nil && !msg.Timestamp.After(current.Timestamp) {
+			return nil
+		}
+	}
+
+	d.records[key] = &dhtRecord{
+		raw:      append([]byte(nil), raw...),
+		signer:   signer,
+		observed: observed,
+		expires:  msg.Timestamp.Add(DHTRecordTTL),
+	}
+
+	return nil
+}
+
+// localRecord retorna um registro armazenado localmente, se ainda válido
+func (d *DHT) localRecord(key DHTID) *dhtRecord {
+	d.recordsMutex.Lock()
+	defer d.recordsMutex.Unlock()
+
+	record, exists := d.records[key]
+	if !exists || time.Now().After(record.expires) {
+		return nil
+	}
+	return record
+}
+
+// Prune remove registros expirados
+func (d *DHT) Prune() {
+	d.recordsMutex.Lock()
+	defer d.recordsMutex.Unlock()
+
+	for key, record := range d.records {
+		if time.Now().After(record.expires) {
+			delete(d.records, key)
+		}
+	}
+}
+
+// validateDHTRecord verifica a assinatura e a validade de um anúncio armazenado no DHT
+func validateDHTRecord(raw []byte) (*SignedMessage, DHTID, error) {
+	var key DHTID
+
+	msg, err := DecodeMessage(raw)
+	if err != nil {
+		return nil, key, err
+	}
+	if msg.Type != MsgAnnouncement {
+		return nil, key, fmt.Errorf("%w: registro DHT do tipo %s", ErrInvalidMessage, msg.Type)
+	}
+
+	age := time.Since(msg.Timestamp)
+	if age > DHTRecordTTL || age < -MaxClockSkew {
+		return nil, key, ErrStaleMessage
+	}
+
+	var announcement Announcement
+	if err := msg.Decode(&announcement); err != nil {
+		return nil, key, err
+	}
+
+	key, err = DHTKey(announcement.PublicKey)
+	return msg, key, err
+}
+
+// parseDHTID converte um identificador hexadecimal
+func parseDHTID(value string) (DHTID, error) {
+	var id DHTID
+
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != DHTIDSize {
+		return id, fmt.Errorf("%w: identificador DHT inválido", ErrInvalidMessage)
+	}
+
+	copy(id[:], raw)
+	return id, nil
+}
@@ -0,0 +1,210 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sendPacket envia um pacote pelo socket de descoberta
+func (p *PeerDiscovery) sendPacket(data []byte, addr *net.UDPAddr) error {
+	p.mutex.Lock()
+	conn := p.udpConn
+	p.mutex.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("serviço de descoberta não está em execução")
+	}
+
+	_, err := conn.WriteTo(data, addr)
+	return err
+}
+
+// dhtBackend publica o anúncio do nó no DHT e busca nele os peers confiáveis sem contato recente
+type dhtBackend struct {
+	discovery *PeerDiscovery
+	interval  time.Duration
+	results   chan DiscoveryResult
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// newDHTBackend cria o backend do DHT. O DHT do serviço já deve ter sido criado.
+func newDHTBackend(discovery *PeerDiscovery) *dhtBackend {
+	return &dhtBackend{
+		discovery: discovery,
+		interval:  DHTRefreshInterval,
+		results:   make(chan DiscoveryResult, backendResultsBuffer),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Name retorna o nome do backend
+func (d *dhtBackend) Name() string {
+	return BackendDHT
+}
+
+// Results retorna o canal dos registros encontrados no DHT
+func (d *dhtBackend) Results() <-chan DiscoveryResult {
+	return d.results
+}
+
+// Start entra no DHT e inicia a publicação e as buscas periódicas
+func (d *dhtBackend) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.running {
+		return fmt.Errorf("o backend do DHT já está em execução")
+	}
+
+	d.running = true
+	go d.dhtRoutine()
+	return nil
+}
+
+// Stop para a publicação e as buscas periódicas
+func (d *dhtBackend) Stop() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.running {
+		return nil
+	}
+
+	close(d.stopChan)
+	d.running = false
+	return nil
+}
+
+// Announce publica o anúncio assinado deste nó no DHT
+func (d *dhtBackend) Announce() error {
+	return d.discovery.PublishToDHT()
+}
+
+// dhtRoutine entra no DHT, publica o registro local e busca os peers confiáveis periodicamente
+func (d *dhtBackend) dhtRoutine() {
+	p := d.discovery
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if p.dht.Size() == 0 {
+			p.dht.Bootstrap(p.dhtBootstrapAddrs())
+		}
+
+		if err := d.Announce(); err != nil {
+			fmt.Printf("Erro ao publicar registro no DHT: %v\n", err)
+		}
+		d.lookupTrustedPeers()
+		p.dht.Prune()
+
+		select {
+		case <-ticker.C:
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+// lookupTrustedPeers busca no DHT os peers confiáveis sem anúncio recente
+func (d *dhtBackend) lookupTrustedPeers() {
+	p := d.discovery
+
+	for _, publicKey := range p.unreachableTrustedPeers() {
+		result, err := p.lookupResult(publicKey)
+		if err != nil {
+			continue
+		}
+
+		publishResult(d.results, result, d.stopChan)
+	}
+}
+
+// dhtBootstrapAddrs reúne os endereços configurados e os nós já conhecidos para entrar no DHT
+func (p *PeerDiscovery) dhtBootstrapAddrs() []*net.UDPAddr {
+	var targets []string
+	for _, target := range p.config.Discovery.DHTBootstrap {
+		targets = appendUnique(targets, target)
+	}
+
+	p.nodesMutex.RLock()
+	for _, peer := range p.knownNodes {
+		if peer.DiscoveryAddr != "" {
+			targets = appendUnique(targets, peer.DiscoveryAddr)
+		}
+	}
+	p.nodesMutex.RUnlock()
+
+	var addrs []*net.UDPAddr
+	for _, target := range targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, strconv.Itoa(p.listenPort))
+		}
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			fmt.Printf("Endereço de bootstrap do DHT inválido %s: %v\n", target, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// PublishToDHT publica o anúncio assinado deste nó no DHT
+func (p *PeerDiscovery) PublishToDHT() error {
+	if p.dht == nil {
+		return fmt.Errorf("DHT desativado")
+	}
+
+	record, err := p.buildAnnouncement()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.dht.Publish(record)
+	return err
+}
+
+// LookupPeer busca no DHT o registro publicado sob uma chave WireGuard
+func (p *PeerDiscovery) LookupPeer(publicKey string) (*PeerInfo, error) {
+	result, err := p.lookupResult(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return p.peerFromResult(result)
+}
+
+// lookupResult busca no DHT o registro de uma chave WireGuard e o descreve como resultado do backend
+func (p *PeerDiscovery) lookupResult(publicKey string) (DiscoveryResult, error) {
+	result := DiscoveryResult{Backend: BackendDHT}
+
+	if p.dht == nil {
+		return result, fmt.Errorf("DHT desativado")
+	}
+
+	msg, observed, err := p.dht.Lookup(publicKey)
+	if err != nil {
+		return result, err
+	}
+	result.Message = msg
+
+	// Sem endereço observado, usar o primeiro endpoint anunciado como origem
+	if observed != "" {
+		result.Addr, _ = net.ResolveUDPAddr("udp", observed)
+		result.DiscoveryAddr = observed
+	}
+	if result.Addr == nil {
+		if result.Addr, err = announcedDiscoveryAddr(msg); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,332 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parâmetros da descoberta via DNS
+const (
+	DNSServiceName     = "p2pvpn"        // Serviço do registro SRV (_p2pvpn._udp.<domínio>)
+	DNSRecordPrefix    = "p2pvpn1:"      // Prefixo do TXT com o anúncio assinado em base64
+	DNSRecordMaxAge    = 24 * time.Hour  // Idade máxima aceita de um anúncio publicado no DNS
+	DefaultDNSInterval = 5 * time.Minute // Intervalo entre consultas e republicações
+	DefaultDNSTTL      = 300             // TTL dos registros publicados, em segundos
+
+	dnsTimeout  = 5 * time.Second
+	dnsTXTChunk = 255 // Tamanho máximo de cada string de um TXT
+)
+
+// DNSRecord descreve os registros que publicam um nó no DNS: um SRV no nome do serviço
+// apontando para o nome do nó, e um TXT no nome do nó com o anúncio assinado
+// DNSRecord describes the records that publish a node in DNS: an SRV under the service
+// name pointing to the node name, and a TXT under the node name with the signed announcement
+// DNSRecord describe los registros que publican un nodo en DNS: un SRV en el nombre del
+// servicio apuntando al nombre del nodo, y un TXT en el nombre del nodo con el anuncio firmado
+type DNSRecord struct {
+	Service string   // Nome do SRV, ex: _p2pvpn._udp.vpn.example.com.
+	Target  string   // Nome do nó, ex: n1a2b3c4d5e6f7a8b9c0.vpn.example.com.
+	Port    uint16   // Porta de descoberta
+	TXT     []string // Anúncio assinado em blocos de até 255 bytes
+	TTL     uint32   // TTL em segundos
+}
+
+// DNSUpdater publica o registro do nó no DNS
+// DNSUpdater publishes the node record in DNS
+// DNSUpdater publica el registro del nodo en DNS
+type DNSUpdater interface {
+	Publish(record DNSRecord) error
+}
+
+// BuildDNSRecord monta os registros DNS de um anúncio assinado sob o domínio informado.
+// O nome do nó deriva da chave WireGuard, que é estável e válida como rótulo DNS.
+func BuildDNSRecord(domain string, announcement []byte, ttl uint32) (DNSRecord, error) {
+	msg, err := DecodeMessage(announcement)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+
+	var content Announcement
+	if err := msg.Decode(&content); err != nil {
+		return DNSRecord{}, err
+	}
+	key, err := DHTKey(content.PublicKey)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+
+	encoded := DNSRecordPrefix + base64.StdEncoding.EncodeToString(announcement)
+	var chunks []string
+	for len(encoded) > dnsTXTChunk {
+		chunks = append(chunks, encoded[:dnsTXTChunk])
+		encoded = encoded[dnsTXTChunk:]
+	}
+	chunks = append(chunks, encoded)
+
+	return DNSRecord{
+		Service: fqdn("_" + DNSServiceName + "._udp." + domain),
+		Target:  fqdn("n" + hex.EncodeToString(key[:10]) + "." + domain),
+		Port:    uint16(content.DiscoveryPort),
+		TXT:     chunks,
+		TTL:     ttl,
+	}, nil
+}
+
+// ParseDNSRecord decodifica e valida o anúncio assinado de um TXT.
+// Retorna erro se o TXT não for um registro de descoberta.
+func ParseDNSRecord(txt string) (*SignedMessage, error) {
+	if !strings.HasPrefix(txt, DNSRecordPrefix) {
+		return nil, fmt.Errorf("%w: TXT sem o prefixo %s", ErrInvalidMessage, DNSRecordPrefix)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(txt, DNSRecordPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: TXT com base64 inválido", ErrInvalidMessage)
+	}
+
+	msg, err := DecodeMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != MsgAnnouncement {
+		return nil, fmt.Errorf("%w: registro DNS do tipo %s", ErrInvalidMessage, msg.Type)
+	}
+
+	age := time.Since(msg.Timestamp)
+	if age > DNSRecordMaxAge || age < -MaxClockSkew {
+		return nil, ErrStaleMessage
+	}
+
+	return msg, nil
+}
+
+// fqdn garante o ponto final de um nome DNS absoluto
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// dnsBackend consulta os registros SRV/TXT de um domínio e entrega os anúncios assinados
+// dos peers confiáveis. Com um DNSUpdater, também publica o registro do próprio nó.
+type dnsBackend struct {
+	discovery *PeerDiscovery
+	domain    string
+	resolver  *net.Resolver
+	updater   DNSUpdater
+	interval  time.Duration
+	results   chan DiscoveryResult
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// newDNSBackend cria o backend de DNS. server é o servidor DNS (host:porta); vazio usa o
+// resolvedor do sistema. updater pode ser nil para apenas consultar.
+func newDNSBackend(discovery *PeerDiscovery, domain, server string, updater DNSUpdater) *dnsBackend {
+	resolver := net.DefaultResolver
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &dnsBackend{
+		discovery: discovery,
+		domain:    strings.TrimSuffix(domain, "."),
+		resolver:  resolver,
+		updater:   updater,
+		interval:  DefaultDNSInterval,
+		results:   make(chan DiscoveryResult, backendResultsBuffer),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Name retorna o nome do backend
+func (d *dnsBackend) Name() string {
+	return BackendDNS
+}
+
+// Results retorna o canal dos anúncios encontrados no DNS
+func (d *dnsBackend) Results() <-chan DiscoveryResult {
+	return d.results
+}
+
+// Start inicia as consultas e publicações periódicas
+func (d *dnsBackend) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.running {
+		return fmt.Errorf("o backend de DNS já está em execução")
+	}
+	if d.domain == "" {
+		return fmt.Errorf("nenhum domínio de descoberta DNS configurado")
+	}
+
+	d.running = true
+	go d.dnsRoutine()
+	return nil
+}
+
+// Stop para as consultas e publicações periódicas
+func (d *dnsBackend) Stop() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.running {
+		return nil
+	}
+
+	close(d.stopChan)
+	d.running = false
+	return nil
+}
+
+// Announce publica o registro deste nó, se houver um DNSUpdater
+func (d *dnsBackend) Announce() error {
+	if d.updater == nil {
+		return nil
+	}
+
+	announcement, err := d.discovery.buildAnnouncement()
+	if err != nil {
+		return err
+	}
+	record, err := BuildDNSRecord(d.domain, announcement, DefaultDNSTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := d.updater.Publish(record); err != nil {
+		return fmt.Errorf("erro ao publicar registro DNS: %w", err)
+	}
+	return nil
+}
+
+// dnsRoutine publica o registro local e consulta o domínio periodicamente
+func (d *dnsBackend) dnsRoutine() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Announce(); err != nil {
+			fmt.Printf("Erro ao anunciar via DNS: %v\n", err)
+		}
+		if err := d.resolve(); err != nil {
+			fmt.Printf("Erro na descoberta via DNS em %s: %v\n", d.domain, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+// resolve consulta o SRV do domínio e o TXT de cada nó listado
+func (d *dnsBackend) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	_, services, err := d.resolver.LookupSRV(ctx, DNSServiceName, "udp", d.domain)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		texts, err := d.resolver.LookupTXT(ctx, service.Target)
+		if err != nil {
+			fmt.Printf("Erro ao consultar TXT de %s: %v\n", service.Target, err)
+			continue
+		}
+
+		for _, txt := range texts {
+			msg, err := ParseDNSRecord(txt)
+			if err != nil {
+				continue
+			}
+			if msg.SignerKey.Equal(d.discovery.signingKey.Public()) {
+				continue
+			}
+			if err := d.discovery.checkDNSRecord(msg); err != nil {
+				fmt.Printf("Registro DNS de %s ignorado: %v\n", service.Target, err)
+				continue
+			}
+
+			addr := d.serviceAddr(ctx, service.Target, service.Port)
+			if addr == nil {
+				if addr, err = announcedDiscoveryAddr(msg); err != nil {
+					continue
+				}
+			}
+
+			publishResult(d.results, DiscoveryResult{
+				Backend:       BackendDNS,
+				Message:       msg,
+				Addr:          addr,
+				DiscoveryAddr: addr.String(),
+			}, d.stopChan)
+		}
+	}
+
+	return nil
+}
+
+// serviceAddr resolve o endereço do nome do nó indicado no SRV
+func (d *dnsBackend) serviceAddr(ctx context.Context, target string, port uint16) *net.UDPAddr {
+	if port == 0 {
+		return nil
+	}
+
+	addrs, err := d.resolver.LookupIPAddr(ctx, target)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	return &net.UDPAddr{IP: addrs[0].IP, Port: int(port)}
+}
+
+// checkDNSRecord aceita apenas anúncios de peers confiáveis, com a chave de assinatura
+// registrada quando houver uma. Qualquer um pode escrever no DNS de terceiros.
+func (p *PeerDiscovery) checkDNSRecord(msg *SignedMessage) error {
+	var announcement Announcement
+	if err := msg.Decode(&announcement); err != nil {
+		return err
+	}
+
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.PublicKey != announcement.PublicKey {
+			continue
+		}
+		if peer.SigningKey != "" && peer.SigningKey != msg.Signer() {
+			return fmt.Errorf("chave de assinatura difere da registrada para o peer %s", peer.NodeID)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("nó %s não está entre os peers confiáveis", announcement.NodeID)
+}
+
+// SetDNSUpdater define como o backend de DNS publica o registro deste nó.
+// Substitui a atualização dinâmica definida na configuração.
+func (p *PeerDiscovery) SetDNSUpdater(updater DNSUpdater) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dnsUpdater = updater
+}
@@ -0,0 +1,261 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Parâmetros da atualização dinâmica (RFC 2136) e da autenticação TSIG (RFC 8945)
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeTSIG     = 250
+	tsigFudge       = 300
+)
+
+// TSIGKey é a chave compartilhada que autentica as atualizações dinâmicas
+// TSIGKey is the shared key that authenticates dynamic updates
+// TSIGKey es la clave compartida que autentica las actualizaciones dinámicas
+type TSIGKey struct {
+	Name      string // Nome da chave, como configurado no servidor
+	Algorithm string // hmac-sha256 (padrão) ou hmac-sha512
+	Secret    []byte
+}
+
+// RFC2136Updater publica o registro do nó por atualização dinâmica de DNS (RFC 2136) via TCP
+// RFC2136Updater publishes the node record through DNS dynamic update (RFC 2136) over TCP
+// RFC2136Updater publica el registro del nodo mediante actualización dinámica de DNS (RFC 2136) por TCP
+type RFC2136Updater struct {
+	server  string
+	zone    string
+	key     *TSIGKey
+	timeout time.Duration
+}
+
+// NewRFC2136Updater cria um DNSUpdater para a zona informada. key pode ser nil se o
+// servidor aceitar atualizações sem autenticação.
+func NewRFC2136Updater(server, zone string, key *TSIGKey) *RFC2136Updater {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &RFC2136Updater{
+		server:  server,
+		zone:    fqdn(zone),
+		key:     key,
+		timeout: dnsTimeout,
+	}
+}
+
+// Publish substitui o TXT do nó e garante o SRV que o lista no domínio
+func (u *RFC2136Updater) Publish(record DNSRecord) error {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	msg, err := u.buildUpdate(id, record)
+	if err != nil {
+		return err
+	}
+	if u.key != nil {
+		if msg, err = signTSIG(msg, u.key, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	response, err := u.exchange(msg)
+	if err != nil {
+		return err
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return fmt.Errorf("resposta de atualização DNS inválida: %w", err)
+	}
+	if header.ID != id {
+		return fmt.Errorf("resposta de atualização DNS com ID inesperado")
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("atualização DNS recusada pelo servidor: %s", header.RCode)
+	}
+
+	return nil
+}
+
+// buildUpdate monta a mensagem de atualização: apaga o TXT anterior do nó, publica o novo
+// e acrescenta o SRV (acrescentar um registro já existente não tem efeito)
+func (u *RFC2136Updater) buildUpdate(id uint16, record DNSRecord) ([]byte, error) {
+	zone, err := dnsmessage.NewName(u.zone)
+	if err != nil {
+		return nil, err
+	}
+	service, err := dnsmessage.NewName(fqdn(record.Service))
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(fqdn(record.Target))
+	if err != nil {
+		return nil, err
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: dnsOpcodeUpdate})
+
+	// Seção de zona
+	builder.StartQuestions()
+	if err := builder.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+
+	// Seção de atualização (sem pré-requisitos)
+	builder.StartAnswers()
+	builder.StartAuthorities()
+	if err := builder.UnknownResource(
+		dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassANY},
+		dnsmessage.UnknownResource{Type: dnsmessage.TypeTXT},
+	); err != nil {
+		return nil, err
+	}
+	if err := builder.TXTResource(
+		dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET, TTL: record.TTL},
+		dnsmessage.TXTResource{TXT: record.TXT},
+	); err != nil {
+		return nil, err
+	}
+	if err := builder.SRVResource(
+		dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: record.TTL},
+		dnsmessage.SRVResource{Port: record.Port, Target: target},
+	); err != nil {
+		return nil, err
+	}
+
+	return builder.Finish()
+}
+
+// exchange envia a mensagem ao servidor por TCP e lê a resposta
+func (u *RFC2136Updater) exchange(msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", u.server, u.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar ao servidor DNS %s: %w", u.server, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(u.timeout))
+
+	// Mensagens DNS sobre TCP são precedidas pelo tamanho em 2 bytes
+	frame := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	if _, err := conn.Write(append(frame, msg...)); err != nil {
+		return nil, fmt.Errorf("erro ao enviar atualização DNS: %w", err)
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("erro ao ler resposta de atualização DNS: %w", err)
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, fmt.Errorf("erro ao ler resposta de atualização DNS: %w", err)
+	}
+
+	return response, nil
+}
+
+// signTSIG acrescenta a assinatura TSIG a uma mensagem DNS pronta
+func signTSIG(msg []byte, key *TSIGKey, now time.Time) ([]byte, error) {
+	algorithm := strings.ToLower(strings.TrimSuffix(key.Algorithm, "."))
+	var newHash func() hash.Hash
+	switch algorithm {
+	case "", "hmac-sha256":
+		algorithm, newHash = "hmac-sha256", sha256.New
+	case "hmac-sha512":
+		newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("algoritmo TSIG não suportado: %s", key.Algorithm)
+	}
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("mensagem DNS incompleta")
+	}
+
+	keyName, err := wireName(key.Name)
+	if err != nil {
+		return nil, err
+	}
+	algName, err := wireName(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeSigned [6]byte
+	signed := uint64(now.Unix())
+	for i := range timeSigned {
+		timeSigned[5-i] = byte(signed >> (8 * uint(i)))
+	}
+
+	// Variáveis TSIG cobertas pelo MAC, após a mensagem (RFC 8945, seção 4.3.3)
+	variables := append([]byte(nil), keyName...)
+	variables = binary.BigEndian.AppendUint16(variables, uint16(dnsmessage.ClassANY))
+	variables = binary.BigEndian.AppendUint32(variables, 0)
+	variables = append(variables, algName...)
+	variables = append(variables, timeSigned[:]...)
+	variables = binary.BigEndian.AppendUint16(variables, tsigFudge)
+	variables = binary.BigEndian.AppendUint16(variables, 0) // Erro
+	variables = binary.BigEndian.AppendUint16(variables, 0) // Tamanho dos dados extras
+
+	mac := hmac.New(newHash, key.Secret)
+	mac.Write(msg)
+	mac.Write(variables)
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte(nil), algName...)
+	rdata = append(rdata, timeSigned[:]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0:2]...) // ID original
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+
+	signedMsg := append([]byte(nil), msg...)
+	signedMsg = append(signedMsg, keyName...)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, dnsTypeTSIG)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, uint16(dnsmessage.ClassANY))
+	signedMsg = binary.BigEndian.AppendUint32(signedMsg, 0)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, uint16(len(rdata)))
+	signedMsg = append(signedMsg, rdata...)
+
+	// O registro TSIG vai na seção adicional
+	additional := binary.BigEndian.Uint16(signedMsg[10:12])
+	binary.BigEndian.PutUint16(signedMsg[10:12], additional+1)
+
+	return signedMsg, nil
+}
+
+// wireName codifica um nome DNS na forma canônica (minúsculas, sem compressão)
+func wireName(name string) ([]byte, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return []byte{0}, nil
+	}
+
+	var wire []byte
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("nome DNS inválido: %s", name)
+		}
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	return append(wire, 0), nil
+}
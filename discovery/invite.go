@@ -0,0 +1,567 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"gopkg.in/yaml.v3"
+)
+
+// InviteTokenPrefix identifica um convite codificado
+const InviteTokenPrefix = "p2pvpn:"
+
+// Erros de convites
+var (
+	ErrInviteExpired  = errors.New("convite expirado")
+	ErrInviteUnknown  = errors.New("convite desconhecido")
+	ErrInviteConsumed = errors.New("convite já utilizado")
+)
+
+// Invite é o conteúdo de um convite assinado pelo nó que convida
+type Invite struct {
+	ID          string   `json:"id"`
+	NodeID      string   `json:"nodeId"`
+	PublicKey   string   `json:"publicKey"`
+	VirtualIP   string   `json:"virtualIp"`
+	VirtualCIDR string   `json:"cidr,omitempty"`
+	Endpoints   []string `json:"endpoints,omitempty"` // Endpoints WireGuard do anfitrião
+	Discovery   []string `json:"discovery"`           // Endereços de descoberta do anfitrião
+	AssignedIP  string   `json:"assignedIp,omitempty"`
+	Expires     int64    `json:"expires"` // Unix, em segundos
+	SingleUse   bool     `json:"singleUse,omitempty"`
+}
+
+// JoinRequest é enviado pelo convidado ao anfitrião para trocar as chaves
+type JoinRequest struct {
+	InviteID   string   `json:"inviteId"`
+	NodeID     string   `json:"nodeId"`
+	PublicKey  string   `json:"publicKey"`
+	VirtualIP  string   `json:"virtualIp"` // IP atual do convidado; o definitivo é atribuído pelo anfitrião
+	ListenPort int      `json:"listenPort"`
+	Endpoints  []string `json:"endpoints,omitempty"`
+}
+
+// JoinAccept é a resposta do anfitrião a um JoinRequest
+type JoinAccept struct {
+	InviteID  string `json:"inviteId"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	VirtualIP string `json:"virtualIp,omitempty"` // IP virtual atribuído ao convidado
+}
+
+// EncodeInvite assina um convite e o codifica como texto
+func EncodeInvite(invite *Invite, key ed25519.PrivateKey) (string, error) {
+	data, err := EncodeMessage(MsgInvite, invite, key)
+	if err != nil {
+		return "", err
+	}
+	return InviteTokenPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeInvite valida a assinatura e a validade de um convite.
+// Retorna também a chave de assinatura do anfitrião (base64).
+func DecodeInvite(token string) (*Invite, string, error) {
+	token = strings.TrimPrefix(strings.TrimSpace(token), InviteTokenPrefix)
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: convite mal formado", ErrInvalidMessage)
+	}
+
+	msg, err := DecodeMessage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if msg.Type != MsgInvite {
+		return nil, "", fmt.Errorf("%w: não é um convite", ErrInvalidMessage)
+	}
+
+	invite := &Invite{}
+	if err := msg.Decode(invite); err != nil {
+		return nil, "", err
+	}
+	if invite.ID == "" || invite.PublicKey == "" || len(invite.Discovery) == 0 {
+		return nil, "", fmt.Errorf("%w: convite incompleto", ErrInvalidMessage)
+	}
+	if time.Now().Unix() > invite.Expires {
+		return nil, "", ErrInviteExpired
+	}
+
+	return invite, msg.Signer(), nil
+}
+
+// NewInvite cria um convite para a configuração local. discoveryPort é a porta do
+// serviço de descoberta do anfitrião; extraEndpoints são endereços públicos conhecidos (host:porta WireGuard).
+func NewInvite(config *core.Config, wgPort, discoveryPort int, extraEndpoints []string, assignedIP string, ttl time.Duration, singleUse bool) (*Invite, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("erro ao gerar ID do convite: %w", err)
+	}
+
+	interfaceName := config.InterfaceName
+	if interfaceName == "" {
+		interfaceName = "wg0"
+	}
+
+	invite := &Invite{
+		ID:          hex.EncodeToString(id[:]),
+		NodeID:      config.NodeID,
+		PublicKey:   config.PublicKey,
+		VirtualIP:   config.VirtualIP,
+		VirtualCIDR: config.VirtualCIDR,
+		AssignedIP:  assignedIP,
+		Expires:     time.Now().Add(ttl).Unix(),
+		SingleUse:   singleUse,
+	}
+
+	endpoints := append([]string(nil), extraEndpoints...)
+	for _, endpoint := range localEndpoints(wgPort, interfaceName) {
+		endpoints = appendUnique(endpoints, endpoint)
+	}
+
+	// O serviço de descoberta escuta nos mesmos hosts dos endpoints WireGuard
+	for _, endpoint := range endpoints {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		invite.Discovery = appendUnique(invite.Discovery, net.JoinHostPort(host, strconv.Itoa(discoveryPort)))
+	}
+	invite.Endpoints = endpoints
+
+	if len(invite.Discovery) == 0 {
+		return nil, fmt.Errorf("nenhum endereço local disponível para o convite")
+	}
+
+	return invite, nil
+}
+
+// IssuedInvite é o registro local de um convite emitido
+type IssuedInvite struct {
+	ID         string   `yaml:"id"`
+	AssignedIP string   `yaml:"assignedIp,omitempty"`
+	Expires    int64    `yaml:"expires"`
+	SingleUse  bool     `yaml:"singleUse,omitempty"`
+	UsedBy     []string `yaml:"usedBy,omitempty"` // Chaves WireGuard que já usaram o convite
+}
+
+// InviteStore guarda os convites emitidos em um arquivo ao lado da configuração
+type InviteStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// InviteStorePath retorna o arquivo de convites ao lado do arquivo de configuração
+func InviteStorePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "invites.yaml")
+}
+
+// NewInviteStore cria o armazenamento de convites no caminho informado
+func NewInviteStore(path string) *InviteStore {
+	return &InviteStore{path: path}
+}
+
+// load lê os convites do arquivo; um arquivo inexistente equivale a nenhum convite
+func (s *InviteStore) load() ([]IssuedInvite, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []IssuedInvite
+	if err := yaml.Unmarshal(data, &invites); err != nil {
+		return nil, fmt.Errorf("erro ao analisar arquivo de convites: %w", err)
+	}
+	return invites, nil
+}
+
+// save grava os convites, descartando os expirados
+func (s *InviteStore) save(invites []IssuedInvite) error {
+	var active []IssuedInvite
+	now := time.Now().Unix()
+	for _, invite := range invites {
+		if invite.Expires >= now {
+			active = append(active, invite)
+		}
+	}
+
+	data, err := yaml.Marshal(active)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar convites: %w", err)
+	}
+	if err := ioutil.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("erro ao salvar arquivo de convites: %w", err)
+	}
+	return nil
+}
+
+// Add registra um convite emitido
+func (s *InviteStore) Add(invite *Invite) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	invites, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	invites = append(invites, IssuedInvite{
+		ID:         invite.ID,
+		AssignedIP: invite.AssignedIP,
+		Expires:    invite.Expires,
+		SingleUse:  invite.SingleUse,
+	})
+	return s.save(invites)
+}
+
+// List retorna os convites ainda válidos
+func (s *InviteStore) List() ([]IssuedInvite, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	invites, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var active []IssuedInvite
+	now := time.Now().Unix()
+	for _, invite := range invites {
+		if invite.Expires >= now {
+			active = append(active, invite)
+		}
+	}
+	return active, nil
+}
+
+// Get retorna o convite emitido com o ID informado, sem marcá-lo como usado
+func (s *InviteStore) Get(id string) (*IssuedInvite, error) {
+	invites, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range invites {
+		if invites[i].ID == id {
+			return &invites[i], nil
+		}
+	}
+	return nil, ErrInviteUnknown
+}
+
+// Consume marca o uso de um convite por uma chave WireGuard.
+// Repetir o pedido com a mesma chave é permitido, para tolerar perda de pacotes.
+func (s *InviteStore) Consume(id, publicKey string) (*IssuedInvite, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	invites, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range invites {
+		invite := &invites[i]
+		if invite.ID != id {
+			continue
+		}
+
+		if time.Now().Unix() > invite.Expires {
+			return nil, ErrInviteExpired
+		}
+
+		for _, used := range invite.UsedBy {
+			if used == publicKey {
+				return invite, nil
+			}
+		}
+		if invite.SingleUse && len(invite.UsedBy) > 0 {
+			return nil, ErrInviteConsumed
+		}
+
+		invite.UsedBy = append(invite.UsedBy, publicKey)
+		result := *invite
+		return &result, s.save(invites)
+	}
+
+	return nil, ErrInviteUnknown
+}
+
+// NextFreeIP escolhe um IP livre na rede virtual para atribuir a um convidado
+func NextFreeIP(config *core.Config, store *InviteStore) (string, error) {
+	return nextFreeIP(config, config.TrustedPeers, store)
+}
+
+// nextFreeIP escolhe um IP livre na rede virtual do nó, fora dos IPs dos peers informados
+func nextFreeIP(config *core.Config, peers []core.TrustedPeer, store *InviteStore) (string, error) {
+	_, network, err := net.ParseCIDR(config.VirtualCIDR)
+	if err != nil {
+		return "", fmt.Errorf("rede virtual inválida %q: %w", config.VirtualCIDR, err)
+	}
+
+	used := map[string]bool{config.VirtualIP: true}
+	for _, peer := range peers {
+		used[peer.VirtualIP] = true
+	}
+	if store != nil {
+		invites, _ := store.List()
+		for _, invite := range invites {
+			used[invite.AssignedIP] = true
+		}
+	}
+
+	base := network.IP.To4()
+	if base == nil {
+		return "", fmt.Errorf("apenas redes virtuais IPv4 são suportadas")
+	}
+	ones, size := network.Mask.Size()
+	hosts := 1 << uint(size-ones)
+
+	// Ignorar o endereço de rede e o de broadcast
+	for i := 1; i < hosts-1; i++ {
+		ip := make(net.IP, 4)
+		n := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+		n += uint32(i)
+		ip[0], ip[1], ip[2], ip[3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+
+		if !used[ip.String()] {
+			return ip.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("nenhum IP livre na rede %s", config.VirtualCIDR)
+}
+
+// JoinNetwork troca as chaves com o anfitrião de um convite pelo protocolo de descoberta.
+// Em caso de sucesso o anfitrião já adicionou este nó aos seus peers confiáveis.
+func JoinNetwork(invite *Invite, inviterSigningKey string, config *core.Config, wgPort int, timeout time.Duration) error {
+	signingKey, err := config.SigningKey()
+	if err != nil {
+		return fmt.Errorf("erro ao obter chave de assinatura: %w", err)
+	}
+
+	interfaceName := config.InterfaceName
+	if interfaceName == "" {
+		interfaceName = "wg0"
+	}
+
+	request := JoinRequest{
+		InviteID:   invite.ID,
+		NodeID:     config.NodeID,
+		PublicKey:  config.PublicKey,
+		VirtualIP:  config.VirtualIP,
+		ListenPort: wgPort,
+		Endpoints:  localEndpoints(wgPort, interfaceName),
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return fmt.Errorf("erro ao abrir socket UDP: %w", err)
+	}
+	defer conn.Close()
+
+	var targets []*net.UDPAddr
+	for _, target := range invite.Discovery {
+		if addr, err := net.ResolveUDPAddr("udp", target); err == nil {
+			targets = append(targets, addr)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("convite sem endereços de descoberta válidos")
+	}
+
+	buffer := make([]byte, MaxMessageSize)
+	deadline := time.Now().Add(timeout)
+
+	// Reenviar o pedido periodicamente até receber a resposta do anfitrião.
+	// Cada tentativa é assinada de novo para não ser descartada como replay.
+	for time.Now().Before(deadline) {
+		data, err := EncodeMessage(MsgJoinRequest, request, signingKey)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			conn.WriteToUDP(data, target)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		for {
+			n, _, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				break
+			}
+
+			msg, err := DecodeMessage(buffer[:n])
+			if err != nil || msg.Type != MsgJoinAccept || msg.Signer() != inviterSigningKey {
+				continue
+			}
+
+			var accept JoinAccept
+			if err := msg.Decode(&accept); err != nil || accept.InviteID != invite.ID {
+				continue
+			}
+			if !accept.Accepted {
+				return fmt.Errorf("convite recusado pelo anfitrião: %s", accept.Reason)
+			}
+
+			// O anfitrião decide o IP virtual, para que não colida com os peers dele
+			if accept.VirtualIP != "" {
+				config.VirtualIP = accept.VirtualIP
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("o anfitrião %s não respondeu", invite.NodeID)
+}
+
+// SetInviteStore define onde o serviço de descoberta encontra os convites emitidos
+func (p *PeerDiscovery) SetInviteStore(store *InviteStore) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.invites = store
+}
+
+// SetConfigPath define o arquivo onde mudanças nos peers confiáveis são persistidas
+func (p *PeerDiscovery) SetConfigPath(path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.configPath = path
+}
+
+// handleJoinRequest aceita um convidado que apresenta um convite válido. O ID e a chave do
+// convidado não podem pertencer a outro peer, e o IP virtual é atribuído aqui, para que o
+// convite não sirva para substituir um peer existente.
+func (p *PeerDiscovery) handleJoinRequest(msg *SignedMessage, addr *net.UDPAddr) {
+	p.mutex.Lock()
+	store := p.invites
+	p.mutex.Unlock()
+
+	if store == nil {
+		return
+	}
+
+	var request JoinRequest
+	if err := msg.Decode(&request); err != nil {
+		fmt.Printf("Pedido de entrada inválido de %s: %v\n", addr.String(), err)
+		return
+	}
+
+	if request.NodeID == "" || request.PublicKey == "" {
+		p.replyJoin(request.InviteID, "pedido incompleto", addr)
+		return
+	}
+	if _, err := DHTKey(request.PublicKey); err != nil {
+		p.replyJoin(request.InviteID, "chave pública inválida", addr)
+		return
+	}
+
+	virtualIP, err := p.assignJoinIP(store, request, msg.Signer())
+	if err == nil {
+		_, err = store.Consume(request.InviteID, request.PublicKey)
+	}
+	if err != nil {
+		fmt.Printf("Pedido de entrada de %s (%s) recusado: %v\n", request.NodeID, addr.String(), err)
+		p.replyJoin(request.InviteID, err.Error(), addr)
+		return
+	}
+
+	endpoints := make([]string, 0, len(request.Endpoints)+1)
+	if request.ListenPort > 0 {
+		endpoints = append(endpoints, net.JoinHostPort(addr.IP.String(), strconv.Itoa(request.ListenPort)))
+	}
+	for _, endpoint := range request.Endpoints {
+		endpoints = appendUnique(endpoints, endpoint)
+	}
+
+	fmt.Printf("Convidado %s (%s) entrou na rede com o IP %s\n", request.NodeID, addr.String(), virtualIP)
+
+	// O convite válido é a autorização do novo peer
+	if err := p.applyPeerInfo(&PeerInfo{
+		NodeID:     request.NodeID,
+		PublicKey:  request.PublicKey,
+		VirtualIP:  virtualIP,
+		Endpoints:  endpoints,
+		SigningKey: msg.Signer(),
+	}); err != nil {
+		fmt.Printf("Erro ao atualizar peer %s no VPN: %v\n", request.NodeID, err)
+	}
+
+	p.persistConfig()
+
+	accept, err := EncodeMessage(MsgJoinAccept, JoinAccept{
+		InviteID:  request.InviteID,
+		Accepted:  true,
+		VirtualIP: virtualIP,
+	}, p.signingKey)
+	if err == nil {
+		p.sendPacket(accept, addr)
+	}
+}
+
+// assignJoinIP escolhe o IP virtual do convidado: o já atribuído, se o pedido é a repetição
+// de uma entrada aceita, o definido no convite ou o próximo IP livre. Recusa convidados
+// cujo ID ou chave já pertençam a outro peer ou a este nó.
+func (p *PeerDiscovery) assignJoinIP(store *InviteStore, request JoinRequest, signingKey string) (string, error) {
+	if request.NodeID == p.nodeID || request.PublicKey == p.publicKey {
+		return "", fmt.Errorf("ID ou chave do convidado pertence ao anfitrião")
+	}
+
+	config := p.vpnCore.GetConfig()
+	trusted := p.vpnCore.TrustedPeers()
+	for _, peer := range trusted {
+		if peer.PublicKey == request.PublicKey {
+			// Repetição do mesmo convidado, por perda da resposta
+			if peer.NodeID == request.NodeID && peer.SigningKey == signingKey {
+				return peer.VirtualIP, nil
+			}
+			return "", fmt.Errorf("chave pública já pertence ao peer %s", peer.NodeID)
+		}
+		if peer.NodeID == request.NodeID {
+			return "", fmt.Errorf("ID %s já pertence a outro peer", request.NodeID)
+		}
+	}
+
+	issued, err := store.Get(request.InviteID)
+	if err != nil {
+		return "", err
+	}
+
+	virtualIP := issued.AssignedIP
+	if virtualIP == "" {
+		return nextFreeIP(config, trusted, store)
+	}
+
+	if virtualIP == config.VirtualIP {
+		return "", fmt.Errorf("IP virtual %s pertence ao anfitrião", virtualIP)
+	}
+	for _, peer := range trusted {
+		if peer.VirtualIP == virtualIP {
+			return "", fmt.Errorf("IP virtual %s já pertence ao peer %s", virtualIP, peer.NodeID)
+		}
+	}
+	return virtualIP, nil
+}
+
+// replyJoin recusa um pedido de entrada informando o motivo
+func (p *PeerDiscovery) replyJoin(inviteID, reason string, addr *net.UDPAddr) {
+	data, err := EncodeMessage(MsgJoinAccept, JoinAccept{InviteID: inviteID, Reason: reason}, p.signingKey)
+	if err == nil {
+		p.sendPacket(data, addr)
+	}
+}
@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// keyProof calcula a prova de posse de uma chave WireGuard: um HMAC, com o segredo X25519
+// entre a chave do nó e a de quem verifica, sobre a chave que assina a mensagem e a identidade
+// anunciada. A prova não serve a outro assinante nem a outro verificador.
+func keyProof(context string, shared []byte, signer ed25519.PublicKey, announcement Announcement) []byte {
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte(context))
+	mac.Write(signer)
+	mac.Write([]byte(announcement.NodeID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(announcement.PublicKey))
+	return mac.Sum(nil)
+}
+
+// verifyKeyProof confere a prova de posse da chave WireGuard anunciada com a chave X25519
+// de quem verifica
+func verifyKeyProof(context string, local *ecdh.PrivateKey, proof []byte, signer ed25519.PublicKey, announcement Announcement) error {
+	wgKey, err := parseExchangePublicKey(announcement.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	shared, err := local.ECDH(wgKey)
+	if err != nil {
+		return fmt.Errorf("chave WireGuard inválida: %w", err)
+	}
+
+	if !hmac.Equal(proof, keyProof(context, shared, signer, announcement)) {
+		return fmt.Errorf("prova de posse da chave WireGuard inválida")
+	}
+	return nil
+}
+
+// parseExchangePrivateKey decodifica uma chave privada WireGuard (base64) como chave X25519
+func parseExchangePrivateKey(encoded string) (*ecdh.PrivateKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar chave privada: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("chave privada WireGuard inválida: %w", err)
+	}
+	return key, nil
+}
+
+// parseExchangePublicKey decodifica uma chave pública WireGuard (base64) como chave X25519
+func parseExchangePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("chave WireGuard inválida: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("chave WireGuard inválida: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,336 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Grupos multicast usados para descoberta na LAN
+const (
+	DefaultMulticastPort     = 51822
+	DefaultMulticastGroupV4  = "239.255.51.82" // Escopo administrativo local (RFC 2365)
+	DefaultMulticastGroupV6  = "ff02::5182"    // Escopo de enlace
+	DefaultMulticastInterval = 10 * time.Second
+)
+
+// MulticastDiscovery anuncia o nó e escuta anúncios de outros nós no mesmo segmento L2
+type MulticastDiscovery struct {
+	port         int
+	vpnInterface string
+	interval     time.Duration
+
+	// Fonte dos anúncios assinados e filtro das mensagens recebidas
+	announce func() ([]byte, error)
+	accept   func(data []byte, addr *net.UDPAddr) (*SignedMessage, bool)
+	results  chan DiscoveryResult
+
+	conn4 *ipv4.PacketConn
+	conn6 *ipv6.PacketConn
+
+	interfaces []net.Interface
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewMulticastDiscovery cria o backend de descoberta multicast. accept decodifica e filtra
+// os pacotes recebidos; apenas os anúncios aceitos são entregues em Results.
+func NewMulticastDiscovery(port int, vpnInterface string, announce func() ([]byte, error), accept func([]byte, *net.UDPAddr) (*SignedMessage, bool)) *MulticastDiscovery {
+	if port <= 0 {
+		port = DefaultMulticastPort
+	}
+
+	return &MulticastDiscovery{
+		port:         port,
+		vpnInterface: vpnInterface,
+		interval:     DefaultMulticastInterval,
+		announce:     announce,
+		accept:       accept,
+		results:      make(chan DiscoveryResult, backendResultsBuffer),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Name retorna o nome do backend
+func (m *MulticastDiscovery) Name() string {
+	return BackendMulticast
+}
+
+// Results retorna o canal dos anúncios recebidos nos grupos multicast
+func (m *MulticastDiscovery) Results() <-chan DiscoveryResult {
+	return m.results
+}
+
+// Start entra nos grupos multicast em todas as interfaces da LAN
+func (m *MulticastDiscovery) Start() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running {
+		return fmt.Errorf("a descoberta multicast já está em execução")
+	}
+
+	m.interfaces = multicastInterfaces(m.vpnInterface)
+	if len(m.interfaces) == 0 {
+		return fmt.Errorf("nenhuma interface com suporte a multicast encontrada")
+	}
+
+	group4 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV4)}
+	group6 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV6)}
+
+	// IPv4
+	if c, err := net.ListenPacket("udp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(m.port))); err == nil {
+		conn := ipv4.NewPacketConn(c)
+		if rejoinGroup(conn.JoinGroup, conn.LeaveGroup, group4, nil, m.interfaces) > 0 {
+			conn.SetMulticastLoopback(true)
+			m.conn4 = conn
+		} else {
+			c.Close()
+		}
+	} else {
+		fmt.Printf("Aviso: descoberta multicast IPv4 indisponível: %v\n", err)
+	}
+
+	// IPv6
+	if c, err := net.ListenPacket("udp6", net.JoinHostPort("::", strconv.Itoa(m.port))); err == nil {
+		conn := ipv6.NewPacketConn(c)
+		if rejoinGroup(conn.JoinGroup, conn.LeaveGroup, group6, nil, m.interfaces) > 0 {
+			conn.SetMulticastLoopback(true)
+			m.conn6 = conn
+		} else {
+			c.Close()
+		}
+	} else {
+		fmt.Printf("Aviso: descoberta multicast IPv6 indisponível: %v\n", err)
+	}
+
+	if m.conn4 == nil && m.conn6 == nil {
+		return fmt.Errorf("não foi possível entrar em nenhum grupo multicast")
+	}
+
+	m.running = true
+
+	if m.conn4 != nil {
+		go m.receiveLoop(m.conn4.SetReadDeadline, func(b []byte) (int, net.Addr, error) {
+			n, _, src, err := m.conn4.ReadFrom(b)
+			return n, src, err
+		})
+	}
+	if m.conn6 != nil {
+		go m.receiveLoop(m.conn6.SetReadDeadline, func(b []byte) (int, net.Addr, error) {
+			n, _, src, err := m.conn6.ReadFrom(b)
+			return n, src, err
+		})
+	}
+	go m.announceLoop()
+
+	return nil
+}
+
+// Stop sai dos grupos multicast e fecha os sockets
+func (m *MulticastDiscovery) Stop() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	close(m.stopChan)
+
+	if m.conn4 != nil {
+		m.conn4.Close()
+		m.conn4 = nil
+	}
+	if m.conn6 != nil {
+		m.conn6.Close()
+		m.conn6 = nil
+	}
+
+	m.running = false
+	return nil
+}
+
+// HandleNetworkChange volta a entrar nos grupos multicast nas interfaces atuais. As interfaces
+// são escolhidas em Start; sem isso, depois de trocar de rede (ex: Wi-Fi para cabo) o nó não
+// anunciaria nem ouviria anúncios na LAN nova.
+// HandleNetworkChange rejoins the multicast groups on the current interfaces
+// HandleNetworkChange vuelve a unirse a los grupos multicast en las interfaces actuales
+func (m *MulticastDiscovery) HandleNetworkChange() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.running {
+		return
+	}
+
+	interfaces := multicastInterfaces(m.vpnInterface)
+	joined := 0
+	if m.conn4 != nil {
+		group4 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV4)}
+		joined += rejoinGroup(m.conn4.JoinGroup, m.conn4.LeaveGroup, group4, m.interfaces, interfaces)
+	}
+	if m.conn6 != nil {
+		group6 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV6)}
+		joined += rejoinGroup(m.conn6.JoinGroup, m.conn6.LeaveGroup, group6, m.interfaces, interfaces)
+	}
+	m.interfaces = interfaces
+
+	if joined == 0 {
+		fmt.Println("Aviso: descoberta multicast sem interfaces após a mudança de rede")
+	}
+}
+
+// Announce envia o anúncio atual para os grupos multicast em todas as interfaces
+func (m *MulticastDiscovery) Announce() error {
+	data, err := m.announce()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	group4 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV4), Port: m.port}
+	group6 := &net.UDPAddr{IP: net.ParseIP(DefaultMulticastGroupV6), Port: m.port}
+
+	sent := 0
+	for i := range m.interfaces {
+		ifi := &m.interfaces[i]
+
+		if m.conn4 != nil && m.conn4.SetMulticastInterface(ifi) == nil {
+			if _, err := m.conn4.WriteTo(data, nil, group4); err == nil {
+				sent++
+			}
+		}
+		if m.conn6 != nil && m.conn6.SetMulticastInterface(ifi) == nil {
+			if _, err := m.conn6.WriteTo(data, nil, group6); err == nil {
+				sent++
+			}
+		}
+	}
+
+	if sent == 0 {
+		return fmt.Errorf("falha ao enviar anúncio multicast em todas as interfaces")
+	}
+
+	return nil
+}
+
+// announceLoop envia anúncios multicast periódicos
+func (m *MulticastDiscovery) announceLoop() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	// Fazer um anúncio inicial
+	if err := m.Announce(); err != nil {
+		fmt.Printf("Erro ao enviar anúncio multicast: %v\n", err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Announce(); err != nil {
+				fmt.Printf("Erro ao enviar anúncio multicast: %v\n", err)
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// receiveLoop lê anúncios de um dos grupos multicast
+func (m *MulticastDiscovery) receiveLoop(setDeadline func(time.Time) error, read func([]byte) (int, net.Addr, error)) {
+	buffer := make([]byte, MaxMessageSize)
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		default:
+			// Configurar timeout para não bloquear indefinidamente
+			setDeadline(time.Now().Add(1 * time.Second))
+
+			n, src, err := read(buffer)
+			if err != nil {
+				// Ignorar erros de timeout
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					continue
+				}
+
+				select {
+				case <-m.stopChan:
+					return
+				default:
+				}
+
+				fmt.Printf("Erro ao ler mensagem multicast: %v\n", err)
+				continue
+			}
+
+			addr, ok := src.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			data := make([]byte, n)
+			copy(data, buffer[:n])
+
+			msg, ok := m.accept(data, addr)
+			if !ok || msg.Type != MsgAnnouncement {
+				continue
+			}
+			publishResult(m.results, DiscoveryResult{Backend: BackendMulticast, Message: msg, Addr: addr}, m.stopChan)
+		}
+	}
+}
+
+// rejoinGroup sai do grupo nas interfaces anteriores e entra nas atuais, renovando também a
+// inscrição das interfaces que continuam, que o sistema pode ter descartado quando a interface
+// caiu. Retorna em quantas interfaces o socket entrou no grupo.
+func rejoinGroup(join, leave func(*net.Interface, net.Addr) error, group net.Addr, previous, current []net.Interface) int {
+	for i := range previous {
+		leave(&previous[i], group)
+	}
+
+	joined := 0
+	for i := range current {
+		if err := join(&current[i], group); err == nil {
+			joined++
+		}
+	}
+	return joined
+}
+
+// multicastInterfaces lista as interfaces ativas com suporte a multicast, exceto a da VPN
+func multicastInterfaces(vpnInterface string) []net.Interface {
+	var result []net.Interface
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return result
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if iface.Flags&net.FlagLoopback != 0 || iface.Name == vpnInterface {
+			continue
+		}
+		result = append(result, iface)
+	}
+
+	return result
+}
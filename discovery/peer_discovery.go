@@ -1,14 +1,25 @@
 package discovery
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
 )
 
+// DefaultWireGuardPort é a porta WireGuard anunciada quando nenhuma outra é configurada
+const DefaultWireGuardPort = 51820
+
 // PeerDiscovery gerencia a descoberta de peers na rede
 type PeerDiscovery struct {
 	config      *core.Config
@@ -19,9 +30,44 @@ type PeerDiscovery struct {
 	nodeID      string
 	publicKey   string
 	virtualIP   string
+	wgPort      int
+	
+	// Chave usada para assinar os anúncios e proteção contra replay
+	signingKey  ed25519.PrivateKey
+	replayGuard *ReplayGuard
 	
 	// Para comunicação via UDP
-	udpConn     *net.UDPConn
+	udpConn     net.PacketConn
+	receiving   sync.WaitGroup // Goroutine de recebimento, aguardada por Stop antes de soltar o socket
+	listen      nattraversal.AddrListener
+	
+	// Intervalo dos anúncios unicast, dos registros de rendezvous e dos resumos de peers
+	announceInterval time.Duration
+	
+	// Backends de descoberta em execução e os embutidos que recebem pelo socket de descoberta
+	backends    *BackendManager
+	extra       []DiscoveryBackend
+	static      *staticBackend
+	rendezvous  *rendezvousBackend
+	
+	// DHT Kademlia sobre o socket de descoberta (opcional)
+	dht         *DHT
+	
+	// Convites emitidos e arquivo de configuração para persistir novos peers
+	invites     *InviteStore
+	configPath  string
+	
+	// Fila de aprovação de nós desconhecidos (nil configura qualquer nó)
+	pending     *PendingStore
+	
+	// Política de confiança aplicada antes de configurar peers (nil aceita qualquer nó)
+	policy      *TrustPolicy
+	
+	// Publicação do registro DNS (nil usa a atualização dinâmica da configuração, se houver)
+	dnsUpdater  DNSUpdater
+	
+	// NAT traversal que fornece endpoints públicos candidatos (ex: mapeamento UPnP)
+	nat         *nattraversal.NATTraversal
 	
 	// Controle de estado
 	running     bool
@@ -30,16 +76,25 @@ type PeerDiscovery struct {
 	
 	// Cache de nós conhecidos
 	knownNodes  map[string]*PeerInfo
+	failedPaths map[string]bool // Peers cuja última tentativa de caminho falhou
 	nodesMutex  sync.RWMutex
 }
 
 // PeerInfo armazena informações sobre um peer descoberto
 type PeerInfo struct {
-	NodeID      string
-	PublicKey   string
-	VirtualIP   string
-	Endpoints   []string
-	LastSeen    time.Time
+	NodeID        string
+	PublicKey     string
+	VirtualIP     string
+	Endpoints     []string     // Endpoints WireGuard candidatos
+	Candidates    []nattraversal.Candidate // Candidatos com tipo e prioridade, da maior para a menor
+	DiscoveryAddr string       // Endereço de descoberta de onde o último anúncio chegou
+	SigningKey    string       // Chave Ed25519 que assina os anúncios do peer
+	Capabilities  Capability
+	Relay         string                     // Relay entre peers operado pelo peer
+	HomeRelay     nattraversal.PeerRelayInfo // Relay em que o peer recebe tráfego
+	LastSeen      time.Time
+	LearnedFrom   string       // Nó que repassou este peer via PEX (vazio se o contato foi direto)
+	Sources       []string     // Backends que encontraram este peer
 }
 
 // NewPeerDiscovery cria uma nova instância do sistema de descoberta
@@ -47,21 +102,99 @@ func NewPeerDiscovery(config *core.Config, listenPort int, vpnCore core.VPNProvi
 	// Obter informações do nó local do VPNCore
 	nodeID, publicKey, virtualIP := vpnCore.GetNodeInfo()
 	
+	// Derivar a chave de assinatura dos anúncios
+	signingKey, err := config.SigningKey()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter chave de assinatura: %w", err)
+	}
+	
 	discovery := &PeerDiscovery{
-		config:     config,
-		vpnCore:    vpnCore,
-		listenPort: listenPort,
-		nodeID:     nodeID,
-		publicKey:  publicKey,
-		virtualIP:  virtualIP,
-		running:    false,
-		stopChan:   make(chan struct{}),
-		knownNodes: make(map[string]*PeerInfo),
+		config:      config,
+		vpnCore:     vpnCore,
+		listenPort:  listenPort,
+		nodeID:      nodeID,
+		publicKey:   publicKey,
+		virtualIP:   virtualIP,
+		wgPort:      DefaultWireGuardPort,
+		signingKey:  signingKey,
+		replayGuard: NewReplayGuard(MaxClockSkew),
+		listen:      nattraversal.ListenUDPAddr,
+		announceInterval: DefaultAnnounceInterval,
+		running:     false,
+		stopChan:    make(chan struct{}),
+		knownNodes:  make(map[string]*PeerInfo),
+		failedPaths: make(map[string]bool),
 	}
 	
 	return discovery, nil
 }
 
+// SetWireGuardPort define a porta WireGuard anunciada aos outros nós
+func (p *PeerDiscovery) SetWireGuardPort(port int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.wgPort = port
+}
+
+// SetListener define como o socket de descoberta é aberto, por exemplo em uma rede simulada.
+// Deve ser chamado antes de Start.
+func (p *PeerDiscovery) SetListener(listen nattraversal.AddrListener) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.listen = listen
+}
+
+// SetAnnounceInterval define o intervalo dos anúncios periódicos, dos registros nos servidores
+// de rendezvous e dos resumos de peers (PEX). Deve ser chamado antes de Start.
+func (p *PeerDiscovery) SetAnnounceInterval(interval time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.announceInterval = interval
+}
+
+// SetNATTraversal define o NAT traversal cujos endpoints públicos candidatos entram nos
+// anúncios; a descoberta também passa a combinar com os peers as rodadas de hole punching
+func (p *PeerDiscovery) SetNATTraversal(nat *nattraversal.NATTraversal) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.nat = nat
+	if nat != nil {
+		nat.SetPunchSignaler(p)
+	}
+}
+
+// SetTrustPolicy define a política de confiança aplicada aos nós descobertos
+func (p *PeerDiscovery) SetTrustPolicy(policy *TrustPolicy) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.policy = policy
+}
+
+// AddBackend registra um backend de descoberta adicional. Deve ser chamado antes de Start.
+func (p *PeerDiscovery) AddBackend(backend DiscoveryBackend) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	
+	if p.running {
+		return fmt.Errorf("não é possível adicionar backends com a descoberta em execução")
+	}
+	for _, existing := range p.extra {
+		if existing.Name() == backend.Name() {
+			return fmt.Errorf("backend de descoberta duplicado: %s", backend.Name())
+		}
+	}
+	
+	p.extra = append(p.extra, backend)
+	return nil
+}
+
+// trustPolicy retorna a política de confiança em uso
+func (p *PeerDiscovery) trustPolicy() *TrustPolicy {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.policy
+}
+
 // Start inicia o serviço de descoberta
 func (p *PeerDiscovery) Start() error {
 	p.mutex.Lock()
@@ -71,24 +204,60 @@ func (p *PeerDiscovery) Start() error {
 		return fmt.Errorf("o serviço de descoberta já está em execução")
 	}
 	
+	backendNames, err := EnabledBackends(p.config.Discovery)
+	if err != nil {
+		return err
+	}
+	
 	// Iniciar o listener UDP
 	addr := &net.UDPAddr{
 		IP:   net.IPv4zero,
 		Port: p.listenPort,
 	}
 	
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := p.listen(addr)
 	if err != nil {
 		return fmt.Errorf("erro ao abrir porta UDP para descoberta: %w", err)
 	}
 	
 	p.udpConn = conn
 	p.running = true
+	p.warnUnpinnedPeers()
+	
+	// Restaurar os nós conhecidos para reencontrá-los sem esperar que anunciem primeiro
+	var restored []PeerInfo
+	if p.configPath != "" {
+		restored, err = p.loadState(DiscoveryStatePath(p.configPath))
+		if err != nil {
+			fmt.Printf("Aviso: estado da descoberta ignorado: %v\n", err)
+		}
+	}
+	
+	// Todos os backends rodam em paralelo e entregam os peers encontrados a handleResult
+	p.backends = NewBackendManager(p.handleResult)
+	for _, name := range backendNames {
+		backend, err := p.newBackend(name)
+		if err != nil {
+			fmt.Printf("Aviso: backend de descoberta %s desativado: %v\n", name, err)
+			continue
+		}
+		p.backends.Add(backend)
+	}
+	for _, backend := range p.extra {
+		if err := p.backends.Add(backend); err != nil {
+			fmt.Printf("Aviso: %v\n", err)
+		}
+	}
 	
-	// Iniciar goroutines para recebimento de mensagens e anúncios periódicos
-	go p.receiveMessages()
-	go p.announceRoutine()
+	// Iniciar goroutines para recebimento de mensagens e tarefas periódicas
+	p.receiving.Add(1)
+	go p.receiveMessages(conn)
+	p.backends.Start()
+	go p.exchangeRoutine()
 	go p.maintenanceRoutine()
+	if len(restored) > 0 {
+		go p.reannounce(restored)
+	}
 	
 	return nil
 }
@@ -96,26 +265,177 @@ func (p *PeerDiscovery) Start() error {
 // Stop para o serviço de descoberta
 func (p *PeerDiscovery) Stop() error {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
 	
 	if !p.running {
+		p.mutex.Unlock()
 		return nil // Já está parado
 	}
 	
 	// Sinalizar para as goroutines pararem
 	close(p.stopChan)
 	
-	// Fechar a conexão UDP
+	p.backends.Stop()
+	
+	// Fechar a conexão UDP, o que desbloqueia a leitura em andamento
 	if p.udpConn != nil {
 		p.udpConn.Close()
-		p.udpConn = nil
 	}
 	
 	p.running = false
+	p.mutex.Unlock()
+	
+	// O processamento de uma mensagem pode travar o mutex, então a espera acontece sem ele
+	p.receiving.Wait()
+	
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.udpConn = nil
+	
+	// Gravar os pedidos de aprovação recebidos desde a última gravação
+	if p.pending != nil {
+		if err := p.pending.Flush(); err != nil {
+			fmt.Printf("Erro ao gravar peers pendentes: %v\n", err)
+		}
+	}
+	
+	// Salvar os nós conhecidos para o próximo início
+	if p.configPath != "" {
+		if err := p.saveState(DiscoveryStatePath(p.configPath)); err != nil {
+			fmt.Printf("Erro ao salvar estado da descoberta: %v\n", err)
+		}
+	}
 	
 	return nil
 }
 
+// newBackend cria um dos backends embutidos
+func (p *PeerDiscovery) newBackend(name string) (DiscoveryBackend, error) {
+	switch name {
+	case BackendStatic:
+		p.static = newStaticBackend(p)
+		return p.static, nil
+	case BackendMulticast:
+		return NewMulticastDiscovery(p.config.Discovery.MulticastPort, p.interfaceName(),
+			p.buildAnnouncement, p.acceptPacket), nil
+	case BackendRendezvous:
+		p.rendezvous = newRendezvousBackend(p)
+		return p.rendezvous, nil
+	case BackendDHT:
+		dht, err := NewDHT(p.config.PrivateKey, p.signingKey, p.sendPacket)
+		if err != nil {
+			return nil, err
+		}
+		p.dht = dht
+		return newDHTBackend(p), nil
+	case BackendDNS:
+		updater, err := p.configDNSUpdater()
+		if err != nil {
+			return nil, err
+		}
+		return newDNSBackend(p, p.config.Discovery.DNSDomain, p.config.Discovery.DNSServer, updater), nil
+	}
+	return nil, fmt.Errorf("backend de descoberta desconhecido: %s", name)
+}
+
+// configDNSUpdater retorna o DNSUpdater definido por SetDNSUpdater ou, na falta dele,
+// a atualização dinâmica da configuração
+func (p *PeerDiscovery) configDNSUpdater() (DNSUpdater, error) {
+	if p.dnsUpdater != nil {
+		return p.dnsUpdater, nil
+	}
+	
+	update := p.config.Discovery.DNSUpdate
+	if update.Server == "" {
+		return nil, nil
+	}
+	
+	zone := update.Zone
+	if zone == "" {
+		zone = p.config.Discovery.DNSDomain
+	}
+	
+	var key *TSIGKey
+	if update.TSIGKeyName != "" {
+		secret, err := base64.StdEncoding.DecodeString(update.TSIGSecret)
+		if err != nil {
+			return nil, fmt.Errorf("segredo TSIG inválido: %w", err)
+		}
+		key = &TSIGKey{Name: update.TSIGKeyName, Algorithm: update.TSIGAlgorithm, Secret: secret}
+	}
+	
+	return NewRFC2136Updater(update.Server, zone, key), nil
+}
+
+// Announce anuncia o nó imediatamente em todos os backends, sem esperar os ciclos periódicos
+func (p *PeerDiscovery) Announce() {
+	p.mutex.Lock()
+	backends := p.backends
+	p.mutex.Unlock()
+	
+	if backends != nil {
+		backends.Announce()
+	}
+}
+
+// HandleNetworkChange reage a uma mudança na rede local (ex: troca de Wi-Fi para tethering):
+// redetecta o endpoint público, ajusta os backends às novas interfaces, anuncia o nó em todos
+// eles e refaz em paralelo o caminho até os peers conhecidos, que pode passar a exigir o relay.
+// nat pode ser nil para apenas anunciar.
+func (p *PeerDiscovery) HandleNetworkChange(nat *nattraversal.NATTraversal) {
+	if nat != nil {
+		nat.Refresh()
+	}
+	
+	p.mutex.Lock()
+	backends := p.backends
+	p.mutex.Unlock()
+	if backends != nil {
+		backends.HandleNetworkChange()
+	}
+	
+	p.Announce()
+	
+	if nat != nil {
+		for _, peer := range p.Peers() {
+			if len(peer.Candidates) > 0 {
+				go p.connectPeer(nat, peer.NodeID, peer.Candidates)
+			}
+		}
+	}
+}
+
+// Backends lista os nomes dos backends de descoberta em execução
+func (p *PeerDiscovery) Backends() []string {
+	p.mutex.Lock()
+	backends := p.backends
+	p.mutex.Unlock()
+	
+	var names []string
+	if backends != nil {
+		for _, backend := range backends.Active() {
+			names = append(names, backend.Name())
+		}
+	}
+	return names
+}
+
+// Peers retorna a visão consolidada dos nós conhecidos, com os backends que encontraram cada um
+func (p *PeerDiscovery) Peers() []PeerInfo {
+	p.nodesMutex.RLock()
+	defer p.nodesMutex.RUnlock()
+	
+	peers := make([]PeerInfo, 0, len(p.knownNodes))
+	for _, peer := range p.knownNodes {
+		copied := *peer
+		copied.Endpoints = append([]string(nil), peer.Endpoints...)
+		copied.Candidates = append([]nattraversal.Candidate(nil), peer.Candidates...)
+		copied.Sources = append([]string(nil), peer.Sources...)
+		peers = append(peers, copied)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].NodeID < peers[j].NodeID })
+	return peers
+}
+
 // IsRunning verifica se o serviço está em execução
 func (p *PeerDiscovery) IsRunning() bool {
 	p.mutex.Lock()
@@ -123,8 +443,9 @@ func (p *PeerDiscovery) IsRunning() bool {
 	return p.running
 }
 
-// receiveMessages processa mensagens recebidas via UDP
-func (p *PeerDiscovery) receiveMessages() {
+// receiveMessages processa mensagens recebidas via UDP no socket aberto por Start
+func (p *PeerDiscovery) receiveMessages(conn net.PacketConn) {
+	defer p.receiving.Done()
 	buffer := make([]byte, 2048)
 	
 	for {
@@ -133,142 +454,699 @@ func (p *PeerDiscovery) receiveMessages() {
 			return
 		default:
 			// Configurar timeout para não bloquear indefinidamente
-			p.udpConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 			
-			n, addr, err := p.udpConn.ReadFromUDP(buffer)
+			n, from, err := conn.ReadFrom(buffer)
 			if err != nil {
 				// Ignorar erros de timeout
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue
 				}
 				
+				// O socket é fechado por Stop
+				select {
+				case <-p.stopChan:
+					return
+				default:
+				}
+				
 				fmt.Printf("Erro ao ler mensagem UDP: %v\n", err)
 				continue
 			}
 			
-			// Processar a mensagem recebida
-			p.handleMessage(buffer[:n], addr)
+			addr, ok := from.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			
+			// As mensagens seguem para os backends depois da próxima leitura do buffer
+			data := make([]byte, n)
+			copy(data, buffer[:n])
+			p.handleMessage(data, addr)
+		}
+	}
+}
+
+// acceptPacket verifica a origem, a assinatura e o replay de um pacote recebido.
+// Retorna a mensagem decodificada se ela deve ser processada.
+func (p *PeerDiscovery) acceptPacket(data []byte, addr *net.UDPAddr) (*SignedMessage, bool) {
+	// Servidores de rendezvous configurados são aceitos mesmo fora dos IPs confiáveis
+	if !p.trustPolicy().AllowSource(addr.IP) && !p.isRendezvousServer(addr) {
+		fmt.Printf("Mensagem de descoberta de %s descartada: origem fora dos IPs confiáveis\n", addr.String())
+		return nil, false
+	}
+	
+	msg, err := DecodeMessage(data)
+	if err != nil {
+		fmt.Printf("Mensagem de descoberta rejeitada de %s: %v\n", addr.String(), err)
+		return nil, false
+	}
+	
+	// Ignorar nossas próprias mensagens (ex: recebidas de volta por broadcast)
+	if msg.SignerKey.Equal(p.signingKey.Public()) {
+		return nil, false
+	}
+	
+	if err := p.replayGuard.Check(msg); err != nil {
+		// Cópias do mesmo anúncio chegam por várias interfaces/grupos multicast
+		if errors.Is(err, ErrReplayedMessage) {
+			return nil, false
 		}
+		fmt.Printf("Mensagem de descoberta rejeitada de %s: %v\n", addr.String(), err)
+		return nil, false
 	}
+	
+	return msg, true
 }
 
-// handleMessage processa uma mensagem recebida do serviço de descoberta
+// handleMessage processa uma mensagem recebida no socket de descoberta
 func (p *PeerDiscovery) handleMessage(data []byte, addr *net.UDPAddr) {
-	// Aqui implementaríamos a análise da mensagem e o processamento
-	// Por enquanto, apenas simulamos o processamento
+	msg, ok := p.acceptPacket(data, addr)
+	if !ok {
+		return
+	}
+	
+	switch msg.Type {
+	case MsgAnnouncement:
+		if p.static != nil {
+			p.static.deliver(msg, addr)
+		}
+	case MsgRendezvousPeer:
+		if p.rendezvous == nil {
+			fmt.Printf("Apresentação de peer ignorada de %s: backend de rendezvous desativado\n", addr.String())
+			return
+		}
+		p.rendezvous.deliver(msg, addr)
+	case MsgPeerExchange:
+		p.handlePeerExchange(msg, addr)
+	case MsgDHTFindNode, MsgDHTFindValue, MsgDHTStore, MsgDHTNodes, MsgDHTValue:
+		if p.dht != nil {
+			p.dht.HandleMessage(msg, addr)
+		}
+	case MsgJoinRequest:
+		p.handleJoinRequest(msg, addr)
+	case MsgPunch:
+		p.handlePunch(msg, addr)
+	default:
+		fmt.Printf("Tipo de mensagem de descoberta desconhecido de %s: %s\n", addr.String(), msg.Type)
+	}
+}
+
+// handleResult valida e registra um peer encontrado por um dos backends
+func (p *PeerDiscovery) handleResult(result DiscoveryResult) {
+	info, err := p.peerFromResult(result)
+	if err != nil {
+		fmt.Printf("Anúncio de %s rejeitado (%s): %v\n", result.Addr, result.Backend, err)
+		return
+	}
+	
+	fmt.Printf("Recebido anúncio do nó %s via %s (%s)\n", info.NodeID, result.Backend, info.DiscoveryAddr)
+	
+	p.updatePeerInfo(info)
+}
+
+// peerFromResult monta as informações do peer a partir do anúncio entregue por um backend
+func (p *PeerDiscovery) peerFromResult(result DiscoveryResult) (*PeerInfo, error) {
+	if result.Message == nil || result.Addr == nil {
+		return nil, fmt.Errorf("%w: resultado sem anúncio ou endereço", ErrInvalidMessage)
+	}
+	
+	info, err := p.peerFromAnnouncement(result.Message, result.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if result.DiscoveryAddr != "" {
+		info.DiscoveryAddr = result.DiscoveryAddr
+	}
+	if result.Backend != "" {
+		info.Sources = []string{result.Backend}
+	}
+	
+	return info, nil
+}
+
+// peerFromAnnouncement valida um anúncio assinado e monta as informações do peer.
+// addr é o endereço de onde o anúncio foi observado.
+func (p *PeerDiscovery) peerFromAnnouncement(msg *SignedMessage, addr *net.UDPAddr) (*PeerInfo, error) {
+	var announcement Announcement
+	if err := msg.Decode(&announcement); err != nil {
+		return nil, err
+	}
+	
+	if announcement.NodeID == "" || announcement.PublicKey == "" || announcement.VirtualIP == "" {
+		return nil, fmt.Errorf("%w: anúncio incompleto", ErrInvalidMessage)
+	}
+	
+	// A chave de assinatura precisa ser a mesma já associada a esta chave WireGuard
+	if err := p.checkSigningKey(announcement.NodeID, announcement.PublicKey, msg.Signer()); err != nil {
+		if errors.Is(err, ErrUnpinnedSigningKey) {
+			p.queueUnpinned(announcement, msg.Signer(), addr)
+		}
+		return nil, fmt.Errorf("nó %s: %w", announcement.NodeID, err)
+	}
+	
+	// O endereço observado é o candidato mais confiável: foi de lá que o pacote veio
+	endpoints := make([]string, 0, len(announcement.Endpoints)+1)
+	if announcement.ListenPort > 0 {
+		endpoints = append(endpoints, net.JoinHostPort(addr.IP.String(), strconv.Itoa(announcement.ListenPort)))
+	}
+	for _, endpoint := range announcement.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			continue
+		}
+		endpoints = appendUnique(endpoints, endpoint)
+	}
+	
+	// Candidatos com tipo e prioridade ou, de nós que não os anunciam, deduzidos dos
+	// endpoints. O endereço observado entra como peer-reflexivo, se ainda não estiver entre eles.
+	candidates := recordCandidates(announcement.Candidates)
+	if len(candidates) == 0 {
+		candidates = nattraversal.CandidatesFromEndpoints(announcement.Endpoints)
+	}
+	if announcement.ListenPort > 0 && !slices.ContainsFunc(candidates, func(c nattraversal.Candidate) bool {
+		return c.Address == endpoints[0]
+	}) {
+		candidates = append(candidates, nattraversal.Candidate{
+			Type:     nattraversal.CandidatePeerReflexive,
+			Address:  endpoints[0],
+			Priority: nattraversal.CandidatePriority(nattraversal.CandidatePeerReflexive, 65535),
+		})
+	}
+	nattraversal.SortCandidates(candidates)
+	
+	// Anúncios multicast saem da porta do grupo; respostas unicast vão para a porta de descoberta
+	discoveryAddr := addr.String()
+	if announcement.DiscoveryPort > 0 {
+		discoveryAddr = net.JoinHostPort(addr.IP.String(), strconv.Itoa(announcement.DiscoveryPort))
+	}
+	
+	return &PeerInfo{
+		NodeID:        announcement.NodeID,
+		PublicKey:     announcement.PublicKey,
+		VirtualIP:     announcement.VirtualIP,
+		Endpoints:     endpoints,
+		Candidates:    candidates,
+		DiscoveryAddr: discoveryAddr,
+		SigningKey:    msg.Signer(),
+		Capabilities:  announcement.Capabilities,
+		Relay:         announcement.Relay,
+		HomeRelay: nattraversal.PeerRelayInfo{
+			Address:   announcement.HomeRelay,
+			PublicKey: announcement.HomeRelayKey,
+		},
+	}, nil
+}
+
+// announcedDiscoveryAddr deduz o endereço de descoberta de um anúncio a partir do primeiro
+// endpoint anunciado, para registros que não vieram diretamente do nó
+func announcedDiscoveryAddr(msg *SignedMessage) (*net.UDPAddr, error) {
+	var announcement Announcement
+	if err := msg.Decode(&announcement); err != nil {
+		return nil, err
+	}
+	
+	for _, endpoint := range announcement.Endpoints {
+		if candidate, err := net.ResolveUDPAddr("udp", endpoint); err == nil {
+			candidate.Port = announcement.DiscoveryPort
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: registro sem endereço utilizável", ErrInvalidMessage)
+}
+
+// checkSigningKey garante que o NodeID e a chave WireGuard de um nó continuem assinados pela
+// mesma chave Ed25519. A chave de um peer confiável é fixada na configuração (peer add ou
+// convite) e nunca aprendida do primeiro anúncio: sem ela, os anúncios do peer são recusados.
+func (p *PeerDiscovery) checkSigningKey(nodeID, publicKey, signingKey string) error {
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.PublicKey != publicKey && peer.NodeID != nodeID {
+			continue
+		}
+		if peer.SigningKey == "" {
+			return fmt.Errorf("%w: peer confiável %s sem chave de assinatura registrada", ErrUnpinnedSigningKey, peer.NodeID)
+		}
+		if peer.SigningKey != signingKey {
+			return fmt.Errorf("chave de assinatura difere da registrada para o peer %s", peer.NodeID)
+		}
+	}
+	
+	p.nodesMutex.RLock()
+	defer p.nodesMutex.RUnlock()
+	
+	// Só conta a chave vista em anúncio direto; o que chegou via PEX não fixa chave alguma
+	for _, peer := range p.knownNodes {
+		if peer.LearnedFrom != "" || peer.SigningKey == "" {
+			continue
+		}
+		if (peer.PublicKey == publicKey || peer.NodeID == nodeID) && peer.SigningKey != signingKey {
+			return fmt.Errorf("chave de assinatura difere da já conhecida para o nó %s", peer.NodeID)
+		}
+	}
+	
+	return nil
+}
+
+// queueUnpinned coloca na fila de aprovação o anúncio de um peer confiável configurado sem
+// chave de assinatura, para que o administrador confira a impressão digital e fixe a chave com
+// 'peer pin'. O anúncio continua recusado até lá.
+func (p *PeerDiscovery) queueUnpinned(announcement Announcement, signingKey string, addr *net.UDPAddr) {
+	store := p.pendingStore()
+	if store == nil || store.IsBlocked(announcement.PublicKey, signingKey) {
+		return
+	}
+	
+	isNew, err := store.Add(PendingPeer{
+		NodeID:     announcement.NodeID,
+		PublicKey:  announcement.PublicKey,
+		VirtualIP:  announcement.VirtualIP,
+		Endpoints:  announcement.Endpoints,
+		SourceAddr: addr.String(),
+		SigningKey: signingKey,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao registrar peer pendente %s: %v\n", announcement.NodeID, err)
+		return
+	}
+	if isNew {
+		fingerprint := Fingerprint(announcement.PublicKey, signingKey)
+		fmt.Printf("Peer confiável %s anunciou a impressão digital %s, mas não tem chave de assinatura registrada.\n",
+			announcement.NodeID, fingerprint)
+		fmt.Printf("Confira-a com o dono do nó ('peer identity') e fixe a chave com 'p2p-vpn peer pin %s'.\n", fingerprint)
+	}
+}
+
+// warnUnpinnedPeers avisa na inicialização sobre os peers confiáveis sem chave de assinatura,
+// configurados antes de a chave ser exigida: os anúncios deles são recusados até a fixação
+func (p *PeerDiscovery) warnUnpinnedPeers() {
+	var unpinned []string
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.SigningKey == "" {
+			unpinned = append(unpinned, peer.NodeID)
+		}
+	}
+	if len(unpinned) == 0 {
+		return
+	}
+	
+	fmt.Printf("AVISO: %d peer(s) confiável(is) sem chave de assinatura registrada: %s\n", len(unpinned), strings.Join(unpinned, ", "))
+	fmt.Println("AVISO: os anúncios de descoberta desses peers serão recusados e ficarão em 'p2p-vpn peer pending'.")
+	fmt.Println("AVISO: confira a impressão digital com o dono de cada nó ('peer identity') e fixe a chave com")
+	fmt.Println("AVISO: 'p2p-vpn peer pin <impressão digital>', ou readicione o peer com 'peer add --signing-key'.")
+}
+
+// updatePeerInfo atualiza as informações de um peer conhecido, se a fila de aprovação
+// e a política de confiança permitirem
+func (p *PeerDiscovery) updatePeerInfo(info *PeerInfo) {
+	approved, ok := p.admitPeer(info)
+	if !ok {
+		return
+	}
+	
+	// A aprovação do administrador conta como configuração do peer para a política
+	trusted := p.vpnCore.TrustedPeers()
+	if approved != nil {
+		trusted = append(trusted, approved.TrustedPeer())
+	}
+	
+	if err := p.trustPolicy().Authorize(info, trusted); err != nil {
+		fmt.Printf("Peer %s rejeitado pela política de confiança: %v\n", info.NodeID, err)
+		return
+	}
 	
-	// Simular extração de informações da mensagem
-	receivedNodeID := "node-simulated"
-	receivedPublicKey := "key-simulated"
-	receivedVirtualIP := "10.0.0.123"
+	if err := p.applyPeerInfo(info); err != nil {
+		fmt.Printf("Erro ao atualizar peer %s no VPN: %v\n", info.NodeID, err)
+		return
+	}
 	
-	fmt.Printf("Recebida mensagem de descoberta do nó %s (%s)\n", receivedNodeID, addr.String())
+	// A aprovação só é consumida com o peer configurado; se a política ou o WireGuard o
+	// recusarem, ela continua valendo para o próximo anúncio
+	if approved != nil {
+		if err := p.pendingStore().ConsumeApproved(approved.PublicKey, approved.SigningKey); err != nil {
+			fmt.Printf("Erro ao atualizar peers aprovados: %v\n", err)
+		}
+		p.persistConfig()
+	}
+}
+
+// persistConfig grava a configuração com os peers adicionados em execução
+func (p *PeerDiscovery) persistConfig() {
+	p.mutex.Lock()
+	configPath := p.configPath
+	p.mutex.Unlock()
 	
-	// Atualizar a informação do peer
-	p.updatePeerInfo(receivedNodeID, receivedPublicKey, receivedVirtualIP, addr.String())
+	if configPath == "" {
+		return
+	}
+	if err := p.vpnCore.SaveConfig(configPath); err != nil {
+		fmt.Printf("Erro ao salvar configuração: %v\n", err)
+	}
 }
 
-// updatePeerInfo atualiza as informações de um peer conhecido
-func (p *PeerDiscovery) updatePeerInfo(nodeID, publicKey, virtualIP, endpoint string) {
+// applyPeerInfo registra o peer e atualiza seu endpoint no VPN sem consultar a política.
+// Retorna o erro do VPN ao configurar o peer.
+func (p *PeerDiscovery) applyPeerInfo(info *PeerInfo) error {
 	p.nodesMutex.Lock()
-	defer p.nodesMutex.Unlock()
 	
 	// Verificar se o nó já é conhecido
-	peer, exists := p.knownNodes[nodeID]
+	peer, exists := p.knownNodes[info.NodeID]
 	if !exists {
 		// Novo nó descoberto
 		peer = &PeerInfo{
-			NodeID:    nodeID,
-			PublicKey: publicKey,
-			VirtualIP: virtualIP,
-			Endpoints: []string{endpoint},
-			LastSeen:  time.Now(),
-		}
-		p.knownNodes[nodeID] = peer
-		
-		fmt.Printf("Novo peer descoberto: %s (%s)\n", nodeID, endpoint)
-	} else {
-		// Atualizar informações do nó existente
-		peer.LastSeen = time.Now()
-		
-		// Verificar se o endpoint já existe
-		endpointExists := false
-		for _, ep := range peer.Endpoints {
-			if ep == endpoint {
-				endpointExists = true
-				break
-			}
+			NodeID:     info.NodeID,
+			SigningKey: info.SigningKey,
 		}
+		p.knownNodes[info.NodeID] = peer
 		
-		// Adicionar novo endpoint se necessário
-		if !endpointExists {
-			peer.Endpoints = append(peer.Endpoints, endpoint)
+		fmt.Printf("Novo peer descoberto: %s (%s)\n", info.NodeID, info.DiscoveryAddr)
+	}
+	
+	// Endpoints, candidatos ou relay principal novos pedem um novo caminho até o peer, e cada
+	// anúncio é uma nova tentativa quando a anterior falhou (ex: sondas perdidas na rede)
+	endpointsChanged := !exists || !slices.Equal(peer.Endpoints, info.Endpoints) ||
+		!slices.Equal(peer.Candidates, info.Candidates) || peer.HomeRelay != info.HomeRelay ||
+		p.failedPaths[info.NodeID]
+	delete(p.failedPaths, info.NodeID)
+	
+	// Atualizar informações do nó
+	peer.PublicKey = info.PublicKey
+	peer.VirtualIP = info.VirtualIP
+	peer.Endpoints = info.Endpoints
+	peer.Candidates = info.Candidates
+	peer.DiscoveryAddr = info.DiscoveryAddr
+	peer.Capabilities = info.Capabilities
+	peer.Relay = info.Relay
+	peer.HomeRelay = info.HomeRelay
+	peer.LastSeen = time.Now()
+	peer.LearnedFrom = ""
+	// Peers vindos do PEX não trazem chave; a do primeiro anúncio direto passa a valer
+	if peer.SigningKey == "" {
+		peer.SigningKey = info.SigningKey
+	}
+	for _, source := range info.Sources {
+		peer.Sources = appendUnique(peer.Sources, source)
+	}
+	
+	p.nodesMutex.Unlock()
+	
+	p.mutex.Lock()
+	nat := p.nat
+	p.mutex.Unlock()
+	
+	// Responder de imediato a nós novos acelera a convergência da malha
+	if !exists && info.DiscoveryAddr != "" {
+		go p.greetPeer(info.NodeID, info.DiscoveryAddr)
+	}
+	
+	// Nós com contato direto também servem de contatos do DHT
+	if p.dht != nil && info.DiscoveryAddr != "" {
+		if addr, err := net.ResolveUDPAddr("udp", info.DiscoveryAddr); err == nil {
+			p.dht.AddContact(info.PublicKey, addr)
 		}
 	}
 	
-	// Atualizar o endpoint no VPNCore para configuração do WireGuard
+	// Atualizar o endpoint no VPNCore para configuração do WireGuard,
+	// preservando os campos definidos manualmente para peers já configurados
 	trustedPeer := core.TrustedPeer{
-		NodeID:    nodeID,
-		PublicKey: publicKey,
-		VirtualIP: virtualIP,
-		Endpoints: []string{endpoint},
-		LastSeen:  time.Now().Unix(),
+		NodeID:     info.NodeID,
+		PublicKey:  info.PublicKey,
+		VirtualIP:  info.VirtualIP,
+		Endpoints:  info.Endpoints,
+		LastSeen:   time.Now().Unix(),
+		SigningKey: info.SigningKey,
+	}
+	for _, existing := range p.vpnCore.TrustedPeers() {
+		if existing.PublicKey == info.PublicKey {
+			trustedPeer.AllowedIPs = existing.AllowedIPs
+			trustedPeer.KeepAlive = existing.KeepAlive
+			break
+		}
+	}
+	
+	if err := p.vpnCore.AddPeer(trustedPeer); err != nil {
+		return err
+	}
+	
+	// O relay que o peer opera é candidato a relay principal deste nó, e o relay em que ele
+	// recebe tráfego é o caminho de reserva até ele
+	if nat != nil {
+		if info.Relay != "" {
+			nat.AddRelayCandidates(nattraversal.PeerRelayInfo{Address: info.Relay, PublicKey: info.PublicKey})
+		}
+		nat.SetPeerRelay(info.NodeID, info.PublicKey, info.HomeRelay)
+	}
+	
+	// Escolher o caminho até o peer (direto ou relay) depois que ele existe no VPN
+	if endpointsChanged && nat != nil && len(info.Candidates) > 0 {
+		go p.connectPeer(nat, info.NodeID, info.Candidates)
 	}
 	
-	p.vpnCore.AddPeer(trustedPeer)
+	return nil
 }
 
-// announceRoutine envia anúncios periódicos para descoberta de peers
-func (p *PeerDiscovery) announceRoutine() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// connectPeer estabelece o caminho até o peer pelo NAT traversal, verificando os candidatos
+func (p *PeerDiscovery) connectPeer(nat *nattraversal.NATTraversal, nodeID string, candidates []nattraversal.Candidate) {
+	if err := nat.ConnectPeerCandidates(nodeID, candidates); err != nil {
+		fmt.Printf("Erro ao conectar ao peer %s: %v\n", nodeID, err)
+		
+		p.nodesMutex.Lock()
+		if _, known := p.knownNodes[nodeID]; known {
+			p.failedPaths[nodeID] = true
+		}
+		p.nodesMutex.Unlock()
+	}
+}
+
+// exchangeRoutine envia resumos de peers (PEX) periodicamente aos nós com contato direto
+func (p *PeerDiscovery) exchangeRoutine() {
+	p.mutex.Lock()
+	interval := p.announceInterval
+	p.mutex.Unlock()
 	
-	// Fazer um anúncio inicial
-	p.sendAnnouncement()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	
 	for {
 		select {
 		case <-ticker.C:
-			p.sendAnnouncement()
+			p.sendPeerExchange()
 		case <-p.stopChan:
 			return
 		}
 	}
 }
 
-// sendAnnouncement envia um anúncio para os peers conhecidos e para endereços de rendezvous
-func (p *PeerDiscovery) sendAnnouncement() {
+// sendAnnouncement envia um anúncio para os nós conhecidos e os peers configurados
+func (p *PeerDiscovery) sendAnnouncement() error {
 	p.mutex.Lock()
 	running := p.running
+	conn := p.udpConn
+	p.mutex.Unlock()
+	
+	if !running || conn == nil {
+		return nil
+	}
+	
+	data, err := p.buildAnnouncement()
+	if err != nil {
+		return fmt.Errorf("erro ao construir anúncio de descoberta: %w", err)
+	}
+	
+	// Enviar para os peers conhecidos para manter as conexões ativas
+	for _, target := range p.announceTargets() {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			fmt.Printf("Endereço de descoberta inválido %s: %v\n", target, err)
+			continue
+		}
+		
+		if _, err := conn.WriteTo(data, addr); err != nil {
+			fmt.Printf("Erro ao enviar anúncio para %s: %v\n", target, err)
+		}
+	}
+	
+	return nil
+}
+
+// sendAnnouncementTo envia o anúncio atual diretamente para um endereço
+func (p *PeerDiscovery) sendAnnouncementTo(addr *net.UDPAddr) {
+	p.mutex.Lock()
+	conn := p.udpConn
 	p.mutex.Unlock()
 	
-	if !running {
+	if conn == nil {
 		return
 	}
 	
-	// Aqui construiríamos e enviaríamos a mensagem de anúncio
-	// Por enquanto, apenas simulamos o envio
-	fmt.Println("Simulando envio de anúncio para descoberta de peers...")
+	data, err := p.buildAnnouncement()
+	if err != nil {
+		fmt.Printf("Erro ao construir anúncio de descoberta: %v\n", err)
+		return
+	}
 	
-	// Enviar para os rendezvous servers (seriam servidores STUN ou outro mecanismo)
-	// Isso permitiria a descoberta mesmo através de NATs
+	if _, err := conn.WriteTo(data, addr); err != nil {
+		fmt.Printf("Erro ao enviar anúncio para %s: %v\n", addr.String(), err)
+	}
+}
+
+// unreachableTrustedPeers lista as chaves dos peers confiáveis sem anúncio recente
+func (p *PeerDiscovery) unreachableTrustedPeers() []string {
+	var keys []string
 	
-	// Enviar para os peers conhecidos para manter as conexões ativas
 	p.nodesMutex.RLock()
 	defer p.nodesMutex.RUnlock()
 	
-	for nodeID, peer := range p.knownNodes {
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.PublicKey == "" || peer.PublicKey == p.publicKey {
+			continue
+		}
+		
+		recent := false
+		for _, known := range p.knownNodes {
+			if known.PublicKey == peer.PublicKey && time.Since(known.LastSeen) < RendezvousRegistrationTTL {
+				recent = true
+				break
+			}
+		}
+		if !recent {
+			keys = append(keys, peer.PublicKey)
+		}
+	}
+	
+	return keys
+}
+
+// rendezvousServers resolve os servidores de rendezvous configurados. Servidores sem a chave
+// pública de assinatura são ignorados: sem ela, as apresentações não podem ser verificadas.
+func (p *PeerDiscovery) rendezvousServers() []rendezvousServer {
+	var servers []rendezvousServer
+	
+	for _, entry := range p.config.Discovery.RendezvousServers {
+		server, key, err := ParseRendezvousServer(entry)
+		if err != nil {
+			fmt.Printf("Servidor de rendezvous ignorado: %v\n", err)
+			continue
+		}
+		
+		addr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			fmt.Printf("Servidor de rendezvous inválido %s: %v\n", server, err)
+			continue
+		}
+		servers = append(servers, rendezvousServer{addr: addr, key: key})
+	}
+	
+	return servers
+}
+
+// rendezvousServerKey retorna a chave de assinatura do servidor de rendezvous configurado
+// no endereço, ou nil se o endereço não for de um servidor de rendezvous
+func (p *PeerDiscovery) rendezvousServerKey(addr *net.UDPAddr) ed25519.PublicKey {
+	for _, server := range p.rendezvousServers() {
+		if server.addr.Port == addr.Port && server.addr.IP.Equal(addr.IP) {
+			return server.key
+		}
+	}
+	return nil
+}
+
+// isRendezvousServer verifica se um endereço pertence a um servidor de rendezvous configurado
+func (p *PeerDiscovery) isRendezvousServer(addr *net.UDPAddr) bool {
+	return p.rendezvousServerKey(addr) != nil
+}
+
+// buildAnnouncement cria o anúncio assinado deste nó
+func (p *PeerDiscovery) buildAnnouncement() ([]byte, error) {
+	return EncodeMessage(MsgAnnouncement, p.localAnnouncement(), p.signingKey)
+}
+
+// localAnnouncement descreve este nó para anúncios e registros de rendezvous
+func (p *PeerDiscovery) localAnnouncement() Announcement {
+	p.mutex.Lock()
+	wgPort := p.wgPort
+	nat := p.nat
+	p.mutex.Unlock()
+	
+	// Endpoints públicos primeiro: são os que funcionam fora da LAN
+	var endpoints []string
+	if nat != nil {
+		endpoints = nat.EndpointCandidates()
+	}
+	hosts := localEndpoints(wgPort, p.interfaceName())
+	endpoints = append(endpoints, hosts...)
+	
+	var caps Capability
+	for _, endpoint := range endpoints {
+		host, _, _ := net.SplitHostPort(endpoint)
+		if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+			caps |= CapabilityIPv4
+		} else {
+			caps |= CapabilityIPv6
+		}
+	}
+	
+	announcement := Announcement{
+		NodeID:        p.nodeID,
+		PublicKey:     p.publicKey,
+		VirtualIP:     p.virtualIP,
+		ListenPort:    wgPort,
+		DiscoveryPort: p.listenPort,
+		Endpoints:     endpoints,
+	}
+	
+	// Relay próprio, se alcançável de fora, o relay em que o nó recebe tráfego e os
+	// candidatos para as verificações de conectividade
+	if nat != nil {
+		announcement.Candidates = candidateRecords(nat.GatherCandidates(hosts))
+		if relay := nat.RelayEndpoint(); relay != "" {
+			announcement.Relay = relay
+			caps |= CapabilityRelay
+		}
+		if home, ok := nat.HomeRelay(); ok {
+			announcement.HomeRelay = home.Address
+			announcement.HomeRelayKey = home.PublicKey
+		}
+	}
+	announcement.Capabilities = caps
+	
+	return announcement
+}
+
+// interfaceName retorna o nome da interface WireGuard local
+func (p *PeerDiscovery) interfaceName() string {
+	if p.config.InterfaceName != "" {
+		return p.config.InterfaceName
+	}
+	return "wg0"
+}
+
+// announceTargets retorna os endereços de descoberta para onde os anúncios devem ir
+func (p *PeerDiscovery) announceTargets() []string {
+	var targets []string
+	
+	p.nodesMutex.RLock()
+	for _, peer := range p.knownNodes {
 		// Ignorar nós que não foram vistos recentemente
 		if time.Since(peer.LastSeen) > 1*time.Hour {
 			continue
 		}
-		
-		// Enviar para todos os endpoints conhecidos do peer
+		if peer.DiscoveryAddr != "" {
+			targets = appendUnique(targets, peer.DiscoveryAddr)
+		}
+	}
+	p.nodesMutex.RUnlock()
+	
+	// Peers configurados: assumir que o serviço de descoberta usa a mesma porta que a nossa
+	for _, peer := range p.vpnCore.TrustedPeers() {
 		for _, endpoint := range peer.Endpoints {
-			// Simular envio
-			fmt.Printf("Simulando envio de anúncio para o peer %s em %s\n", nodeID, endpoint)
+			host, _, err := net.SplitHostPort(endpoint)
+			if err != nil {
+				host = endpoint
+			}
+			targets = appendUnique(targets, net.JoinHostPort(host, strconv.Itoa(p.listenPort)))
 		}
 	}
+	
+	return targets
 }
 
 // maintenanceRoutine executa tarefas de manutenção periódicas
@@ -280,6 +1158,8 @@ func (p *PeerDiscovery) maintenanceRoutine() {
 		select {
 		case <-ticker.C:
 			p.cleanupStaleNodes()
+			p.replayGuard.Prune()
+			p.persistState()
 		case <-p.stopChan:
 			return
 		}
@@ -293,13 +1173,58 @@ func (p *PeerDiscovery) cleanupStaleNodes() {
 	
 	for nodeID, peer := range p.knownNodes {
 		// Remover nós que não foram vistos há mais de 24 horas
-		if time.Since(peer.LastSeen) > 24*time.Hour {
+		if time.Since(peer.LastSeen) > NodeStaleAfter {
 			delete(p.knownNodes, nodeID)
+			delete(p.failedPaths, nodeID)
 			fmt.Printf("Removendo peer inativo: %s (último contato: %v)\n", 
 				nodeID, peer.LastSeen)
 			
-			// Remover também do VPNCore
-			p.vpnCore.RemovePeer(nodeID)
+			// Remover também do VPNCore (peers aprendidos via PEX nunca foram configurados)
+			if peer.LearnedFrom == "" {
+				p.vpnCore.RemovePeer(nodeID)
+			}
+		}
+	}
+}
+
+// localEndpoints lista os endereços locais utilizáveis como endpoints WireGuard,
+// ignorando a própria interface da VPN
+func localEndpoints(port int, vpnInterface string) []string {
+	var endpoints []string
+	
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return endpoints
+	}
+	
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Name == vpnInterface {
+			continue
+		}
+		
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsLoopback() {
+				continue
+			}
+			endpoints = append(endpoints, net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port)))
+		}
+	}
+	
+	return endpoints
+}
+
+// appendUnique adiciona um valor à lista apenas se ele ainda não estiver presente
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
 		}
 	}
+	return append(list, value)
 }
@@ -0,0 +1,250 @@
+package discovery
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Limites do resumo de peers (PEX)
+const (
+	// MaxPeerExchangeEntries é o número máximo de peers em uma mensagem PEX
+	MaxPeerExchangeEntries = 8
+
+	// maxPeerExchangeEndpoints limita os endpoints repassados por peer
+	maxPeerExchangeEndpoints = 3
+
+	// peerExchangeMaxAge é a idade máxima de um peer para ser repassado
+	peerExchangeMaxAge = 1 * time.Hour
+)
+
+// sendPeerExchange envia um resumo dos peers conhecidos para os nós com contato direto
+func (p *PeerDiscovery) sendPeerExchange() {
+	p.mutex.Lock()
+	running := p.running
+	conn := p.udpConn
+	p.mutex.Unlock()
+
+	if !running || conn == nil {
+		return
+	}
+
+	p.nodesMutex.RLock()
+	var targets []*PeerInfo
+	for _, peer := range p.knownNodes {
+		if peer.LearnedFrom == "" && peer.DiscoveryAddr != "" && time.Since(peer.LastSeen) < peerExchangeMaxAge {
+			copied := *peer
+			targets = append(targets, &copied)
+		}
+	}
+	p.nodesMutex.RUnlock()
+
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target.DiscoveryAddr)
+		if err != nil {
+			continue
+		}
+		p.sendPeerExchangeTo(conn, target.NodeID, addr)
+	}
+}
+
+// sendPeerExchangeTo envia um resumo dos peers conhecidos para um único nó
+func (p *PeerDiscovery) sendPeerExchangeTo(conn net.PacketConn, nodeID string, addr *net.UDPAddr) {
+	data, err := p.buildPeerExchange(nodeID)
+	if err != nil {
+		fmt.Printf("Erro ao construir resumo de peers para %s: %v\n", nodeID, err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	if _, err := conn.WriteTo(data, addr); err != nil {
+		fmt.Printf("Erro ao enviar resumo de peers para %s: %v\n", nodeID, err)
+	}
+}
+
+// greetPeer responde a um nó recém-descoberto com nosso anúncio e os peers que conhecemos,
+// sem esperar o próximo ciclo de anúncios
+func (p *PeerDiscovery) greetPeer(nodeID, discoveryAddr string) {
+	addr, err := net.ResolveUDPAddr("udp", discoveryAddr)
+	if err != nil {
+		return
+	}
+
+	p.sendAnnouncementTo(addr)
+
+	p.mutex.Lock()
+	conn := p.udpConn
+	p.mutex.Unlock()
+
+	if conn != nil {
+		p.sendPeerExchangeTo(conn, nodeID, addr)
+	}
+}
+
+// buildPeerExchange cria um resumo assinado com uma amostra dos peers com que este nó teve
+// contato direto, omitindo o próprio destinatário. Retorna nil se não houver nada a compartilhar.
+func (p *PeerDiscovery) buildPeerExchange(exclude string) ([]byte, error) {
+	p.nodesMutex.RLock()
+	var records []PeerRecord
+	for _, peer := range p.knownNodes {
+		if peer.NodeID == exclude || peer.LearnedFrom != "" || time.Since(peer.LastSeen) > peerExchangeMaxAge {
+			continue
+		}
+
+		endpoints := peer.Endpoints
+		if len(endpoints) > maxPeerExchangeEndpoints {
+			endpoints = endpoints[:maxPeerExchangeEndpoints]
+		}
+
+		records = append(records, PeerRecord{
+			NodeID:        peer.NodeID,
+			PublicKey:     peer.PublicKey,
+			VirtualIP:     peer.VirtualIP,
+			Endpoints:     append([]string(nil), endpoints...),
+			DiscoveryAddr: peer.DiscoveryAddr,
+			SigningKey:    peer.SigningKey,
+			LastSeen:      peer.LastSeen.Unix(),
+		})
+	}
+	p.nodesMutex.RUnlock()
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// Uma amostra diferente a cada rodada faz todos os peers circularem com o tempo
+	rand.Shuffle(len(records), func(i, j int) {
+		records[i], records[j] = records[j], records[i]
+	})
+	if len(records) > MaxPeerExchangeEntries {
+		records = records[:MaxPeerExchangeEntries]
+	}
+
+	// Reduzir a amostra até caber no tamanho máximo de mensagem
+	for len(records) > 0 {
+		data, err := EncodeMessage(MsgPeerExchange, PeerExchange{Peers: records}, p.signingKey)
+		if err == nil {
+			return data, nil
+		}
+		records = records[:len(records)-1]
+	}
+
+	return nil, nil
+}
+
+// handlePeerExchange incorpora o resumo de peers enviado por um nó confiável
+func (p *PeerDiscovery) handlePeerExchange(msg *SignedMessage, addr *net.UDPAddr) {
+	sender, trusted := p.trustedSigner(msg.Signer())
+	if !trusted {
+		fmt.Printf("Resumo de peers de %s ignorado: remetente não confiável\n", addr.String())
+		return
+	}
+
+	var exchange PeerExchange
+	if err := msg.Decode(&exchange); err != nil {
+		fmt.Printf("Resumo de peers inválido de %s: %v\n", sender, err)
+		return
+	}
+
+	if len(exchange.Peers) > MaxPeerExchangeEntries {
+		exchange.Peers = exchange.Peers[:MaxPeerExchangeEntries]
+	}
+
+	merged := 0
+	for _, record := range exchange.Peers {
+		isNew, ok := p.mergePeerRecord(record, sender)
+		if !ok {
+			continue
+		}
+		merged++
+
+		// Apresentar-se aos novos peers para que o contato direto se estabeleça
+		if isNew {
+			if addr, err := net.ResolveUDPAddr("udp", record.DiscoveryAddr); err == nil {
+				p.sendAnnouncementTo(addr)
+			}
+		}
+	}
+
+	if merged > 0 {
+		fmt.Printf("%d peers aprendidos via PEX de %s\n", merged, sender)
+	}
+}
+
+// mergePeerRecord adiciona ou atualiza um peer aprendido via PEX e informa se ele era
+// desconhecido. O PEX só fornece endereços: a chave de assinatura do registro não é guardada,
+// e o peer só é configurado no WireGuard depois que anunciar diretamente para nós.
+func (p *PeerDiscovery) mergePeerRecord(record PeerRecord, sender string) (isNew bool, ok bool) {
+	if record.NodeID == "" || record.PublicKey == "" || record.DiscoveryAddr == "" {
+		return false, false
+	}
+	if record.NodeID == p.nodeID || record.PublicKey == p.publicKey {
+		return false, false
+	}
+	if _, err := net.ResolveUDPAddr("udp", record.DiscoveryAddr); err != nil {
+		return false, false
+	}
+
+	// Chaves recusadas pelo administrador não circulam a partir deste nó
+	if store := p.pendingStore(); store != nil && store.IsBlocked(record.PublicKey, "") {
+		return false, false
+	}
+
+	// Peers que a política recusaria não são guardados nem contatados
+	candidate := &PeerInfo{
+		NodeID:        record.NodeID,
+		PublicKey:     record.PublicKey,
+		VirtualIP:     record.VirtualIP,
+		DiscoveryAddr: record.DiscoveryAddr,
+	}
+	if err := p.trustPolicy().Authorize(candidate, p.vpnCore.TrustedPeers()); err != nil {
+		fmt.Printf("Peer %s repassado por %s rejeitado pela política de confiança: %v\n", record.NodeID, sender, err)
+		return false, false
+	}
+
+	lastSeen := time.Unix(record.LastSeen, 0)
+	if lastSeen.After(time.Now()) {
+		lastSeen = time.Now()
+	}
+	if time.Since(lastSeen) > peerExchangeMaxAge {
+		return false, false
+	}
+
+	p.nodesMutex.Lock()
+	defer p.nodesMutex.Unlock()
+
+	peer, exists := p.knownNodes[record.NodeID]
+	if exists {
+		// Informação direta ou mais recente prevalece sobre o que foi repassado
+		if peer.PublicKey != record.PublicKey || peer.LearnedFrom == "" || !lastSeen.After(peer.LastSeen) {
+			return false, false
+		}
+	} else {
+		peer = &PeerInfo{NodeID: record.NodeID}
+		p.knownNodes[record.NodeID] = peer
+	}
+
+	peer.PublicKey = record.PublicKey
+	peer.VirtualIP = record.VirtualIP
+	peer.Endpoints = record.Endpoints
+	peer.DiscoveryAddr = record.DiscoveryAddr
+	peer.SigningKey = ""
+	peer.LastSeen = lastSeen
+	peer.LearnedFrom = sender
+
+	return !exists, true
+}
+
+// trustedSigner retorna o peer confiável dono da chave de assinatura informada. Só valem as
+// chaves registradas na configuração; peers sem chave registrada não são reconhecidos.
+func (p *PeerDiscovery) trustedSigner(signingKey string) (string, bool) {
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.SigningKey != "" && peer.SigningKey == signingKey {
+			return peer.NodeID, true
+		}
+	}
+	return "", false
+}
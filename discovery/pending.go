@@ -0,0 +1,620 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Limites da fila de aprovação
+const (
+	// MaxPendingPeers limita a fila; os pedidos mais antigos são descartados primeiro
+	MaxPendingPeers = 256
+
+	// pendingUpdateInterval evita regravar o arquivo a cada anúncio do mesmo nó
+	pendingUpdateInterval = 1 * time.Minute
+
+	// pendingSaveDelay agrupa as alterações da fila feitas pelos anúncios em uma só gravação
+	pendingSaveDelay = 5 * time.Second
+
+	// pendingRacyWindow cobre a resolução da data de modificação: gravações de outro processo no
+	// mesmo tique não mudam a data, então um arquivo gravado há pouco é sempre relido
+	pendingRacyWindow = 2 * time.Second
+)
+
+// fingerprintContext separa a impressão digital de outros hashes das mesmas chaves
+const fingerprintContext = "p2p-vpn/fingerprint/v1"
+
+// ErrPendingNotFound indica que nenhum peer pendente corresponde ao identificador
+var ErrPendingNotFound = errors.New("peer pendente não encontrado")
+
+// PendingPeer é um nó descoberto que aguarda aprovação do administrador. Cada pedido é a
+// combinação da chave WireGuard com a chave de assinatura que anunciou o nó; Conflict indica
+// outro pedido com o mesmo ID ou a mesma chave WireGuard, que só a impressão digital separa.
+// PendingPeer is a discovered node waiting for administrator approval
+// PendingPeer es un nodo descubierto que espera la aprobación del administrador
+type PendingPeer struct {
+	NodeID      string   `yaml:"nodeId" json:"node_id"`
+	PublicKey   string   `yaml:"publicKey" json:"public_key"`
+	Fingerprint string   `yaml:"fingerprint" json:"fingerprint"`
+	VirtualIP   string   `yaml:"virtualIp" json:"virtual_ip"`
+	Endpoints   []string `yaml:"endpoints,omitempty" json:"endpoints"`
+	SourceAddr  string   `yaml:"sourceAddr" json:"source_addr"`
+	SigningKey  string   `yaml:"signingKey" json:"signing_key"`
+	FirstSeen   int64    `yaml:"firstSeen" json:"first_seen"`
+	LastSeen    int64    `yaml:"lastSeen" json:"last_seen"`
+	Conflict    bool     `yaml:"-" json:"conflict"`
+}
+
+// BlockedPeer é uma identidade recusada pelo administrador
+type BlockedPeer struct {
+	NodeID      string `yaml:"nodeId" json:"node_id"`
+	PublicKey   string `yaml:"publicKey" json:"public_key"`
+	SigningKey  string `yaml:"signingKey,omitempty" json:"signing_key"`
+	Fingerprint string `yaml:"fingerprint" json:"fingerprint"`
+	Blocked     int64  `yaml:"blocked" json:"blocked"`
+}
+
+// pendingState é o conteúdo do arquivo da fila de aprovação
+type pendingState struct {
+	Pending  []PendingPeer `yaml:"pending,omitempty"`
+	Approved []PendingPeer `yaml:"approved,omitempty"`
+	Blocked  []BlockedPeer `yaml:"blocked,omitempty"`
+}
+
+// Fingerprint retorna uma impressão digital curta da identidade de um nó, a chave WireGuard
+// junto com a chave de assinatura da descoberta, para conferência manual com o dono do nó.
+// Quem anuncia a chave WireGuard de outro nó com a própria chave de assinatura gera outra
+// impressão digital.
+// Fingerprint returns a short fingerprint of a node's WireGuard and signing keys for manual checking
+// Fingerprint devuelve una huella corta de las claves WireGuard y de firma de un nodo
+func Fingerprint(publicKey, signingKey string) string {
+	wgKey, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(wgKey) != 32 {
+		return ""
+	}
+	signer, err := core.ParseSigningPublicKey(signingKey)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(fingerprintContext))
+	hash.Write(wgKey)
+	hash.Write(signer)
+	digest := hash.Sum(nil)
+
+	groups := make([]string, 0, 8)
+	for i := 0; i < 16; i += 2 {
+		groups = append(groups, fmt.Sprintf("%02x%02x", digest[i], digest[i+1]))
+	}
+	return strings.Join(groups, ":")
+}
+
+// PendingStore guarda a fila de aprovação e a lista de bloqueio em um arquivo ao lado da configuração.
+// O arquivo é compartilhado entre o serviço e os comandos de administração: o estado fica em
+// memória e é relido quando outro processo altera o arquivo. Os anúncios só alteram a memória e
+// são gravados em lote depois de pendingSaveDelay; as decisões do administrador são gravadas na hora.
+type PendingStore struct {
+	path  string
+	mutex sync.Mutex
+
+	state     *pendingState // Estado em memória, carregado no primeiro uso
+	modTime   time.Time     // Modificação do arquivo na última leitura ou gravação
+	dirty     bool          // Alterações dos anúncios ainda não gravadas
+	saveTimer *time.Timer
+}
+
+// PendingStorePath retorna o arquivo da fila de aprovação ao lado do arquivo de configuração
+func PendingStorePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "pending_peers.yaml")
+}
+
+// NewPendingStore cria o armazenamento da fila de aprovação no caminho informado
+func NewPendingStore(path string) *PendingStore {
+	return &PendingStore{path: path}
+}
+
+// load lê o estado do arquivo; um arquivo inexistente equivale a uma fila vazia
+func (s *PendingStore) load() (*pendingState, error) {
+	state := &pendingState{}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("erro ao analisar arquivo de peers pendentes: %w", err)
+	}
+	return state, nil
+}
+
+// sync carrega o estado na primeira chamada e o relê quando outro processo alterou o arquivo,
+// mantendo os pedidos ainda não gravados que o administrador não decidiu
+func (s *PendingStore) sync() error {
+	var modTime time.Time
+	info, err := os.Stat(s.path)
+	if err == nil {
+		modTime = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if s.state != nil && modTime.Equal(s.modTime) && time.Since(modTime) > pendingRacyWindow {
+		return nil
+	}
+
+	state, err := s.load()
+	if err != nil {
+		return err
+	}
+	if s.state != nil && s.dirty {
+		mergeUnsavedPending(state, s.state.Pending)
+	}
+
+	s.state = state
+	s.modTime = modTime
+	return nil
+}
+
+// mergeUnsavedPending junta ao estado relido os pedidos da memória: pedidos já decididos
+// ficam de fora e os demais mantêm o contato mais recente
+func mergeUnsavedPending(state *pendingState, pending []PendingPeer) {
+	for _, peer := range pending {
+		if findIdentity(state.Approved, peer.PublicKey, peer.SigningKey) >= 0 || state.isBlocked(peer.PublicKey, peer.SigningKey) {
+			continue
+		}
+
+		index := findIdentity(state.Pending, peer.PublicKey, peer.SigningKey)
+		if index < 0 {
+			state.Pending = append(state.Pending, peer)
+		} else if peer.LastSeen > state.Pending[index].LastSeen {
+			state.Pending[index] = peer
+		}
+	}
+}
+
+// save grava o estado em memória no arquivo
+func (s *PendingStore) save() error {
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+
+	data, err := yaml.Marshal(s.state)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar peers pendentes: %w", err)
+	}
+	if err := ioutil.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("erro ao salvar arquivo de peers pendentes: %w", err)
+	}
+
+	s.dirty = false
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// scheduleSave marca o estado como alterado e agenda a gravação, agrupando os anúncios seguintes
+func (s *PendingStore) scheduleSave() {
+	s.dirty = true
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(pendingSaveDelay, s.flushScheduled)
+	}
+}
+
+// flushScheduled grava as alterações agendadas por scheduleSave
+func (s *PendingStore) flushScheduled() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.saveTimer = nil
+	if err := s.flush(); err != nil {
+		fmt.Printf("Erro ao gravar peers pendentes: %v\n", err)
+	}
+}
+
+// Flush grava imediatamente as alterações ainda não gravadas
+// Flush immediately writes changes not yet saved
+// Flush graba inmediatamente los cambios aún no guardados
+func (s *PendingStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.flush()
+}
+
+// flush grava o estado se houver alterações pendentes, relendo antes as decisões de outros processos
+func (s *PendingStore) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Add coloca um nó na fila de aprovação ou renova o registro existente. O pedido é identificado
+// pela chave WireGuard junto com a chave de assinatura: um anúncio da mesma chave WireGuard
+// assinado por outra chave vira outro pedido, em conflito com o primeiro.
+// Retorna true se o pedido ainda não estava na fila.
+func (s *PendingStore) Add(peer PendingPeer) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix()
+	if index := findIdentity(s.state.Pending, peer.PublicKey, peer.SigningKey); index >= 0 {
+		existing := &s.state.Pending[index]
+
+		// Os dados de contato mudam com frequência; sem mudança, o registro é renovado só de tempos em tempos
+		changed := existing.NodeID != peer.NodeID || existing.VirtualIP != peer.VirtualIP ||
+			existing.SourceAddr != peer.SourceAddr || !equalStrings(existing.Endpoints, peer.Endpoints)
+		if !changed && now-existing.LastSeen < int64(pendingUpdateInterval/time.Second) {
+			return false, nil
+		}
+		existing.NodeID = peer.NodeID
+		existing.VirtualIP = peer.VirtualIP
+		existing.Endpoints = peer.Endpoints
+		existing.SourceAddr = peer.SourceAddr
+		existing.LastSeen = now
+		s.scheduleSave()
+		return false, nil
+	}
+
+	peer.Fingerprint = Fingerprint(peer.PublicKey, peer.SigningKey)
+	peer.FirstSeen = now
+	peer.LastSeen = now
+	s.state.Pending = append(s.state.Pending, peer)
+
+	if len(s.state.Pending) > MaxPendingPeers {
+		sort.Slice(s.state.Pending, func(i, j int) bool {
+			return s.state.Pending[i].LastSeen > s.state.Pending[j].LastSeen
+		})
+		s.state.Pending = s.state.Pending[:MaxPendingPeers]
+	}
+
+	s.scheduleSave()
+	return true, nil
+}
+
+// List retorna os nós que aguardam aprovação, do mais antigo para o mais recente, marcando os
+// pedidos que compartilham o ID ou a chave WireGuard com outro pedido
+func (s *PendingStore) List() ([]PendingPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+
+	pending := make([]PendingPeer, len(s.state.Pending))
+	copy(pending, s.state.Pending)
+
+	nodeIDs := make(map[string]int)
+	publicKeys := make(map[string]int)
+	for _, peer := range pending {
+		nodeIDs[peer.NodeID]++
+		publicKeys[peer.PublicKey]++
+	}
+	for i := range pending {
+		pending[i].Conflict = nodeIDs[pending[i].NodeID] > 1 || publicKeys[pending[i].PublicKey] > 1
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].FirstSeen < pending[j].FirstSeen
+	})
+	return pending, nil
+}
+
+// Blocked retorna as identidades recusadas
+func (s *PendingStore) Blocked() ([]BlockedPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	return append([]BlockedPeer(nil), s.state.Blocked...), nil
+}
+
+// Approve libera o nó pendente com a impressão digital informada. Só a impressão digital
+// identifica o pedido: o ID e a chave WireGuard podem ser anunciados por outro nó.
+// O nó é configurado no WireGuard no próximo contato, se ainda usar a mesma chave de assinatura.
+func (s *PendingStore) Approve(fingerprint string) (*PendingPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+
+	index := findPending(s.state.Pending, fingerprint)
+	if index < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPendingNotFound, fingerprint)
+	}
+
+	peer := s.state.Pending[index]
+	s.state.Pending = append(s.state.Pending[:index], s.state.Pending[index+1:]...)
+	s.state.Approved = append(s.state.Approved, peer)
+
+	return &peer, s.save()
+}
+
+// Reject remove da fila o nó com a impressão digital informada e bloqueia sua identidade
+func (s *PendingStore) Reject(fingerprint string) (*PendingPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+
+	index := findPending(s.state.Pending, fingerprint)
+	if index < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPendingNotFound, fingerprint)
+	}
+
+	peer := s.state.Pending[index]
+	s.state.Pending = append(s.state.Pending[:index], s.state.Pending[index+1:]...)
+	s.state.Blocked = append(s.state.Blocked, BlockedPeer{
+		NodeID:      peer.NodeID,
+		PublicKey:   peer.PublicKey,
+		SigningKey:  peer.SigningKey,
+		Fingerprint: peer.Fingerprint,
+		Blocked:     time.Now().Unix(),
+	})
+
+	return &peer, s.save()
+}
+
+// IsBlocked informa se a chave WireGuard foi recusada junto com a chave de assinatura.
+// Sem chave de assinatura, qualquer recusa da chave WireGuard conta.
+func (s *PendingStore) IsBlocked(publicKey, signingKey string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return false
+	}
+	return s.state.isBlocked(publicKey, signingKey)
+}
+
+// isBlocked procura a identidade na lista de bloqueio; bloqueios sem chave de assinatura,
+// gravados por versões anteriores, valem para qualquer chave
+func (state *pendingState) isBlocked(publicKey, signingKey string) bool {
+	for _, blocked := range state.Blocked {
+		if blocked.PublicKey != publicKey {
+			continue
+		}
+		if signingKey == "" || blocked.SigningKey == "" || blocked.SigningKey == signingKey {
+			return true
+		}
+	}
+	return false
+}
+
+// FindApproved retorna a aprovação de uma chave se ela foi concedida para a mesma chave de
+// assinatura, sem retirá-la: a aprovação só é consumida por ConsumeApproved depois que o peer
+// passou pela política de confiança e foi configurado
+func (s *PendingStore) FindApproved(publicKey, signingKey string) (*PendingPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+
+	index := findIdentity(s.state.Approved, publicKey, signingKey)
+	if index < 0 {
+		return nil, nil
+	}
+
+	approved := s.state.Approved[index]
+	return &approved, nil
+}
+
+// ConsumeApproved retira a aprovação de uma chave já configurada
+func (s *PendingStore) ConsumeApproved(publicKey, signingKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return err
+	}
+
+	index := findIdentity(s.state.Approved, publicKey, signingKey)
+	if index < 0 {
+		return nil
+	}
+
+	s.state.Approved = append(s.state.Approved[:index], s.state.Approved[index+1:]...)
+	return s.save()
+}
+
+// Pin fixa a chave de assinatura de um peer confiável configurado sem ela, a partir do pedido
+// com a impressão digital informada. pin grava a chave na configuração (veja PinTrustedPeer); o
+// pedido só sai da fila se pin tiver sucesso.
+// Pin pins a trusted peer's signing key from the pending request with the given fingerprint
+// Pin fija la clave de firma de un par de confianza a partir de la solicitud con la huella indicada
+func (s *PendingStore) Pin(fingerprint string, pin func(PendingPeer) error) (*PendingPeer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+
+	index := findPending(s.state.Pending, fingerprint)
+	if index < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPendingNotFound, fingerprint)
+	}
+
+	peer := s.state.Pending[index]
+	if err := pin(peer); err != nil {
+		return nil, err
+	}
+	s.state.Pending = append(s.state.Pending[:index], s.state.Pending[index+1:]...)
+
+	return &peer, s.save()
+}
+
+// PinTrustedPeer retorna o peer confiável que usa a chave WireGuard do pedido, com a chave de
+// assinatura do pedido fixada. Só peers configurados sem chave de assinatura são fixados dessa
+// forma; trocar uma chave registrada exige readicionar o peer.
+// PinTrustedPeer returns the trusted peer using the request's WireGuard key with its signing key pinned
+// PinTrustedPeer devuelve el par de confianza con la clave WireGuard de la solicitud y su clave de firma fijada
+func PinTrustedPeer(peers []core.TrustedPeer, pending PendingPeer) (core.TrustedPeer, error) {
+	for _, peer := range peers {
+		if peer.PublicKey != pending.PublicKey {
+			continue
+		}
+		if peer.SigningKey != "" {
+			return core.TrustedPeer{}, fmt.Errorf("o peer %s já tem chave de assinatura registrada; remova-o e adicione-o de novo para trocá-la", peer.NodeID)
+		}
+		peer.SigningKey = pending.SigningKey
+		return peer, nil
+	}
+	return core.TrustedPeer{}, fmt.Errorf("nenhum peer confiável usa a chave WireGuard de %s; use 'peer approve'", pending.NodeID)
+}
+
+// findPending localiza um nó pela impressão digital
+func findPending(peers []PendingPeer, fingerprint string) int {
+	for i, peer := range peers {
+		if peer.Fingerprint != "" && peer.Fingerprint == fingerprint {
+			return i
+		}
+	}
+	return -1
+}
+
+// findIdentity localiza um nó pela chave WireGuard e pela chave de assinatura
+func findIdentity(peers []PendingPeer, publicKey, signingKey string) int {
+	for i, peer := range peers {
+		if peer.PublicKey == publicKey && peer.SigningKey == signingKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalStrings compara duas listas de endereços na mesma ordem
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPendingStore ativa a fila de aprovação: nós desconhecidos deixam de ser configurados
+// automaticamente e aguardam a decisão do administrador
+func (p *PeerDiscovery) SetPendingStore(store *PendingStore) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pending = store
+}
+
+// pendingStore retorna a fila de aprovação em uso
+func (p *PeerDiscovery) pendingStore() *PendingStore {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.pending
+}
+
+// isTrustedKey informa se a chave WireGuard já está entre os peers configurados
+func (p *PeerDiscovery) isTrustedKey(publicKey string) bool {
+	for _, peer := range p.vpnCore.TrustedPeers() {
+		if peer.PublicKey == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// admitPeer decide se um nó fora dos peers configurados pode ser configurado agora.
+// Retorna o registro da aprovação quando o administrador liberou o nó; nós bloqueados
+// são descartados e os demais aguardam na fila.
+func (p *PeerDiscovery) admitPeer(info *PeerInfo) (*PendingPeer, bool) {
+	store := p.pendingStore()
+	if store == nil || p.isTrustedKey(info.PublicKey) {
+		return nil, true
+	}
+
+	// Não enfileirar nós de origens que a política recusaria de qualquer forma
+	if host, _, err := net.SplitHostPort(info.DiscoveryAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !p.trustPolicy().AllowSource(ip) {
+			fmt.Printf("Peer %s rejeitado: origem %s fora dos IPs confiáveis\n", info.NodeID, host)
+			return nil, false
+		}
+	}
+
+	if store.IsBlocked(info.PublicKey, info.SigningKey) {
+		fmt.Printf("Peer %s ignorado: chave bloqueada\n", info.NodeID)
+		return nil, false
+	}
+
+	approved, err := store.FindApproved(info.PublicKey, info.SigningKey)
+	if err != nil {
+		fmt.Printf("Erro ao consultar peers aprovados: %v\n", err)
+		return nil, false
+	}
+	if approved != nil {
+		fmt.Printf("Peer %s aprovado pelo administrador\n", info.NodeID)
+		return approved, true
+	}
+
+	isNew, err := store.Add(PendingPeer{
+		NodeID:     info.NodeID,
+		PublicKey:  info.PublicKey,
+		VirtualIP:  info.VirtualIP,
+		Endpoints:  info.Endpoints,
+		SourceAddr: info.DiscoveryAddr,
+		SigningKey: info.SigningKey,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao registrar peer pendente %s: %v\n", info.NodeID, err)
+		return nil, false
+	}
+	if isNew {
+		fmt.Printf("Novo peer aguardando aprovação: %s (%s, impressão digital %s)\n",
+			info.NodeID, info.DiscoveryAddr, Fingerprint(info.PublicKey, info.SigningKey))
+	}
+	return nil, false
+}
+
+// TrustedPeer converte o peer aprovado para a configuração do WireGuard
+func (pp *PendingPeer) TrustedPeer() core.TrustedPeer {
+	return core.TrustedPeer{
+		NodeID:     pp.NodeID,
+		PublicKey:  pp.PublicKey,
+		VirtualIP:  pp.VirtualIP,
+		Endpoints:  pp.Endpoints,
+		SigningKey: pp.SigningKey,
+		LastSeen:   pp.LastSeen,
+	}
+}
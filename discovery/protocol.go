@@ -0,0 +1,352 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Formato de uma mensagem de descoberta no fio (big-endian):
+//
+//	magic(4) | versão(1) | tipo(1) | timestamp(8) | nonce(16) | tamanho(2) | payload | chave(32) | assinatura(64)
+//
+// A assinatura Ed25519 cobre todos os bytes anteriores a ela, incluindo a chave do remetente.
+const (
+	ProtocolMagic   = "P2PD"
+	ProtocolVersion = 1
+
+	// MaxClockSkew é a diferença máxima aceita entre o relógio do remetente e o local
+	MaxClockSkew = 5 * time.Minute
+
+	// MaxMessageSize é o tamanho máximo de uma mensagem de descoberta
+	MaxMessageSize = 2048
+
+	nonceSize  = 16
+	headerSize = len(ProtocolMagic) + 1 + 1 + 8 + nonceSize + 2
+)
+
+// MessageType identifica o conteúdo de uma mensagem de descoberta
+type MessageType uint8
+
+const (
+	MsgAnnouncement       MessageType = iota + 1 // Anúncio de presença de um nó
+	MsgRendezvousRegister                        // Registro de um nó em um servidor de rendezvous
+	MsgRendezvousConnect                         // Pedido de conexão com um nó registrado
+	MsgRendezvousPeer                            // Dados de contato de um nó enviados pelo servidor
+	MsgPeerExchange                              // Resumo dos peers conhecidos pelo remetente (PEX)
+	MsgDHTFindNode                               // DHT: pedido dos nós mais próximos de um ID
+	MsgDHTFindValue                              // DHT: pedido do registro de um ID
+	MsgDHTStore                                  // DHT: publicação de um registro
+	MsgDHTNodes                                  // DHT: resposta com contatos
+	MsgDHTValue                                  // DHT: resposta com um registro
+	MsgInvite                                    // Convite assinado (usado apenas dentro do token)
+	MsgJoinRequest                               // Pedido de entrada com um convite
+	MsgJoinAccept                                // Resposta do anfitrião a um pedido de entrada
+	MsgPunch                                     // Rodada de hole punching combinada com um peer
+	MsgRendezvousForward                         // Mensagem a repassar pelo rendezvous a um nó registrado
+)
+
+// String retorna o nome do tipo de mensagem
+func (t MessageType) String() string {
+	switch t {
+	case MsgAnnouncement:
+		return "announcement"
+	case MsgRendezvousRegister:
+		return "rendezvous-register"
+	case MsgRendezvousConnect:
+		return "rendezvous-connect"
+	case MsgRendezvousPeer:
+		return "rendezvous-peer"
+	case MsgPeerExchange:
+		return "peer-exchange"
+	case MsgDHTFindNode:
+		return "dht-find-node"
+	case MsgDHTFindValue:
+		return "dht-find-value"
+	case MsgDHTStore:
+		return "dht-store"
+	case MsgDHTNodes:
+		return "dht-nodes"
+	case MsgDHTValue:
+		return "dht-value"
+	case MsgInvite:
+		return "invite"
+	case MsgJoinRequest:
+		return "join-request"
+	case MsgJoinAccept:
+		return "join-accept"
+	case MsgPunch:
+		return "punch"
+	case MsgRendezvousForward:
+		return "rendezvous-forward"
+	default:
+		return fmt.Sprintf("desconhecido(%d)", uint8(t))
+	}
+}
+
+// Capability representa funcionalidades opcionais anunciadas por um nó
+type Capability uint32
+
+const (
+	CapabilityIPv4  Capability = 1 << iota // Possui endpoints IPv4
+	CapabilityIPv6                         // Possui endpoints IPv6
+	CapabilityRelay                        // Opera um relay entre peers alcançável de fora
+)
+
+// Has verifica se o conjunto contém a capacidade informada
+func (c Capability) Has(flag Capability) bool {
+	return c&flag == flag
+}
+
+// Erros de validação de mensagens
+var (
+	ErrInvalidMessage   = errors.New("mensagem de descoberta inválida")
+	ErrBadSignature     = errors.New("assinatura da mensagem inválida")
+	ErrUnsupportedProto = errors.New("versão de protocolo não suportada")
+	ErrStaleMessage     = errors.New("mensagem fora da janela de tempo aceita")
+	ErrReplayedMessage  = errors.New("mensagem repetida (replay)")
+
+	// ErrUnpinnedSigningKey indica um peer confiável configurado sem chave de assinatura
+	ErrUnpinnedSigningKey = errors.New("chave de assinatura não registrada")
+)
+
+// Announcement é o anúncio periódico que um nó envia para se tornar conhecido
+type Announcement struct {
+	NodeID        string     `json:"nodeId"`
+	PublicKey     string     `json:"publicKey"` // Chave pública WireGuard (base64)
+	VirtualIP     string     `json:"virtualIp"`
+	ListenPort    int        `json:"listenPort"`    // Porta WireGuard do nó
+	DiscoveryPort int        `json:"discoveryPort"` // Porta unicast do serviço de descoberta
+	Endpoints     []string   `json:"endpoints"`     // Endpoints WireGuard candidatos (ip:porta)
+	Capabilities  Capability `json:"caps"`
+	Relay         string     `json:"relay,omitempty"`        // Relay entre peers operado pelo nó (ip:porta)
+	HomeRelay     string     `json:"homeRelay,omitempty"`    // Relay em que o nó recebe tráfego (ip:porta)
+	HomeRelayKey  string     `json:"homeRelayKey,omitempty"` // Chave WireGuard do nó que opera o relay principal
+
+	// Candidatos com tipo e prioridade; nós que não os anunciam usam apenas Endpoints
+	Candidates []CandidateRecord `json:"candidates,omitempty"`
+}
+
+// CandidateRecord descreve um candidato da porta WireGuard, como no ICE
+type CandidateRecord struct {
+	Type     string `json:"type"`    // host, srflx, mapped, prflx ou relay
+	Address  string `json:"address"` // ip:porta
+	Priority uint32 `json:"priority"`
+}
+
+// RendezvousConnect pede ao servidor de rendezvous que apresente dois nós
+type RendezvousConnect struct {
+	PublicKey string `json:"publicKey"` // Chave WireGuard do nó procurado
+}
+
+// RendezvousPeer apresenta um nó ao outro lado de um pedido de conexão
+type RendezvousPeer struct {
+	ObservedAddr string `json:"observedAddr"` // Endereço de descoberta público observado pelo servidor
+	Registration []byte `json:"registration"` // Registro original, assinado pelo próprio nó
+}
+
+// RendezvousForward pede ao servidor de rendezvous que repasse uma mensagem assinada pelo
+// remetente ao endereço registrado de outro nó
+type RendezvousForward struct {
+	PublicKey string `json:"publicKey"` // Chave WireGuard do nó de destino
+	Message   []byte `json:"message"`   // Mensagem original (MsgPunch), assinada pelo remetente
+}
+
+// PunchRequest combina com um peer o instante de uma rodada de hole punching simultâneo
+type PunchRequest struct {
+	NodeID    string   `json:"nodeId"`
+	Session   string   `json:"session"`   // Identificador da rodada, o mesmo nos dois lados
+	At        int64    `json:"at"`        // Início das sondas (Unix, em milissegundos)
+	Endpoints []string `json:"endpoints"` // Endpoints da porta WireGuard do remetente
+
+	// Alocação de portas do remetente, quando atrás de NAT simétrico
+	Ports *PortAllocation `json:"ports,omitempty"`
+
+	// Rodada de verificações de conectividade, com os candidatos do remetente
+	Checks     bool              `json:"checks,omitempty"`
+	Candidates []CandidateRecord `json:"candidates,omitempty"`
+}
+
+// PortAllocation descreve como o NAT simétrico do remetente aloca portas externas
+type PortAllocation struct {
+	IP       string `json:"ip"`
+	LastPort int    `json:"lastPort"` // Última porta observada
+	Delta    int    `json:"delta"`    // Passo entre alocações; zero quando não há padrão
+}
+
+// PeerExchange é o resumo limitado de peers compartilhado entre nós conectados
+type PeerExchange struct {
+	Peers []PeerRecord `json:"peers"`
+}
+
+// PeerRecord descreve um peer conhecido pelo remetente de um PeerExchange
+type PeerRecord struct {
+	NodeID        string   `json:"nodeId"`
+	PublicKey     string   `json:"publicKey"`
+	VirtualIP     string   `json:"virtualIp"`
+	Endpoints     []string `json:"endpoints,omitempty"`
+	DiscoveryAddr string   `json:"discoveryAddr,omitempty"`
+	SigningKey    string   `json:"signingKey"` // Apenas informativa: o receptor não a fixa
+	LastSeen      int64    `json:"lastSeen"`   // Unix, em segundos
+}
+
+// SignedMessage é uma mensagem de descoberta decodificada e com assinatura verificada
+type SignedMessage struct {
+	Type      MessageType
+	Timestamp time.Time
+	Nonce     [nonceSize]byte
+	Payload   []byte
+	SignerKey ed25519.PublicKey
+
+	// Raw mantém os bytes originais para que a mensagem possa ser repassada sem reassinatura
+	Raw []byte
+}
+
+// Signer retorna a chave do remetente codificada em base64
+func (m *SignedMessage) Signer() string {
+	return base64.StdEncoding.EncodeToString(m.SignerKey)
+}
+
+// Decode decodifica o payload JSON da mensagem
+func (m *SignedMessage) Decode(v interface{}) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("%w: payload %s: %v", ErrInvalidMessage, m.Type, err)
+	}
+	return nil
+}
+
+// EncodeMessage serializa e assina uma mensagem de descoberta
+func EncodeMessage(msgType MessageType, payload interface{}, key ed25519.PrivateKey) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar payload: %w", err)
+	}
+
+	total := headerSize + len(body) + ed25519.PublicKeySize + ed25519.SignatureSize
+	if total > MaxMessageSize {
+		return nil, fmt.Errorf("mensagem %s excede o tamanho máximo (%d > %d bytes)", msgType, total, MaxMessageSize)
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("erro ao gerar nonce: %w", err)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, total))
+	buf.WriteString(ProtocolMagic)
+	buf.WriteByte(ProtocolVersion)
+	buf.WriteByte(byte(msgType))
+	binary.Write(buf, binary.BigEndian, time.Now().UnixNano())
+	buf.Write(nonce[:])
+	binary.Write(buf, binary.BigEndian, uint16(len(body)))
+	buf.Write(body)
+	buf.Write(key.Public().(ed25519.PublicKey))
+
+	signature := ed25519.Sign(key, buf.Bytes())
+	buf.Write(signature)
+
+	return buf.Bytes(), nil
+}
+
+// DecodeMessage valida o formato e a assinatura de uma mensagem de descoberta.
+// A verificação de janela de tempo e replay é feita por ReplayGuard.
+func DecodeMessage(data []byte) (*SignedMessage, error) {
+	if len(data) < headerSize+ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: mensagem curta demais (%d bytes)", ErrInvalidMessage, len(data))
+	}
+	if string(data[:len(ProtocolMagic)]) != ProtocolMagic {
+		return nil, fmt.Errorf("%w: identificador de protocolo ausente", ErrInvalidMessage)
+	}
+
+	offset := len(ProtocolMagic)
+	if data[offset] != ProtocolVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedProto, data[offset])
+	}
+
+	msg := &SignedMessage{
+		Type: MessageType(data[offset+1]),
+	}
+	offset += 2
+
+	msg.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(data[offset:])))
+	offset += 8
+
+	copy(msg.Nonce[:], data[offset:offset+nonceSize])
+	offset += nonceSize
+
+	payloadLen := int(binary.BigEndian.Uint16(data[offset:]))
+	offset += 2
+
+	if len(data) != offset+payloadLen+ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: tamanho do payload inconsistente", ErrInvalidMessage)
+	}
+
+	msg.Payload = data[offset : offset+payloadLen]
+	offset += payloadLen
+
+	msg.SignerKey = ed25519.PublicKey(data[offset : offset+ed25519.PublicKeySize])
+	offset += ed25519.PublicKeySize
+
+	if !ed25519.Verify(msg.SignerKey, data[:offset], data[offset:]) {
+		return nil, ErrBadSignature
+	}
+
+	msg.Raw = data
+	return msg, nil
+}
+
+// ReplayGuard rejeita mensagens antigas ou repetidas
+type ReplayGuard struct {
+	window time.Duration
+	seen   map[string]time.Time
+	mutex  sync.Mutex
+}
+
+// NewReplayGuard cria um verificador de replay com a janela informada
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Check verifica a janela de tempo e registra o nonce da mensagem
+func (g *ReplayGuard) Check(msg *SignedMessage) error {
+	now := time.Now()
+	skew := now.Sub(msg.Timestamp)
+	if skew > g.window || skew < -g.window {
+		return fmt.Errorf("%w: diferença de %v", ErrStaleMessage, skew.Round(time.Second))
+	}
+
+	key := string(msg.SignerKey) + string(msg.Nonce[:])
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, exists := g.seen[key]; exists {
+		return ErrReplayedMessage
+	}
+	g.seen[key] = msg.Timestamp
+
+	return nil
+}
+
+// Prune remove nonces que já saíram da janela de tempo
+func (g *ReplayGuard) Prune() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	limit := time.Now().Add(-2 * g.window)
+	for key, ts := range g.seen {
+		if ts.Before(limit) {
+			delete(g.seen, key)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// SignalPunch envia ao peer uma rodada de hole punching: direto ao endereço de descoberta
+// dele e pelos servidores de rendezvous, que alcançam o peer pelo registro mesmo quando o
+// contato direto ainda não existe. A cópia que chegar depois é descartada como replay.
+func (p *PeerDiscovery) SignalPunch(nodeID string, offer nattraversal.PunchOffer) error {
+	p.mutex.Lock()
+	conn := p.udpConn
+	p.mutex.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("serviço de descoberta não está em execução")
+	}
+
+	p.nodesMutex.RLock()
+	var publicKey, discoveryAddr string
+	if peer, ok := p.knownNodes[nodeID]; ok {
+		publicKey = peer.PublicKey
+		discoveryAddr = peer.DiscoveryAddr
+	}
+	p.nodesMutex.RUnlock()
+
+	if publicKey == "" {
+		return fmt.Errorf("peer %s desconhecido", nodeID)
+	}
+
+	request := PunchRequest{
+		NodeID:    p.nodeID,
+		Session:   offer.Session,
+		At:        offer.At.UnixMilli(),
+		Endpoints: offer.Endpoints,
+	}
+	if ports := offer.Ports; ports != nil {
+		request.Ports = &PortAllocation{IP: ports.IP, LastPort: ports.LastPort, Delta: ports.Delta}
+	}
+	request.Checks = offer.Checks
+	request.Candidates = candidateRecords(offer.Candidates)
+	data, err := EncodeMessage(MsgPunch, request, p.signingKey)
+	if err != nil {
+		return fmt.Errorf("erro ao construir mensagem de hole punching: %w", err)
+	}
+
+	sent := false
+	if discoveryAddr != "" {
+		if addr, err := net.ResolveUDPAddr("udp", discoveryAddr); err == nil {
+			if _, err := conn.WriteTo(data, addr); err == nil {
+				sent = true
+			}
+		}
+	}
+
+	servers := p.rendezvousServers()
+	if len(servers) > 0 {
+		forward, err := EncodeMessage(MsgRendezvousForward, RendezvousForward{
+			PublicKey: publicKey,
+			Message:   data,
+		}, p.signingKey)
+		if err != nil {
+			return fmt.Errorf("erro ao construir repasse de hole punching: %w", err)
+		}
+		for _, server := range servers {
+			if _, err := conn.WriteTo(forward, server.addr); err != nil {
+				fmt.Printf("Erro ao enviar hole punching pelo rendezvous %s: %v\n", server.addr.String(), err)
+				continue
+			}
+			sent = true
+		}
+	}
+
+	if !sent {
+		return fmt.Errorf("peer %s sem endereço de descoberta nem servidor de rendezvous", nodeID)
+	}
+	return nil
+}
+
+// handlePunch entrega ao NAT traversal a rodada de hole punching de um peer conhecido,
+// identificado pela chave que assina os anúncios dele
+func (p *PeerDiscovery) handlePunch(msg *SignedMessage, addr *net.UDPAddr) {
+	var request PunchRequest
+	if err := msg.Decode(&request); err != nil {
+		fmt.Printf("Hole punching inválido de %s: %v\n", addr.String(), err)
+		return
+	}
+
+	p.nodesMutex.RLock()
+	var publicKey string
+	if peer, ok := p.knownNodes[request.NodeID]; ok && peer.SigningKey == msg.Signer() {
+		publicKey = peer.PublicKey
+	}
+	p.nodesMutex.RUnlock()
+
+	if publicKey == "" {
+		fmt.Printf("Hole punching de %s ignorado: nó %s desconhecido\n", addr.String(), request.NodeID)
+		return
+	}
+
+	p.mutex.Lock()
+	nat := p.nat
+	p.mutex.Unlock()
+	if nat == nil {
+		return
+	}
+
+	offer := nattraversal.PunchOffer{
+		Session:   request.Session,
+		At:        time.UnixMilli(request.At),
+		Endpoints: request.Endpoints,
+	}
+	if ports := request.Ports; ports != nil {
+		offer.Ports = &nattraversal.PortPrediction{IP: ports.IP, LastPort: ports.LastPort, Delta: ports.Delta}
+	}
+	offer.Checks = request.Checks
+	offer.Candidates = recordCandidates(request.Candidates)
+	if err := nat.HandlePunch(request.NodeID, publicKey, offer); err != nil {
+		fmt.Printf("Hole punching do peer %s recusado: %v\n", request.NodeID, err)
+	}
+}
+
+// candidateRecords converte candidatos do NAT traversal para o formato das mensagens
+func candidateRecords(candidates []nattraversal.Candidate) []CandidateRecord {
+	var records []CandidateRecord
+	for _, candidate := range candidates {
+		records = append(records, CandidateRecord{
+			Type:     string(candidate.Type),
+			Address:  candidate.Address,
+			Priority: candidate.Priority,
+		})
+	}
+	return records
+}
+
+// recordCandidates converte candidatos recebidos, descartando tipos desconhecidos e
+// endereços sem porta
+func recordCandidates(records []CandidateRecord) []nattraversal.Candidate {
+	var candidates []nattraversal.Candidate
+	for _, record := range records {
+		switch nattraversal.CandidateType(record.Type) {
+		case nattraversal.CandidateHost, nattraversal.CandidateServerReflexive, nattraversal.CandidatePortMapped,
+			nattraversal.CandidatePeerReflexive, nattraversal.CandidateRelay:
+		default:
+			continue
+		}
+		if _, _, err := net.SplitHostPort(record.Address); err != nil {
+			continue
+		}
+		candidates = append(candidates, nattraversal.Candidate{
+			Type:     nattraversal.CandidateType(record.Type),
+			Address:  record.Address,
+			Priority: record.Priority,
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// rendezvousBackend mantém o registro nos servidores de rendezvous e entrega os peers
+// apresentados por eles
+type rendezvousBackend struct {
+	discovery *PeerDiscovery
+	interval  time.Duration
+	results   chan DiscoveryResult
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// newRendezvousBackend cria o backend de rendezvous
+func newRendezvousBackend(discovery *PeerDiscovery) *rendezvousBackend {
+	return &rendezvousBackend{
+		discovery: discovery,
+		interval:  discovery.announceInterval,
+		results:   make(chan DiscoveryResult, backendResultsBuffer),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Name retorna o nome do backend
+func (r *rendezvousBackend) Name() string {
+	return BackendRendezvous
+}
+
+// Results retorna o canal dos peers apresentados
+func (r *rendezvousBackend) Results() <-chan DiscoveryResult {
+	return r.results
+}
+
+// Start inicia a renovação periódica do registro
+func (r *rendezvousBackend) Start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running {
+		return fmt.Errorf("o backend de rendezvous já está em execução")
+	}
+	if len(r.discovery.rendezvousServers()) == 0 {
+		return fmt.Errorf("nenhum servidor de rendezvous configurado")
+	}
+
+	r.running = true
+	go r.registerRoutine()
+	return nil
+}
+
+// Stop para a renovação do registro
+func (r *rendezvousBackend) Stop() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.running {
+		return nil
+	}
+
+	close(r.stopChan)
+	r.running = false
+	return nil
+}
+
+// Announce renova o registro nos servidores de rendezvous e pede apresentação aos
+// peers confiáveis que não foram vistos recentemente
+func (r *rendezvousBackend) Announce() error {
+	p := r.discovery
+
+	p.mutex.Lock()
+	conn := p.udpConn
+	p.mutex.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("serviço de descoberta não está em execução")
+	}
+
+	announcement := p.localAnnouncement()
+	signer := p.signingKey.Public().(ed25519.PublicKey)
+	missing := p.unreachableTrustedPeers()
+
+	for _, server := range p.rendezvousServers() {
+		// A prova de posse da chave WireGuard é calculada com a chave de cada servidor
+		payload, err := NewRendezvousRegistration(announcement, p.config.PrivateKey, signer, server.key)
+		if err != nil {
+			return fmt.Errorf("erro ao construir registro de rendezvous: %w", err)
+		}
+		registration, err := EncodeMessage(MsgRendezvousRegister, payload, p.signingKey)
+		if err != nil {
+			return fmt.Errorf("erro ao construir registro de rendezvous: %w", err)
+		}
+
+		if _, err := conn.WriteTo(registration, server.addr); err != nil {
+			fmt.Printf("Erro ao registrar no rendezvous %s: %v\n", server.addr.String(), err)
+			continue
+		}
+
+		for _, publicKey := range missing {
+			request, err := EncodeMessage(MsgRendezvousConnect, RendezvousConnect{PublicKey: publicKey}, p.signingKey)
+			if err != nil {
+				continue
+			}
+			if _, err := conn.WriteTo(request, server.addr); err != nil {
+				fmt.Printf("Erro ao enviar pedido de conexão ao rendezvous %s: %v\n", server.addr.String(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// registerRoutine renova o registro periodicamente
+func (r *rendezvousBackend) registerRoutine() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	if err := r.Announce(); err != nil {
+		fmt.Printf("Erro ao contatar servidores de rendezvous: %v\n", err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Announce(); err != nil {
+				fmt.Printf("Erro ao contatar servidores de rendezvous: %v\n", err)
+			}
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// deliver processa a apresentação de um nó feita por um servidor de rendezvous, que precisa
+// vir assinada pela chave configurada para o servidor
+func (r *rendezvousBackend) deliver(msg *SignedMessage, addr *net.UDPAddr) {
+	p := r.discovery
+
+	serverKey := p.rendezvousServerKey(addr)
+	if serverKey == nil {
+		fmt.Printf("Apresentação de peer de origem desconhecida ignorada: %s\n", addr.String())
+		return
+	}
+	if !msg.SignerKey.Equal(serverKey) {
+		fmt.Printf("Apresentação do rendezvous %s ignorada: assinatura não é a do servidor\n", addr.String())
+		return
+	}
+
+	var introduction RendezvousPeer
+	if err := msg.Decode(&introduction); err != nil {
+		fmt.Printf("Apresentação inválida do rendezvous %s: %v\n", addr.String(), err)
+		return
+	}
+
+	// O registro repassado é verificado com a assinatura do próprio nó, não a do servidor
+	registration, err := DecodeMessage(introduction.Registration)
+	if err != nil || registration.Type != MsgRendezvousRegister {
+		fmt.Printf("Registro inválido recebido do rendezvous %s: %v\n", addr.String(), err)
+		return
+	}
+	if registration.SignerKey.Equal(p.signingKey.Public()) {
+		return
+	}
+	if age := time.Since(registration.Timestamp); age > MaxClockSkew || age < -MaxClockSkew {
+		fmt.Printf("Registro desatualizado recebido do rendezvous %s\n", addr.String())
+		return
+	}
+
+	observed, err := net.ResolveUDPAddr("udp", introduction.ObservedAddr)
+	if err != nil {
+		fmt.Printf("Endereço observado inválido recebido do rendezvous %s: %v\n", addr.String(), err)
+		return
+	}
+
+	// Atrás de NAT a porta de descoberta real é a que o servidor observou
+	publishResult(r.results, DiscoveryResult{
+		Backend:       BackendRendezvous,
+		Message:       registration,
+		Addr:          observed,
+		DiscoveryAddr: observed.String(),
+	}, r.stopChan)
+
+	// Enviar um anúncio direto abre o mapeamento no nosso NAT para os pacotes do peer
+	p.sendAnnouncementTo(observed)
+}
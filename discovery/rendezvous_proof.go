@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+)
+
+// rendezvousProofContext separa a prova de registro de outros usos do segredo compartilhado
+const rendezvousProofContext = "p2p-vpn/rendezvous-register/v1"
+
+// curve25519Prime é o primo 2^255 - 19 das curvas Ed25519 e Curve25519
+var curve25519Prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// RendezvousRegistration é o registro de um nó no servidor de rendezvous: o anúncio do nó e a
+// prova de que quem assina o registro possui a chave privada WireGuard anunciada. Os campos do
+// anúncio ficam no mesmo nível do JSON, de modo que o registro repassado aos peers continua
+// sendo lido como um Announcement.
+// RendezvousRegistration is a node's registration on the rendezvous server: its announcement plus
+// the proof that the signer owns the announced WireGuard private key
+// RendezvousRegistration es el registro de un nodo en el servidor de rendezvous: su anuncio y la
+// prueba de que quien firma posee la clave privada WireGuard anunciada
+type RendezvousRegistration struct {
+	Announcement
+	Proof []byte `json:"proof"` // HMAC com o segredo X25519 entre a chave WireGuard e o servidor
+}
+
+// rendezvousServer é um servidor de rendezvous configurado, com a chave que assina as apresentações
+type rendezvousServer struct {
+	addr *net.UDPAddr
+	key  ed25519.PublicKey
+}
+
+// ParseRendezvousServer interpreta um servidor de rendezvous configurado como chave@host:porta,
+// em que a chave é a chave de assinatura Ed25519 do servidor em base64. Sem porta, usa
+// DefaultRendezvousPort.
+// ParseRendezvousServer parses a rendezvous server configured as key@host:port
+// ParseRendezvousServer interpreta un servidor de rendezvous configurado como clave@host:puerto
+func ParseRendezvousServer(entry string) (string, ed25519.PublicKey, error) {
+	encodedKey, address, found := strings.Cut(strings.TrimSpace(entry), "@")
+	if !found || encodedKey == "" {
+		return "", nil, fmt.Errorf("servidor de rendezvous %s sem chave pública (use chave@host:porta)", entry)
+	}
+
+	key, err := core.ParseSigningPublicKey(encodedKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("chave do servidor de rendezvous %s inválida: %w", address, err)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(DefaultRendezvousPort))
+	}
+	return address, key, nil
+}
+
+// NewRendezvousRegistration cria o registro do nó para o servidor de rendezvous informado. A
+// prova usa o segredo X25519 entre a chave privada WireGuard do nó e a chave do servidor, e
+// fica presa à chave que assina o registro: outro nó não a reaproveita sem a chave WireGuard.
+// NewRendezvousRegistration creates the node's registration for the given rendezvous server
+// NewRendezvousRegistration crea el registro del nodo para el servidor de rendezvous indicado
+func NewRendezvousRegistration(announcement Announcement, privateKey string, signer, server ed25519.PublicKey) (RendezvousRegistration, error) {
+	wgKey, err := parseExchangePrivateKey(privateKey)
+	if err != nil {
+		return RendezvousRegistration{}, err
+	}
+
+	serverKey, err := montgomeryPublicKey(server)
+	if err != nil {
+		return RendezvousRegistration{}, err
+	}
+	shared, err := wgKey.ECDH(serverKey)
+	if err != nil {
+		return RendezvousRegistration{}, fmt.Errorf("erro ao calcular segredo com o servidor: %w", err)
+	}
+
+	return RendezvousRegistration{
+		Announcement: announcement,
+		Proof:        keyProof(rendezvousProofContext, shared, signer, announcement),
+	}, nil
+}
+
+// rendezvousExchangeKey deriva da chave Ed25519 do servidor a chave X25519 equivalente,
+// cujo ponto público é o da chave Ed25519 na forma de Montgomery
+func rendezvousExchangeKey(signingKey ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	digest := sha512.Sum512(signingKey.Seed())
+	return ecdh.X25519().NewPrivateKey(digest[:32])
+}
+
+// montgomeryPublicKey converte uma chave pública Ed25519 na chave X25519 correspondente,
+// u = (1 + y) / (1 - y) mod p
+func montgomeryPublicKey(key ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("chave do servidor de rendezvous inválida")
+	}
+
+	// A coordenada y é codificada em little-endian, com o sinal de x no último bit
+	encoded := make([]byte, len(key))
+	for i := range key {
+		encoded[len(key)-1-i] = key[i]
+	}
+	encoded[0] &= 0x7f
+	y := new(big.Int).SetBytes(encoded)
+
+	one := big.NewInt(1)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, curve25519Prime)
+	if denominator.Sign() == 0 {
+		return nil, fmt.Errorf("chave do servidor de rendezvous inválida")
+	}
+
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, new(big.Int).ModInverse(denominator, curve25519Prime))
+	u.Mod(u, curve25519Prime)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return ecdh.X25519().NewPublicKey(out)
+}
@@ -0,0 +1,378 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// Valores padrão do servidor de rendezvous
+const (
+	DefaultRendezvousPort = 51823
+
+	// RendezvousRegistrationTTL é o tempo que um registro permanece válido sem renovação
+	RendezvousRegistrationTTL = 2 * time.Minute
+)
+
+// RendezvousServer apresenta nós atrás de NAT uns aos outros.
+// Os nós se registram com mensagens de descoberta assinadas que provam a posse da chave
+// WireGuard anunciada; o servidor guarda o endereço público observado de cada um e, quando
+// uma conexão é pedida, envia a ambos os lados o endereço do outro junto com o registro
+// original assinado.
+type RendezvousServer struct {
+	server      *nattraversal.TestServer
+	signingKey  ed25519.PrivateKey
+	exchangeKey *ecdh.PrivateKey // Chave X25519 derivada de signingKey, para conferir as provas
+	replayGuard *ReplayGuard
+
+	// Registros ativos indexados pela chave WireGuard do nó
+	registrations map[string]*rendezvousEntry
+	regMutex      sync.RWMutex
+
+	// Chaves WireGuard autorizadas a se registrar (vazio permite qualquer nó)
+	allowedKeys map[string]bool
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// rendezvousEntry é o registro de um nó no servidor
+type rendezvousEntry struct {
+	announcement Announcement
+	signingKey   string
+	raw          []byte
+	addr         *net.UDPAddr
+	lastSeen     time.Time
+}
+
+// NewRendezvousServer cria um servidor de rendezvous na porta informada.
+// Sem chave de assinatura, uma chave temporária é gerada.
+func NewRendezvousServer(port int, signingKey ed25519.PrivateKey) (*RendezvousServer, error) {
+	if port <= 0 {
+		port = DefaultRendezvousPort
+	}
+
+	if signingKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao gerar chave de assinatura: %w", err)
+		}
+		signingKey = key
+	}
+
+	exchangeKey, err := rendezvousExchangeKey(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao derivar chave de troca: %w", err)
+	}
+
+	server, err := nattraversal.NewTestServer(port)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar servidor UDP: %w", err)
+	}
+
+	rendezvous := &RendezvousServer{
+		server:        server,
+		signingKey:    signingKey,
+		exchangeKey:   exchangeKey,
+		replayGuard:   NewReplayGuard(MaxClockSkew),
+		registrations: make(map[string]*rendezvousEntry),
+		allowedKeys:   make(map[string]bool),
+		stopChan:      make(chan struct{}),
+	}
+	server.SetPacketHandler(rendezvous.handlePacket)
+
+	return rendezvous, nil
+}
+
+// PublicKey retorna a chave pública de assinatura do servidor em base64, que os nós
+// configuram junto com o endereço (chave@host:porta)
+func (r *RendezvousServer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(r.signingKey.Public().(ed25519.PublicKey))
+}
+
+// SetAllowedKeys restringe o registro às chaves WireGuard informadas
+func (r *RendezvousServer) SetAllowedKeys(keys []string) {
+	r.regMutex.Lock()
+	defer r.regMutex.Unlock()
+
+	r.allowedKeys = make(map[string]bool)
+	for _, key := range keys {
+		r.allowedKeys[key] = true
+	}
+}
+
+// SetListener define como o socket do servidor é aberto, por exemplo em uma rede simulada.
+// Deve ser chamado antes de Start.
+func (r *RendezvousServer) SetListener(listen nattraversal.AddrListener) {
+	r.server.SetListener(listen)
+}
+
+// Start inicia o servidor de rendezvous
+func (r *RendezvousServer) Start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running {
+		return fmt.Errorf("o servidor de rendezvous já está em execução")
+	}
+
+	if err := r.server.Start(); err != nil {
+		return err
+	}
+
+	r.running = true
+	go r.maintenanceRoutine()
+
+	return nil
+}
+
+// Stop para o servidor de rendezvous
+func (r *RendezvousServer) Stop() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.running {
+		return nil
+	}
+
+	close(r.stopChan)
+	r.running = false
+
+	return r.server.Stop()
+}
+
+// LocalAddr retorna o endereço em que o servidor está escutando
+func (r *RendezvousServer) LocalAddr() *net.UDPAddr {
+	return r.server.LocalAddr()
+}
+
+// RegisteredNodes retorna a quantidade de nós com registro ativo
+func (r *RendezvousServer) RegisteredNodes() int {
+	r.regMutex.RLock()
+	defer r.regMutex.RUnlock()
+	return len(r.registrations)
+}
+
+// handlePacket processa as mensagens assinadas; o restante segue para os comandos de texto do TestServer
+func (r *RendezvousServer) handlePacket(data []byte, addr *net.UDPAddr) bool {
+	if !bytes.HasPrefix(data, []byte(ProtocolMagic)) {
+		return false
+	}
+
+	// O buffer de leitura é reutilizado pelo servidor
+	data = append([]byte(nil), data...)
+
+	msg, err := DecodeMessage(data)
+	if err != nil {
+		fmt.Printf("Mensagem de rendezvous rejeitada de %s: %v\n", addr.String(), err)
+		return true
+	}
+
+	if err := r.replayGuard.Check(msg); err != nil {
+		if !errors.Is(err, ErrReplayedMessage) {
+			fmt.Printf("Mensagem de rendezvous rejeitada de %s: %v\n", addr.String(), err)
+		}
+		return true
+	}
+
+	switch msg.Type {
+	case MsgRendezvousRegister:
+		r.handleRegister(msg, addr)
+	case MsgRendezvousConnect:
+		r.handleConnect(msg, addr)
+	case MsgRendezvousForward:
+		r.handleForward(msg, addr)
+	default:
+		fmt.Printf("Tipo de mensagem de rendezvous inesperado de %s: %s\n", addr.String(), msg.Type)
+	}
+
+	return true
+}
+
+// handleRegister registra ou renova o endereço observado de um nó. Só é aceito o registro
+// que prova a posse da chave WireGuard anunciada, para que ninguém ocupe a chave de outro nó.
+func (r *RendezvousServer) handleRegister(msg *SignedMessage, addr *net.UDPAddr) {
+	var registration RendezvousRegistration
+	if err := msg.Decode(&registration); err != nil {
+		fmt.Printf("Registro inválido de %s: %v\n", addr.String(), err)
+		return
+	}
+	announcement := registration.Announcement
+
+	if announcement.NodeID == "" || announcement.PublicKey == "" {
+		fmt.Printf("Registro incompleto de %s ignorado\n", addr.String())
+		return
+	}
+
+	if err := verifyKeyProof(rendezvousProofContext, r.exchangeKey, registration.Proof, msg.SignerKey, announcement); err != nil {
+		fmt.Printf("Registro de %s (%s) recusado: %v\n", announcement.NodeID, addr.String(), err)
+		return
+	}
+
+	r.regMutex.Lock()
+	defer r.regMutex.Unlock()
+
+	if len(r.allowedKeys) > 0 && !r.allowedKeys[announcement.PublicKey] {
+		fmt.Printf("Registro de %s (%s) recusado: chave não autorizada\n", announcement.NodeID, addr.String())
+		return
+	}
+
+	// Enquanto o registro estiver ativo, somente a mesma chave de assinatura pode renová-lo
+	entry, exists := r.registrations[announcement.PublicKey]
+	if exists && entry.signingKey != msg.Signer() {
+		fmt.Printf("Registro de %s (%s) recusado: chave de assinatura diferente da registrada\n",
+			announcement.NodeID, addr.String())
+		return
+	}
+
+	if !exists {
+		fmt.Printf("Nó registrado: %s (%s)\n", announcement.NodeID, addr.String())
+	}
+
+	r.registrations[announcement.PublicKey] = &rendezvousEntry{
+		announcement: announcement,
+		signingKey:   msg.Signer(),
+		raw:          msg.Raw,
+		addr:         addr,
+		lastSeen:     time.Now(),
+	}
+}
+
+// handleConnect apresenta o nó solicitante e o nó procurado um ao outro
+func (r *RendezvousServer) handleConnect(msg *SignedMessage, addr *net.UDPAddr) {
+	var request RendezvousConnect
+	if err := msg.Decode(&request); err != nil {
+		fmt.Printf("Pedido de conexão inválido de %s: %v\n", addr.String(), err)
+		return
+	}
+
+	r.regMutex.RLock()
+	var requester *rendezvousEntry
+	for _, entry := range r.registrations {
+		if entry.signingKey == msg.Signer() {
+			requester = entry
+			break
+		}
+	}
+	target := r.registrations[request.PublicKey]
+	r.regMutex.RUnlock()
+
+	if target != nil && time.Since(target.lastSeen) > RendezvousRegistrationTTL {
+		target = nil
+	}
+
+	// Apenas nós registrados podem consultar outros nós
+	if requester == nil {
+		fmt.Printf("Pedido de conexão de %s ignorado: nó não registrado\n", addr.String())
+		return
+	}
+	if target == nil || target == requester {
+		return
+	}
+
+	// O pedido pode chegar de outro socket; responder para o endereço registrado
+	if err := r.sendPeer(target, requester.addr); err != nil {
+		fmt.Printf("Erro ao enviar dados de %s para %s: %v\n", target.announcement.NodeID, requester.announcement.NodeID, err)
+		return
+	}
+	if err := r.sendPeer(requester, target.addr); err != nil {
+		fmt.Printf("Erro ao enviar dados de %s para %s: %v\n", requester.announcement.NodeID, target.announcement.NodeID, err)
+		return
+	}
+
+	fmt.Printf("Conexão facilitada entre %s (%s) e %s (%s)\n",
+		requester.announcement.NodeID, requester.addr.String(),
+		target.announcement.NodeID, target.addr.String())
+}
+
+// handleForward repassa ao nó procurado uma rodada de hole punching de outro nó registrado.
+// O servidor alcança o destino pelo endereço do registro, que o próprio nó mantém aberto.
+func (r *RendezvousServer) handleForward(msg *SignedMessage, addr *net.UDPAddr) {
+	var request RendezvousForward
+	if err := msg.Decode(&request); err != nil {
+		fmt.Printf("Pedido de repasse inválido de %s: %v\n", addr.String(), err)
+		return
+	}
+
+	// Só são repassadas mensagens de hole punching assinadas por quem pediu o repasse
+	inner, err := DecodeMessage(request.Message)
+	if err != nil || inner.Type != MsgPunch || !inner.SignerKey.Equal(msg.SignerKey) {
+		fmt.Printf("Pedido de repasse de %s ignorado: mensagem não é hole punching do remetente\n", addr.String())
+		return
+	}
+
+	r.regMutex.RLock()
+	registered := false
+	for _, entry := range r.registrations {
+		if entry.signingKey == msg.Signer() {
+			registered = true
+			break
+		}
+	}
+	target := r.registrations[request.PublicKey]
+	r.regMutex.RUnlock()
+
+	if !registered {
+		fmt.Printf("Pedido de repasse de %s ignorado: nó não registrado\n", addr.String())
+		return
+	}
+	if target == nil || time.Since(target.lastSeen) > RendezvousRegistrationTTL {
+		return
+	}
+
+	if err := r.server.SendTo(request.Message, target.addr); err != nil {
+		fmt.Printf("Erro ao repassar mensagem para %s: %v\n", target.announcement.NodeID, err)
+	}
+}
+
+// sendPeer envia os dados de contato de um nó para um endereço
+func (r *RendezvousServer) sendPeer(entry *rendezvousEntry, to *net.UDPAddr) error {
+	data, err := EncodeMessage(MsgRendezvousPeer, RendezvousPeer{
+		ObservedAddr: entry.addr.String(),
+		Registration: entry.raw,
+	}, r.signingKey)
+	if err != nil {
+		return err
+	}
+
+	return r.server.SendTo(data, to)
+}
+
+// maintenanceRoutine remove registros expirados
+func (r *RendezvousServer) maintenanceRoutine() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.cleanupExpired()
+			r.replayGuard.Prune()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// cleanupExpired remove nós que não renovaram o registro
+func (r *RendezvousServer) cleanupExpired() {
+	r.regMutex.Lock()
+	defer r.regMutex.Unlock()
+
+	for key, entry := range r.registrations {
+		if time.Since(entry.lastSeen) > RendezvousRegistrationTTL {
+			delete(r.registrations, key)
+			fmt.Printf("Registro expirado: %s (%s)\n", entry.announcement.NodeID, entry.addr.String())
+		}
+	}
+}
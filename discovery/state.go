@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NodeStaleAfter é o tempo sem contato após o qual um nó é esquecido
+const NodeStaleAfter = 24 * time.Hour
+
+// discoveryStateVersion identifica o formato do arquivo de estado
+const discoveryStateVersion = 1
+
+// discoveryState é o conteúdo do arquivo de estado da descoberta
+type discoveryState struct {
+	Version int          `json:"version"`
+	Saved   time.Time    `json:"saved"`
+	Nodes   []cachedNode `json:"nodes"`
+}
+
+// cachedNode é um nó conhecido salvo entre reinícios.
+// LastSeen marca o último contato bem-sucedido pelos endereços salvos.
+type cachedNode struct {
+	NodeID        string     `json:"nodeId"`
+	PublicKey     string     `json:"publicKey"`
+	VirtualIP     string     `json:"virtualIp"`
+	Endpoints     []string   `json:"endpoints,omitempty"`
+	DiscoveryAddr string     `json:"discoveryAddr,omitempty"`
+	SigningKey    string     `json:"signingKey"`
+	Capabilities  Capability `json:"capabilities,omitempty"`
+	LearnedFrom   string     `json:"learnedFrom,omitempty"`
+	Sources       []string   `json:"sources,omitempty"`
+	LastSeen      time.Time  `json:"lastSeen"`
+}
+
+// DiscoveryStatePath retorna o arquivo de estado da descoberta ao lado do arquivo de configuração
+func DiscoveryStatePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "discovery_state.json")
+}
+
+// statePath retorna o arquivo de estado em uso, ou vazio se a configuração não tiver caminho
+func (p *PeerDiscovery) statePath() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.configPath == "" {
+		return ""
+	}
+	return DiscoveryStatePath(p.configPath)
+}
+
+// loadState restaura os nós conhecidos salvos, descartando os inativos como em cleanupStaleNodes.
+// Retorna os nós restaurados que têm endereço de descoberta.
+func (p *PeerDiscovery) loadState(path string) ([]PeerInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler estado da descoberta: %w", err)
+	}
+
+	var state discoveryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("erro ao analisar estado da descoberta: %w", err)
+	}
+	if state.Version != discoveryStateVersion {
+		return nil, fmt.Errorf("versão do estado da descoberta não suportada: %d", state.Version)
+	}
+
+	p.nodesMutex.Lock()
+	defer p.nodesMutex.Unlock()
+
+	var restored []PeerInfo
+	for _, node := range state.Nodes {
+		if node.NodeID == "" || node.PublicKey == "" || node.NodeID == p.nodeID || node.PublicKey == p.publicKey {
+			continue
+		}
+		if time.Since(node.LastSeen) > NodeStaleAfter {
+			continue
+		}
+		if _, exists := p.knownNodes[node.NodeID]; exists {
+			continue
+		}
+
+		peer := &PeerInfo{
+			NodeID:        node.NodeID,
+			PublicKey:     node.PublicKey,
+			VirtualIP:     node.VirtualIP,
+			Endpoints:     node.Endpoints,
+			DiscoveryAddr: node.DiscoveryAddr,
+			SigningKey:    node.SigningKey,
+			Capabilities:  node.Capabilities,
+			LearnedFrom:   node.LearnedFrom,
+			Sources:       node.Sources,
+			LastSeen:      node.LastSeen,
+		}
+		// Chaves de assinatura de peers repassados via PEX não são confiáveis
+		if peer.LearnedFrom != "" {
+			peer.SigningKey = ""
+		}
+		p.knownNodes[node.NodeID] = peer
+
+		if peer.DiscoveryAddr != "" {
+			restored = append(restored, *peer)
+		}
+	}
+
+	if len(restored) > 0 {
+		fmt.Printf("%d peers restaurados do estado da descoberta\n", len(restored))
+	}
+	return restored, nil
+}
+
+// reannounce contata os nós restaurados do estado sem esperar o ciclo de anúncios
+func (p *PeerDiscovery) reannounce(nodes []PeerInfo) {
+	for _, node := range nodes {
+		if node.LearnedFrom == "" {
+			p.greetPeer(node.NodeID, node.DiscoveryAddr)
+			continue
+		}
+
+		if addr, err := net.ResolveUDPAddr("udp", node.DiscoveryAddr); err == nil {
+			p.sendAnnouncementTo(addr)
+		}
+	}
+}
+
+// saveState grava os nós conhecidos no arquivo de estado
+func (p *PeerDiscovery) saveState(path string) error {
+	state := discoveryState{
+		Version: discoveryStateVersion,
+		Saved:   time.Now(),
+		Nodes:   []cachedNode{},
+	}
+
+	p.nodesMutex.RLock()
+	for _, peer := range p.knownNodes {
+		state.Nodes = append(state.Nodes, cachedNode{
+			NodeID:        peer.NodeID,
+			PublicKey:     peer.PublicKey,
+			VirtualIP:     peer.VirtualIP,
+			Endpoints:     peer.Endpoints,
+			DiscoveryAddr: peer.DiscoveryAddr,
+			SigningKey:    peer.SigningKey,
+			Capabilities:  peer.Capabilities,
+			LearnedFrom:   peer.LearnedFrom,
+			Sources:       append([]string(nil), peer.Sources...),
+			LastSeen:      peer.LastSeen,
+		})
+	}
+	p.nodesMutex.RUnlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao codificar estado da descoberta: %w", err)
+	}
+
+	// Gravar em um arquivo temporário e renomear, para não deixar o estado pela metade
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("erro ao salvar estado da descoberta: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("erro ao salvar estado da descoberta: %w", err)
+	}
+	return nil
+}
+
+// persistState grava o estado se houver um caminho configurado
+func (p *PeerDiscovery) persistState() {
+	path := p.statePath()
+	if path == "" {
+		return
+	}
+	if err := p.saveState(path); err != nil {
+		fmt.Printf("Erro ao salvar estado da descoberta: %v\n", err)
+	}
+}
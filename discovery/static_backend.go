@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultAnnounceInterval é o intervalo dos anúncios unicast periódicos
+const DefaultAnnounceInterval = 30 * time.Second
+
+// staticBackend anuncia o nó por unicast aos nós conhecidos e aos peers configurados,
+// e entrega os anúncios que chegam diretamente ao socket de descoberta
+type staticBackend struct {
+	discovery *PeerDiscovery
+	interval  time.Duration
+	results   chan DiscoveryResult
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// newStaticBackend cria o backend de anúncios unicast
+func newStaticBackend(discovery *PeerDiscovery) *staticBackend {
+	return &staticBackend{
+		discovery: discovery,
+		interval:  discovery.announceInterval,
+		results:   make(chan DiscoveryResult, backendResultsBuffer),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Name retorna o nome do backend
+func (s *staticBackend) Name() string {
+	return BackendStatic
+}
+
+// Results retorna o canal dos anúncios recebidos
+func (s *staticBackend) Results() <-chan DiscoveryResult {
+	return s.results
+}
+
+// Start inicia os anúncios periódicos
+func (s *staticBackend) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running {
+		return fmt.Errorf("o backend static já está em execução")
+	}
+
+	s.running = true
+	go s.announceRoutine()
+	return nil
+}
+
+// Stop para os anúncios periódicos
+func (s *staticBackend) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return nil
+	}
+
+	close(s.stopChan)
+	s.running = false
+	return nil
+}
+
+// Announce envia o anúncio atual para os nós conhecidos e os peers configurados
+func (s *staticBackend) Announce() error {
+	return s.discovery.sendAnnouncement()
+}
+
+// announceRoutine envia anúncios unicast periódicos
+func (s *staticBackend) announceRoutine() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Fazer um anúncio inicial
+	if err := s.Announce(); err != nil {
+		fmt.Printf("Erro ao enviar anúncio de descoberta: %v\n", err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Announce(); err != nil {
+				fmt.Printf("Erro ao enviar anúncio de descoberta: %v\n", err)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// deliver entrega um anúncio recebido no socket de descoberta e espera que ele seja
+// processado, para que as mensagens seguintes do mesmo nó (ex: PEX) já o encontrem registrado
+func (s *staticBackend) deliver(msg *SignedMessage, addr *net.UDPAddr) {
+	done := make(chan struct{})
+	publishResult(s.results, DiscoveryResult{Backend: BackendStatic, Message: msg, Addr: addr, done: done}, s.stopChan)
+
+	select {
+	case <-done:
+	case <-s.stopChan:
+	}
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+)
+
+// TrustPolicy decide quais nós descobertos podem ser configurados no WireGuard.
+// É montada a partir de vpn.access na configuração de segurança; sem política,
+// a descoberta aceita qualquer nó com anúncio assinado válido.
+//
+// TrustPolicy decides which discovered nodes may be configured in WireGuard.
+// It is built from vpn.access in the security configuration; without a policy,
+// discovery accepts any node with a valid signed announcement.
+//
+// TrustPolicy decide qué nodos descubiertos pueden configurarse en WireGuard.
+// Se construye a partir de vpn.access en la configuración de seguridad; sin política,
+// el descubrimiento acepta cualquier nodo con un anuncio firmado válido.
+type TrustPolicy struct {
+	// Apenas chaves já presentes em TrustedPeers podem ter endpoints atualizados
+	AuthenticatedOnly bool
+
+	// O anúncio precisa ser coerente com o que está configurado para a chave
+	VerifyPeerKeys bool
+
+	// Redes de origem aceitas (vazio aceita qualquer origem)
+	trustedNets []*net.IPNet
+}
+
+// NewTrustPolicy cria uma política a partir das opções de acesso.
+// trustedIPs aceita endereços IP isolados ou redes em notação CIDR.
+func NewTrustPolicy(trustedIPs []string, authenticatedOnly, verifyPeerKeys bool) (*TrustPolicy, error) {
+	policy := &TrustPolicy{
+		AuthenticatedOnly: authenticatedOnly,
+		VerifyPeerKeys:    verifyPeerKeys,
+	}
+
+	for _, entry := range trustedIPs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("rede confiável inválida %q: %w", entry, err)
+			}
+			policy.trustedNets = append(policy.trustedNets, network)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("IP confiável inválido: %q", entry)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		policy.trustedNets = append(policy.trustedNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return policy, nil
+}
+
+// AllowSource informa se pacotes vindos do IP informado devem ser processados
+func (t *TrustPolicy) AllowSource(ip net.IP) bool {
+	if t == nil || len(t.trustedNets) == 0 {
+		return true
+	}
+
+	for _, network := range t.trustedNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize verifica se um nó descoberto pode ser adicionado ou atualizado no VPN,
+// comparando-o com os peers confiáveis configurados
+func (t *TrustPolicy) Authorize(info *PeerInfo, trusted []core.TrustedPeer) error {
+	if t == nil {
+		return nil
+	}
+
+	if info.DiscoveryAddr != "" {
+		if host, _, err := net.SplitHostPort(info.DiscoveryAddr); err == nil {
+			if ip := net.ParseIP(host); ip != nil && !t.AllowSource(ip) {
+				return fmt.Errorf("origem %s fora dos IPs confiáveis", host)
+			}
+		}
+	}
+
+	var configured *core.TrustedPeer
+	for i := range trusted {
+		if trusted[i].PublicKey == info.PublicKey {
+			configured = &trusted[i]
+			break
+		}
+	}
+
+	if t.AuthenticatedOnly && configured == nil {
+		return fmt.Errorf("chave %s não está entre os peers confiáveis", info.PublicKey)
+	}
+
+	if !t.VerifyPeerKeys {
+		return nil
+	}
+
+	if _, err := DHTKey(info.PublicKey); err != nil {
+		return fmt.Errorf("chave pública inválida: %w", err)
+	}
+
+	// Um nó configurado não pode ser assumido por outra chave
+	for _, peer := range trusted {
+		if peer.NodeID == info.NodeID && peer.PublicKey != info.PublicKey {
+			return fmt.Errorf("nó %s está configurado com outra chave pública", info.NodeID)
+		}
+	}
+
+	if configured != nil {
+		// Peers repassados via PEX chegam sem chave de assinatura, que só o anúncio direto traz
+		if configured.SigningKey != "" && info.SigningKey != "" && configured.SigningKey != info.SigningKey {
+			return fmt.Errorf("chave de assinatura difere da registrada para o peer %s", configured.NodeID)
+		}
+		if configured.VirtualIP != "" && configured.VirtualIP != info.VirtualIP {
+			return fmt.Errorf("IP virtual %s difere do configurado para o peer %s (%s)",
+				info.VirtualIP, configured.NodeID, configured.VirtualIP)
+		}
+	}
+
+	return nil
+}
@@ -5,14 +5,13 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
 	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/p2p-vpn/p2p-vpn/node"
 	"github.com/p2p-vpn/p2p-vpn/platform"
-	"github.com/p2p-vpn/p2p-vpn/security"
 	"github.com/p2p-vpn/p2p-vpn/ui/web"
 )
 
@@ -21,8 +20,10 @@ func main() {
 	listenPort := flag.Int("port", 51820, "Porta local para o serviço WireGuard")
 	discoveryPort := flag.Int("discovery-port", 51821, "Porta para o serviço de descoberta de peers")
 	configPath := flag.String("config", "config.yaml", "Caminho para o arquivo de configuração")
-	securityConfigPath := flag.String("security-config", "config/server_security.yaml", "Caminho para o arquivo de configuração de segurança")
+	securityConfigPath := flag.String("security-config", node.DefaultSecurityConfigPath, "Caminho para o arquivo de configuração de segurança")
 	webPort := flag.String("web-port", "8080", "Porta para a interface web")
+	discoveryFlags := node.RegisterDiscoveryFlags(flag.CommandLine)
+	natFlags := node.RegisterNATFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Inicializar o logger
@@ -41,6 +42,9 @@ func main() {
 		}
 	}
 
+	discoveryFlags.Apply(&config.Discovery)
+	natFlags.Apply(&config.NAT)
+
 	// Verificar a plataforma atual
 	plat, err := platform.GetPlatform()
 	if err != nil {
@@ -78,21 +82,29 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Carregar configuração de segurança
-	fmt.Println("Carregando configuração de segurança...")
-	securityConfig, err := security.LoadSecurityConfig(*securityConfigPath)
+	peerDiscovery.SetWireGuardPort(*listenPort)
+	peerDiscovery.SetConfigPath(*configPath)
+	peerDiscovery.SetInviteStore(discovery.NewInviteStore(discovery.InviteStorePath(*configPath)))
+	pendingPeers := discovery.NewPendingStore(discovery.PendingStorePath(*configPath))
+	peerDiscovery.SetPendingStore(pendingPeers)
+
+	// Carregar configuração de segurança e aplicar o controle de acesso da VPN aos peers descobertos
+	securityConfig, err := node.ApplyTrustPolicy(peerDiscovery, *securityConfigPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Println("Arquivo de configuração de segurança não encontrado. Usando configuração padrão.")
-			// Criar diretório para o arquivo de configuração
-			if err := os.MkdirAll(filepath.Dir(*securityConfigPath), 0755); err != nil {
-				fmt.Printf("Erro ao criar diretório de configuração: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			fmt.Printf("Erro ao carregar configuração de segurança: %v\n", err)
-			os.Exit(1)
-		}
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if securityConfig == nil {
+		// A interface web depende dos certificados e da autenticação da configuração de segurança
+		fmt.Printf("Erro: a interface web exige a configuração de segurança em %s\n", *securityConfigPath)
+		os.Exit(1)
+	}
+
+	// Travessia de NAT, servidor STUN embutido e monitor de rede, validados antes de iniciar os serviços
+	nat, err := node.SetupNAT(config, *listenPort, vpnCore, peerDiscovery)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
 	}
 
 	// Iniciar os serviços
@@ -107,6 +119,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	nat.Start()
+
 	// Iniciar servidor web com HTTPS e autenticação
 	webAddr := fmt.Sprintf("0.0.0.0:%s", *webPort)
 	if securityConfig != nil {
@@ -117,6 +131,9 @@ func main() {
 		ListenAddr:       webAddr,
 		CoreVPN:          vpnCore, // VPNProvider já é aceito aqui
 		Config:           config,
+		PendingPeers:     pendingPeers,
+		NetworkMonitor:   nat.Monitor,
+		NATTraversal:     nat.Traversal,
 		UseHTTPS:         securityConfig != nil && securityConfig.Web.HTTPS.Enabled,
 		TLSConfig:        securityConfig.ToTLSConfig(),
 		JWTSecret:        securityConfig.Web.Auth.JWTSecret,
@@ -146,6 +163,7 @@ func main() {
 
 	// Encerrar os serviços
 	fmt.Println("\nEncerrando...")
+	nat.Stop()
 	peerDiscovery.Stop()
 	vpnCore.Stop()
 	fmt.Println("VPN P2P encerrada com sucesso!")
@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+package nattraversal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Flags de /proc/net/route
+const (
+	routeFlagUp      = 0x1
+	routeFlagGateway = 0x2
+)
+
+// defaultGateway retorna o gateway da rota padrão IPv4, lido de /proc/net/route
+func defaultGateway() (net.IP, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Cabeçalho
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&(routeFlagUp|routeFlagGateway) != routeFlagUp|routeFlagGateway {
+			continue
+		}
+		gateway, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+
+		// O endereço é impresso como o inteiro na ordem de bytes do host
+		ip := make(net.IP, net.IPv4len)
+		binary.NativeEndian.PutUint32(ip, uint32(gateway))
+		return ip, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("nenhuma rota padrão IPv4")
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+package nattraversal
+
+import (
+	"fmt"
+	"net"
+)
+
+// defaultGateway estima o gateway da rota padrão como o primeiro endereço da sub-rede da
+// interface usada para sair da rede local, que é a configuração de quase todo roteador doméstico
+func defaultGateway() (net.IP, error) {
+	// Nenhum pacote é enviado: o dial só escolhe a rota e o endereço de origem
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, fmt.Errorf("sem rota padrão: %w", err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	conn.Close()
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.Equal(local) {
+			continue
+		}
+		gateway := local.Mask(ipNet.Mask).To4()
+		if gateway == nil {
+			break
+		}
+		gateway[3]++
+		if gateway.Equal(local) {
+			break
+		}
+		return gateway, nil
+	}
+	return nil, fmt.Errorf("sub-rede da interface %s não encontrada", local)
+}
@@ -0,0 +1,346 @@
+package nattraversal
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CandidateType identifica a origem de um candidato, como no ICE (RFC 8445)
+type CandidateType string
+
+const (
+	CandidateHost            CandidateType = "host"   // Endereço de uma interface local
+	CandidateServerReflexive CandidateType = "srflx"  // Endpoint público da porta do WireGuard, descoberto por STUN
+	CandidatePortMapped      CandidateType = "mapped" // Endpoint mapeado no gateway (PCP, NAT-PMP ou UPnP)
+	CandidatePeerReflexive   CandidateType = "prflx"  // Endereço de onde o peer foi visto, pelas sondas ou anúncios
+	CandidateRelay           CandidateType = "relay"  // Endereço em um relay TURN ou entre peers
+)
+
+// Temporização das verificações de conectividade
+const (
+	DefaultCheckSettle     = 300 * time.Millisecond // Espera por pares de maior prioridade depois do primeiro válido
+	DefaultConsentInterval = time.Minute            // Intervalo das verificações do par escolhido
+)
+
+// Candidate é um endereço pelo qual a porta WireGuard de um nó pode ser alcançada
+// Candidate is an address through which a node's WireGuard port can be reached
+// Candidate es una dirección por la cual se puede alcanzar el puerto WireGuard de un nodo
+type Candidate struct {
+	Type     CandidateType
+	Address  string // host:porta
+	Priority uint32
+}
+
+// typePreference é a preferência de cada tipo de candidato. O mapeamento no gateway não
+// depende de o peer perfurar o NAT, então fica acima do endpoint descoberto por STUN.
+func typePreference(kind CandidateType) uint32 {
+	switch kind {
+	case CandidateHost:
+		return 126
+	case CandidatePeerReflexive:
+		return 110
+	case CandidatePortMapped:
+		return 105
+	case CandidateServerReflexive:
+		return 100
+	default:
+		return 0
+	}
+}
+
+// CandidatePriority calcula a prioridade de um candidato pela fórmula do ICE, com um único
+// componente. localPreference (0 a 65535) ordena candidatos do mesmo tipo.
+func CandidatePriority(kind CandidateType, localPreference int) uint32 {
+	return typePreference(kind)<<24 | uint32(localPreference&0xffff)<<8 | 255
+}
+
+// SortCandidates ordena os candidatos da maior para a menor prioridade
+func SortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+}
+
+// CandidatesFromEndpoints classifica endpoints (host:porta) anunciados sem tipo, como os de
+// nós anteriores aos candidatos: endereços privados são host, os demais, reflexivos
+func CandidatesFromEndpoints(endpoints []string) []Candidate {
+	var candidates []Candidate
+	for i, endpoint := range endpoints {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		kind := CandidateServerReflexive
+		if ip := net.ParseIP(host); ip != nil && (ip.IsPrivate() || ip.IsLoopback()) {
+			kind = CandidateHost
+		}
+		candidates = append(candidates, Candidate{
+			Type:     kind,
+			Address:  endpoint,
+			Priority: CandidatePriority(kind, 65535-i),
+		})
+	}
+	SortCandidates(candidates)
+	return candidates
+}
+
+// GatherCandidates reúne os candidatos deste nó: os endereços locais informados (host:porta
+// da porta do WireGuard), o endpoint público descoberto por STUN, o mapeamento no gateway e
+// os relays em que o nó recebe tráfego. Os endereços locais também vão nas ofertas das
+// rodadas de verificação. O endpoint público vem do cache; quando ele expira, é redescoberto
+// em segundo plano e entra nos anúncios seguintes.
+func (n *NATTraversal) GatherCandidates(hostEndpoints []string) []Candidate {
+	n.punchMutex.Lock()
+	n.hostEndpoints = append([]string(nil), hostEndpoints...)
+	reflexive := ""
+	if n.punchEndpoint != "" && time.Since(n.punchLearned) < DefaultMappingLifetime*time.Second {
+		reflexive = n.punchEndpoint
+	}
+	n.punchMutex.Unlock()
+
+	if reflexive == "" {
+		go n.refreshReflexive()
+	}
+	return n.localCandidates(reflexive)
+}
+
+// localCandidates monta os candidatos deste nó com o endpoint público informado, sem
+// endereços repetidos (no NAT aberto, o endpoint público é o próprio endereço local)
+func (n *NATTraversal) localCandidates(reflexive string) []Candidate {
+	var candidates []Candidate
+	add := func(kind CandidateType, addresses ...string) {
+		for i, address := range addresses {
+			if address == "" {
+				continue
+			}
+			candidates = append(candidates, Candidate{
+				Type:     kind,
+				Address:  address,
+				Priority: CandidatePriority(kind, 65535-i),
+			})
+		}
+	}
+
+	n.punchMutex.Lock()
+	hosts := n.hostEndpoints
+	n.punchMutex.Unlock()
+	add(CandidateHost, hosts...)
+	add(CandidatePortMapped, n.EndpointCandidates()...)
+	add(CandidateServerReflexive, reflexive)
+
+	n.relayMutex.Lock()
+	if n.relay != nil {
+		add(CandidateRelay, n.relay.RelayedAddress().String())
+	}
+	n.relayMutex.Unlock()
+	if home, ok := n.HomeRelay(); ok {
+		add(CandidateRelay, home.Address)
+	}
+
+	SortCandidates(candidates)
+	unique := candidates[:0]
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if !seen[candidate.Address] {
+			seen[candidate.Address] = true
+			unique = append(unique, candidate)
+		}
+	}
+	return unique
+}
+
+// refreshReflexive redescobre o endpoint público da porta do WireGuard pelo socket das
+// sondas, com o serviço em execução. Atrás de NAT simétrico, esse endpoint só vale para o
+// servidor STUN.
+func (n *NATTraversal) refreshReflexive() {
+	n.mutex.Lock()
+	running := n.running
+	n.mutex.Unlock()
+	if !running || n.GetNATInfo().Type == "symmetric" {
+		return
+	}
+
+	n.punchMutex.Lock()
+	if n.punchGathering {
+		n.punchMutex.Unlock()
+		return
+	}
+	n.punchGathering = true
+	n.punchMutex.Unlock()
+
+	defer func() {
+		n.punchMutex.Lock()
+		n.punchGathering = false
+		n.punchMutex.Unlock()
+	}()
+
+	conn, err := n.acquirePunchConn()
+	if err != nil {
+		return
+	}
+	defer n.releasePunchConn()
+	n.reflexiveEndpoint(conn)
+}
+
+// SetConsentInterval define o intervalo das verificações do par escolhido para cada peer
+func (n *NATTraversal) SetConsentInterval(interval time.Duration) {
+	n.punchMutex.Lock()
+	n.consentInterval = interval
+	n.punchMutex.Unlock()
+
+	select {
+	case n.consentReset <- struct{}{}:
+	default:
+	}
+}
+
+// SelectedCandidate retorna o candidato do peer escolhido pelas verificações de conectividade
+func (n *NATTraversal) SelectedCandidate(nodeID string) (Candidate, bool) {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	candidate, ok := n.selectedPairs[nodeID]
+	return candidate, ok
+}
+
+// ConnectPeerCandidates estabelece o caminho até um peer pelos candidatos dele, como o ICE:
+// combina com o peer uma rodada em que os dois lados sondam os candidatos um do outro pela
+// porta do WireGuard, e este nó, que propôs a rodada, nomeia o par válido de maior prioridade,
+// aplicado ao WireGuard dos dois lados. Os candidatos relay não são sondados: sem par válido,
+// o peer vai para um relay. O par escolhido é verificado a cada SetConsentInterval, e as
+// verificações são refeitas com todos os candidatos quando ele deixa de responder. Sem canal
+// para combinar a rodada, segue como ConnectPeer.
+func (n *NATTraversal) ConnectPeerCandidates(nodeID string, candidates []Candidate) error {
+	candidates = append([]Candidate(nil), candidates...)
+	SortCandidates(candidates)
+	endpoints := fallbackEndpoints(candidates)
+
+	n.punchMutex.Lock()
+	n.peerCandidates[nodeID] = candidates
+	n.punchMutex.Unlock()
+
+	if !n.canPunch(nodeID) {
+		return n.ConnectPeer(nodeID, endpoints)
+	}
+
+	n.punchMutex.Lock()
+	n.peerEndpoints[nodeID] = endpoints
+	n.punchMutex.Unlock()
+
+	fmt.Printf("Verificando %d candidatos do peer %s\n", len(candidates), nodeID)
+	selected, err := n.connectivityChecks(nodeID, candidates, false)
+	if err == nil {
+		fmt.Printf("Par nomeado para o peer %s: %s (%s)\n", nodeID, selected.Address, selected.Type)
+		n.punchMutex.Lock()
+		n.selectedPairs[nodeID] = selected
+		n.punchMutex.Unlock()
+		return nil
+	}
+
+	n.punchMutex.Lock()
+	delete(n.selectedPairs, nodeID)
+	n.punchMutex.Unlock()
+
+	if len(endpoints) == 0 || !n.canRelay(nodeID) {
+		return err
+	}
+	host, portStr, _ := net.SplitHostPort(endpoints[0])
+	port, _ := strconv.Atoi(portStr)
+	fmt.Printf("Verificações de conectividade falharam (%v), recorrendo a relay\n", err)
+	return n.relayConnection(nodeID, host, port)
+}
+
+// fallbackEndpoints lista os endereços dos candidatos sem relay, para caminhos fora das
+// verificações: os públicos primeiro, como o endereço observado, e os da LAN por último
+func fallbackEndpoints(candidates []Candidate) []string {
+	var public, hosts []string
+	for _, candidate := range candidates {
+		switch candidate.Type {
+		case CandidateRelay:
+		case CandidateHost:
+			hosts = append(hosts, candidate.Address)
+		default:
+			public = append(public, candidate.Address)
+		}
+	}
+	return append(public, hosts...)
+}
+
+// connectivityChecks propõe ao peer uma rodada de verificações com os candidatos informados e
+// retorna o par nomeado. Na verificação de consentimento, as respostas do peer não acrescentam
+// candidatos: só o par escolhido é sondado.
+func (n *NATTraversal) connectivityChecks(nodeID string, candidates []Candidate, consent bool) (Candidate, error) {
+	var session *punchSession
+	winner, err := n.proposePunch(nodeID, true, func(s *punchSession) {
+		session = s
+		s.fixed = consent
+		s.addCandidates(candidates)
+	})
+	if err != nil {
+		return Candidate{}, err
+	}
+	return session.candidate(winner), nil
+}
+
+// consentRoutine verifica periodicamente os pares escolhidos
+func (n *NATTraversal) consentRoutine() {
+	for {
+		n.punchMutex.Lock()
+		interval := n.consentInterval
+		n.punchMutex.Unlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+			n.checkConsent()
+		case <-n.consentReset:
+			timer.Stop()
+		case <-n.stopChan:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// checkConsent sonda o par escolhido de cada peer e, se ele não responde mais, refaz as
+// verificações com todos os candidatos. A próxima verificação espera estas terminarem. Os peers alcançados por sockets próprios, atrás de
+// NAT simétrico, ficam com os keepalives do WireGuard, já que a porta prevista não se repete.
+func (n *NATTraversal) checkConsent() {
+	n.punchMutex.Lock()
+	selected := make(map[string]Candidate, len(n.selectedPairs))
+	for nodeID, candidate := range n.selectedPairs {
+		if _, bridged := n.punchBridges[nodeID]; !bridged {
+			selected[nodeID] = candidate
+		}
+	}
+	n.punchMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for nodeID, pair := range selected {
+		wg.Add(1)
+		go func(nodeID string, pair Candidate) {
+			defer wg.Done()
+
+			_, err := n.connectivityChecks(nodeID, []Candidate{pair}, true)
+			if err == nil {
+				return
+			}
+			fmt.Printf("Par %s do peer %s não responde (%v), refazendo as verificações\n", pair.Address, nodeID, err)
+
+			n.punchMutex.Lock()
+			current, ok := n.selectedPairs[nodeID]
+			candidates := n.peerCandidates[nodeID]
+			n.punchMutex.Unlock()
+			if !ok || current != pair {
+				return
+			}
+			if err := n.ConnectPeerCandidates(nodeID, candidates); err != nil {
+				fmt.Printf("Erro ao reconectar ao peer %s: %v\n", nodeID, err)
+			}
+		}(nodeID, pair)
+	}
+	wg.Wait()
+}
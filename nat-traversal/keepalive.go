@@ -0,0 +1,84 @@
+package nattraversal
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// KeepaliveUpdater ajusta o keepalive do WireGuard de um peer ao caminho escolhido para ele.
+// behindNAT indica se o caminho atravessa o NAT deste nó, e mappingLifetime é o tempo de
+// vida medido do mapeamento, zero enquanto não medido. Implementado pelo núcleo da VPN.
+type KeepaliveUpdater interface {
+	UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error
+}
+
+// SetKeepaliveUpdater define quem aplica ao WireGuard o keepalive de cada peer. Os keepalives
+// são aplicados a cada caminho escolhido e reaplicados quando o tempo de vida do mapeamento é
+// medido ou o tipo de NAT muda.
+// SetKeepaliveUpdater sets who applies each peer's WireGuard keepalive
+// SetKeepaliveUpdater define quién aplica el keepalive WireGuard de cada peer
+func (n *NATTraversal) SetKeepaliveUpdater(updater KeepaliveUpdater) {
+	n.keepaliveMutex.Lock()
+	defer n.keepaliveMutex.Unlock()
+	n.keepaliveUpdater = updater
+}
+
+// setPathKeepalive registra o caminho escolhido para o peer e aplica o keepalive dele. lan
+// indica um caminho pela rede local, que não atravessa o NAT.
+func (n *NATTraversal) setPathKeepalive(nodeID string, lan bool) {
+	n.keepaliveMutex.Lock()
+	n.peerPaths[nodeID] = lan
+	updater := n.keepaliveUpdater
+	n.keepaliveMutex.Unlock()
+
+	if updater != nil {
+		n.applyKeepalive(updater, nodeID, lan)
+	}
+}
+
+// refreshKeepalives reaplica o keepalive de todos os caminhos conhecidos, depois de uma
+// mudança no NAT deste nó
+func (n *NATTraversal) refreshKeepalives() {
+	n.keepaliveMutex.Lock()
+	updater := n.keepaliveUpdater
+	paths := make(map[string]bool, len(n.peerPaths))
+	for nodeID, lan := range n.peerPaths {
+		paths[nodeID] = lan
+	}
+	n.keepaliveMutex.Unlock()
+
+	if updater == nil {
+		return
+	}
+	for nodeID, lan := range paths {
+		n.applyKeepalive(updater, nodeID, lan)
+	}
+}
+
+// applyKeepalive informa ao KeepaliveUpdater se o caminho até o peer atravessa o NAT deste nó
+// e o tempo de vida medido do mapeamento. Sem detecção, o caminho é tratado como atrás de NAT.
+func (n *NATTraversal) applyKeepalive(updater KeepaliveUpdater, nodeID string, lan bool) {
+	n.natInfoMutex.RLock()
+	behindNAT := !lan && n.natInfo.Type != "open"
+	lifetime := time.Duration(n.lifetime) * time.Second
+	n.natInfoMutex.RUnlock()
+
+	if err := updater.UpdatePeerKeepalive(nodeID, behindNAT, lifetime); err != nil {
+		fmt.Printf("Erro ao ajustar o keepalive do peer %s: %v\n", nodeID, err)
+	}
+}
+
+// lanCandidate informa se o candidato do peer é um endereço privado da rede local. Um
+// candidato host público, como o de um nó sem NAT, ainda passa pelo NAT deste nó.
+func lanCandidate(candidate Candidate) bool {
+	if candidate.Type != CandidateHost {
+		return false
+	}
+	host, _, err := net.SplitHostPort(candidate.Address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
@@ -0,0 +1,371 @@
+package nattraversal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// NATBehaviorType classifica o comportamento de mapeamento ou de filtragem de um NAT (RFC 4787)
+// NATBehaviorType classifies the mapping or filtering behavior of a NAT (RFC 4787)
+// NATBehaviorType clasifica el comportamiento de mapeo o de filtrado de un NAT (RFC 4787)
+type NATBehaviorType int
+
+const (
+	BehaviorUnknown              NATBehaviorType = iota
+	BehaviorEndpointIndependent                  // O mesmo para qualquer destino
+	BehaviorAddressDependent                     // Depende do IP do destino
+	BehaviorAddressPortDependent                 // Depende do IP e da porta do destino
+)
+
+// String retorna a representação em string do comportamento
+func (b NATBehaviorType) String() string {
+	switch b {
+	case BehaviorEndpointIndependent:
+		return "independente do endpoint"
+	case BehaviorAddressDependent:
+		return "dependente do endereço"
+	case BehaviorAddressPortDependent:
+		return "dependente do endereço e da porta"
+	default:
+		return "desconhecido"
+	}
+}
+
+// Ociosidades padrão testadas ao medir o tempo de vida de um mapeamento. NATs domésticos
+// costumam expirar mapeamentos UDP entre 30 segundos e alguns minutos.
+const (
+	DefaultMappingProbeMin = 15 * time.Second
+	DefaultMappingProbeMax = DefaultMappingLifetime * time.Second
+)
+
+// ErrRFC5780Unsupported indica que o servidor STUN não informa OTHER-ADDRESS e por isso
+// não permite os testes de comportamento do RFC 5780
+var ErrRFC5780Unsupported = errors.New("servidor STUN não suporta RFC 5780")
+
+// PacketListener abre um novo socket UDP local. Os testes de comportamento precisam de
+// sockets novos, cujos mapeamentos ainda não foram afetados por testes anteriores.
+type PacketListener func() (net.PacketConn, error)
+
+// ListenUDP é o PacketListener padrão, que abre um socket UDP em uma porta efêmera
+func ListenUDP() (net.PacketConn, error) {
+	return net.ListenPacket("udp", ":0")
+}
+
+// AddrListener abre um socket UDP no endereço informado; IP não especificado ou porta zero
+// deixam a escolha para a rede. Servidores e a descoberta o aceitam para rodar sobre redes
+// simuladas.
+type AddrListener func(addr *net.UDPAddr) (net.PacketConn, error)
+
+// ListenUDPAddr é o AddrListener padrão, que abre um socket UDP do sistema
+func ListenUDPAddr(addr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NATBehavior é o resultado dos testes de comportamento do RFC 5780
+// NATBehavior is the result of the RFC 5780 behavior tests
+// NATBehavior es el resultado de las pruebas de comportamiento del RFC 5780
+type NATBehavior struct {
+	Local           *net.UDPAddr    // Endereço local do socket testado
+	Mapped          *net.UDPAddr    // Endereço público visto pelo servidor primário
+	NATPresent      bool            // Se o endereço mapeado difere do local
+	Mapping         NATBehaviorType // Comportamento de mapeamento
+	Filtering       NATBehaviorType // Comportamento de filtragem
+	Hairpinning     bool            // Se o NAT entrega pacotes enviados ao próprio endereço público
+	MappingLifetime time.Duration   // Tempo de vida medido do mapeamento, zero se não medido
+}
+
+// NATType converte o comportamento medido na classificação clássica do RFC 3489
+func (b *NATBehavior) NATType() NATType {
+	switch {
+	case !b.NATPresent:
+		return NATOpen
+	case b.Mapping == BehaviorUnknown:
+		return NATUnknown
+	case b.Mapping != BehaviorEndpointIndependent:
+		return NATSymmetric
+	case b.Filtering == BehaviorEndpointIndependent:
+		return NATFullCone
+	case b.Filtering == BehaviorAddressDependent:
+		return NATRestrictedCone
+	case b.Filtering == BehaviorAddressPortDependent:
+		return NATPortRestricted
+	default:
+		return NATUnknown
+	}
+}
+
+// TypeName retorna o nome do tipo de NAT usado em NATInfo.Type
+func (b *NATBehavior) TypeName() string {
+	switch b.NATType() {
+	case NATOpen:
+		return "open"
+	case NATFullCone:
+		return "full-cone"
+	case NATRestrictedCone:
+		return "restricted-cone"
+	case NATPortRestricted:
+		return "port-restricted"
+	case NATSymmetric:
+		return "symmetric"
+	default:
+		return "unknown"
+	}
+}
+
+// DiscoverBehavior executa os testes de mapeamento, filtragem e hairpinning do RFC 5780
+// contra um servidor que informe OTHER-ADDRESS e aceite CHANGE-REQUEST
+func (c *STUNClient) DiscoverBehavior(listen PacketListener, server STUNServer) (*NATBehavior, error) {
+	primary, err := net.ResolveUDPAddr("udp", stunServerAddr(server))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao resolver servidor STUN: %w", err)
+	}
+
+	conn, err := listen()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar socket para teste de comportamento: %w", err)
+	}
+	defer conn.Close()
+
+	// Teste de mapeamento I: endereço primário
+	first, err := c.bindAddr(conn, primary, nil)
+	if err != nil {
+		return nil, err
+	}
+	other := first.OtherAddress
+	if other == nil || other.IP.Equal(primary.IP) || other.Port == primary.Port {
+		return nil, ErrRFC5780Unsupported
+	}
+
+	behavior := &NATBehavior{Mapped: first.Mapped}
+	behavior.Local, _ = conn.LocalAddr().(*net.UDPAddr)
+	behavior.NATPresent = !isLocalEndpoint(first.Mapped, behavior.Local)
+
+	// Teste de mapeamento II: IP alternativo, porta primária
+	second, err := c.bindAddr(conn, &net.UDPAddr{IP: other.IP, Port: primary.Port}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("erro no teste de mapeamento com IP alternativo: %w", err)
+	}
+	if sameUDPAddr(second.Mapped, first.Mapped) {
+		behavior.Mapping = BehaviorEndpointIndependent
+	} else {
+		// Teste de mapeamento III: IP e porta alternativos
+		third, err := c.bindAddr(conn, other, nil)
+		if err != nil {
+			return nil, fmt.Errorf("erro no teste de mapeamento com porta alternativa: %w", err)
+		}
+		if sameUDPAddr(third.Mapped, second.Mapped) {
+			behavior.Mapping = BehaviorAddressDependent
+		} else {
+			behavior.Mapping = BehaviorAddressPortDependent
+		}
+	}
+
+	behavior.Filtering, err = c.discoverFiltering(listen, primary)
+	if err != nil {
+		return nil, err
+	}
+
+	// O teste de hairpinning fica por último porque envia ao próprio endereço público
+	behavior.Hairpinning = c.testHairpinning(conn, first.Mapped)
+
+	fmt.Printf("Comportamento do NAT: mapeamento %s, filtragem %s, hairpinning=%v\n",
+		behavior.Mapping, behavior.Filtering, behavior.Hairpinning)
+	return behavior, nil
+}
+
+// discoverFiltering executa os testes de filtragem em um socket novo, para que os destinos
+// contatados nos testes de mapeamento não abram o filtro
+func (c *STUNClient) discoverFiltering(listen PacketListener, primary *net.UDPAddr) (NATBehaviorType, error) {
+	conn, err := listen()
+	if err != nil {
+		return BehaviorUnknown, fmt.Errorf("erro ao criar socket para teste de filtragem: %w", err)
+	}
+	defer conn.Close()
+
+	// Teste de filtragem I: cria o mapeamento contatando apenas o endereço primário
+	if _, err := c.bindAddr(conn, primary, nil); err != nil {
+		return BehaviorUnknown, err
+	}
+
+	// Teste de filtragem II: resposta de outro IP e outra porta
+	_, err = c.bindAddr(conn, primary, []stunAttribute{changeRequest(true, true)})
+	if err == nil {
+		return BehaviorEndpointIndependent, nil
+	}
+	if !errors.Is(err, ErrSTUNTimeout) {
+		return BehaviorUnknown, fmt.Errorf("erro no teste de filtragem: %w", err)
+	}
+
+	// Teste de filtragem III: resposta do mesmo IP, de outra porta
+	_, err = c.bindAddr(conn, primary, []stunAttribute{changeRequest(false, true)})
+	if err == nil {
+		return BehaviorAddressDependent, nil
+	}
+	if !errors.Is(err, ErrSTUNTimeout) {
+		return BehaviorUnknown, fmt.Errorf("erro no teste de filtragem: %w", err)
+	}
+	return BehaviorAddressPortDependent, nil
+}
+
+// testHairpinning envia uma requisição ao próprio endereço público e verifica se ela volta
+func (c *STUNClient) testHairpinning(conn net.PacketConn, mapped *net.UDPAddr) bool {
+	transactionID, err := newSTUNTransactionID()
+	if err != nil {
+		return false
+	}
+	request := &stunMessage{Type: stunBindingRequest, TransactionID: transactionID}
+
+	_, err = c.awaitTransaction(conn, conn, mapped, request)
+	return err == nil
+}
+
+// MeasureMappingLifetime mede por quanto tempo um mapeamento ocioso continua válido.
+// A cada rodada o mapeamento é renovado, fica ocioso pelo intervalo e é testado com uma
+// requisição de outro socket que pede a resposta na porta mapeada (RESPONSE-PORT). O
+// intervalo dobra a partir de minIdle até maxIdle; depois, uma busca binária entre o maior
+// intervalo que o mapeamento sobreviveu e o primeiro que ele não sobreviveu refina o
+// resultado até um quarto de minIdle. O resultado é o maior intervalo sobrevivido, ou zero
+// se nem o menor testado sobreviveu. Se a medição for interrompida, o erro acompanha o
+// melhor resultado obtido até ali.
+func (c *STUNClient) MeasureMappingLifetime(listen PacketListener, server STUNServer, minIdle, maxIdle time.Duration, stop <-chan struct{}) (time.Duration, error) {
+	primary, err := net.ResolveUDPAddr("udp", stunServerAddr(server))
+	if err != nil {
+		return 0, fmt.Errorf("erro ao resolver servidor STUN: %w", err)
+	}
+
+	conn, err := listen()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao criar socket para medir o mapeamento: %w", err)
+	}
+	defer conn.Close()
+
+	probe, err := listen()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao criar socket de sonda: %w", err)
+	}
+	defer probe.Close()
+
+	// Intervalos crescentes até o primeiro que o mapeamento não sobrevive
+	var lifetime, expired time.Duration
+	for idle := minIdle; idle <= maxIdle; idle *= 2 {
+		alive, err := c.probeMapping(conn, probe, primary, idle, stop)
+		if err != nil {
+			return lifetime, err
+		}
+		if !alive {
+			expired = idle
+			break
+		}
+		lifetime = idle
+	}
+	if expired == 0 {
+		return lifetime, nil
+	}
+
+	// Busca binária entre o último intervalo sobrevivido e o primeiro que expirou
+	resolution := minIdle / 4
+	for expired-lifetime > resolution {
+		idle := lifetime + (expired-lifetime)/2
+		alive, err := c.probeMapping(conn, probe, primary, idle, stop)
+		if err != nil {
+			return lifetime, err
+		}
+		if alive {
+			lifetime = idle
+		} else {
+			expired = idle
+		}
+	}
+
+	return lifetime, nil
+}
+
+// probeMapping renova o mapeamento de conn, espera o intervalo ocioso e verifica, com uma
+// requisição de probe que pede a resposta na porta mapeada, se o mapeamento continua válido
+func (c *STUNClient) probeMapping(conn, probe net.PacketConn, server *net.UDPAddr, idle time.Duration, stop <-chan struct{}) (bool, error) {
+	binding, err := c.bindAddr(conn, server, nil)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case <-time.After(idle):
+	case <-stop:
+		return false, fmt.Errorf("medição do mapeamento interrompida")
+	}
+
+	transactionID, err := newSTUNTransactionID()
+	if err != nil {
+		return false, err
+	}
+	request := &stunMessage{
+		Type:          stunBindingRequest,
+		TransactionID: transactionID,
+		Attributes:    []stunAttribute{responsePort(binding.Mapped.Port)},
+	}
+	if _, err := c.awaitTransaction(probe, conn, server, request); err != nil {
+		if errors.Is(err, ErrSTUNTimeout) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// awaitTransaction envia a mensagem por um socket e espera, em outro (ou no mesmo), uma
+// mensagem da mesma transação, retransmitindo como em roundTrip
+func (c *STUNClient) awaitTransaction(send, receive net.PacketConn, to *net.UDPAddr, msg *stunMessage) (*stunMessage, error) {
+	packet := msg.encode()
+	defer receive.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, 1500)
+	timeout := c.rto
+	for attempt := 0; attempt < c.transmissions; attempt++ {
+		if _, err := send.WriteTo(packet, to); err != nil {
+			return nil, fmt.Errorf("erro ao enviar mensagem STUN: %w", err)
+		}
+
+		receive.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, _, err := receive.ReadFrom(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, fmt.Errorf("erro ao receber mensagem STUN: %w", err)
+			}
+
+			reply, err := parseSTUNMessage(buffer[:n])
+			if err == nil && reply.TransactionID == msg.TransactionID {
+				return reply, nil
+			}
+		}
+
+		timeout *= 2
+	}
+
+	return nil, ErrSTUNTimeout
+}
+
+// isLocalEndpoint indica se o endereço mapeado é o próprio endereço local do socket
+func isLocalEndpoint(mapped, local *net.UDPAddr) bool {
+	if local == nil || mapped.Port != local.Port {
+		return false
+	}
+	if local.IP == nil || local.IP.IsUnspecified() {
+		return isLocalIP(mapped.IP)
+	}
+	return mapped.IP.Equal(local.IP)
+}
+
+// sameUDPAddr compara dois endereços UDP
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -1,6 +1,7 @@
 package nattraversal
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -50,6 +51,12 @@ type DiagnosticResult struct {
 	STUNServer     string    // Servidor STUN usado
 	TestTime       time.Time // Quando o teste foi realizado
 	ReachableTests []bool    // Resultados de testes de alcançabilidade
+	
+	// Comportamento medido pelos testes do RFC 5780, desconhecido se o servidor não os suporta
+	Mapping         NATBehaviorType // Comportamento de mapeamento
+	Filtering       NATBehaviorType // Comportamento de filtragem
+	Hairpinning     bool            // Se o NAT entrega pacotes enviados ao próprio endereço público
+	MappingLifetime time.Duration   // Tempo de vida medido do mapeamento, zero se não medido
 }
 
 // NATDiagnostic implementa diagnóstico de NAT usando STUN
@@ -57,6 +64,12 @@ type DiagnosticResult struct {
 // NATDiagnostic implementa el diagnóstico de NAT usando STUN
 type NATDiagnostic struct {
 	stunServers []STUNServer
+	client      *STUNClient
+	listen      PacketListener
+	
+	// Ociosidades testadas ao medir o tempo de vida do mapeamento; zero para não medir
+	probeMin    time.Duration
+	probeMax    time.Duration
 }
 
 // NewNATDiagnostic cria uma nova instância de diagnóstico
@@ -69,9 +82,30 @@ func NewNATDiagnostic(stunServers []STUNServer) *NATDiagnostic {
 	
 	return &NATDiagnostic{
 		stunServers: stunServers,
+		client:      NewSTUNClient(stunServers),
+		listen:      ListenUDP,
 	}
 }
 
+// SetPacketListener define como os sockets dos testes são abertos, permitindo diagnosticar
+// por trás de um NATSimulator
+func (d *NATDiagnostic) SetPacketListener(listen PacketListener) {
+	d.listen = listen
+}
+
+// SetRetransmission ajusta os temporizadores das transações STUN do diagnóstico. Os testes
+// de filtragem esperam todas as retransmissões quando o NAT descarta a resposta.
+func (d *NATDiagnostic) SetRetransmission(rto time.Duration, transmissions int) {
+	d.client.SetRetransmission(rto, transmissions)
+}
+
+// SetMappingProbe faz o diagnóstico medir o tempo de vida do mapeamento, testando
+// ociosidades de minIdle até maxIdle. Com os valores padrão a medição leva vários minutos.
+func (d *NATDiagnostic) SetMappingProbe(minIdle, maxIdle time.Duration) {
+	d.probeMin = minIdle
+	d.probeMax = maxIdle
+}
+
 // RunDiagnosis executa uma série de testes para determinar o tipo de NAT
 // RunDiagnosis runs a series of tests to determine the NAT type
 // RunDiagnosis ejecuta una serie de pruebas para determinar el tipo de NAT
@@ -82,15 +116,8 @@ func (d *NATDiagnostic) RunDiagnosis() (*DiagnosticResult, error) {
 		NATType:        NATUnknown,
 	}
 	
-	// Obter endereço IP local
-	localIP, err := getLocalIP()
-	if err != nil {
-		return nil, fmt.Errorf("erro ao obter IP local: %w", err)
-	}
-	result.LocalIP = localIP
-	
 	// Criar socket UDP local
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	conn, err := d.listen()
 	if err != nil {
 		return nil, fmt.Errorf("erro ao criar socket UDP: %w", err)
 	}
@@ -99,41 +126,40 @@ func (d *NATDiagnostic) RunDiagnosis() (*DiagnosticResult, error) {
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
 	result.LocalPort = localAddr.Port
 	
-	fmt.Printf("Usando socket UDP local %s:%d\n", localIP, result.LocalPort)
+	// Obter endereço IP local
+	if localAddr.IP == nil || localAddr.IP.IsUnspecified() {
+		localIP, err := getLocalIP()
+		if err != nil {
+			return nil, fmt.Errorf("erro ao obter IP local: %w", err)
+		}
+		result.LocalIP = localIP
+	} else {
+		result.LocalIP = localAddr.IP.String()
+	}
+	
+	fmt.Printf("Usando socket UDP local %s:%d\n", result.LocalIP, result.LocalPort)
 	
-	// Teste 1: Detectar o endereço IP público e porta usando o primeiro servidor STUN
+	// Teste 1: Detectar o endereço IP público e porta via STUN
 	if len(d.stunServers) == 0 {
 		return nil, fmt.Errorf("nenhum servidor STUN disponível")
 	}
 	
-	stunServer := d.stunServers[0]
-	fmt.Printf("Usando servidor STUN primário: %s:%d\n", stunServer.Address, stunServer.Port)
-	result.STUNServer = fmt.Sprintf("%s:%d", stunServer.Address, stunServer.Port)
-	
-	publicIP, publicPort, err := d.detectPublicAddress(conn, stunServer)
+	// O cliente tenta os servidores na ordem, passando ao próximo quando um não responde
+	binding, err := d.client.Bind(conn)
 	if err != nil {
-		fmt.Printf("Erro ao detectar endereço público com servidor STUN primário: %v\n", err)
-		
-		// Tentar o próximo servidor STUN
-		if len(d.stunServers) > 1 {
-			stunServer = d.stunServers[1]
-			fmt.Printf("Usando servidor STUN alternativo: %s:%d\n", stunServer.Address, stunServer.Port)
-			result.STUNServer = fmt.Sprintf("%s:%d", stunServer.Address, stunServer.Port)
-			
-			publicIP, publicPort, err = d.detectPublicAddress(conn, stunServer)
-			if err != nil {
-				return nil, fmt.Errorf("erro ao detectar endereço público: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("erro ao detectar endereço público: %w", err)
-		}
+		return nil, fmt.Errorf("erro ao detectar endereço público: %w", err)
 	}
+	result.STUNServer = binding.Server
+	fmt.Printf("Usando servidor STUN: %s\n", binding.Server)
+	
+	stunServer := d.serverByAddr(binding.Server)
+	publicIP, publicPort := binding.Mapped.IP.String(), binding.Mapped.Port
 	
 	result.PublicIP = publicIP
 	result.PublicPort = publicPort
 	
 	// Se o IP público for igual ao IP local, provavelmente estamos em uma rede com IP público direto
-	if publicIP == localIP {
+	if publicIP == result.LocalIP {
 		result.NATType = NATOpen
 		fmt.Println("Detectado: Rede com IP público direto (sem NAT)")
 		return result, nil
@@ -141,122 +167,77 @@ func (d *NATDiagnostic) RunDiagnosis() (*DiagnosticResult, error) {
 	
 	fmt.Printf("IP público detectado: %s:%d\n", publicIP, publicPort)
 	
-	// Teste 2: Verificar a consistência da porta mapeada com diferentes servidores
-	// Este teste diferencia NAT simétrico de outros tipos
-	portConsistent := true
-	if len(d.stunServers) > 1 {
-		stunServer2 := d.stunServers[1]
-		_, publicPort2, err := d.detectPublicAddress(conn, stunServer2)
-		if err != nil {
-			fmt.Printf("Erro no teste de consistência de porta: %v\n", err)
-		} else {
-			portConsistent = (publicPort == publicPort2)
-			fmt.Printf("Teste de consistência de porta: %v (porta1=%d, porta2=%d)\n", 
-				portConsistent, publicPort, publicPort2)
+	// Testes 2 a 4: comportamento de mapeamento, filtragem e hairpinning (RFC 5780)
+	behavior, err := d.client.DiscoverBehavior(d.listen, stunServer)
+	if err != nil {
+		if !errors.Is(err, ErrRFC5780Unsupported) {
+			return nil, fmt.Errorf("erro nos testes de comportamento do NAT: %w", err)
 		}
+		fmt.Printf("Servidor %s não suporta RFC 5780; apenas a consistência de porta será testada\n", binding.Server)
+		d.checkPortConsistency(conn, binding, result)
+		return result, nil
 	}
 	
-	// Teste 3: Tentar receber dados de um endpoint não contatado previamente
-	// Este teste diferencia Full Cone de Restricted Cone
-	canReceiveFromAny := d.testReceiveFromUnknown(conn, d.stunServers)
-	result.ReachableTests[0] = canReceiveFromAny
-	fmt.Printf("Teste de recebimento de endpoint desconhecido: %v\n", canReceiveFromAny)
+	result.NATType = behavior.NATType()
+	result.Mapping = behavior.Mapping
+	result.Filtering = behavior.Filtering
+	result.Hairpinning = behavior.Hairpinning
+	result.ReachableTests[0] = behavior.Filtering == BehaviorEndpointIndependent
+	result.ReachableTests[1] = behavior.Filtering == BehaviorEndpointIndependent ||
+		behavior.Filtering == BehaviorAddressDependent
+	result.ReachableTests[2] = behavior.Hairpinning
 	
-	// Teste 4: Tentar receber dados da mesma IP mas porta diferente
-	// Este teste diferencia Restricted Cone de Port Restricted
-	canReceiveFromSameIPDiffPort := d.testReceiveFromSameIPDiffPort(conn, stunServer)
-	result.ReachableTests[1] = canReceiveFromSameIPDiffPort
-	fmt.Printf("Teste de recebimento de mesma IP, porta diferente: %v\n", canReceiveFromSameIPDiffPort)
+	fmt.Printf("Teste de recebimento de endpoint desconhecido: %v\n", result.ReachableTests[0])
+	fmt.Printf("Teste de recebimento de mesma IP, porta diferente: %v\n", result.ReachableTests[1])
+	fmt.Printf("Teste de hairpinning: %v\n", behavior.Hairpinning)
+	fmt.Printf("Detectado: %s\n", result.NATType)
 	
-	// Determinar o tipo de NAT com base nos resultados dos testes
-	if !portConsistent {
-		result.NATType = NATSymmetric
-		fmt.Println("Detectado: NAT Simétrico")
-	} else if canReceiveFromAny {
-		result.NATType = NATFullCone
-		fmt.Println("Detectado: NAT Full Cone")
-	} else if canReceiveFromSameIPDiffPort {
-		result.NATType = NATRestrictedCone
-		fmt.Println("Detectado: NAT Restricted Cone")
-	} else {
-		result.NATType = NATPortRestricted
-		fmt.Println("Detectado: NAT Port Restricted")
+	// Teste 5: tempo de vida do mapeamento ocioso, apenas quando solicitado
+	if d.probeMin > 0 && d.probeMax >= d.probeMin {
+		lifetime, err := d.client.MeasureMappingLifetime(d.listen, stunServer, d.probeMin, d.probeMax, nil)
+		if err != nil {
+			fmt.Printf("Erro ao medir o tempo de vida do mapeamento: %v\n", err)
+		} else {
+			result.MappingLifetime = lifetime
+			fmt.Printf("Tempo de vida do mapeamento: pelo menos %s\n", lifetime)
+		}
 	}
 	
 	return result, nil
 }
 
-// detectPublicAddress detecta o endereço IP público e porta usando STUN
-func (d *NATDiagnostic) detectPublicAddress(conn *net.UDPConn, stunServer STUNServer) (string, int, error) {
-	// Implementação real usaria o protocolo STUN
-	// Por ora, usaremos uma versão simplificada
-	
-	stunAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", stunServer.Address, stunServer.Port))
-	if err != nil {
-		return "", 0, fmt.Errorf("erro ao resolver endereço STUN: %w", err)
+// checkPortConsistency compara a porta mapeada por dois servidores diferentes. Sem os testes
+// do RFC 5780 só é possível distinguir o NAT simétrico; os demais são tratados como port
+// restricted, o caso mais comum que ainda permite hole punching.
+func (d *NATDiagnostic) checkPortConsistency(conn net.PacketConn, binding *STUNBinding, result *DiagnosticResult) {
+	result.NATType = NATPortRestricted
+	if len(d.stunServers) < 2 {
+		return
 	}
 	
-	// Enviar solicitação STUN simplificada
-	// Na implementação real, usaríamos pacotes STUN formatados corretamente
-	_, err = conn.WriteToUDP([]byte("STUN-REQUEST"), stunAddr)
+	other, err := d.client.BindOther(conn, binding.Server)
 	if err != nil {
-		return "", 0, fmt.Errorf("erro ao enviar solicitação STUN: %w", err)
+		fmt.Printf("Erro no teste de consistência de porta: %v\n", err)
+		return
 	}
 	
-	// Configurar timeout para recebimento
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	
-	// Receber resposta
-	buffer := make([]byte, 1024)
-	n, _, err := conn.ReadFromUDP(buffer)
-	if err != nil {
-		return "", 0, fmt.Errorf("erro ao receber resposta STUN: %w", err)
-	}
-	
-	// Parse da resposta simplificada
-	// Em uma implementação real, teríamos que analisar um pacote STUN real
-	response := string(buffer[:n])
-	
-	// Formato simulado: "IP:PORTA"
-	// Na implementação real, extrairíamos esses dados do pacote STUN
-	var ip string
-	var port int
-	_, err = fmt.Sscanf(response, "%s:%d", &ip, &port)
-	if err != nil {
-		return "", 0, fmt.Errorf("erro ao analisar resposta STUN: %w", err)
+	portConsistent := binding.Mapped.Port == other.Mapped.Port
+	fmt.Printf("Teste de consistência de porta: %v (porta1=%d, porta2=%d)\n", 
+		portConsistent, binding.Mapped.Port, other.Mapped.Port)
+	if !portConsistent {
+		result.NATType = NATSymmetric
+		result.Mapping = BehaviorAddressPortDependent
 	}
-	
-	return ip, port, nil
 }
 
-// testReceiveFromUnknown testa se podemos receber pacotes de um endpoint desconhecido
-func (d *NATDiagnostic) testReceiveFromUnknown(conn *net.UDPConn, stunServers []STUNServer) bool {
-	// Em uma implementação real, solicitaríamos a um servidor STUN
-	// que enviasse um pacote de um endpoint desconhecido
-	
-	// Configurar timeout para recebimento
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	
-	// Esperar por um pacote
-	buffer := make([]byte, 1024)
-	_, _, err := conn.ReadFromUDP(buffer)
-	
-	return err == nil
-}
-
-// testReceiveFromSameIPDiffPort testa se podemos receber de uma IP conhecida mas porta diferente
-func (d *NATDiagnostic) testReceiveFromSameIPDiffPort(conn *net.UDPConn, stunServer STUNServer) bool {
-	// Em uma implementação real, solicitaríamos ao servidor STUN
-	// que enviasse um pacote da mesma IP, mas de uma porta diferente
-	
-	// Configurar timeout para recebimento
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	
-	// Esperar por um pacote
-	buffer := make([]byte, 1024)
-	_, _, err := conn.ReadFromUDP(buffer)
-	
-	return err == nil
+// serverByAddr retorna o servidor configurado com o endereço informado (host:porta)
+func (d *NATDiagnostic) serverByAddr(addr string) STUNServer {
+	for _, server := range d.stunServers {
+		if stunServerAddr(server) == addr {
+			return server
+		}
+	}
+	return d.stunServers[0]
 }
 
 // getLocalIP obtém o endereço IP local preferido
@@ -25,6 +25,9 @@ type NATMapping struct {
 	ExternalPort int                 // Porta externa atribuída
 	Destinations map[string]struct{} // Conjunto de destinos permitidos (IP:porta)
 	LastActivity time.Time           // Última atividade neste mapeamento
+	
+	conn  net.PacketConn // Socket externo próprio do mapeamento (modo ListenPacket)
+	owner *natPacketConn // Socket interno virtual que recebe o tráfego do mapeamento
 }
 
 // NATSimulator simula diferentes tipos de NAT para testes
@@ -42,12 +45,29 @@ type NATSimulator struct {
 	internalConn  *net.UDPConn           // Socket para tráfego interno
 	
 	nextPort      int                    // Próxima porta externa a ser atribuída
+	portDelta     int                    // Passo das portas dos mapeamentos de ListenPacket
+	portStrategy  NATPortAllocation      // Estratégia de alocação das portas externas
 	portMutex     sync.Mutex             // Mutex para alocação de porta
 	
+	mappingTimeout time.Duration         // Inatividade após a qual um mapeamento expira
+	nextInternal   int                   // Próximo host interno entregue por ListenPacket
+	hosts          map[string]*natPacketConn // Sockets virtuais da rede interna, por endereço
+	hairpinning    bool                  // Se pacotes enviados ao IP externo voltam para a rede interna
+	external       AddrListener          // Abre os sockets dos mapeamentos no lado externo
+	
+	// Degradação do tráfego que atravessa o NAT
+	packetLoss    float64                // Fração dos pacotes descartados
+	latency       time.Duration          // Atraso de cada travessia
+	jitter        time.Duration          // Variação máxima do atraso, para mais ou para menos
+	impairMutex   sync.Mutex             // Mutex para os parâmetros de degradação
+	
 	running       bool                   // Estado do simulador
 	stopChan      chan struct{}          // Canal para sinalizar parada
 }
 
+// DefaultNATMappingTimeout é a inatividade após a qual o simulador remove um mapeamento
+const DefaultNATMappingTimeout = 5 * time.Minute
+
 // NewNATSimulator cria um novo simulador de NAT
 // NewNATSimulator creates a new NAT simulator
 // NewNATSimulator crea un nuevo simulador de NAT
@@ -70,6 +90,10 @@ func NewNATSimulator(natType NATSimulatorType, externalIP string, internalNet st
 		internalNet: ipNet,
 		mappings:    make(map[string]*NATMapping),
 		nextPort:    10000, // Iniciar portas externas a partir de 10000
+		mappingTimeout: DefaultNATMappingTimeout,
+		hosts:       make(map[string]*natPacketConn),
+		hairpinning: true,
+		external:    ListenUDPAddr,
 		stopChan:    make(chan struct{}),
 	}
 	
@@ -121,6 +145,9 @@ func (s *NATSimulator) Start(externalPort, internalPort int) error {
 // Stop stops the NAT simulator
 // Stop detiene el simulador de NAT
 func (s *NATSimulator) Stop() {
+	// Os sockets do modo ListenPacket não dependem de Start
+	s.closePacketMappings(nil)
+	
 	if !s.running {
 		return
 	}
@@ -230,8 +257,11 @@ func (s *NATSimulator) processInternalPacket(data []byte, srcAddr *net.UDPAddr)
 	mapping.LastActivity = time.Now()
 	s.mappingsMutex.Unlock()
 	
-	// Encaminhar o pacote para o destino
-	_, err = s.externalConn.WriteToUDP(data, dstAddr)
+	// Encaminhar o pacote para o destino, com a perda e o atraso configurados
+	err = s.forward(data, func(packet []byte) error {
+		_, err := s.externalConn.WriteToUDP(packet, dstAddr)
+		return err
+	})
 	if err != nil {
 		fmt.Printf("Erro ao encaminhar pacote interno: %v\n", err)
 		return
@@ -253,54 +283,10 @@ func (s *NATSimulator) processExternalPacket(data []byte, srcAddr *net.UDPAddr)
 	var mapping *NATMapping
 	
 	for _, m := range s.mappings {
-		if m.ExternalPort == dstPort {
-			// Verificar restrições com base no tipo de NAT
-			switch s.natType {
-			case SimulateFullCone:
-				// Full Cone: aceita qualquer pacote do exterior para a porta mapeada
-				internalAddr = m.InternalAddr
-				mapping = m
-				break
-				
-			case SimulateRestrictedCone:
-				// Restricted Cone: verifica se o IP de origem já foi contatado
-				found := false
-				
-				for destKey := range m.Destinations {
-					destAddr, _ := net.ResolveUDPAddr("udp", destKey)
-					if destAddr.IP.Equal(srcAddr.IP) {
-						found = true
-						break
-					}
-				}
-				
-				if found {
-					internalAddr = m.InternalAddr
-					mapping = m
-				}
-				break
-				
-			case SimulatePortRestrictedCone:
-				// Port Restricted Cone: verifica IP:porta específica
-				if _, exists := m.Destinations[srcAddr.String()]; exists {
-					internalAddr = m.InternalAddr
-					mapping = m
-				}
-				break
-				
-			case SimulateSymmetric:
-				// Symmetric: verifica mapeamento específico para IP:porta
-				if _, exists := m.Destinations[srcAddr.String()]; exists {
-					internalAddr = m.InternalAddr
-					mapping = m
-				}
-				break
-			}
-			
-			// Se encontrou um mapeamento válido, não precisa continuar procurando
-			if internalAddr != nil {
-				break
-			}
+		if m.ExternalPort == dstPort && s.allowsInbound(m, srcAddr) {
+			internalAddr = m.InternalAddr
+			mapping = m
+			break
 		}
 	}
 	
@@ -318,8 +304,11 @@ func (s *NATSimulator) processExternalPacket(data []byte, srcAddr *net.UDPAddr)
 	mapping.LastActivity = time.Now()
 	s.mappingsMutex.Unlock()
 	
-	// Encaminhar o pacote para o cliente interno
-	_, err := s.internalConn.WriteToUDP(data, internalAddr)
+	// Encaminhar o pacote para o cliente interno, com a perda e o atraso configurados
+	err := s.forward(data, func(packet []byte) error {
+		_, err := s.internalConn.WriteToUDP(packet, internalAddr)
+		return err
+	})
 	if err != nil {
 		fmt.Printf("Erro ao encaminhar pacote externo: %v\n", err)
 		return
@@ -328,20 +317,38 @@ func (s *NATSimulator) processExternalPacket(data []byte, srcAddr *net.UDPAddr)
 	fmt.Printf("Pacote encaminhado: %s -> %s\n", srcAddr.String(), internalAddr.String())
 }
 
+// allowsInbound aplica a filtragem do tipo de NAT simulado a um pacote externo destinado
+// ao mapeamento. Deve ser chamada com mappingsMutex travado, ao menos para leitura.
+func (s *NATSimulator) allowsInbound(m *NATMapping, srcAddr *net.UDPAddr) bool {
+	switch s.natType {
+	case SimulateFullCone:
+		// Full Cone: aceita qualquer pacote do exterior para a porta mapeada
+		return true
+		
+	case SimulateRestrictedCone:
+		// Restricted Cone: verifica se o IP de origem já foi contatado
+		for destKey := range m.Destinations {
+			destAddr, err := net.ResolveUDPAddr("udp", destKey)
+			if err == nil && destAddr.IP.Equal(srcAddr.IP) {
+				return true
+			}
+		}
+		return false
+		
+	default:
+		// Port Restricted Cone e Symmetric: verifica IP:porta específica
+		_, exists := m.Destinations[srcAddr.String()]
+		return exists
+	}
+}
+
 // getOrCreateMapping obtém um mapeamento existente ou cria um novo
 func (s *NATSimulator) getOrCreateMapping(internalAddr *net.UDPAddr, dstAddr *net.UDPAddr) *NATMapping {
 	s.mappingsMutex.Lock()
 	defer s.mappingsMutex.Unlock()
 	
-	var key string
-	
-	// Para NAT simétrico, usar combinação de origem+destino como chave
-	if s.natType == SimulateSymmetric {
-		key = fmt.Sprintf("%s->%s", internalAddr.String(), dstAddr.String())
-	} else {
-		// Para outros tipos, usar apenas o endereço interno
-		key = internalAddr.String()
-	}
+	// Para NAT simétrico, a chave combina origem e destino
+	key := s.mappingKey(internalAddr, dstAddr)
 	
 	// Verificar se já existe um mapeamento
 	if mapping, exists := s.mappings[key]; exists {
@@ -371,7 +378,15 @@ func (s *NATSimulator) getOrCreateMapping(internalAddr *net.UDPAddr, dstAddr *ne
 
 // cleanupMappings remove mapeamentos inativos após um tempo
 func (s *NATSimulator) cleanupMappings() {
-	ticker := time.NewTicker(60 * time.Second)
+	// Com tempos de expiração curtos, a verificação acompanha o tempo configurado
+	interval := 60 * time.Second
+	s.mappingsMutex.RLock()
+	if s.mappingTimeout > 0 && s.mappingTimeout < interval {
+		interval = s.mappingTimeout
+	}
+	s.mappingsMutex.RUnlock()
+	
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	
 	for {
@@ -382,13 +397,12 @@ func (s *NATSimulator) cleanupMappings() {
 			s.mappingsMutex.Lock()
 			
 			now := time.Now()
-			timeout := 5 * time.Minute
 			
 			for key, mapping := range s.mappings {
-				if now.Sub(mapping.LastActivity) > timeout {
+				if now.Sub(mapping.LastActivity) > s.mappingTimeout {
 					fmt.Printf("Removendo mapeamento inativo: %s -> %s:%d\n",
 						mapping.InternalAddr.String(), s.externalIP, mapping.ExternalPort)
-					delete(s.mappings, key)
+					s.removeMapping(key, mapping)
 				}
 			}
 			
@@ -0,0 +1,408 @@
+package nattraversal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// natPacketPort é a porta dos hosts internos virtuais; cada socket recebe um IP próprio
+const natPacketPort = 40000
+
+// natPacket é um pacote entregue a um socket interno virtual
+type natPacket struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// natPacketConn é um socket de um host virtual atrás do simulador. Cada mapeamento criado
+// pelos seus envios ganha um socket UDP real no IP externo do simulador, e os pacotes que
+// chegam a ele passam pela filtragem do tipo de NAT antes de serem entregues.
+type natPacketConn struct {
+	sim   *NATSimulator
+	local *net.UDPAddr
+	inbox chan natPacket
+
+	readDeadline time.Time
+	mutex        sync.Mutex
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// ListenPacket abre um socket em um novo host da rede interna simulada. O tráfego enviado
+// por ele sai de fato pela rede, a partir do IP externo do simulador, com o mapeamento e a
+// filtragem do tipo de NAT configurado. Não depende de Start.
+// ListenPacket opens a socket on a new host of the simulated internal network
+// ListenPacket abre un socket en un nuevo host de la red interna simulada
+func (s *NATSimulator) ListenPacket() (net.PacketConn, error) {
+	ip, err := s.allocateHost()
+	if err != nil {
+		return nil, err
+	}
+	return s.listenPacketAt(&net.UDPAddr{IP: ip, Port: natPacketPort})
+}
+
+// allocateHost reserva o próximo endereço da rede interna simulada
+func (s *NATSimulator) allocateHost() (net.IP, error) {
+	base := s.internalNet.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("ListenPacket requer uma rede interna IPv4")
+	}
+
+	s.mappingsMutex.Lock()
+	s.nextInternal++
+	host := s.nextInternal + 1 // O primeiro endereço fica para o próprio NAT
+	s.mappingsMutex.Unlock()
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+uint32(host))
+	if !s.internalNet.Contains(ip) {
+		return nil, fmt.Errorf("rede interna simulada esgotada")
+	}
+	return ip, nil
+}
+
+// listenPacketAt abre um socket virtual no endereço interno informado, como fazem os NATs
+// encadeados atrás deste para cada mapeamento
+func (s *NATSimulator) listenPacketAt(local *net.UDPAddr) (*natPacketConn, error) {
+	s.mappingsMutex.Lock()
+	defer s.mappingsMutex.Unlock()
+
+	// Porta zero escolhe uma porta livre do host, como o sistema faz
+	if local.Port == 0 {
+		for attempt := 0; attempt < 64; attempt++ {
+			port := 1024 + rand.Intn(65535-1024)
+			if _, used := s.hosts[(&net.UDPAddr{IP: local.IP, Port: port}).String()]; !used {
+				local = &net.UDPAddr{IP: local.IP, Port: port}
+				break
+			}
+		}
+	}
+
+	key := local.String()
+	if _, used := s.hosts[key]; used || local.Port == 0 {
+		return nil, fmt.Errorf("endereço interno %s já está em uso", key)
+	}
+	conn := &natPacketConn{
+		sim:    s,
+		local:  local,
+		inbox:  make(chan natPacket, 64),
+		closed: make(chan struct{}),
+	}
+	s.hosts[key] = conn
+	return conn, nil
+}
+
+// SetMappingTimeout define a inatividade após a qual um mapeamento expira
+// SetMappingTimeout sets the inactivity after which a mapping expires
+// SetMappingTimeout define la inactividad tras la cual un mapeo expira
+func (s *NATSimulator) SetMappingTimeout(timeout time.Duration) {
+	s.mappingsMutex.Lock()
+	defer s.mappingsMutex.Unlock()
+	s.mappingTimeout = timeout
+}
+
+// SetPortAllocation faz os mapeamentos dos sockets de ListenPacket usarem portas externas
+// sequenciais a partir de first, com o passo delta, como os NATs que alocam portas em ordem;
+// delta zero volta às portas aleatórias. Portas ocupadas são puladas, como quando outros
+// hosts da rede interna abrem mapeamentos no meio da sequência.
+// SetPortAllocation makes ListenPacket mappings use sequential external ports
+// SetPortAllocation hace que los mapeos de ListenPacket usen puertos externos secuenciales
+func (s *NATSimulator) SetPortAllocation(first, delta int) {
+	s.portMutex.Lock()
+	defer s.portMutex.Unlock()
+	s.nextPort = first
+	s.portDelta = delta
+	s.portStrategy = PortAllocationSequential
+	if delta == 0 {
+		s.portStrategy = PortAllocationRandom
+	}
+}
+
+// listenMapping abre o socket externo de um novo mapeamento do host interno informado, com a
+// porta escolhida pela estratégia de alocação. Retorna o socket e a porta externa.
+func (s *NATSimulator) listenMapping(internalAddr *net.UDPAddr) (net.PacketConn, int, error) {
+	s.portMutex.Lock()
+	defer s.portMutex.Unlock()
+
+	switch s.portStrategy {
+	case PortAllocationPreserving:
+		// Mantém a porta do host interno quando está livre, como o Linux; senão, sorteia
+		if conn, port, err := s.bindExternal(internalAddr.Port); err == nil {
+			return conn, port, nil
+		}
+		return s.bindExternal(0)
+
+	case PortAllocationSequential:
+		var lastErr error
+		for attempt := 0; attempt < 64; attempt++ {
+			port := s.nextPort
+			s.nextPort += s.portDelta
+			if port < 1 || port > 65535 {
+				break
+			}
+			conn, port, err := s.bindExternal(port)
+			if err == nil {
+				return conn, port, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			lastErr = fmt.Errorf("sequência de portas esgotada")
+		}
+		return nil, 0, lastErr
+
+	default:
+		return s.bindExternal(0)
+	}
+}
+
+// bindExternal abre um socket na porta informada do IP externo, ou em uma porta livre se zero
+func (s *NATSimulator) bindExternal(port int) (net.PacketConn, int, error) {
+	conn, err := s.external(&net.UDPAddr{IP: s.externalIP, Port: port})
+	if err != nil {
+		return nil, 0, err
+	}
+	return conn, conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// mappingKey identifica o mapeamento de um envio: por origem, ou por origem e destino no NAT simétrico
+func (s *NATSimulator) mappingKey(internalAddr, dstAddr *net.UDPAddr) string {
+	if s.natType == SimulateSymmetric {
+		return fmt.Sprintf("%s->%s", internalAddr.String(), dstAddr.String())
+	}
+	return internalAddr.String()
+}
+
+// sendPacket traduz um envio de um socket virtual, criando o mapeamento quando necessário.
+// Destinos na rede interna são entregues diretamente, sem passar pelo NAT, e destinos no IP
+// externo voltam para a rede interna pelo hairpinning, quando habilitado.
+func (s *NATSimulator) sendPacket(c *natPacketConn, data []byte, dstAddr *net.UDPAddr) (int, error) {
+	s.mappingsMutex.Lock()
+
+	if s.internalNet.Contains(dstAddr.IP) {
+		host := s.hosts[dstAddr.String()]
+		s.mappingsMutex.Unlock()
+		if host != nil {
+			host.deliver(data, c.local)
+		}
+		return len(data), nil
+	}
+
+	hairpin := dstAddr.IP.Equal(s.externalIP)
+	if hairpin && !s.hairpinning {
+		s.mappingsMutex.Unlock()
+		return len(data), nil
+	}
+
+	key := s.mappingKey(c.local, dstAddr)
+	mapping, exists := s.mappings[key]
+	if exists && time.Since(mapping.LastActivity) > s.mappingTimeout {
+		s.removeMapping(key, mapping)
+		exists = false
+	}
+
+	if !exists {
+		conn, port, err := s.listenMapping(c.local)
+		if err != nil {
+			s.mappingsMutex.Unlock()
+			return 0, fmt.Errorf("erro ao criar mapeamento: %w", err)
+		}
+
+		mapping = &NATMapping{
+			InternalAddr: c.local,
+			ExternalPort: port,
+			Destinations: make(map[string]struct{}),
+			conn:         conn,
+			owner:        c,
+		}
+		s.mappings[key] = mapping
+		go s.serveMapping(key, mapping)
+
+		fmt.Printf("Novo mapeamento criado: %s -> %s:%d\n", c.local, s.externalIP, port)
+	}
+
+	mapping.Destinations[dstAddr.String()] = struct{}{}
+	mapping.LastActivity = time.Now()
+	conn := mapping.conn
+
+	if hairpin {
+		// O pacote volta com o endereço externo do remetente, filtrado pelo mapeamento de destino
+		srcAddr := &net.UDPAddr{IP: s.externalIP, Port: mapping.ExternalPort}
+		var target *NATMapping
+		for _, m := range s.mappings {
+			if m.conn != nil && m.ExternalPort == dstAddr.Port && s.allowsInbound(m, srcAddr) {
+				target = m
+				break
+			}
+		}
+		s.mappingsMutex.Unlock()
+		if target != nil {
+			s.forward(data, func(packet []byte) error {
+				target.owner.deliver(packet, srcAddr)
+				return nil
+			})
+		}
+		return len(data), nil
+	}
+	s.mappingsMutex.Unlock()
+
+	err := s.forward(data, func(packet []byte) error {
+		_, err := conn.WriteTo(packet, dstAddr)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// serveMapping recebe o tráfego externo de um mapeamento e entrega ao socket virtual o que
+// a filtragem permitir, até o mapeamento expirar ou ser fechado
+func (s *NATSimulator) serveMapping(key string, mapping *NATMapping) {
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := mapping.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		srcAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		s.mappingsMutex.Lock()
+		if time.Since(mapping.LastActivity) > s.mappingTimeout {
+			s.removeMapping(key, mapping)
+			s.mappingsMutex.Unlock()
+			return
+		}
+		allowed := s.allowsInbound(mapping, srcAddr)
+		if allowed {
+			mapping.LastActivity = time.Now()
+		}
+		s.mappingsMutex.Unlock()
+
+		if allowed {
+			s.forward(buffer[:n], func(packet []byte) error {
+				mapping.owner.deliver(packet, srcAddr)
+				return nil
+			})
+		}
+	}
+}
+
+// removeMapping apaga o mapeamento e fecha seu socket. Deve ser chamada com mappingsMutex travado.
+func (s *NATSimulator) removeMapping(key string, mapping *NATMapping) {
+	if s.mappings[key] == mapping {
+		delete(s.mappings, key)
+	}
+	if mapping.conn != nil {
+		mapping.conn.Close()
+	}
+}
+
+// closePacketMappings remove os mapeamentos do socket virtual informado, ou de todos se nil
+func (s *NATSimulator) closePacketMappings(owner *natPacketConn) {
+	s.mappingsMutex.Lock()
+	defer s.mappingsMutex.Unlock()
+
+	for key, mapping := range s.mappings {
+		if mapping.conn != nil && (owner == nil || mapping.owner == owner) {
+			s.removeMapping(key, mapping)
+		}
+	}
+}
+
+// deliver coloca um pacote na fila do socket, descartando-o se a fila estiver cheia
+func (c *natPacketConn) deliver(data []byte, from *net.UDPAddr) {
+	packet := natPacket{data: append([]byte(nil), data...), from: from}
+	select {
+	case c.inbox <- packet:
+	default:
+	}
+}
+
+// ReadFrom lê o próximo pacote entregue pelo NAT. O prazo de leitura vale a partir da chamada;
+// alterá-lo durante uma leitura em andamento não tem efeito.
+func (c *natPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	deadline := c.readDeadline
+	c.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.inbox:
+		return copy(b, packet.data), packet.from, nil
+	case <-timeout:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.local, Err: os.ErrDeadlineExceeded}
+	case <-c.closed:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.local, Err: net.ErrClosed}
+	}
+}
+
+// WriteTo envia um pacote pela tradução do NAT
+func (c *natPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: c.local, Err: net.ErrClosed}
+	default:
+	}
+
+	dstAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		var err error
+		if dstAddr, err = net.ResolveUDPAddr("udp", addr.String()); err != nil {
+			return 0, err
+		}
+	}
+	return c.sim.sendPacket(c, b, dstAddr)
+}
+
+// Close fecha o socket e remove os mapeamentos criados por ele
+func (c *natPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.sim.closePacketMappings(c)
+
+		c.sim.mappingsMutex.Lock()
+		if c.sim.hosts[c.local.String()] == c {
+			delete(c.sim.hosts, c.local.String())
+		}
+		c.sim.mappingsMutex.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr retorna o endereço do host virtual na rede interna
+func (c *natPacketConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// SetDeadline define o prazo de leitura; escritas nunca bloqueiam
+func (c *natPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline define o prazo da próxima leitura
+func (c *natPacketConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline não tem efeito, pois as escritas nunca bloqueiam
+func (c *natPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package nattraversal
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"time"
+)
+
+// NATPortAllocation define como o simulador escolhe as portas externas dos mapeamentos
+// NATPortAllocation defines how the simulator chooses the external ports of mappings
+// NATPortAllocation define cómo el simulador elige los puertos externos de los mapeos
+type NATPortAllocation int
+
+const (
+	PortAllocationRandom     NATPortAllocation = iota // Porta aleatória para cada mapeamento
+	PortAllocationSequential                          // Portas em ordem, com passo fixo (SetPortAllocation)
+	PortAllocationPreserving                          // Mesma porta do host interno quando livre, senão aleatória
+)
+
+// NATMappingInfo descreve um mapeamento ativo do simulador
+// NATMappingInfo describes an active mapping of the simulator
+// NATMappingInfo describe un mapeo activo del simulador
+type NATMappingInfo struct {
+	Internal     *net.UDPAddr // Endereço do host na rede interna
+	External     *net.UDPAddr // Endereço atribuído no lado externo do NAT
+	Destinations []string     // Destinos contatados pelo mapeamento (IP:porta), em ordem
+	LastActivity time.Time    // Última atividade no mapeamento
+}
+
+// NewChainedNATSimulator cria um NAT atrás de outro, como um roteador doméstico atrás de um
+// CGNAT. O IP externo dele é um novo host da rede interna de upstream, e cada mapeamento
+// dele é um socket desse host, traduzido de novo por upstream ao sair para a rede.
+// NewChainedNATSimulator creates a NAT behind another one, like a home router behind a CGNAT
+// NewChainedNATSimulator crea un NAT detrás de otro, como un router doméstico detrás de un CGNAT
+func NewChainedNATSimulator(natType NATSimulatorType, upstream *NATSimulator, internalNet string) (*NATSimulator, error) {
+	ip, err := upstream.allocateHost()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao reservar o IP externo no NAT de fora: %w", err)
+	}
+
+	simulator, err := NewNATSimulator(natType, ip.String(), internalNet)
+	if err != nil {
+		return nil, err
+	}
+	simulator.SetExternalNetwork(func(addr *net.UDPAddr) (net.PacketConn, error) {
+		conn, err := upstream.listenPacketAt(addr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
+	return simulator, nil
+}
+
+// AddHost reserva um novo host na rede interna simulada e retorna o IP dele. Ao contrário de
+// ListenPacket, que cria um host por socket, os sockets de um host são abertos por
+// ListenPacketAt, com portas escolhidas, como numa máquina com vários serviços.
+// AddHost reserves a new host on the simulated internal network and returns its IP
+// AddHost reserva un nuevo host en la red interna simulada y devuelve su IP
+func (s *NATSimulator) AddHost() (net.IP, error) {
+	return s.allocateHost()
+}
+
+// ListenPacketAt abre um socket no endereço interno informado, normalmente de um host
+// reservado por AddHost; porta zero escolhe uma porta livre do host
+// ListenPacketAt opens a socket at the given internal address
+// ListenPacketAt abre un socket en la dirección interna indicada
+func (s *NATSimulator) ListenPacketAt(addr *net.UDPAddr) (net.PacketConn, error) {
+	if addr == nil || !s.internalNet.Contains(addr.IP) {
+		return nil, fmt.Errorf("endereço %v fora da rede interna simulada %s", addr, s.internalNet)
+	}
+	conn, err := s.listenPacketAt(addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// SetExternalNetwork define como os sockets dos mapeamentos são abertos no IP externo, para
+// ligar o lado externo do NAT a uma rede simulada. Por padrão, são sockets UDP do sistema.
+// SetExternalNetwork sets how mapping sockets are opened on the external IP
+// SetExternalNetwork define cómo se abren los sockets de los mapeos en la IP externa
+func (s *NATSimulator) SetExternalNetwork(listen AddrListener) {
+	s.portMutex.Lock()
+	defer s.portMutex.Unlock()
+	s.external = listen
+}
+
+// ExternalIP retorna o IP externo do simulador; no NAT encadeado, um endereço da rede interna do NAT de fora
+// ExternalIP returns the external IP of the simulator
+// ExternalIP devuelve la IP externa del simulador
+func (s *NATSimulator) ExternalIP() net.IP {
+	return s.externalIP
+}
+
+// SetPortAllocationStrategy define a estratégia de alocação das portas externas. A alocação
+// sequencial usa o início e o passo de SetPortAllocation, com passo 1 se nenhum foi definido.
+// SetPortAllocationStrategy sets the external port allocation strategy
+// SetPortAllocationStrategy define la estrategia de asignación de puertos externos
+func (s *NATSimulator) SetPortAllocationStrategy(strategy NATPortAllocation) {
+	s.portMutex.Lock()
+	defer s.portMutex.Unlock()
+	s.portStrategy = strategy
+	if strategy == PortAllocationSequential && s.portDelta == 0 {
+		s.portDelta = 1
+	}
+}
+
+// SetHairpinning define se pacotes enviados da rede interna ao IP externo do simulador voltam
+// para o mapeamento de destino. Sem hairpinning, eles são descartados.
+// SetHairpinning sets whether packets sent from the internal network to the external IP loop back
+// SetHairpinning define si los paquetes enviados desde la red interna a la IP externa regresan
+func (s *NATSimulator) SetHairpinning(enabled bool) {
+	s.mappingsMutex.Lock()
+	defer s.mappingsMutex.Unlock()
+	s.hairpinning = enabled
+}
+
+// SetPacketLoss define a fração (0 a 1) dos pacotes descartados ao atravessar o NAT
+// SetPacketLoss sets the fraction (0 to 1) of packets dropped while crossing the NAT
+// SetPacketLoss define la fracción (0 a 1) de paquetes descartados al atravesar el NAT
+func (s *NATSimulator) SetPacketLoss(rate float64) {
+	s.impairMutex.Lock()
+	defer s.impairMutex.Unlock()
+	s.packetLoss = rate
+}
+
+// SetLatency define o atraso de cada travessia do NAT e a variação máxima dele. Com variação,
+// os pacotes podem chegar fora de ordem.
+// SetLatency sets the delay of each NAT traversal and its maximum jitter
+// SetLatency define el retardo de cada travesía del NAT y su variación máxima
+func (s *NATSimulator) SetLatency(delay, jitter time.Duration) {
+	s.impairMutex.Lock()
+	defer s.impairMutex.Unlock()
+	s.latency = delay
+	s.jitter = jitter
+}
+
+// forward aplica a perda e o atraso configurados a um pacote que atravessa o NAT. Sem atraso,
+// o envio é imediato e o erro dele é retornado; com atraso, o pacote é copiado e enviado depois.
+func (s *NATSimulator) forward(data []byte, send func([]byte) error) error {
+	s.impairMutex.Lock()
+	loss, delay, jitter := s.packetLoss, s.latency, s.jitter
+	s.impairMutex.Unlock()
+
+	if loss > 0 && rand.Float64() < loss {
+		return nil
+	}
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter
+	}
+	if delay <= 0 {
+		return send(data)
+	}
+
+	packet := append([]byte(nil), data...)
+	time.AfterFunc(delay, func() { send(packet) })
+	return nil
+}
+
+// Mappings retorna os mapeamentos ativos, ordenados pelo endereço interno e pela porta
+// externa. Os mapeamentos expirados são removidos antes.
+// Mappings returns the active mappings, ordered by internal address and external port
+// Mappings devuelve los mapeos activos, ordenados por dirección interna y puerto externo
+func (s *NATSimulator) Mappings() []NATMappingInfo {
+	s.mappingsMutex.Lock()
+	defer s.mappingsMutex.Unlock()
+
+	result := make([]NATMappingInfo, 0, len(s.mappings))
+	for key, mapping := range s.mappings {
+		if time.Since(mapping.LastActivity) > s.mappingTimeout {
+			s.removeMapping(key, mapping)
+			continue
+		}
+
+		destinations := make([]string, 0, len(mapping.Destinations))
+		for destination := range mapping.Destinations {
+			destinations = append(destinations, destination)
+		}
+		sort.Strings(destinations)
+
+		result = append(result, NATMappingInfo{
+			Internal:     mapping.InternalAddr,
+			External:     &net.UDPAddr{IP: s.externalIP, Port: mapping.ExternalPort},
+			Destinations: destinations,
+			LastActivity: mapping.LastActivity,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if a, b := result[i].Internal.String(), result[j].Internal.String(); a != b {
+			return a < b
+		}
+		return result[i].External.Port < result[j].External.Port
+	})
+	return result
+}
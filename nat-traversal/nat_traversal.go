@@ -1,8 +1,11 @@
 package nattraversal
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -11,8 +14,11 @@ import (
 const (
 	TechniqueHolePunching = "hole-punching"
 	TechniqueUPnP         = "upnp"
+	TechniqueNATPMP       = "natpmp"
+	TechniquePCP          = "pcp"
 	TechniqueSTUN         = "stun"
 	TechniqueTURN         = "turn" // Fallback quando métodos diretos falham
+	TechniquePeerRelay    = "peer-relay" // Relay operado por outro nó da malha
 )
 
 // STUNServer representa um servidor STUN para descoberta de endereço público
@@ -35,21 +41,124 @@ type NATInfo struct {
 	PublicIP        string    // Endereço IP público
 	PublicPort      int       // Porta pública mapeada
 	LastUpdate      time.Time // Última vez que a informação foi atualizada
-	MappingLifetime int       // Tempo de vida estimado do mapeamento em segundos
+	MappingLifetime int       // Tempo de vida do mapeamento em segundos, medido quando o servidor suporta RFC 5780
+	Hairpinning     bool      // Se o NAT entrega pacotes enviados ao próprio endereço público
 }
 
+// EndpointUpdater aplica ao WireGuard o endpoint escolhido para um peer, como o proxy local
+// do relay TURN; endpoint vazio volta ao endpoint configurado. Implementado pelo VPNProvider.
+type EndpointUpdater interface {
+	UpdatePeerEndpoint(nodeID string, endpoint string) error
+}
+
+// PeerRelayInfo identifica um relay entre peers: endereço (host:porta) e chave pública
+// WireGuard (base64) do nó que o opera
+type PeerRelayInfo struct {
+	Address   string
+	PublicKey string
+}
+
+// RelayStatus descreve por onde passa o tráfego de um peer sem caminho direto
+type RelayStatus struct {
+	Technique string // TechniqueTURN ou TechniquePeerRelay
+	Relay     string // Endereço alocado no servidor TURN ou endereço do relay entre peers
+	Peer      string // Endereço do peer (TURN) ou sua chave pública (relay entre peers)
+	Transport string // Transporte até o relay entre peers: "udp" ou "tls"
+}
+
+// peerRelayRoute é o que o nó sabe para alcançar um peer pelo relay entre peers
+type peerRelayRoute struct {
+	publicKey string
+	home      PeerRelayInfo // Relay em que o peer recebe tráfego
+}
+
+// DefaultMappingLifetime é o tempo, em segundos, que um endereço público detectado é considerado atual
+const DefaultMappingLifetime = 300
+
 // NATTraversal gerencia técnicas de NAT traversal
 type NATTraversal struct {
 	stunServers     []STUNServer
+	stunClient      *STUNClient
 	localPort       int
 	
 	// Informações sobre o NAT local
 	natInfo         NATInfo
 	natInfoMutex    sync.RWMutex
 	
-	// UPnP e outras técnicas
-	useUPnP         bool
-	upnpMappingID   string
+	// Medição do tempo de vida do mapeamento (protegidos por natInfoMutex)
+	listen          PacketListener // Abre os sockets dos testes de comportamento
+	probeMin        time.Duration
+	probeMax        time.Duration
+	lifetime        int  // Tempo de vida medido em segundos, zero enquanto desconhecido
+	measuring       bool // Se há uma medição em andamento
+	
+	// Mapeamento de porta no gateway (PCP, NAT-PMP, UPnP)
+	portMappers     []PortMapper  // Protocolos em ordem de tentativa; vazio desativa
+	mappingLease    time.Duration
+	portMapping     *PortMapping  // Mapeamento ativo, nil se não houver
+	portMapper      PortMapper    // Protocolo que criou o mapeamento ativo
+	mappingTimer    *time.Timer   // Renovação do mapeamento antes do fim do lease
+	mappingBusy     bool          // Se há uma configuração de mapeamento em andamento
+	mappingRenewal  chan struct{} // Fechado ao fim da renovação em andamento, nil se não houver
+	relayMapping    *PortMapping  // Mapeamento da porta do relay próprio, pelo mesmo protocolo
+	mappingMutex    sync.Mutex
+	
+	// Relay TURN para peers sem caminho direto
+	turnServers     []TURNServer
+	relay           *TURNRelay              // Alocação ativa, criada no primeiro peer que precisa dela
+	relayedPeers    map[string]*net.UDPAddr // Endereço de cada peer (por nodeID) alcançado via relay
+	endpointUpdater EndpointUpdater
+	relayMutex      sync.Mutex
+	
+	// Relay entre peers: serviço próprio e conexões com relays de outros nós
+	relayKey        string                      // Chave privada WireGuard, que autentica o nó nos relays
+	relayPublicKey  string
+	relayListen     string                      // Endereço do relay próprio; vazio não opera relay
+	relayAuthorize  func(publicKey string) bool
+	relayServer     *PeerRelayServer
+	relayRunning    bool
+	relayCandidates map[string]string           // Relays anunciados: endereço -> chave pública
+	relayClients    map[string]*PeerRelayClient // Conexões com relays, por endereço
+	homeRelay       PeerRelayInfo               // Relay de menor RTT, em que o nó recebe tráfego
+	relayProxies    *relayProxySet              // Proxies dos peers via relay, por chave pública
+	relayPaths      map[string]string           // Relay usado para alcançar cada chave pública
+	peerRoutes      map[string]peerRelayRoute   // Chave e relay de cada peer (por nodeID)
+	peerRelayed     map[string]string           // Chave pública dos peers (por nodeID) em relay entre peers
+	peerRelayMutex  sync.Mutex
+	relayDialMutex  sync.Mutex // Serializa as conexões com relays
+	selectionMutex  sync.Mutex // Serializa a escolha do relay principal
+	
+	// Hole punching coordenado pela porta do WireGuard
+	punchConn       net.PacketConn              // Socket definido em SetPunchConn; nil usa o socket raw
+	punchSignaler   PunchSignaler
+	punchActive     net.PacketConn              // Socket em uso pelas rodadas abertas
+	punchUsers      int                         // Rodadas usando punchActive
+	punchStop       chan struct{}               // Para a leitura de punchActive
+	punchSessions   map[string]*punchSession    // Rodadas abertas, por identificador
+	punchBinder     *punchBinder                // Descoberta STUN em andamento pelo socket das sondas
+	punchEndpoint   string                      // Endpoint público da porta do WireGuard
+	punchLearned    time.Time
+	peerKeys        map[string]string           // Chave pública WireGuard de cada peer (por nodeID)
+	peerEndpoints   map[string][]string         // Endpoints anunciados de cada peer (por nodeID)
+	birthdaySockets int                         // Sockets abertos atrás de NAT simétrico
+	birthdayProbes  int                         // Portas sondadas no IP de um peer simétrico
+	punchProxies    *relayProxySet              // Proxies dos peers alcançados por sockets próprios, por nodeID
+	punchBridges    map[string]net.PacketConn   // Socket que alcança cada peer (por nodeID) atrás de NAT simétrico
+	punchGathering  bool                        // Descoberta do endpoint público em segundo plano
+	punchMutex      sync.Mutex
+	punchBindMutex  sync.Mutex // Serializa a descoberta do endpoint público
+	
+	// Verificações de conectividade (ICE), protegidas por punchMutex
+	hostEndpoints   []string                    // Candidatos host deste nó, informados em GatherCandidates
+	peerCandidates  map[string][]Candidate      // Candidatos de cada peer (por nodeID)
+	selectedPairs   map[string]Candidate        // Candidato nomeado de cada peer (por nodeID)
+	consentInterval time.Duration
+	consentReset    chan struct{}               // Reinicia a espera quando o intervalo muda
+	
+	// Keepalive de cada peer, ajustado ao caminho escolhido e ao tempo de vida do mapeamento
+	keepaliveUpdater KeepaliveUpdater
+	peerPaths        map[string]bool            // Caminhos aplicados (por nodeID); true se pela LAN
+	keepaliveMutex   sync.Mutex
 	
 	// Controle de estado
 	running         bool
@@ -59,12 +168,42 @@ type NATTraversal struct {
 
 // NewNATTraversal cria uma nova instância do sistema de NAT traversal
 func NewNATTraversal(localPort int) *NATTraversal {
+	portMappers, _ := NewPortMappers(DefaultPortMappingProtocols)
+	
 	return &NATTraversal{
-		stunServers: DefaultSTUNServers,
-		localPort:   localPort,
-		useUPnP:     true,
-		running:     false,
-		stopChan:    make(chan struct{}),
+		stunServers:  DefaultSTUNServers,
+		stunClient:   NewSTUNClient(DefaultSTUNServers),
+		localPort:    localPort,
+		probeMin:     DefaultMappingProbeMin,
+		probeMax:     DefaultMappingProbeMax,
+		portMappers:  portMappers,
+		mappingLease: DefaultPortMappingLease,
+		relayedPeers: make(map[string]*net.UDPAddr),
+		
+		relayCandidates: make(map[string]string),
+		relayClients:    make(map[string]*PeerRelayClient),
+		relayProxies:    newRelayProxySet(localPort),
+		relayPaths:      make(map[string]string),
+		peerRoutes:      make(map[string]peerRelayRoute),
+		peerRelayed:     make(map[string]string),
+		
+		punchSessions:   make(map[string]*punchSession),
+		peerKeys:        make(map[string]string),
+		peerEndpoints:   make(map[string][]string),
+		birthdaySockets: DefaultBirthdaySockets,
+		birthdayProbes:  DefaultBirthdayProbes,
+		punchProxies:    newRelayProxySet(localPort),
+		punchBridges:    make(map[string]net.PacketConn),
+		
+		peerCandidates:  make(map[string][]Candidate),
+		selectedPairs:   make(map[string]Candidate),
+		consentInterval: DefaultConsentInterval,
+		consentReset:    make(chan struct{}, 1),
+		
+		peerPaths:       make(map[string]bool),
+		
+		running:      false,
+		stopChan:     make(chan struct{}),
 	}
 }
 
@@ -77,19 +216,23 @@ func (n *NATTraversal) Start() error {
 		return fmt.Errorf("o serviço de NAT traversal já está em execução")
 	}
 	
+	// O relay próprio abre antes do mapeamento de porta, que também mapeia a porta dele
+	n.startPeerRelay()
+	
 	// Iniciar a detecção de NAT
 	go n.detectNATType()
 	
-	// Tentar configurar UPnP se disponível
-	if n.useUPnP {
-		go n.setupUPnP()
-	}
+	// Tentar mapear a porta no gateway (PCP, NAT-PMP ou UPnP)
+	go n.setupPortMapping()
 	
 	n.running = true
 	
 	// Iniciar rotina de manutenção
 	go n.maintenanceRoutine()
 	
+	// Verificar periodicamente os pares escolhidos para os peers
+	go n.consentRoutine()
+	
 	return nil
 }
 
@@ -105,39 +248,190 @@ func (n *NATTraversal) Stop() error {
 	// Sinalizar para as goroutines pararem
 	close(n.stopChan)
 	
-	// Remover mapeamentos UPnP
-	if n.useUPnP && n.upnpMappingID != "" {
-		n.removeUPnPMapping()
-	}
+	// Remover o mapeamento do gateway
+	n.removePortMapping()
+	
+	// Devolver os peers aos endpoints diretos e liberar a alocação TURN e os relays entre peers
+	n.stopRelay()
+	n.stopPeerRelay()
+	n.stopPunch()
 	
 	n.running = false
 	
 	return nil
 }
 
-// detectNATType detecta o tipo de NAT usando servidores STUN
-func (n *NATTraversal) detectNATType() {
-	// Aqui implementaríamos a lógica real de detecção de NAT com STUN
-	// Por enquanto, apenas simulamos a detecção
+// SetSTUNServers define os servidores STUN usados na detecção, em ordem de preferência
+func (n *NATTraversal) SetSTUNServers(servers []STUNServer) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	
+	client := NewSTUNClient(servers)
+	client.SetRetransmission(n.stunClient.rto, n.stunClient.transmissions)
 	
+	n.stunServers = servers
+	n.stunClient = client
+}
+
+// SetSTUNRetransmission ajusta os temporizadores das transações STUN da detecção
+func (n *NATTraversal) SetSTUNRetransmission(rto time.Duration, transmissions int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.stunClient.SetRetransmission(rto, transmissions)
+}
+
+// SetPacketListener define como os sockets de detecção são abertos. Por padrão a detecção
+// usa a porta local da VPN; com um listener (ex: NATSimulator.ListenPacket) usa os sockets dele.
+func (n *NATTraversal) SetPacketListener(listen PacketListener) {
+	n.natInfoMutex.Lock()
+	defer n.natInfoMutex.Unlock()
+	n.listen = listen
+}
+
+// SetMappingProbe define as ociosidades testadas ao medir o tempo de vida do mapeamento
+func (n *NATTraversal) SetMappingProbe(minIdle, maxIdle time.Duration) {
+	n.natInfoMutex.Lock()
+	defer n.natInfoMutex.Unlock()
+	n.probeMin = minIdle
+	n.probeMax = maxIdle
+}
+
+// GetNATInfo retorna as informações de NAT detectadas
+func (n *NATTraversal) GetNATInfo() NATInfo {
+	n.natInfoMutex.RLock()
+	defer n.natInfoMutex.RUnlock()
+	return n.natInfo
+}
+
+// detectNATType detecta o endereço público e o comportamento do NAT usando servidores STUN
+func (n *NATTraversal) detectNATType() {
 	fmt.Println("Iniciando detecção de NAT...")
 	
-	// Simular um atraso para a detecção
-	time.Sleep(1 * time.Second)
+	n.mutex.Lock()
+	client := n.stunClient
+	n.mutex.Unlock()
+	
+	conn, listen, err := n.detectionSocket()
+	if err != nil {
+		fmt.Printf("Erro ao criar socket para detecção de NAT: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	
+	binding, err := client.Bind(conn)
+	if err != nil {
+		fmt.Printf("Erro na detecção de NAT: %v\n", err)
+		return
+	}
+	server, _ := client.serverByAddr(binding.Server)
+	
+	// Com um servidor RFC 5780 o comportamento é medido. Sem ele, um endereço mapeado local
+	// indica ausência de NAT e mapeamentos diferentes por destino um NAT simétrico; o
+	// restante é tratado como port-restricted, o caso mais comum que ainda permite hole punching.
+	natType := "port-restricted"
+	hairpinning := false
+	behavior, err := client.DiscoverBehavior(listen, server)
+	if err == nil {
+		natType = behavior.TypeName()
+		hairpinning = behavior.Hairpinning
+	} else {
+		if !errors.Is(err, ErrRFC5780Unsupported) {
+			fmt.Printf("Erro nos testes de comportamento do NAT: %v\n", err)
+		}
+		if isLocalIP(binding.Mapped.IP) {
+			natType = "open"
+		} else if other, err := client.BindOther(conn, binding.Server); err == nil && other.Mapped.Port != binding.Mapped.Port {
+			natType = "symmetric"
+		}
+	}
 	
-	// Atualizar com valores simulados
 	n.natInfoMutex.Lock()
+	lifetime := n.lifetime
+	if lifetime == 0 {
+		lifetime = DefaultMappingLifetime
+	}
 	n.natInfo = NATInfo{
-		Type:            "restricted-cone", // Valor simulado
-		PublicIP:        "203.0.113.45",    // IP simulado
-		PublicPort:      12345,             // Porta simulada
+		Type:            natType,
+		PublicIP:        binding.Mapped.IP.String(),
+		PublicPort:      binding.Mapped.Port,
 		LastUpdate:      time.Now(),
-		MappingLifetime: 300, // 5 minutos (simulado)
+		MappingLifetime: lifetime,
+		Hairpinning:     hairpinning,
+	}
+	
+	// O tempo de vida só muda com a rede, então é medido uma vez por rede
+	measure := behavior != nil && behavior.NATPresent && n.lifetime == 0 && !n.measuring
+	n.measuring = n.measuring || measure
+	n.natInfoMutex.Unlock()
+	
+	if measure {
+		go n.measureMappingLifetime(client, listen, server)
+	}
+	
+	fmt.Printf("NAT detectado via %s: tipo=%s, IP público=%s:%d\n", 
+		binding.Server, natType, binding.Mapped.IP, binding.Mapped.Port)
+	
+	// O tipo de NAT e o tempo de vida (zerado numa nova rede) decidem o keepalive dos peers;
+	// o núcleo da VPN ignora os que não mudaram
+	n.refreshKeepalives()
+}
+
+// detectionSocket abre o socket principal da detecção e retorna o listener dos demais testes
+func (n *NATTraversal) detectionSocket() (net.PacketConn, PacketListener, error) {
+	n.natInfoMutex.RLock()
+	listen := n.listen
+	n.natInfoMutex.RUnlock()
+	
+	if listen != nil {
+		conn, err := listen()
+		return conn, listen, err
+	}
+	
+	// Usar a porta local quando estiver livre, para que o mapeamento seja o mesmo do tráfego
+	// da VPN; se o WireGuard já a ocupa, uma porta efêmera ainda revela o IP e o tipo de NAT
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: n.localPort})
+	if err != nil {
+		conn, err = net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	return conn, ListenUDP, nil
+}
+
+// measureMappingLifetime mede em segundo plano o tempo de vida do mapeamento e atualiza NATInfo
+func (n *NATTraversal) measureMappingLifetime(client *STUNClient, listen PacketListener, server STUNServer) {
+	n.natInfoMutex.RLock()
+	minIdle, maxIdle := n.probeMin, n.probeMax
+	n.natInfoMutex.RUnlock()
+	
+	lifetime, err := client.MeasureMappingLifetime(listen, server, minIdle, maxIdle, n.stopChan)
+	
+	n.natInfoMutex.Lock()
+	n.measuring = false
+	
+	if err != nil {
+		n.natInfoMutex.Unlock()
+		fmt.Printf("Erro ao medir o tempo de vida do mapeamento: %v\n", err)
+		return
+	}
+	
+	// Um mapeamento que não sobrevive à menor ociosidade testada fica com ela como limite
+	if lifetime == 0 {
+		lifetime = minIdle
+	}
+	n.lifetime = int(lifetime / time.Second)
+	if n.lifetime < 1 {
+		n.lifetime = 1
+	}
+	n.natInfo.MappingLifetime = n.lifetime
+	measured := n.lifetime
 	n.natInfoMutex.Unlock()
 	
-	fmt.Printf("NAT detectado: tipo=%s, IP público=%s:%d\n", 
-		n.natInfo.Type, n.natInfo.PublicIP, n.natInfo.PublicPort)
+	fmt.Printf("Tempo de vida do mapeamento NAT: %ds\n", measured)
+	
+	// Os keepalives dos peers passam a seguir o tempo de vida medido
+	n.refreshKeepalives()
 }
 
 // GetPublicEndpoint retorna o endpoint público detectado
@@ -158,44 +452,351 @@ func (n *NATTraversal) GetPublicEndpoint() (string, int, error) {
 	return n.natInfo.PublicIP, n.natInfo.PublicPort, nil
 }
 
-// setupUPnP tenta configurar mapeamento de portas UPnP no roteador
-func (n *NATTraversal) setupUPnP() {
-	// Aqui implementaríamos a configuração real de UPnP
-	// Usando bibliotecas como github.com/huin/goupnp
-	// Por enquanto, apenas simulamos a configuração
-	
-	fmt.Println("Tentando configurar mapeamento UPnP...")
+// Refresh redetecta o endpoint público imediatamente, sem esperar a manutenção periódica.
+// Usado quando a rede local muda e o mapeamento anterior deixa de valer.
+func (n *NATTraversal) Refresh() {
+	// A nova rede pode ter outro NAT, então o tempo de vida volta a ser medido
+	n.natInfoMutex.Lock()
+	n.lifetime = 0
+	n.natInfoMutex.Unlock()
 	
-	// Simular um atraso para a configuração
-	time.Sleep(2 * time.Second)
+	n.punchMutex.Lock()
+	n.punchEndpoint = ""
+	n.punchMutex.Unlock()
 	
-	// Simulação de sucesso
+	// O mapeamento de porta pertence ao gateway anterior
 	n.mutex.Lock()
-	n.upnpMappingID = "simulado-12345"
+	running := n.running
 	n.mutex.Unlock()
+	if running {
+		go func() {
+			n.removePortMapping()
+			n.setupPortMapping()
+		}()
+	}
 	
-	fmt.Println("Mapeamento UPnP configurado com sucesso!")
+	n.detectNATType()
 }
 
-// removeUPnPMapping remove o mapeamento UPnP do roteador
-func (n *NATTraversal) removeUPnPMapping() {
-	// Aqui implementaríamos a remoção real do mapeamento UPnP
-	// Por enquanto, apenas simulamos a remoção
+// SetPortMappingProtocols define os protocolos de mapeamento de porta (pcp, natpmp, upnp)
+// na ordem de tentativa; "none" desativa o mapeamento. Deve ser chamado antes de Start.
+func (n *NATTraversal) SetPortMappingProtocols(protocols []string) error {
+	mappers, err := NewPortMappers(protocols)
+	if err != nil {
+		return err
+	}
+	n.SetPortMappers(mappers...)
+	return nil
+}
+
+// SetPortMappers define os clientes de mapeamento de porta na ordem de tentativa, como
+// clientes com um gateway específico. Deve ser chamado antes de Start.
+func (n *NATTraversal) SetPortMappers(mappers ...PortMapper) {
+	n.mappingMutex.Lock()
+	defer n.mappingMutex.Unlock()
+	n.portMappers = mappers
+}
+
+// SetPortMappingLease define a duração pedida para o mapeamento, renovado na metade do prazo
+func (n *NATTraversal) SetPortMappingLease(lease time.Duration) {
+	n.mappingMutex.Lock()
+	defer n.mappingMutex.Unlock()
+	n.mappingLease = lease
+}
+
+// EndpointCandidates lista endpoints públicos (host:porta) pelos quais a porta local pode
+// ser alcançada diretamente, como o endereço externo do mapeamento no gateway
+func (n *NATTraversal) EndpointCandidates() []string {
+	n.mappingMutex.Lock()
+	defer n.mappingMutex.Unlock()
 	
-	fmt.Println("Removendo mapeamento UPnP...")
+	var candidates []string
+	if n.portMapping != nil {
+		candidates = append(candidates, n.portMapping.Endpoint())
+	}
+	return candidates
+}
+
+// setupPortMapping mapeia a porta local com o primeiro protocolo que o gateway aceitar,
+// se ainda não houver mapeamento. As requisições são feitas sem travar mappingMutex, para
+// que Stop não espere os protocolos que o gateway não atende esgotarem as retransmissões.
+func (n *NATTraversal) setupPortMapping() {
+	if n.localPort == 0 {
+		return
+	}
 	
-	// Simular um atraso para a remoção
-	time.Sleep(500 * time.Millisecond)
+	n.mappingMutex.Lock()
+	if n.portMapping != nil || n.mappingBusy {
+		n.mappingMutex.Unlock()
+		return
+	}
+	n.mappingBusy = true
+	mappers, lease := n.portMappers, n.mappingLease
+	n.mappingMutex.Unlock()
 	
-	n.mutex.Lock()
-	n.upnpMappingID = ""
-	n.mutex.Unlock()
+	defer func() {
+		n.mappingMutex.Lock()
+		n.mappingBusy = false
+		n.mappingMutex.Unlock()
+	}()
+	
+	for _, mapper := range mappers {
+		select {
+		case <-n.stopChan:
+			return
+		default:
+		}
+		
+		fmt.Printf("Tentando configurar mapeamento de porta via %s...\n", mapper.Name())
+		mapping, err := mapper.AddMapping(n.localPort, n.localPort, lease)
+		if err != nil {
+			fmt.Printf("Mapeamento via %s indisponível: %v\n", mapper.Name(), err)
+			continue
+		}
+		
+		n.mappingMutex.Lock()
+		select {
+		case <-n.stopChan:
+			// Stop aconteceu durante a requisição: o mapeamento não deve ficar no gateway
+			n.mappingMutex.Unlock()
+			mapper.DeleteMapping(mapping)
+			return
+		default:
+		}
+		n.portMapping = mapping
+		n.portMapper = mapper
+		n.scheduleMappingRenewal()
+		n.mappingMutex.Unlock()
+		
+		fmt.Printf("Mapeamento %s configurado: %s -> porta local %d (lease %s)\n",
+			mapper.Name(), mapping.Endpoint(), n.localPort, mapping.Lease)
+		
+		n.mapRelayPort(mapper, lease)
+		return
+	}
+}
+
+// scheduleMappingRenewal agenda a renovação do mapeamento na metade do lease.
+// Deve ser chamada com mappingMutex travado.
+func (n *NATTraversal) scheduleMappingRenewal() {
+	if n.mappingTimer != nil {
+		n.mappingTimer.Stop()
+		n.mappingTimer = nil
+	}
+	if n.portMapping != nil && n.portMapping.Lease > 0 {
+		n.mappingTimer = time.AfterFunc(n.portMapping.Lease/2, n.renewPortMapping)
+	}
+}
+
+// renewPortMapping renova o mapeamento antes do fim do lease; se o gateway recusar, o
+// mapeamento é refeito do zero, possivelmente com outro protocolo. Como em setupPortMapping,
+// as requisições são feitas sem travar mappingMutex.
+func (n *NATTraversal) renewPortMapping() {
+	select {
+	case <-n.stopChan:
+		return
+	default:
+	}
+	
+	// Uma renovação por vez; a outra chamada (ex: reinício do gateway) já é atendida por ela
+	n.mappingMutex.Lock()
+	mapping, mapper, relayMapping := n.portMapping, n.portMapper, n.relayMapping
+	if mapping == nil || n.mappingRenewal != nil {
+		n.mappingMutex.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	n.mappingRenewal = done
+	n.mappingMutex.Unlock()
+	defer close(done)
+	
+	// A porta do relay próprio acompanha a da VPN; se o gateway recusar, deixa de ser anunciada
+	renewed, err := mapper.RenewMapping(mapping)
+	var relayRenewed *PortMapping
+	var relayErr error
+	if err == nil && relayMapping != nil {
+		relayRenewed, relayErr = mapper.RenewMapping(relayMapping)
+	}
+	
+	n.mappingMutex.Lock()
+	n.mappingRenewal = nil
+	
+	// O mapeamento pode ter sido removido (ex: Stop) durante as requisições; a remoção espera
+	// por esta renovação, que então tira do gateway o que acabou de renovar
+	if n.portMapper != mapper {
+		n.mappingMutex.Unlock()
+		if err != nil {
+			renewed = mapping
+		}
+		mapper.DeleteMapping(renewed)
+		if relayRenewed != nil {
+			mapper.DeleteMapping(relayRenewed)
+		} else if relayMapping != nil {
+			mapper.DeleteMapping(relayMapping)
+		}
+		return
+	}
+	if n.portMapping != mapping {
+		n.mappingMutex.Unlock()
+		return
+	}
 	
-	fmt.Println("Mapeamento UPnP removido com sucesso!")
+	if err == nil {
+		if renewed.Endpoint() != mapping.Endpoint() {
+			fmt.Printf("Endpoint do mapeamento %s mudou: %s -> %s\n", 
+				mapper.Name(), mapping.Endpoint(), renewed.Endpoint())
+		}
+		n.portMapping = renewed
+		n.scheduleMappingRenewal()
+		
+		if relayMapping != nil && n.relayMapping == relayMapping {
+			if relayErr == nil {
+				n.relayMapping = relayRenewed
+			} else {
+				fmt.Printf("Erro ao renovar mapeamento do relay: %v\n", relayErr)
+				n.relayMapping = nil
+			}
+		}
+		n.mappingMutex.Unlock()
+		return
+	}
+	
+	fmt.Printf("Erro ao renovar mapeamento %s: %v\n", mapper.Name(), err)
+	n.portMapping = nil
+	n.portMapper = nil
+	n.relayMapping = nil
+	n.mappingMutex.Unlock()
+	
+	n.setupPortMapping()
+}
+
+// checkGatewayReset consulta a época do gateway e refaz o mapeamento imediatamente se ele
+// reiniciou, em vez de esperar a próxima renovação
+func (n *NATTraversal) checkGatewayReset() {
+	n.mappingMutex.Lock()
+	mapper := n.portMapper
+	n.mappingMutex.Unlock()
+	if mapper == nil {
+		return
+	}
+	
+	reset, err := mapper.GatewayReset()
+	if err != nil {
+		fmt.Printf("Erro ao consultar o gateway via %s: %v\n", mapper.Name(), err)
+		return
+	}
+	if reset {
+		fmt.Printf("Gateway %s reiniciou; refazendo o mapeamento de porta\n", mapper.Name())
+		n.renewPortMapping()
+	}
+}
+
+// mappingRenewalWait limita quanto a remoção espera por uma renovação em andamento
+const mappingRenewalWait = time.Second
+
+// removePortMapping cancela a renovação e remove o mapeamento do gateway. O mapeamento sai do
+// estado antes das requisições, feitas sem travar mappingMutex; uma renovação ou um mapeamento
+// do relay em andamento percebe a remoção e desfaz o que criou. Se a renovação terminar dentro
+// de mappingRenewalWait, a remoção fica a cargo dela, evitando remover o mapeamento duas vezes.
+func (n *NATTraversal) removePortMapping() {
+	n.mappingMutex.Lock()
+	if n.mappingTimer != nil {
+		n.mappingTimer.Stop()
+		n.mappingTimer = nil
+	}
+	mapping, mapper, relayMapping := n.portMapping, n.portMapper, n.relayMapping
+	renewal := n.mappingRenewal
+	n.portMapping = nil
+	n.portMapper = nil
+	n.relayMapping = nil
+	n.mappingMutex.Unlock()
+	
+	if mapping == nil {
+		return
+	}
+	
+	if renewal != nil {
+		select {
+		case <-renewal:
+			return
+		case <-time.After(mappingRenewalWait):
+			// Gateway sem resposta: remover agora; a renovação desfaz o que criar ao terminar
+		}
+	}
+	
+	fmt.Printf("Removendo mapeamento %s...\n", mapper.Name())
+	if err := mapper.DeleteMapping(mapping); err != nil {
+		fmt.Printf("Erro ao remover mapeamento %s: %v\n", mapper.Name(), err)
+	}
+	if relayMapping != nil {
+		if err := mapper.DeleteMapping(relayMapping); err != nil {
+			fmt.Printf("Erro ao remover mapeamento do relay: %v\n", err)
+		}
+	}
+}
+
+// mapRelayPort mapeia a porta UDP do relay próprio pelo protocolo que mapeou a da VPN, para
+// que o relay seja alcançável de fora do NAT
+func (n *NATTraversal) mapRelayPort(mapper PortMapper, lease time.Duration) {
+	port := n.relayPort()
+	if port == 0 {
+		return
+	}
+	
+	mapping, err := mapper.AddMapping(port, port, lease)
+	if err != nil {
+		fmt.Printf("Erro ao mapear a porta do relay via %s: %v\n", mapper.Name(), err)
+		return
+	}
+	
+	n.mappingMutex.Lock()
+	defer n.mappingMutex.Unlock()
+	
+	// O mapeamento da VPN pode ter sido removido durante a requisição
+	if n.portMapper != mapper {
+		mapper.DeleteMapping(mapping)
+		return
+	}
+	n.relayMapping = mapping
+	fmt.Printf("Mapeamento %s do relay configurado: %s -> porta local %d\n",
+		mapper.Name(), mapping.Endpoint(), port)
 }
 
 // FacilitateConnection tenta facilitar uma conexão com um peer remoto
 func (n *NATTraversal) FacilitateConnection(remoteIP string, remotePort int) error {
+	return n.facilitateConnection("", remoteIP, remotePort)
+}
+
+// ConnectPeer estabelece o caminho até um peer pelos endpoints anunciados (host:porta),
+// conforme o tipo de NAT local. Atrás de NAT restrito ou simétrico, o hole punching é
+// combinado com o peer pelo PunchSignaler e o endpoint vencedor é aplicado pelo
+// EndpointUpdater; no simétrico, por um proxy local ligado ao socket vencedor. Quando não
+// há caminho direto, o tráfego WireGuard do peer passa por um servidor TURN ou, sem ele, pelo
+// relay entre peers informado em SetPeerRelay, e o EndpointUpdater aponta o peer para o proxy
+// local; se depois o caminho direto voltar a servir, o relay do peer é desfeito.
+func (n *NATTraversal) ConnectPeer(nodeID string, endpoints []string) error {
+	// Os endpoints também são candidatos das rodadas de hole punching propostas pelo peer
+	n.punchMutex.Lock()
+	n.peerEndpoints[nodeID] = append([]string(nil), endpoints...)
+	n.punchMutex.Unlock()
+	
+	for _, endpoint := range endpoints {
+		host, portStr, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
+		}
+		return n.facilitateConnection(nodeID, host, port)
+	}
+	return fmt.Errorf("peer %s sem endpoint válido", nodeID)
+}
+
+// facilitateConnection aplica a estratégia do tipo de NAT local. nodeID vazio não permite
+// o relay, que precisa atualizar o endpoint do peer no WireGuard.
+func (n *NATTraversal) facilitateConnection(nodeID string, remoteIP string, remotePort int) error {
 	fmt.Printf("Tentando facilitar conexão com %s:%d...\n", remoteIP, remotePort)
 	
 	// Obter informações do NAT local
@@ -204,26 +805,43 @@ func (n *NATTraversal) FacilitateConnection(remoteIP string, remotePort int) err
 	n.natInfoMutex.RUnlock()
 	
 	// Aqui implementaríamos diferentes estratégias com base no tipo de NAT
+	var err error
 	switch localNATType {
 	case "open":
 		fmt.Println("NAT aberto, conexão direta possível")
-		return nil
 		
 	case "full-cone":
 		fmt.Println("NAT full-cone, tentando conexão direta")
-		return n.directConnection(remoteIP, remotePort)
+		err = n.directConnection(remoteIP, remotePort)
 		
 	case "restricted-cone", "port-restricted":
 		fmt.Println("NAT restrito, tentando hole punching")
-		return n.holePunching(remoteIP, remotePort)
+		if err = n.holePunching(nodeID, remoteIP, remotePort); err != nil && n.canRelay(nodeID) {
+			fmt.Printf("Hole punching falhou (%v), recorrendo a relay\n", err)
+			return n.relayConnection(nodeID, remoteIP, remotePort)
+		}
 		
 	case "symmetric":
-		fmt.Println("NAT simétrico, conexão direta pode não ser possível")
-		return n.tryRelayIfNeeded(remoteIP, remotePort)
+		if !n.canPunch(nodeID) {
+			fmt.Println("NAT simétrico, conexão direta pode não ser possível")
+			return n.tryRelayIfNeeded(nodeID, remoteIP, remotePort)
+		}
+		// A previsão de portas e as sondas por vários sockets vêm antes do relay, que só
+		// existe se algum servidor TURN ou relay entre peers estiver disponível
+		fmt.Println("NAT simétrico, tentando hole punching com previsão de portas")
+		if err = n.holePunching(nodeID, remoteIP, remotePort); err != nil && n.canRelay(nodeID) {
+			fmt.Printf("Hole punching falhou (%v), recorrendo a relay\n", err)
+			return n.relayConnection(nodeID, remoteIP, remotePort)
+		}
 		
 	default:
 		return fmt.Errorf("tipo de NAT desconhecido: %s", localNATType)
 	}
+	
+	if err == nil && nodeID != "" {
+		n.releaseRelay(nodeID)
+	}
+	return err
 }
 
 // directConnection implementa conexão direta com o peer remoto
@@ -233,34 +851,602 @@ func (n *NATTraversal) directConnection(remoteIP string, remotePort int) error {
 	return nil
 }
 
-// holePunching implementa a técnica de hole punching para atravessar NAT
-func (n *NATTraversal) holePunching(remoteIP string, remotePort int) error {
-	// Simulação de hole punching
-	fmt.Printf("Simulando UDP hole punching com %s:%d\n", remoteIP, remotePort)
+// tryRelayIfNeeded usa um relay (TURN ou entre peers) quando há algum disponível. O NAT
+// simétrico cria um mapeamento por destino, então o endpoint que o peer conhece não recebe
+// os pacotes dele e, sem combinar a rodada com o peer, o hole punching não é confiável; sem
+// relay resta tentá-lo mesmo assim.
+func (n *NATTraversal) tryRelayIfNeeded(nodeID string, remoteIP string, remotePort int) error {
+	if !n.canRelay(nodeID) {
+		return n.holePunching(nodeID, remoteIP, remotePort)
+	}
+	
+	fmt.Println("Sem caminho direto confiável, recorrendo a relay")
+	return n.relayConnection(nodeID, remoteIP, remotePort)
+}
+
+// SetTURNServers define os servidores TURN usados, em ordem, como relay
+func (n *NATTraversal) SetTURNServers(servers []TURNServer) {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	n.turnServers = servers
+}
+
+// SetEndpointUpdater define quem aplica ao WireGuard os endpoints dos peers em relay
+func (n *NATTraversal) SetEndpointUpdater(updater EndpointUpdater) {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	n.endpointUpdater = updater
+}
+
+// RelayedPeers informa, por nodeID, os peers alcançados via relay e por onde passam
+func (n *NATTraversal) RelayedPeers() map[string]RelayStatus {
+	peers := make(map[string]RelayStatus)
+	
+	n.relayMutex.Lock()
+	for nodeID, addr := range n.relayedPeers {
+		peers[nodeID] = RelayStatus{
+			Technique: TechniqueTURN,
+			Relay:     n.relay.RelayedAddress().String(),
+			Peer:      addr.String(),
+		}
+	}
+	n.relayMutex.Unlock()
+	
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	for nodeID, publicKey := range n.peerRelayed {
+		status := RelayStatus{
+			Technique: TechniquePeerRelay,
+			Relay:     n.relayPaths[publicKey],
+			Peer:      publicKey,
+		}
+		if client := n.relayClients[status.Relay]; client != nil {
+			status.Transport = client.Transport()
+		}
+		peers[nodeID] = status
+	}
+	return peers
+}
+
+// canRelay indica se o peer pode ser levado a um relay TURN ou entre peers
+func (n *NATTraversal) canRelay(nodeID string) bool {
+	if nodeID == "" || n.updater() == nil {
+		return false
+	}
+	if n.hasTURNServers() {
+		return true
+	}
+	_, ok := n.peerRoute(nodeID)
+	return ok
+}
+
+// updater retorna o EndpointUpdater configurado
+func (n *NATTraversal) updater() EndpointUpdater {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	return n.endpointUpdater
+}
+
+// hasTURNServers indica se há servidores TURN configurados
+func (n *NATTraversal) hasTURNServers() bool {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	return len(n.turnServers) > 0
+}
+
+// relayConnection leva o peer a um relay: primeiro aos servidores TURN, dedicados a isso, e
+// depois ao relay entre peers em que o peer recebe tráfego
+func (n *NATTraversal) relayConnection(nodeID string, remoteIP string, remotePort int) error {
+	n.mutex.Lock()
+	running := n.running
+	n.mutex.Unlock()
+	if !running {
+		return fmt.Errorf("o serviço de NAT traversal não está em execução")
+	}
+	
+	var errs []error
+	if n.hasTURNServers() {
+		err := n.turnConnection(nodeID, remoteIP, remotePort)
+		if err == nil {
+			n.dropPeerRelay(nodeID)
+			n.dropPunchedPeer(nodeID)
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if route, ok := n.peerRoute(nodeID); ok {
+		err := n.peerRelayConnection(nodeID, route)
+		if err == nil {
+			n.dropTURNPeer(nodeID)
+			n.dropPunchedPeer(nodeID)
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("nenhum relay disponível para o peer %s", nodeID)
+	}
+	return errors.Join(errs...)
+}
+
+// turnConnection leva o peer ao relay TURN, criando a alocação se preciso, e aponta seu
+// endpoint WireGuard para o proxy local
+func (n *NATTraversal) turnConnection(nodeID string, remoteIP string, remotePort int) error {
+	peer, err := net.ResolveUDPAddr("udp", net.JoinHostPort(remoteIP, strconv.Itoa(remotePort)))
+	if err != nil {
+		return fmt.Errorf("endereço do peer inválido: %w", err)
+	}
+	
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	
+	if err := n.allocateRelay(); err != nil {
+		return err
+	}
+	
+	proxy, err := n.relay.AddPeer(peer)
+	if err != nil {
+		return fmt.Errorf("erro ao configurar relay TURN para %s: %w", nodeID, err)
+	}
+	
+	// O peer mudou de endereço: o proxy anterior deixa de ser usado
+	if previous, ok := n.relayedPeers[nodeID]; ok && previous.String() != peer.String() {
+		n.relay.RemovePeer(previous)
+	}
+	n.relayedPeers[nodeID] = peer
 	
-	// Na implementação real, enviaríamos pacotes UDP para criar o "buraco" no NAT
-	// e permitir a comunicação bidirecional
+	if err := n.endpointUpdater.UpdatePeerEndpoint(nodeID, proxy.String()); err != nil {
+		return fmt.Errorf("erro ao apontar o peer %s para o relay: %w", nodeID, err)
+	}
+	n.setPathKeepalive(nodeID, false)
 	
+	fmt.Printf("Peer %s conectado via relay TURN %s (proxy local %s)\n",
+		nodeID, n.relay.RelayedAddress(), proxy)
 	return nil
 }
 
-// tryRelayIfNeeded tenta usar um servidor TURN como relay se necessário
-func (n *NATTraversal) tryRelayIfNeeded(remoteIP string, remotePort int) error {
-	// Primeiro tentar hole punching
-	err := n.holePunching(remoteIP, remotePort)
-	if err == nil {
+// allocateRelay cria a alocação no primeiro servidor TURN que responder.
+// Deve ser chamada com relayMutex travado.
+func (n *NATTraversal) allocateRelay() error {
+	if n.relay != nil {
+		return nil
+	}
+	
+	var lastErr error
+	for _, server := range n.turnServers {
+		relay := NewTURNRelay(server, n.localPort)
+		if err := relay.Start(); err != nil {
+			fmt.Printf("Servidor TURN %s indisponível: %v\n", turnServerAddr(server), err)
+			lastErr = err
+			continue
+		}
+		n.relay = relay
 		return nil
 	}
+	return fmt.Errorf("nenhum servidor TURN disponível: %w", lastErr)
+}
+
+// releaseRelay tira o peer do relay e devolve o WireGuard ao endpoint configurado
+func (n *NATTraversal) releaseRelay(nodeID string) {
+	turn := n.dropTURNPeer(nodeID)
+	peerRelay := n.dropPeerRelay(nodeID)
+	if !turn && !peerRelay {
+		return
+	}
+	
+	if err := n.updater().UpdatePeerEndpoint(nodeID, ""); err != nil {
+		fmt.Printf("Erro ao restaurar endpoint do peer %s: %v\n", nodeID, err)
+	}
+}
+
+// dropTURNPeer fecha o proxy TURN do peer, sem mexer no endpoint WireGuard, e informa se
+// o peer estava no relay TURN
+func (n *NATTraversal) dropTURNPeer(nodeID string) bool {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	
+	peer, ok := n.relayedPeers[nodeID]
+	if !ok {
+		return false
+	}
+	delete(n.relayedPeers, nodeID)
+	n.relay.RemovePeer(peer)
+	return true
+}
+
+// stopRelay devolve os peers aos endpoints configurados e remove a alocação TURN
+func (n *NATTraversal) stopRelay() {
+	n.relayMutex.Lock()
+	defer n.relayMutex.Unlock()
+	
+	if n.relay == nil {
+		return
+	}
+	for nodeID := range n.relayedPeers {
+		if err := n.endpointUpdater.UpdatePeerEndpoint(nodeID, ""); err != nil {
+			fmt.Printf("Erro ao restaurar endpoint do peer %s: %v\n", nodeID, err)
+		}
+		delete(n.relayedPeers, nodeID)
+	}
+	
+	n.relay.Stop()
+	n.relay = nil
+}
+
+// SetRelayKey define a chave privada WireGuard (base64) com que o nó se autentica nos
+// relays entre peers e opera o próprio relay
+func (n *NATTraversal) SetRelayKey(privateKey string) error {
+	key, err := parseRelayPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	n.relayKey = privateKey
+	n.relayPublicKey = base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	return nil
+}
+
+// SetRelayService faz o nó operar um relay entre peers em listenAddr (ex: ":3479"), atendendo
+// as chaves públicas aceitas por authorize. Deve ser chamado antes de Start, com a chave
+// definida em SetRelayKey. O relay só é anunciado quando alcançável de fora: sem NAT ou com
+// a porta mapeada no gateway.
+func (n *NATTraversal) SetRelayService(listenAddr string, authorize func(publicKey string) bool) {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	n.relayListen = listenAddr
+	n.relayAuthorize = authorize
+}
+
+// AddRelayCandidates registra relays entre peers anunciados na malha, candidatos a relay
+// principal do nó
+func (n *NATTraversal) AddRelayCandidates(relays ...PeerRelayInfo) {
+	n.peerRelayMutex.Lock()
+	added := false
+	for _, relay := range relays {
+		if relay.Address == "" || relay.PublicKey == "" {
+			continue
+		}
+		if n.relayCandidates[relay.Address] != relay.PublicKey {
+			n.relayCandidates[relay.Address] = relay.PublicKey
+			added = true
+		}
+	}
+	// Sem relay principal, o primeiro candidato já é usado; os demais entram na próxima escolha
+	selectNow := added && n.homeRelay.Address == "" && n.relayRunning
+	n.peerRelayMutex.Unlock()
+	
+	if selectNow {
+		go n.selectHomeRelay()
+	}
+}
+
+// HomeRelay retorna o relay entre peers em que o nó recebe tráfego, a ser anunciado aos peers
+func (n *NATTraversal) HomeRelay() (PeerRelayInfo, bool) {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	return n.homeRelay, n.homeRelay.Address != ""
+}
+
+// RelayEndpoint retorna o endereço público (host:porta) do relay operado pelo nó, vazio se
+// o nó não opera relay ou se ele não é alcançável de fora
+func (n *NATTraversal) RelayEndpoint() string {
+	port := n.relayPort()
+	if port == 0 {
+		return ""
+	}
+	
+	n.mappingMutex.Lock()
+	mapping := n.relayMapping
+	n.mappingMutex.Unlock()
+	if mapping != nil {
+		return mapping.Endpoint()
+	}
 	
-	// Se falhar, usar relay TURN
-	fmt.Println("Hole punching falhou, recorrendo a servidor relay TURN")
+	info := n.GetNATInfo()
+	if info.Type == "open" && info.PublicIP != "" {
+		return net.JoinHostPort(info.PublicIP, strconv.Itoa(port))
+	}
+	return ""
+}
+
+// SetPeerRelay informa a chave pública WireGuard do peer, que também autentica as sondas de
+// hole punching, e o relay entre peers em que ele recebe tráfego, usados por ConnectPeer
+// quando não há caminho direto
+func (n *NATTraversal) SetPeerRelay(nodeID string, publicKey string, home PeerRelayInfo) {
+	n.setPeerKey(nodeID, publicKey)
+	
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	
+	if publicKey == "" || home.Address == "" || home.PublicKey == "" {
+		delete(n.peerRoutes, nodeID)
+		return
+	}
+	n.peerRoutes[nodeID] = peerRelayRoute{publicKey: publicKey, home: home}
+}
+
+// relayPort retorna a porta UDP do relay próprio, zero se o nó não opera relay
+func (n *NATTraversal) relayPort() int {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	if n.relayServer == nil {
+		return 0
+	}
+	return n.relayServer.Addr().Port
+}
+
+// peerRoute retorna como alcançar o peer pelo relay entre peers, se o nó tiver chave
+func (n *NATTraversal) peerRoute(nodeID string) (peerRelayRoute, bool) {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	route, ok := n.peerRoutes[nodeID]
+	return route, ok && n.relayKey != ""
+}
+
+// startPeerRelay abre o relay próprio, se configurado, e inicia a escolha do relay principal.
+// Uma falha no relay próprio não impede o NAT traversal: o nó apenas deixa de operar relay.
+func (n *NATTraversal) startPeerRelay() {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	
+	if n.relayListen != "" && n.relayServer == nil {
+		if err := n.startRelayServer(); err != nil {
+			fmt.Printf("Aviso: relay entre peers desativado: %v\n", err)
+		}
+	}
+	
+	n.relayRunning = true
+	if n.relayKey != "" {
+		go n.selectHomeRelay()
+	}
+}
+
+// startRelayServer abre o relay próprio. Deve ser chamada com peerRelayMutex travado.
+func (n *NATTraversal) startRelayServer() error {
+	if n.relayKey == "" {
+		return fmt.Errorf("o relay entre peers exige a chave privada do nó")
+	}
+	server, err := NewPeerRelayServer(n.relayListen, n.relayKey)
+	if err != nil {
+		return err
+	}
+	server.SetAuthorizer(n.relayAuthorize)
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("erro ao iniciar o relay entre peers: %w", err)
+	}
+	n.relayServer = server
+	return nil
+}
+
+// selectHomeRelay escolhe como relay principal o candidato de menor RTT. Um nó que opera
+// relay alcançável de fora recebe pelo próprio relay.
+func (n *NATTraversal) selectHomeRelay() {
+	n.selectionMutex.Lock()
+	defer n.selectionMutex.Unlock()
+	
+	n.peerRelayMutex.Lock()
+	selfKey := n.relayPublicKey
+	previous := n.homeRelay
+	var candidates []PeerRelayInfo
+	for address, publicKey := range n.relayCandidates {
+		if publicKey != selfKey {
+			candidates = append(candidates, PeerRelayInfo{Address: address, PublicKey: publicKey})
+		}
+	}
+	n.peerRelayMutex.Unlock()
+	if selfKey == "" {
+		return
+	}
+	
+	var best PeerRelayInfo
+	var bestRTT time.Duration
+	if endpoint := n.RelayEndpoint(); endpoint != "" {
+		candidates = []PeerRelayInfo{{Address: endpoint, PublicKey: selfKey}}
+	}
+	for _, candidate := range candidates {
+		client, err := n.peerRelayClient(candidate)
+		if err != nil {
+			fmt.Printf("Relay %s indisponível: %v\n", candidate.Address, err)
+			continue
+		}
+		if best.Address == "" || client.RTT() < bestRTT {
+			best, bestRTT = candidate, client.RTT()
+		}
+	}
+	
+	n.peerRelayMutex.Lock()
+	if n.relayRunning {
+		n.homeRelay = best
+	}
+	n.peerRelayMutex.Unlock()
+	n.closeIdleRelayClients()
+	
+	if best != previous && best.Address != "" {
+		fmt.Printf("Relay principal: %s (RTT %s)\n", best.Address, bestRTT)
+	}
+}
+
+// peerRelayClient retorna a conexão com o relay, conectando se preciso. O relay próprio é
+// acessado pela interface local, já que o endereço público pode não servir de dentro do NAT.
+func (n *NATTraversal) peerRelayClient(relay PeerRelayInfo) (*PeerRelayClient, error) {
+	n.relayDialMutex.Lock()
+	defer n.relayDialMutex.Unlock()
+	
+	n.peerRelayMutex.Lock()
+	existing := n.relayClients[relay.Address]
+	privateKey, selfKey, server := n.relayKey, n.relayPublicKey, n.relayServer
+	n.peerRelayMutex.Unlock()
+	
+	if existing != nil && existing.Healthy() {
+		return existing, nil
+	}
+	
+	address := relay.Address
+	if relay.PublicKey == selfKey && server != nil {
+		address = net.JoinHostPort("127.0.0.1", strconv.Itoa(server.Addr().Port))
+	}
+	client, err := NewPeerRelayClient(address, relay.PublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	client.SetHandler(func(source string, data []byte) {
+		n.deliverRelayed(relay.Address, source, data)
+	})
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
 	
-	// Simulação de conexão via relay
-	fmt.Printf("Simulando conexão via relay TURN com %s:%d\n", remoteIP, remotePort)
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
+	if !n.relayRunning {
+		client.Close()
+		return nil, fmt.Errorf("o serviço de NAT traversal não está em execução")
+	}
+	if existing != nil {
+		existing.Close()
+	}
+	n.relayClients[relay.Address] = client
+	return client, nil
+}
+
+// closeIdleRelayClients fecha as conexões com relays que não são o principal nem levam
+// tráfego de algum peer
+func (n *NATTraversal) closeIdleRelayClients() {
+	n.peerRelayMutex.Lock()
+	defer n.peerRelayMutex.Unlock()
 	
+	inUse := map[string]bool{n.homeRelay.Address: true}
+	for _, address := range n.relayPaths {
+		inUse[address] = true
+	}
+	for address, client := range n.relayClients {
+		if !inUse[address] {
+			client.Close()
+			delete(n.relayClients, address)
+		}
+	}
+}
+
+// peerRelayConnection leva o peer ao relay em que ele recebe tráfego e aponta seu endpoint
+// WireGuard para o proxy local
+func (n *NATTraversal) peerRelayConnection(nodeID string, route peerRelayRoute) error {
+	if _, err := n.peerRelayClient(route.home); err != nil {
+		return fmt.Errorf("relay %s do peer %s indisponível: %w", route.home.Address, nodeID, err)
+	}
+	
+	n.peerRelayMutex.Lock()
+	n.relayPaths[route.publicKey] = route.home.Address
+	previous, ok := n.peerRelayed[nodeID]
+	n.peerRelayed[nodeID] = route.publicKey
+	n.peerRelayMutex.Unlock()
+	
+	// O peer trocou de chave: o proxy anterior deixa de ser usado
+	if ok && previous != route.publicKey {
+		n.relayProxies.remove(previous)
+	}
+	
+	proxy, err := n.relayProxies.add(route.publicKey, n.relaySender(route.publicKey))
+	if err != nil {
+		return err
+	}
+	if err := n.updater().UpdatePeerEndpoint(nodeID, proxy.String()); err != nil {
+		return fmt.Errorf("erro ao apontar o peer %s para o relay: %w", nodeID, err)
+	}
+	n.setPathKeepalive(nodeID, false)
+	
+	fmt.Printf("Peer %s conectado via relay entre peers %s (proxy local %s)\n",
+		nodeID, route.home.Address, proxy)
 	return nil
 }
 
+// relaySender envia ao peer, pelo relay por onde ele é alcançado, o que o WireGuard manda
+// ao proxy dele
+func (n *NATTraversal) relaySender(publicKey string) func(data []byte) error {
+	return func(data []byte) error {
+		n.peerRelayMutex.Lock()
+		client := n.relayClients[n.relayPaths[publicKey]]
+		n.peerRelayMutex.Unlock()
+		if client == nil {
+			return fmt.Errorf("sem conexão com o relay do peer")
+		}
+		return client.Send(publicKey, data)
+	}
+}
+
+// deliverRelayed entrega ao WireGuard os dados recebidos de um peer pelo relay. O primeiro
+// pacote de um peer que alcançou o nó pelo relay cria o proxy dele, e a resposta volta pelo
+// mesmo relay; ao autenticar o pacote, o WireGuard passa a usar o proxy como endpoint.
+func (n *NATTraversal) deliverRelayed(relayAddress string, source string, data []byte) {
+	if n.relayProxies.deliver(source, data) {
+		return
+	}
+	
+	n.peerRelayMutex.Lock()
+	if !n.relayRunning {
+		n.peerRelayMutex.Unlock()
+		return
+	}
+	if _, ok := n.relayPaths[source]; !ok {
+		n.relayPaths[source] = relayAddress
+	}
+	n.peerRelayMutex.Unlock()
+	
+	if _, err := n.relayProxies.add(source, n.relaySender(source)); err != nil {
+		fmt.Printf("Erro ao receber pelo relay de %s: %v\n", source, err)
+		return
+	}
+	n.relayProxies.deliver(source, data)
+}
+
+// dropPeerRelay fecha o proxy do peer no relay entre peers, sem mexer no endpoint
+// WireGuard, e informa se o peer estava no relay
+func (n *NATTraversal) dropPeerRelay(nodeID string) bool {
+	n.peerRelayMutex.Lock()
+	publicKey, ok := n.peerRelayed[nodeID]
+	if ok {
+		delete(n.peerRelayed, nodeID)
+		delete(n.relayPaths, publicKey)
+	}
+	n.peerRelayMutex.Unlock()
+	
+	if ok {
+		n.relayProxies.remove(publicKey)
+	}
+	return ok
+}
+
+// stopPeerRelay devolve os peers aos endpoints configurados, fecha as conexões com relays
+// e encerra o relay próprio
+func (n *NATTraversal) stopPeerRelay() {
+	n.peerRelayMutex.Lock()
+	relayed := n.peerRelayed
+	n.peerRelayed = make(map[string]string)
+	n.relayPaths = make(map[string]string)
+	for address, client := range n.relayClients {
+		client.Close()
+		delete(n.relayClients, address)
+	}
+	if n.relayServer != nil {
+		n.relayServer.Stop()
+		n.relayServer = nil
+	}
+	n.homeRelay = PeerRelayInfo{}
+	n.relayRunning = false
+	n.peerRelayMutex.Unlock()
+	
+	n.relayProxies.close()
+	
+	if updater := n.updater(); updater != nil {
+		for nodeID := range relayed {
+			if err := updater.UpdatePeerEndpoint(nodeID, ""); err != nil {
+				fmt.Printf("Erro ao restaurar endpoint do peer %s: %v\n", nodeID, err)
+			}
+		}
+	}
+}
+	
 // maintenanceRoutine executa tarefas de manutenção periódicas
 func (n *NATTraversal) maintenanceRoutine() {
 	ticker := time.NewTicker(2 * time.Minute)
@@ -272,9 +1458,16 @@ func (n *NATTraversal) maintenanceRoutine() {
 			// Atualizar informações de NAT periodicamente
 			go n.detectNATType()
 			
-			// Renovar mapeamentos UPnP se necessário
-			if n.useUPnP && n.upnpMappingID != "" {
-				go n.setupUPnP()
+			// Os relays candidatos são medidos de novo e o mais próximo passa a ser o principal
+			go n.selectHomeRelay()
+			
+			// Os mapeamentos são renovados pelo próprio lease; aqui tentamos de novo quando
+			// ainda não há mapeamento (ex: o gateway não respondeu antes) e verificamos se o
+			// gateway reiniciou e perdeu o mapeamento existente
+			if len(n.EndpointCandidates()) == 0 {
+				go n.setupPortMapping()
+			} else {
+				go n.checkGatewayReset()
 			}
 			
 		case <-n.stopChan:
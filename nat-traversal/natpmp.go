@@ -0,0 +1,138 @@
+package nattraversal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Mensagens NAT-PMP (RFC 6886)
+const (
+	natpmpVersion           = 0
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpResponse          = 128 // Somado ao opcode nas respostas
+)
+
+// Descrição dos códigos de resultado NAT-PMP
+var natpmpResults = map[int]string{
+	1: "versão não suportada",
+	2: "não autorizado",
+	3: "falha de rede",
+	4: "sem recursos",
+	5: "opcode não suportado",
+}
+
+// NATPMPClient mapeia portas em gateways NAT-PMP (RFC 6886)
+// NATPMPClient maps ports on NAT-PMP gateways (RFC 6886)
+// NATPMPClient mapea puertos en gateways NAT-PMP (RFC 6886)
+type NATPMPClient struct {
+	gatewayClient
+}
+
+// NewNATPMPClient cria um cliente para o gateway informado (host[:porta]); vazio usa o
+// gateway da rota padrão, consultado a cada requisição
+func NewNATPMPClient(gateway string) *NATPMPClient {
+	return &NATPMPClient{gatewayClient: newGatewayClient(gateway)}
+}
+
+// Name retorna o nome do protocolo
+func (c *NATPMPClient) Name() string {
+	return TechniqueNATPMP
+}
+
+// ExternalAddress consulta o endereço externo do gateway
+func (c *NATPMPClient) ExternalAddress() (net.IP, error) {
+	response, _, err := c.request(natpmpOpExternalAddress, nil, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(append([]byte(nil), response[8:12]...)), nil
+}
+
+// AddMapping mapeia a porta UDP interna no gateway
+func (c *NATPMPClient) AddMapping(internalPort, externalPort int, lease time.Duration) (*PortMapping, error) {
+	// A resposta do mapeamento não traz o endereço externo, que é consultado antes
+	externalIP, err := c.ExternalAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.mapUDP(internalPort, externalPort, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortMapping{
+		Protocol:     TechniqueNATPMP,
+		ExternalIP:   externalIP,
+		ExternalPort: int(binary.BigEndian.Uint16(response[10:12])),
+		InternalPort: internalPort,
+		Lease:        time.Duration(binary.BigEndian.Uint32(response[12:16])) * time.Second,
+	}, nil
+}
+
+// RenewMapping repete o pedido de mapeamento, que no NAT-PMP também renova o lease
+func (c *NATPMPClient) RenewMapping(mapping *PortMapping) (*PortMapping, error) {
+	return c.AddMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Lease)
+}
+
+// DeleteMapping remove o mapeamento pedindo lease e porta externa zero
+func (c *NATPMPClient) DeleteMapping(mapping *PortMapping) error {
+	_, err := c.mapUDP(mapping.InternalPort, 0, 0)
+	return err
+}
+
+// GatewayReset consulta o endereço externo e compara a época da resposta com a anterior
+func (c *NATPMPClient) GatewayReset() (bool, error) {
+	_, reset, err := c.request(natpmpOpExternalAddress, nil, 12)
+	return reset, err
+}
+
+// mapUDP envia a requisição de mapeamento UDP e retorna a resposta
+func (c *NATPMPClient) mapUDP(internalPort, externalPort int, lease time.Duration) ([]byte, error) {
+	payload := make([]byte, 10)
+	binary.BigEndian.PutUint16(payload[2:4], uint16(internalPort))
+	binary.BigEndian.PutUint16(payload[4:6], uint16(externalPort))
+	binary.BigEndian.PutUint32(payload[6:10], uint32(lease/time.Second))
+
+	response, _, err := c.request(natpmpOpMapUDP, payload, 16)
+	if err != nil {
+		return nil, err
+	}
+	if int(binary.BigEndian.Uint16(response[8:10])) != internalPort {
+		return nil, fmt.Errorf("resposta NAT-PMP para outra porta interna")
+	}
+	return response, nil
+}
+
+// request executa uma transação NAT-PMP, valida o resultado e registra a época informada
+func (c *NATPMPClient) request(op byte, payload []byte, size int) ([]byte, bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	request := append([]byte{natpmpVersion, op}, payload...)
+	response, err := c.exchange(conn, request, func(data []byte) bool {
+		return len(data) >= 4 && data[1] == natpmpResponse+op
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if response[0] != natpmpVersion {
+		return nil, false, fmt.Errorf("resposta NAT-PMP com versão %d", response[0])
+	}
+	if code := int(binary.BigEndian.Uint16(response[2:4])); code != 0 {
+		return nil, false, &GatewayError{Protocol: TechniqueNATPMP, Code: code, Description: natpmpResults[code]}
+	}
+	if len(response) < size {
+		return nil, false, fmt.Errorf("resposta NAT-PMP truncada")
+	}
+
+	reset := c.epoch.observe(conn.RemoteAddr().String(), binary.BigEndian.Uint32(response[4:8]))
+	return response, reset, nil
+}
@@ -0,0 +1,162 @@
+package nattraversal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Mensagens PCP (RFC 6887)
+const (
+	pcpVersion     = 2
+	pcpOpAnnounce  = 0
+	pcpOpMap       = 1
+	pcpResponse    = 0x80 // Bit R do opcode nas respostas
+	pcpHeaderSize  = 24
+	pcpMapSize     = 36
+	pcpProtocolUDP = 17
+)
+
+// Descrição dos códigos de resultado PCP
+var pcpResults = map[int]string{
+	1:  "UNSUPP_VERSION",
+	2:  "NOT_AUTHORIZED",
+	3:  "MALFORMED_REQUEST",
+	4:  "UNSUPP_OPCODE",
+	5:  "UNSUPP_OPTION",
+	6:  "MALFORMED_OPTION",
+	7:  "NETWORK_FAILURE",
+	8:  "NO_RESOURCES",
+	9:  "UNSUPP_PROTOCOL",
+	10: "USER_EX_QUOTA",
+	11: "CANNOT_PROVIDE_EXTERNAL",
+	12: "ADDRESS_MISMATCH",
+	13: "EXCESSIVE_REMOTE_PEERS",
+}
+
+// ErrPCPUnsupported indica que o gateway respondeu com outra versão, em geral a 0 do NAT-PMP
+var ErrPCPUnsupported = errors.New("o gateway não suporta PCP")
+
+// PCPClient mapeia portas em gateways PCP (RFC 6887) com o opcode MAP
+// PCPClient maps ports on PCP gateways (RFC 6887) with the MAP opcode
+// PCPClient mapea puertos en gateways PCP (RFC 6887) con el opcode MAP
+type PCPClient struct {
+	gatewayClient
+}
+
+// NewPCPClient cria um cliente para o gateway informado (host[:porta]); vazio usa o
+// gateway da rota padrão, consultado a cada requisição
+func NewPCPClient(gateway string) *PCPClient {
+	return &PCPClient{gatewayClient: newGatewayClient(gateway)}
+}
+
+// Name retorna o nome do protocolo
+func (c *PCPClient) Name() string {
+	return TechniquePCP
+}
+
+// AddMapping cria um mapeamento com um nonce novo, que identifica o mapeamento no gateway
+func (c *PCPClient) AddMapping(internalPort, externalPort int, lease time.Duration) (*PortMapping, error) {
+	mapping := &PortMapping{Protocol: TechniquePCP, InternalPort: internalPort, ExternalPort: externalPort, Lease: lease}
+	if _, err := rand.Read(mapping.nonce[:]); err != nil {
+		return nil, fmt.Errorf("erro ao gerar nonce PCP: %w", err)
+	}
+	return c.mapRequest(mapping, lease)
+}
+
+// RenewMapping repete o MAP com o mesmo nonce, o que renova o lease do mapeamento existente
+func (c *PCPClient) RenewMapping(mapping *PortMapping) (*PortMapping, error) {
+	return c.mapRequest(mapping, mapping.Lease)
+}
+
+// DeleteMapping envia o MAP do mapeamento com lease zero
+func (c *PCPClient) DeleteMapping(mapping *PortMapping) error {
+	_, err := c.mapRequest(mapping, 0)
+	return err
+}
+
+// GatewayReset envia um ANNOUNCE e compara a época da resposta com a anterior
+func (c *PCPClient) GatewayReset() (bool, error) {
+	_, reset, err := c.request(pcpOpAnnounce, 0, nil, nil)
+	return reset, err
+}
+
+// mapRequest envia o MAP do mapeamento com o lease informado e retorna o mapeamento concedido
+func (c *PCPClient) mapRequest(mapping *PortMapping, lease time.Duration) (*PortMapping, error) {
+	payload := make([]byte, pcpMapSize)
+	copy(payload[0:12], mapping.nonce[:])
+	payload[12] = pcpProtocolUDP
+	binary.BigEndian.PutUint16(payload[16:18], uint16(mapping.InternalPort))
+	binary.BigEndian.PutUint16(payload[18:20], uint16(mapping.ExternalPort))
+	// Sem preferência de endereço externo: ::ffff:0.0.0.0
+	copy(payload[20:36], net.IPv4zero.To16())
+
+	response, _, err := c.request(pcpOpMap, uint32(lease/time.Second), payload, mapping.nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	result := response[pcpHeaderSize:]
+
+	granted := *mapping
+	granted.ExternalPort = int(binary.BigEndian.Uint16(result[18:20]))
+	granted.ExternalIP = net.IP(append([]byte(nil), result[20:36]...))
+	if ip4 := granted.ExternalIP.To4(); ip4 != nil {
+		granted.ExternalIP = ip4
+	}
+	granted.Lease = time.Duration(binary.BigEndian.Uint32(response[4:8])) * time.Second
+	return &granted, nil
+}
+
+// request executa uma transação PCP, valida o resultado e registra a época informada.
+// Respostas de MAP só são aceitas com o nonce da requisição.
+func (c *PCPClient) request(op byte, lifetime uint32, payload, nonce []byte) ([]byte, bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	// O gateway confere o endereço do cliente com a origem do pacote
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	request := make([]byte, pcpHeaderSize, pcpHeaderSize+len(payload))
+	request[0] = pcpVersion
+	request[1] = op
+	binary.BigEndian.PutUint32(request[4:8], lifetime)
+	copy(request[8:24], clientIP.To16())
+	request = append(request, payload...)
+
+	response, err := c.exchange(conn, request, func(data []byte) bool {
+		if len(data) < 4 || data[1] != pcpResponse|op {
+			return false
+		}
+		// Respostas de erro podem vir sem o payload, mas as completas precisam do nosso nonce
+		if nonce != nil && len(data) >= pcpHeaderSize+pcpMapSize {
+			return bytes.Equal(data[pcpHeaderSize:pcpHeaderSize+12], nonce)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if response[0] != pcpVersion {
+		return nil, false, fmt.Errorf("%w (versão %d)", ErrPCPUnsupported, response[0])
+	}
+	if len(response) < pcpHeaderSize {
+		return nil, false, fmt.Errorf("resposta PCP truncada")
+	}
+	reset := c.epoch.observe(conn.RemoteAddr().String(), binary.BigEndian.Uint32(response[8:12]))
+
+	if code := int(response[3]); code != 0 {
+		return nil, reset, &GatewayError{Protocol: TechniquePCP, Code: code, Description: pcpResults[code]}
+	}
+	if nonce != nil && len(response) < pcpHeaderSize+pcpMapSize {
+		return nil, reset, fmt.Errorf("resposta PCP sem o payload do MAP")
+	}
+	return response, reset, nil
+}
@@ -0,0 +1,548 @@
+package nattraversal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocolo do relay entre peers. Cada nó se autentica no relay com a própria chave
+// WireGuard: o X25519 entre a chave do nó e a do relay gera as chaves da sessão, que
+// assinam o HELLO/WELCOME e cifram os quadros. Os datagramas WireGuard repassados já são
+// cifrados de ponta a ponta; a cifra da sessão esconde do caminho quem fala com quem.
+const (
+	peerRelayHello   = 0x01 // Cliente -> relay: chave, horário, nonce e MAC
+	peerRelayWelcome = 0x02 // Relay -> cliente: nonce do HELLO e MAC
+	peerRelayData    = 0x03 // Cliente -> relay: chave do remetente e quadro cifrado (destino + dados)
+	peerRelayDeliver = 0x04 // Relay -> cliente: quadro cifrado (origem + dados)
+
+	peerRelayKeySize   = 32
+	peerRelayNonceSize = 16
+	peerRelayMACSize   = sha256.Size
+	peerRelayHelloSize = 1 + peerRelayKeySize + 8 + peerRelayNonceSize + peerRelayMACSize
+
+	// Diferença aceita entre o horário do HELLO e o relógio do relay
+	peerRelayClockSkew = time.Minute
+)
+
+// Tempos do relay entre peers
+const (
+	DefaultPeerRelayKeepalive = 25 * time.Second // HELLO periódico, que mantém a sessão e o NAT
+	peerRelaySessionTimeout   = 3 * DefaultPeerRelayKeepalive
+)
+
+// ErrPeerRelayUnauthorized indica que o relay não atende a chave informada
+var ErrPeerRelayUnauthorized = errors.New("chave não autorizada no relay")
+
+// parseRelayPrivateKey decodifica uma chave privada WireGuard (base64) como chave X25519
+func parseRelayPrivateKey(privateKey string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(raw) != peerRelayKeySize {
+		return nil, fmt.Errorf("chave privada inválida para o relay")
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// parseRelayPublicKey decodifica uma chave pública WireGuard (base64) como chave X25519
+func parseRelayPublicKey(publicKey string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(raw) != peerRelayKeySize {
+		return nil, fmt.Errorf("chave pública inválida para o relay: %q", publicKey)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// peerRelayCrypto guarda as chaves de uma sessão entre um cliente e o relay
+type peerRelayCrypto struct {
+	macKey []byte
+	aead   cipher.AEAD
+}
+
+// newPeerRelayCrypto deriva as chaves da sessão do X25519 entre as duas pontas; cliente e
+// relay chegam às mesmas chaves, cada um com a própria chave privada
+func newPeerRelayCrypto(local *ecdh.PrivateKey, remote *ecdh.PublicKey, client, server []byte) (*peerRelayCrypto, error) {
+	shared, err := local.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("erro no acordo de chaves do relay: %w", err)
+	}
+
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, shared)
+		mac.Write([]byte("p2p-vpn relay v1 " + label))
+		mac.Write(client)
+		mac.Write(server)
+		return mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(derive("aead"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &peerRelayCrypto{macKey: derive("mac"), aead: aead}, nil
+}
+
+// sign acrescenta o MAC da sessão aos dados
+func (c *peerRelayCrypto) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(data)
+	return mac.Sum(data)
+}
+
+// verify confere o MAC no fim dos dados e retorna o conteúdo sem ele
+func (c *peerRelayCrypto) verify(data []byte) ([]byte, bool) {
+	if len(data) < peerRelayMACSize {
+		return nil, false
+	}
+	content := data[:len(data)-peerRelayMACSize]
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(content)
+	return content, hmac.Equal(mac.Sum(nil), data[len(content):])
+}
+
+// seal cifra o conteúdo após o cabeçalho, que é autenticado mas segue em claro
+func (c *peerRelayCrypto) seal(header, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	frame := append(append([]byte(nil), header...), nonce...)
+	return c.aead.Seal(frame, nonce, plaintext, header), nil
+}
+
+// open decifra o quadro cujo cabeçalho tem headerSize bytes
+func (c *peerRelayCrypto) open(frame []byte, headerSize int) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(frame) < headerSize+nonceSize+c.aead.Overhead() {
+		return nil, fmt.Errorf("quadro do relay truncado")
+	}
+	header := frame[:headerSize]
+	nonce := frame[headerSize : headerSize+nonceSize]
+	return c.aead.Open(nil, nonce, frame[headerSize+nonceSize:], header)
+}
+
+// peerRelayStream envia quadros por uma conexão TLS, com o tamanho à frente de cada um
+type peerRelayStream struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+func (s *peerRelayStream) write(frame []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(frame))), frame...))
+	return err
+}
+
+// readPeerRelayFrame lê um quadro de uma conexão TLS
+func readPeerRelayFrame(conn net.Conn, buffer []byte) ([]byte, error) {
+	if _, err := io.ReadFull(conn, buffer[:2]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(buffer[:2]))
+	if _, err := io.ReadFull(conn, buffer[:length]); err != nil {
+		return nil, err
+	}
+	return buffer[:length], nil
+}
+
+// peerRelaySession é um cliente conectado ao relay
+type peerRelaySession struct {
+	crypto    *peerRelayCrypto
+	udpAddr   *net.UDPAddr     // Endereço do cliente via UDP, nil se conectado por TLS
+	stream    *peerRelayStream // Conexão TLS do cliente, nil se conectado por UDP
+	lastHello uint64           // Horário do último HELLO aceito, que precisa sempre crescer
+	lastSeen  time.Time
+}
+
+// PeerRelayServer repassa datagramas WireGuard opacos entre nós da malha autenticados pela
+// chave pública. Escuta em UDP e, como alternativa para redes que bloqueiam UDP, em TLS
+// sobre TCP na mesma porta.
+// PeerRelayServer forwards opaque WireGuard datagrams between mesh nodes authenticated by
+// public key. It listens on UDP and, as a fallback for networks blocking UDP, on TLS over
+// TCP on the same port.
+// PeerRelayServer reenvía datagramas WireGuard opacos entre nodos de la malla autenticados
+// por clave pública. Escucha en UDP y, como alternativa para redes que bloquean UDP, en TLS
+// sobre TCP en el mismo puerto.
+type PeerRelayServer struct {
+	listenAddr string
+	key        *ecdh.PrivateKey
+	authorize  func(publicKey string) bool
+
+	udp      *net.UDPConn
+	tcp      net.Listener
+	sessions map[string]*peerRelaySession // Clientes por chave pública (bytes)
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewPeerRelayServer cria um relay que escuta em listenAddr (ex: ":3479") e se autentica
+// com a chave privada WireGuard do nó (base64)
+func NewPeerRelayServer(listenAddr, privateKey string) (*PeerRelayServer, error) {
+	key, err := parseRelayPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerRelayServer{
+		listenAddr: listenAddr,
+		key:        key,
+		sessions:   make(map[string]*peerRelaySession),
+		stopChan:   make(chan struct{}),
+	}, nil
+}
+
+// SetAuthorizer define quais chaves públicas (base64) podem usar o relay. Sem autorizador,
+// qualquer nó que prove possuir sua chave é atendido.
+func (s *PeerRelayServer) SetAuthorizer(authorize func(publicKey string) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authorize = authorize
+}
+
+// PublicKey retorna a chave pública do relay (base64), que os clientes precisam conhecer
+func (s *PeerRelayServer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.PublicKey().Bytes())
+}
+
+// Addr retorna o endereço UDP em que o relay escuta
+func (s *PeerRelayServer) Addr() *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr().(*net.UDPAddr)
+}
+
+// Clients retorna o número de nós conectados ao relay
+func (s *PeerRelayServer) Clients() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.sessions)
+}
+
+// Start abre os sockets UDP e TLS do relay
+func (s *PeerRelayServer) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running {
+		return fmt.Errorf("o relay já está em execução")
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("endereço do relay inválido: %w", err)
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("erro ao escutar UDP no relay: %w", err)
+	}
+
+	certificate, err := selfSignedCertificate()
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	// A porta TCP acompanha a UDP, que pode ter sido escolhida pelo sistema
+	bound := udp.LocalAddr().(*net.UDPAddr)
+	tcpAddr := net.JoinHostPort(udpAddr.IP.String(), strconv.Itoa(bound.Port))
+	if udpAddr.IP == nil {
+		tcpAddr = net.JoinHostPort("", strconv.Itoa(bound.Port))
+	}
+	tcp, err := tls.Listen("tcp", tcpAddr, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("erro ao escutar TLS no relay: %w", err)
+	}
+
+	s.udp = udp
+	s.tcp = tcp
+	s.stopChan = make(chan struct{})
+	s.running = true
+
+	go s.serveUDP(udp)
+	go s.serveTCP(tcp)
+	go s.expireSessions()
+
+	fmt.Printf("Relay entre peers escutando em %s (UDP e TLS)\n", bound)
+	return nil
+}
+
+// Stop fecha os sockets e encerra as sessões
+func (s *PeerRelayServer) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return nil
+	}
+	close(s.stopChan)
+	s.udp.Close()
+	s.tcp.Close()
+	for key, session := range s.sessions {
+		if session.stream != nil {
+			session.stream.conn.Close()
+		}
+		delete(s.sessions, key)
+	}
+	s.running = false
+	return nil
+}
+
+// serveUDP atende os quadros recebidos por UDP
+func (s *PeerRelayServer) serveUDP(conn *net.UDPConn) {
+	buffer := make([]byte, 65535)
+	for {
+		n, src, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.handleFrame(buffer[:n], src, nil)
+	}
+}
+
+// serveTCP aceita as conexões TLS dos clientes sem UDP
+func (s *PeerRelayServer) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go s.serveStream(&peerRelayStream{conn: conn})
+	}
+}
+
+// serveStream atende os quadros de uma conexão TLS e remove suas sessões quando ela cai
+func (s *PeerRelayServer) serveStream(stream *peerRelayStream) {
+	defer func() {
+		stream.conn.Close()
+		s.mutex.Lock()
+		for key, session := range s.sessions {
+			if session.stream == stream {
+				delete(s.sessions, key)
+			}
+		}
+		s.mutex.Unlock()
+	}()
+
+	buffer := make([]byte, 65535)
+	for {
+		stream.conn.SetReadDeadline(time.Now().Add(peerRelaySessionTimeout))
+		frame, err := readPeerRelayFrame(stream.conn, buffer)
+		if err != nil {
+			return
+		}
+		s.handleFrame(frame, nil, stream)
+	}
+}
+
+// handleFrame trata um quadro vindo de um endereço UDP ou de uma conexão TLS
+func (s *PeerRelayServer) handleFrame(frame []byte, src *net.UDPAddr, stream *peerRelayStream) {
+	if len(frame) == 0 {
+		return
+	}
+	switch frame[0] {
+	case peerRelayHello:
+		s.handleHello(frame, src, stream)
+	case peerRelayData:
+		s.handleData(frame)
+	}
+}
+
+// handleHello autentica o cliente e registra (ou renova) sua sessão
+func (s *PeerRelayServer) handleHello(frame []byte, src *net.UDPAddr, stream *peerRelayStream) {
+	if len(frame) != peerRelayHelloSize {
+		return
+	}
+	clientKey := frame[1 : 1+peerRelayKeySize]
+	timestamp := binary.BigEndian.Uint64(frame[1+peerRelayKeySize : 1+peerRelayKeySize+8])
+	nonce := frame[1+peerRelayKeySize+8 : 1+peerRelayKeySize+8+peerRelayNonceSize]
+
+	s.mutex.Lock()
+	authorize := s.authorize
+	session := s.sessions[string(clientKey)]
+	s.mutex.Unlock()
+
+	// O próprio nó sempre usa o relay que opera
+	self := bytes.Equal(clientKey, s.key.PublicKey().Bytes())
+	if authorize != nil && !self && !authorize(base64.StdEncoding.EncodeToString(clientKey)) {
+		return
+	}
+
+	// A sessão existente já tem as chaves; um cliente novo exige o acordo X25519
+	var crypto *peerRelayCrypto
+	if session != nil {
+		crypto = session.crypto
+	} else {
+		remote, err := ecdh.X25519().NewPublicKey(clientKey)
+		if err != nil {
+			return
+		}
+		crypto, err = newPeerRelayCrypto(s.key, remote, clientKey, s.key.PublicKey().Bytes())
+		if err != nil {
+			return
+		}
+	}
+	if _, ok := crypto.verify(frame); !ok {
+		return
+	}
+
+	// HELLOs antigos ou repetidos não podem desviar a sessão para outro endereço
+	sent := time.Unix(0, int64(timestamp))
+	if skew := time.Since(sent); skew > peerRelayClockSkew || skew < -peerRelayClockSkew {
+		return
+	}
+
+	s.mutex.Lock()
+	if current := s.sessions[string(clientKey)]; current != nil {
+		if timestamp <= current.lastHello {
+			s.mutex.Unlock()
+			return
+		}
+		session = current
+	} else {
+		session = &peerRelaySession{crypto: crypto}
+		s.sessions[string(clientKey)] = session
+	}
+	session.udpAddr = src
+	session.stream = stream
+	session.lastHello = timestamp
+	session.lastSeen = time.Now()
+	s.mutex.Unlock()
+
+	welcome := crypto.sign(append([]byte{peerRelayWelcome}, nonce...))
+	s.send(session, welcome)
+}
+
+// handleData decifra o quadro do remetente e o entrega, recifrado, ao destino
+func (s *PeerRelayServer) handleData(frame []byte) {
+	if len(frame) < 1+peerRelayKeySize {
+		return
+	}
+	senderKey := frame[1 : 1+peerRelayKeySize]
+
+	s.mutex.Lock()
+	sender := s.sessions[string(senderKey)]
+	s.mutex.Unlock()
+	if sender == nil {
+		return
+	}
+
+	plaintext, err := sender.crypto.open(frame, 1+peerRelayKeySize)
+	if err != nil || len(plaintext) < peerRelayKeySize {
+		return
+	}
+	destKey := plaintext[:peerRelayKeySize]
+
+	s.mutex.Lock()
+	dest := s.sessions[string(destKey)]
+	s.mutex.Unlock()
+	if dest == nil {
+		return
+	}
+
+	// O destino recebe a chave do remetente no lugar da sua
+	copy(plaintext[:peerRelayKeySize], senderKey)
+	delivery, err := dest.crypto.seal([]byte{peerRelayDeliver}, plaintext)
+	if err != nil {
+		return
+	}
+	s.send(dest, delivery)
+}
+
+// send envia um quadro à sessão pelo transporte em que ela se conectou
+func (s *PeerRelayServer) send(session *peerRelaySession, frame []byte) {
+	s.mutex.Lock()
+	udpAddr, stream := session.udpAddr, session.stream
+	conn := s.udp
+	s.mutex.Unlock()
+
+	if stream != nil {
+		stream.write(frame)
+	} else if udpAddr != nil && conn != nil {
+		conn.WriteToUDP(frame, udpAddr)
+	}
+}
+
+// expireSessions remove os clientes que pararam de enviar HELLO
+func (s *PeerRelayServer) expireSessions() {
+	s.mutex.Lock()
+	stopChan := s.stopChan
+	s.mutex.Unlock()
+
+	ticker := time.NewTicker(DefaultPeerRelayKeepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			for key, session := range s.sessions {
+				if time.Since(session.lastSeen) > peerRelaySessionTimeout {
+					delete(s.sessions, key)
+				}
+			}
+			s.mutex.Unlock()
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// selfSignedCertificate gera o certificado do TLS do relay. Os clientes não o validam: a
+// autenticação do relay é o WELCOME assinado com a chave derivada da chave WireGuard.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("erro ao gerar chave TLS do relay: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("erro ao gerar certificado TLS do relay: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
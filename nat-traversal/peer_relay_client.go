@@ -0,0 +1,392 @@
+package nattraversal
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transportes do cliente do relay entre peers
+const (
+	PeerRelayTransportUDP = "udp"
+	PeerRelayTransportTLS = "tls"
+)
+
+// PeerRelayClient conecta o nó a um relay entre peers. Tenta UDP primeiro e recorre ao TLS
+// sobre TCP quando o UDP não recebe resposta.
+// PeerRelayClient connects the node to a peer relay. It tries UDP first and falls back to
+// TLS over TCP when UDP gets no answer.
+// PeerRelayClient conecta el nodo a un relay entre pares. Intenta UDP primero y recurre a
+// TLS sobre TCP cuando UDP no recibe respuesta.
+type PeerRelayClient struct {
+	address string
+	key     *ecdh.PrivateKey
+	crypto  *peerRelayCrypto
+
+	rto           time.Duration
+	transmissions int
+	keepalive     time.Duration
+	handler       func(source string, data []byte)
+
+	conn        net.Conn
+	stream      *peerRelayStream // Preenchido quando o transporte é TLS
+	transport   string
+	rtt         time.Duration
+	lastWelcome time.Time
+	welcomes    chan []byte // Nonces dos WELCOMEs recebidos durante a conexão
+	probeNonce  []byte      // HELLO de manutenção aguardando WELCOME, para medir o RTT
+	probeSent   time.Time
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewPeerRelayClient cria um cliente para o relay em address (host:porta), identificado pela
+// chave pública serverKey, autenticando-se com a chave privada WireGuard do nó (base64)
+func NewPeerRelayClient(address, serverKey, privateKey string) (*PeerRelayClient, error) {
+	key, err := parseRelayPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	server, err := parseRelayPublicKey(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	crypto, err := newPeerRelayCrypto(key, server, key.PublicKey().Bytes(), server.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &PeerRelayClient{
+		address:       address,
+		key:           key,
+		crypto:        crypto,
+		rto:           DefaultSTUNRTO,
+		transmissions: DefaultSTUNTransmissions,
+		keepalive:     DefaultPeerRelayKeepalive,
+		welcomes:      make(chan []byte, 4),
+		stopChan:      make(chan struct{}),
+	}, nil
+}
+
+// SetRetransmission ajusta o timeout inicial e o número de envios do HELLO por transporte
+func (c *PeerRelayClient) SetRetransmission(rto time.Duration, transmissions int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rto = rto
+	c.transmissions = transmissions
+}
+
+// SetKeepalive ajusta o intervalo dos HELLOs que mantêm a sessão no relay
+func (c *PeerRelayClient) SetKeepalive(interval time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.keepalive = interval
+}
+
+// SetHandler define quem recebe os dados entregues pelo relay, com a chave pública (base64)
+// do remetente
+func (c *PeerRelayClient) SetHandler(handler func(source string, data []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handler = handler
+}
+
+// Address retorna o endereço do relay
+func (c *PeerRelayClient) Address() string {
+	return c.address
+}
+
+// Transport retorna o transporte em uso ("udp" ou "tls"), vazio se desconectado
+func (c *PeerRelayClient) Transport() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.transport
+}
+
+// RTT retorna o tempo de ida e volta medido no último HELLO respondido
+func (c *PeerRelayClient) RTT() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rtt
+}
+
+// Healthy informa se o relay respondeu aos HELLOs recentes
+func (c *PeerRelayClient) Healthy() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.running && time.Since(c.lastWelcome) < 3*c.keepalive
+}
+
+// Connect autentica o nó no relay, por UDP ou, se não houver resposta, por TLS
+func (c *PeerRelayClient) Connect() error {
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.mutex.Unlock()
+
+	udpErr := c.connect(PeerRelayTransportUDP)
+	if udpErr == nil {
+		return nil
+	}
+	if tlsErr := c.connect(PeerRelayTransportTLS); tlsErr != nil {
+		return fmt.Errorf("relay %s indisponível: UDP: %v; TLS: %w", c.address, udpErr, tlsErr)
+	}
+	return nil
+}
+
+// connect abre o transporte e conclui o HELLO; em caso de falha, fecha a conexão
+func (c *PeerRelayClient) connect(transport string) error {
+	c.mutex.Lock()
+	rto, transmissions := c.rto, c.transmissions
+	c.mutex.Unlock()
+
+	timeout := rto * time.Duration(transmissions)
+	var conn net.Conn
+	var stream *peerRelayStream
+	var err error
+	if transport == PeerRelayTransportUDP {
+		conn, err = net.Dial("udp", c.address)
+	} else {
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, &tls.Config{
+			// O certificado é autoassinado; o relay se autentica pelo WELCOME
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS13,
+		})
+		if err == nil {
+			stream = &peerRelayStream{conn: conn}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao conectar ao relay: %w", err)
+	}
+
+	// Descarta WELCOMEs atrasados de tentativas anteriores
+	for len(c.welcomes) > 0 {
+		<-c.welcomes
+	}
+	go c.receive(conn, stream)
+
+	wait := rto
+	for attempt := 0; attempt < transmissions; attempt++ {
+		hello, nonce, err := c.hello()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		sent := time.Now()
+		if err := c.write(conn, stream, hello); err != nil {
+			conn.Close()
+			return fmt.Errorf("erro ao enviar HELLO ao relay: %w", err)
+		}
+
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case echoed := <-c.welcomes:
+				if string(echoed) != string(nonce) {
+					continue
+				}
+				timer.Stop()
+				c.mutex.Lock()
+				c.conn = conn
+				c.stream = stream
+				c.transport = transport
+				c.rtt = time.Since(sent)
+				c.lastWelcome = time.Now()
+				c.stopChan = make(chan struct{})
+				c.running = true
+				c.mutex.Unlock()
+
+				go c.maintain()
+				return nil
+			case <-timer.C:
+				break waiting
+			}
+		}
+		wait *= 2
+	}
+
+	conn.Close()
+	return fmt.Errorf("relay não respondeu ao HELLO via %s", transport)
+}
+
+// hello monta um HELLO com horário atual e nonce novo
+func (c *PeerRelayClient) hello() ([]byte, []byte, error) {
+	nonce := make([]byte, peerRelayNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	frame := append([]byte{peerRelayHello}, c.key.PublicKey().Bytes()...)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(time.Now().UnixNano()))
+	frame = append(frame, nonce...)
+	return c.crypto.sign(frame), nonce, nil
+}
+
+// write envia um quadro pelo transporte da conexão
+func (c *PeerRelayClient) write(conn net.Conn, stream *peerRelayStream, frame []byte) error {
+	if stream != nil {
+		return stream.write(frame)
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// Send envia dados pelo relay ao nó com a chave pública dest (base64)
+func (c *PeerRelayClient) Send(dest string, data []byte) error {
+	destKey, err := parseRelayPublicKey(dest)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	conn, stream, running := c.conn, c.stream, c.running
+	c.mutex.Unlock()
+	if !running {
+		return fmt.Errorf("cliente do relay não está conectado")
+	}
+
+	header := append([]byte{peerRelayData}, c.key.PublicKey().Bytes()...)
+	frame, err := c.crypto.seal(header, append(destKey.Bytes(), data...))
+	if err != nil {
+		return err
+	}
+	return c.write(conn, stream, frame)
+}
+
+// Close encerra a conexão com o relay; a sessão expira sozinha no servidor
+func (c *PeerRelayClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.running {
+		return nil
+	}
+	close(c.stopChan)
+	c.conn.Close()
+	c.running = false
+	c.transport = ""
+	return nil
+}
+
+// maintain renova a sessão com HELLOs periódicos
+func (c *PeerRelayClient) maintain() {
+	c.mutex.Lock()
+	stopChan := c.stopChan
+	conn, stream := c.conn, c.stream
+	interval := c.keepalive
+	c.mutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			hello, nonce, err := c.hello()
+			if err != nil {
+				continue
+			}
+			// O RTT é atualizado quando o WELCOME deste HELLO chega
+			c.mutex.Lock()
+			c.probeNonce = nonce
+			c.probeSent = time.Now()
+			c.mutex.Unlock()
+			if err := c.write(conn, stream, hello); err != nil {
+				fmt.Printf("Erro ao renovar sessão no relay %s: %v\n", c.address, err)
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// receive lê os quadros do relay até a conexão ser fechada
+func (c *PeerRelayClient) receive(conn net.Conn, stream *peerRelayStream) {
+	buffer := make([]byte, 65535)
+	for {
+		var frame []byte
+		if stream != nil {
+			var err error
+			if frame, err = readPeerRelayFrame(conn, buffer); err != nil {
+				c.disconnected(conn)
+				return
+			}
+		} else {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			frame = buffer[:n]
+		}
+		c.handleFrame(frame)
+	}
+}
+
+// disconnected marca o cliente como desconectado quando o relay fecha a conexão TLS
+func (c *PeerRelayClient) disconnected(conn net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.running && c.conn == conn {
+		close(c.stopChan)
+		c.running = false
+		c.transport = ""
+	}
+	conn.Close()
+}
+
+// handleFrame trata WELCOMEs e entregas vindas do relay
+func (c *PeerRelayClient) handleFrame(frame []byte) {
+	if len(frame) == 0 {
+		return
+	}
+	switch frame[0] {
+	case peerRelayWelcome:
+		content, ok := c.crypto.verify(frame)
+		if !ok || len(content) != 1+peerRelayNonceSize {
+			return
+		}
+		nonce := content[1:]
+		c.mutex.Lock()
+		c.lastWelcome = time.Now()
+		running := c.running
+		if running && string(nonce) == string(c.probeNonce) {
+			c.rtt = time.Since(c.probeSent)
+			c.probeNonce = nil
+		}
+		c.mutex.Unlock()
+		if !running {
+			select {
+			case c.welcomes <- append([]byte(nil), nonce...):
+			default:
+			}
+		}
+	case peerRelayDeliver:
+		plaintext, err := c.crypto.open(frame, 1)
+		if err != nil || len(plaintext) < peerRelayKeySize {
+			return
+		}
+		c.mutex.Lock()
+		handler := c.handler
+		c.mutex.Unlock()
+		if handler != nil {
+			source := base64.StdEncoding.EncodeToString(plaintext[:peerRelayKeySize])
+			handler(source, plaintext[peerRelayKeySize:])
+		}
+	}
+}
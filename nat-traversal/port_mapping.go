@@ -0,0 +1,219 @@
+package nattraversal
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Parâmetros comuns do mapeamento de portas no gateway
+const (
+	DefaultPortMappingLease = time.Hour // Duração pedida para o mapeamento, renovado na metade do prazo
+
+	// Porta em que o gateway atende NAT-PMP e PCP
+	PortMappingServerPort = 5351
+
+	// Retransmissão das requisições NAT-PMP e PCP: 250ms dobrando a cada tentativa (RFC 6886 §3.1)
+	DefaultPortMappingRTO           = 250 * time.Millisecond
+	DefaultPortMappingTransmissions = 4
+)
+
+// DefaultPortMappingProtocols é a ordem padrão de tentativa: PCP sucede o NAT-PMP e ambos
+// respondem bem mais rápido que a descoberta SSDP do UPnP
+var DefaultPortMappingProtocols = []string{TechniquePCP, TechniqueNATPMP, TechniqueUPnP}
+
+// PortMapping é um mapeamento UDP de uma porta externa do gateway para uma porta local
+// PortMapping is a UDP mapping from an external gateway port to a local port
+// PortMapping es un mapeo UDP de un puerto externo del gateway a un puerto local
+type PortMapping struct {
+	Protocol     string // Protocolo que criou o mapeamento (pcp, natpmp, upnp)
+	ExternalIP   net.IP
+	ExternalPort int
+	InternalPort int
+	Lease        time.Duration // Lease concedido pelo gateway, zero para permanente
+
+	gateway *UPnPGateway // Gateway UPnP que mantém o mapeamento
+	nonce   [12]byte     // Nonce PCP, repetido nas renovações e na remoção
+}
+
+// Endpoint retorna o endereço externo do mapeamento (host:porta)
+func (m *PortMapping) Endpoint() string {
+	return net.JoinHostPort(m.ExternalIP.String(), strconv.Itoa(m.ExternalPort))
+}
+
+// PortMapper é um protocolo de mapeamento de portas no gateway (PCP, NAT-PMP ou UPnP)
+// PortMapper is a gateway port mapping protocol (PCP, NAT-PMP or UPnP)
+// PortMapper es un protocolo de mapeo de puertos en el gateway (PCP, NAT-PMP o UPnP)
+type PortMapper interface {
+	// Name retorna o nome do protocolo
+	Name() string
+
+	// AddMapping mapeia a porta UDP interna, pedindo a porta externa sugerida. lease zero
+	// pede um mapeamento permanente, que nem todo protocolo concede.
+	AddMapping(internalPort, externalPort int, lease time.Duration) (*PortMapping, error)
+
+	// RenewMapping renova, antes do fim do lease, um mapeamento criado por este protocolo
+	RenewMapping(mapping *PortMapping) (*PortMapping, error)
+
+	// DeleteMapping remove o mapeamento do gateway
+	DeleteMapping(mapping *PortMapping) error
+
+	// GatewayReset consulta o gateway e indica se ele perdeu os mapeamentos desde a
+	// resposta anterior, como acontece quando o roteador reinicia
+	GatewayReset() (bool, error)
+}
+
+// NewPortMappers cria os clientes dos protocolos informados, na mesma ordem. "none" desativa
+// o mapeamento de portas. Os clientes NAT-PMP e PCP usam o gateway da rota padrão.
+func NewPortMappers(protocols []string) ([]PortMapper, error) {
+	var mappers []PortMapper
+	seen := make(map[string]bool)
+	for _, protocol := range protocols {
+		if seen[protocol] {
+			return nil, fmt.Errorf("protocolo de mapeamento de portas repetido: %s", protocol)
+		}
+		seen[protocol] = true
+
+		switch protocol {
+		case TechniquePCP:
+			mappers = append(mappers, NewPCPClient(""))
+		case TechniqueNATPMP:
+			mappers = append(mappers, NewNATPMPClient(""))
+		case TechniqueUPnP:
+			mappers = append(mappers, NewUPnPClient())
+		case "none":
+		default:
+			return nil, fmt.Errorf("protocolo de mapeamento de portas desconhecido: %s", protocol)
+		}
+	}
+	if seen["none"] && len(mappers) > 0 {
+		return nil, fmt.Errorf("\"none\" não pode ser combinado com outros protocolos de mapeamento")
+	}
+	return mappers, nil
+}
+
+// GatewayError é um código de resultado diferente de sucesso em uma resposta NAT-PMP ou PCP
+// GatewayError is a non-success result code in a NAT-PMP or PCP response
+// GatewayError es un código de resultado distinto de éxito en una respuesta NAT-PMP o PCP
+type GatewayError struct {
+	Protocol    string
+	Code        int
+	Description string
+}
+
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("gateway %s retornou erro %d: %s", e.Protocol, e.Code, e.Description)
+}
+
+// gatewayEpoch acompanha a época ("segundos desde o início") informada pelo gateway. Um
+// gateway que reiniciou volta a contar do zero, ou passa a contar em ritmo diferente do
+// relógio local, e nos dois casos perdeu os mapeamentos (RFC 6886 §3.6, RFC 6887 §8.5).
+type gatewayEpoch struct {
+	gateway  string // Gateway a que a época se refere
+	epoch    uint32
+	received time.Time
+	mutex    sync.Mutex
+}
+
+// observe registra a época de uma resposta do gateway e indica se ele perdeu o estado
+func (e *gatewayEpoch) observe(gateway string, epoch uint32) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	reset := false
+	if e.gateway == gateway {
+		// Tolerância de 2s e 1/16 para a imprecisão dos relógios, como no RFC 6887
+		client := int64(now.Sub(e.received) / time.Second)
+		server := int64(epoch) - int64(e.epoch)
+		reset = server < -1 || client+2 < server-server/16 || server+2 < client-client/16
+	}
+
+	e.gateway = gateway
+	e.epoch = epoch
+	e.received = now
+	return reset
+}
+
+// gatewayClient reúne o que os clientes NAT-PMP e PCP têm em comum: o endereço do gateway,
+// a retransmissão das requisições e o acompanhamento da época
+type gatewayClient struct {
+	gateway       string // host[:porta]; vazio usa o gateway da rota padrão
+	rto           time.Duration
+	transmissions int
+	epoch         gatewayEpoch
+}
+
+func newGatewayClient(gateway string) gatewayClient {
+	return gatewayClient{
+		gateway:       gateway,
+		rto:           DefaultPortMappingRTO,
+		transmissions: DefaultPortMappingTransmissions,
+	}
+}
+
+// SetRetransmission ajusta o intervalo inicial e o número de envios de cada requisição
+func (c *gatewayClient) SetRetransmission(rto time.Duration, transmissions int) {
+	if rto > 0 {
+		c.rto = rto
+	}
+	if transmissions > 0 {
+		c.transmissions = transmissions
+	}
+}
+
+// dial abre um socket conectado ao gateway configurado ou ao da rota padrão
+func (c *gatewayClient) dial() (*net.UDPConn, error) {
+	gateway := c.gateway
+	if gateway == "" {
+		ip, err := defaultGateway()
+		if err != nil {
+			return nil, fmt.Errorf("gateway padrão não encontrado: %w", err)
+		}
+		gateway = ip.String()
+	}
+	if _, _, err := net.SplitHostPort(gateway); err != nil {
+		gateway = net.JoinHostPort(gateway, strconv.Itoa(PortMappingServerPort))
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", gateway)
+	if err != nil {
+		return nil, fmt.Errorf("endereço do gateway inválido: %w", err)
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("sem rota até o gateway: %w", err)
+	}
+	return conn, nil
+}
+
+// exchange envia a requisição e espera a resposta aceita por match, retransmitindo com o
+// intervalo dobrado a cada tentativa
+func (c *gatewayClient) exchange(conn *net.UDPConn, request []byte, match func([]byte) bool) ([]byte, error) {
+	buffer := make([]byte, 1100) // Tamanho máximo de uma mensagem PCP
+	timeout := c.rto
+	for attempt := 0; attempt < c.transmissions; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("erro ao enviar requisição ao gateway: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				// ICMP port unreachable: o gateway não atende este protocolo
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					return nil, fmt.Errorf("gateway não respondeu: %w", err)
+				}
+				break
+			}
+			if match(buffer[:n]) {
+				return append([]byte(nil), buffer[:n]...), nil
+			}
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("gateway %s não respondeu após %d tentativas", conn.RemoteAddr(), c.transmissions)
+}
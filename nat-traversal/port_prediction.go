@@ -0,0 +1,389 @@
+package nattraversal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Orçamento do hole punching com NAT simétrico. O lado simétrico abre DefaultBirthdaySockets
+// sockets, cada um com um mapeamento próprio voltado ao peer, e o outro lado sonda até
+// DefaultBirthdayProbes portas do IP externo dele: com portas aleatórias entre 1024 e 65535,
+// a chance de alguma sonda acertar um dos mapeamentos é de cerca de 98%.
+const (
+	DefaultBirthdaySockets = 256
+	DefaultBirthdayProbes  = 1024
+	birthdayResend         = 500 * time.Millisecond // Intervalo entre as sondas de cada socket do lado simétrico
+	birthdayMinPort        = 1024
+	birthdayMaxPort        = 65535
+)
+
+// ErrUnpredictablePorts indica que os dois peers estão atrás de NAT simétrico e ao menos um
+// deles aloca portas sem padrão, o que torna o hole punching inviável
+var ErrUnpredictablePorts = errors.New("NAT simétrico nos dois peers sem alocação de portas previsível")
+
+// PortPrediction descreve como o NAT simétrico de um nó aloca as portas externas, medido por
+// consultas STUN a destinos diferentes a partir do mesmo socket
+// PortPrediction describes how a node's symmetric NAT allocates external ports, measured by
+// STUN queries to different destinations from the same socket
+// PortPrediction describe cómo el NAT simétrico de un nodo asigna los puertos externos,
+// medido por consultas STUN a destinos distintos desde el mismo socket
+type PortPrediction struct {
+	IP       string // IP externo do NAT
+	LastPort int    // Última porta externa observada
+	Delta    int    // Passo entre portas alocadas em sequência; zero quando não há padrão
+}
+
+// Predict retorna as próximas count portas que o NAT deve alocar, ou nenhuma se a alocação
+// não tem padrão
+func (p PortPrediction) Predict(count int) []int {
+	if p.Delta == 0 || count <= 0 {
+		return nil
+	}
+	ports := make([]int, 0, count)
+	for k := 1; len(ports) < count; k++ {
+		port := p.LastPort + p.Delta*k
+		if port < 1 || port > birthdayMaxPort {
+			break
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// String descreve a previsão para os logs
+func (p PortPrediction) String() string {
+	if p.Delta == 0 {
+		return fmt.Sprintf("%s, portas sem padrão", p.IP)
+	}
+	return fmt.Sprintf("%s, última porta %d, passo %d", p.IP, p.LastPort, p.Delta)
+}
+
+// MeasurePortAllocation mede como o NAT aloca portas externas. Pelo mesmo socket, consulta
+// cada servidor e, nos que suportam RFC 5780, também os outros três pares de IP e porta. No
+// NAT simétrico cada destino ganha um mapeamento novo, e o passo entre as portas mapeadas em
+// sequência, quando se repete na maioria das consultas, permite prever as próximas.
+func (c *STUNClient) MeasurePortAllocation(conn net.PacketConn) (*PortPrediction, error) {
+	var ports []int
+	var ip net.IP
+	sample := func(binding *STUNBinding) {
+		ports = append(ports, binding.Mapped.Port)
+		ip = binding.Mapped.IP
+	}
+
+	for _, server := range c.servers {
+		binding, err := c.BindServer(conn, server)
+		if err != nil {
+			fmt.Printf("Servidor STUN %s falhou: %v\n", stunServerAddr(server), err)
+			continue
+		}
+		sample(binding)
+
+		other := binding.OtherAddress
+		primary, err := net.ResolveUDPAddr("udp", binding.Server)
+		if other == nil || err != nil {
+			continue
+		}
+		for _, addr := range []*net.UDPAddr{
+			{IP: primary.IP, Port: other.Port},
+			{IP: other.IP, Port: primary.Port},
+			other,
+		} {
+			if binding, err := c.bindAddr(conn, addr, nil); err == nil {
+				sample(binding)
+			}
+		}
+	}
+
+	if len(ports) < 2 {
+		return nil, fmt.Errorf("a medição da alocação de portas requer ao menos dois destinos STUN, obtidos %d", len(ports))
+	}
+	return &PortPrediction{
+		IP:       ip.String(),
+		LastPort: ports[len(ports)-1],
+		Delta:    allocationDelta(ports),
+	}, nil
+}
+
+// allocationDelta retorna o passo entre portas consecutivas que aparece em mais da metade
+// das alocações, ou zero se nenhum passo predomina
+func allocationDelta(ports []int) int {
+	counts := make(map[int]int)
+	for i := 1; i < len(ports); i++ {
+		counts[ports[i]-ports[i-1]]++
+	}
+	for delta, count := range counts {
+		if delta != 0 && count*2 > len(ports)-1 {
+			return delta
+		}
+	}
+	return 0
+}
+
+// birthdayPorts escolhe as portas sondadas no IP do peer simétrico: primeiro as previstas,
+// depois portas aleatórias distintas até completar o orçamento
+func birthdayPorts(prediction PortPrediction, budget int) []int {
+	ports := prediction.Predict(budget)
+	chosen := make(map[int]bool, budget)
+	for _, port := range ports {
+		chosen[port] = true
+	}
+
+	span := birthdayMaxPort - birthdayMinPort + 1
+	if budget > span {
+		budget = span
+	}
+	for len(ports) < budget {
+		port := birthdayMinPort + rand.Intn(span)
+		if !chosen[port] {
+			chosen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// SetBirthdayBudget define o orçamento do hole punching com NAT simétrico: quantos sockets
+// este nó abre quando está atrás de NAT simétrico e quantas portas sonda no IP de um peer
+// simétrico. Valores menores ou iguais a zero mantêm o orçamento atual.
+func (n *NATTraversal) SetBirthdayBudget(sockets, probes int) {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	if sockets > 0 {
+		n.birthdaySockets = sockets
+	}
+	if probes > 0 {
+		n.birthdayProbes = probes
+	}
+}
+
+// packetListener retorna o listener dos sockets auxiliares: o de SetPacketListener ou ListenUDP
+func (n *NATTraversal) packetListener() PacketListener {
+	n.natInfoMutex.RLock()
+	defer n.natInfoMutex.RUnlock()
+	if n.listen != nil {
+		return n.listen
+	}
+	return ListenUDP
+}
+
+// measurePorts mede, com um socket novo, a alocação de portas do NAT local. Sem medição,
+// informa apenas o IP público, e o peer sonda portas aleatórias.
+func (n *NATTraversal) measurePorts() *PortPrediction {
+	n.mutex.Lock()
+	client := n.stunClient
+	n.mutex.Unlock()
+
+	conn, err := n.packetListener()()
+	if err == nil {
+		defer conn.Close()
+		var prediction *PortPrediction
+		if prediction, err = client.MeasurePortAllocation(conn); err == nil {
+			fmt.Printf("Alocação de portas do NAT simétrico: %s\n", prediction)
+			return prediction
+		}
+	}
+	fmt.Printf("Alocação de portas do NAT simétrico desconhecida: %v\n", err)
+
+	n.natInfoMutex.RLock()
+	defer n.natInfoMutex.RUnlock()
+	return &PortPrediction{IP: n.natInfo.PublicIP}
+}
+
+// runBirthday é o lado simétrico de uma rodada: abre os sockets do orçamento, cada um com um
+// mapeamento próprio, e sonda o peer por todos eles. Se o peer não é simétrico, os sockets
+// sondam os endpoints dele enquanto ele sonda portas do nosso IP até acertar um dos
+// mapeamentos; se também é, cada socket sonda a porta prevista do socket correspondente do
+// peer. O socket vencedor passa a levar o tráfego WireGuard por um proxy local.
+func (n *NATTraversal) runBirthday(session *punchSession, deadline time.Time) (*net.UDPAddr, error) {
+	n.punchMutex.Lock()
+	budget := n.birthdaySockets
+	n.punchMutex.Unlock()
+
+	session.mutex.Lock()
+	peer := session.peerPorts
+	targets := append([]*net.UDPAddr(nil), session.targets...)
+	session.mutex.Unlock()
+
+	// Com o peer também simétrico, só os pares de portas previstas podem se encontrar
+	var paired []*net.UDPAddr
+	if peer != nil {
+		ip := net.ParseIP(peer.IP)
+		for _, port := range peer.Predict(budget) {
+			paired = append(paired, &net.UDPAddr{IP: ip, Port: port})
+		}
+		if ip == nil || len(paired) == 0 {
+			return nil, ErrUnpredictablePorts
+		}
+		budget = len(paired)
+	} else if len(targets) == 0 {
+		return nil, fmt.Errorf("peer %s sem endpoint para hole punching", session.nodeID)
+	}
+
+	listen := n.packetListener()
+	conns := make([]net.PacketConn, 0, budget)
+	defer func() {
+		_, winner := session.winnerConn()
+		for _, conn := range conns {
+			if conn != winner {
+				conn.Close()
+			}
+		}
+	}()
+	for len(conns) < budget {
+		conn, err := listen()
+		if err != nil {
+			if len(conns) == 0 {
+				return nil, fmt.Errorf("erro ao abrir sockets do hole punching: %w", err)
+			}
+			break
+		}
+		conns = append(conns, conn)
+		go n.readBirthdaySocket(session, conn)
+	}
+	fmt.Printf("Hole punching com NAT simétrico com o peer %s: %d sockets\n", session.nodeID, len(conns))
+
+	probe := session.frame(punchProbe)
+	send := func() {
+		// Os endpoints da resposta do peer podem chegar depois do início
+		session.mutex.Lock()
+		targets := append([]*net.UDPAddr(nil), session.targets...)
+		session.mutex.Unlock()
+		for i, conn := range conns {
+			if paired != nil {
+				conn.WriteTo(probe, paired[i])
+				continue
+			}
+			for _, target := range targets {
+				conn.WriteTo(probe, target)
+			}
+		}
+	}
+
+	send()
+	ticker := time.NewTicker(birthdayResend)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-session.done:
+			winner, conn := session.winnerConn()
+			fmt.Printf("Hole punching com o peer %s concluído pelo socket %s: %s\n", session.nodeID, conn.LocalAddr(), winner)
+			if !session.controlled() {
+				go session.nominate(conn, winner)
+			}
+			return winner, n.bridgePunched(session.nodeID, conn, winner)
+		case <-ticker.C:
+			send()
+		case <-timeout.C:
+			return nil, ErrPunchTimeout
+		}
+	}
+}
+
+// readBirthdaySocket lê um socket do lado simétrico: responde às sondas do peer e registra a
+// primeira sonda ou resposta, ou a escolha do peer quando é ele quem controla a rodada. Se o
+// socket vence, o que chega depois dele vai ao WireGuard pelo proxy.
+func (n *NATTraversal) readBirthdaySocket(session *punchSession, conn net.PacketConn) {
+	buffer := make([]byte, 65535)
+	for {
+		count, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		from, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		data := buffer[:count]
+		if kind, id, ok := parsePunchFrame(data); ok {
+			// Sondas de outras rodadas também são descartadas, e não entregues ao WireGuard
+			if id != session.id || !session.verify(data) {
+				continue
+			}
+			if kind == punchProbe {
+				conn.WriteTo(session.frame(punchAck), from)
+			}
+			if kind == punchNominate || !session.controlled() {
+				session.win(conn, from)
+			}
+			continue
+		}
+		n.punchProxies.deliver(session.nodeID, data)
+	}
+}
+
+// bridgePunched tira o peer do relay e aponta o WireGuard para um proxy local ligado ao
+// socket que venceu o hole punching, pelo qual o tráfego sai para o endpoint do peer
+func (n *NATTraversal) bridgePunched(nodeID string, conn net.PacketConn, peer *net.UDPAddr) error {
+	n.dropTURNPeer(nodeID)
+	n.dropPeerRelay(nodeID)
+	n.dropPunchedPeer(nodeID)
+
+	proxy, err := n.punchProxies.add(nodeID, func(data []byte) error {
+		_, err := conn.WriteTo(data, peer)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	n.punchMutex.Lock()
+	n.punchBridges[nodeID] = conn
+	n.punchMutex.Unlock()
+
+	updater := n.updater()
+	if updater == nil {
+		return nil
+	}
+	if err := updater.UpdatePeerEndpoint(nodeID, proxy.String()); err != nil {
+		return fmt.Errorf("erro ao aplicar endpoint do hole punching: %w", err)
+	}
+	n.setPathKeepalive(nodeID, false)
+	return nil
+}
+
+// dropPunchedPeer fecha o socket e o proxy de um peer alcançado pelo lado simétrico do hole
+// punching, sem mexer no endpoint WireGuard
+func (n *NATTraversal) dropPunchedPeer(nodeID string) {
+	n.punchMutex.Lock()
+	conn, ok := n.punchBridges[nodeID]
+	delete(n.punchBridges, nodeID)
+	n.punchMutex.Unlock()
+
+	if ok {
+		n.punchProxies.remove(nodeID)
+		conn.Close()
+	}
+}
+
+// stopPunch devolve aos endpoints configurados os peers alcançados pelo lado simétrico e
+// fecha seus sockets e proxies
+func (n *NATTraversal) stopPunch() {
+	n.punchMutex.Lock()
+	bridges := n.punchBridges
+	n.punchBridges = make(map[string]net.PacketConn)
+	n.punchMutex.Unlock()
+
+	for _, conn := range bridges {
+		conn.Close()
+	}
+	n.punchProxies.close()
+
+	if updater := n.updater(); updater != nil {
+		for nodeID := range bridges {
+			if err := updater.UpdatePeerEndpoint(nodeID, ""); err != nil {
+				fmt.Printf("Erro ao restaurar endpoint do peer %s: %v\n", nodeID, err)
+			}
+		}
+	}
+}
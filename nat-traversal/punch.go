@@ -0,0 +1,918 @@
+package nattraversal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sondas de hole punching, trocadas pela porta do WireGuard (big-endian):
+//
+//	magic(4) | tipo(1) | sessão(8) | chave do remetente(32) | MAC(32)
+//
+// O primeiro byte não é um tipo de mensagem WireGuard (1 a 4) nem STUN, então o WireGuard
+// descarta as sondas que chegam ao seu socket. O MAC usa uma chave derivada do acordo X25519
+// entre as chaves WireGuard dos dois peers e do identificador da sessão.
+const (
+	punchMagic       = "\xf0P2P"
+	punchProbe       = 0x01 // Sonda enviada a cada candidato do peer
+	punchAck         = 0x02 // Resposta a uma sonda, enviada ao endereço de onde ela veio
+	punchNominate    = 0x03 // Caminho escolhido pelo lado que controla a rodada
+	punchSessionSize = 8
+	punchFrameSize   = len(punchMagic) + 1 + punchSessionSize + peerRelayKeySize + sha256.Size
+)
+
+// Temporização das rodadas de hole punching
+const (
+	DefaultPunchLead     = 500 * time.Millisecond // Antecedência do início, para o sinal chegar ao peer
+	DefaultPunchInterval = 100 * time.Millisecond // Intervalo entre as sondas
+	DefaultPunchWindow   = 3 * time.Second        // Duração das sondas a partir do início combinado
+	punchMaxLead         = 10 * time.Second       // Inícios mais distantes que isso são recusados
+)
+
+// ErrPunchTimeout indica que nenhum endpoint do peer respondeu às sondas dentro da janela
+var ErrPunchTimeout = errors.New("nenhum endpoint do peer respondeu ao hole punching")
+
+// PunchOffer combina com um peer uma rodada de hole punching simultâneo
+// PunchOffer agrees a simultaneous hole punching round with a peer
+// PunchOffer acuerda con un par una ronda de hole punching simultáneo
+type PunchOffer struct {
+	Session    string          // Identificador da rodada (hex), o mesmo nos dois lados
+	At         time.Time       // Início combinado das sondas
+	Endpoints  []string        // Endpoints (host:porta) da porta WireGuard de quem envia
+	Ports      *PortPrediction // Alocação de portas de quem envia, quando atrás de NAT simétrico
+	Checks     bool            // Rodada de verificações de conectividade: quem a propõe nomeia o par
+	Candidates []Candidate     // Candidatos de quem envia, nas rodadas de verificação
+}
+
+// PunchSignaler leva uma PunchOffer ao peer por um canal que já funciona, como a descoberta
+// ou um servidor de rendezvous. Implementado por PeerDiscovery.
+type PunchSignaler interface {
+	SignalPunch(nodeID string, offer PunchOffer) error
+}
+
+// punchSession é uma rodada de hole punching com um peer
+type punchSession struct {
+	id      string
+	raw     []byte // Identificador em bytes, como vai nas sondas
+	nodeID  string
+	at      time.Time
+	conn    net.PacketConn // nil quando os handshakes do WireGuard fazem o papel das sondas
+	local   []byte         // Chave pública WireGuard deste nó
+	remote  []byte         // Chave pública WireGuard do peer
+	macKey  []byte
+	targets []*net.UDPAddr
+	winner  *net.UDPAddr
+	done    chan struct{}
+	mutex   sync.Mutex
+
+	// NAT simétrico
+	localPorts *PortPrediction // Alocação de portas deste nó, que então sonda por vários sockets
+	peerPorts  *PortPrediction // Alocação de portas do peer, cujo IP este nó sonda
+	spray      []*net.UDPAddr  // Portas do IP do peer ainda não sondadas
+	sprayChunk int             // Portas sondadas a cada intervalo
+	via        net.PacketConn  // Socket que recebeu a resposta vencedora
+
+	// Verificações de conectividade
+	checks      bool                 // Rodada de verificações: o par é nomeado por quem a propôs
+	controlling bool                 // Este nó propôs a rodada de verificações
+	fixed       bool                 // Verificação de consentimento: só o par escolhido é sondado
+	candidates  map[string]Candidate // Candidato do peer de cada alvo, por endereço
+	valid       []*net.UDPAddr       // Alvos que responderam às sondas
+	validated   chan struct{}        // Fechado quando o primeiro alvo responde
+}
+
+// newPunchSession cria a sessão id (hex) com o peer da chave pública informada
+func newPunchSession(id, nodeID, privateKey, publicKey string, at time.Time) (*punchSession, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != punchSessionSize {
+		return nil, fmt.Errorf("identificador de sessão de hole punching inválido: %q", id)
+	}
+	local, err := parseRelayPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := parseRelayPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := local.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("erro no acordo de chaves do hole punching: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("p2p-vpn punch v1"))
+	mac.Write(raw)
+
+	return &punchSession{
+		id:     id,
+		raw:    raw,
+		nodeID: nodeID,
+		at:     at,
+		local:  local.PublicKey().Bytes(),
+		remote: remote.Bytes(),
+		macKey: mac.Sum(nil),
+		done:   make(chan struct{}),
+
+		candidates: make(map[string]Candidate),
+		validated:  make(chan struct{}),
+	}, nil
+}
+
+// newPunchSessionID gera um identificador de sessão aleatório
+func newPunchSessionID() (string, error) {
+	raw := make([]byte, punchSessionSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// frame monta uma sonda ou resposta desta sessão
+func (s *punchSession) frame(kind byte) []byte {
+	frame := append([]byte(punchMagic), kind)
+	frame = append(frame, s.raw...)
+	frame = append(frame, s.local...)
+	mac := hmac.New(sha256.New, s.macKey)
+	mac.Write(frame)
+	return mac.Sum(frame)
+}
+
+// verify confere o MAC de um quadro desta sessão e descarta os enviados por este nó, que
+// voltam quando o NAT faz hairpinning
+func (s *punchSession) verify(frame []byte) bool {
+	content := frame[:len(frame)-sha256.Size]
+	mac := hmac.New(sha256.New, s.macKey)
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), frame[len(content):]) {
+		return false
+	}
+	sender := content[len(punchMagic)+1+punchSessionSize:]
+	return !bytes.Equal(sender, s.local)
+}
+
+// addTargets inclui candidatos (host:porta) ainda não sondados
+func (s *punchSession) addTargets(endpoints ...string) {
+	for _, endpoint := range endpoints {
+		addr, err := net.ResolveUDPAddr("udp", endpoint)
+		if err != nil {
+			continue
+		}
+		s.addTarget(addr)
+	}
+}
+
+// addTarget inclui um candidato, como o endereço de onde chegou uma sonda do peer, que é
+// peer-reflexivo só se ainda não for conhecido
+func (s *punchSession) addTarget(addr *net.UDPAddr) {
+	s.addResolved(addr, Candidate{
+		Type:     CandidatePeerReflexive,
+		Address:  addr.String(),
+		Priority: CandidatePriority(CandidatePeerReflexive, 0),
+	}, false)
+}
+
+// addCandidate inclui um candidato com tipo e prioridade anunciados pelo peer
+func (s *punchSession) addCandidate(candidate Candidate) {
+	addr, err := net.ResolveUDPAddr("udp", candidate.Address)
+	if err != nil {
+		return
+	}
+	s.addResolved(addr, candidate, true)
+}
+
+// addCandidates inclui os candidatos do peer que podem ser sondados: os relays não recebem
+// as sondas pela porta do WireGuard
+func (s *punchSession) addCandidates(candidates []Candidate) {
+	for _, candidate := range candidates {
+		if candidate.Type != CandidateRelay {
+			s.addCandidate(candidate)
+		}
+	}
+}
+
+// addResolved inclui o alvo. Se ele já existe, um candidato anunciado pelo peer substitui o
+// registrado quando tem prioridade maior; um aprendido pelas sondas não substitui nenhum.
+func (s *punchSession) addResolved(addr *net.UDPAddr, candidate Candidate, announced bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := addr.String()
+	if existing, ok := s.candidates[key]; ok {
+		if announced && candidate.Priority > existing.Priority {
+			s.candidates[key] = candidate
+		}
+		return
+	}
+	for _, target := range s.targets {
+		if sameUDPAddr(target, addr) {
+			return
+		}
+	}
+	s.targets = append(s.targets, addr)
+	s.candidates[key] = candidate
+}
+
+// candidate retorna o candidato do peer de um alvo
+func (s *punchSession) candidate(addr *net.UDPAddr) Candidate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if candidate, ok := s.candidates[addr.String()]; ok {
+		return candidate
+	}
+	return Candidate{Type: CandidatePeerReflexive, Address: addr.String()}
+}
+
+// unconfirmed escolhe o alvo de um caminho que não pode ser confirmado: o de maior
+// prioridade, mas os endereços locais do peer, que só servem na mesma LAN, apenas se não
+// houver outro
+func (s *punchSession) unconfirmed() *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var best *net.UDPAddr
+	rank := func(addr *net.UDPAddr) (bool, uint32) {
+		candidate := s.candidates[addr.String()]
+		return candidate.Type != CandidateHost, candidate.Priority
+	}
+	for _, target := range s.targets {
+		if best == nil {
+			best = target
+			continue
+		}
+		remote, priority := rank(target)
+		bestRemote, bestPriority := rank(best)
+		if remote && !bestRemote || remote == bestRemote && priority > bestPriority {
+			best = target
+		}
+	}
+	return best
+}
+
+// setPeerPorts registra a alocação de portas do peer simétrico. Se este nó não é simétrico,
+// passa a sondar também portas do IP do peer, até o orçamento, ao longo da primeira metade da
+// janela, para que as sondas dos sockets do peer ainda encontrem as portas abertas.
+func (s *punchSession) setPeerPorts(prediction *PortPrediction, budget int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if prediction == nil || s.peerPorts != nil {
+		return
+	}
+	s.peerPorts = prediction
+
+	ip := net.ParseIP(prediction.IP)
+	if s.localPorts != nil || ip == nil {
+		return
+	}
+	for _, port := range birthdayPorts(*prediction, budget) {
+		s.spray = append(s.spray, &net.UDPAddr{IP: ip, Port: port})
+	}
+	rounds := int(DefaultPunchWindow / 2 / DefaultPunchInterval)
+	s.sprayChunk = (len(s.spray) + rounds - 1) / rounds
+}
+
+// controlled indica se o caminho é escolhido pelo peer, que então envia punchNominate: quando
+// só ele está atrás de NAT simétrico ou, com os dois, quando a chave pública dele é maior. Com
+// vários sockets, mais de um par pode se encontrar, e os dois lados precisam usar o mesmo.
+// Sem NAT simétrico, numa rodada de verificações, o caminho é escolhido por quem a propôs.
+func (s *punchSession) controlled() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.peerPorts != nil {
+		return s.localPorts == nil || bytes.Compare(s.local, s.remote) < 0
+	}
+	return s.localPorts == nil && s.checks && !s.controlling
+}
+
+// nominates indica se este nó escolhe o par entre os válidos, pela prioridade, em vez de
+// ficar com o primeiro que responde
+func (s *punchSession) nominates() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.checks && s.controlling && s.localPorts == nil && s.peerPorts == nil
+}
+
+// validate registra um alvo que respondeu às sondas
+func (s *punchSession) validate(addr *net.UDPAddr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, valid := range s.valid {
+		if sameUDPAddr(valid, addr) {
+			return
+		}
+	}
+	s.valid = append(s.valid, addr)
+	if len(s.valid) == 1 {
+		close(s.validated)
+	}
+}
+
+// best retorna o alvo válido de maior prioridade e se nenhum alvo ainda sem resposta tem
+// prioridade maior que a dele
+func (s *punchSession) best() (*net.UDPAddr, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var best *net.UDPAddr
+	for _, valid := range s.valid {
+		if best == nil || s.candidates[valid.String()].Priority > s.candidates[best.String()].Priority {
+			best = valid
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	priority := s.candidates[best.String()].Priority
+	for _, target := range s.targets {
+		if s.candidates[target.String()].Priority > priority {
+			return best, false
+		}
+	}
+	return best, true
+}
+
+// nominate informa ao peer, pelo socket vencedor, o caminho escolhido
+func (s *punchSession) nominate(conn net.PacketConn, addr *net.UDPAddr) {
+	frame := s.frame(punchNominate)
+	for i := 0; i < 3; i++ {
+		if _, err := conn.WriteTo(frame, addr); err != nil {
+			return
+		}
+		time.Sleep(DefaultPunchInterval)
+	}
+}
+
+// win registra o primeiro candidato que respondeu e o socket por onde a resposta chegou
+func (s *punchSession) win(conn net.PacketConn, addr *net.UDPAddr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.winner == nil {
+		s.winner = addr
+		s.via = conn
+		close(s.done)
+	}
+}
+
+// winnerConn retorna o candidato vencedor e o socket por onde ele respondeu
+func (s *punchSession) winnerConn() (*net.UDPAddr, net.PacketConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.winner, s.via
+}
+
+// result retorna o candidato vencedor, nil enquanto nenhum respondeu
+func (s *punchSession) result() *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.winner
+}
+
+// probe envia uma sonda a cada candidato e às próximas portas do peer simétrico
+func (s *punchSession) probe() {
+	s.mutex.Lock()
+	targets := append([]*net.UDPAddr(nil), s.targets...)
+	chunk := s.spray[:min(s.sprayChunk, len(s.spray))]
+	s.spray = s.spray[len(chunk):]
+	s.mutex.Unlock()
+
+	probe := s.frame(punchProbe)
+	for _, target := range targets {
+		s.conn.WriteTo(probe, target)
+	}
+	for _, target := range chunk {
+		s.conn.WriteTo(probe, target)
+	}
+}
+
+// parsePunchFrame identifica uma sonda ou resposta, sem verificar o MAC
+func parsePunchFrame(data []byte) (byte, string, bool) {
+	if len(data) != punchFrameSize || !bytes.HasPrefix(data, []byte(punchMagic)) {
+		return 0, "", false
+	}
+	kind := data[len(punchMagic)]
+	if kind != punchProbe && kind != punchAck && kind != punchNominate {
+		return 0, "", false
+	}
+	session := data[len(punchMagic)+1 : len(punchMagic)+1+punchSessionSize]
+	return kind, hex.EncodeToString(session), true
+}
+
+// punchPacket é um pacote lido do socket das sondas
+type punchPacket struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// punchBinder é a visão do socket das sondas usada pelo STUNClient: as escritas saem pelo
+// socket e as leituras recebem as mensagens STUN separadas pela leitura das sondas
+type punchBinder struct {
+	conn     net.PacketConn
+	inbox    chan punchPacket
+	deadline time.Time
+	mutex    sync.Mutex
+}
+
+func (b *punchBinder) ReadFrom(p []byte) (int, net.Addr, error) {
+	b.mutex.Lock()
+	deadline := b.deadline
+	b.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-b.inbox:
+		return copy(p, packet.data), packet.from, nil
+	case <-timeout:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+	}
+}
+
+func (b *punchBinder) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return b.conn.WriteTo(p, addr)
+}
+
+func (b *punchBinder) Close() error                       { return nil }
+func (b *punchBinder) LocalAddr() net.Addr                { return b.conn.LocalAddr() }
+func (b *punchBinder) SetDeadline(t time.Time) error      { return b.SetReadDeadline(t) }
+func (b *punchBinder) SetWriteDeadline(t time.Time) error { return nil }
+
+func (b *punchBinder) SetReadDeadline(t time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.deadline = t
+	return nil
+}
+
+// SetPunchConn define o socket das sondas de hole punching. Ele deve enviar pela porta do
+// WireGuard e entregar o que chega a ela, como o socket de um WireGuard em espaço de usuário.
+// Sem ele, no Linux, as sondas saem de um socket raw com a porta de origem do WireGuard,
+// aberto só durante as rodadas; nos demais sistemas, os handshakes do WireGuard perfuram o NAT.
+// Um socket novo tem outro mapeamento no NAT, então o endpoint público volta a ser descoberto.
+func (n *NATTraversal) SetPunchConn(conn net.PacketConn) {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	n.punchConn = conn
+	n.punchEndpoint = ""
+}
+
+// SetPunchSignaler define quem combina as rodadas de hole punching com os peers
+func (n *NATTraversal) SetPunchSignaler(signaler PunchSignaler) {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	n.punchSignaler = signaler
+}
+
+// HandlePunch trata uma PunchOffer recebida do peer, com a chave pública WireGuard dele. A
+// resposta a uma rodada deste nó acrescenta os endpoints do peer aos candidatos; uma rodada
+// proposta pelo peer é respondida com os endpoints deste nó e sondada no instante combinado,
+// e o endpoint vencedor é aplicado ao WireGuard. Numa rodada de verificações, o endpoint
+// aplicado é o que o peer nomear.
+func (n *NATTraversal) HandlePunch(nodeID, publicKey string, offer PunchOffer) error {
+	if wait := time.Until(offer.At); wait > punchMaxLead || wait < -DefaultPunchWindow {
+		return fmt.Errorf("início do hole punching fora da janela aceita: %s", offer.At.Format(time.RFC3339Nano))
+	}
+	n.setPeerKey(nodeID, publicKey)
+
+	n.punchMutex.Lock()
+	session, exists := n.punchSessions[offer.Session]
+	signaler := n.punchSignaler
+	probes := n.birthdayProbes
+	endpoints := n.peerEndpoints[nodeID]
+	candidates := n.peerCandidates[nodeID]
+	n.punchMutex.Unlock()
+
+	if exists {
+		if session.nodeID != nodeID {
+			return fmt.Errorf("sessão de hole punching %s pertence a outro peer", offer.Session)
+		}
+		if !session.fixed {
+			session.addCandidates(offer.Candidates)
+			session.addTargets(offer.Endpoints...)
+		}
+		session.setPeerPorts(offer.Ports, probes)
+		return nil
+	}
+
+	session, answer, err := n.startPunch(nodeID, publicKey, offer.Session, offer.At, offer.Checks)
+	if err != nil {
+		return err
+	}
+	// Os candidatos começam pelos conhecidos do peer; os tipados vêm antes dos endpoints,
+	// que sem tipo contam como peer-reflexivos
+	if offer.Checks {
+		session.addCandidates(candidates)
+		session.addCandidates(offer.Candidates)
+	}
+	session.addTargets(endpoints...)
+	session.addTargets(offer.Endpoints...)
+	session.setPeerPorts(offer.Ports, probes)
+	if signaler != nil {
+		if err := signaler.SignalPunch(nodeID, answer); err != nil {
+			fmt.Printf("Erro ao responder hole punching do peer %s: %v\n", nodeID, err)
+		}
+	}
+
+	go func() {
+		if _, err := n.runPunch(session); err != nil {
+			fmt.Printf("Hole punching com o peer %s falhou: %v\n", nodeID, err)
+		}
+	}()
+	return nil
+}
+
+// canPunch indica se o hole punching com o peer pode ser combinado com ele
+func (n *NATTraversal) canPunch(nodeID string) bool {
+	n.punchMutex.Lock()
+	coordinated := n.punchSignaler != nil && n.peerKeys[nodeID] != ""
+	n.punchMutex.Unlock()
+	return nodeID != "" && coordinated && n.updater() != nil
+}
+
+// holePunching combina com o peer uma rodada de hole punching simultâneo pela porta do
+// WireGuard e aplica o endpoint vencedor. Sem peer identificado, sem chave ou sem canal para
+// combinar o instante, fica a cargo dos handshakes que o WireGuard envia ao endpoint.
+func (n *NATTraversal) holePunching(nodeID string, remoteIP string, remotePort int) error {
+	remote := net.JoinHostPort(remoteIP, strconv.Itoa(remotePort))
+
+	if !n.canPunch(nodeID) {
+		fmt.Printf("Hole punching com %s sem coordenação: o WireGuard perfura o NAT ao enviar handshakes\n", remote)
+		return nil
+	}
+
+	n.punchMutex.Lock()
+	endpoints := n.peerEndpoints[nodeID]
+	n.punchMutex.Unlock()
+
+	_, err := n.proposePunch(nodeID, false, func(session *punchSession) {
+		session.addTargets(endpoints...)
+		session.addTargets(remote)
+	})
+	return err
+}
+
+// proposePunch combina com o peer uma rodada cujos alvos são incluídos por prepare e retorna
+// o endpoint vencedor. Numa rodada de verificações, este nó nomeia o par.
+func (n *NATTraversal) proposePunch(nodeID string, checks bool, prepare func(*punchSession)) (*net.UDPAddr, error) {
+	n.punchMutex.Lock()
+	signaler := n.punchSignaler
+	publicKey := n.peerKeys[nodeID]
+	n.punchMutex.Unlock()
+
+	id, err := newPunchSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session, offer, err := n.startPunch(nodeID, publicKey, id, time.Time{}, checks)
+	if err != nil {
+		return nil, err
+	}
+	// O início conta a partir do fim de startPunch, que atrás de NAT simétrico mede as portas
+	session.mutex.Lock()
+	session.controlling = checks
+	session.at = time.Now().Add(DefaultPunchLead)
+	session.mutex.Unlock()
+	offer.At = session.at
+	prepare(session)
+
+	fmt.Printf("Combinando hole punching com o peer %s (sessão %s)\n", nodeID, id)
+	if err := signaler.SignalPunch(nodeID, offer); err != nil {
+		n.endPunch(session)
+		return nil, fmt.Errorf("erro ao combinar hole punching com o peer %s: %w", nodeID, err)
+	}
+
+	return n.runPunch(session)
+}
+
+// startPunch registra uma rodada e monta a oferta com os endpoints deste nó e, numa rodada
+// de verificações, com os candidatos dele. Atrás de NAT simétrico, a porta do WireGuard não
+// serve às sondas, e a oferta leva a alocação de portas medida no lugar do endpoint dela.
+func (n *NATTraversal) startPunch(nodeID, publicKey, id string, at time.Time, checks bool) (*punchSession, PunchOffer, error) {
+	n.peerRelayMutex.Lock()
+	privateKey := n.relayKey
+	n.peerRelayMutex.Unlock()
+	if privateKey == "" {
+		return nil, PunchOffer{}, fmt.Errorf("hole punching coordenado requer a chave definida em SetRelayKey")
+	}
+
+	session, err := newPunchSession(id, nodeID, privateKey, publicKey, at)
+	if err != nil {
+		return nil, PunchOffer{}, err
+	}
+	session.checks = checks
+
+	n.natInfoMutex.RLock()
+	symmetric := n.natInfo.Type == "symmetric"
+	n.natInfoMutex.RUnlock()
+
+	var conn net.PacketConn
+	if symmetric {
+		session.localPorts = n.measurePorts()
+	} else {
+		// Sem socket para as sondas, os handshakes do WireGuard perfuram o NAT
+		if conn, err = n.acquirePunchConn(); err != nil {
+			fmt.Printf("Sondas pela porta do WireGuard indisponíveis (%v), usando handshakes\n", err)
+		}
+		session.conn = conn
+	}
+
+	n.punchMutex.Lock()
+	n.punchSessions[id] = session
+	n.punchMutex.Unlock()
+
+	offer := PunchOffer{Session: id, At: at, Ports: session.localPorts, Checks: checks}
+	var reflexive string
+	if conn != nil {
+		if reflexive = n.reflexiveEndpoint(conn); reflexive != "" {
+			offer.Endpoints = append(offer.Endpoints, reflexive)
+		}
+	}
+	offer.Endpoints = append(offer.Endpoints, n.EndpointCandidates()...)
+	if checks {
+		offer.Candidates = n.localCandidates(reflexive)
+	}
+	return session, offer, nil
+}
+
+// runPunch espera o início combinado e sonda os candidatos até um deles responder ou a janela
+// acabar. O vencedor passa a ser o endpoint WireGuard do peer. Atrás de NAT simétrico, a
+// rodada segue por runBirthday.
+func (n *NATTraversal) runPunch(session *punchSession) (*net.UDPAddr, error) {
+	// A sessão continua registrada até o fim da janela: o peer ainda pode enviar a resposta
+	// à oferta ou sondas que precisam da nossa resposta
+	deadline := session.at.Add(DefaultPunchWindow)
+	time.AfterFunc(time.Until(deadline), func() { n.endPunch(session) })
+
+	if wait := time.Until(session.at); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	session.mutex.Lock()
+	local, peer := session.localPorts, session.peerPorts
+	session.mutex.Unlock()
+	if local != nil {
+		return n.runBirthday(session, deadline)
+	}
+
+	if session.conn == nil {
+		if peer != nil {
+			return nil, fmt.Errorf("peer %s atrás de NAT simétrico requer sondas pela porta do WireGuard", session.nodeID)
+		}
+		// Os dois lados apontam o WireGuard um para o outro no mesmo instante, e os
+		// handshakes que se cruzam abrem os mapeamentos; não há como confirmar o resultado,
+		// então vale o primeiro candidato ou, numa rodada de verificações, o escolhido por unconfirmed
+		session.mutex.Lock()
+		var target *net.UDPAddr
+		if len(session.targets) > 0 {
+			target = session.targets[0]
+		}
+		session.mutex.Unlock()
+		if session.checks {
+			target = session.unconfirmed()
+		}
+		if target == nil {
+			return nil, fmt.Errorf("peer %s sem endpoint para hole punching", session.nodeID)
+		}
+		return target, n.applyPunched(session.nodeID, target, lanCandidate(session.candidate(target)))
+	}
+
+	session.probe()
+	ticker := time.NewTicker(DefaultPunchInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+
+	// Quem nomeia o par espera, depois do primeiro válido, pelos de maior prioridade
+	validated := session.validated
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-session.done:
+			winner := session.result()
+			fmt.Printf("Hole punching com o peer %s concluído: %s\n", session.nodeID, winner)
+			if session.nominates() {
+				go session.nominate(session.conn, winner)
+			}
+			return winner, n.applyPunched(session.nodeID, winner, lanCandidate(session.candidate(winner)))
+		case <-validated:
+			validated = nil
+			if best, top := session.best(); top {
+				session.win(session.conn, best)
+				continue
+			}
+			timer := time.NewTimer(DefaultCheckSettle)
+			defer timer.Stop()
+			settle = timer.C
+		case <-settle:
+			best, _ := session.best()
+			session.win(session.conn, best)
+		case <-ticker.C:
+			session.probe()
+		case <-timeout.C:
+			return nil, ErrPunchTimeout
+		}
+	}
+}
+
+// endPunch encerra a rodada e libera o socket das sondas
+func (n *NATTraversal) endPunch(session *punchSession) {
+	n.punchMutex.Lock()
+	current, ok := n.punchSessions[session.id]
+	if ok && current == session {
+		delete(n.punchSessions, session.id)
+	}
+	n.punchMutex.Unlock()
+
+	if ok && current == session && session.conn != nil {
+		n.releasePunchConn()
+	}
+}
+
+// applyPunched tira o peer do relay, se estiver nele, e aponta o WireGuard para o endpoint
+// aberto pelo hole punching; lan indica um endereço do peer na mesma rede local
+func (n *NATTraversal) applyPunched(nodeID string, endpoint *net.UDPAddr, lan bool) error {
+	n.dropTURNPeer(nodeID)
+	n.dropPeerRelay(nodeID)
+	n.dropPunchedPeer(nodeID)
+
+	updater := n.updater()
+	if updater == nil {
+		return nil
+	}
+	if err := updater.UpdatePeerEndpoint(nodeID, endpoint.String()); err != nil {
+		return fmt.Errorf("erro ao aplicar endpoint do hole punching: %w", err)
+	}
+	n.setPathKeepalive(nodeID, lan)
+	return nil
+}
+
+// setPeerKey registra a chave pública WireGuard do peer
+func (n *NATTraversal) setPeerKey(nodeID, publicKey string) {
+	if nodeID == "" || publicKey == "" {
+		return
+	}
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	n.peerKeys[nodeID] = publicKey
+}
+
+// acquirePunchConn abre, se preciso, o socket das sondas e inicia sua leitura. Cada
+// chamada bem-sucedida deve ser seguida de releasePunchConn.
+func (n *NATTraversal) acquirePunchConn() (net.PacketConn, error) {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+
+	if n.punchActive == nil {
+		conn := n.punchConn
+		if conn == nil {
+			raw, err := listenPortPuncher(n.localPort)
+			if err != nil {
+				return nil, err
+			}
+			conn = raw
+		}
+		n.punchActive = conn
+		n.punchStop = make(chan struct{})
+		go n.readPunchConn(conn, n.punchStop)
+	}
+	n.punchUsers++
+	return n.punchActive, nil
+}
+
+// releasePunchConn para a leitura e fecha o socket raw quando a última rodada termina
+func (n *NATTraversal) releasePunchConn() {
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+
+	n.punchUsers--
+	if n.punchUsers > 0 || n.punchActive == nil {
+		return
+	}
+	close(n.punchStop)
+	if n.punchActive != n.punchConn {
+		n.punchActive.Close()
+	}
+	n.punchActive = nil
+}
+
+// readPunchConn lê o socket das sondas: responde às sondas das rodadas abertas, registra as
+// respostas e repassa as mensagens STUN à descoberta do endpoint público
+func (n *NATTraversal) readPunchConn(conn net.PacketConn, stop chan struct{}) {
+	defer conn.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, 65535)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		conn.SetReadDeadline(time.Now().Add(DefaultPunchInterval))
+		count, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		from, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		data := buffer[:count]
+		if isSTUNMessage(data) {
+			n.punchMutex.Lock()
+			binder := n.punchBinder
+			n.punchMutex.Unlock()
+			if binder != nil {
+				select {
+				case binder.inbox <- punchPacket{data: append([]byte(nil), data...), from: from}:
+				default:
+				}
+			}
+			continue
+		}
+		n.handlePunchFrame(conn, data, from)
+	}
+}
+
+// handlePunchFrame responde a uma sonda do peer ou registra a resposta a uma sonda nossa
+func (n *NATTraversal) handlePunchFrame(conn net.PacketConn, data []byte, from *net.UDPAddr) {
+	kind, id, ok := parsePunchFrame(data)
+	if !ok {
+		return
+	}
+
+	n.punchMutex.Lock()
+	session := n.punchSessions[id]
+	n.punchMutex.Unlock()
+	if session == nil || !session.verify(data) {
+		return
+	}
+
+	switch kind {
+	case punchProbe:
+		// O endereço de origem já atravessou o NAT do peer, então também é candidato
+		conn.WriteTo(session.frame(punchAck), from)
+		session.addTarget(from)
+	case punchAck:
+		// Com o peer atrás de NAT simétrico ou numa rodada proposta por ele, o caminho é
+		// escolhido pelo peer; numa rodada proposta por este nó, o par é escolhido entre os válidos
+		switch {
+		case session.controlled():
+		case session.nominates():
+			session.addTarget(from)
+			session.validate(from)
+		default:
+			session.win(conn, from)
+		}
+	case punchNominate:
+		session.win(conn, from)
+	}
+}
+
+// reflexiveEndpoint descobre por STUN, pelo socket das sondas, o endpoint público da porta
+// do WireGuard, que é o que o peer precisa sondar. O resultado vale por DefaultMappingLifetime.
+func (n *NATTraversal) reflexiveEndpoint(conn net.PacketConn) string {
+	n.punchBindMutex.Lock()
+	defer n.punchBindMutex.Unlock()
+
+	n.punchMutex.Lock()
+	if n.punchEndpoint != "" && time.Since(n.punchLearned) < DefaultMappingLifetime*time.Second {
+		endpoint := n.punchEndpoint
+		n.punchMutex.Unlock()
+		return endpoint
+	}
+	binder := &punchBinder{conn: conn, inbox: make(chan punchPacket, 16)}
+	n.punchBinder = binder
+	n.punchMutex.Unlock()
+
+	n.mutex.Lock()
+	client := n.stunClient
+	n.mutex.Unlock()
+	binding, err := client.Bind(binder)
+
+	n.punchMutex.Lock()
+	defer n.punchMutex.Unlock()
+	n.punchBinder = nil
+	if err != nil {
+		fmt.Printf("Endpoint público da porta do WireGuard desconhecido: %v\n", err)
+		return ""
+	}
+	n.punchEndpoint = binding.Mapped.String()
+	n.punchLearned = time.Now()
+	return n.punchEndpoint
+}
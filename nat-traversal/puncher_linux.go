@@ -0,0 +1,91 @@
+//go:build linux
+// +build linux
+
+package nattraversal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// udpHeaderSize é o tamanho do cabeçalho UDP montado pelo socket raw
+const udpHeaderSize = 8
+
+// rawPuncher envia datagramas UDP com a porta de origem do WireGuard por um socket raw e lê
+// os que chegam a ela. O kernel monta o cabeçalho IP; o cabeçalho UDP vai sem checksum, o que
+// o IPv4 permite. O socket do WireGuard continua recebendo sua própria cópia de cada pacote.
+type rawPuncher struct {
+	conn *net.IPConn
+	port int
+}
+
+// listenPortPuncher abre o socket raw que envia e recebe pela porta UDP informada. Requer
+// CAP_NET_RAW, que o nó já tem por configurar a interface WireGuard.
+func listenPortPuncher(port int) (net.PacketConn, error) {
+	if port == 0 {
+		return nil, fmt.Errorf("porta do WireGuard desconhecida")
+	}
+	conn, err := net.ListenIP("ip4:udp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir socket raw para hole punching: %w", err)
+	}
+	return &rawPuncher{conn: conn, port: port}, nil
+}
+
+// ReadFrom retorna o próximo datagrama destinado à porta do WireGuard, sem o cabeçalho UDP
+func (p *rawPuncher) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := p.conn.ReadFrom(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < udpHeaderSize || int(binary.BigEndian.Uint16(b[2:4])) != p.port {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(b[4:6]))
+		if length < udpHeaderSize || length > n {
+			continue
+		}
+
+		source := &net.UDPAddr{IP: addr.(*net.IPAddr).IP, Port: int(binary.BigEndian.Uint16(b[0:2]))}
+		return copy(b, b[udpHeaderSize:length]), source, nil
+	}
+}
+
+// WriteTo envia um datagrama com a porta de origem do WireGuard
+func (p *rawPuncher) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok || dst.IP.To4() == nil {
+		return 0, fmt.Errorf("socket raw de hole punching só envia a endereços UDP IPv4: %s", addr)
+	}
+	if udpHeaderSize+len(b) > 0xffff {
+		return 0, fmt.Errorf("datagrama grande demais: %d bytes", len(b))
+	}
+
+	datagram := make([]byte, udpHeaderSize, udpHeaderSize+len(b))
+	binary.BigEndian.PutUint16(datagram[0:2], uint16(p.port))
+	binary.BigEndian.PutUint16(datagram[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(udpHeaderSize+len(b)))
+	datagram = append(datagram, b...)
+
+	if _, err := p.conn.WriteTo(datagram, &net.IPAddr{IP: dst.IP.To4()}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close fecha o socket raw
+func (p *rawPuncher) Close() error {
+	return p.conn.Close()
+}
+
+// LocalAddr retorna a porta do WireGuard em todos os endereços
+func (p *rawPuncher) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: p.port}
+}
+
+func (p *rawPuncher) SetDeadline(t time.Time) error      { return p.conn.SetDeadline(t) }
+func (p *rawPuncher) SetReadDeadline(t time.Time) error  { return p.conn.SetReadDeadline(t) }
+func (p *rawPuncher) SetWriteDeadline(t time.Time) error { return p.conn.SetWriteDeadline(t) }
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package nattraversal
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenPortPuncher não está disponível: fora do Linux, sockets raw UDP não recebem os
+// datagramas que chegam às portas locais, então os handshakes do WireGuard perfuram o NAT
+func listenPortPuncher(port int) (net.PacketConn, error) {
+	return nil, fmt.Errorf("sondas pela porta do WireGuard não suportadas neste sistema")
+}
@@ -0,0 +1,113 @@
+package nattraversal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// relayProxy é o socket local que representa um peer remoto para o WireGuard
+type relayProxy struct {
+	conn *net.UDPConn
+	send func(data []byte) error // Envia ao peer, pelo relay, o que o WireGuard manda ao proxy
+}
+
+// relayProxySet mantém os proxies UDP locais (127.0.0.1) dos peers alcançados por relay.
+// O endereço do proxy passa a ser o endpoint WireGuard do peer: o que o WireGuard envia ao
+// proxy sai pelo relay, e o que chega do relay volta ao WireGuard pela porta do proxy.
+type relayProxySet struct {
+	wgAddr  *net.UDPAddr           // Porta de escuta do WireGuard local
+	proxies map[string]*relayProxy // Proxies pela identificação do peer no relay
+	mutex   sync.Mutex
+}
+
+func newRelayProxySet(wgPort int) *relayProxySet {
+	return &relayProxySet{
+		wgAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: wgPort},
+		proxies: make(map[string]*relayProxy),
+	}
+}
+
+// add abre o proxy do peer, se ainda não existir, e retorna seu endereço local
+func (s *relayProxySet) add(key string, send func(data []byte) error) (*net.UDPAddr, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if proxy, ok := s.proxies[key]; ok {
+		return proxy.conn.LocalAddr().(*net.UDPAddr), nil
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir proxy local do relay: %w", err)
+	}
+	proxy := &relayProxy{conn: conn, send: send}
+	s.proxies[key] = proxy
+
+	go s.forward(key, proxy)
+	return conn.LocalAddr().(*net.UDPAddr), nil
+}
+
+// has informa se o peer já tem proxy
+func (s *relayProxySet) has(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.proxies[key]
+	return ok
+}
+
+// remove fecha o proxy do peer
+func (s *relayProxySet) remove(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if proxy, ok := s.proxies[key]; ok {
+		proxy.conn.Close()
+		delete(s.proxies, key)
+	}
+}
+
+// close fecha todos os proxies
+func (s *relayProxySet) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, proxy := range s.proxies {
+		proxy.conn.Close()
+		delete(s.proxies, key)
+	}
+}
+
+// deliver entrega ao WireGuard, pela porta do proxy do peer, os dados recebidos pelo relay
+func (s *relayProxySet) deliver(key string, data []byte) bool {
+	s.mutex.Lock()
+	proxy, ok := s.proxies[key]
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	proxy.conn.WriteToUDP(data, s.wgAddr)
+	return true
+}
+
+// forward envia pelo relay os pacotes que o WireGuard manda ao proxy do peer
+func (s *relayProxySet) forward(key string, proxy *relayProxy) {
+	buffer := make([]byte, 65535)
+	for {
+		n, src, err := proxy.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		// Só o WireGuard local usa o proxy
+		if src.Port != s.wgAddr.Port || !src.IP.IsLoopback() {
+			continue
+		}
+		if err := proxy.send(buffer[:n]); err != nil {
+			fmt.Printf("Erro ao enviar pelo relay para %s: %v\n", key, err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package nattraversal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constantes do protocolo STUN (RFC 5389)
+const (
+	stunMagicCookie = 0x2112A442
+	stunHeaderSize  = 20
+
+	stunBindingRequest       = 0x0001
+	stunBindingSuccess       = 0x0101
+	stunBindingErrorResponse = 0x0111
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrChangeRequest    = 0x0003 // RFC 5780
+	stunAttrErrorCode        = 0x0009
+	stunAttrXORMappedAddress = 0x0020
+	stunAttrResponsePort     = 0x0027 // RFC 5780
+	stunAttrResponseOrigin   = 0x802b // RFC 5780
+	stunAttrOtherAddress     = 0x802c // RFC 5780
+
+	// Flags do atributo CHANGE-REQUEST
+	stunChangeIP   = 0x04
+	stunChangePort = 0x02
+
+	stunFamilyIPv4 = 0x01
+	stunFamilyIPv6 = 0x02
+)
+
+// Temporizadores padrão das transações STUN. O RFC sugere RTO de 500ms e até 7 envios;
+// usamos menos envios para que a troca de servidor não demore demais.
+const (
+	DefaultSTUNRTO           = 500 * time.Millisecond
+	DefaultSTUNTransmissions = 4
+)
+
+// ErrSTUNTimeout indica que o servidor STUN não respondeu depois de todas as retransmissões
+var ErrSTUNTimeout = errors.New("servidor STUN não respondeu")
+
+// stunAttribute é um atributo TLV de uma mensagem STUN
+type stunAttribute struct {
+	Type  uint16
+	Value []byte
+}
+
+// stunMessage é uma mensagem STUN decodificada
+type stunMessage struct {
+	Type          uint16
+	TransactionID [12]byte
+	Attributes    []stunAttribute
+}
+
+// newSTUNTransactionID gera um identificador de transação aleatório
+func newSTUNTransactionID() ([12]byte, error) {
+	var id [12]byte
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// encode serializa a mensagem, alinhando cada atributo a 4 bytes
+func (m *stunMessage) encode() []byte {
+	body := make([]byte, 0, 64)
+	for _, attr := range m.Attributes {
+		body = binary.BigEndian.AppendUint16(body, attr.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(attr.Value)))
+		body = append(body, attr.Value...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	msg := make([]byte, stunHeaderSize, stunHeaderSize+len(body))
+	binary.BigEndian.PutUint16(msg[0:2], m.Type)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(body)))
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], m.TransactionID[:])
+	return append(msg, body...)
+}
+
+// attribute retorna o valor do primeiro atributo do tipo informado
+func (m *stunMessage) attribute(attrType uint16) ([]byte, bool) {
+	for _, attr := range m.Attributes {
+		if attr.Type == attrType {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+// isSTUNMessage verifica rapidamente se um pacote tem o cabeçalho de uma mensagem STUN
+func isSTUNMessage(data []byte) bool {
+	return len(data) >= stunHeaderSize &&
+		data[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(data[4:8]) == stunMagicCookie
+}
+
+// parseSTUNMessage decodifica uma mensagem STUN
+func parseSTUNMessage(data []byte) (*stunMessage, error) {
+	if !isSTUNMessage(data) {
+		return nil, fmt.Errorf("pacote não é uma mensagem STUN")
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length%4 != 0 || stunHeaderSize+length > len(data) {
+		return nil, fmt.Errorf("tamanho de mensagem STUN inválido: %d", length)
+	}
+
+	msg := &stunMessage{Type: binary.BigEndian.Uint16(data[0:2])}
+	copy(msg.TransactionID[:], data[8:20])
+
+	body := data[stunHeaderSize : stunHeaderSize+length]
+	for len(body) >= 4 {
+		attrType := binary.BigEndian.Uint16(body[0:2])
+		attrLen := int(binary.BigEndian.Uint16(body[2:4]))
+		padded := (attrLen + 3) &^ 3
+		if 4+padded > len(body) {
+			return nil, fmt.Errorf("atributo STUN 0x%04x truncado", attrType)
+		}
+		msg.Attributes = append(msg.Attributes, stunAttribute{Type: attrType, Value: body[4 : 4+attrLen]})
+		body = body[4+padded:]
+	}
+
+	return msg, nil
+}
+
+// decodeSTUNAddress decodifica um atributo de endereço, desfazendo o XOR quando necessário
+func decodeSTUNAddress(value []byte, xor bool, transactionID [12]byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("atributo de endereço STUN truncado")
+	}
+
+	var size int
+	switch value[1] {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("família de endereço STUN desconhecida: %d", value[1])
+	}
+	if len(value) < 4+size {
+		return nil, fmt.Errorf("atributo de endereço STUN truncado")
+	}
+
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := append(net.IP(nil), value[4:4+size]...)
+	if xor {
+		port ^= uint16(stunMagicCookie >> 16)
+		key := stunXORKey(transactionID)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// stunXORKey é o magic cookie seguido do ID da transação, usado no XOR dos endereços
+func stunXORKey(transactionID [12]byte) []byte {
+	key := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+	return append(key, transactionID[:]...)
+}
+
+// mappedAddress extrai o endereço mapeado de uma resposta, preferindo XOR-MAPPED-ADDRESS
+// (servidores antigos só enviam MAPPED-ADDRESS)
+func (m *stunMessage) mappedAddress() (*net.UDPAddr, error) {
+	if value, ok := m.attribute(stunAttrXORMappedAddress); ok {
+		return decodeSTUNAddress(value, true, m.TransactionID)
+	}
+	if value, ok := m.attribute(stunAttrMappedAddress); ok {
+		return decodeSTUNAddress(value, false, m.TransactionID)
+	}
+	return nil, fmt.Errorf("resposta STUN sem endereço mapeado")
+}
+
+// errorCode descreve o atributo ERROR-CODE de uma resposta de erro
+func (m *stunMessage) errorCode() error {
+	value, ok := m.attribute(stunAttrErrorCode)
+	if !ok || len(value) < 4 {
+		return fmt.Errorf("servidor STUN retornou erro")
+	}
+	code := int(value[2]&0x07)*100 + int(value[3])
+	return fmt.Errorf("servidor STUN retornou erro %d: %s", code, string(value[4:]))
+}
+
+// STUNBinding é o resultado de uma requisição Binding bem-sucedida
+// STUNBinding is the result of a successful Binding request
+// STUNBinding es el resultado de una solicitud Binding exitosa
+type STUNBinding struct {
+	Mapped *net.UDPAddr  // Endereço público visto pelo servidor
+	Server string        // Servidor que respondeu (host:porta)
+	RTT    time.Duration // Tempo entre o último envio e a resposta
+
+	// Atributos RFC 5780, nil quando o servidor não os suporta
+	ResponseOrigin *net.UDPAddr // Endereço de onde a resposta foi enviada
+	OtherAddress   *net.UDPAddr // Endereço alternativo do servidor (outro IP e outra porta)
+}
+
+// STUNClient envia requisições Binding STUN (RFC 5389) com retransmissão e troca de servidor
+// STUNClient sends STUN Binding requests (RFC 5389) with retransmission and server failover
+// STUNClient envía solicitudes Binding STUN (RFC 5389) con retransmisión y cambio de servidor
+type STUNClient struct {
+	servers       []STUNServer
+	rto           time.Duration // Tempo de espera inicial, dobrado a cada retransmissão
+	transmissions int           // Envios por servidor antes de desistir dele
+}
+
+// NewSTUNClient cria um cliente que consulta os servidores na ordem informada
+func NewSTUNClient(servers []STUNServer) *STUNClient {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+
+	return &STUNClient{
+		servers:       servers,
+		rto:           DefaultSTUNRTO,
+		transmissions: DefaultSTUNTransmissions,
+	}
+}
+
+// SetRetransmission ajusta o tempo de espera inicial e o número de envios por servidor
+func (c *STUNClient) SetRetransmission(rto time.Duration, transmissions int) {
+	if rto > 0 {
+		c.rto = rto
+	}
+	if transmissions > 0 {
+		c.transmissions = transmissions
+	}
+}
+
+// Servers retorna os servidores consultados pelo cliente
+func (c *STUNClient) Servers() []STUNServer {
+	return append([]STUNServer(nil), c.servers...)
+}
+
+// serverByAddr retorna o servidor configurado com o endereço informado (host:porta)
+func (c *STUNClient) serverByAddr(addr string) (STUNServer, bool) {
+	for _, server := range c.servers {
+		if stunServerAddr(server) == addr {
+			return server, true
+		}
+	}
+	return STUNServer{}, false
+}
+
+// Bind descobre o endereço público do socket, passando ao próximo servidor da lista
+// quando um deles não responde ou retorna erro
+func (c *STUNClient) Bind(conn net.PacketConn) (*STUNBinding, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		binding, err := c.BindServer(conn, server)
+		if err == nil {
+			return binding, nil
+		}
+		fmt.Printf("Servidor STUN %s falhou: %v\n", stunServerAddr(server), err)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("nenhum servidor STUN configurado")
+	}
+	return nil, fmt.Errorf("não foi possível obter o endereço público: %w", lastErr)
+}
+
+// BindOther repete a descoberta com outro servidor que não o informado (host:porta).
+// Comparar as duas portas mapeadas revela se o NAT é simétrico.
+func (c *STUNClient) BindOther(conn net.PacketConn, exclude string) (*STUNBinding, error) {
+	var lastErr error = fmt.Errorf("nenhum servidor STUN alternativo configurado")
+	for _, server := range c.servers {
+		if stunServerAddr(server) == exclude {
+			continue
+		}
+		binding, err := c.BindServer(conn, server)
+		if err == nil {
+			return binding, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// BindServer envia uma requisição Binding a um servidor específico
+func (c *STUNClient) BindServer(conn net.PacketConn, server STUNServer) (*STUNBinding, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", stunServerAddr(server))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao resolver servidor STUN: %w", err)
+	}
+
+	binding, err := c.bindAddr(conn, serverAddr, nil)
+	if err != nil {
+		return nil, err
+	}
+	binding.Server = stunServerAddr(server)
+	return binding, nil
+}
+
+// bindAddr envia uma requisição Binding com os atributos informados a um endereço já resolvido
+func (c *STUNClient) bindAddr(conn net.PacketConn, server *net.UDPAddr, attributes []stunAttribute) (*STUNBinding, error) {
+	response, rtt, err := c.roundTrip(conn, server, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, err := response.mappedAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	binding := &STUNBinding{Mapped: mapped, Server: server.String(), RTT: rtt}
+	if value, ok := response.attribute(stunAttrResponseOrigin); ok {
+		binding.ResponseOrigin, _ = decodeSTUNAddress(value, false, response.TransactionID)
+	}
+	if value, ok := response.attribute(stunAttrOtherAddress); ok {
+		binding.OtherAddress, _ = decodeSTUNAddress(value, false, response.TransactionID)
+	}
+	return binding, nil
+}
+
+// changeRequest monta o atributo CHANGE-REQUEST, pedindo a resposta de outro IP e/ou porta
+func changeRequest(changeIP, changePort bool) stunAttribute {
+	var flags byte
+	if changeIP {
+		flags |= stunChangeIP
+	}
+	if changePort {
+		flags |= stunChangePort
+	}
+	return stunAttribute{Type: stunAttrChangeRequest, Value: []byte{0, 0, 0, flags}}
+}
+
+// responsePort monta o atributo RESPONSE-PORT, pedindo a resposta em outra porta do mesmo IP
+func responsePort(port int) stunAttribute {
+	value := binary.BigEndian.AppendUint16(nil, uint16(port))
+	return stunAttribute{Type: stunAttrResponsePort, Value: append(value, 0, 0)}
+}
+
+// roundTrip envia uma requisição Binding e espera a resposta da mesma transação,
+// retransmitindo com o tempo de espera dobrado a cada tentativa
+func (c *STUNClient) roundTrip(conn net.PacketConn, server *net.UDPAddr, attributes []stunAttribute) (*stunMessage, time.Duration, error) {
+	transactionID, err := newSTUNTransactionID()
+	if err != nil {
+		return nil, 0, err
+	}
+	request := (&stunMessage{
+		Type:          stunBindingRequest,
+		TransactionID: transactionID,
+		Attributes:    attributes,
+	}).encode()
+
+	defer conn.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, 1500)
+	timeout := c.rto
+	for attempt := 0; attempt < c.transmissions; attempt++ {
+		sent := time.Now()
+		if _, err := conn.WriteTo(request, server); err != nil {
+			return nil, 0, fmt.Errorf("erro ao enviar requisição STUN: %w", err)
+		}
+
+		deadline := sent.Add(timeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, 0, fmt.Errorf("erro ao receber resposta STUN: %w", err)
+			}
+
+			// Descartar pacotes que não são a resposta desta transação (ex: retransmissões atrasadas)
+			response, err := parseSTUNMessage(buffer[:n])
+			if err != nil || response.TransactionID != transactionID {
+				continue
+			}
+
+			switch response.Type {
+			case stunBindingSuccess:
+				return response, time.Since(sent), nil
+			case stunBindingErrorResponse:
+				return nil, 0, response.errorCode()
+			}
+		}
+
+		timeout *= 2
+	}
+
+	return nil, 0, ErrSTUNTimeout
+}
+
+// ParseSTUNServers converte endereços host:porta em servidores STUN
+func ParseSTUNServers(addrs []string) ([]STUNServer, error) {
+	servers := make([]STUNServer, 0, len(addrs))
+	for _, addr := range addrs {
+		host, portStr, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("servidor STUN inválido %q: %w", addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("porta inválida no servidor STUN %q", addr)
+		}
+		servers = append(servers, STUNServer{Address: host, Port: port})
+	}
+	return servers, nil
+}
+
+// stunServerAddr formata o endereço de um servidor STUN
+func stunServerAddr(server STUNServer) string {
+	return net.JoinHostPort(server.Address, strconv.Itoa(server.Port))
+}
@@ -0,0 +1,296 @@
+package nattraversal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Atributos e códigos usados apenas pelo servidor
+const (
+	stunAttrUnknownAttributes = 0x000a
+
+	stunErrorUnknownAttribute = 420
+)
+
+// STUNService é um servidor STUN (RFC 5389) que qualquer nó pode executar, para que a rede
+// não dependa de servidores públicos. Com um endereço alternativo (outro IP e outra porta)
+// também atende os testes de comportamento do RFC 5780: CHANGE-REQUEST, RESPONSE-PORT,
+// RESPONSE-ORIGIN e OTHER-ADDRESS.
+// STUNService is a STUN server (RFC 5389) that any node can run, so the network does not
+// depend on public servers. With an alternate address it also serves RFC 5780 tests.
+// STUNService es un servidor STUN (RFC 5389) que cualquier nodo puede ejecutar, para que la
+// red no dependa de servidores públicos. Con una dirección alternativa también atiende RFC 5780.
+type STUNService struct {
+	listenAddr string // Endereço primário (host:porta)
+	alternate  string // Endereço alternativo (IP:porta), vazio para apenas RFC 5389
+
+	// Sockets indexados por [IP][porta]; sem endereço alternativo só [0][0] existe
+	conns   [2][2]net.PacketConn
+	rfc5780 bool
+	listen  AddrListener
+
+	running bool
+	mutex   sync.Mutex
+}
+
+// NewSTUNService cria um servidor STUN no endereço informado. Se alternate (IP:porta) for
+// informado, os quatro pares de IP e porta são abertos e o modo RFC 5780 é ativado.
+func NewSTUNService(listenAddr, alternate string) *STUNService {
+	return &STUNService{
+		listenAddr: listenAddr,
+		alternate:  alternate,
+		listen:     ListenUDPAddr,
+	}
+}
+
+// SetListener define como os sockets do servidor são abertos. Deve ser chamado antes de Start.
+func (s *STUNService) SetListener(listen AddrListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listen = listen
+}
+
+// Start abre os sockets e passa a responder requisições Binding
+func (s *STUNService) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running {
+		return fmt.Errorf("o servidor STUN já está em execução")
+	}
+
+	primary, err := net.ResolveUDPAddr("udp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("endereço do servidor STUN inválido: %w", err)
+	}
+
+	var conns [2][2]net.PacketConn
+	closeAll := func() {
+		for i := range conns {
+			for _, conn := range conns[i] {
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}
+	}
+
+	if conns[0][0], err = s.listen(primary); err != nil {
+		return fmt.Errorf("erro ao abrir o servidor STUN: %w", err)
+	}
+
+	if s.alternate != "" {
+		alternate, err := net.ResolveUDPAddr("udp", s.alternate)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("endereço alternativo do servidor STUN inválido: %w", err)
+		}
+		primary = conns[0][0].LocalAddr().(*net.UDPAddr)
+		if primary.IP.IsUnspecified() || alternate.IP.IsUnspecified() || alternate.IP.Equal(primary.IP) {
+			closeAll()
+			return fmt.Errorf("o modo RFC 5780 requer dois IPs específicos e diferentes")
+		}
+		if alternate.Port == primary.Port {
+			closeAll()
+			return fmt.Errorf("o endereço alternativo precisa de outra porta")
+		}
+
+		if conns[1][1], err = s.listen(alternate); err != nil {
+			closeAll()
+			return fmt.Errorf("erro ao abrir o endereço alternativo: %w", err)
+		}
+		alternate = conns[1][1].LocalAddr().(*net.UDPAddr)
+
+		// Os pares cruzados: IP primário com a porta alternativa e vice-versa
+		if conns[0][1], err = s.listen(&net.UDPAddr{IP: primary.IP, Port: alternate.Port}); err == nil {
+			conns[1][0], err = s.listen(&net.UDPAddr{IP: alternate.IP, Port: primary.Port})
+		}
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("erro ao abrir as portas cruzadas do modo RFC 5780: %w", err)
+		}
+		s.rfc5780 = true
+	}
+
+	s.conns = conns
+	for i := range conns {
+		for j, conn := range conns[i] {
+			if conn != nil {
+				go s.serve(i, j)
+			}
+		}
+	}
+
+	s.running = true
+	fmt.Printf("Servidor STUN escutando em %s (RFC 5780: %v)\n", conns[0][0].LocalAddr(), s.rfc5780)
+	return nil
+}
+
+// Stop fecha os sockets do servidor
+func (s *STUNService) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return nil
+	}
+
+	for i := range s.conns {
+		for _, conn := range s.conns[i] {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	s.running = false
+	return nil
+}
+
+// Server retorna o endereço primário do servidor no formato usado pelos clientes, ou um
+// STUNServer vazio antes de Start
+func (s *STUNService) Server() STUNServer {
+	addr := s.addr(0, 0)
+	if addr == nil {
+		return STUNServer{}
+	}
+	return STUNServer{Address: addr.IP.String(), Port: addr.Port}
+}
+
+// OtherAddress retorna o endereço alternativo, ou nil fora do modo RFC 5780
+func (s *STUNService) OtherAddress() *net.UDPAddr {
+	s.mutex.Lock()
+	rfc5780 := s.rfc5780
+	s.mutex.Unlock()
+
+	if !rfc5780 {
+		return nil
+	}
+	return s.addr(1, 1)
+}
+
+// addr retorna o endereço local de um dos sockets, ou nil se ele não estiver aberto
+func (s *STUNService) addr(i, j int) *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conns[i][j] == nil {
+		return nil
+	}
+	return s.conns[i][j].LocalAddr().(*net.UDPAddr)
+}
+
+// serve atende as requisições que chegam a um dos sockets até ele ser fechado
+func (s *STUNService) serve(i, j int) {
+	conn := s.conns[i][j]
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		src, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		request, err := parseSTUNMessage(buffer[:n])
+		if err != nil || request.Type != stunBindingRequest {
+			continue
+		}
+		s.handleBinding(i, j, request, src)
+	}
+}
+
+// handleBinding responde uma requisição Binding recebida pelo socket [i][j]
+func (s *STUNService) handleBinding(i, j int, request *stunMessage, src *net.UDPAddr) {
+	ri, rj := i, j
+	dst := src
+
+	var unknown []uint16
+	for _, attr := range request.Attributes {
+		switch {
+		case attr.Type == stunAttrChangeRequest && s.rfc5780 && len(attr.Value) == 4:
+			if attr.Value[3]&stunChangeIP != 0 {
+				ri = 1 - i
+			}
+			if attr.Value[3]&stunChangePort != 0 {
+				rj = 1 - j
+			}
+		case attr.Type == stunAttrResponsePort && len(attr.Value) == 4:
+			dst = &net.UDPAddr{IP: src.IP, Port: int(binary.BigEndian.Uint16(attr.Value))}
+		case attr.Type < 0x8000:
+			// Atributos de compreensão obrigatória que não conhecemos (inclui CHANGE-REQUEST
+			// sem endereço alternativo) exigem erro 420
+			if attr.Type != stunAttrChangeRequest || !s.rfc5780 {
+				unknown = append(unknown, attr.Type)
+			}
+		}
+	}
+
+	response := &stunMessage{TransactionID: request.TransactionID}
+	if len(unknown) > 0 {
+		response.Type = stunBindingErrorResponse
+		response.Attributes = []stunAttribute{
+			stunErrorCode(stunErrorUnknownAttribute, "Unknown Attribute"),
+			{Type: stunAttrUnknownAttributes, Value: encodeAttributeList(unknown)},
+		}
+		// Erros sempre saem pelo socket que recebeu, para a origem da requisição
+		ri, rj, dst = i, j, src
+	} else {
+		response.Type = stunBindingSuccess
+		response.Attributes = []stunAttribute{
+			{Type: stunAttrXORMappedAddress, Value: encodeSTUNAddress(src, true, request.TransactionID)},
+		}
+		if s.rfc5780 {
+			response.Attributes = append(response.Attributes,
+				stunAttribute{Type: stunAttrResponseOrigin, Value: encodeSTUNAddress(s.addr(ri, rj), false, request.TransactionID)},
+				stunAttribute{Type: stunAttrOtherAddress, Value: encodeSTUNAddress(s.addr(1-i, 1-j), false, request.TransactionID)},
+			)
+		}
+	}
+
+	if _, err := s.conns[ri][rj].WriteTo(response.encode(), dst); err != nil {
+		fmt.Printf("Erro ao enviar resposta STUN para %s: %v\n", dst, err)
+	}
+}
+
+// encodeSTUNAddress codifica um atributo de endereço, aplicando o XOR quando solicitado
+func encodeSTUNAddress(addr *net.UDPAddr, xor bool, transactionID [12]byte) []byte {
+	family := byte(stunFamilyIPv4)
+	ip := addr.IP.To4()
+	if ip == nil {
+		family = stunFamilyIPv6
+		ip = addr.IP.To16()
+	}
+	ip = append(net.IP(nil), ip...)
+
+	port := uint16(addr.Port)
+	if xor {
+		port ^= uint16(stunMagicCookie >> 16)
+		key := stunXORKey(transactionID)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	value := []byte{0, family}
+	value = binary.BigEndian.AppendUint16(value, port)
+	return append(value, ip...)
+}
+
+// stunErrorCode monta o atributo ERROR-CODE
+func stunErrorCode(code int, reason string) stunAttribute {
+	value := []byte{0, 0, byte(code / 100), byte(code % 100)}
+	return stunAttribute{Type: stunAttrErrorCode, Value: append(value, reason...)}
+}
+
+// encodeAttributeList codifica a lista do atributo UNKNOWN-ATTRIBUTES
+func encodeAttributeList(types []uint16) []byte {
+	value := make([]byte, 0, 2*len(types))
+	for _, attrType := range types {
+		value = binary.BigEndian.AppendUint16(value, attrType)
+	}
+	return value
+}
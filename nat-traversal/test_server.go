@@ -12,20 +12,30 @@ import (
 // TestServer implementa un servidor de prueba para NAT traversal
 type TestServer struct {
 	port      int
-	conn      *net.UDPConn
+	conn      net.PacketConn
+	receiving sync.WaitGroup // Goroutine de recebimento, aguardada por Stop antes de soltar o socket
+	listen    AddrListener
 	running   bool
 	mutex     sync.Mutex
 	clients   map[string]*net.UDPAddr
 	clientsMx sync.RWMutex
 	stopChan  chan struct{}
+	
+	// Manipulador opcional para protocolos binários construídos sobre o servidor
+	packetHandler PacketHandler
 }
 
+// PacketHandler processa um pacote antes dos comandos de texto do servidor.
+// Retorna true se o pacote foi consumido.
+type PacketHandler func(data []byte, addr *net.UDPAddr) bool
+
 // NewTestServer cria um novo servidor de teste
 // NewTestServer creates a new test server
 // NewTestServer crea un nuevo servidor de prueba
 func NewTestServer(port int) (*TestServer, error) {
 	server := &TestServer{
 		port:     port,
+		listen:   ListenUDPAddr,
 		clients:  make(map[string]*net.UDPAddr),
 		stopChan: make(chan struct{}),
 	}
@@ -33,6 +43,24 @@ func NewTestServer(port int) (*TestServer, error) {
 	return server, nil
 }
 
+// SetPacketHandler registra um manipulador chamado para cada pacote recebido
+// SetPacketHandler registers a handler called for every received packet
+// SetPacketHandler registra un manejador llamado para cada paquete recibido
+func (s *TestServer) SetPacketHandler(handler PacketHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.packetHandler = handler
+}
+
+// SetListener define como o socket do servidor é aberto. Deve ser chamado antes de Start.
+// SetListener sets how the server socket is opened. Must be called before Start.
+// SetListener define cómo se abre el socket del servidor. Debe llamarse antes de Start.
+func (s *TestServer) SetListener(listen AddrListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listen = listen
+}
+
 // Start inicia o servidor para escutar conexões
 // Start starts the server to listen for connections
 // Start inicia el servidor para escuchar conexiones
@@ -50,7 +78,7 @@ func (s *TestServer) Start() error {
 		Port: s.port,
 	}
 	
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := s.listen(addr)
 	if err != nil {
 		return fmt.Errorf("erro ao criar socket UDP: %w", err)
 	}
@@ -59,7 +87,8 @@ func (s *TestServer) Start() error {
 	s.running = true
 	
 	// Iniciar goroutine para processar mensagens
-	go s.processMessages()
+	s.receiving.Add(1)
+	go s.processMessages(conn)
 	
 	return nil
 }
@@ -69,27 +98,35 @@ func (s *TestServer) Start() error {
 // Stop detiene el servidor
 func (s *TestServer) Stop() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 	
 	if !s.running {
+		s.mutex.Unlock()
 		return nil
 	}
 	
 	// Sinalizar para a goroutine parar
 	close(s.stopChan)
 	
-	// Fechar o socket
+	// Fechar o socket, o que desbloqueia a leitura em andamento
 	if s.conn != nil {
 		s.conn.Close()
-		s.conn = nil
 	}
 	
 	s.running = false
+	s.mutex.Unlock()
+	
+	// O processamento de uma mensagem trava o mutex, então a espera acontece sem ele
+	s.receiving.Wait()
+	
+	s.mutex.Lock()
+	s.conn = nil
+	s.mutex.Unlock()
 	return nil
 }
 
-// processMessages processa mensagens recebidas dos clientes
-func (s *TestServer) processMessages() {
+// processMessages processa mensagens recebidas dos clientes no socket aberto por Start
+func (s *TestServer) processMessages(conn net.PacketConn) {
+	defer s.receiving.Done()
 	buffer := make([]byte, 2048)
 	
 	for {
@@ -98,27 +135,44 @@ func (s *TestServer) processMessages() {
 			return
 		default:
 			// Configurar timeout para não bloquear indefinidamente
-			s.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 			
-			n, addr, err := s.conn.ReadFromUDP(buffer)
+			n, from, err := conn.ReadFrom(buffer)
 			if err != nil {
 				// Ignorar erros de timeout
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue
 				}
 				
+				// O socket é fechado por Stop
+				select {
+				case <-s.stopChan:
+					return
+				default:
+				}
+				
 				fmt.Printf("Erro ao ler mensagem UDP: %v\n", err)
 				continue
 			}
 			
 			// Processar a mensagem recebida
-			s.handleMessage(buffer[:n], addr)
+			if addr, ok := from.(*net.UDPAddr); ok {
+				s.handleMessage(buffer[:n], addr)
+			}
 		}
 	}
 }
 
 // handleMessage processa uma mensagem recebida de um cliente
 func (s *TestServer) handleMessage(data []byte, addr *net.UDPAddr) {
+	s.mutex.Lock()
+	handler := s.packetHandler
+	s.mutex.Unlock()
+	
+	if handler != nil && handler(data, addr) {
+		return
+	}
+	
 	message := string(data)
 	
 	// Verificar se é um comando ou uma mensagem normal
@@ -242,6 +296,34 @@ func (s *TestServer) facilitateConnection(targetName string, requesterAddr *net.
 	s.sendToClient(connRequest, requesterAddr)
 }
 
+// SendTo envia um pacote bruto para um endereço a partir do socket do servidor
+// SendTo sends a raw packet to an address from the server socket
+// SendTo envía un paquete bruto a una dirección desde el socket del servidor
+func (s *TestServer) SendTo(data []byte, addr *net.UDPAddr) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	if !s.running || s.conn == nil {
+		return fmt.Errorf("servidor não está em execução")
+	}
+	
+	_, err := s.conn.WriteTo(data, addr)
+	return err
+}
+
+// LocalAddr retorna o endereço em que o servidor está escutando
+// LocalAddr returns the address the server is listening on
+// LocalAddr devuelve la dirección en la que escucha el servidor
+func (s *TestServer) LocalAddr() *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
 // sendToClient envia uma mensagem para um cliente específico
 func (s *TestServer) sendToClient(message string, addr *net.UDPAddr) {
 	s.mutex.Lock()
@@ -251,7 +333,7 @@ func (s *TestServer) sendToClient(message string, addr *net.UDPAddr) {
 		return
 	}
 	
-	_, err := s.conn.WriteToUDP([]byte(message), addr)
+	_, err := s.conn.WriteTo([]byte(message), addr)
 	if err != nil {
 		fmt.Printf("Erro ao enviar mensagem para %s:%d: %v\n", 
 			addr.IP, addr.Port, err)
@@ -0,0 +1,716 @@
+package nattraversal
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mensagens e atributos TURN (RFC 5766 / RFC 8656)
+const (
+	turnAllocateRequest         = 0x0003
+	turnRefreshRequest          = 0x0004
+	turnSendIndication          = 0x0016
+	turnDataIndication          = 0x0017
+	turnCreatePermissionRequest = 0x0008
+	turnChannelBindRequest      = 0x0009
+
+	// Classes somadas ao método nas respostas
+	stunClassSuccess = 0x0100
+	stunClassError   = 0x0110
+
+	stunAttrUsername         = 0x0006
+	stunAttrMessageIntegrity = 0x0008
+	stunAttrRealm            = 0x0014
+	stunAttrNonce            = 0x0015
+
+	turnAttrChannelNumber      = 0x000c
+	turnAttrLifetime           = 0x000d
+	turnAttrXORPeerAddress     = 0x0012
+	turnAttrData               = 0x0013
+	turnAttrXORRelayedAddress  = 0x0016
+	turnAttrRequestedTransport = 0x0019
+
+	turnTransportUDP = 17
+
+	turnErrUnauthorized = 401
+	turnErrStaleNonce   = 438
+
+	// Números de canal válidos para ChannelData
+	turnChannelMin = 0x4000
+	turnChannelMax = 0x7fff
+)
+
+// Tempos de vida do TURN
+const (
+	DefaultTURNLifetime = 10 * time.Minute // Lifetime pedido para a alocação, renovada na metade
+
+	// Permissões duram 5 minutos e canais 10; ambos são renovados antes disso
+	turnBindingRefresh = 4 * time.Minute
+)
+
+// TURNServer é um servidor TURN com credenciais de longo prazo
+// TURNServer is a TURN server with long-term credentials
+// TURNServer es un servidor TURN con credenciales de largo plazo
+type TURNServer struct {
+	Address  string
+	Port     int
+	Username string
+	Password string
+}
+
+// TURNError é uma resposta de erro do servidor TURN
+// TURNError is an error response from the TURN server
+// TURNError es una respuesta de error del servidor TURN
+type TURNError struct {
+	Code   int
+	Reason string
+}
+
+func (e *TURNError) Error() string {
+	return fmt.Sprintf("servidor TURN retornou erro %d: %s", e.Code, e.Reason)
+}
+
+// ParseTURNServers converte endereços "usuário:senha@host:porta" em servidores TURN
+func ParseTURNServers(addrs []string) ([]TURNServer, error) {
+	servers := make([]TURNServer, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		credentials, hostport, found := strings.Cut(addr, "@")
+		if !found {
+			return nil, fmt.Errorf("servidor TURN %q sem credenciais (usuário:senha@host:porta)", addr)
+		}
+		username, password, _ := strings.Cut(credentials, ":")
+
+		host, portStr, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return nil, fmt.Errorf("servidor TURN inválido %q: %w", addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("porta inválida no servidor TURN %q", addr)
+		}
+		servers = append(servers, TURNServer{Address: host, Port: port, Username: username, Password: password})
+	}
+	return servers, nil
+}
+
+// turnResponse é uma resposta recebida para uma transação pendente
+type turnResponse struct {
+	msg *stunMessage
+	raw []byte
+}
+
+// TURNClient mantém uma alocação UDP em um servidor TURN: autenticação de longo prazo,
+// permissões e canais para os peers, renovação dos tempos de vida e envio de dados
+// TURNClient keeps a UDP allocation on a TURN server: long-term authentication,
+// peer permissions and channels, lifetime refreshes and data transfer
+// TURNClient mantiene una asignación UDP en un servidor TURN: autenticación de largo
+// plazo, permisos y canales para los pares, renovación de tiempos de vida y envío de datos
+type TURNClient struct {
+	server        TURNServer
+	rto           time.Duration
+	transmissions int
+
+	conn     *net.UDPConn
+	realm    string
+	nonce    string
+	key      []byte // MD5(usuário:realm:senha)
+	relayed  *net.UDPAddr
+	mapped   *net.UDPAddr
+	lifetime time.Duration
+
+	// Canais vinculados por peer (ip:porta) e o inverso, para decodificar ChannelData
+	channels    map[string]uint16
+	channelPeer map[uint16]*net.UDPAddr
+	permissions map[string]*net.UDPAddr // Peers com permissão instalada, por IP
+	nextChannel uint16
+
+	handler func(peer *net.UDPAddr, data []byte)
+	pending map[[12]byte]chan turnResponse
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewTURNClient cria um cliente para o servidor informado
+func NewTURNClient(server TURNServer) *TURNClient {
+	return &TURNClient{
+		server:        server,
+		rto:           DefaultSTUNRTO,
+		transmissions: DefaultSTUNTransmissions,
+		lifetime:      DefaultTURNLifetime,
+		channels:      make(map[string]uint16),
+		channelPeer:   make(map[uint16]*net.UDPAddr),
+		permissions:   make(map[string]*net.UDPAddr),
+		nextChannel:   turnChannelMin,
+		pending:       make(map[[12]byte]chan turnResponse),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// SetRetransmission ajusta o tempo de espera inicial e o número de envios das requisições
+func (c *TURNClient) SetRetransmission(rto time.Duration, transmissions int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if rto > 0 {
+		c.rto = rto
+	}
+	if transmissions > 0 {
+		c.transmissions = transmissions
+	}
+}
+
+// SetLifetime define o tempo de vida pedido para a alocação. Deve ser chamado antes de Allocate.
+func (c *TURNClient) SetLifetime(lifetime time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lifetime = lifetime
+}
+
+// SetHandler define a função que recebe os dados enviados pelos peers através do relay
+func (c *TURNClient) SetHandler(handler func(peer *net.UDPAddr, data []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handler = handler
+}
+
+// Allocate abre o socket com o servidor e cria a alocação, retornando o endereço relayed
+func (c *TURNClient) Allocate() (*net.UDPAddr, error) {
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("a alocação TURN já existe")
+	}
+	serverAddr, err := net.ResolveUDPAddr("udp", turnServerAddr(c.server))
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("erro ao resolver servidor TURN: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("erro ao conectar ao servidor TURN: %w", err)
+	}
+	c.conn = conn
+	c.running = true
+	lifetime := c.lifetime
+	c.mutex.Unlock()
+
+	go c.receive(conn)
+
+	response, err := c.transact(turnAllocateRequest, []stunAttribute{
+		{Type: turnAttrRequestedTransport, Value: []byte{turnTransportUDP, 0, 0, 0}},
+		turnLifetime(lifetime),
+	})
+	if err != nil {
+		c.shutdown()
+		return nil, fmt.Errorf("erro na alocação TURN: %w", err)
+	}
+
+	value, ok := response.attribute(turnAttrXORRelayedAddress)
+	if !ok {
+		c.shutdown()
+		return nil, fmt.Errorf("resposta de alocação sem XOR-RELAYED-ADDRESS")
+	}
+	relayed, err := decodeSTUNAddress(value, true, response.TransactionID)
+	if err != nil {
+		c.shutdown()
+		return nil, err
+	}
+	// Servidores atrás de um IP de wildcard informam 0.0.0.0; o endereço útil é o do servidor
+	if relayed.IP.IsUnspecified() {
+		relayed.IP = serverAddr.IP
+	}
+	mapped, _ := response.mappedAddress()
+
+	c.mutex.Lock()
+	c.relayed = relayed
+	c.mapped = mapped
+	c.lifetime = responseLifetime(response, lifetime)
+	granted := c.lifetime
+	c.mutex.Unlock()
+
+	go c.maintain()
+
+	fmt.Printf("Alocação TURN criada em %s: endereço relayed %s (lifetime %s)\n",
+		serverAddr, relayed, granted)
+	return relayed, nil
+}
+
+// RelayedAddress retorna o endereço alocado no servidor, ou nil sem alocação
+func (c *TURNClient) RelayedAddress() *net.UDPAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.relayed
+}
+
+// MappedAddress retorna o endereço público do cliente visto pelo servidor TURN
+func (c *TURNClient) MappedAddress() *net.UDPAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.mapped
+}
+
+// CreatePermission permite que os peers informados enviem dados ao endereço relayed
+func (c *TURNClient) CreatePermission(peers ...*net.UDPAddr) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("nenhum peer informado")
+	}
+	if _, err := c.transact(turnCreatePermissionRequest, nil, peers...); err != nil {
+		return fmt.Errorf("erro ao criar permissão TURN: %w", err)
+	}
+
+	c.mutex.Lock()
+	for _, peer := range peers {
+		c.permissions[peer.IP.String()] = peer
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+// ChannelBind vincula um canal ao peer, o que também instala a permissão e reduz o overhead
+// de cada pacote de 36 para 4 bytes
+func (c *TURNClient) ChannelBind(peer *net.UDPAddr) error {
+	c.mutex.Lock()
+	channel, bound := c.channels[peer.String()]
+	if !bound {
+		if c.nextChannel > turnChannelMax {
+			c.mutex.Unlock()
+			return fmt.Errorf("canais TURN esgotados")
+		}
+		channel = c.nextChannel
+		c.nextChannel++
+	}
+	c.mutex.Unlock()
+
+	if err := c.bindChannel(peer, channel); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.channels[peer.String()] = channel
+	c.channelPeer[channel] = peer
+	c.permissions[peer.IP.String()] = peer
+	c.mutex.Unlock()
+	return nil
+}
+
+// bindChannel envia o ChannelBind de um canal, usado também para renová-lo
+func (c *TURNClient) bindChannel(peer *net.UDPAddr, channel uint16) error {
+	attributes := []stunAttribute{{
+		Type:  turnAttrChannelNumber,
+		Value: []byte{byte(channel >> 8), byte(channel), 0, 0},
+	}}
+	if _, err := c.transact(turnChannelBindRequest, attributes, peer); err != nil {
+		return fmt.Errorf("erro ao vincular canal TURN: %w", err)
+	}
+	return nil
+}
+
+// Send envia dados ao peer pelo relay: por ChannelData quando há canal vinculado, ou por
+// uma Send indication, que exige permissão para o IP do peer
+func (c *TURNClient) Send(peer *net.UDPAddr, data []byte) error {
+	c.mutex.Lock()
+	conn := c.conn
+	channel, bound := c.channels[peer.String()]
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("sem alocação TURN")
+	}
+
+	if bound {
+		frame := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint16(frame[0:2], channel)
+		binary.BigEndian.PutUint16(frame[2:4], uint16(len(data)))
+		_, err := conn.Write(append(frame, data...))
+		return err
+	}
+
+	transactionID, err := newSTUNTransactionID()
+	if err != nil {
+		return err
+	}
+	indication := &stunMessage{
+		Type:          turnSendIndication,
+		TransactionID: transactionID,
+		Attributes: []stunAttribute{
+			{Type: turnAttrXORPeerAddress, Value: encodeSTUNAddress(peer, true, transactionID)},
+			{Type: turnAttrData, Value: data},
+		},
+	}
+	_, err = conn.Write(indication.encode())
+	return err
+}
+
+// Close remove a alocação do servidor (Refresh com lifetime zero) e fecha o socket
+func (c *TURNClient) Close() error {
+	c.mutex.Lock()
+	if !c.running {
+		c.mutex.Unlock()
+		return nil
+	}
+	allocated := c.relayed != nil
+	c.mutex.Unlock()
+
+	if allocated {
+		if _, err := c.transact(turnRefreshRequest, []stunAttribute{turnLifetime(0)}); err != nil {
+			fmt.Printf("Erro ao remover alocação TURN: %v\n", err)
+		}
+	}
+	c.shutdown()
+	return nil
+}
+
+// shutdown fecha o socket e encerra as goroutines
+func (c *TURNClient) shutdown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.running {
+		return
+	}
+	close(c.stopChan)
+	c.conn.Close()
+	c.conn = nil
+	c.relayed = nil
+	c.running = false
+}
+
+// maintain renova a alocação na metade do lifetime e as permissões e canais antes de expirarem
+func (c *TURNClient) maintain() {
+	c.mutex.Lock()
+	stopChan := c.stopChan
+	c.mutex.Unlock()
+
+	bindings := time.NewTicker(turnBindingRefresh)
+	defer bindings.Stop()
+
+	c.mutex.Lock()
+	allocation := time.NewTimer(c.lifetime / 2)
+	c.mutex.Unlock()
+	defer allocation.Stop()
+
+	for {
+		select {
+		case <-allocation.C:
+			c.mutex.Lock()
+			requested := c.lifetime
+			c.mutex.Unlock()
+			response, err := c.transact(turnRefreshRequest, []stunAttribute{turnLifetime(requested)})
+			if err != nil {
+				fmt.Printf("Erro ao renovar alocação TURN: %v\n", err)
+				// Nova tentativa antes que a alocação expire
+				allocation.Reset(requested / 8)
+				continue
+			}
+			c.mutex.Lock()
+			c.lifetime = responseLifetime(response, requested)
+			allocation.Reset(c.lifetime / 2)
+			c.mutex.Unlock()
+
+		case <-bindings.C:
+			c.refreshBindings()
+
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// refreshBindings renova os canais vinculados e as permissões dos demais peers
+func (c *TURNClient) refreshBindings() {
+	c.mutex.Lock()
+	channels := make(map[uint16]*net.UDPAddr, len(c.channelPeer))
+	for channel, peer := range c.channelPeer {
+		channels[channel] = peer
+	}
+	var permissions []*net.UDPAddr
+	for ip, peer := range c.permissions {
+		covered := false
+		for _, bound := range channels {
+			covered = covered || bound.IP.String() == ip
+		}
+		if !covered {
+			permissions = append(permissions, peer)
+		}
+	}
+	c.mutex.Unlock()
+
+	for channel, peer := range channels {
+		if err := c.bindChannel(peer, channel); err != nil {
+			fmt.Printf("Erro ao renovar canal TURN de %s: %v\n", peer, err)
+		}
+	}
+	if len(permissions) > 0 {
+		if err := c.CreatePermission(permissions...); err != nil {
+			fmt.Printf("Erro ao renovar permissões TURN: %v\n", err)
+		}
+	}
+}
+
+// transact executa uma requisição autenticada, obtendo realm e nonce no primeiro 401 e
+// atualizando o nonce quando o servidor o declara vencido (438). Os peers viram atributos
+// XOR-PEER-ADDRESS, que dependem do ID de cada transação.
+func (c *TURNClient) transact(method uint16, attributes []stunAttribute, peers ...*net.UDPAddr) (*stunMessage, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		response, err := c.roundTrip(method, attributes, peers)
+		if err != nil {
+			return nil, err
+		}
+		if response.Type == method|stunClassSuccess {
+			return response, nil
+		}
+
+		turnErr := turnErrorCode(response)
+		realm, _ := response.attribute(stunAttrRealm)
+		nonce, _ := response.attribute(stunAttrNonce)
+		retry := (turnErr.Code == turnErrUnauthorized && c.authenticating()) || turnErr.Code == turnErrStaleNonce
+		if !retry || len(nonce) == 0 {
+			return nil, turnErr
+		}
+
+		c.mutex.Lock()
+		if len(realm) > 0 {
+			c.realm = string(realm)
+		}
+		c.nonce = string(nonce)
+		c.key = turnKey(c.server.Username, c.realm, c.server.Password)
+		c.mutex.Unlock()
+	}
+	return nil, fmt.Errorf("servidor TURN recusou as credenciais")
+}
+
+// authenticating indica se o cliente ainda não enviou credenciais (o 401 esperado)
+func (c *TURNClient) authenticating() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.key == nil
+}
+
+// roundTrip envia uma requisição com retransmissão e espera a resposta da mesma transação
+func (c *TURNClient) roundTrip(method uint16, attributes []stunAttribute, peers []*net.UDPAddr) (*stunMessage, error) {
+	transactionID, err := newSTUNTransactionID()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	conn := c.conn
+	stopChan := c.stopChan
+	if conn == nil {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("sem conexão com o servidor TURN")
+	}
+
+	request := &stunMessage{Type: method, TransactionID: transactionID}
+	for _, peer := range peers {
+		request.Attributes = append(request.Attributes,
+			stunAttribute{Type: turnAttrXORPeerAddress, Value: encodeSTUNAddress(peer, true, transactionID)})
+	}
+	request.Attributes = append(request.Attributes, attributes...)
+	key := c.key
+	if key != nil {
+		request.Attributes = append(request.Attributes,
+			stunAttribute{Type: stunAttrUsername, Value: []byte(c.server.Username)},
+			stunAttribute{Type: stunAttrRealm, Value: []byte(c.realm)},
+			stunAttribute{Type: stunAttrNonce, Value: []byte(c.nonce)},
+		)
+	}
+	rto, transmissions := c.rto, c.transmissions
+
+	responses := make(chan turnResponse, 1)
+	c.pending[transactionID] = responses
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, transactionID)
+		c.mutex.Unlock()
+	}()
+
+	data := request.encodeWithIntegrity(key)
+	timeout := rto
+	for attempt := 0; attempt < transmissions; attempt++ {
+		if _, err := conn.Write(data); err != nil {
+			return nil, fmt.Errorf("erro ao enviar requisição TURN: %w", err)
+		}
+
+		timer := time.NewTimer(timeout)
+		select {
+		case response := <-responses:
+			timer.Stop()
+			// Respostas de sucesso autenticadas precisam da integridade correta
+			if key != nil && response.msg.Type&stunClassError == stunClassSuccess && !stunIntegrityValid(response.raw, key) {
+				return nil, fmt.Errorf("resposta TURN com MESSAGE-INTEGRITY inválido")
+			}
+			return response.msg, nil
+		case <-timer.C:
+		case <-stopChan:
+			timer.Stop()
+			return nil, fmt.Errorf("cliente TURN encerrado")
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("servidor TURN não respondeu após %d tentativas", transmissions)
+}
+
+// receive lê o socket do servidor: respostas vão para as transações pendentes e dados
+// (ChannelData ou Data indication) para o handler
+func (c *TURNClient) receive(conn *net.UDPConn) {
+	buffer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		// As mensagens decodificadas apontam para os bytes lidos, que precisam sobreviver à
+		// próxima leitura
+		data := append([]byte(nil), buffer[:n]...)
+
+		// ChannelData: os dois primeiros bits 01 distinguem do STUN
+		if n >= 4 && data[0]&0xc0 == 0x40 {
+			channel := binary.BigEndian.Uint16(data[0:2])
+			length := int(binary.BigEndian.Uint16(data[2:4]))
+			c.mutex.Lock()
+			peer := c.channelPeer[channel]
+			handler := c.handler
+			c.mutex.Unlock()
+			if peer != nil && handler != nil && 4+length <= n {
+				handler(peer, data[4:4+length])
+			}
+			continue
+		}
+
+		msg, err := parseSTUNMessage(data)
+		if err != nil {
+			continue
+		}
+
+		if msg.Type == turnDataIndication {
+			c.deliverIndication(msg)
+			continue
+		}
+
+		c.mutex.Lock()
+		responses, ok := c.pending[msg.TransactionID]
+		c.mutex.Unlock()
+		if ok {
+			select {
+			case responses <- turnResponse{msg: msg, raw: data}:
+			default:
+			}
+		}
+	}
+}
+
+// deliverIndication entrega ao handler os dados de uma Data indication
+func (c *TURNClient) deliverIndication(msg *stunMessage) {
+	value, ok := msg.attribute(turnAttrXORPeerAddress)
+	if !ok {
+		return
+	}
+	peer, err := decodeSTUNAddress(value, true, msg.TransactionID)
+	if err != nil {
+		return
+	}
+	payload, ok := msg.attribute(turnAttrData)
+	if !ok {
+		return
+	}
+
+	c.mutex.Lock()
+	handler := c.handler
+	c.mutex.Unlock()
+	if handler != nil {
+		handler(peer, payload)
+	}
+}
+
+// encodeWithIntegrity serializa a mensagem e, com uma chave, acrescenta o MESSAGE-INTEGRITY
+// (HMAC-SHA1 da mensagem com o tamanho já contando o próprio atributo)
+func (m *stunMessage) encodeWithIntegrity(key []byte) []byte {
+	data := m.encode()
+	if key == nil {
+		return data
+	}
+
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)-stunHeaderSize+24))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+
+	data = binary.BigEndian.AppendUint16(data, stunAttrMessageIntegrity)
+	data = binary.BigEndian.AppendUint16(data, sha1.Size)
+	return mac.Sum(data)
+}
+
+// stunIntegrityValid confere o MESSAGE-INTEGRITY de uma mensagem recebida
+func stunIntegrityValid(data []byte, key []byte) bool {
+	if len(data) < stunHeaderSize {
+		return false
+	}
+	offset := stunHeaderSize
+	for offset+4 <= len(data) {
+		attrType := binary.BigEndian.Uint16(data[offset : offset+2])
+		attrLen := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if attrType == stunAttrMessageIntegrity {
+			if attrLen != sha1.Size || offset+4+sha1.Size > len(data) {
+				return false
+			}
+			signed := append([]byte(nil), data[:offset]...)
+			binary.BigEndian.PutUint16(signed[2:4], uint16(offset-stunHeaderSize+24))
+			mac := hmac.New(sha1.New, key)
+			mac.Write(signed)
+			return hmac.Equal(mac.Sum(nil), data[offset+4:offset+4+sha1.Size])
+		}
+		offset += 4 + (attrLen+3)&^3
+	}
+	return false
+}
+
+// turnKey deriva a chave das credenciais de longo prazo: MD5(usuário:realm:senha)
+func turnKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// turnLifetime monta o atributo LIFETIME
+func turnLifetime(lifetime time.Duration) stunAttribute {
+	return stunAttribute{Type: turnAttrLifetime, Value: binary.BigEndian.AppendUint32(nil, uint32(lifetime/time.Second))}
+}
+
+// responseLifetime lê o LIFETIME concedido, ou mantém o pedido se o servidor não informar
+func responseLifetime(response *stunMessage, requested time.Duration) time.Duration {
+	if value, ok := response.attribute(turnAttrLifetime); ok && len(value) == 4 {
+		if granted := time.Duration(binary.BigEndian.Uint32(value)) * time.Second; granted > 0 {
+			return granted
+		}
+	}
+	return requested
+}
+
+// turnErrorCode decodifica o ERROR-CODE de uma resposta de erro
+func turnErrorCode(response *stunMessage) *TURNError {
+	value, ok := response.attribute(stunAttrErrorCode)
+	if !ok || len(value) < 4 {
+		return &TURNError{Code: 0, Reason: "erro sem código"}
+	}
+	return &TURNError{Code: int(value[2]&0x07)*100 + int(value[3]), Reason: string(value[4:])}
+}
+
+// turnServerAddr formata o endereço de um servidor TURN
+func turnServerAddr(server TURNServer) string {
+	return net.JoinHostPort(server.Address, strconv.Itoa(server.Port))
+}
@@ -0,0 +1,74 @@
+package nattraversal
+
+import (
+	"net"
+)
+
+// TURNRelay leva o tráfego WireGuard de peers inalcançáveis diretamente por uma alocação
+// TURN. Cada peer ganha um proxy UDP local (127.0.0.1) que passa a ser seu endpoint no
+// WireGuard: o que o WireGuard envia ao proxy sai pelo relay até o peer, e o que o peer
+// envia ao endereço relayed volta ao WireGuard pela porta do proxy.
+// TURNRelay carries the WireGuard traffic of peers unreachable directly through a TURN
+// allocation. Each peer gets a local UDP proxy (127.0.0.1) that becomes its WireGuard
+// endpoint, forwarding in both directions through the relay.
+// TURNRelay lleva el tráfico WireGuard de pares inalcanzables directamente por una
+// asignación TURN. Cada par recibe un proxy UDP local (127.0.0.1) que pasa a ser su
+// endpoint WireGuard, reenviando en ambos sentidos por el relay.
+type TURNRelay struct {
+	client  *TURNClient
+	proxies *relayProxySet // Proxies por endereço do peer (ip:porta)
+}
+
+// NewTURNRelay cria um relay pelo servidor TURN para o WireGuard escutando em wgPort
+func NewTURNRelay(server TURNServer, wgPort int) *TURNRelay {
+	return &TURNRelay{
+		client:  NewTURNClient(server),
+		proxies: newRelayProxySet(wgPort),
+	}
+}
+
+// Client retorna o cliente TURN do relay, para ajustes antes de Start
+func (r *TURNRelay) Client() *TURNClient {
+	return r.client
+}
+
+// Start cria a alocação no servidor TURN
+func (r *TURNRelay) Start() error {
+	r.client.SetHandler(r.deliver)
+	_, err := r.client.Allocate()
+	return err
+}
+
+// Stop fecha os proxies e remove a alocação
+func (r *TURNRelay) Stop() error {
+	r.proxies.close()
+	return r.client.Close()
+}
+
+// RelayedAddress retorna o endereço alocado no servidor TURN
+func (r *TURNRelay) RelayedAddress() *net.UDPAddr {
+	return r.client.RelayedAddress()
+}
+
+// AddPeer vincula um canal ao peer e retorna o endereço do proxy local que deve ser usado
+// como endpoint WireGuard do peer
+func (r *TURNRelay) AddPeer(peer *net.UDPAddr) (*net.UDPAddr, error) {
+	if !r.proxies.has(peer.String()) {
+		if err := r.client.ChannelBind(peer); err != nil {
+			return nil, err
+		}
+	}
+	return r.proxies.add(peer.String(), func(data []byte) error {
+		return r.client.Send(peer, data)
+	})
+}
+
+// RemovePeer fecha o proxy do peer. O canal expira sozinho no servidor.
+func (r *TURNRelay) RemovePeer(peer *net.UDPAddr) {
+	r.proxies.remove(peer.String())
+}
+
+// deliver entrega ao WireGuard os dados recebidos do peer pelo relay
+func (r *TURNRelay) deliver(peer *net.UDPAddr, data []byte) {
+	r.proxies.deliver(peer.String(), data)
+}
@@ -0,0 +1,402 @@
+package nattraversal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parâmetros do UPnP IGD
+const (
+	DefaultSSDPAddr    = "239.255.255.250:1900" // Grupo multicast do SSDP
+	DefaultSSDPTimeout = 2 * time.Second        // Espera pelas respostas ao M-SEARCH
+
+	upnpDescription = "p2p-vpn WireGuard"
+)
+
+// Tipos de serviço que controlam o mapeamento de portas, em ordem de preferência
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// Códigos de erro UPnP tratados pelo cliente
+const (
+	upnpErrConflict           = 718 // ConflictInMappingEntry
+	upnpErrOnlyPermanentLease = 725 // OnlyPermanentLeasesSupported
+)
+
+// UPnPError é um erro retornado pelo gateway em uma chamada SOAP
+// UPnPError is an error returned by the gateway in a SOAP call
+// UPnPError es un error devuelto por el gateway en una llamada SOAP
+type UPnPError struct {
+	Code        int
+	Description string
+}
+
+func (e *UPnPError) Error() string {
+	return fmt.Sprintf("gateway UPnP retornou erro %d: %s", e.Code, e.Description)
+}
+
+// UPnPClient descobre gateways de internet (IGD) via SSDP
+// UPnPClient discovers internet gateway devices (IGD) via SSDP
+// UPnPClient descubre gateways de internet (IGD) mediante SSDP
+type UPnPClient struct {
+	ssdpAddr   string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// NewUPnPClient cria um cliente que procura gateways no grupo multicast padrão do SSDP
+func NewUPnPClient() *UPnPClient {
+	return &UPnPClient{
+		ssdpAddr:   DefaultSSDPAddr,
+		timeout:    DefaultSSDPTimeout,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// SetSSDPAddr define para onde o M-SEARCH é enviado (ex: um gateway conhecido em unicast)
+func (c *UPnPClient) SetSSDPAddr(addr string) {
+	c.ssdpAddr = addr
+}
+
+// SetTimeout define a espera pelas respostas SSDP
+func (c *UPnPClient) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		c.timeout = timeout
+	}
+}
+
+// Discover procura um gateway com serviço WANIPConnection ou WANPPPConnection
+func (c *UPnPClient) Discover() (*UPnPGateway, error) {
+	target, err := net.ResolveUDPAddr("udp4", c.ssdpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("endereço SSDP inválido: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar socket SSDP: %w", err)
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + DefaultSSDPAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(search), target); err != nil {
+		return nil, fmt.Errorf("erro ao enviar M-SEARCH: %w", err)
+	}
+
+	// Vários dispositivos podem responder; o primeiro com um serviço de mapeamento é usado
+	deadline := time.Now().Add(c.timeout)
+	conn.SetReadDeadline(deadline)
+	buffer := make([]byte, 2048)
+	var lastErr error = fmt.Errorf("nenhum gateway UPnP respondeu")
+	seen := make(map[string]bool)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return nil, lastErr
+		}
+
+		location := ssdpLocation(buffer[:n])
+		if location == "" || seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		gateway, err := c.fetchGateway(location)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return gateway, nil
+	}
+}
+
+// Name retorna o nome do protocolo
+func (c *UPnPClient) Name() string {
+	return TechniqueUPnP
+}
+
+// AddMapping descobre o gateway e mapeia a porta interna, usando a porta externa sugerida
+// quando possível. Gateways que só aceitam mapeamentos permanentes recebem lease zero.
+func (c *UPnPClient) AddMapping(internalPort, externalPort int, lease time.Duration) (*PortMapping, error) {
+	gateway, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	externalIP, err := gateway.ExternalIP()
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &PortMapping{
+		Protocol:     TechniqueUPnP,
+		ExternalIP:   externalIP,
+		ExternalPort: externalPort,
+		InternalPort: internalPort,
+		Lease:        lease,
+		gateway:      gateway,
+	}
+	for attempt := 0; ; attempt++ {
+		err := gateway.AddPortMapping("UDP", mapping.ExternalPort, internalPort, mapping.Lease)
+		switch {
+		case err == nil:
+			return mapping, nil
+		case isUPnPError(err, upnpErrOnlyPermanentLease) && mapping.Lease != 0:
+			mapping.Lease = 0
+		case isUPnPError(err, upnpErrConflict) && attempt < 3:
+			// Outra máquina já usa a porta externa: tentar uma porta alta aleatória
+			mapping.ExternalPort = 49152 + rand.Intn(16384)
+		default:
+			return nil, err
+		}
+	}
+}
+
+// RenewMapping repete o AddPortMapping no mesmo gateway, o que renova o lease
+func (c *UPnPClient) RenewMapping(mapping *PortMapping) (*PortMapping, error) {
+	if err := mapping.gateway.AddPortMapping("UDP", mapping.ExternalPort, mapping.InternalPort, mapping.Lease); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// DeleteMapping remove o mapeamento do gateway que o criou
+func (c *UPnPClient) DeleteMapping(mapping *PortMapping) error {
+	return mapping.gateway.DeletePortMapping("UDP", mapping.ExternalPort)
+}
+
+// GatewayReset sempre retorna falso: o UPnP não informa reinícios do gateway, e um
+// mapeamento perdido é refeito na próxima renovação
+func (c *UPnPClient) GatewayReset() (bool, error) {
+	return false, nil
+}
+
+// ssdpLocation extrai o cabeçalho LOCATION de uma resposta SSDP
+func ssdpLocation(data []byte) string {
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return ""
+	}
+	response.Body.Close()
+	return response.Header.Get("Location")
+}
+
+// upnpDevice é um dispositivo da descrição XML do gateway, com seus subdispositivos
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService procura o serviço do tipo informado na árvore de dispositivos
+func (d *upnpDevice) findService(serviceType string) (string, bool) {
+	for _, service := range d.Services {
+		if service.ServiceType == serviceType {
+			return service.ControlURL, true
+		}
+	}
+	for i := range d.Devices {
+		if controlURL, ok := d.Devices[i].findService(serviceType); ok {
+			return controlURL, true
+		}
+	}
+	return "", false
+}
+
+// fetchGateway lê a descrição do dispositivo e localiza o serviço de mapeamento de portas
+func (c *UPnPClient) fetchGateway(location string) (*UPnPGateway, error) {
+	response, err := c.httpClient.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter descrição do gateway: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("descrição do gateway retornou %s", response.Status)
+	}
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("descrição do gateway inválida: %w", err)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if parsed, err := url.Parse(root.URLBase); err == nil {
+			base = parsed
+		}
+	}
+
+	for _, serviceType := range upnpServiceTypes {
+		controlURL, ok := root.Device.findService(serviceType)
+		if !ok {
+			continue
+		}
+		control, err := base.Parse(controlURL)
+		if err != nil {
+			return nil, fmt.Errorf("URL de controle inválida: %w", err)
+		}
+
+		// O IP interno informado ao gateway é o da rota até ele
+		localIP, err := routeSourceIP(control.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		return &UPnPGateway{
+			ControlURL:  control.String(),
+			ServiceType: serviceType,
+			LocalIP:     localIP,
+			httpClient:  c.httpClient,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("gateway %s não oferece mapeamento de portas", location)
+}
+
+// routeSourceIP retorna o IP local usado para alcançar o host informado (host:porta ou host)
+func routeSourceIP(hostport string) (net.IP, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "1900"))
+	if err != nil {
+		return nil, fmt.Errorf("sem rota até o gateway: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// UPnPGateway é o serviço de mapeamento de portas de um gateway descoberto
+// UPnPGateway is the port mapping service of a discovered gateway
+// UPnPGateway es el servicio de mapeo de puertos de un gateway descubierto
+type UPnPGateway struct {
+	ControlURL  string
+	ServiceType string
+	LocalIP     net.IP // IP deste host na rede do gateway
+
+	httpClient *http.Client
+}
+
+// ExternalIP consulta o endereço externo do gateway
+func (g *UPnPGateway) ExternalIP() (net.IP, error) {
+	body, err := g.call("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("resposta UPnP inválida: %w", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(envelope.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("gateway UPnP não informou o endereço externo")
+	}
+	return ip, nil
+}
+
+// AddPortMapping encaminha a porta externa para a porta interna deste host. lease zero
+// pede um mapeamento permanente.
+func (g *UPnPGateway) AddPortMapping(protocol string, externalPort, internalPort int, lease time.Duration) error {
+	_, err := g.call("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", protocol},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", g.LocalIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", upnpDescription},
+		{"NewLeaseDuration", strconv.Itoa(int(lease / time.Second))},
+	})
+	return err
+}
+
+// DeletePortMapping remove o mapeamento da porta externa
+func (g *UPnPGateway) DeletePortMapping(protocol string, externalPort int) error {
+	_, err := g.call("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", protocol},
+	})
+	return err
+}
+
+// call executa uma ação SOAP no serviço do gateway. Os argumentos seguem a ordem da
+// especificação, que alguns roteadores exigem.
+func (g *UPnPGateway) call(action string, args [][2]string) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + g.ServiceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		xml.EscapeText(&body, []byte(arg[1]))
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	request, err := http.NewRequest(http.MethodPost, g.ControlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+g.ServiceType+"#"+action+`"`)
+
+	response, err := g.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("erro na chamada UPnP %s: %w", action, err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler resposta UPnP: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var fault struct {
+			Code        int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+			Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		if xml.Unmarshal(data, &fault) == nil && fault.Code != 0 {
+			return nil, &UPnPError{Code: fault.Code, Description: fault.Description}
+		}
+		return nil, fmt.Errorf("chamada UPnP %s retornou %s", action, response.Status)
+	}
+	return data, nil
+}
+
+// isUPnPError indica se err é um erro UPnP com o código informado
+func isUPnPError(err error, code int) bool {
+	var upnpErr *UPnPError
+	return errors.As(err, &upnpErr) && upnpErr.Code == code
+}
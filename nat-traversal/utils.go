@@ -79,3 +79,18 @@ func FindAvailablePort() (int, error) {
 	
 	return conn.LocalAddr().(*net.UDPAddr).Port, nil
 }
+
+// isLocalIP verifica se o IP pertence a uma das interfaces desta máquina
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
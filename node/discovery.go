@@ -0,0 +1,61 @@
+package node
+
+import (
+	"flag"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+)
+
+// DiscoveryFlags são as opções de descoberta de peers da linha de comando, com os mesmos nomes e
+// padrões no executável principal e no comando 'start'. Opções vazias mantêm a configuração.
+// DiscoveryFlags are the peer discovery command-line options shared by the entry points
+// DiscoveryFlags son las opciones de descubrimiento de la línea de comandos compartidas por los puntos de entrada
+type DiscoveryFlags struct {
+	Backends     listFlag
+	Multicast    bool
+	Rendezvous   listFlag
+	DHT          bool
+	DHTBootstrap listFlag
+	DNSDomain    string
+	DNSServer    string
+}
+
+// RegisterDiscoveryFlags registra as opções de descoberta no conjunto de opções informado
+// RegisterDiscoveryFlags registers the discovery options on the given flag set
+// RegisterDiscoveryFlags registra las opciones de descubrimiento en el conjunto de opciones indicado
+func RegisterDiscoveryFlags(flags *flag.FlagSet) *DiscoveryFlags {
+	f := &DiscoveryFlags{}
+	flags.Var(&f.Backends, "backends", "Backends de descoberta ativos (static, multicast, rendezvous, dht, dns), separados por vírgula")
+	flags.BoolVar(&f.Multicast, "multicast", false, "Descobrir peers da mesma LAN via multicast")
+	flags.Var(&f.Rendezvous, "rendezvous", "Servidores de rendezvous (chave@host:porta), separados por vírgula")
+	flags.BoolVar(&f.DHT, "dht", false, "Descobrir peers via DHT, sem servidores")
+	flags.Var(&f.DHTBootstrap, "dht-bootstrap", "Nós de bootstrap do DHT (host:porta), separados por vírgula; ativam o DHT")
+	flags.StringVar(&f.DNSDomain, "dns-domain", "", "Domínio com os registros SRV/TXT dos peers")
+	flags.StringVar(&f.DNSServer, "dns-server", "", "Servidor DNS para a descoberta (host:porta); vazio usa o do sistema")
+	return f
+}
+
+// Apply sobrepõe à configuração de descoberta as opções informadas na linha de comando
+func (f *DiscoveryFlags) Apply(config *core.DiscoveryConfig) {
+	if len(f.Backends) > 0 {
+		config.Backends = f.Backends
+	}
+	if f.Multicast {
+		config.Multicast = true
+	}
+	if len(f.Rendezvous) > 0 {
+		config.RendezvousServers = f.Rendezvous
+	}
+	if f.DHT || len(f.DHTBootstrap) > 0 {
+		config.DHT = true
+	}
+	if len(f.DHTBootstrap) > 0 {
+		config.DHTBootstrap = f.DHTBootstrap
+	}
+	if f.DNSDomain != "" {
+		config.DNSDomain = f.DNSDomain
+	}
+	if f.DNSServer != "" {
+		config.DNSServer = f.DNSServer
+	}
+}
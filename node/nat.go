@@ -0,0 +1,204 @@
+package node
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+	"github.com/p2p-vpn/p2p-vpn/platform"
+)
+
+// listFlag é uma opção de linha de comando com vários valores, separados por vírgula ou
+// informados repetindo a opção
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// NATFlags são as opções de travessia de NAT da linha de comando, com os mesmos nomes e
+// padrões no executável principal e no comando 'start'. Opções vazias mantêm a configuração.
+// NATFlags are the NAT traversal command-line options shared by the entry points
+// NATFlags son las opciones de NAT de la línea de comandos compartidas por los puntos de entrada
+type NATFlags struct {
+	STUNServers   listFlag
+	STUNListen    string
+	STUNAlternate string
+	PortMapping   listFlag
+	TURNServers   listFlag
+	RelayListen   string
+}
+
+// RegisterNATFlags registra as opções de NAT no conjunto de opções informado
+// RegisterNATFlags registers the NAT options on the given flag set
+// RegisterNATFlags registra las opciones de NAT en el conjunto de opciones indicado
+func RegisterNATFlags(flags *flag.FlagSet) *NATFlags {
+	f := &NATFlags{}
+	flags.Var(&f.STUNServers, "stun-servers", "Servidores STUN (host:porta), separados por vírgula; podem ser outros nós da rede")
+	flags.StringVar(&f.STUNListen, "stun-listen", "", "Executar o servidor STUN embutido neste endereço (ex: :3478)")
+	flags.StringVar(&f.STUNAlternate, "stun-alternate", "", "Endereço alternativo (IP:porta) do servidor STUN, para os testes RFC 5780")
+	flags.Var(&f.PortMapping, "port-mapping", "Protocolos de mapeamento de porta (pcp, natpmp, upnp ou none) na ordem de tentativa, separados por vírgula")
+	flags.Var(&f.TURNServers, "turn-servers", "Servidores TURN (usuário:senha@host:porta) usados como relay, separados por vírgula")
+	flags.StringVar(&f.RelayListen, "relay-listen", "", "Operar um relay para os peers confiáveis neste endereço (ex: :3479)")
+	return f
+}
+
+// Apply sobrepõe à configuração de NAT as opções informadas na linha de comando
+func (f *NATFlags) Apply(config *core.NATConfig) {
+	if len(f.STUNServers) > 0 {
+		config.STUNServers = f.STUNServers
+	}
+	if f.STUNListen != "" {
+		config.STUNListen = f.STUNListen
+	}
+	if f.STUNAlternate != "" {
+		config.STUNAlternate = f.STUNAlternate
+	}
+	if len(f.PortMapping) > 0 {
+		config.PortMapping = f.PortMapping
+	}
+	if len(f.TURNServers) > 0 {
+		config.TURNServers = f.TURNServers
+	}
+	if f.RelayListen != "" {
+		config.RelayListen = f.RelayListen
+	}
+}
+
+// NAT reúne os serviços de travessia de NAT de um nó: a travessia, o servidor STUN embutido
+// e o monitor de mudanças de rede que refaz a descoberta e os caminhos até os peers.
+// NAT groups a node's NAT traversal services: traversal, embedded STUN server and network monitor
+// NAT reúne los servicios de NAT de un nodo: travesía, servidor STUN embebido y monitor de red
+type NAT struct {
+	Traversal *nattraversal.NATTraversal
+	Monitor   *platform.NetworkMonitor
+
+	stun *nattraversal.STUNService
+}
+
+// SetupNAT valida a configuração de NAT e monta os serviços ligados ao core da VPN e à
+// descoberta, sem iniciá-los: um erro de configuração interrompe o nó antes que qualquer
+// serviço suba. Os serviços são iniciados por Start e encerrados por Stop.
+// SetupNAT validates the NAT configuration and assembles the services without starting them
+// SetupNAT valida la configuración de NAT y monta los servicios sin iniciarlos
+func SetupNAT(config *core.Config, listenPort int, vpnCore core.VPNProvider, peerDiscovery *discovery.PeerDiscovery) (*NAT, error) {
+	traversal := nattraversal.NewNATTraversal(listenPort)
+
+	if len(config.NAT.STUNServers) > 0 {
+		servers, err := nattraversal.ParseSTUNServers(config.NAT.STUNServers)
+		if err != nil {
+			return nil, fmt.Errorf("erro na configuração de NAT: %w", err)
+		}
+		traversal.SetSTUNServers(servers)
+	}
+	if len(config.NAT.PortMapping) > 0 {
+		if err := traversal.SetPortMappingProtocols(config.NAT.PortMapping); err != nil {
+			return nil, fmt.Errorf("erro na configuração de NAT: %w", err)
+		}
+	}
+	if len(config.NAT.TURNServers) > 0 {
+		servers, err := nattraversal.ParseTURNServers(config.NAT.TURNServers)
+		if err != nil {
+			return nil, fmt.Errorf("erro na configuração de NAT: %w", err)
+		}
+		traversal.SetTURNServers(servers)
+	}
+	traversal.SetEndpointUpdater(vpnCore)
+	traversal.SetKeepaliveUpdater(vpnCore)
+
+	// Relay entre peers: a chave WireGuard autentica o nó nos relays e o próprio relay, se
+	// ativado, atende apenas os peers confiáveis
+	if err := traversal.SetRelayKey(config.PrivateKey); err != nil {
+		if config.NAT.RelayListen != "" {
+			return nil, fmt.Errorf("erro na configuração do relay entre peers: %w", err)
+		}
+		fmt.Printf("Aviso: relay entre peers desativado: %v\n", err)
+	} else if config.NAT.RelayListen != "" {
+		if _, err := net.ResolveUDPAddr("udp", config.NAT.RelayListen); err != nil {
+			return nil, fmt.Errorf("endereço do relay entre peers inválido: %w", err)
+		}
+		traversal.SetRelayService(config.NAT.RelayListen, func(publicKey string) bool {
+			for _, peer := range vpnCore.TrustedPeers() {
+				if peer.PublicKey == publicKey {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	// Servidor STUN embutido, para que outros nós não dependam de servidores públicos
+	var stunService *nattraversal.STUNService
+	if config.NAT.STUNListen != "" {
+		if _, err := net.ResolveUDPAddr("udp", config.NAT.STUNListen); err != nil {
+			return nil, fmt.Errorf("endereço do servidor STUN inválido: %w", err)
+		}
+		if config.NAT.STUNAlternate != "" {
+			if _, err := net.ResolveUDPAddr("udp", config.NAT.STUNAlternate); err != nil {
+				return nil, fmt.Errorf("endereço alternativo do servidor STUN inválido: %w", err)
+			}
+		}
+		stunService = nattraversal.NewSTUNService(config.NAT.STUNListen, config.NAT.STUNAlternate)
+	}
+
+	peerDiscovery.SetNATTraversal(traversal)
+
+	// Reagir a mudanças de rede (ex: Wi-Fi para tethering) sem esperar os ciclos periódicos
+	monitor := platform.NewNetworkMonitor(platform.DefaultNetworkDebounce)
+	vpnInterface := config.InterfaceName
+	if vpnInterface == "" {
+		vpnInterface = "wg0"
+	}
+	monitor.SetIgnoredInterfaces(vpnInterface)
+	monitor.Subscribe(func(change platform.NetworkChange) {
+		peerDiscovery.HandleNetworkChange(traversal)
+	})
+
+	return &NAT{
+		Traversal: traversal,
+		Monitor:   monitor,
+		stun:      stunService,
+	}, nil
+}
+
+// Start inicia a travessia de NAT, o servidor STUN embutido e o monitor de rede. Falhas em
+// tempo de execução, como uma porta ocupada, desativam apenas o serviço afetado.
+// Start starts NAT traversal, the embedded STUN server and the network monitor
+// Start inicia el NAT traversal, el servidor STUN embebido y el monitor de red
+func (n *NAT) Start() {
+	if err := n.Traversal.Start(); err != nil {
+		fmt.Printf("Aviso: NAT traversal desativado: %v\n", err)
+	}
+	if n.stun != nil {
+		if err := n.stun.Start(); err != nil {
+			fmt.Printf("Aviso: servidor STUN desativado: %v\n", err)
+		}
+	}
+	if err := n.Monitor.Start(); err != nil {
+		fmt.Printf("Aviso: mudanças de rede não serão detectadas: %v\n", err)
+	}
+}
+
+// Stop encerra o monitor de rede, o servidor STUN embutido e a travessia de NAT
+// Stop stops the network monitor, the embedded STUN server and NAT traversal
+// Stop detiene el monitor de red, el servidor STUN embebido y el NAT traversal
+func (n *NAT) Stop() {
+	n.Monitor.Stop()
+	if n.stun != nil {
+		n.stun.Stop()
+	}
+	n.Traversal.Stop()
+}
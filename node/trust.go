@@ -0,0 +1,46 @@
+// Package node reúne a montagem dos serviços de um nó da VPN compartilhada pelos pontos de
+// entrada (o executável principal e o comando 'start' da CLI), para que ambos apliquem a
+// mesma política de confiança e a mesma travessia de NAT.
+//
+// Package node holds the assembly of a VPN node's services shared by the entry points.
+// Package node reúne el montaje de los servicios de un nodo compartido por los puntos de entrada.
+package node
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/p2p-vpn/p2p-vpn/security"
+)
+
+// DefaultSecurityConfigPath é o arquivo de configuração de segurança usado por padrão
+const DefaultSecurityConfigPath = "config/server_security.yaml"
+
+// ApplyTrustPolicy carrega a configuração de segurança e aplica à descoberta o controle de
+// acesso da VPN (vpn.access). Sem o arquivo, a descoberta mantém a política padrão e a
+// configuração retornada é nil.
+// ApplyTrustPolicy loads the security configuration and applies the VPN access control to discovery
+// ApplyTrustPolicy carga la configuración de seguridad y aplica al descubrimiento el control de acceso
+func ApplyTrustPolicy(peerDiscovery *discovery.PeerDiscovery, securityConfigPath string) (*security.SecurityConfig, error) {
+	fmt.Println("Carregando configuração de segurança...")
+
+	if _, err := os.Stat(securityConfigPath); os.IsNotExist(err) {
+		fmt.Println("Arquivo de configuração de segurança não encontrado. Usando a política de confiança padrão.")
+		return nil, nil
+	}
+
+	securityConfig, err := security.LoadSecurityConfig(securityConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao carregar configuração de segurança: %w", err)
+	}
+
+	access := securityConfig.VPN.Access
+	policy, err := discovery.NewTrustPolicy(access.TrustedIPs, access.AuthenticatedOnly, access.VerifyPeerKeys)
+	if err != nil {
+		return nil, fmt.Errorf("erro na política de acesso: %w", err)
+	}
+	peerDiscovery.SetTrustPolicy(policy)
+
+	return securityConfig, nil
+}
@@ -0,0 +1,180 @@
+package platform
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Parâmetros do monitor de rede
+const (
+	DefaultNetworkDebounce = 2 * time.Second // Espera por novas mudanças antes de reagir
+	networkMaxDelay        = 5               // Atraso máximo de uma reação, em múltiplos do debounce
+	networkHistorySize     = 20              // Mudanças recentes mantidas para a interface
+)
+
+// NetworkChange descreve uma mudança de rede já consolidada pelo debounce
+// NetworkChange describes a network change already coalesced by the debounce
+// NetworkChange describe un cambio de red ya consolidado por el debounce
+type NetworkChange struct {
+	Time   time.Time `json:"time"`
+	Events []string  `json:"events"` // Eventos brutos agrupados (ex: "endereço removido: 192.168.1.5/24")
+}
+
+// NetworkMonitor observa mudanças de endereços, rotas e interfaces do sistema e avisa os
+// assinantes uma única vez por rajada de eventos
+// NetworkMonitor watches system address, route and interface changes and notifies
+// subscribers once per burst of events
+// NetworkMonitor observa cambios de direcciones, rutas e interfaces del sistema y avisa a
+// los suscriptores una sola vez por ráfaga de eventos
+type NetworkMonitor struct {
+	debounce time.Duration
+	ignored  map[string]bool
+	handlers []func(NetworkChange)
+	history  []NetworkChange
+
+	// Eventos aguardando o fim da rajada
+	pending   []string
+	firstSeen time.Time
+	timer     *time.Timer
+
+	running  bool
+	mutex    sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewNetworkMonitor cria um monitor que reage depois de debounce sem novos eventos
+func NewNetworkMonitor(debounce time.Duration) *NetworkMonitor {
+	if debounce <= 0 {
+		debounce = DefaultNetworkDebounce
+	}
+
+	return &NetworkMonitor{
+		debounce: debounce,
+		ignored:  make(map[string]bool),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// SetIgnoredInterfaces define interfaces cujas mudanças não contam, como a própria
+// interface WireGuard, que muda sempre que peers e rotas são configurados
+func (m *NetworkMonitor) SetIgnoredInterfaces(names ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ignored = make(map[string]bool)
+	for _, name := range names {
+		m.ignored[name] = true
+	}
+}
+
+// Subscribe registra uma função chamada a cada mudança de rede consolidada
+func (m *NetworkMonitor) Subscribe(handler func(NetworkChange)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+// Start passa a observar as mudanças de rede do sistema
+func (m *NetworkMonitor) Start() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running {
+		return fmt.Errorf("o monitor de rede já está em execução")
+	}
+
+	if err := watchNetwork(m, m.stopChan); err != nil {
+		return fmt.Errorf("erro ao observar mudanças de rede: %w", err)
+	}
+
+	m.running = true
+	return nil
+}
+
+// Stop para de observar a rede e descarta eventos ainda não processados
+func (m *NetworkMonitor) Stop() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	close(m.stopChan)
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.pending = nil
+
+	m.running = false
+	return nil
+}
+
+// Notify registra um evento de rede bruto. Eventos próximos são agrupados e os assinantes
+// são avisados quando a rede se estabiliza, ou no máximo após networkMaxDelay debounces.
+func (m *NetworkMonitor) Notify(event string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	select {
+	case <-m.stopChan:
+		return
+	default:
+	}
+
+	now := time.Now()
+	if len(m.pending) == 0 {
+		m.firstSeen = now
+	}
+	m.pending = append(m.pending, event)
+
+	delay := m.debounce
+	if deadline := m.firstSeen.Add(networkMaxDelay * m.debounce); now.Add(delay).After(deadline) {
+		delay = deadline.Sub(now)
+	}
+
+	if m.timer == nil {
+		m.timer = time.AfterFunc(delay, m.fire)
+	} else {
+		m.timer.Reset(delay)
+	}
+}
+
+// Events retorna as mudanças de rede mais recentes, da mais antiga para a mais nova
+func (m *NetworkMonitor) Events() []NetworkChange {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]NetworkChange(nil), m.history...)
+}
+
+// ignoresInterface indica se os eventos da interface devem ser descartados
+func (m *NetworkMonitor) ignoresInterface(name string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ignored[name]
+}
+
+// fire entrega os eventos acumulados aos assinantes
+func (m *NetworkMonitor) fire() {
+	m.mutex.Lock()
+	if len(m.pending) == 0 {
+		m.mutex.Unlock()
+		return
+	}
+
+	change := NetworkChange{Time: time.Now(), Events: m.pending}
+	m.pending = nil
+
+	m.history = append(m.history, change)
+	if len(m.history) > networkHistorySize {
+		m.history = m.history[len(m.history)-networkHistorySize:]
+	}
+	handlers := append([](func(NetworkChange)){}, m.handlers...)
+	m.mutex.Unlock()
+
+	fmt.Printf("Mudança de rede detectada (%d eventos)\n", len(change.Events))
+	for _, handler := range handlers {
+		handler(change)
+	}
+}
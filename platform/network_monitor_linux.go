@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+package platform
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// watchNetwork assina as notificações netlink de endereços, rotas e interfaces e as repassa
+// ao monitor até stop ser fechado
+func watchNetwork(m *NetworkMonitor, stop <-chan struct{}) error {
+	done := make(chan struct{})
+	addrs := make(chan netlink.AddrUpdate, 16)
+	routes := make(chan netlink.RouteUpdate, 16)
+	links := make(chan netlink.LinkUpdate, 16)
+
+	if err := netlink.AddrSubscribe(addrs, done); err != nil {
+		close(done)
+		return err
+	}
+	if err := netlink.RouteSubscribe(routes, done); err != nil {
+		close(done)
+		return err
+	}
+	if err := netlink.LinkSubscribe(links, done); err != nil {
+		close(done)
+		return err
+	}
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case update, ok := <-addrs:
+				if !ok {
+					return
+				}
+				if m.ignoresInterface(interfaceNameByIndex(update.LinkIndex)) {
+					continue
+				}
+				if update.NewAddr {
+					m.Notify(fmt.Sprintf("endereço adicionado: %s", update.LinkAddress.String()))
+				} else {
+					m.Notify(fmt.Sprintf("endereço removido: %s", update.LinkAddress.String()))
+				}
+
+			case update, ok := <-routes:
+				if !ok {
+					return
+				}
+				if m.ignoresInterface(interfaceNameByIndex(update.LinkIndex)) {
+					continue
+				}
+				if update.Type == unix.RTM_NEWROUTE {
+					m.Notify(fmt.Sprintf("rota adicionada: %s", routeDescription(update.Route)))
+				} else {
+					m.Notify(fmt.Sprintf("rota removida: %s", routeDescription(update.Route)))
+				}
+
+			case update, ok := <-links:
+				if !ok {
+					return
+				}
+				attrs := update.Attrs()
+				if m.ignoresInterface(attrs.Name) {
+					continue
+				}
+				if update.Header.Type == unix.RTM_DELLINK {
+					m.Notify(fmt.Sprintf("interface removida: %s", attrs.Name))
+				} else {
+					m.Notify(fmt.Sprintf("interface %s: %s", attrs.Name, attrs.OperState))
+				}
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// interfaceNameByIndex retorna o nome da interface, ou vazio se ela não existir mais
+func interfaceNameByIndex(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return ""
+	}
+	return iface.Name
+}
+
+// routeDescription descreve o destino e o gateway de uma rota
+func routeDescription(route netlink.Route) string {
+	destination := "default"
+	if route.Dst != nil {
+		destination = route.Dst.String()
+	}
+	if route.Gw != nil {
+		return destination + " via " + route.Gw.String()
+	}
+	return destination
+}
@@ -0,0 +1,71 @@
+//go:build !linux
+// +build !linux
+
+package platform
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// networkPollInterval é o intervalo de verificação dos endereços fora do Linux
+const networkPollInterval = 5 * time.Second
+
+// watchNetwork compara periodicamente os endereços das interfaces, já que não há
+// notificações netlink fora do Linux
+func watchNetwork(m *NetworkMonitor, stop <-chan struct{}) error {
+	previous, err := addressSnapshot(m)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(networkPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				current, err := addressSnapshot(m)
+				if err != nil {
+					continue
+				}
+				if current != previous {
+					m.Notify("endereços das interfaces alterados: " + current)
+					previous = current
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// addressSnapshot lista os endereços das interfaces ativas que não são ignoradas
+func addressSnapshot(m *NetworkMonitor) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	var entries []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || m.ignoresInterface(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			entries = append(entries, iface.Name+"="+addr.String())
+		}
+	}
+
+	sort.Strings(entries)
+	return strings.Join(entries, " "), nil
+}
@@ -30,7 +30,8 @@ type VPNPlatform interface {
 	// Configura o endereço IP na interface
 	ConfigureInterfaceAddress(interfaceName, address, subnet string) error
 	
-	// Adiciona um peer à interface WireGuard
+	// Adiciona um peer à interface WireGuard; keepAlive em segundos, zero mantém o atual e
+	// KeepaliveOff desativa
 	AddPeer(interfaceName, publicKeyStr, allowedIPs, endpointStr string, keepAlive int) error
 	
 	// Remove um peer da interface WireGuard
@@ -43,6 +44,11 @@ type VPNPlatform interface {
 	GetInterfaceStatus(interfaceName string) (bool, error)
 }
 
+// KeepaliveOff, passado como keepAlive em AddPeer, desativa o keepalive do peer
+// KeepaliveOff, passed as keepAlive to AddPeer, disables the peer's keepalive
+// KeepaliveOff, pasado como keepAlive a AddPeer, desactiva el keepalive del peer
+const KeepaliveOff = -1
+
 // PlatformFactory é um tipo de função que tenta criar uma implementação VPNPlatform
 type PlatformFactory func() (VPNPlatform, error)
 
@@ -203,6 +203,9 @@ func (p *DarwinPlatform) AddPeer(interfaceName, publicKeyStr, allowedIPs, endpoi
 	if keepAlive > 0 {
 		keepAliveDuration := time.Duration(keepAlive) * time.Second
 		persistentKeepalive = &keepAliveDuration
+	} else if keepAlive == KeepaliveOff {
+		off := time.Duration(0)
+		persistentKeepalive = &off
 	}
 	
 	// Configurar peer
@@ -183,6 +183,9 @@ func (p *LinuxPlatform) AddPeer(interfaceName, publicKeyStr, allowedIPs, endpoin
 	if keepAlive > 0 {
 		keepAliveDuration := time.Duration(keepAlive) * time.Second
 		persistentKeepalive = &keepAliveDuration
+	} else if keepAlive == KeepaliveOff {
+		off := time.Duration(0)
+		persistentKeepalive = &off
 	}
 	
 	// Configurar peer
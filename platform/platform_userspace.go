@@ -234,6 +234,8 @@ func (p *UserspaceWireguardPlatform) AddPeer(interfaceName, publicKeyStr, allowe
 	
 	if keepAlive > 0 {
 		args = append(args, "persistent-keepalive", fmt.Sprintf("%d", keepAlive))
+	} else if keepAlive == KeepaliveOff {
+		args = append(args, "persistent-keepalive", "off")
 	}
 	
 	// Executar comando
@@ -168,12 +168,18 @@ func (p *WindowsPlatform) AddPeer(interfaceName, publicKeyStr, allowedIPs, endpo
 	config := string(configData)
 	
 	// Verificar se o peer já existe
+	currentKeepalive := ""
 	peerSection := fmt.Sprintf("[Peer]\nPublicKey = %s", publicKeyStr)
 	if strings.Contains(config, peerSection) {
-		// Removemos o peer existente para substituí-lo
+		// Removemos o peer existente para substituí-lo, guardando o keepalive atual
 		sections := strings.Split(config, "[Peer]")
 		for i, section := range sections {
 			if strings.Contains(section, fmt.Sprintf("PublicKey = %s", publicKeyStr)) {
+				for _, line := range strings.Split(section, "\n") {
+					if value, found := strings.CutPrefix(strings.TrimSpace(line), "PersistentKeepalive = "); found {
+						currentKeepalive = value
+					}
+				}
 				sections = append(sections[:i], sections[i+1:]...)
 				break
 			}
@@ -188,8 +194,11 @@ func (p *WindowsPlatform) AddPeer(interfaceName, publicKeyStr, allowedIPs, endpo
 		peerConfig += fmt.Sprintf("\nEndpoint = %s", endpointStr)
 	}
 	
+	// Zero mantém o keepalive atual e KeepaliveOff o remove, desativando-o
 	if keepAlive > 0 {
 		peerConfig += fmt.Sprintf("\nPersistentKeepalive = %d", keepAlive)
+	} else if keepAlive == 0 && currentKeepalive != "" {
+		peerConfig += fmt.Sprintf("\nPersistentKeepalive = %s", currentKeepalive)
 	}
 	
 	// Adicionar peer à configuração
@@ -0,0 +1,215 @@
+package netlab_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+	"github.com/p2p-vpn/p2p-vpn/tests/netlab"
+)
+
+// TestMeshConvergesBehindMixedNATs sobe 20 nós na rede virtual — públicos, atrás de NATs full
+// cone, restricted e port-restricted, dois na mesma LAN e dois atrás de NAT duplo sob o mesmo
+// CGNAT — com enlaces com perda e atraso, e verifica que, partindo de um único nó de bootstrap
+// e de um rendezvous, todos se descobrem, cada par tem um caminho escolhido pelas verificações
+// de conectividade e o tráfego WireGuard chega pelo endpoint aplicado nos dois lados
+// TestMeshConvergesBehindMixedNATs starts 20 nodes on the virtual network behind mixed NATs and
+// checks that, from a single bootstrap node and a rendezvous, every node discovers every other,
+// each pair has a checked path and WireGuard traffic arrives through the applied endpoints
+// TestMeshConvergesBehindMixedNATs levanta 20 nodos en la red virtual detrás de NAT mixtos y
+// verifica que, desde un único nodo de bootstrap y un rendezvous, todos se descubren, cada par
+// tiene un camino verificado y el tráfico WireGuard llega por los endpoints aplicados
+func TestMeshConvergesBehindMixedNATs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("malha de 20 nós")
+	}
+
+	network := netlab.New()
+	defer network.Close()
+
+	// Servidor STUN RFC 5780 e rendezvous na rede pública
+	stun := nattraversal.NewSTUNService("198.51.100.1:3478", "198.51.100.2:3479")
+	stun.SetListener(network.Listen)
+	if err := stun.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar STUN: %v", err)
+	}
+	defer stun.Stop()
+	stunServers := []nattraversal.STUNServer{stun.Server()}
+
+	rendezvous, err := discovery.NewRendezvousServer(discovery.DefaultRendezvousPort, nil)
+	if err != nil {
+		t.Fatalf("Falha ao criar rendezvous: %v", err)
+	}
+	rendezvous.SetListener(func(addr *net.UDPAddr) (net.PacketConn, error) {
+		return network.Listen(&net.UDPAddr{IP: net.ParseIP("198.51.100.10"), Port: addr.Port})
+	})
+	if err := rendezvous.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar rendezvous: %v", err)
+	}
+	defer rendezvous.Stop()
+
+	// Topologia: cada host com o seu enlace de acesso. Os roteadores preservam as portas dos
+	// hosts quando livres, como a maioria dos roteadores domésticos.
+	var hosts []*netlab.Host
+	var discoveryPorts []int
+	addNAT := func(natType nattraversal.NATSimulatorType, publicIP, internalNet string) *nattraversal.NATSimulator {
+		nat, err := network.AddNAT(natType, publicIP, internalNet)
+		if err != nil {
+			t.Fatalf("Falha ao criar NAT %s: %v", publicIP, err)
+		}
+		nat.SetPortAllocationStrategy(nattraversal.PortAllocationPreserving)
+		return nat
+	}
+	addHost := func(nat *nattraversal.NATSimulator, discoveryPort int) {
+		host, err := netlab.HostBehind(nat)
+		if err != nil {
+			t.Fatalf("Falha ao criar host: %v", err)
+		}
+		hosts = append(hosts, host)
+		discoveryPorts = append(discoveryPorts, discoveryPort)
+	}
+	for i := 1; i <= 4; i++ {
+		ip := fmt.Sprintf("203.0.113.%d", i)
+		network.SetLink(ip, netlab.Link{Latency: 2 * time.Millisecond})
+		hosts = append(hosts, network.PublicHost(ip))
+		discoveryPorts = append(discoveryPorts, netlab.DiscoveryPort)
+	}
+	natTypes := []nattraversal.NATSimulatorType{
+		nattraversal.SimulateFullCone,
+		nattraversal.SimulateRestrictedCone,
+		nattraversal.SimulatePortRestrictedCone,
+	}
+	for i := 0; i < 12; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i+1)
+		network.SetLink(ip, netlab.Link{Loss: 0.01, Latency: 10 * time.Millisecond, Jitter: 3 * time.Millisecond})
+		addHost(addNAT(natTypes[i%len(natTypes)], ip, fmt.Sprintf("10.%d.0.0/16", i+1)), netlab.DiscoveryPort)
+	}
+	// Dois nós na mesma LAN, que se alcançam pelo hairpinning do roteador
+	shared := addNAT(nattraversal.SimulatePortRestrictedCone, "192.0.2.100", "10.100.0.0/16")
+	addHost(shared, netlab.DiscoveryPort)
+	addHost(shared, netlab.DiscoveryPort+1)
+	// Dois roteadores domésticos atrás do mesmo CGNAT
+	cgnat := addNAT(nattraversal.SimulatePortRestrictedCone, "192.0.2.200", "100.64.0.0/16")
+	network.SetLink("192.0.2.200", netlab.Link{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	for i, natType := range []nattraversal.NATSimulatorType{nattraversal.SimulateFullCone, nattraversal.SimulatePortRestrictedCone} {
+		home, err := network.AddChainedNAT(natType, cgnat, fmt.Sprintf("192.168.%d.0/24", i+1))
+		if err != nil {
+			t.Fatalf("Falha ao criar NAT encadeado: %v", err)
+		}
+		home.SetPortAllocationStrategy(nattraversal.PortAllocationPreserving)
+		addHost(home, netlab.DiscoveryPort+i)
+	}
+
+	// O primeiro nó público é o bootstrap conhecido por todos
+	var nodes []*netlab.Node
+	for i, host := range hosts {
+		node, err := netlab.NewNode(host, fmt.Sprintf("node-%02d", i+1), fmt.Sprintf("10.99.0.%d", i+1))
+		if err != nil {
+			t.Fatalf("Falha ao criar nó: %v", err)
+		}
+		node.DiscoveryPort = discoveryPorts[i]
+		config := node.Config()
+		config.Discovery.RendezvousServers = []string{
+			fmt.Sprintf("%s@198.51.100.10:%d", rendezvous.PublicKey(), discovery.DefaultRendezvousPort),
+		}
+		if i > 0 {
+			bootstrap := nodes[0].Config()
+			signingKey, err := bootstrap.SigningPublicKey()
+			if err != nil {
+				t.Fatalf("Falha ao obter chave de assinatura: %v", err)
+			}
+			config.TrustedPeers = []core.TrustedPeer{{
+				NodeID:     bootstrap.NodeID,
+				PublicKey:  bootstrap.PublicKey,
+				VirtualIP:  bootstrap.VirtualIP,
+				Endpoints:  []string{fmt.Sprintf("203.0.113.1:%d", netlab.DiscoveryPort)},
+				SigningKey: signingKey,
+			}}
+		}
+		if err := node.Start(stunServers); err != nil {
+			t.Fatalf("Falha ao iniciar %s: %v", node.ID, err)
+		}
+		defer node.Stop()
+		nodes = append(nodes, node)
+	}
+
+	// Todos se descobrem e cada par tem um caminho verificado
+	missing := func() []string {
+		var pending []string
+		for _, node := range nodes {
+			for _, other := range nodes {
+				if node == other {
+					continue
+				}
+				link := node.ID + "->" + other.ID
+				if _, selected := node.Traversal.SelectedCandidate(other.ID); !node.VPN.HasPeer(other.PublicKey()) {
+					pending = append(pending, link+" (não descoberto)")
+				} else if !selected {
+					pending = append(pending, link+" (sem par nomeado)")
+				} else if node.VPN.Endpoint(other.ID) == "" {
+					pending = append(pending, link+" (sem endpoint)")
+				}
+			}
+		}
+		return pending
+	}
+	deadline := time.Now().Add(60 * time.Second)
+	for pending := missing(); len(pending) > 0; pending = missing() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Malha não convergiu: %d ligações ausentes, como %v", len(pending), pending[:min(len(pending), 10)])
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	// As rodadas em andamento terminam antes de o teste usar a porta do WireGuard
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+
+	// O WireGuard de cada nó alcança cada peer pelo endpoint aplicado, e o pacote chega do
+	// endpoint que o peer aplicou de volta
+	for _, node := range nodes {
+		for _, other := range nodes {
+			if node == other {
+				continue
+			}
+			if err := checkPath(node, other); err != nil {
+				t.Errorf("%s -> %s: %v", node.ID, other.ID, err)
+			}
+		}
+	}
+}
+
+// checkPath envia um pacote pela porta do WireGuard de from ao endpoint aplicado para to e
+// verifica que ele chega a to vindo do endpoint que to aplicou para from. Perdas nos enlaces
+// são cobertas por novas tentativas.
+func checkPath(from, to *netlab.Node) error {
+	endpoint, err := net.ResolveUDPAddr("udp4", from.VPN.Endpoint(to.ID))
+	if err != nil {
+		return fmt.Errorf("endpoint inválido: %w", err)
+	}
+	payload := "wg " + from.ID + " " + to.ID
+	buffer := make([]byte, 1500)
+
+	for attempt := 0; attempt < 5; attempt++ {
+		from.WG.WriteTo([]byte(payload), endpoint)
+
+		to.WG.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		for {
+			n, src, err := to.WG.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+			if string(buffer[:n]) != payload {
+				continue
+			}
+			if expected := to.VPN.Endpoint(from.ID); src.String() != expected {
+				return fmt.Errorf("pacote chegou de %s, mas o endpoint aplicado é %s", src, expected)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("nenhum pacote chegou por %s", endpoint)
+}
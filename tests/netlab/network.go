@@ -0,0 +1,337 @@
+// Package netlab é um laboratório de rede virtual, em processo, para testes de ponta a ponta:
+// hosts públicos e atrás de NATs simulados, ligados por enlaces com perda e atraso. A
+// descoberta, o STUN e o hole punching rodam sobre ele pelos listeners injetáveis
+// (nattraversal.AddrListener e nattraversal.PacketListener), sem sockets do sistema.
+//
+// Package netlab is an in-process virtual network lab for end-to-end tests.
+// Package netlab es un laboratorio de red virtual, en proceso, para pruebas de extremo a extremo.
+package netlab
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// Link descreve o enlace de um endereço da rede: perda e atraso de cada pacote que passa por ele
+// Link describes the link of a network address: loss and delay of each packet crossing it
+// Link describe el enlace de una dirección de la red: pérdida y retardo de cada paquete
+type Link struct {
+	Loss    float64       // Fração dos pacotes descartados (0 a 1)
+	Latency time.Duration // Atraso de cada pacote
+	Jitter  time.Duration // Variação máxima do atraso, para mais ou para menos
+}
+
+// Network é a rede pública virtual. Um pacote entre dois endereços passa pelo enlace do
+// remetente e pelo do destinatário; pacotes para endereços sem socket são descartados.
+// Network is the virtual public network
+// Network es la red pública virtual
+type Network struct {
+	sockets map[string]*packetConn // Sockets abertos, por endereço
+	links   map[string]Link        // Enlaces configurados, por IP
+	nats    []*nattraversal.NATSimulator
+	mutex   sync.Mutex
+}
+
+// New cria uma rede virtual vazia
+// New creates an empty virtual network
+// New crea una red virtual vacía
+func New() *Network {
+	return &Network{
+		sockets: make(map[string]*packetConn),
+		links:   make(map[string]Link),
+	}
+}
+
+// SetLink define o enlace de um IP da rede
+// SetLink sets the link of a network IP
+// SetLink define el enlace de una IP de la red
+func (n *Network) SetLink(ip string, link Link) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.links[ip] = link
+}
+
+// Listen abre um socket no endereço informado da rede. O IP é obrigatório; porta zero
+// escolhe uma porta livre. Serve como nattraversal.AddrListener para servidores na rede.
+// Listen opens a socket at the given network address
+// Listen abre un socket en la dirección indicada de la red
+func (n *Network) Listen(addr *net.UDPAddr) (net.PacketConn, error) {
+	if addr == nil || addr.IP == nil || addr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("a rede virtual requer um IP específico")
+	}
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	local := &net.UDPAddr{IP: ip, Port: addr.Port}
+	if local.Port == 0 {
+		for attempt := 0; attempt < 64; attempt++ {
+			candidate := &net.UDPAddr{IP: ip, Port: 20000 + rand.Intn(40000)}
+			if _, used := n.sockets[candidate.String()]; !used {
+				local = candidate
+				break
+			}
+		}
+	}
+	if _, used := n.sockets[local.String()]; used || local.Port == 0 {
+		return nil, fmt.Errorf("endereço %s já está em uso", local)
+	}
+
+	conn := &packetConn{
+		network: n,
+		local:   local,
+		inbox:   make(chan packet, 256),
+		closed:  make(chan struct{}),
+	}
+	n.sockets[local.String()] = conn
+	return conn, nil
+}
+
+// AddNAT cria um NAT simulado com o IP público informado na rede. Os NATs criados são
+// encerrados por Close.
+// AddNAT creates a simulated NAT with the given public IP on the network
+// AddNAT crea un NAT simulado con la IP pública indicada en la red
+func (n *Network) AddNAT(natType nattraversal.NATSimulatorType, publicIP, internalNet string) (*nattraversal.NATSimulator, error) {
+	nat, err := nattraversal.NewNATSimulator(natType, publicIP, internalNet)
+	if err != nil {
+		return nil, err
+	}
+	nat.SetExternalNetwork(n.Listen)
+
+	n.mutex.Lock()
+	n.nats = append(n.nats, nat)
+	n.mutex.Unlock()
+	return nat, nil
+}
+
+// AddChainedNAT cria um NAT atrás de outro NAT da rede, encerrado por Close
+// AddChainedNAT creates a NAT behind another NAT of the network
+// AddChainedNAT crea un NAT detrás de otro NAT de la red
+func (n *Network) AddChainedNAT(natType nattraversal.NATSimulatorType, upstream *nattraversal.NATSimulator, internalNet string) (*nattraversal.NATSimulator, error) {
+	nat, err := nattraversal.NewChainedNATSimulator(natType, upstream, internalNet)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mutex.Lock()
+	n.nats = append(n.nats, nat)
+	n.mutex.Unlock()
+	return nat, nil
+}
+
+// Close encerra os NATs e fecha os sockets da rede
+// Close stops the NATs and closes the network sockets
+// Close detiene los NAT y cierra los sockets de la red
+func (n *Network) Close() {
+	n.mutex.Lock()
+	nats := n.nats
+	n.nats = nil
+	sockets := make([]*packetConn, 0, len(n.sockets))
+	for _, conn := range n.sockets {
+		sockets = append(sockets, conn)
+	}
+	n.mutex.Unlock()
+
+	// Os NATs de dentro primeiro, porque os mapeamentos deles são sockets dos de fora
+	for i := len(nats) - 1; i >= 0; i-- {
+		nats[i].Stop()
+	}
+	for _, conn := range sockets {
+		conn.Close()
+	}
+}
+
+// send entrega um pacote ao socket do destino, com a perda e o atraso dos dois enlaces
+func (n *Network) send(from *net.UDPAddr, data []byte, to *net.UDPAddr) {
+	n.mutex.Lock()
+	source, target := n.links[from.IP.String()], n.links[to.IP.String()]
+	n.mutex.Unlock()
+
+	loss := 1 - (1-source.Loss)*(1-target.Loss)
+	if loss > 0 && rand.Float64() < loss {
+		return
+	}
+	delay := source.Latency + target.Latency
+	for _, jitter := range []time.Duration{source.Jitter, target.Jitter} {
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter
+		}
+	}
+
+	packet := packet{data: append([]byte(nil), data...), from: from}
+	deliver := func() {
+		n.mutex.Lock()
+		conn := n.sockets[to.String()]
+		n.mutex.Unlock()
+		if conn != nil {
+			conn.deliver(packet)
+		}
+	}
+	if delay <= 0 {
+		deliver()
+		return
+	}
+	time.AfterFunc(delay, deliver)
+}
+
+// packet é um pacote entregue a um socket da rede
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// packetConn é um socket da rede virtual
+type packetConn struct {
+	network *Network
+	local   *net.UDPAddr
+	inbox   chan packet
+
+	readDeadline time.Time
+	mutex        sync.Mutex
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// deliver coloca um pacote na fila do socket, descartando-o se a fila estiver cheia
+func (c *packetConn) deliver(p packet) {
+	select {
+	case c.inbox <- p:
+	default:
+	}
+}
+
+// ReadFrom lê o próximo pacote. O prazo de leitura vale a partir da chamada.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	deadline := c.readDeadline
+	c.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-c.inbox:
+		return copy(b, p.data), p.from, nil
+	case <-timeout:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.local, Err: os.ErrDeadlineExceeded}
+	case <-c.closed:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.local, Err: net.ErrClosed}
+	}
+}
+
+// WriteTo envia um pacote pela rede virtual
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: c.local, Err: net.ErrClosed}
+	default:
+	}
+
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		var err error
+		if to, err = net.ResolveUDPAddr("udp", addr.String()); err != nil {
+			return 0, err
+		}
+	}
+	if ip4 := to.IP.To4(); ip4 != nil {
+		to = &net.UDPAddr{IP: ip4, Port: to.Port}
+	}
+	c.network.send(c.local, b, to)
+	return len(b), nil
+}
+
+// Close fecha o socket e libera o endereço
+func (c *packetConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.network.mutex.Lock()
+		if c.network.sockets[c.local.String()] == c {
+			delete(c.network.sockets, c.local.String())
+		}
+		c.network.mutex.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr retorna o endereço do socket na rede
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// SetDeadline define o prazo de leitura; escritas nunca bloqueiam
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline define o prazo da próxima leitura
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline não tem efeito, pois as escritas nunca bloqueiam
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Host é uma máquina da rede, pública ou atrás de um NAT, que abre sockets pelos listeners
+// Host is a network machine, public or behind a NAT, that opens sockets through the listeners
+// Host es una máquina de la red, pública o detrás de un NAT, que abre sockets por los listeners
+type Host struct {
+	listen nattraversal.AddrListener
+}
+
+// PublicHost cria um host com o IP público informado na rede
+// PublicHost creates a host with the given public IP on the network
+// PublicHost crea un host con la IP pública indicada en la red
+func (n *Network) PublicHost(ip string) *Host {
+	hostIP := net.ParseIP(ip)
+	return &Host{listen: func(addr *net.UDPAddr) (net.PacketConn, error) {
+		return n.Listen(&net.UDPAddr{IP: hostIP, Port: addr.Port})
+	}}
+}
+
+// HostBehind cria um host atrás do NAT informado, com um endereço próprio da rede interna.
+// Os sockets do host usam as portas pedidas, como numa máquina real.
+// HostBehind creates a host behind the given NAT
+// HostBehind crea un host detrás del NAT indicado
+func HostBehind(nat *nattraversal.NATSimulator) (*Host, error) {
+	ip, err := nat.AddHost()
+	if err != nil {
+		return nil, err
+	}
+	return &Host{listen: func(addr *net.UDPAddr) (net.PacketConn, error) {
+		return nat.ListenPacketAt(&net.UDPAddr{IP: ip, Port: addr.Port})
+	}}, nil
+}
+
+// Listen abre um socket no host; serve como nattraversal.AddrListener
+func (h *Host) Listen(addr *net.UDPAddr) (net.PacketConn, error) {
+	if addr == nil {
+		addr = &net.UDPAddr{}
+	}
+	return h.listen(addr)
+}
+
+// ListenPacket abre um socket em uma porta livre do host; serve como nattraversal.PacketListener
+func (h *Host) ListenPacket() (net.PacketConn, error) {
+	return h.listen(&net.UDPAddr{})
+}
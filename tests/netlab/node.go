@@ -0,0 +1,235 @@
+package netlab
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// DiscoveryPort é a porta de descoberta padrão dos nós do laboratório
+	DiscoveryPort = 51821
+
+	// AnnounceInterval é o intervalo dos anúncios e dos resumos de peers dos nós, menor que
+	// o padrão para que a malha convirja em segundos
+	AnnounceInterval = 5 * time.Second
+)
+
+// VPN implementa core.VPNProvider sem interfaces de rede, registrando os peers configurados e
+// os endpoints aplicados. A configuração é trocada a cada alteração, para que quem a leu
+// continue com uma cópia consistente.
+// VPN implements core.VPNProvider without network interfaces
+// VPN implementa core.VPNProvider sin interfaces de red
+type VPN struct {
+	config     *core.Config
+	endpoints  map[string]string // Endpoints aplicados por UpdatePeerEndpoint
+	keepalives map[string]int    // Keepalives, em segundos, aplicados por UpdatePeerKeepalive
+	mutex      sync.Mutex
+}
+
+func (v *VPN) Start() error    { return nil }
+func (v *VPN) Stop() error     { return nil }
+func (v *VPN) IsRunning() bool { return true }
+
+func (v *VPN) AddPeer(peer core.TrustedPeer) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	config := v.copyConfig()
+	config.AddTrustedPeer(peer)
+	v.config = config
+	return nil
+}
+
+func (v *VPN) RemovePeer(nodeID string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	config := v.copyConfig()
+	config.RemoveTrustedPeer(nodeID)
+	v.config = config
+	return nil
+}
+
+// copyConfig copia a configuração com uma lista de peers própria. Deve ser chamada com mutex travado.
+func (v *VPN) copyConfig() *core.Config {
+	config := *v.config
+	config.TrustedPeers = append([]core.TrustedPeer(nil), v.config.TrustedPeers...)
+	return &config
+}
+
+func (v *VPN) UpdatePeerEndpoint(nodeID string, endpoint string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.endpoints[nodeID] = endpoint
+	return nil
+}
+
+func (v *VPN) UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.keepalives[nodeID] = core.KeepaliveInterval(behindNAT, mappingLifetime)
+	return nil
+}
+
+func (v *VPN) GetConfig() *core.Config {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.config
+}
+
+func (v *VPN) TrustedPeers() []core.TrustedPeer {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.config.CopyTrustedPeers()
+}
+
+func (v *VPN) SaveConfig(path string) error { return nil }
+
+func (v *VPN) GetNodeInfo() (string, string, string) {
+	config := v.GetConfig()
+	return config.NodeID, config.PublicKey, config.VirtualIP
+}
+
+// Endpoint retorna o último endpoint aplicado ao peer
+func (v *VPN) Endpoint(nodeID string) string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.endpoints[nodeID]
+}
+
+// Keepalive retorna o último keepalive aplicado ao peer e se algum foi aplicado
+func (v *VPN) Keepalive(nodeID string) (int, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	interval, ok := v.keepalives[nodeID]
+	return interval, ok
+}
+
+// HasPeer verifica se o peer com a chave informada foi configurado a partir de um anúncio
+func (v *VPN) HasPeer(publicKey string) bool {
+	for _, peer := range v.TrustedPeers() {
+		if peer.PublicKey == publicKey && peer.LastSeen != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Node é um nó completo da VPN no laboratório: descoberta e NAT traversal sobre os sockets
+// do host, com um socket que faz o papel da porta do WireGuard
+// Node is a complete VPN node in the lab
+// Node es un nodo completo de la VPN en el laboratorio
+type Node struct {
+	ID            string
+	Host          *Host
+	DiscoveryPort int // Porta de descoberta; pode ser trocada antes de Start
+	VPN           *VPN
+	Traversal     *nattraversal.NATTraversal
+	Discovery     *discovery.PeerDiscovery
+	WG            net.PacketConn
+}
+
+// NewNode cria um nó no host informado, com chaves novas. A configuração (peers confiáveis,
+// servidores de rendezvous) pode ser ajustada por Config antes de Start. Nós atrás do mesmo
+// NAT precisam de portas de descoberta diferentes, como máquinas reais da mesma LAN.
+// NewNode creates a node on the given host, with new keys
+// NewNode crea un nodo en el host indicado, con claves nuevas
+func NewNode(host *Host, nodeID, virtualIP string) (*Node, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gerar chave: %w", err)
+	}
+	publicKey := key.PublicKey()
+
+	return &Node{
+		ID:            nodeID,
+		Host:          host,
+		DiscoveryPort: DiscoveryPort,
+		VPN: &VPN{
+			config: &core.Config{
+				NodeID:     nodeID,
+				PrivateKey: base64.StdEncoding.EncodeToString(key[:]),
+				PublicKey:  base64.StdEncoding.EncodeToString(publicKey[:]),
+				VirtualIP:  virtualIP,
+			},
+			endpoints:  make(map[string]string),
+			keepalives: make(map[string]int),
+		},
+	}, nil
+}
+
+// Config retorna a configuração do nó
+func (n *Node) Config() *core.Config {
+	return n.VPN.GetConfig()
+}
+
+// PublicKey retorna a chave pública WireGuard do nó
+func (n *Node) PublicKey() string {
+	return n.Config().PublicKey
+}
+
+// Start abre a porta do WireGuard, detecta o NAT pelos servidores STUN informados e inicia a
+// descoberta, que combina com os peers as verificações de conectividade
+// Start opens the WireGuard port, detects the NAT and starts discovery
+// Start abre el puerto WireGuard, detecta el NAT e inicia el descubrimiento
+func (n *Node) Start(stunServers []nattraversal.STUNServer) error {
+	wg, err := n.Host.ListenPacket()
+	if err != nil {
+		return fmt.Errorf("erro ao abrir a porta do WireGuard: %w", err)
+	}
+	n.WG = wg
+	wgPort := wg.LocalAddr().(*net.UDPAddr).Port
+
+	n.Traversal = nattraversal.NewNATTraversal(wgPort)
+	n.Traversal.SetPortMappers()
+	n.Traversal.SetSTUNServers(stunServers)
+	n.Traversal.SetSTUNRetransmission(50*time.Millisecond, 4)
+	n.Traversal.SetPacketListener(n.Host.ListenPacket)
+	n.Traversal.SetPunchConn(wg)
+	n.Traversal.SetEndpointUpdater(n.VPN)
+	n.Traversal.SetKeepaliveUpdater(n.VPN)
+	if err := n.Traversal.SetRelayKey(n.Config().PrivateKey); err != nil {
+		wg.Close()
+		return fmt.Errorf("chave do nó recusada: %w", err)
+	}
+
+	n.Discovery, err = discovery.NewPeerDiscovery(n.Config(), n.DiscoveryPort, n.VPN)
+	if err != nil {
+		wg.Close()
+		return fmt.Errorf("erro ao criar descoberta: %w", err)
+	}
+	n.Discovery.SetListener(n.Host.Listen)
+	n.Discovery.SetAnnounceInterval(AnnounceInterval)
+	n.Discovery.SetWireGuardPort(wgPort)
+	n.Discovery.SetNATTraversal(n.Traversal)
+
+	if err := n.Traversal.Start(); err != nil {
+		wg.Close()
+		return fmt.Errorf("erro ao iniciar NAT traversal: %w", err)
+	}
+	if err := n.Discovery.Start(); err != nil {
+		n.Traversal.Stop()
+		wg.Close()
+		return fmt.Errorf("erro ao iniciar descoberta: %w", err)
+	}
+	return nil
+}
+
+// Stop encerra a descoberta, o NAT traversal e a porta do WireGuard
+func (n *Node) Stop() {
+	if n.Discovery != nil {
+		n.Discovery.Stop()
+	}
+	if n.Traversal != nil {
+		n.Traversal.Stop()
+	}
+	if n.WG != nil {
+		n.WG.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package unit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// fakeBackend entrega anúncios prontos pelo canal de resultados
+type fakeBackend struct {
+	results        chan discovery.DiscoveryResult
+	announced      chan struct{}
+	networkChanges chan struct{}
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		results:        make(chan discovery.DiscoveryResult, 4),
+		announced:      make(chan struct{}, 4),
+		networkChanges: make(chan struct{}, 4),
+	}
+}
+
+func (f *fakeBackend) Name() string                              { return "fake" }
+func (f *fakeBackend) Start() error                              { return nil }
+func (f *fakeBackend) Stop() error                               { return nil }
+func (f *fakeBackend) Results() <-chan discovery.DiscoveryResult { return f.results }
+func (f *fakeBackend) Announce() error                           { f.announced <- struct{}{}; return nil }
+
+// HandleNetworkChange registra o aviso de mudança de rede, como o backend multicast
+func (f *fakeBackend) HandleNetworkChange() {
+	select {
+	case f.networkChanges <- struct{}{}:
+	default:
+	}
+}
+
+// TestEnabledBackends verifica a seleção de backends pela configuração
+// TestEnabledBackends checks backend selection from the configuration
+// TestEnabledBackends verifica la selección de backends por la configuración
+func TestEnabledBackends(t *testing.T) {
+	names, err := discovery.EnabledBackends(core.DiscoveryConfig{})
+	if err != nil || len(names) != 1 || names[0] != discovery.BackendStatic {
+		t.Errorf("Padrão deveria ser apenas static, obtido: %v (%v)", names, err)
+	}
+
+	names, _ = discovery.EnabledBackends(core.DiscoveryConfig{
+		Backends:          []string{"dht"},
+		RendezvousServers: []string{"127.0.0.1:51823"},
+	})
+	expected := []string{discovery.BackendStatic, discovery.BackendDHT, discovery.BackendRendezvous}
+	if len(names) != len(expected) {
+		t.Fatalf("Esperado %v, obtido: %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Esperado %v, obtido: %v", expected, names)
+		}
+	}
+
+	if _, err := discovery.EnabledBackends(core.DiscoveryConfig{Backends: []string{"carrier-pigeon"}}); err == nil {
+		t.Error("Backend desconhecido deveria ser recusado")
+	}
+}
+
+// TestBackendResultsMerged verifica que os peers de vários backends formam uma única visão com a origem de cada um
+// TestBackendResultsMerged checks that peers from several backends form a single view with their sources
+// TestBackendResultsMerged verifica que los pares de varios backends forman una única vista con su origen
+func TestBackendResultsMerged(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+
+	backend := newFakeBackend()
+	if err := service.AddBackend(backend); err != nil {
+		t.Fatalf("Falha ao adicionar backend: %v", err)
+	}
+	if err := service.AddBackend(newFakeBackend()); err == nil {
+		t.Error("Backend duplicado deveria ser recusado")
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	active := service.Backends()
+	if len(active) != 2 || active[0] != discovery.BackendStatic || active[1] != "fake" {
+		t.Errorf("Backends ativos incorretos: %v", active)
+	}
+
+	service.Announce()
+	select {
+	case <-backend.announced:
+	case <-time.After(time.Second):
+		t.Error("Announce não chegou ao backend")
+	}
+
+	// O mesmo amigo chega pelo backend externo e por um anúncio direto no socket
+	key, _ := friend.config.SigningKey()
+	data, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:        friend.config.NodeID,
+		PublicKey:     friend.config.PublicKey,
+		VirtualIP:     friend.config.VirtualIP,
+		ListenPort:    51820,
+		DiscoveryPort: 40000,
+	}, key)
+	msg, _ := discovery.DecodeMessage(data)
+	backend.results <- discovery.DiscoveryResult{Message: msg, Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}
+
+	announceTo(t, friend, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+
+	var peers []discovery.PeerInfo
+	merged := waitFor(2*time.Second, func() bool {
+		peers = service.Peers()
+		return len(peers) == 1 && len(peers[0].Sources) == 2
+	})
+	if !merged {
+		t.Fatalf("Peer não consolidado a partir dos dois backends: %+v", peers)
+	}
+	if peers[0].NodeID != "friend" || !host.hasPeer(friend.config.PublicKey) {
+		t.Errorf("Peer incorreto ou não configurado: %+v", peers[0])
+	}
+
+	sources := map[string]bool{}
+	for _, source := range peers[0].Sources {
+		sources[source] = true
+	}
+	if !sources["fake"] || !sources[discovery.BackendStatic] {
+		t.Errorf("Origens incorretas: %v", peers[0].Sources)
+	}
+}
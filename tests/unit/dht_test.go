@@ -0,0 +1,179 @@
+package unit_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// TestDHTKeyDistance verifica o cálculo dos identificadores a partir da chave WireGuard
+// TestDHTKeyDistance checks identifier derivation from the WireGuard key
+// TestDHTKeyDistance verifica el cálculo de identificadores a partir de la clave WireGuard
+func TestDHTKeyDistance(t *testing.T) {
+	a, err := discovery.DHTKey("lxaBB1/7huHXOgC4PN2J8tTey4mCL+NvgfnSyL4SGQI=")
+	if err != nil {
+		t.Fatalf("Falha ao calcular ID: %v", err)
+	}
+	b, _ := discovery.DHTKey("lxaBB1/7huHXOgC4PN2J8tTey4mCL+NvgfnSyL4SGQI=")
+	if a != b {
+		t.Error("A mesma chave deve gerar o mesmo ID")
+	}
+
+	if _, err := discovery.DHTKey("não-é-base64"); err == nil {
+		t.Error("Chave inválida deveria ser rejeitada")
+	}
+}
+
+// TestDHTRejectsForeignRecord verifica se um nó não consegue publicar o registro de outro
+// TestDHTRejectsForeignRecord checks that a node cannot publish another node's record
+// TestDHTRejectsForeignRecord verifica que un nodo no puede publicar el registro de otro
+func TestDHTRejectsForeignRecord(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	wgKey, _ := wgtypes.GeneratePrivateKey()
+
+	dht, err := discovery.NewDHT(wgKey.String(), key, nil)
+	if err != nil {
+		t.Fatalf("Falha ao criar DHT: %v", err)
+	}
+
+	record, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:    "node-b",
+		PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		VirtualIP: "10.0.0.2",
+	}, key)
+
+	if _, err := dht.Publish(record); err == nil {
+		t.Error("Registro de outra chave WireGuard deveria ser recusado")
+	}
+}
+
+// TestDHTRejectsSquattedRecord verifica se um nó sem a chave privada WireGuard não ocupa o
+// registro de outro no DHT, mesmo publicando antes do dono
+// TestDHTRejectsSquattedRecord checks that a node without the WireGuard private key cannot squat
+// another node's DHT record, even when publishing before the owner
+// TestDHTRejectsSquattedRecord verifica que un nodo sin la clave privada WireGuard no ocupa el
+// registro de otro en el DHT, aunque publique antes que el dueño
+func TestDHTRejectsSquattedRecord(t *testing.T) {
+	storerKey, _ := wgtypes.GeneratePrivateKey()
+	ownerKey, _ := wgtypes.GeneratePrivateKey()
+	_, storerSigning, _ := ed25519.GenerateKey(rand.Reader)
+	_, ownerSigning, _ := ed25519.GenerateKey(rand.Reader)
+	_, attackerSigning, _ := ed25519.GenerateKey(rand.Reader)
+
+	storerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	ownerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	// Os dois DHTs trocam mensagens diretamente, sem sockets
+	var storer, owner *discovery.DHT
+	deliver := func(to **discovery.DHT, from *net.UDPAddr) func([]byte, *net.UDPAddr) error {
+		return func(data []byte, addr *net.UDPAddr) error {
+			msg, err := discovery.DecodeMessage(data)
+			if err != nil {
+				return err
+			}
+			go (*to).HandleMessage(msg, from)
+			return nil
+		}
+	}
+	storer, _ = discovery.NewDHT(storerKey.String(), storerSigning, deliver(&owner, storerAddr))
+	owner, _ = discovery.NewDHT(ownerKey.String(), ownerSigning, deliver(&storer, ownerAddr))
+
+	ownerPublic := ownerKey.PublicKey().String()
+	announcement := discovery.Announcement{NodeID: "node-a", PublicKey: ownerPublic, VirtualIP: "10.0.0.1"}
+
+	// O atacante publica primeiro um anúncio com a chave do dono, sem poder provar a posse
+	forged, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, announcement, attackerSigning)
+	store, _ := discovery.EncodeMessage(discovery.MsgDHTStore, discovery.DHTMessage{
+		NodeKey: ownerPublic,
+		Record:  forged,
+		Proof:   make([]byte, 32),
+	}, attackerSigning)
+	msg, _ := discovery.DecodeMessage(store)
+	storer.HandleMessage(msg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3})
+
+	if _, _, err := storer.Lookup(ownerPublic); err == nil {
+		t.Fatal("Registro sem prova de posse da chave WireGuard foi armazenado")
+	}
+
+	// O dono continua conseguindo publicar o próprio registro
+	owner.AddContact(storerKey.PublicKey().String(), storerAddr)
+	record, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, announcement, ownerSigning)
+	if stored, err := owner.Publish(record); err != nil || stored == 0 {
+		t.Fatalf("Falha ao publicar registro do dono: %d nós (%v)", stored, err)
+	}
+
+	if !waitFor(2*time.Second, func() bool {
+		found, _, err := storer.Lookup(ownerPublic)
+		return err == nil && found.SignerKey.Equal(ownerSigning.Public())
+	}) {
+		t.Error("Registro do dono deveria ser armazenado")
+	}
+}
+
+// TestDHTLookupAcrossNodes verifica publicação e busca entre vários nós no loopback
+// TestDHTLookupAcrossNodes checks publishing and lookup across many loopback nodes
+// TestDHTLookupAcrossNodes verifica publicación y búsqueda entre varios nodos en loopback
+func TestDHTLookupAcrossNodes(t *testing.T) {
+	const nodeCount = 24
+
+	var nodes []*fakeVPN
+	var services []*discovery.PeerDiscovery
+	bootstrap := ""
+
+	for i := 0; i < nodeCount; i++ {
+		node := newFakeVPN(t, fmt.Sprintf("node-%d", i), fmt.Sprintf("10.1.0.%d", i+1))
+		node.config.Discovery.DHT = true
+		if bootstrap != "" {
+			node.config.Discovery.DHTBootstrap = []string{bootstrap}
+		}
+
+		port := freeUDPPort(t)
+		if bootstrap == "" {
+			bootstrap = fmt.Sprintf("127.0.0.1:%d", port)
+		}
+
+		service, err := discovery.NewPeerDiscovery(node.config, port, node)
+		if err != nil {
+			t.Fatalf("Falha ao criar descoberta: %v", err)
+		}
+		if err := service.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar descoberta: %v", err)
+		}
+		defer service.Stop()
+
+		nodes = append(nodes, node)
+		services = append(services, service)
+	}
+
+	// Aguardar o bootstrap inicial e republicar com as tabelas já preenchidas
+	time.Sleep(500 * time.Millisecond)
+	for i, service := range services {
+		if err := service.PublishToDHT(); err != nil {
+			t.Fatalf("Falha ao publicar registro do nó %d: %v", i, err)
+		}
+	}
+
+	for i := 0; i < nodeCount; i++ {
+		target := nodes[(i*7+3)%nodeCount]
+		if target == nodes[i] {
+			continue
+		}
+
+		info, err := services[i].LookupPeer(target.config.PublicKey)
+		if err != nil {
+			t.Fatalf("Nó %d não encontrou %s: %v", i, target.config.NodeID, err)
+		}
+		if info.NodeID != target.config.NodeID || info.VirtualIP != target.config.VirtualIP {
+			t.Errorf("Registro incorreto para %s: %+v", target.config.NodeID, info)
+		}
+		if info.DiscoveryAddr == "" {
+			t.Errorf("Registro de %s sem endereço de descoberta", target.config.NodeID)
+		}
+	}
+}
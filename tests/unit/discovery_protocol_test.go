@@ -0,0 +1,176 @@
+package unit_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// TestAnnouncementRoundTrip verifica se um anúncio assinado é decodificado intacto
+// TestAnnouncementRoundTrip checks that a signed announcement decodes intact
+// TestAnnouncementRoundTrip verifica que un anuncio firmado se decodifica intacto
+func TestAnnouncementRoundTrip(t *testing.T) {
+	config := &core.Config{PrivateKey: "2BJtcgPUOMHmHQ4hKMYfwEQhW5Y9XYJHW0C1vQCravU="}
+	key, err := config.SigningKey()
+	if err != nil {
+		t.Fatalf("Falha ao derivar chave de assinatura: %v", err)
+	}
+
+	announcement := discovery.Announcement{
+		NodeID:       "node-a",
+		PublicKey:    "lxaBB1/7huHXOgC4PN2J8tTey4mCL+NvgfnSyL4SGQI=",
+		VirtualIP:    "10.0.0.1",
+		ListenPort:   51820,
+		Endpoints:    []string{"192.168.1.10:51820"},
+		Capabilities: discovery.CapabilityIPv4,
+	}
+
+	data, err := discovery.EncodeMessage(discovery.MsgAnnouncement, announcement, key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar anúncio: %v", err)
+	}
+
+	msg, err := discovery.DecodeMessage(data)
+	if err != nil {
+		t.Fatalf("Falha ao decodificar anúncio: %v", err)
+	}
+
+	if msg.Type != discovery.MsgAnnouncement {
+		t.Errorf("Tipo inesperado: %s", msg.Type)
+	}
+
+	signer, _ := config.SigningPublicKey()
+	if msg.Signer() != signer {
+		t.Errorf("Assinante inesperado: %s", msg.Signer())
+	}
+
+	var decoded discovery.Announcement
+	if err := msg.Decode(&decoded); err != nil {
+		t.Fatalf("Falha ao decodificar payload: %v", err)
+	}
+
+	if decoded.NodeID != announcement.NodeID || decoded.ListenPort != announcement.ListenPort ||
+		len(decoded.Endpoints) != 1 || !decoded.Capabilities.Has(discovery.CapabilityIPv4) {
+		t.Errorf("Anúncio decodificado difere do original: %+v", decoded)
+	}
+}
+
+// TestTamperedMessageRejected verifica se qualquer alteração invalida a assinatura
+// TestTamperedMessageRejected checks that any modification invalidates the signature
+// TestTamperedMessageRejected verifica que cualquier alteración invalida la firma
+func TestTamperedMessageRejected(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	data, err := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{NodeID: "node-a"}, key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar anúncio: %v", err)
+	}
+
+	// Alterar um byte do payload
+	tampered := append([]byte(nil), data...)
+	tampered[40] ^= 0xFF
+
+	if _, err := discovery.DecodeMessage(tampered); err == nil {
+		t.Error("Mensagem adulterada deveria ser rejeitada")
+	}
+
+	// Substituir a chave do remetente por outra
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	spoofed := append([]byte(nil), data...)
+	copy(spoofed[len(spoofed)-ed25519.SignatureSize-ed25519.PublicKeySize:], otherKey.Public().(ed25519.PublicKey))
+
+	if _, err := discovery.DecodeMessage(spoofed); !errors.Is(err, discovery.ErrBadSignature) {
+		t.Errorf("Esperado ErrBadSignature para chave trocada, obtido: %v", err)
+	}
+}
+
+// TestReplayGuard verifica a rejeição de mensagens repetidas
+// TestReplayGuard checks that replayed messages are rejected
+// TestReplayGuard verifica el rechazo de mensajes repetidos
+func TestReplayGuard(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	data, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{NodeID: "node-a"}, key)
+	msg, err := discovery.DecodeMessage(data)
+	if err != nil {
+		t.Fatalf("Falha ao decodificar anúncio: %v", err)
+	}
+
+	guard := discovery.NewReplayGuard(time.Minute)
+	if err := guard.Check(msg); err != nil {
+		t.Fatalf("Primeira mensagem deveria ser aceita: %v", err)
+	}
+	if err := guard.Check(msg); !errors.Is(err, discovery.ErrReplayedMessage) {
+		t.Errorf("Esperado ErrReplayedMessage, obtido: %v", err)
+	}
+
+	msg.Timestamp = time.Now().Add(-time.Hour)
+	msg.Nonce[0] ^= 0xFF
+	if err := guard.Check(msg); !errors.Is(err, discovery.ErrStaleMessage) {
+		t.Errorf("Esperado ErrStaleMessage, obtido: %v", err)
+	}
+}
+
+// TestTrustedPeerSigningKeyPinned verifica que os anúncios de um peer confiável só são aceitos
+// com a chave de assinatura fixada na configuração: quem anuncia primeiro com a chave
+// WireGuard ou o ID do peer não toma o lugar dele, e peers sem chave fixada são recusados
+// TestTrustedPeerSigningKeyPinned checks that a trusted peer's announcements are only accepted
+// with the signing key pinned in the configuration: announcing first with the peer's WireGuard
+// key or ID does not take its place, and peers without a pinned key are refused
+// TestTrustedPeerSigningKeyPinned verifica que los anuncios de un par confiable solo se aceptan
+// con la clave de firma fijada en la configuración: anunciar primero con su clave WireGuard o
+// su ID no ocupa su lugar, y los pares sin clave fijada se rechazan
+func TestTrustedPeerSigningKeyPinned(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	victim := newFakeVPN(t, "victim", "10.0.0.2")
+	unpinned := newFakeVPN(t, "unpinned", "10.0.0.3")
+
+	host.config.AddTrustedPeer(victim.trustedPeer())
+	legacy := unpinned.trustedPeer()
+	legacy.SigningKey = ""
+	host.config.AddTrustedPeer(legacy)
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	// O impostor anuncia antes do peer verdadeiro, com a chave WireGuard e o ID dele
+	_, attackerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Falha ao gerar chave: %v", err)
+	}
+	announceSigned(t, victim, attackerKey, hostAddr)
+	announceTo(t, unpinned, hostAddr)
+
+	time.Sleep(200 * time.Millisecond)
+	if peers := service.Peers(); len(peers) != 0 {
+		t.Fatalf("Anúncios sem a chave fixada deveriam ser recusados, aceitos: %+v", peers)
+	}
+	for _, peer := range host.TrustedPeers() {
+		if peer.NodeID == "victim" && (peer.SigningKey != victim.trustedPeer().SigningKey || len(peer.Endpoints) > 0) {
+			t.Fatalf("Peer confiável alterado pelo impostor: %+v", peer)
+		}
+	}
+
+	// O peer verdadeiro continua aceito
+	announceTo(t, victim, hostAddr)
+	if !waitFor(2*time.Second, func() bool { return len(service.Peers()) == 1 }) {
+		t.Fatal("Anúncio do peer com a chave fixada não foi aceito")
+	}
+	if peer := service.Peers()[0]; peer.NodeID != "victim" {
+		t.Errorf("Peer aceito inesperado: %s", peer.NodeID)
+	}
+}
@@ -0,0 +1,186 @@
+package unit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// savedNodeIDs lê os IDs dos nós gravados no arquivo de estado
+func savedNodeIDs(t *testing.T, path string) map[string]bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Arquivo de estado não foi gravado: %v", err)
+	}
+
+	var state struct {
+		Nodes []struct {
+			NodeID   string    `json:"nodeId"`
+			LastSeen time.Time `json:"lastSeen"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Arquivo de estado inválido: %v", err)
+	}
+
+	ids := make(map[string]bool)
+	for _, node := range state.Nodes {
+		ids[node.NodeID] = true
+	}
+	return ids
+}
+
+// savedSources lê os backends que encontraram cada nó gravado no arquivo de estado
+func savedSources(t *testing.T, path string) map[string][]string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Arquivo de estado não foi gravado: %v", err)
+	}
+
+	var state struct {
+		Nodes []struct {
+			NodeID  string   `json:"nodeId"`
+			Sources []string `json:"sources"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Arquivo de estado inválido: %v", err)
+	}
+
+	sources := make(map[string][]string)
+	for _, node := range state.Nodes {
+		sources[node.NodeID] = node.Sources
+	}
+	return sources
+}
+
+// hasSource verifica se o backend está entre as origens do nó
+func hasSource(sources []string, backend string) bool {
+	for _, source := range sources {
+		if source == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// TestDiscoveryStateSurvivesRestart verifica se os nós conhecidos são restaurados e contatados após reiniciar
+// TestDiscoveryStateSurvivesRestart checks that known nodes are restored and contacted after a restart
+// TestDiscoveryStateSurvivesRestart verifica que los nodos conocidos se restauran y contactan tras reiniciar
+func TestDiscoveryStateSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	statePath := discovery.DiscoveryStatePath(configPath)
+
+	node := newFakeVPN(t, "node", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+
+	// O amigo escuta em um socket próprio, que também é seu endereço de descoberta
+	friendConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer friendConn.Close()
+
+	port := freeUDPPort(t)
+	first, err := discovery.NewPeerDiscovery(node.config, port, node)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	first.SetConfigPath(configPath)
+	if err := first.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+
+	friendKey, _ := friend.config.SigningKey()
+	data, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:     friend.config.NodeID,
+		PublicKey:  friend.config.PublicKey,
+		VirtualIP:  friend.config.VirtualIP,
+		ListenPort: 51820,
+	}, friendKey)
+	friendConn.WriteToUDP(data, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+
+	if !waitFor(2*time.Second, func() bool { return node.hasPeer(friend.config.PublicKey) }) {
+		t.Fatal("Nó não aprendeu o amigo")
+	}
+	first.Stop()
+
+	if !savedNodeIDs(t, statePath)["friend"] {
+		t.Fatal("Amigo não foi salvo no estado")
+	}
+	if sources := savedSources(t, statePath)["friend"]; !hasSource(sources, discovery.BackendStatic) {
+		t.Fatalf("Origens do amigo não foram salvas no estado: %v", sources)
+	}
+
+	// Acrescentar um nó inativo há mais tempo que o limite de limpeza
+	raw, _ := ioutil.ReadFile(statePath)
+	var state map[string]interface{}
+	json.Unmarshal(raw, &state)
+	nodes := state["nodes"].([]interface{})
+	stale := map[string]interface{}{}
+	for k, v := range nodes[0].(map[string]interface{}) {
+		stale[k] = v
+	}
+	stale["nodeId"] = "stale"
+	stale["publicKey"] = newFakeVPN(t, "stale", "10.0.0.9").config.PublicKey
+	stale["lastSeen"] = time.Now().Add(-discovery.NodeStaleAfter - time.Hour)
+	state["nodes"] = append(nodes, stale)
+	raw, _ = json.Marshal(state)
+	ioutil.WriteFile(statePath, raw, 0600)
+
+	// Ao reiniciar, o nó deve anunciar ao amigo sem esperar o ciclo de 30s
+	drain := make([]byte, discovery.MaxMessageSize)
+	for {
+		friendConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, _, err := friendConn.ReadFromUDP(drain); err != nil {
+			break
+		}
+	}
+
+	second, err := discovery.NewPeerDiscovery(node.config, port, node)
+	if err != nil {
+		t.Fatalf("Falha ao recriar descoberta: %v", err)
+	}
+	second.SetConfigPath(configPath)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Falha ao reiniciar descoberta: %v", err)
+	}
+	defer second.Stop()
+
+	for _, peer := range second.Peers() {
+		if peer.NodeID == "friend" && !hasSource(peer.Sources, discovery.BackendStatic) {
+			t.Errorf("Origens do amigo não foram restauradas: %v", peer.Sources)
+		}
+	}
+
+	nodeSigner, _ := node.config.SigningPublicKey()
+	friendConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := friendConn.ReadFromUDP(drain)
+		if err != nil {
+			t.Fatalf("Amigo não recebeu anúncio após reinício: %v", err)
+		}
+		msg, err := discovery.DecodeMessage(drain[:n])
+		if err == nil && msg.Type == discovery.MsgAnnouncement && msg.Signer() == nodeSigner {
+			break
+		}
+	}
+
+	second.Stop()
+	saved := savedNodeIDs(t, statePath)
+	if !saved["friend"] {
+		t.Error("Amigo restaurado não foi mantido no estado")
+	}
+	if saved["stale"] {
+		t.Error("Nó inativo não foi descartado ao restaurar")
+	}
+	if sources := savedSources(t, statePath)["friend"]; !hasSource(sources, discovery.BackendStatic) {
+		t.Errorf("Origens restauradas do amigo não foram mantidas no estado: %v", sources)
+	}
+}
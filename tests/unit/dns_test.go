@@ -0,0 +1,414 @@
+package unit_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	testDNSDomain  = "vpn.test."
+	testTSIGName   = "p2pvpn-key."
+	testUDPMaxSize = 1232
+)
+
+var testTSIGSecret = []byte("segredo-compartilhado-de-teste")
+
+// testDNSServer é um servidor DNS em processo: responde consultas por UDP e TCP a partir de
+// uma zona em memória e aplica atualizações dinâmicas autenticadas por TSIG (hmac-sha256)
+type testDNSServer struct {
+	addr    string
+	udp     net.PacketConn
+	tcp     net.Listener
+	records []dnsmessage.Resource
+	mutex   sync.Mutex
+}
+
+func newTestDNSServer(t *testing.T) *testDNSServer {
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Falha ao abrir UDP do DNS: %v", err)
+	}
+	tcp, err := net.Listen("tcp4", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Fatalf("Falha ao abrir TCP do DNS: %v", err)
+	}
+
+	s := &testDNSServer{addr: udp.LocalAddr().String(), udp: udp, tcp: tcp}
+	go s.serveUDP()
+	go s.serveTCP()
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+	return s
+}
+
+func (s *testDNSServer) serveUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		response := s.handle(buf[:n])
+		if response == nil {
+			continue
+		}
+		if len(response) > testUDPMaxSize {
+			// Resposta truncada: o cliente repete a consulta por TCP
+			response = response[:12]
+			response[2] |= 0x02
+			binary.BigEndian.PutUint16(response[6:8], 0)
+			binary.BigEndian.PutUint16(response[8:10], 0)
+			binary.BigEndian.PutUint16(response[10:12], 0)
+		}
+		s.udp.WriteTo(response, addr)
+	}
+}
+
+func (s *testDNSServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					return
+				}
+				response := s.handle(msg)
+				if response == nil {
+					return
+				}
+				binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+				conn.Write(append(length[:], response...))
+			}
+		}()
+	}
+}
+
+// handle responde a uma consulta ou aplica uma atualização
+func (s *testDNSServer) handle(data []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(data); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            msg.Header.ID,
+			Response:      true,
+			OpCode:        msg.Header.OpCode,
+			Authoritative: true,
+		},
+		Questions: msg.Questions,
+	}
+
+	if msg.Header.OpCode == 5 {
+		response.Header.RCode = s.update(data, &msg)
+	} else {
+		response.Answers = s.lookup(msg.Questions[0])
+		if len(response.Answers) == 0 && msg.Questions[0].Type != dnsmessage.TypeAAAA {
+			response.Header.RCode = dnsmessage.RCodeNameError
+		}
+	}
+
+	packed, err := response.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+// lookup retorna os registros da zona para a pergunta. Todo nome sob o domínio resolve
+// para 127.0.0.1, onde os nós de teste escutam.
+func (s *testDNSServer) lookup(question dnsmessage.Question) []dnsmessage.Resource {
+	name := strings.ToLower(question.Name.String())
+	if question.Type == dnsmessage.TypeA && strings.HasSuffix(name, "."+testDNSDomain) {
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+		}}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var answers []dnsmessage.Resource
+	for _, record := range s.records {
+		if strings.ToLower(record.Header.Name.String()) == name && record.Header.Type == question.Type {
+			answers = append(answers, record)
+		}
+	}
+	return answers
+}
+
+// update aplica a seção de atualização depois de verificar a assinatura TSIG
+func (s *testDNSServer) update(data []byte, msg *dnsmessage.Message) dnsmessage.RCode {
+	if !verifyTestTSIG(data, msg) {
+		return dnsmessage.RCode(9) // NOTAUTH
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, record := range msg.Authorities {
+		name := strings.ToLower(record.Header.Name.String())
+		if record.Header.Class == dnsmessage.ClassANY {
+			kept := s.records[:0]
+			for _, existing := range s.records {
+				if strings.ToLower(existing.Header.Name.String()) != name || existing.Header.Type != record.Header.Type {
+					kept = append(kept, existing)
+				}
+			}
+			s.records = kept
+			continue
+		}
+		s.addRecord(record)
+	}
+	return dnsmessage.RCodeSuccess
+}
+
+// addRecord inclui um registro na zona, ignorando duplicatas
+func (s *testDNSServer) addRecord(record dnsmessage.Resource) {
+	for _, existing := range s.records {
+		if existing.Header.Name == record.Header.Name && existing.Header.Type == record.Header.Type &&
+			existing.Body.GoString() == record.Body.GoString() {
+			return
+		}
+	}
+	s.records = append(s.records, record)
+}
+
+// count retorna quantos registros do tipo existem na zona
+func (s *testDNSServer) count(recordType dnsmessage.Type) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, record := range s.records {
+		if record.Header.Type == recordType {
+			count++
+		}
+	}
+	return count
+}
+
+// verifyTestTSIG confere o MAC hmac-sha256 do registro TSIG no fim da mensagem
+func verifyTestTSIG(data []byte, msg *dnsmessage.Message) bool {
+	if len(msg.Additionals) == 0 {
+		return false
+	}
+	tsig := msg.Additionals[len(msg.Additionals)-1]
+	body, ok := tsig.Body.(*dnsmessage.UnknownResource)
+	if !ok || tsig.Header.Type != 250 || !strings.EqualFold(tsig.Header.Name.String(), testTSIGName) {
+		return false
+	}
+
+	keyName := encodeTestName(testTSIGName)
+	algName := encodeTestName("hmac-sha256.")
+	rdata := body.Data
+	if len(rdata) < len(algName)+10 || !bytes.Equal(rdata[:len(algName)], algName) {
+		return false
+	}
+	fixed := rdata[len(algName):]
+	macSize := int(binary.BigEndian.Uint16(fixed[8:10]))
+	if len(fixed) < 10+macSize {
+		return false
+	}
+	mac := fixed[10 : 10+macSize]
+
+	// Mensagem original: sem o registro TSIG e com a contagem adicional anterior
+	unsigned := append([]byte(nil), data[:len(data)-len(keyName)-10-len(rdata)]...)
+	binary.BigEndian.PutUint16(unsigned[10:12], binary.BigEndian.Uint16(unsigned[10:12])-1)
+
+	variables := append([]byte(nil), keyName...)
+	variables = binary.BigEndian.AppendUint16(variables, uint16(dnsmessage.ClassANY))
+	variables = binary.BigEndian.AppendUint32(variables, 0)
+	variables = append(variables, algName...)
+	variables = append(variables, fixed[:8]...) // Horário e fudge
+	variables = binary.BigEndian.AppendUint32(variables, 0)
+
+	expected := hmac.New(sha256.New, testTSIGSecret)
+	expected.Write(unsigned)
+	expected.Write(variables)
+	return hmac.Equal(mac, expected.Sum(nil))
+}
+
+func encodeTestName(name string) []byte {
+	var wire []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	return append(wire, 0)
+}
+
+// startDNSNode inicia a descoberta de um nó usando o servidor DNS de teste
+func startDNSNode(t *testing.T, node *fakeVPN, server *testDNSServer, publish bool) (*discovery.PeerDiscovery, int) {
+	node.config.Discovery.DNSDomain = testDNSDomain
+	node.config.Discovery.DNSServer = server.addr
+	if publish {
+		node.config.Discovery.DNSUpdate = core.DNSUpdateConfig{
+			Server:      server.addr,
+			TSIGKeyName: testTSIGName,
+			TSIGSecret:  base64.StdEncoding.EncodeToString(testTSIGSecret),
+		}
+	}
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(node.config, port, node)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta de %s: %v", node.config.NodeID, err)
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta de %s: %v", node.config.NodeID, err)
+	}
+	t.Cleanup(func() { service.Stop() })
+	return service, port
+}
+
+// TestDNSBackendDiscoversPublishedPeer verifica que um nó publicado por atualização dinâmica é encontrado via SRV/TXT
+// TestDNSBackendDiscoversPublishedPeer checks that a node published via dynamic update is found through SRV/TXT
+// TestDNSBackendDiscoversPublishedPeer verifica que un nodo publicado por actualización dinámica se encuentra vía SRV/TXT
+func TestDNSBackendDiscoversPublishedPeer(t *testing.T) {
+	server := newTestDNSServer(t)
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+
+	friendService, _ := startDNSNode(t, friend, server, true)
+	if !waitFor(2*time.Second, func() bool { return server.count(dnsmessage.TypeTXT) == 1 }) {
+		t.Fatal("Registro do amigo não publicado no DNS")
+	}
+
+	// Republicar substitui o TXT em vez de acumular registros
+	friendService.Announce()
+	if server.count(dnsmessage.TypeTXT) != 1 || server.count(dnsmessage.TypeSRV) != 1 {
+		t.Errorf("Republicação deveria manter um TXT e um SRV: %d TXT, %d SRV",
+			server.count(dnsmessage.TypeTXT), server.count(dnsmessage.TypeSRV))
+	}
+
+	host.config.AddTrustedPeer(friend.trustedPeer())
+	service, _ := startDNSNode(t, host, server, false)
+
+	var peers []discovery.PeerInfo
+	found := waitFor(3*time.Second, func() bool {
+		peers = service.Peers()
+		return len(peers) == 1 && host.hasPeer(friend.config.PublicKey)
+	})
+	if !found {
+		t.Fatalf("Amigo não encontrado via DNS: %+v", peers)
+	}
+	if len(peers[0].Sources) == 0 || peers[0].Sources[0] != discovery.BackendDNS {
+		t.Errorf("Origem incorreta: %v", peers[0].Sources)
+	}
+	if !strings.HasPrefix(peers[0].DiscoveryAddr, "127.0.0.1:") {
+		t.Errorf("Endereço de descoberta deveria vir do SRV: %s", peers[0].DiscoveryAddr)
+	}
+}
+
+// TestDNSBackendRejectsUntrustedRecords verifica que registros de nós não confiáveis ou adulterados são ignorados
+// TestDNSBackendRejectsUntrustedRecords checks that records from untrusted nodes or tampered ones are ignored
+// TestDNSBackendRejectsUntrustedRecords verifica que los registros de nodos no confiables o alterados se ignoran
+func TestDNSBackendRejectsUntrustedRecords(t *testing.T) {
+	server := newTestDNSServer(t)
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	stranger := newFakeVPN(t, "stranger", "10.0.0.3")
+	forged := newFakeVPN(t, "forged", "10.0.0.4")
+
+	updater := discovery.NewRFC2136Updater(server.addr, testDNSDomain, &discovery.TSIGKey{
+		Name:   testTSIGName,
+		Secret: testTSIGSecret,
+	})
+	publish := func(node *fakeVPN, tamper bool) {
+		record, err := discovery.BuildDNSRecord(testDNSDomain, mustAnnouncement(t, node), 60)
+		if err != nil {
+			t.Fatalf("Falha ao montar registro: %v", err)
+		}
+		if tamper {
+			// Trocar um caractere do base64 altera o anúncio sem refazer a assinatura
+			txt := []byte(record.TXT[0])
+			i := len(discovery.DNSRecordPrefix) + 60
+			if txt[i] == 'A' {
+				txt[i] = 'B'
+			} else {
+				txt[i] = 'A'
+			}
+			record.TXT[0] = string(txt)
+		}
+		if err := updater.Publish(record); err != nil {
+			t.Fatalf("Falha ao publicar registro: %v", err)
+		}
+	}
+
+	publish(stranger, false)
+	publish(forged, true)
+	publish(friend, false)
+
+	// Sem a chave TSIG correta o servidor recusa a atualização
+	intruder := discovery.NewRFC2136Updater(server.addr, testDNSDomain, &discovery.TSIGKey{
+		Name:   testTSIGName,
+		Secret: []byte("outro-segredo"),
+	})
+	record, _ := discovery.BuildDNSRecord(testDNSDomain, mustAnnouncement(t, stranger), 60)
+	if err := intruder.Publish(record); err == nil {
+		t.Error("Atualização com TSIG inválido deveria ser recusada")
+	}
+
+	for _, node := range []*fakeVPN{friend, forged} {
+		host.config.AddTrustedPeer(node.trustedPeer())
+	}
+	service, _ := startDNSNode(t, host, server, false)
+
+	if !waitFor(3*time.Second, func() bool { return host.hasPeer(friend.config.PublicKey) }) {
+		t.Fatal("Amigo confiável não encontrado via DNS")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for _, peer := range service.Peers() {
+		if peer.NodeID != friend.config.NodeID {
+			t.Errorf("Registro não confiável ou adulterado aceito: %s", peer.NodeID)
+		}
+	}
+	if host.hasPeer(forged.config.PublicKey) {
+		t.Error("Registro adulterado não deveria configurar o peer")
+	}
+}
+
+// mustAnnouncement codifica o anúncio assinado de um nó
+func mustAnnouncement(t *testing.T, node *fakeVPN) []byte {
+	key, _ := node.config.SigningKey()
+	data, err := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:        node.config.NodeID,
+		PublicKey:     node.config.PublicKey,
+		VirtualIP:     node.config.VirtualIP,
+		ListenPort:    51820,
+		DiscoveryPort: 40000,
+	}, key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar anúncio: %v", err)
+	}
+	return data
+}
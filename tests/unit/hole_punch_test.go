@@ -0,0 +1,204 @@
+package unit_test
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// punchLink entrega as rodadas de hole punching entre nós de teste, como a descoberta faz
+type punchLink struct {
+	nodes map[string]*relayNode
+	calls int
+	mutex sync.Mutex
+}
+
+// punchSignaler é o PunchSignaler de um nó ligado ao punchLink
+type punchSignaler struct {
+	link *punchLink
+	from *relayNode
+}
+
+func (s *punchSignaler) SignalPunch(nodeID string, offer nattraversal.PunchOffer) error {
+	s.link.mutex.Lock()
+	s.link.calls++
+	target := s.link.nodes[nodeID]
+	s.link.mutex.Unlock()
+
+	// A mensagem chega ao peer pela rede, sem bloquear quem envia
+	go target.traversal.HandlePunch(s.from.vpn.config.NodeID, s.from.vpn.config.PublicKey, offer)
+	return nil
+}
+
+func (l *punchLink) connect(node *relayNode) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.nodes[node.vpn.config.NodeID] = node
+	node.traversal.SetPunchSignaler(&punchSignaler{link: l, from: node})
+}
+
+// TestHolePunchingThroughPortRestrictedNAT verifica que dois nós atrás de NAT port-restricted
+// combinam a rodada, sondam pelo socket do WireGuard e aplicam o endpoint público um do outro,
+// pelo qual o tráfego WireGuard passa a fluir nos dois sentidos
+// TestHolePunchingThroughPortRestrictedNAT checks that two nodes behind port-restricted NAT
+// agree on the round, probe from the WireGuard socket and apply each other's public endpoint,
+// through which WireGuard traffic then flows both ways
+// TestHolePunchingThroughPortRestrictedNAT verifica que dos nodos detrás de NAT port-restricted
+// acuerdan la ronda, sondean desde el socket de WireGuard y aplican el endpoint público del
+// otro, por el cual el tráfico WireGuard fluye en ambos sentidos
+func TestHolePunchingThroughPortRestrictedNAT(t *testing.T) {
+	service := newRFC5780Service(t)
+
+	natA, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.3.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natA.Stop()
+	natB, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.4", "10.0.4.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natB.Stop()
+
+	alice := newRelayNode(t, "node-a", service, natA.ListenPacket)
+	bob := newRelayNode(t, "node-b", service, natB.ListenPacket)
+
+	// O socket WireGuard de cada nó fica atrás do seu NAT e também envia as sondas
+	wgA, _ := natA.ListenPacket()
+	defer wgA.Close()
+	wgB, _ := natB.ListenPacket()
+	defer wgB.Close()
+	alice.traversal.SetPunchConn(wgA)
+	bob.traversal.SetPunchConn(wgB)
+
+	link := &punchLink{nodes: make(map[string]*relayNode)}
+	link.connect(alice)
+	link.connect(bob)
+	alice.traversal.SetPeerRelay("node-b", bob.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+	bob.traversal.SetPeerRelay("node-a", alice.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+
+	for _, node := range []*relayNode{alice, bob} {
+		node.traversal.Refresh()
+		if err := node.traversal.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+		}
+		if info := node.traversal.GetNATInfo(); info.Type != "port-restricted" {
+			t.Fatalf("NAT port-restricted esperado, detectado %q", info.Type)
+		}
+	}
+
+	// O endpoint anunciado não é o mapeamento da porta do WireGuard: o de B vem na resposta
+	if err := alice.traversal.ConnectPeer("node-b", []string{"127.0.0.4:9"}); err != nil {
+		t.Fatalf("Hole punching falhou: %v", err)
+	}
+
+	endpointB, err := net.ResolveUDPAddr("udp4", alice.vpn.activeEndpoint("node-b"))
+	if err != nil || endpointB.IP.String() != "127.0.0.4" || endpointB.Port == 9 {
+		t.Fatalf("Endpoint de B deveria ser o mapeamento público do WireGuard, obtido %q", alice.vpn.activeEndpoint("node-b"))
+	}
+	if !waitFor(2*time.Second, func() bool { return bob.vpn.activeEndpoint("node-a") != "" }) {
+		t.Fatal("B não aplicou o endpoint de A")
+	}
+	endpointA, err := net.ResolveUDPAddr("udp4", bob.vpn.activeEndpoint("node-a"))
+	if err != nil || endpointA.IP.String() != "127.0.0.3" {
+		t.Fatalf("Endpoint de A deveria ser o mapeamento público do WireGuard, obtido %q", bob.vpn.activeEndpoint("node-a"))
+	}
+
+	link.mutex.Lock()
+	calls := link.calls
+	link.mutex.Unlock()
+	if calls != 2 {
+		t.Errorf("Esperadas a oferta e a resposta, obtidas %d mensagens", calls)
+	}
+
+	// Depois da janela, contada do início combinado, o WireGuard volta a ser o único leitor do socket
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+
+	wgA.WriteTo([]byte("handshake"), endpointB)
+	data, src := readPacket(t, wgB)
+	if string(data) != "handshake" || src.String() != endpointA.String() {
+		t.Fatalf("WireGuard de B recebeu %q de %s, esperado handshake de %s", data, src, endpointA)
+	}
+	wgB.WriteTo([]byte("resposta"), endpointA)
+	data, src = readPacket(t, wgA)
+	if string(data) != "resposta" || src.String() != endpointB.String() {
+		t.Errorf("WireGuard de A recebeu %q de %s, esperado resposta de %s", data, src, endpointB)
+	}
+}
+
+// TestHolePunchingFromWireGuardPort verifica que, sem socket próprio para as sondas, o Linux
+// as envia por socket raw com a porta de origem do WireGuard
+// TestHolePunchingFromWireGuardPort checks that, without a probe socket, Linux sends the
+// probes through a raw socket using the WireGuard source port
+// TestHolePunchingFromWireGuardPort verifica que, sin socket propio para las sondas, Linux
+// las envía por socket raw con el puerto de origen de WireGuard
+func TestHolePunchingFromWireGuardPort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Sondas por socket raw só existem no Linux")
+	}
+	probe, err := net.ListenIP("ip4:udp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Skipf("Socket raw indisponível: %v", err)
+	}
+	probe.Close()
+
+	service := newRFC5780Service(t)
+	alice := newRelayNode(t, "node-a", service, nil)
+	bob := newRelayNode(t, "node-b", service, nil)
+	portA := alice.wg.LocalAddr().(*net.UDPAddr).Port
+
+	// A mesma rodada, com o endpoint WireGuard do outro lado, entregue aos dois nós
+	offer := nattraversal.PunchOffer{Session: "0123456789abcdef", At: time.Now().Add(200 * time.Millisecond)}
+	offerA := offer
+	offerA.Endpoints = []string{bob.wg.LocalAddr().String()}
+	offerB := offer
+	offerB.Endpoints = []string{alice.wg.LocalAddr().String()}
+	if err := alice.traversal.HandlePunch("node-b", bob.vpn.config.PublicKey, offerA); err != nil {
+		t.Fatalf("Rodada recusada por A: %v", err)
+	}
+	if err := bob.traversal.HandlePunch("node-a", alice.vpn.config.PublicKey, offerB); err != nil {
+		t.Fatalf("Rodada recusada por B: %v", err)
+	}
+
+	// O socket WireGuard de B recebe a sonda vinda da porta WireGuard de A. Antes dela podem
+	// chegar as respostas STUN da descoberta do endpoint público, também pela porta do WireGuard.
+	for {
+		data, src := readUDP(t, bob.wg)
+		if len(data) > 0 && data[0] == 0xf0 {
+			if src.Port != portA {
+				t.Fatalf("Sonda esperada da porta %d, recebida de %s", portA, src)
+			}
+			break
+		}
+	}
+
+	if !waitFor(3*time.Second, func() bool {
+		return alice.vpn.activeEndpoint("node-b") == bob.wg.LocalAddr().String() &&
+			bob.vpn.activeEndpoint("node-a") == alice.wg.LocalAddr().String()
+	}) {
+		t.Fatalf("Endpoints esperados %s e %s, obtidos %q e %q", bob.wg.LocalAddr(), alice.wg.LocalAddr(),
+			alice.vpn.activeEndpoint("node-b"), bob.vpn.activeEndpoint("node-a"))
+	}
+
+	// Rodadas com início distante demais são recusadas
+	late := nattraversal.PunchOffer{Session: "fedcba9876543210", At: time.Now().Add(time.Hour)}
+	if err := alice.traversal.HandlePunch("node-b", bob.vpn.config.PublicKey, late); err == nil {
+		t.Error("Rodada com início distante deveria ser recusada")
+	}
+}
+
+// readPacket lê um pacote de um socket, com prazo
+func readPacket(t *testing.T, conn net.PacketConn) ([]byte, net.Addr) {
+	t.Helper()
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, src, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Nenhum pacote recebido: %v", err)
+	}
+	return buffer[:n], src
+}
@@ -0,0 +1,242 @@
+package unit_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// TestCandidatePriorities verifica a prioridade dos candidatos pela fórmula do ICE, a ordem
+// entre os tipos e a classificação dos endpoints anunciados sem tipo
+// TestCandidatePriorities checks candidate priorities from the ICE formula, the order between
+// types and the classification of endpoints announced without a type
+// TestCandidatePriorities verifica la prioridad de los candidatos por la fórmula de ICE, el
+// orden entre los tipos y la clasificación de los endpoints anunciados sin tipo
+func TestCandidatePriorities(t *testing.T) {
+	if priority := nattraversal.CandidatePriority(nattraversal.CandidateHost, 65535); priority != 126<<24|65535<<8|255 {
+		t.Errorf("Prioridade do candidato host %d, esperada %d", priority, uint32(126<<24|65535<<8|255))
+	}
+
+	kinds := []nattraversal.CandidateType{
+		nattraversal.CandidateHost,
+		nattraversal.CandidatePeerReflexive,
+		nattraversal.CandidatePortMapped,
+		nattraversal.CandidateServerReflexive,
+		nattraversal.CandidateRelay,
+	}
+	for i := 1; i < len(kinds); i++ {
+		// A preferência do tipo pesa mais que a ordem dentro do tipo
+		if nattraversal.CandidatePriority(kinds[i-1], 0) <= nattraversal.CandidatePriority(kinds[i], 65535) {
+			t.Errorf("Candidato %s deveria ter prioridade maior que %s", kinds[i-1], kinds[i])
+		}
+	}
+
+	candidates := nattraversal.CandidatesFromEndpoints([]string{"203.0.113.7:51820", "192.168.1.10:51820", "sem-porta"})
+	if len(candidates) != 2 {
+		t.Fatalf("Esperados 2 candidatos, obtidos %+v", candidates)
+	}
+	if candidates[0].Type != nattraversal.CandidateHost || candidates[0].Address != "192.168.1.10:51820" ||
+		candidates[1].Type != nattraversal.CandidateServerReflexive || candidates[1].Address != "203.0.113.7:51820" {
+		t.Errorf("Candidatos classificados ou ordenados incorretamente: %+v", candidates)
+	}
+}
+
+// TestConnectivityChecksNominatePair verifica que, entre os candidatos do peer, as
+// verificações descartam o endereço local inalcançável, nomeiam o endpoint público e o aplicam
+// ao WireGuard dos dois lados
+// TestConnectivityChecksNominatePair checks that, among the peer's candidates, the checks
+// discard the unreachable local address, nominate the public endpoint and apply it to
+// WireGuard on both sides
+// TestConnectivityChecksNominatePair verifica que, entre los candidatos del par, las
+// verificaciones descartan la dirección local inalcanzable, nominan el endpoint público y lo
+// aplican a WireGuard en ambos lados
+func TestConnectivityChecksNominatePair(t *testing.T) {
+	service := newRFC5780Service(t)
+	natA, natB := newCheckNATs(t)
+	alice, bob, wgA, wgB := newPunchPair(t, service, natA, natB, "port-restricted", "port-restricted")
+
+	candidates := gatherWithReflexive(t, bob, wgB)
+	if candidates[0].Type != nattraversal.CandidateHost {
+		t.Fatalf("Candidato host deveria vir primeiro: %+v", candidates)
+	}
+	reflexive := candidates[1]
+
+	if err := alice.traversal.ConnectPeerCandidates("node-b", candidates); err != nil {
+		t.Fatalf("Verificações de conectividade falharam: %v", err)
+	}
+	selected, ok := alice.traversal.SelectedCandidate("node-b")
+	if !ok || selected != reflexive {
+		t.Fatalf("Par nomeado %+v, esperado o candidato reflexivo %+v", selected, reflexive)
+	}
+	if endpoint := alice.vpn.activeEndpoint("node-b"); endpoint != reflexive.Address {
+		t.Fatalf("Endpoint de B em A %q, esperado %s", endpoint, reflexive.Address)
+	}
+	if !waitFor(2*time.Second, func() bool { return bob.vpn.activeEndpoint("node-a") != "" }) {
+		t.Fatal("B não aplicou o par nomeado por A")
+	}
+
+	// Depois da janela, o WireGuard volta a ser o único leitor do socket
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+	assertCheckedPath(t, alice, bob, wgA, wgB)
+}
+
+// TestConnectivityChecksRecoverDegradedPair verifica que, quando o par escolhido deixa de
+// responder, as verificações são refeitas e o novo endpoint do peer é nomeado
+// TestConnectivityChecksRecoverDegradedPair checks that, when the selected pair stops
+// responding, the checks run again and the peer's new endpoint is nominated
+// TestConnectivityChecksRecoverDegradedPair verifica que, cuando el par elegido deja de
+// responder, las verificaciones se repiten y se nomina el nuevo endpoint del par
+func TestConnectivityChecksRecoverDegradedPair(t *testing.T) {
+	service := newRFC5780Service(t)
+	natA, natB := newCheckNATs(t)
+	alice, bob, wgA, wgB := newPunchPair(t, service, natA, natB, "port-restricted", "port-restricted")
+
+	if err := alice.traversal.ConnectPeerCandidates("node-b", gatherWithReflexive(t, bob, wgB)); err != nil {
+		t.Fatalf("Verificações de conectividade falharam: %v", err)
+	}
+	previous, _ := alice.traversal.SelectedCandidate("node-b")
+
+	// O WireGuard de B passa a outro socket, com outro mapeamento no NAT; o anterior fecha
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+	wgB.Close()
+	wgB, _ = natB.ListenPacket()
+	t.Cleanup(func() { wgB.Close() })
+	bob.traversal.SetPunchConn(wgB)
+	alice.traversal.SetConsentInterval(200 * time.Millisecond)
+
+	var current nattraversal.Candidate
+	if !waitFor(15*time.Second, func() bool {
+		current, _ = alice.traversal.SelectedCandidate("node-b")
+		return current.Address != "" && current.Address != previous.Address
+	}) {
+		t.Fatalf("Par degradado %s não foi substituído", previous.Address)
+	}
+	alice.traversal.SetConsentInterval(time.Hour)
+	if endpoint := alice.vpn.activeEndpoint("node-b"); endpoint != current.Address {
+		t.Fatalf("Endpoint de B em A %q, esperado o novo par %s", endpoint, current.Address)
+	}
+
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+	assertCheckedPath(t, alice, bob, wgA, wgB)
+}
+
+// TestAnnouncementCandidates verifica que os candidatos anunciados chegam ordenados aos
+// peers, com o endereço observado como peer-reflexivo
+// TestAnnouncementCandidates checks that announced candidates reach peers in order, with the
+// observed address as peer-reflexive
+// TestAnnouncementCandidates verifica que los candidatos anunciados llegan ordenados a los
+// pares, con la dirección observada como peer-reflexiva
+func TestAnnouncementCandidates(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+
+	service, err := discovery.NewPeerDiscovery(host.config, freeUDPPort(t), host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	backend := newFakeBackend()
+	if err := service.AddBackend(backend); err != nil {
+		t.Fatalf("Falha ao adicionar backend: %v", err)
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	key, _ := friend.config.SigningKey()
+	data, _ := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:     friend.config.NodeID,
+		PublicKey:  friend.config.PublicKey,
+		VirtualIP:  friend.config.VirtualIP,
+		ListenPort: 51820,
+		Endpoints:  []string{"192.168.1.20:51820"},
+		Candidates: []discovery.CandidateRecord{
+			{Type: "relay", Address: "198.51.100.9:3478", Priority: nattraversal.CandidatePriority(nattraversal.CandidateRelay, 65535)},
+			{Type: "host", Address: "192.168.1.20:51820", Priority: nattraversal.CandidatePriority(nattraversal.CandidateHost, 65535)},
+			{Type: "desconhecido", Address: "192.0.2.1:1", Priority: 1 << 31},
+		},
+	}, key)
+	msg, _ := discovery.DecodeMessage(data)
+	backend.results <- discovery.DiscoveryResult{Message: msg, Addr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40000}}
+
+	var peers []discovery.PeerInfo
+	if !waitFor(2*time.Second, func() bool {
+		peers = service.Peers()
+		return len(peers) == 1
+	}) {
+		t.Fatal("Anúncio não registrado")
+	}
+
+	candidates := peers[0].Candidates
+	expected := []nattraversal.CandidateType{nattraversal.CandidateHost, nattraversal.CandidatePeerReflexive, nattraversal.CandidateRelay}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Esperados %d candidatos, obtidos %+v", len(expected), candidates)
+	}
+	for i, kind := range expected {
+		if candidates[i].Type != kind {
+			t.Errorf("Candidato %d do tipo %s, esperado %s: %+v", i, candidates[i].Type, kind, candidates)
+		}
+	}
+	if candidates[1].Address != "203.0.113.7:51820" {
+		t.Errorf("Candidato peer-reflexivo %s, esperado o endereço observado", candidates[1].Address)
+	}
+}
+
+// newCheckNATs cria os NATs port-restricted dos dois nós das verificações de conectividade
+func newCheckNATs(t *testing.T) (*nattraversal.NATSimulator, *nattraversal.NATSimulator) {
+	t.Helper()
+	natA, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.3.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	t.Cleanup(natA.Stop)
+	natB, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.4", "10.0.4.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	t.Cleanup(natB.Stop)
+	return natA, natB
+}
+
+// gatherWithReflexive reúne os candidatos do nó, com um endereço local que o peer não alcança,
+// até o endpoint público da porta do WireGuard ser descoberto
+func gatherWithReflexive(t *testing.T, node *relayNode, wg net.PacketConn) []nattraversal.Candidate {
+	t.Helper()
+	port := strconv.Itoa(wg.LocalAddr().(*net.UDPAddr).Port)
+	var candidates []nattraversal.Candidate
+	if !waitFor(3*time.Second, func() bool {
+		candidates = node.traversal.GatherCandidates([]string{net.JoinHostPort("192.0.2.1", port)})
+		return len(candidates) == 2 && candidates[1].Type == nattraversal.CandidateServerReflexive
+	}) {
+		t.Fatalf("Endpoint público não descoberto: %+v", candidates)
+	}
+	return candidates
+}
+
+// assertCheckedPath verifica o tráfego WireGuard de A para B pelo par aplicado e a resposta
+func assertCheckedPath(t *testing.T, alice, bob *relayNode, wgA, wgB net.PacketConn) {
+	t.Helper()
+	endpointB, err := net.ResolveUDPAddr("udp4", alice.vpn.activeEndpoint("node-b"))
+	if err != nil {
+		t.Fatalf("Endpoint de B inválido: %v", err)
+	}
+	endpointA, err := net.ResolveUDPAddr("udp4", bob.vpn.activeEndpoint("node-a"))
+	if err != nil {
+		t.Fatalf("Endpoint de A inválido: %v", err)
+	}
+
+	wgA.WriteTo([]byte("handshake"), endpointB)
+	data, src := readPacket(t, wgB)
+	if string(data) != "handshake" || src.String() != endpointA.String() {
+		t.Fatalf("WireGuard de B recebeu %q de %s, esperado handshake de %s", data, src, endpointA)
+	}
+	wgB.WriteTo([]byte("resposta"), endpointA)
+	data, src = readPacket(t, wgA)
+	if string(data) != "resposta" || src.String() != endpointB.String() {
+		t.Errorf("WireGuard de A recebeu %q de %s, esperado resposta de %s", data, src, endpointB)
+	}
+}
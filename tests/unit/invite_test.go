@@ -0,0 +1,198 @@
+package unit_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// TestInviteTokenRoundTrip verifica a codificação, a assinatura e a validade dos convites
+// TestInviteTokenRoundTrip checks invite encoding, signature and expiry
+// TestInviteTokenRoundTrip verifica la codificación, la firma y la validez de las invitaciones
+func TestInviteTokenRoundTrip(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	key, _ := host.config.SigningKey()
+
+	invite := &discovery.Invite{
+		ID:         "0102030405060708",
+		NodeID:     "host",
+		PublicKey:  host.config.PublicKey,
+		VirtualIP:  "10.0.0.1",
+		Discovery:  []string{"127.0.0.1:51821"},
+		AssignedIP: "10.0.0.2",
+		Expires:    time.Now().Add(time.Hour).Unix(),
+		SingleUse:  true,
+	}
+
+	token, err := discovery.EncodeInvite(invite, key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar convite: %v", err)
+	}
+	if !strings.HasPrefix(token, discovery.InviteTokenPrefix) {
+		t.Errorf("Token sem prefixo: %s", token)
+	}
+
+	decoded, signer, err := discovery.DecodeInvite(token)
+	if err != nil {
+		t.Fatalf("Falha ao decodificar convite: %v", err)
+	}
+	expectedSigner, _ := host.config.SigningPublicKey()
+	if signer != expectedSigner || decoded.AssignedIP != "10.0.0.2" || !decoded.SingleUse {
+		t.Errorf("Convite decodificado difere do original: %+v", decoded)
+	}
+
+	// Alterar um caractere do token invalida a assinatura
+	tampered := token[:len(token)-10] + "A" + token[len(token)-9:]
+	if tampered != token {
+		if _, _, err := discovery.DecodeInvite(tampered); err == nil {
+			t.Error("Convite adulterado deveria ser rejeitado")
+		}
+	}
+
+	invite.Expires = time.Now().Add(-time.Minute).Unix()
+	expired, _ := discovery.EncodeInvite(invite, key)
+	if _, _, err := discovery.DecodeInvite(expired); !errors.Is(err, discovery.ErrInviteExpired) {
+		t.Errorf("Esperado ErrInviteExpired, obtido: %v", err)
+	}
+}
+
+// TestJoinNetworkWithInvite verifica a troca de chaves entre convidado e anfitrião
+// TestJoinNetworkWithInvite checks the key exchange between guest and host
+// TestJoinNetworkWithInvite verifica el intercambio de claves entre invitado y anfitrión
+func TestJoinNetworkWithInvite(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	host.config.VirtualCIDR = "10.0.0.0/24"
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+
+	store := discovery.NewInviteStore(filepath.Join(t.TempDir(), "invites.yaml"))
+	service.SetInviteStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	assignedIP, err := discovery.NextFreeIP(host.config, store)
+	if err != nil || assignedIP != "10.0.0.2" {
+		t.Fatalf("IP atribuído inesperado: %s (%v)", assignedIP, err)
+	}
+
+	signer, _ := host.config.SigningPublicKey()
+	invite := &discovery.Invite{
+		ID:         "a1b2c3d4e5f60708",
+		NodeID:     "host",
+		PublicKey:  host.config.PublicKey,
+		VirtualIP:  host.config.VirtualIP,
+		Discovery:  []string{fmt.Sprintf("127.0.0.1:%d", port)},
+		AssignedIP: assignedIP,
+		Expires:    time.Now().Add(time.Hour).Unix(),
+		SingleUse:  true,
+	}
+	if err := store.Add(invite); err != nil {
+		t.Fatalf("Falha ao registrar convite: %v", err)
+	}
+
+	guest := newFakeVPN(t, "guest", assignedIP)
+	if err := discovery.JoinNetwork(invite, signer, guest.config, 51820, 3*time.Second); err != nil {
+		t.Fatalf("Falha ao entrar na rede: %v", err)
+	}
+	if !host.hasPeer(guest.config.PublicKey) {
+		t.Error("Anfitrião não adicionou o convidado aos peers confiáveis")
+	}
+
+	// O mesmo convidado pode repetir o pedido; outro nó não pode reutilizar o convite
+	if err := discovery.JoinNetwork(invite, signer, guest.config, 51820, 3*time.Second); err != nil {
+		t.Errorf("Repetição do mesmo convidado deveria ser aceita: %v", err)
+	}
+
+	intruder := newFakeVPN(t, "intruder", assignedIP)
+	if err := discovery.JoinNetwork(invite, signer, intruder.config, 51820, 3*time.Second); err == nil {
+		t.Error("Convite de uso único foi reutilizado por outro nó")
+	}
+	if host.hasPeer(intruder.config.PublicKey) {
+		t.Error("Intruso foi adicionado aos peers confiáveis")
+	}
+}
+
+// TestJoinRejectsCollisions verifica que um convite não serve para substituir um peer
+// existente, pelo ID ou pela chave, e que o IP virtual é atribuído pelo anfitrião
+// TestJoinRejectsCollisions checks that an invite cannot replace an existing peer, by ID or by
+// key, and that the virtual IP is assigned by the host
+// TestJoinRejectsCollisions verifica que una invitación no sirve para reemplazar un par
+// existente, por ID o por clave, y que la IP virtual la asigna el anfitrión
+func TestJoinRejectsCollisions(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	host.config.VirtualCIDR = "10.0.0.0/24"
+	existing := newFakeVPN(t, "existing", "10.0.0.2")
+	host.config.TrustedPeers = []core.TrustedPeer{existing.trustedPeer()}
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	store := discovery.NewInviteStore(filepath.Join(t.TempDir(), "invites.yaml"))
+	service.SetInviteStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	signer, _ := host.config.SigningPublicKey()
+	invite := &discovery.Invite{
+		ID:        "0f1e2d3c4b5a6978",
+		NodeID:    "host",
+		PublicKey: host.config.PublicKey,
+		VirtualIP: host.config.VirtualIP,
+		Discovery: []string{fmt.Sprintf("127.0.0.1:%d", port)},
+		Expires:   time.Now().Add(time.Hour).Unix(),
+	}
+	if err := store.Add(invite); err != nil {
+		t.Fatalf("Falha ao registrar convite: %v", err)
+	}
+
+	// Mesmo ID de um peer existente, com outra chave
+	sameID := newFakeVPN(t, "existing", "10.0.0.50")
+	if err := discovery.JoinNetwork(invite, signer, sameID.config, 51820, 2*time.Second); err == nil {
+		t.Error("Convidado com o ID de um peer existente deveria ser recusado")
+	}
+
+	// Mesma chave WireGuard de um peer existente, assinada por outra chave
+	sameKey := newFakeVPN(t, "impostor", "10.0.0.51")
+	sameKey.config.PublicKey = existing.config.PublicKey
+	if err := discovery.JoinNetwork(invite, signer, sameKey.config, 51820, 2*time.Second); err == nil {
+		t.Error("Convidado com a chave de um peer existente deveria ser recusado")
+	}
+
+	for _, peer := range host.TrustedPeers() {
+		original := existing.trustedPeer()
+		if peer.PublicKey == original.PublicKey &&
+			(peer.NodeID != original.NodeID || peer.SigningKey != original.SigningKey || peer.VirtualIP != original.VirtualIP) {
+			t.Fatalf("Peer existente alterado pelo convite: %+v", peer)
+		}
+	}
+
+	// O IP pedido pelo convidado colide com o peer existente; o anfitrião atribui outro
+	guest := newFakeVPN(t, "guest", "10.0.0.2")
+	if err := discovery.JoinNetwork(invite, signer, guest.config, 51820, 2*time.Second); err != nil {
+		t.Fatalf("Falha ao entrar na rede: %v", err)
+	}
+	if guest.config.VirtualIP != "10.0.0.3" {
+		t.Errorf("IP atribuído pelo anfitrião deveria ser 10.0.0.3, obtido %s", guest.config.VirtualIP)
+	}
+	for _, peer := range host.TrustedPeers() {
+		if peer.PublicKey == guest.config.PublicKey && peer.VirtualIP != guest.config.VirtualIP {
+			t.Errorf("Anfitrião registrou o convidado com o IP %s", peer.VirtualIP)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package unit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// TestKeepaliveInterval verifica o keepalive calculado para cada caminho: desativado fora de
+// NAT, o padrão sem medição e metade do tempo de vida medido, com mínimo de um segundo
+// TestKeepaliveInterval checks the keepalive computed for each path: disabled outside NAT,
+// the default without measurement and half the measured lifetime, at least one second
+// TestKeepaliveInterval verifica el keepalive calculado para cada camino: desactivado fuera
+// de NAT, el predeterminado sin medición y la mitad del tiempo de vida medido
+func TestKeepaliveInterval(t *testing.T) {
+	tests := []struct {
+		name      string
+		behindNAT bool
+		lifetime  time.Duration
+		expected  int
+	}{
+		{"sem NAT", false, 0, 0},
+		{"sem NAT com medição", false, 120 * time.Second, 0},
+		{"NAT sem medição", true, 0, core.DefaultKeepalive},
+		{"NAT de 2 minutos", true, 120 * time.Second, 60},
+		{"NAT de 30 segundos", true, 30 * time.Second, 15},
+		{"NAT de 1 segundo", true, time.Second, 1},
+	}
+
+	for _, test := range tests {
+		if interval := core.KeepaliveInterval(test.behindNAT, test.lifetime); interval != test.expected {
+			t.Errorf("%s: keepalive %d, esperado %d", test.name, interval, test.expected)
+		}
+	}
+}
+
+// TestKeepaliveFollowsPath verifica que, depois do hole punching entre um nó sem NAT e um nó
+// atrás de NAT port-restricted, só o lado atrás do NAT mantém keepalive, e que a medição do
+// tempo de vida do mapeamento o reajusta em execução
+// TestKeepaliveFollowsPath checks that, after hole punching between a node without NAT and a
+// node behind port-restricted NAT, only the side behind the NAT keeps a keepalive, and that
+// measuring the mapping lifetime readjusts it live
+// TestKeepaliveFollowsPath verifica que, tras el hole punching entre un nodo sin NAT y un nodo
+// detrás de NAT port-restricted, solo el lado detrás del NAT mantiene keepalive, y que la
+// medición del tiempo de vida del mapeo lo reajusta en ejecución
+func TestKeepaliveFollowsPath(t *testing.T) {
+	service := newRFC5780Service(t)
+
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.3.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+	simulator.SetMappingTimeout(1500 * time.Millisecond)
+
+	alice := newRelayNode(t, "node-a", service, simulator.ListenPacket)
+	bob := newRelayNode(t, "node-b", service, nil)
+
+	wgA, _ := simulator.ListenPacket()
+	defer wgA.Close()
+	alice.traversal.SetPunchConn(wgA)
+	bob.traversal.SetPunchConn(bob.wg)
+	alice.traversal.SetMappingProbe(time.Second, 2*time.Second)
+
+	link := &punchLink{nodes: make(map[string]*relayNode)}
+	link.connect(alice)
+	link.connect(bob)
+	alice.traversal.SetPeerRelay("node-b", bob.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+	bob.traversal.SetPeerRelay("node-a", alice.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+
+	for _, node := range []*relayNode{alice, bob} {
+		node.traversal.Refresh()
+		if err := node.traversal.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+		}
+	}
+	if info := bob.traversal.GetNATInfo(); info.Type != "open" {
+		t.Fatalf("Nó sem NAT detectado como %q", info.Type)
+	}
+
+	if err := alice.traversal.ConnectPeer("node-b", []string{bob.wg.LocalAddr().String()}); err != nil {
+		t.Fatalf("Hole punching falhou: %v", err)
+	}
+
+	// O nó atrás do NAT mantém o mapeamento aberto; o nó sem NAT economiza os keepalives
+	if interval, ok := alice.vpn.activeKeepalive("node-b"); !ok || interval == 0 {
+		t.Fatalf("Nó atrás de NAT deveria manter keepalive, obtido %d (aplicado: %v)", interval, ok)
+	}
+	if !waitFor(2*time.Second, func() bool {
+		interval, ok := bob.vpn.activeKeepalive("node-a")
+		return ok && interval == 0
+	}) {
+		interval, ok := bob.vpn.activeKeepalive("node-a")
+		t.Fatalf("Nó sem NAT deveria desativar o keepalive, obtido %d (aplicado: %v)", interval, ok)
+	}
+
+	// O mapeamento dura 1,25s na medição: metade fica abaixo de um segundo, o mínimo
+	if !waitFor(10*time.Second, func() bool {
+		interval, _ := alice.vpn.activeKeepalive("node-b")
+		return interval == 1
+	}) {
+		interval, _ := alice.vpn.activeKeepalive("node-b")
+		t.Errorf("Keepalive deveria seguir o tempo de vida medido (%ds), obtido %d",
+			alice.traversal.GetNATInfo().MappingLifetime, interval)
+	}
+}
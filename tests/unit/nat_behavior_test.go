@@ -0,0 +1,252 @@
+package unit_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// rfc5780Responder é um servidor STUN com dois IPs e duas portas que atende CHANGE-REQUEST,
+// RESPONSE-PORT e informa RESPONSE-ORIGIN e OTHER-ADDRESS (RFC 5780)
+type rfc5780Responder struct {
+	conns [2][2]*net.UDPConn // Indexado por [IP][porta]
+}
+
+func newRFC5780Responder(t *testing.T) *rfc5780Responder {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+
+	// As mesmas duas portas precisam estar livres nos dois IPs
+	for attempt := 0; attempt < 10; attempt++ {
+		r := &rfc5780Responder{}
+		ok := true
+		for j := 0; j < 2 && ok; j++ {
+			for i := 0; i < 2 && ok; i++ {
+				port := 0
+				if i == 1 {
+					port = r.conns[0][j].LocalAddr().(*net.UDPAddr).Port
+				}
+				conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ips[i], Port: port})
+				if err != nil {
+					ok = false
+					break
+				}
+				r.conns[i][j] = conn
+			}
+		}
+		if !ok {
+			r.close()
+			continue
+		}
+
+		t.Cleanup(r.close)
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				go r.serve(i, j)
+			}
+		}
+		return r
+	}
+
+	t.Fatal("Falha ao abrir os sockets do servidor RFC 5780")
+	return nil
+}
+
+func (r *rfc5780Responder) close() {
+	for i := range r.conns {
+		for _, conn := range r.conns[i] {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+func (r *rfc5780Responder) addr(i, j int) *net.UDPAddr {
+	return r.conns[i][j].LocalAddr().(*net.UDPAddr)
+}
+
+func (r *rfc5780Responder) server() nattraversal.STUNServer {
+	addr := r.addr(0, 0)
+	return nattraversal.STUNServer{Address: addr.IP.String(), Port: addr.Port}
+}
+
+func (r *rfc5780Responder) serve(i, j int) {
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := r.conns[i][j].ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 20 || binary.BigEndian.Uint16(buf[0:2]) != 0x0001 || binary.BigEndian.Uint32(buf[4:8]) != 0x2112A442 {
+			continue
+		}
+
+		var transactionID [12]byte
+		copy(transactionID[:], buf[8:20])
+
+		// CHANGE-REQUEST escolhe o socket que responde; RESPONSE-PORT, a porta de destino
+		ri, rj := i, j
+		dst := src
+		body := buf[20:n]
+		for len(body) >= 4 {
+			attrType := binary.BigEndian.Uint16(body[0:2])
+			attrLen := int(binary.BigEndian.Uint16(body[2:4]))
+			if 4+attrLen > len(body) {
+				break
+			}
+			value := body[4 : 4+attrLen]
+			switch {
+			case attrType == 0x0003 && attrLen == 4:
+				if value[3]&0x04 != 0 {
+					ri = 1 - i
+				}
+				if value[3]&0x02 != 0 {
+					rj = 1 - j
+				}
+			case attrType == 0x0027 && attrLen == 4:
+				dst = &net.UDPAddr{IP: src.IP, Port: int(binary.BigEndian.Uint16(value))}
+			}
+			body = body[4+(attrLen+3)&^3:]
+		}
+
+		msg := stunBindingResponse(transactionID, src, false)
+		msg = appendSTUNAddress(msg, 0x802b, r.addr(ri, rj))
+		msg = appendSTUNAddress(msg, 0x802c, r.addr(1-i, 1-j))
+		r.conns[ri][rj].WriteToUDP(msg, dst)
+	}
+}
+
+// appendSTUNAddress acrescenta um atributo de endereço IPv4 sem XOR e corrige o tamanho da mensagem
+func appendSTUNAddress(msg []byte, attrType uint16, addr *net.UDPAddr) []byte {
+	msg = binary.BigEndian.AppendUint16(msg, attrType)
+	msg = binary.BigEndian.AppendUint16(msg, 8)
+	msg = append(msg, 0, 0x01)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(addr.Port))
+	msg = append(msg, addr.IP.To4()...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-20))
+	return msg
+}
+
+// TestNATBehaviorDiscovery verifica os testes RFC 5780 por trás de cada tipo de NAT simulado
+// TestNATBehaviorDiscovery checks the RFC 5780 tests behind every simulated NAT type
+// TestNATBehaviorDiscovery verifica las pruebas RFC 5780 detrás de cada tipo de NAT simulado
+func TestNATBehaviorDiscovery(t *testing.T) {
+	responder := newRFC5780Responder(t)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{responder.server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+
+	cases := []struct {
+		simulated   nattraversal.NATSimulatorType
+		mapping     nattraversal.NATBehaviorType
+		filtering   nattraversal.NATBehaviorType
+		natType     nattraversal.NATType
+		hairpinning bool
+	}{
+		{nattraversal.SimulateFullCone, nattraversal.BehaviorEndpointIndependent, nattraversal.BehaviorEndpointIndependent, nattraversal.NATFullCone, true},
+		{nattraversal.SimulateRestrictedCone, nattraversal.BehaviorEndpointIndependent, nattraversal.BehaviorAddressDependent, nattraversal.NATRestrictedCone, true},
+		{nattraversal.SimulatePortRestrictedCone, nattraversal.BehaviorEndpointIndependent, nattraversal.BehaviorAddressPortDependent, nattraversal.NATPortRestricted, true},
+		{nattraversal.SimulateSymmetric, nattraversal.BehaviorAddressPortDependent, nattraversal.BehaviorAddressPortDependent, nattraversal.NATSymmetric, false},
+	}
+
+	for _, c := range cases {
+		simulator, err := nattraversal.NewNATSimulator(c.simulated, "127.0.0.3", "10.0.0.0/24")
+		if err != nil {
+			t.Fatalf("Falha ao criar simulador: %v", err)
+		}
+
+		behavior, err := client.DiscoverBehavior(simulator.ListenPacket, responder.server())
+		simulator.Stop()
+		if err != nil {
+			t.Fatalf("Tipo %d: testes de comportamento falharam: %v", c.simulated, err)
+		}
+
+		if !behavior.NATPresent || !behavior.Mapped.IP.Equal(net.IPv4(127, 0, 0, 3)) {
+			t.Errorf("Tipo %d: NAT não detectado, mapeado %s", c.simulated, behavior.Mapped)
+		}
+		if behavior.Mapping != c.mapping || behavior.Filtering != c.filtering {
+			t.Errorf("Tipo %d: mapeamento %s e filtragem %s, esperados %s e %s",
+				c.simulated, behavior.Mapping, behavior.Filtering, c.mapping, c.filtering)
+		}
+		if behavior.NATType() != c.natType {
+			t.Errorf("Tipo %d: classificado como %s, esperado %s", c.simulated, behavior.NATType(), c.natType)
+		}
+		if behavior.Hairpinning != c.hairpinning {
+			t.Errorf("Tipo %d: hairpinning %v, esperado %v", c.simulated, behavior.Hairpinning, c.hairpinning)
+		}
+	}
+
+	// Sem NAT, o endereço mapeado é o próprio endereço local
+	behavior, err := client.DiscoverBehavior(nattraversal.ListenUDP, responder.server())
+	if err != nil {
+		t.Fatalf("Testes de comportamento sem NAT falharam: %v", err)
+	}
+	if behavior.NATType() != nattraversal.NATOpen {
+		t.Errorf("Rede sem NAT classificada como %s", behavior.NATType())
+	}
+
+	// O diagnóstico usa os mesmos testes quando o servidor suporta RFC 5780
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulateRestrictedCone, "127.0.0.3", "10.0.1.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+
+	diagnostic := nattraversal.NewNATDiagnostic([]nattraversal.STUNServer{responder.server()})
+	diagnostic.SetPacketListener(simulator.ListenPacket)
+	diagnostic.SetRetransmission(20*time.Millisecond, 3)
+	result, err := diagnostic.RunDiagnosis()
+	if err != nil {
+		t.Fatalf("Diagnóstico falhou: %v", err)
+	}
+	if result.NATType != nattraversal.NATRestrictedCone || result.ReachableTests[0] || !result.ReachableTests[1] {
+		t.Errorf("Diagnóstico incorreto: %+v", result)
+	}
+}
+
+// TestMappingLifetimeMeasurement verifica a medição do tempo de vida de um mapeamento ocioso
+// TestMappingLifetimeMeasurement checks the measurement of an idle mapping lifetime
+// TestMappingLifetimeMeasurement verifica la medición del tiempo de vida de un mapeo inactivo
+func TestMappingLifetimeMeasurement(t *testing.T) {
+	responder := newRFC5780Responder(t)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{responder.server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+	simulator.SetMappingTimeout(300 * time.Millisecond)
+
+	// Ociosidades de 50, 100 e 200ms sobrevivem e 400ms passa do tempo limite do NAT; a
+	// busca binária entre 200 e 400ms chega a 12,5ms do limite
+	lifetime, err := client.MeasureMappingLifetime(simulator.ListenPacket, responder.server(),
+		50*time.Millisecond, 1600*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("Medição falhou: %v", err)
+	}
+	if lifetime < 250*time.Millisecond || lifetime > 300*time.Millisecond {
+		t.Errorf("Tempo de vida medido %s, esperado entre 250ms e 300ms", lifetime)
+	}
+
+	// O NAT traversal mede em segundo plano e preenche NATInfo.MappingLifetime
+	simulator.SetMappingTimeout(1500 * time.Millisecond)
+	traversal := nattraversal.NewNATTraversal(0)
+	traversal.SetSTUNServers([]nattraversal.STUNServer{responder.server()})
+	traversal.SetSTUNRetransmission(20*time.Millisecond, 3)
+	traversal.SetPacketListener(simulator.ListenPacket)
+	traversal.SetMappingProbe(time.Second, 2*time.Second)
+	traversal.Refresh()
+
+	info := traversal.GetNATInfo()
+	if info.Type != "port-restricted" || !info.Hairpinning {
+		t.Errorf("NAT detectado incorretamente: %+v", info)
+	}
+	// Sondas de 1s, 2s, 1,5s e 1,25s: o mapeamento dura 1,25s, arredondado para 1s
+	if !waitFor(10*time.Second, func() bool { return traversal.GetNATInfo().MappingLifetime == 1 }) {
+		t.Errorf("Tempo de vida não medido: %ds", traversal.GetNATInfo().MappingLifetime)
+	}
+}
@@ -0,0 +1,323 @@
+package unit_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// newSimulatorTarget abre um socket fora do NAT para receber o tráfego dos hosts simulados
+func newSimulatorTarget(t *testing.T) *net.UDPConn {
+	t.Helper()
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao criar destino: %v", err)
+	}
+	t.Cleanup(func() { target.Close() })
+	return target
+}
+
+// expectNoPacket verifica que nenhum pacote chega ao socket dentro do prazo
+func expectNoPacket(t *testing.T, conn net.PacketConn, wait time.Duration) {
+	t.Helper()
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	if n, src, err := conn.ReadFrom(buffer); err == nil {
+		t.Fatalf("Pacote inesperado %q de %s", buffer[:n], src)
+	}
+}
+
+// TestNATSimulatorPortAllocation verifica as estratégias de alocação de portas externas pelas
+// portas observadas fora do NAT e pela tabela de mapeamentos
+// TestNATSimulatorPortAllocation checks the external port allocation strategies through the
+// ports observed outside the NAT and the mapping table
+// TestNATSimulatorPortAllocation verifica las estrategias de asignación de puertos externos
+// por los puertos observados fuera del NAT y la tabla de mapeos
+func TestNATSimulatorPortAllocation(t *testing.T) {
+	target := newSimulatorTarget(t)
+
+	cases := []struct {
+		name      string
+		configure func(*nattraversal.NATSimulator)
+		check     func(first, second int) error
+	}{
+		{"sequencial", func(s *nattraversal.NATSimulator) { s.SetPortAllocation(24000, 2) }, func(first, second int) error {
+			if first != 24000 || second != 24002 {
+				return fmt.Errorf("portas %d e %d, esperadas 24000 e 24002", first, second)
+			}
+			return nil
+		}},
+		{"preservando", func(s *nattraversal.NATSimulator) { s.SetPortAllocationStrategy(nattraversal.PortAllocationPreserving) }, func(first, second int) error {
+			// Os dois hosts usam a mesma porta interna; o segundo encontra a porta ocupada
+			if first != 40000 || second == 40000 {
+				return fmt.Errorf("portas %d e %d, esperada 40000 só na primeira", first, second)
+			}
+			return nil
+		}},
+		{"aleatória", func(s *nattraversal.NATSimulator) { s.SetPortAllocationStrategy(nattraversal.PortAllocationRandom) }, func(first, second int) error {
+			if first == second {
+				return fmt.Errorf("portas repetidas %d", first)
+			}
+			return nil
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.5.0/24")
+			if err != nil {
+				t.Fatalf("Falha ao criar simulador: %v", err)
+			}
+			defer simulator.Stop()
+			tc.configure(simulator)
+
+			var ports []int
+			for i := 0; i < 2; i++ {
+				conn, _ := simulator.ListenPacket()
+				defer conn.Close()
+				conn.WriteTo([]byte("ping"), target.LocalAddr())
+				_, src := readPacket(t, target)
+				ports = append(ports, src.(*net.UDPAddr).Port)
+			}
+			if err := tc.check(ports[0], ports[1]); err != nil {
+				t.Fatal(err)
+			}
+
+			mappings := simulator.Mappings()
+			if len(mappings) != 2 {
+				t.Fatalf("Esperados 2 mapeamentos, obtidos %d", len(mappings))
+			}
+			for i, mapping := range mappings {
+				if mapping.External.Port != ports[i] || !mapping.External.IP.Equal(net.IPv4(127, 0, 0, 3)) ||
+					len(mapping.Destinations) != 1 || mapping.Destinations[0] != target.LocalAddr().String() {
+					t.Errorf("Mapeamento %d incorreto: %+v", i, mapping)
+				}
+			}
+		})
+	}
+}
+
+// TestNATSimulatorHairpinning verifica a entrega entre hosts do mesmo NAT pelo endereço externo,
+// com e sem hairpinning, e a detecção correspondente
+// TestNATSimulatorHairpinning checks delivery between hosts of the same NAT through the external
+// address, with and without hairpinning, and the matching detection
+// TestNATSimulatorHairpinning verifica la entrega entre hosts del mismo NAT por la dirección
+// externa, con y sin hairpinning, y la detección correspondiente
+func TestNATSimulatorHairpinning(t *testing.T) {
+	service := newRFC5780Service(t)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{service.Server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+	target := newSimulatorTarget(t)
+
+	for _, enabled := range []bool{true, false} {
+		simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulateFullCone, "127.0.0.3", "10.0.6.0/24")
+		if err != nil {
+			t.Fatalf("Falha ao criar simulador: %v", err)
+		}
+		simulator.SetHairpinning(enabled)
+
+		// A abre um mapeamento; B, na mesma rede, envia ao endereço externo de A
+		alice, _ := simulator.ListenPacket()
+		bob, _ := simulator.ListenPacket()
+		alice.WriteTo([]byte("abre"), target.LocalAddr())
+		bob.WriteTo([]byte("abre"), target.LocalAddr())
+		_, externalA := readPacket(t, target)
+		_, externalB := readPacket(t, target)
+		if alice.LocalAddr().String() != "10.0.6.2:40000" {
+			externalA, externalB = externalB, externalA
+		}
+
+		bob.WriteTo([]byte("hairpin"), externalA)
+		if enabled {
+			data, src := readPacket(t, alice)
+			if string(data) != "hairpin" || src.String() != externalB.String() {
+				t.Errorf("A recebeu %q de %s, esperado hairpin do endereço externo de B %s", data, src, externalB)
+			}
+		} else {
+			expectNoPacket(t, alice, 200*time.Millisecond)
+		}
+
+		behavior, err := client.DiscoverBehavior(simulator.ListenPacket, service.Server())
+		alice.Close()
+		bob.Close()
+		simulator.Stop()
+		if err != nil {
+			t.Fatalf("Testes de comportamento falharam: %v", err)
+		}
+		if behavior.Hairpinning != enabled {
+			t.Errorf("Hairpinning detectado %v, configurado %v", behavior.Hairpinning, enabled)
+		}
+	}
+}
+
+// TestNATSimulatorMappingTimeout verifica que o mapeamento ocioso expira, some da tabela e
+// deixa de aceitar respostas, e que o próximo envio abre outro
+// TestNATSimulatorMappingTimeout checks that the idle mapping expires, leaves the table and
+// stops accepting replies, and that the next send opens another one
+// TestNATSimulatorMappingTimeout verifica que el mapeo inactivo expira, sale de la tabla y
+// deja de aceptar respuestas, y que el próximo envío abre otro
+func TestNATSimulatorMappingTimeout(t *testing.T) {
+	target := newSimulatorTarget(t)
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "10.0.7.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+	simulator.SetMappingTimeout(200 * time.Millisecond)
+	simulator.SetPortAllocation(25000, 1)
+
+	conn, _ := simulator.ListenPacket()
+	defer conn.Close()
+	conn.WriteTo([]byte("ping"), target.LocalAddr())
+	_, external := readPacket(t, target)
+
+	// Dentro do tempo limite, a resposta chega
+	target.WriteTo([]byte("pong"), external)
+	if data, _ := readPacket(t, conn); string(data) != "pong" {
+		t.Fatalf("Resposta %q, esperado pong", data)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	target.WriteTo([]byte("tarde"), external)
+	expectNoPacket(t, conn, 200*time.Millisecond)
+	if mappings := simulator.Mappings(); len(mappings) != 0 {
+		t.Fatalf("Mapeamento expirado continua na tabela: %+v", mappings)
+	}
+
+	conn.WriteTo([]byte("ping"), target.LocalAddr())
+	_, renewed := readPacket(t, target)
+	if renewed.(*net.UDPAddr).Port != 25001 {
+		t.Errorf("Novo mapeamento em %s, esperada a porta seguinte 25001", renewed)
+	}
+}
+
+// TestNATSimulatorImpairments verifica a perda de pacotes e o atraso com variação
+// TestNATSimulatorImpairments checks packet loss and delay with jitter
+// TestNATSimulatorImpairments verifica la pérdida de paquetes y el retardo con variación
+func TestNATSimulatorImpairments(t *testing.T) {
+	target := newSimulatorTarget(t)
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulateFullCone, "127.0.0.3", "10.0.8.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+
+	conn, _ := simulator.ListenPacket()
+	defer conn.Close()
+
+	// Metade dos pacotes se perde
+	simulator.SetPacketLoss(0.5)
+	const sent = 400
+	for i := 0; i < sent; i++ {
+		conn.WriteTo([]byte("x"), target.LocalAddr())
+	}
+	received := 0
+	buffer := make([]byte, 64)
+	for {
+		target.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, _, err := target.ReadFrom(buffer); err != nil {
+			break
+		}
+		received++
+	}
+	if received < sent/4 || received > sent*3/4 {
+		t.Errorf("Recebidos %d de %d pacotes com 50%% de perda", received, sent)
+	}
+
+	// Cada travessia atrasa entre 60 e 140ms; ida e volta passam duas vezes pelo NAT
+	simulator.SetPacketLoss(0)
+	simulator.SetLatency(100*time.Millisecond, 40*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		conn.WriteTo([]byte("ping"), target.LocalAddr())
+		_, external := readPacket(t, target)
+		oneWay := time.Since(start)
+		target.WriteTo([]byte("pong"), external)
+		readPacket(t, conn)
+		rtt := time.Since(start)
+
+		if oneWay < 60*time.Millisecond || oneWay > 200*time.Millisecond || rtt < 120*time.Millisecond {
+			t.Errorf("Atraso de ida %s e ida e volta %s fora do configurado", oneWay, rtt)
+		}
+	}
+}
+
+// TestChainedNATSimulator monta dois roteadores domésticos atrás do mesmo CGNAT e verifica o
+// comportamento combinado, as tabelas de mapeamento dos dois níveis e o tráfego entre os
+// roteadores pela rede do CGNAT
+// TestChainedNATSimulator builds two home routers behind the same CGNAT and checks the combined
+// behavior, the mapping tables of both levels and traffic between the routers over the CGNAT network
+// TestChainedNATSimulator monta dos routers domésticos detrás del mismo CGNAT y verifica el
+// comportamiento combinado, las tablas de mapeo de los dos niveles y el tráfico entre los routers
+func TestChainedNATSimulator(t *testing.T) {
+	service := newRFC5780Service(t)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{service.Server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+	target := newSimulatorTarget(t)
+
+	cgnat, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.3", "100.64.0.0/16")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer cgnat.Stop()
+	homeA, err := nattraversal.NewChainedNATSimulator(nattraversal.SimulateSymmetric, cgnat, "192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar NAT encadeado: %v", err)
+	}
+	defer homeA.Stop()
+	homeB, err := nattraversal.NewChainedNATSimulator(nattraversal.SimulateFullCone, cgnat, "192.168.2.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar NAT encadeado: %v", err)
+	}
+	defer homeB.Stop()
+
+	if homeA.ExternalIP().String() != "100.64.0.2" || homeB.ExternalIP().String() != "100.64.0.3" {
+		t.Fatalf("IPs externos %s e %s, esperados hosts da rede do CGNAT", homeA.ExternalIP(), homeB.ExternalIP())
+	}
+
+	// Atrás dos dois NATs, o endereço público é o do CGNAT e o mapeamento é o do NAT simétrico
+	behavior, err := client.DiscoverBehavior(homeA.ListenPacket, service.Server())
+	if err != nil {
+		t.Fatalf("Testes de comportamento falharam: %v", err)
+	}
+	if !behavior.Mapped.IP.Equal(net.IPv4(127, 0, 0, 3)) || behavior.NATType() != nattraversal.NATSymmetric {
+		t.Errorf("Comportamento combinado incorreto: mapeado %s, tipo %s", behavior.Mapped, behavior.NATType())
+	}
+
+	// Um envio atravessa os dois níveis: o mapeamento do roteador é o host interno do CGNAT
+	hostB, _ := homeB.ListenPacket()
+	defer hostB.Close()
+	hostB.WriteTo([]byte("ping"), target.LocalAddr())
+	_, public := readPacket(t, target)
+
+	home := homeB.Mappings()
+	if len(home) != 1 || home[0].Internal.String() != hostB.LocalAddr().String() || !home[0].External.IP.Equal(homeB.ExternalIP()) {
+		t.Fatalf("Tabela do roteador B incorreta: %+v", home)
+	}
+	var carrier *nattraversal.NATMappingInfo
+	for _, mapping := range cgnat.Mappings() {
+		if mapping.Internal.String() == home[0].External.String() {
+			found := mapping
+			carrier = &found
+		}
+	}
+	if carrier == nil || carrier.External.String() != public.String() {
+		t.Fatalf("CGNAT sem o mapeamento de %s para %s: %+v", home[0].External, public, cgnat.Mappings())
+	}
+
+	// Um host atrás de A alcança B pelo endereço de B na rede do CGNAT, sem sair para a rede
+	hostA, _ := homeA.ListenPacket()
+	defer hostA.Close()
+	hostA.WriteTo([]byte("vizinho"), home[0].External)
+	data, src := readPacket(t, hostB)
+	if string(data) != "vizinho" || !src.(*net.UDPAddr).IP.Equal(homeA.ExternalIP()) {
+		t.Fatalf("B recebeu %q de %s, esperado vizinho do roteador A", data, src)
+	}
+	hostB.WriteTo([]byte("resposta"), src)
+	if data, _ := readPacket(t, hostA); string(data) != "resposta" {
+		t.Errorf("A recebeu %q, esperado resposta", data)
+	}
+}
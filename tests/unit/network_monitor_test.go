@@ -0,0 +1,96 @@
+package unit_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/p2p-vpn/p2p-vpn/platform"
+)
+
+// TestNetworkMonitorDebounce verifica que uma rajada de eventos gera uma única reação
+// TestNetworkMonitorDebounce checks that a burst of events triggers a single reaction
+// TestNetworkMonitorDebounce verifica que una ráfaga de eventos genera una única reacción
+func TestNetworkMonitorDebounce(t *testing.T) {
+	monitor := platform.NewNetworkMonitor(50 * time.Millisecond)
+
+	var mutex sync.Mutex
+	var changes []platform.NetworkChange
+	monitor.Subscribe(func(change platform.NetworkChange) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, change)
+	})
+	count := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(changes)
+	}
+
+	monitor.Notify("endereço removido: 192.168.1.5/24")
+	monitor.Notify("rota removida: default via 192.168.1.1")
+	monitor.Notify("endereço adicionado: 172.20.10.2/28")
+
+	if !waitFor(time.Second, func() bool { return count() == 1 }) {
+		t.Fatalf("Esperada uma reação, obtidas %d", count())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if count() != 1 {
+		t.Errorf("Rajada deveria gerar uma única reação, obtidas %d", count())
+	}
+	if len(changes[0].Events) != 3 {
+		t.Errorf("Eventos não agrupados: %v", changes[0].Events)
+	}
+	if events := monitor.Events(); len(events) != 1 || len(events[0].Events) != 3 {
+		t.Errorf("Histórico incorreto: %+v", events)
+	}
+
+	// Eventos contínuos não adiam a reação indefinidamente
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) && count() == 1 {
+		monitor.Notify("interface wlan0: up")
+		time.Sleep(20 * time.Millisecond)
+	}
+	if count() < 2 {
+		t.Error("Eventos contínuos deveriam gerar uma reação após o atraso máximo")
+	}
+}
+
+// TestHandleNetworkChangeAnnounces verifica que uma mudança de rede é repassada aos backends
+// que dependem das interfaces e anuncia o nó em todos os backends
+// TestHandleNetworkChangeAnnounces checks that a network change reaches the backends that depend
+// on the interfaces and announces the node on every backend
+// TestHandleNetworkChangeAnnounces verifica que un cambio de red llega a los backends que dependen
+// de las interfaces y anuncia el nodo en todos los backends
+func TestHandleNetworkChangeAnnounces(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+
+	service, err := discovery.NewPeerDiscovery(host.config, freeUDPPort(t), host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	backend := newFakeBackend()
+	service.AddBackend(backend)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	monitor := platform.NewNetworkMonitor(20 * time.Millisecond)
+	monitor.Subscribe(func(change platform.NetworkChange) {
+		service.HandleNetworkChange(nil)
+	})
+	monitor.Notify("endereço adicionado: 172.20.10.2/28")
+
+	select {
+	case <-backend.networkChanges:
+	case <-time.After(time.Second):
+		t.Error("Mudança de rede não foi repassada ao backend")
+	}
+	select {
+	case <-backend.announced:
+	case <-time.After(time.Second):
+		t.Error("Mudança de rede não gerou novo anúncio")
+	}
+}
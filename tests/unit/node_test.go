@@ -0,0 +1,204 @@
+package unit_test
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/p2p-vpn/p2p-vpn/node"
+)
+
+// TestApplyTrustPolicy verifica que a política de vpn.access da configuração de segurança é
+// aplicada à descoberta, e que sem o arquivo a política padrão é mantida
+// TestApplyTrustPolicy checks that the vpn.access policy from the security configuration is
+// applied to discovery, and that the default policy is kept without the file
+// TestApplyTrustPolicy verifica que la política de vpn.access de la configuración de seguridad
+// se aplica al descubrimiento, y que sin el archivo se mantiene la política predeterminada
+func TestApplyTrustPolicy(t *testing.T) {
+	dir := t.TempDir()
+	securityPath := filepath.Join(dir, "security.yaml")
+	content := fmt.Sprintf(`web:
+  auth:
+    users_file: %q
+vpn:
+  access:
+    trusted_ips: ["192.0.2.0/24"]
+`, filepath.Join(dir, "users.json"))
+	if err := os.WriteFile(securityPath, []byte(content), 0600); err != nil {
+		t.Fatalf("Falha ao gravar configuração de segurança: %v", err)
+	}
+
+	start := func(securityPath string) (*fakeVPN, *net.UDPAddr) {
+		host := newFakeVPN(t, "host", "10.0.0.1")
+		port := freeUDPPort(t)
+		service, err := discovery.NewPeerDiscovery(host.config, port, host)
+		if err != nil {
+			t.Fatalf("Falha ao criar descoberta: %v", err)
+		}
+		if _, err := node.ApplyTrustPolicy(service, securityPath); err != nil {
+			t.Fatalf("Falha ao aplicar a política: %v", err)
+		}
+		if err := service.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar descoberta: %v", err)
+		}
+		t.Cleanup(func() { service.Stop() })
+		return host, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	}
+
+	// O loopback está fora dos IPs confiáveis da configuração
+	restricted, addr := start(securityPath)
+	peer := newFakeVPN(t, "peer", "10.0.0.2")
+	announceTo(t, peer, addr)
+	if waitFor(300*time.Millisecond, func() bool { return restricted.hasPeer(peer.config.PublicKey) }) {
+		t.Error("Anúncio de origem fora dos IPs confiáveis deveria ser descartado")
+	}
+
+	// Sem o arquivo, qualquer origem é aceita
+	open, addr := start(filepath.Join(dir, "ausente.yaml"))
+	announceTo(t, peer, addr)
+	if !waitFor(2*time.Second, func() bool { return open.hasPeer(peer.config.PublicKey) }) {
+		t.Error("Sem configuração de segurança, a política padrão deveria aceitar o anúncio")
+	}
+}
+
+// TestNATFlags verifica que as opções de NAT aceitam listas separadas por vírgula ou repetidas
+// e só substituem na configuração os valores informados
+// TestNATFlags checks that NAT options accept comma-separated or repeated lists and only
+// override the values given
+// TestNATFlags verifica que las opciones de NAT aceptan listas separadas por comas o repetidas
+// y solo sustituyen en la configuración los valores indicados
+func TestNATFlags(t *testing.T) {
+	flags := flag.NewFlagSet("nat", flag.ContinueOnError)
+	natFlags := node.RegisterNATFlags(flags)
+	err := flags.Parse([]string{
+		"-stun-servers", "a.example:3478,b.example:3478",
+		"-stun-servers", "c.example:3478",
+		"-turn-servers", "user:pass@turn.example:3478",
+		"-relay-listen", ":3479",
+	})
+	if err != nil {
+		t.Fatalf("Falha ao analisar opções: %v", err)
+	}
+
+	config := core.NATConfig{STUNServers: []string{"old.example:3478"}, STUNListen: ":3478"}
+	natFlags.Apply(&config)
+
+	expected := core.NATConfig{
+		STUNServers: []string{"a.example:3478", "b.example:3478", "c.example:3478"},
+		STUNListen:  ":3478",
+		TURNServers: []string{"user:pass@turn.example:3478"},
+		RelayListen: ":3479",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Configuração de NAT incorreta:\n obtida   %+v\n esperada %+v", config, expected)
+	}
+}
+
+// TestDiscoveryFlags verifica que a lista de bootstrap do DHT também ativa o DHT, que as listas
+// aceitam vírgulas ou repetição e que as opções de descoberta só substituem na configuração os
+// valores informados
+// TestDiscoveryFlags checks that the DHT bootstrap list also enables the DHT, that lists accept
+// commas or repetition and that the discovery options only override the values given
+// TestDiscoveryFlags verifica que la lista de bootstrap del DHT también activa el DHT y que las
+// opciones de descubrimiento solo sustituyen en la configuración los valores indicados
+func TestDiscoveryFlags(t *testing.T) {
+	flags := flag.NewFlagSet("discovery", flag.ContinueOnError)
+	discoveryFlags := node.RegisterDiscoveryFlags(flags)
+	err := flags.Parse([]string{
+		"-dht-bootstrap", "a.example:51821,b.example:51821",
+		"-backends", "static,dns",
+		"-backends", "rendezvous",
+		"-dns-domain", "vpn.example",
+		"-dns-server", "10.0.0.53:53",
+	})
+	if err != nil {
+		t.Fatalf("Falha ao analisar opções: %v", err)
+	}
+
+	config := core.DiscoveryConfig{DHTBootstrap: []string{"old.example:51821"}, Multicast: true}
+	discoveryFlags.Apply(&config)
+
+	expected := core.DiscoveryConfig{
+		Backends:     []string{"static", "dns", "rendezvous"},
+		DHT:          true,
+		DHTBootstrap: []string{"a.example:51821", "b.example:51821"},
+		Multicast:    true,
+		DNSDomain:    "vpn.example",
+		DNSServer:    "10.0.0.53:53",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Configuração de descoberta incorreta:\n obtida   %+v\n esperada %+v", config, expected)
+	}
+
+	// -dht sozinho ativa o DHT com os nós de bootstrap da configuração
+	flags = flag.NewFlagSet("discovery", flag.ContinueOnError)
+	discoveryFlags = node.RegisterDiscoveryFlags(flags)
+	if err := flags.Parse([]string{"-dht"}); err != nil {
+		t.Fatalf("Falha ao analisar opções: %v", err)
+	}
+	config = core.DiscoveryConfig{DHTBootstrap: []string{"old.example:51821"}}
+	discoveryFlags.Apply(&config)
+	if !config.DHT || !reflect.DeepEqual(config.DHTBootstrap, []string{"old.example:51821"}) {
+		t.Errorf("-dht deveria apenas ativar o DHT: %+v", config)
+	}
+}
+
+// TestSetupNAT verifica que uma configuração de NAT inválida é recusada antes de iniciar
+// qualquer serviço e que Stop encerra o servidor STUN embutido
+// TestSetupNAT checks that an invalid NAT configuration is refused before any service starts
+// and that Stop shuts down the embedded STUN server
+// TestSetupNAT verifica que una configuración de NAT inválida se rechaza antes de iniciar
+// cualquier servicio y que Stop detiene el servidor STUN embebido
+func TestSetupNAT(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	service, err := discovery.NewPeerDiscovery(host.config, freeUDPPort(t), host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+
+	invalid := map[string]core.NATConfig{
+		"servidor STUN sem porta":   {STUNServers: []string{"stun.example"}},
+		"protocolo desconhecido":    {PortMapping: []string{"xyz"}},
+		"servidor TURN sem senha":   {TURNServers: []string{"turn.example:3478"}},
+		"relay sem porta":           {RelayListen: "relay"},
+		"servidor STUN embutido":    {STUNListen: "stun"},
+		"endereço STUN alternativo": {STUNListen: "127.0.0.1:0", STUNAlternate: "alternativo"},
+	}
+	for name, natConfig := range invalid {
+		host.config.NAT = natConfig
+		if _, err := node.SetupNAT(host.config, 51820, host, service); err == nil {
+			t.Errorf("%s: configuração deveria ser recusada", name)
+		}
+	}
+
+	port := freeUDPPort(t)
+	host.config.NAT = core.NATConfig{
+		STUNServers: []string{fmt.Sprintf("127.0.0.1:%d", freeUDPPort(t))},
+		STUNListen:  fmt.Sprintf("127.0.0.1:%d", port),
+		PortMapping: []string{"none"},
+	}
+	nat, err := node.SetupNAT(host.config, freeUDPPort(t), host, service)
+	if err != nil {
+		t.Fatalf("Falha ao montar NAT: %v", err)
+	}
+
+	nat.Start()
+	if conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err == nil {
+		conn.Close()
+		t.Fatal("Servidor STUN embutido não foi iniciado")
+	}
+
+	nat.Stop()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("Stop deveria encerrar o servidor STUN embutido: %v", err)
+	}
+	conn.Close()
+}
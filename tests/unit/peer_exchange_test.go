@@ -0,0 +1,313 @@
+package unit_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeVPN implementa core.VPNProvider sem criar interfaces de rede
+type fakeVPN struct {
+	config     *core.Config
+	endpoints  map[string]string // Endpoints aplicados por UpdatePeerEndpoint
+	keepalives map[string]int    // Keepalives, em segundos, aplicados por UpdatePeerKeepalive
+	addErr     error             // Erro retornado por AddPeer, como uma falha do WireGuard
+	mutex      sync.Mutex
+}
+
+func newFakeVPN(t *testing.T, nodeID, virtualIP string) *fakeVPN {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("Falha ao gerar chave: %v", err)
+	}
+	publicKey := key.PublicKey()
+
+	return &fakeVPN{config: &core.Config{
+		NodeID:     nodeID,
+		PrivateKey: base64.StdEncoding.EncodeToString(key[:]),
+		PublicKey:  base64.StdEncoding.EncodeToString(publicKey[:]),
+		VirtualIP:  virtualIP,
+	}}
+}
+
+func (f *fakeVPN) Start() error    { return nil }
+func (f *fakeVPN) Stop() error     { return nil }
+func (f *fakeVPN) IsRunning() bool { return true }
+
+func (f *fakeVPN) AddPeer(peer core.TrustedPeer) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.addErr != nil {
+		return f.addErr
+	}
+	f.config.AddTrustedPeer(peer)
+	return nil
+}
+
+// failAddPeer faz AddPeer falhar com err até ser chamado com nil
+func (f *fakeVPN) failAddPeer(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.addErr = err
+}
+
+func (f *fakeVPN) RemovePeer(nodeID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.config.RemoveTrustedPeer(nodeID)
+	return nil
+}
+
+func (f *fakeVPN) UpdatePeerEndpoint(nodeID string, endpoint string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.endpoints == nil {
+		f.endpoints = make(map[string]string)
+	}
+	f.endpoints[nodeID] = endpoint
+	return nil
+}
+
+func (f *fakeVPN) UpdatePeerKeepalive(nodeID string, behindNAT bool, mappingLifetime time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.keepalives == nil {
+		f.keepalives = make(map[string]int)
+	}
+	f.keepalives[nodeID] = core.KeepaliveInterval(behindNAT, mappingLifetime)
+	return nil
+}
+
+// activeKeepalive retorna o último keepalive aplicado ao peer por UpdatePeerKeepalive e se
+// algum foi aplicado
+func (f *fakeVPN) activeKeepalive(nodeID string) (int, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	interval, ok := f.keepalives[nodeID]
+	return interval, ok
+}
+
+// trustedPeer retorna o nó como peer confiável, com a chave de assinatura fixada como em
+// 'peer add --signing-key'
+func (f *fakeVPN) trustedPeer() core.TrustedPeer {
+	signingKey, _ := f.config.SigningPublicKey()
+	return core.TrustedPeer{
+		NodeID:     f.config.NodeID,
+		PublicKey:  f.config.PublicKey,
+		VirtualIP:  f.config.VirtualIP,
+		SigningKey: signingKey,
+	}
+}
+
+// activeEndpoint retorna o último endpoint aplicado ao peer por UpdatePeerEndpoint
+func (f *fakeVPN) activeEndpoint(nodeID string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.endpoints[nodeID]
+}
+
+func (f *fakeVPN) GetConfig() *core.Config      { return f.config }
+func (f *fakeVPN) SaveConfig(path string) error { return nil }
+
+func (f *fakeVPN) TrustedPeers() []core.TrustedPeer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.config.CopyTrustedPeers()
+}
+
+func (f *fakeVPN) GetNodeInfo() (string, string, string) {
+	return f.config.NodeID, f.config.PublicKey, f.config.VirtualIP
+}
+
+// hasPeer verifica se o nó já configurou um peer com a chave informada a partir de um
+// anúncio, que registra quando o peer foi visto
+func (f *fakeVPN) hasPeer(publicKey string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, peer := range f.config.TrustedPeers {
+		if peer.PublicKey == publicKey && peer.LastSeen != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TestPeerExchangeConvergesFromBootstrap verifica se um único peer de bootstrap basta para formar a malha
+// TestPeerExchangeConvergesFromBootstrap checks that a single bootstrap peer is enough to form the mesh
+// TestPeerExchangeConvergesFromBootstrap verifica que un único par de arranque basta para formar la malla
+func TestPeerExchangeConvergesFromBootstrap(t *testing.T) {
+	port := freeUDPPort(t)
+	server, _ := discovery.NewRendezvousServer(port, nil)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar rendezvous: %v", err)
+	}
+	defer server.Stop()
+	rendezvous := fmt.Sprintf("%s@127.0.0.1:%d", server.PublicKey(), port)
+
+	bootstrap := newFakeVPN(t, "bootstrap", "10.0.0.1")
+	nodes := []*fakeVPN{bootstrap}
+	for i := 2; i <= 4; i++ {
+		node := newFakeVPN(t, fmt.Sprintf("node-%d", i), fmt.Sprintf("10.0.0.%d", i))
+		node.config.TrustedPeers = []core.TrustedPeer{bootstrap.trustedPeer()}
+		nodes = append(nodes, node)
+	}
+
+	for _, node := range nodes {
+		node.config.Discovery.RendezvousServers = []string{rendezvous}
+
+		peerDiscovery, err := discovery.NewPeerDiscovery(node.config, freeUDPPort(t), node)
+		if err != nil {
+			t.Fatalf("Falha ao criar descoberta: %v", err)
+		}
+		if err := peerDiscovery.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar descoberta: %v", err)
+		}
+		defer peerDiscovery.Stop()
+
+		// Dar tempo para o registro chegar ao rendezvous antes do próximo nó
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		missing := 0
+		for _, node := range nodes {
+			for _, other := range nodes {
+				if node != other && !node.hasPeer(other.config.PublicKey) {
+					missing++
+				}
+			}
+		}
+		if missing == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Malha não convergiu: %d ligações ausentes", missing)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestPeerExchangeDoesNotPinSigningKeys verifica que a chave de assinatura repassada por um
+// peer confiável via PEX não é fixada: o nó verdadeiro continua aceito ao anunciar
+// diretamente, e a chave repassada não serve para se passar por ele
+// TestPeerExchangeDoesNotPinSigningKeys checks that a signing key relayed by a trusted peer via
+// PEX is not pinned: the real node is still accepted when announcing directly, and the relayed
+// key cannot be used to impersonate it
+// TestPeerExchangeDoesNotPinSigningKeys verifica que la clave de firma reenviada por un par
+// confiable vía PEX no se fija: el nodo verdadero sigue aceptado al anunciar directamente, y la
+// clave reenviada no sirve para suplantarlo
+func TestPeerExchangeDoesNotPinSigningKeys(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	gossip := newFakeVPN(t, "gossip", "10.0.0.2")
+	victim := newFakeVPN(t, "victim", "10.0.0.3")
+	host.config.TrustedPeers = []core.TrustedPeer{gossip.trustedPeer()}
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	// O peer confiável repassa a vítima com uma chave de assinatura que ele controla
+	attackerPublic, attackerKey, _ := ed25519.GenerateKey(rand.Reader)
+	gossipKey, _ := gossip.config.SigningKey()
+	data, err := discovery.EncodeMessage(discovery.MsgPeerExchange, discovery.PeerExchange{Peers: []discovery.PeerRecord{{
+		NodeID:        victim.config.NodeID,
+		PublicKey:     victim.config.PublicKey,
+		VirtualIP:     victim.config.VirtualIP,
+		DiscoveryAddr: "127.0.0.1:9",
+		SigningKey:    base64.StdEncoding.EncodeToString(attackerPublic),
+		LastSeen:      time.Now().Unix(),
+	}}}, gossipKey)
+	if err != nil {
+		t.Fatalf("Falha ao codificar resumo de peers: %v", err)
+	}
+	conn, err := net.DialUDP("udp4", nil, hostAddr)
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Falha ao enviar resumo de peers: %v", err)
+	}
+	if !waitFor(2*time.Second, func() bool {
+		for _, peer := range service.Peers() {
+			if peer.NodeID == victim.config.NodeID {
+				return peer.SigningKey == ""
+			}
+		}
+		return false
+	}) {
+		t.Fatal("Peer repassado via PEX deveria ser guardado sem chave de assinatura")
+	}
+
+	// A chave repassada não toma o lugar da vítima depois que ela anuncia diretamente
+	announceTo(t, victim, hostAddr)
+	if !waitFor(2*time.Second, func() bool { return host.hasPeer(victim.config.PublicKey) }) {
+		t.Fatal("Anúncio direto da vítima deveria ser aceito")
+	}
+	announceSigned(t, victim, attackerKey, hostAddr)
+	time.Sleep(200 * time.Millisecond)
+
+	signingKey, _ := victim.config.SigningPublicKey()
+	for _, peer := range service.Peers() {
+		if peer.NodeID == victim.config.NodeID && peer.SigningKey != signingKey {
+			t.Errorf("Chave de assinatura da vítima substituída: %s", peer.SigningKey)
+		}
+	}
+}
+
+// TestTrustedPeerRecordEndpoint verifica que os endpoints observados mantêm o configurado em
+// primeiro lugar, não se repetem e ficam limitados aos mais recentes
+// TestTrustedPeerRecordEndpoint checks that observed endpoints keep the configured one first,
+// are not repeated and are limited to the most recent ones
+// TestTrustedPeerRecordEndpoint verifica que los endpoints observados mantienen el configurado
+// en primer lugar, no se repiten y quedan limitados a los más recientes
+func TestTrustedPeerRecordEndpoint(t *testing.T) {
+	peer := core.TrustedPeer{NodeID: "peer-a", Endpoints: []string{"198.51.100.1:51820"}}
+
+	peer.RecordEndpoint("198.51.100.1:51820")
+	if len(peer.Endpoints) != 1 {
+		t.Fatalf("endpoint configurado duplicado: %v", peer.Endpoints)
+	}
+
+	for i := 0; i < 20; i++ {
+		peer.RecordEndpoint(fmt.Sprintf("203.0.113.%d:40000", i))
+	}
+	peer.RecordEndpoint("203.0.113.15:40000")
+
+	if len(peer.Endpoints) != 8 {
+		t.Fatalf("esperados 8 endpoints, obtidos %d: %v", len(peer.Endpoints), peer.Endpoints)
+	}
+	if peer.Endpoints[0] != "198.51.100.1:51820" {
+		t.Errorf("endpoint configurado perdido: %v", peer.Endpoints)
+	}
+	if last := peer.Endpoints[len(peer.Endpoints)-1]; last != "203.0.113.15:40000" {
+		t.Errorf("endpoint mais recente deveria ser o último, obtido %s", last)
+	}
+	seen := make(map[string]bool)
+	for _, ep := range peer.Endpoints {
+		if seen[ep] {
+			t.Errorf("endpoint repetido %s: %v", ep, peer.Endpoints)
+		}
+		seen[ep] = true
+	}
+	if seen["203.0.113.12:40000"] {
+		t.Errorf("endpoint antigo deveria ter sido descartado: %v", peer.Endpoints)
+	}
+}
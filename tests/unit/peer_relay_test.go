@@ -0,0 +1,341 @@
+package unit_test
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// relayMessage é um datagrama entregue pelo relay entre peers, com a chave do remetente
+type relayMessage struct {
+	source string
+	data   string
+}
+
+// newRelayKeys gera um par de chaves WireGuard (privada, pública) em base64
+func newRelayKeys(t *testing.T) (string, string) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("Falha ao gerar chave: %v", err)
+	}
+	public := key.PublicKey()
+	return base64.StdEncoding.EncodeToString(key[:]), base64.StdEncoding.EncodeToString(public[:])
+}
+
+// newPeerRelay inicia um relay que atende apenas as chaves informadas
+func newPeerRelay(t *testing.T, privateKey string, allowed ...string) *nattraversal.PeerRelayServer {
+	server, err := nattraversal.NewPeerRelayServer("127.0.0.1:0", privateKey)
+	if err != nil {
+		t.Fatalf("Falha ao criar o relay: %v", err)
+	}
+	server.SetAuthorizer(func(publicKey string) bool {
+		for _, key := range allowed {
+			if key == publicKey {
+				return true
+			}
+		}
+		return false
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar o relay: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+// newPeerRelayClient cria um cliente com retransmissões curtas que repassa as entregas ao canal
+func newPeerRelayClient(t *testing.T, address, serverKey, privateKey string) (*nattraversal.PeerRelayClient, chan relayMessage) {
+	client, err := nattraversal.NewPeerRelayClient(address, serverKey, privateKey)
+	if err != nil {
+		t.Fatalf("Falha ao criar cliente do relay: %v", err)
+	}
+	client.SetRetransmission(20*time.Millisecond, 3)
+	t.Cleanup(func() { client.Close() })
+
+	received := make(chan relayMessage, 8)
+	client.SetHandler(func(source string, data []byte) {
+		received <- relayMessage{source: source, data: string(data)}
+	})
+	return client, received
+}
+
+// expectRelayMessage espera uma entrega do relay
+func expectRelayMessage(t *testing.T, received chan relayMessage, source, data string) {
+	t.Helper()
+	select {
+	case message := <-received:
+		if message.source != source || message.data != data {
+			t.Errorf("Entrega inesperada: %q de %s, esperado %q de %s", message.data, message.source, data, source)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Entrega de %q não chegou pelo relay", data)
+	}
+}
+
+// TestPeerRelayForwarding verifica a autenticação pelas chaves WireGuard e o repasse de
+// datagramas entre dois nós conectados ao relay
+// TestPeerRelayForwarding checks authentication with WireGuard keys and forwarding of
+// datagrams between two nodes connected to the relay
+// TestPeerRelayForwarding verifica la autenticación con las claves WireGuard y el reenvío
+// de datagramas entre dos nodos conectados al relay
+func TestPeerRelayForwarding(t *testing.T) {
+	relayPrivate, relayPublic := newRelayKeys(t)
+	alicePrivate, alicePublic := newRelayKeys(t)
+	bobPrivate, bobPublic := newRelayKeys(t)
+	strangerPrivate, _ := newRelayKeys(t)
+
+	server := newPeerRelay(t, relayPrivate, alicePublic, bobPublic)
+	address := server.Addr().String()
+
+	// Chaves fora da lista e relays com outra chave não respondem ao HELLO
+	stranger, _ := newPeerRelayClient(t, address, relayPublic, strangerPrivate)
+	if err := stranger.Connect(); err == nil {
+		t.Error("Chave não autorizada foi aceita pelo relay")
+	}
+	_, otherPublic := newRelayKeys(t)
+	impostor, _ := newPeerRelayClient(t, address, otherPublic, alicePrivate)
+	if err := impostor.Connect(); err == nil {
+		t.Error("Cliente aceitou um relay com chave diferente da esperada")
+	}
+
+	alice, aliceReceived := newPeerRelayClient(t, address, relayPublic, alicePrivate)
+	bob, bobReceived := newPeerRelayClient(t, address, relayPublic, bobPrivate)
+	for _, client := range []*nattraversal.PeerRelayClient{alice, bob} {
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Falha ao conectar ao relay: %v", err)
+		}
+		if client.Transport() != nattraversal.PeerRelayTransportUDP || client.RTT() <= 0 || !client.Healthy() {
+			t.Errorf("Conexão UDP esperada, obtido %q (RTT %s)", client.Transport(), client.RTT())
+		}
+	}
+	if server.Clients() != 2 {
+		t.Errorf("Relay deveria ter 2 clientes, tem %d", server.Clients())
+	}
+
+	if err := alice.Send(bobPublic, []byte("handshake")); err != nil {
+		t.Fatalf("Falha ao enviar pelo relay: %v", err)
+	}
+	expectRelayMessage(t, bobReceived, alicePublic, "handshake")
+
+	if err := bob.Send(alicePublic, []byte("resposta")); err != nil {
+		t.Fatalf("Falha ao responder pelo relay: %v", err)
+	}
+	expectRelayMessage(t, aliceReceived, bobPublic, "resposta")
+
+	// Destinos desconectados são descartados em silêncio
+	bob.Close()
+	if err := alice.Send(bobPublic, []byte("perdido")); err != nil {
+		t.Errorf("Envio a destino desconectado não deveria falhar no cliente: %v", err)
+	}
+	if err := bob.Send(alicePublic, []byte("fechado")); err == nil {
+		t.Error("Cliente fechado não deveria enviar")
+	}
+}
+
+// TestPeerRelayTLSFallback verifica que, com o UDP bloqueado, o cliente se conecta por TLS
+// e troca datagramas com um cliente UDP
+// TestPeerRelayTLSFallback checks that with UDP blocked the client connects over TLS and
+// exchanges datagrams with a UDP client
+// TestPeerRelayTLSFallback verifica que, con UDP bloqueado, el cliente se conecta por TLS
+// e intercambia datagramas con un cliente UDP
+func TestPeerRelayTLSFallback(t *testing.T) {
+	relayPrivate, relayPublic := newRelayKeys(t)
+	alicePrivate, alicePublic := newRelayKeys(t)
+	bobPrivate, bobPublic := newRelayKeys(t)
+	server := newPeerRelay(t, relayPrivate, alicePublic, bobPublic)
+
+	// Um firewall que descarta o UDP e deixa passar o TCP até o relay
+	blackhole, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket UDP: %v", err)
+	}
+	defer blackhole.Close()
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: blackhole.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		t.Skipf("Porta TCP correspondente ocupada: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", server.Addr().String())
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() { io.Copy(upstream, conn); upstream.Close() }()
+			go func() { io.Copy(conn, upstream); conn.Close() }()
+		}
+	}()
+
+	alice, aliceReceived := newPeerRelayClient(t, blackhole.LocalAddr().String(), relayPublic, alicePrivate)
+	if err := alice.Connect(); err != nil {
+		t.Fatalf("Falha ao conectar por TLS: %v", err)
+	}
+	if alice.Transport() != nattraversal.PeerRelayTransportTLS {
+		t.Errorf("Transporte TLS esperado, obtido %q", alice.Transport())
+	}
+
+	bob, bobReceived := newPeerRelayClient(t, server.Addr().String(), relayPublic, bobPrivate)
+	if err := bob.Connect(); err != nil {
+		t.Fatalf("Falha ao conectar por UDP: %v", err)
+	}
+
+	alice.Send(bobPublic, []byte("via tls"))
+	expectRelayMessage(t, bobReceived, alicePublic, "via tls")
+	bob.Send(alicePublic, []byte("via udp"))
+	expectRelayMessage(t, aliceReceived, bobPublic, "via udp")
+
+	// A queda da conexão TLS desconecta o cliente
+	server.Stop()
+	if !waitFor(2*time.Second, func() bool { return alice.Transport() == "" }) {
+		t.Error("Cliente TLS deveria ficar desconectado quando o relay cai")
+	}
+	if err := alice.Send(bobPublic, []byte("depois")); err == nil {
+		t.Error("Envio sem conexão com o relay deveria falhar")
+	}
+}
+
+// relayNode é um nó da malha com NAT traversal e o socket que faz o papel do WireGuard
+type relayNode struct {
+	traversal *nattraversal.NATTraversal
+	vpn       *fakeVPN
+	wg        *net.UDPConn
+}
+
+func newRelayNode(t *testing.T, nodeID string, service *nattraversal.STUNService, listen nattraversal.PacketListener) *relayNode {
+	wg, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do WireGuard: %v", err)
+	}
+	t.Cleanup(func() { wg.Close() })
+
+	node := &relayNode{
+		traversal: nattraversal.NewNATTraversal(wg.LocalAddr().(*net.UDPAddr).Port),
+		vpn:       newFakeVPN(t, nodeID, "10.0.0.1"),
+		wg:        wg,
+	}
+	node.traversal.SetPortMappers()
+	node.traversal.SetSTUNServers([]nattraversal.STUNServer{service.Server()})
+	node.traversal.SetSTUNRetransmission(20*time.Millisecond, 3)
+	node.traversal.SetEndpointUpdater(node.vpn)
+	node.traversal.SetKeepaliveUpdater(node.vpn)
+	if listen != nil {
+		node.traversal.SetPacketListener(listen)
+	}
+	if err := node.traversal.SetRelayKey(node.vpn.config.PrivateKey); err != nil {
+		t.Fatalf("Chave do relay recusada: %v", err)
+	}
+	t.Cleanup(func() { node.traversal.Stop() })
+	return node
+}
+
+// TestPeerRelayFallback verifica que um nó sem NAT opera o relay e o anuncia, que os nós
+// atrás de NAT simétrico o escolhem como relay principal e que o tráfego WireGuard entre eles
+// passa pelo relay nos dois sentidos, com o caminho visível no estado dos peers
+// TestPeerRelayFallback checks that a node without NAT runs and announces the relay, that
+// nodes behind symmetric NAT pick it as home relay and that WireGuard traffic between them
+// crosses the relay in both directions, with the path visible in the peer status
+// TestPeerRelayFallback verifica que un nodo sin NAT opera el relay y lo anuncia, que los
+// nodos detrás de NAT simétrico lo eligen como relay principal y que el tráfico WireGuard
+// entre ellos cruza el relay en ambos sentidos, con el camino visible en el estado
+func TestPeerRelayFallback(t *testing.T) {
+	service := newRFC5780Service(t)
+
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulateSymmetric, "127.0.0.3", "10.0.3.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+
+	relay := newRelayNode(t, "node-r", service, nil)
+	alice := newRelayNode(t, "node-a", service, simulator.ListenPacket)
+	bob := newRelayNode(t, "node-b", service, simulator.ListenPacket)
+
+	relay.traversal.SetRelayService("127.0.0.1:0", func(publicKey string) bool {
+		return publicKey == alice.vpn.config.PublicKey || publicKey == bob.vpn.config.PublicKey
+	})
+	for _, node := range []*relayNode{relay, alice, bob} {
+		node.traversal.Refresh()
+		if err := node.traversal.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+		}
+	}
+	if info := alice.traversal.GetNATInfo(); info.Type != "symmetric" {
+		t.Fatalf("NAT simétrico esperado, detectado %q", info.Type)
+	}
+
+	// Sem NAT, o relay é alcançável e anunciado; os demais nós não operam relay
+	endpoint := relay.traversal.RelayEndpoint()
+	if host, _, err := net.SplitHostPort(endpoint); err != nil || host != "127.0.0.1" {
+		t.Fatalf("Relay sem NAT deveria ser anunciado, obtido %q", endpoint)
+	}
+	if alice.traversal.RelayEndpoint() != "" {
+		t.Error("Nó sem relay próprio não deveria anunciar relay")
+	}
+	info := nattraversal.PeerRelayInfo{Address: endpoint, PublicKey: relay.vpn.config.PublicKey}
+
+	// O nó do relay recebe pelo próprio relay; os demais escolhem o candidato anunciado
+	alice.traversal.AddRelayCandidates(info)
+	bob.traversal.AddRelayCandidates(info)
+	for _, node := range []*relayNode{relay, alice, bob} {
+		if !waitFor(3*time.Second, func() bool {
+			home, ok := node.traversal.HomeRelay()
+			return ok && home == info
+		}) {
+			home, _ := node.traversal.HomeRelay()
+			t.Fatalf("Relay principal esperado %v, obtido %v", info, home)
+		}
+	}
+
+	alice.traversal.SetPeerRelay("node-b", bob.vpn.config.PublicKey, info)
+	if err := alice.traversal.ConnectPeer("node-b", []string{"127.0.0.1:9"}); err != nil {
+		t.Fatalf("Falha ao conectar via relay entre peers: %v", err)
+	}
+
+	status := alice.traversal.RelayedPeers()["node-b"]
+	if status.Technique != nattraversal.TechniquePeerRelay || status.Relay != endpoint ||
+		status.Peer != bob.vpn.config.PublicKey || status.Transport != nattraversal.PeerRelayTransportUDP {
+		t.Errorf("Estado do relay inesperado: %+v", status)
+	}
+
+	proxy, err := net.ResolveUDPAddr("udp4", alice.vpn.activeEndpoint("node-b"))
+	if err != nil || !proxy.IP.IsLoopback() {
+		t.Fatalf("Endpoint WireGuard deveria ser o proxy local, obtido %q", alice.vpn.activeEndpoint("node-b"))
+	}
+
+	// WireGuard de A -> proxy -> relay -> proxy criado em B na chegada -> WireGuard de B
+	alice.wg.WriteToUDP([]byte("handshake"), proxy)
+	data, src := readUDP(t, bob.wg)
+	if string(data) != "handshake" || !src.IP.IsLoopback() {
+		t.Fatalf("WireGuard de B recebeu %q de %s, esperado handshake de um proxy local", data, src)
+	}
+
+	// A resposta volta pelo mesmo proxy, como o WireGuard faz ao adotar o endpoint
+	bob.wg.WriteToUDP([]byte("resposta"), src)
+	data, src = readUDP(t, alice.wg)
+	if string(data) != "resposta" || src.String() != proxy.String() {
+		t.Errorf("WireGuard de A recebeu %q de %s, esperado resposta do proxy %s", data, src, proxy)
+	}
+
+	// A parada devolve o peer ao endpoint configurado e desfaz o relay principal
+	alice.traversal.Stop()
+	if endpoint := alice.vpn.activeEndpoint("node-b"); endpoint != "" {
+		t.Errorf("Endpoint do relay deveria ser desfeito na parada, obtido %q", endpoint)
+	}
+	if _, ok := alice.traversal.HomeRelay(); ok {
+		t.Error("Relay principal deveria ser desfeito na parada")
+	}
+	if len(alice.traversal.RelayedPeers()) != 0 {
+		t.Error("Nenhum peer deveria constar em relay após a parada")
+	}
+}
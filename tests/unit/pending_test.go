@@ -0,0 +1,419 @@
+package unit_test
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// announceTo envia um anúncio assinado do nó diretamente para o endereço informado
+func announceTo(t *testing.T, node *fakeVPN, to *net.UDPAddr) {
+	key, err := node.config.SigningKey()
+	if err != nil {
+		t.Fatalf("Falha ao obter chave de assinatura: %v", err)
+	}
+	announceSigned(t, node, key, to)
+}
+
+// announceSigned envia o anúncio do nó assinado pela chave informada, como faria quem se
+// passa pelo nó
+func announceSigned(t *testing.T, node *fakeVPN, key ed25519.PrivateKey, to *net.UDPAddr) {
+	data, err := discovery.EncodeMessage(discovery.MsgAnnouncement, discovery.Announcement{
+		NodeID:     node.config.NodeID,
+		PublicKey:  node.config.PublicKey,
+		VirtualIP:  node.config.VirtualIP,
+		ListenPort: 51820,
+	}, key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar anúncio: %v", err)
+	}
+
+	conn, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Falha ao enviar anúncio: %v", err)
+	}
+}
+
+// signingPublicKey retorna a chave de assinatura da descoberta do nó em base64
+func signingPublicKey(t *testing.T, node *fakeVPN) string {
+	key, err := node.config.SigningPublicKey()
+	if err != nil {
+		t.Fatalf("Falha ao obter chave de assinatura: %v", err)
+	}
+	return key
+}
+
+// waitFor espera a condição se tornar verdadeira até o prazo
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return condition()
+}
+
+// TestPendingPeerApproval verifica que nós desconhecidos aguardam aprovação e que recusados são bloqueados
+// TestPendingPeerApproval checks that unknown nodes wait for approval and rejected ones are blocked
+// TestPendingPeerApproval verifica que los nodos desconocidos esperan aprobación y los rechazados se bloquean
+func TestPendingPeerApproval(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+
+	store := discovery.NewPendingStore(filepath.Join(t.TempDir(), "pending_peers.yaml"))
+	service.SetPendingStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	stranger := newFakeVPN(t, "stranger", "10.0.0.3")
+
+	announceTo(t, friend, hostAddr)
+	announceTo(t, stranger, hostAddr)
+
+	pendingCount := func() int {
+		pending, _ := store.List()
+		return len(pending)
+	}
+	if !waitFor(2*time.Second, func() bool { return pendingCount() == 2 }) {
+		t.Fatalf("Esperados 2 peers pendentes, obtido: %d", pendingCount())
+	}
+	if host.hasPeer(friend.config.PublicKey) || host.hasPeer(stranger.config.PublicKey) {
+		t.Fatal("Peer desconhecido foi configurado sem aprovação")
+	}
+
+	pending, _ := store.List()
+	for _, peer := range pending {
+		if peer.Fingerprint != discovery.Fingerprint(peer.PublicKey, peer.SigningKey) || peer.Fingerprint == "" {
+			t.Errorf("Impressão digital incorreta para %s: %q", peer.NodeID, peer.Fingerprint)
+		}
+		if peer.SourceAddr == "" || peer.FirstSeen == 0 {
+			t.Errorf("Origem ou primeiro contato ausentes para %s", peer.NodeID)
+		}
+	}
+
+	// Aprovar e recusar pela impressão digital; o ID não identifica o pedido
+	strangerFingerprint := discovery.Fingerprint(stranger.config.PublicKey, signingPublicKey(t, stranger))
+	if _, err := store.Reject("stranger"); !errors.Is(err, discovery.ErrPendingNotFound) {
+		t.Errorf("Recusa pelo ID deveria falhar com ErrPendingNotFound, obtido: %v", err)
+	}
+	if _, err := store.Approve(discovery.Fingerprint(friend.config.PublicKey, signingPublicKey(t, friend))); err != nil {
+		t.Fatalf("Falha ao aprovar: %v", err)
+	}
+	if _, err := store.Reject(strangerFingerprint); err != nil {
+		t.Fatalf("Falha ao recusar: %v", err)
+	}
+	if _, err := store.Approve(strangerFingerprint); !errors.Is(err, discovery.ErrPendingNotFound) {
+		t.Errorf("Esperado ErrPendingNotFound, obtido: %v", err)
+	}
+
+	announceTo(t, friend, hostAddr)
+	announceTo(t, stranger, hostAddr)
+
+	if !waitFor(2*time.Second, func() bool { return host.hasPeer(friend.config.PublicKey) }) {
+		t.Error("Peer aprovado não foi configurado no próximo anúncio")
+	}
+	if !store.IsBlocked(stranger.config.PublicKey, signingPublicKey(t, stranger)) {
+		t.Error("Chave recusada não foi bloqueada")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if host.hasPeer(stranger.config.PublicKey) || pendingCount() != 0 {
+		t.Error("Peer bloqueado voltou para a fila ou foi configurado")
+	}
+}
+
+// TestPendingApprovalSurvivesFailure verifica que a aprovação do administrador só é consumida
+// quando o peer é de fato configurado: uma falha ao adicioná-lo ao WireGuard a preserva para o
+// próximo anúncio, sem devolver o peer à fila
+// TestPendingApprovalSurvivesFailure checks that an approval is only consumed once the peer is
+// configured, so a WireGuard failure keeps it for the next announcement
+// TestPendingApprovalSurvivesFailure verifica que la aprobación solo se consume cuando el par se
+// configura, y que un fallo de WireGuard la conserva para el próximo anuncio
+func TestPendingApprovalSurvivesFailure(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	store := discovery.NewPendingStore(filepath.Join(t.TempDir(), "pending_peers.yaml"))
+	service.SetPendingStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	signingKey := signingPublicKey(t, friend)
+
+	announceTo(t, friend, hostAddr)
+	if !waitFor(2*time.Second, func() bool {
+		pending, _ := store.List()
+		return len(pending) == 1
+	}) {
+		t.Fatal("Peer desconhecido não entrou na fila")
+	}
+	if _, err := store.Approve(discovery.Fingerprint(friend.config.PublicKey, signingKey)); err != nil {
+		t.Fatalf("Falha ao aprovar: %v", err)
+	}
+
+	// O WireGuard recusa o peer: a aprovação continua valendo e o peer não volta para a fila
+	host.failAddPeer(errors.New("falha ao configurar o WireGuard"))
+	announceTo(t, friend, hostAddr)
+	time.Sleep(200 * time.Millisecond)
+	if host.hasPeer(friend.config.PublicKey) {
+		t.Fatal("Peer não deveria estar configurado")
+	}
+	if approved, err := store.FindApproved(friend.config.PublicKey, signingKey); err != nil || approved == nil {
+		t.Fatalf("Aprovação perdida depois da falha: %v", err)
+	}
+	if pending, _ := store.List(); len(pending) != 0 {
+		t.Fatalf("Peer aprovado voltou para a fila: %d", len(pending))
+	}
+
+	// No anúncio seguinte o peer é configurado e só então a aprovação é consumida
+	host.failAddPeer(nil)
+	announceTo(t, friend, hostAddr)
+	if !waitFor(2*time.Second, func() bool { return host.hasPeer(friend.config.PublicKey) }) {
+		t.Fatal("Peer aprovado não foi configurado depois da falha")
+	}
+	if !waitFor(time.Second, func() bool {
+		approved, _ := store.FindApproved(friend.config.PublicKey, signingKey)
+		return approved == nil
+	}) {
+		t.Error("Aprovação deveria ser consumida depois de configurar o peer")
+	}
+}
+
+// TestPendingIdentityConflict verifica que um nó que anuncia o ID e a chave WireGuard de outro
+// com a própria chave de assinatura gera um pedido separado, marcado como conflito, e que só a
+// impressão digital escolhe qual pedido aprovar
+// TestPendingIdentityConflict checks that a node announcing another node's ID and WireGuard key
+// with its own signing key creates a separate, conflicting request approved only by fingerprint
+// TestPendingIdentityConflict verifica que un nodo que anuncia el ID y la clave WireGuard de otro
+// con su propia clave de firma crea una solicitud separada, en conflicto, aprobada solo por huella
+func TestPendingIdentityConflict(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+
+	store := discovery.NewPendingStore(filepath.Join(t.TempDir(), "pending_peers.yaml"))
+	service.SetPendingStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	_, impostorKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Falha ao gerar chave: %v", err)
+	}
+
+	// O impostor anuncia primeiro; o anúncio verdadeiro não é descartado
+	announceSigned(t, friend, impostorKey, hostAddr)
+	if !waitFor(2*time.Second, func() bool { pending, _ := store.List(); return len(pending) == 1 }) {
+		t.Fatal("Anúncio do impostor não entrou na fila")
+	}
+	announceTo(t, friend, hostAddr)
+	if !waitFor(2*time.Second, func() bool { pending, _ := store.List(); return len(pending) == 2 }) {
+		t.Fatal("Anúncio verdadeiro deveria gerar outro pedido")
+	}
+
+	friendFingerprint := discovery.Fingerprint(friend.config.PublicKey, signingPublicKey(t, friend))
+	pending, _ := store.List()
+	if pending[0].Fingerprint == pending[1].Fingerprint {
+		t.Fatal("Pedidos com chaves de assinatura diferentes têm a mesma impressão digital")
+	}
+	for _, peer := range pending {
+		if !peer.Conflict {
+			t.Errorf("Pedido %s deveria ser marcado como conflito", peer.Fingerprint)
+		}
+	}
+
+	// O ID e a chave WireGuard não escolhem entre os pedidos
+	for _, id := range []string{"friend", friend.config.PublicKey} {
+		if _, err := store.Approve(id); !errors.Is(err, discovery.ErrPendingNotFound) {
+			t.Errorf("Aprovação por %q deveria falhar com ErrPendingNotFound, obtido: %v", id, err)
+		}
+	}
+
+	for _, peer := range pending {
+		if peer.Fingerprint == friendFingerprint {
+			continue
+		}
+		if _, err := store.Reject(peer.Fingerprint); err != nil {
+			t.Fatalf("Falha ao recusar o impostor: %v", err)
+		}
+	}
+	if _, err := store.Approve(friendFingerprint); err != nil {
+		t.Fatalf("Falha ao aprovar: %v", err)
+	}
+	if store.IsBlocked(friend.config.PublicKey, signingPublicKey(t, friend)) {
+		t.Fatal("Recusar o impostor bloqueou o nó verdadeiro")
+	}
+
+	announceTo(t, friend, hostAddr)
+	if !waitFor(2*time.Second, func() bool { return host.hasPeer(friend.config.PublicKey) }) {
+		t.Fatal("Nó aprovado pela impressão digital não foi configurado")
+	}
+	announceSigned(t, friend, impostorKey, hostAddr)
+	time.Sleep(100 * time.Millisecond)
+	if pending, _ := store.List(); len(pending) != 0 {
+		t.Errorf("Impostor recusado voltou para a fila: %d pedidos", len(pending))
+	}
+}
+
+// TestPendingStoreSharedFile verifica que os anúncios só alteram a memória até a gravação
+// agrupada e que as decisões de outro processo no mesmo arquivo são vistas pelo serviço
+// TestPendingStoreSharedFile checks that announcements only change memory until the batched
+// write and that decisions made by another process on the same file reach the service
+// TestPendingStoreSharedFile verifica que los anuncios solo cambian la memoria hasta la
+// escritura agrupada y que las decisiones de otro proceso en el mismo archivo llegan al servicio
+func TestPendingStoreSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_peers.yaml")
+	service := discovery.NewPendingStore(path)
+	admin := discovery.NewPendingStore(path)
+
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	signingKey := signingPublicKey(t, friend)
+	isNew, err := service.Add(discovery.PendingPeer{
+		NodeID:     "friend",
+		PublicKey:  friend.config.PublicKey,
+		VirtualIP:  "10.0.0.2",
+		SourceAddr: "127.0.0.1:51821",
+		SigningKey: signingKey,
+	})
+	if err != nil || !isNew {
+		t.Fatalf("Falha ao adicionar pedido: novo=%v erro=%v", isNew, err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Anúncio não deveria gravar o arquivo na hora: %v", err)
+	}
+	if pending, _ := admin.List(); len(pending) != 0 {
+		t.Fatalf("Pedido ainda não gravado apareceu para outro processo: %d", len(pending))
+	}
+
+	if err := service.Flush(); err != nil {
+		t.Fatalf("Falha ao gravar pedidos: %v", err)
+	}
+	pending, err := admin.List()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Pedido gravado não apareceu para outro processo: %d (%v)", len(pending), err)
+	}
+
+	if _, err := admin.Approve(pending[0].Fingerprint); err != nil {
+		t.Fatalf("Falha ao aprovar: %v", err)
+	}
+	approved, err := service.FindApproved(friend.config.PublicKey, signingKey)
+	if err != nil || approved == nil {
+		t.Fatalf("Aprovação de outro processo não chegou ao serviço: %v", err)
+	}
+	if pending, _ := service.List(); len(pending) != 0 {
+		t.Errorf("Pedido aprovado continua na fila do serviço: %d", len(pending))
+	}
+}
+
+// TestPinUnpinnedTrustedPeer verifica que o anúncio de um peer confiável configurado sem chave
+// de assinatura é recusado e fica na fila com a impressão digital, e que fixar a chave por ela
+// faz os próximos anúncios serem aceitos
+// TestPinUnpinnedTrustedPeer checks that a trusted peer configured without a signing key is
+// queued with its fingerprint and that pinning the key by fingerprint lets its announcements in
+// TestPinUnpinnedTrustedPeer verifica que un par de confianza configurado sin clave de firma queda
+// en la cola con su huella y que fijar la clave por ella permite aceptar sus anuncios
+func TestPinUnpinnedTrustedPeer(t *testing.T) {
+	host := newFakeVPN(t, "host", "10.0.0.1")
+	friend := newFakeVPN(t, "friend", "10.0.0.2")
+	host.AddPeer(core.TrustedPeer{
+		NodeID:    "friend",
+		PublicKey: friend.config.PublicKey,
+		VirtualIP: friend.config.VirtualIP,
+	})
+
+	port := freeUDPPort(t)
+	service, err := discovery.NewPeerDiscovery(host.config, port, host)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	store := discovery.NewPendingStore(filepath.Join(t.TempDir(), "pending_peers.yaml"))
+	service.SetPendingStore(store)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer service.Stop()
+
+	hostAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	announceTo(t, friend, hostAddr)
+
+	fingerprint := discovery.Fingerprint(friend.config.PublicKey, signingPublicKey(t, friend))
+	if !waitFor(2*time.Second, func() bool {
+		pending, _ := store.List()
+		return len(pending) == 1 && pending[0].Fingerprint == fingerprint
+	}) {
+		t.Fatal("Anúncio do peer sem chave de assinatura não entrou na fila")
+	}
+	if len(service.Peers()) != 0 {
+		t.Fatal("Anúncio do peer sem chave de assinatura não deveria ser aceito")
+	}
+
+	// Uma falha ao gravar a configuração mantém o pedido na fila
+	if _, err := store.Pin(fingerprint, func(discovery.PendingPeer) error { return errors.New("falha") }); err == nil {
+		t.Fatal("Falha na fixação deveria ser retornada")
+	}
+	pin := func(pending discovery.PendingPeer) error {
+		pinned, err := discovery.PinTrustedPeer(host.TrustedPeers(), pending)
+		if err != nil {
+			return err
+		}
+		return host.AddPeer(pinned)
+	}
+	if _, err := store.Pin(fingerprint, pin); err != nil {
+		t.Fatalf("Falha ao fixar chave: %v", err)
+	}
+	if pending, _ := store.List(); len(pending) != 0 {
+		t.Errorf("Pedido fixado deveria sair da fila, restam %d", len(pending))
+	}
+	if peer := host.TrustedPeers()[0]; peer.SigningKey != signingPublicKey(t, friend) {
+		t.Fatalf("Chave de assinatura não fixada: %q", peer.SigningKey)
+	}
+
+	announceTo(t, friend, hostAddr)
+	if !waitFor(2*time.Second, func() bool { return len(service.Peers()) == 1 }) {
+		t.Error("Anúncio do peer com chave fixada deveria ser aceito")
+	}
+
+	// Peers com chave registrada não são fixados de novo pela fila
+	if _, err := discovery.PinTrustedPeer(host.TrustedPeers(), discovery.PendingPeer{PublicKey: friend.config.PublicKey}); err == nil {
+		t.Error("Peer com chave registrada não deveria ser fixado de novo")
+	}
+}
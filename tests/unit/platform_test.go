@@ -1,7 +1,6 @@
 package unit_test
 
 import (
-	"os"
 	"testing"
 
 	"github.com/p2p-vpn/p2p-vpn/platform"
@@ -0,0 +1,383 @@
+package unit_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// fakePMPExternalIP é o endereço externo informado pelo gateway falso
+var fakePMPExternalIP = net.IPv4(198, 51, 100, 20).To4()
+
+// fakePMPRequest é um pedido de mapeamento recebido pelo gateway falso
+type fakePMPRequest struct {
+	version      byte
+	internalPort int
+	externalPort int
+	lifetime     uint32
+	nonce        []byte
+}
+
+// fakePMPGateway simula um gateway NAT-PMP que também pode atender PCP
+type fakePMPGateway struct {
+	conn *net.UDPConn
+	pcp  bool // Sem PCP, requisições versão 2 recebem "versão não suportada"
+
+	epochStart time.Time
+	epochBase  uint32
+	requests   []fakePMPRequest
+	mutex      sync.Mutex
+}
+
+func newFakePMPGateway(t *testing.T, pcp bool) *fakePMPGateway {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir o gateway falso: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	gw := &fakePMPGateway{conn: conn, pcp: pcp, epochStart: time.Now(), epochBase: 1000}
+	go gw.serve()
+	return gw
+}
+
+func (gw *fakePMPGateway) addr() string {
+	return gw.conn.LocalAddr().String()
+}
+
+// reboot simula o reinício do roteador: a época volta a zero e os mapeamentos se perdem
+func (gw *fakePMPGateway) reboot() {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+	gw.epochStart = time.Now()
+	gw.epochBase = 0
+}
+
+func (gw *fakePMPGateway) mapRequests() []fakePMPRequest {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+	return append([]fakePMPRequest(nil), gw.requests...)
+}
+
+func (gw *fakePMPGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, src, err := gw.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if response := gw.handle(buf[:n], src); response != nil {
+			gw.conn.WriteToUDP(response, src)
+		}
+	}
+}
+
+func (gw *fakePMPGateway) handle(data []byte, src *net.UDPAddr) []byte {
+	if len(data) < 2 {
+		return nil
+	}
+
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+	epoch := gw.epochBase + uint32(time.Since(gw.epochStart)/time.Second)
+
+	if data[0] == 2 && gw.pcp {
+		return gw.handlePCP(data, src, epoch)
+	}
+
+	// Respostas NAT-PMP: versão, opcode+128, resultado e época
+	response := []byte{0, 128 + data[1], 0, 0}
+	response = binary.BigEndian.AppendUint32(response, epoch)
+	switch {
+	case data[0] != 0:
+		response[3] = 1 // Versão não suportada
+	case data[1] == 0:
+		response = append(response, fakePMPExternalIP...)
+	case data[1] == 1 && len(data) == 12:
+		request := fakePMPRequest{
+			internalPort: int(binary.BigEndian.Uint16(data[4:6])),
+			externalPort: int(binary.BigEndian.Uint16(data[6:8])),
+			lifetime:     binary.BigEndian.Uint32(data[8:12]),
+		}
+		gw.requests = append(gw.requests, request)
+		mapped := request.externalPort
+		if mapped == 0 {
+			mapped = request.internalPort
+		}
+		response = binary.BigEndian.AppendUint16(response, uint16(request.internalPort))
+		response = binary.BigEndian.AppendUint16(response, uint16(mapped))
+		response = binary.BigEndian.AppendUint32(response, request.lifetime)
+	default:
+		response[3] = 5 // Opcode não suportado
+	}
+	return response
+}
+
+func (gw *fakePMPGateway) handlePCP(data []byte, src *net.UDPAddr, epoch uint32) []byte {
+	if len(data) < 24 {
+		return nil
+	}
+	op := data[1] & 0x7f
+	lifetime := binary.BigEndian.Uint32(data[4:8])
+
+	response := make([]byte, 24)
+	response[0] = 2
+	response[1] = 0x80 | op
+	binary.BigEndian.PutUint32(response[8:12], epoch)
+
+	if !net.IP(data[8:24]).Equal(src.IP) {
+		response[3] = 12 // ADDRESS_MISMATCH
+		return response
+	}
+
+	switch {
+	case op == 0:
+	case op == 1 && len(data) >= 60:
+		payload := append([]byte(nil), data[24:60]...)
+		request := fakePMPRequest{
+			version:      2,
+			internalPort: int(binary.BigEndian.Uint16(payload[16:18])),
+			externalPort: int(binary.BigEndian.Uint16(payload[18:20])),
+			lifetime:     lifetime,
+			nonce:        payload[0:12],
+		}
+		gw.requests = append(gw.requests, request)
+		if request.externalPort == 0 {
+			binary.BigEndian.PutUint16(payload[18:20], uint16(request.internalPort))
+		}
+		copy(payload[20:36], fakePMPExternalIP.To16())
+		binary.BigEndian.PutUint32(response[4:8], lifetime)
+		response = append(response, payload...)
+	default:
+		response[3] = 4 // UNSUPP_OPCODE
+	}
+	return response
+}
+
+// TestPCPPortMapping verifica o mapeamento PCP, a renovação com o mesmo nonce, a remoção
+// na parada e a detecção do reinício do gateway pela época
+// TestPCPPortMapping checks PCP mapping, renewal with the same nonce, removal on stop
+// and gateway reboot detection through the epoch
+// TestPCPPortMapping verifica el mapeo PCP, la renovación con el mismo nonce, la
+// eliminación al detenerse y la detección del reinicio del gateway por la época
+func TestPCPPortMapping(t *testing.T) {
+	gw := newFakePMPGateway(t, true)
+	port := freeUDPPort(t)
+
+	pcp := nattraversal.NewPCPClient(gw.addr())
+	pcp.SetRetransmission(50*time.Millisecond, 3)
+
+	traversal := newMappingTraversal(t, port, pcp)
+	traversal.SetPortMappingLease(2 * time.Second)
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+
+	expected := fmt.Sprintf("198.51.100.20:%d", port)
+	if !waitFor(3*time.Second, func() bool {
+		candidates := traversal.EndpointCandidates()
+		return len(candidates) == 1 && candidates[0] == expected
+	}) {
+		t.Fatalf("Endpoint PCP não informado: %v", traversal.EndpointCandidates())
+	}
+
+	first := gw.mapRequests()[0]
+	if first.version != 2 || first.internalPort != port || first.lifetime != 2 ||
+		bytes.Equal(first.nonce, make([]byte, 12)) {
+		t.Errorf("MAP com campos inesperados: %+v", first)
+	}
+
+	// O lease de 2s é renovado na metade do prazo, com o mesmo nonce
+	if !waitFor(3*time.Second, func() bool { return len(gw.mapRequests()) >= 2 }) {
+		t.Fatal("Mapeamento PCP não foi renovado antes do fim do lease")
+	}
+	if renewal := gw.mapRequests()[1]; !bytes.Equal(renewal.nonce, first.nonce) {
+		t.Error("Renovação PCP deveria repetir o nonce do mapeamento")
+	}
+
+	// A época segue o relógio enquanto o gateway está no ar e volta a zero no reinício
+	if reset, err := pcp.GatewayReset(); err != nil || reset {
+		t.Errorf("Reinício detectado sem o gateway reiniciar: %v, %v", reset, err)
+	}
+	gw.reboot()
+	if reset, err := pcp.GatewayReset(); err != nil || !reset {
+		t.Errorf("Reinício do gateway não detectado: %v, %v", reset, err)
+	}
+
+	traversal.Stop()
+	requests := gw.mapRequests()
+	last := requests[len(requests)-1]
+	if last.lifetime != 0 || !bytes.Equal(last.nonce, first.nonce) {
+		t.Errorf("MAP de remoção esperado na parada, obtido %+v", last)
+	}
+	if len(traversal.EndpointCandidates()) != 0 {
+		t.Error("Endpoint PCP deveria sumir após a remoção do mapeamento")
+	}
+}
+
+// TestNATPMPFallback verifica o uso do NAT-PMP quando o gateway não suporta PCP, a
+// detecção de reinício e a validação da ordem de protocolos
+// TestNATPMPFallback checks NAT-PMP use when the gateway lacks PCP, reboot detection
+// and protocol order validation
+// TestNATPMPFallback verifica el uso de NAT-PMP cuando el gateway no soporta PCP, la
+// detección de reinicio y la validación del orden de protocolos
+func TestNATPMPFallback(t *testing.T) {
+	gw := newFakePMPGateway(t, false)
+	port := freeUDPPort(t)
+
+	pcp := nattraversal.NewPCPClient(gw.addr())
+	natpmp := nattraversal.NewNATPMPClient(gw.addr())
+	pcp.SetRetransmission(50*time.Millisecond, 3)
+	natpmp.SetRetransmission(50*time.Millisecond, 3)
+
+	// O gateway responde ao PCP com a versão do NAT-PMP, sem esperar retransmissões
+	if _, err := pcp.AddMapping(port, port, time.Hour); err == nil {
+		t.Fatal("PCP deveria falhar em um gateway só NAT-PMP")
+	}
+
+	traversal := newMappingTraversal(t, port, pcp, natpmp)
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+
+	expected := fmt.Sprintf("198.51.100.20:%d", port)
+	if !waitFor(3*time.Second, func() bool {
+		candidates := traversal.EndpointCandidates()
+		return len(candidates) == 1 && candidates[0] == expected
+	}) {
+		t.Fatalf("Endpoint NAT-PMP não informado: %v", traversal.EndpointCandidates())
+	}
+	if first := gw.mapRequests()[0]; first.version != 0 || first.lifetime != 3600 || first.externalPort != port {
+		t.Errorf("Mapeamento NAT-PMP com campos inesperados: %+v", first)
+	}
+
+	if reset, err := natpmp.GatewayReset(); err != nil || reset {
+		t.Errorf("Reinício detectado sem o gateway reiniciar: %v, %v", reset, err)
+	}
+	gw.reboot()
+	if reset, err := natpmp.GatewayReset(); err != nil || !reset {
+		t.Errorf("Reinício do gateway não detectado: %v, %v", reset, err)
+	}
+
+	traversal.Stop()
+	requests := gw.mapRequests()
+	if last := requests[len(requests)-1]; last.lifetime != 0 || last.externalPort != 0 {
+		t.Errorf("Remoção NAT-PMP esperada na parada, obtido %+v", last)
+	}
+
+	// Ordem e ativação configuráveis, com nomes validados
+	if err := traversal.SetPortMappingProtocols([]string{"natpmp", "upnp"}); err != nil {
+		t.Errorf("Ordem válida recusada: %v", err)
+	}
+	for _, invalid := range [][]string{{"pcp", "igd"}, {"none", "upnp"}, {"pcp", "pcp"}} {
+		if err := traversal.SetPortMappingProtocols(invalid); err == nil {
+			t.Errorf("Protocolos inválidos aceitos: %v", invalid)
+		}
+	}
+}
+
+// stallingMapper é um protocolo de mapeamento cujo gateway para de responder às renovações
+// até release ser fechado, como um roteador que trava depois de conceder o mapeamento
+type stallingMapper struct {
+	renewing chan struct{} // Fechado quando a primeira renovação começa
+	release  chan struct{}
+	once     sync.Once
+	deleted  int
+	mutex    sync.Mutex
+}
+
+func (m *stallingMapper) Name() string { return "stalling" }
+
+func (m *stallingMapper) AddMapping(internalPort, externalPort int, lease time.Duration) (*nattraversal.PortMapping, error) {
+	return &nattraversal.PortMapping{
+		Protocol:     "stalling",
+		ExternalIP:   net.IPv4(198, 51, 100, 30),
+		ExternalPort: externalPort,
+		InternalPort: internalPort,
+		Lease:        lease,
+	}, nil
+}
+
+func (m *stallingMapper) RenewMapping(mapping *nattraversal.PortMapping) (*nattraversal.PortMapping, error) {
+	m.once.Do(func() { close(m.renewing) })
+	<-m.release
+	renewed := *mapping
+	return &renewed, nil
+}
+
+func (m *stallingMapper) DeleteMapping(mapping *nattraversal.PortMapping) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.deleted++
+	return nil
+}
+
+func (m *stallingMapper) deletions() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.deleted
+}
+
+func (m *stallingMapper) GatewayReset() (bool, error) { return false, nil }
+
+// TestPortMappingRenewalDoesNotBlock verifica que uma renovação presa em um gateway que não
+// responde não trava a consulta dos endpoints nem o Stop, e que o mapeamento renovado depois
+// do Stop é removido do gateway
+// TestPortMappingRenewalDoesNotBlock checks that a renewal stuck on an unresponsive gateway
+// blocks neither the endpoint query nor Stop, and that a mapping renewed after Stop is removed
+// TestPortMappingRenewalDoesNotBlock verifica que una renovación atascada en un gateway que no
+// responde no bloquea la consulta de endpoints ni Stop, y que el mapeo renovado se elimina
+func TestPortMappingRenewalDoesNotBlock(t *testing.T) {
+	mapper := &stallingMapper{renewing: make(chan struct{}), release: make(chan struct{})}
+	traversal := newMappingTraversal(t, freeUDPPort(t), mapper)
+	traversal.SetPortMappingLease(200 * time.Millisecond)
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+
+	select {
+	case <-mapper.renewing:
+	case <-time.After(3 * time.Second):
+		traversal.Stop()
+		t.Fatal("Renovação do mapeamento não começou")
+	}
+
+	done := make(chan []string, 1)
+	go func() { done <- traversal.EndpointCandidates() }()
+	select {
+	case candidates := <-done:
+		if len(candidates) != 1 {
+			t.Errorf("Endpoint do mapeamento deveria continuar anunciado: %v", candidates)
+		}
+	case <-time.After(time.Second):
+		close(mapper.release)
+		t.Fatal("EndpointCandidates ficou preso atrás da renovação")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		traversal.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		close(mapper.release)
+		t.Fatal("Stop ficou preso atrás da renovação")
+	}
+	if mapper.deletions() != 1 {
+		t.Errorf("Stop deveria remover o mapeamento, remoções: %d", mapper.deletions())
+	}
+
+	// A renovação que termina depois do Stop não deixa o mapeamento no gateway
+	close(mapper.release)
+	if !waitFor(time.Second, func() bool { return mapper.deletions() == 2 }) {
+		t.Errorf("Mapeamento renovado depois do Stop deveria ser removido, remoções: %d", mapper.deletions())
+	}
+}
@@ -0,0 +1,231 @@
+package unit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// TestPortAllocationMeasurement verifica a medição do passo de alocação de portas pelos quatro
+// endereços de um servidor RFC 5780 e a previsão das próximas portas
+// TestPortAllocationMeasurement checks the port allocation step measured through the four
+// addresses of an RFC 5780 server and the prediction of the next ports
+// TestPortAllocationMeasurement verifica la medición del paso de asignación de puertos por
+// las cuatro direcciones de un servidor RFC 5780 y la predicción de los próximos puertos
+func TestPortAllocationMeasurement(t *testing.T) {
+	service := newRFC5780Service(t)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{service.Server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+
+	cases := []struct {
+		name      string
+		simulated nattraversal.NATSimulatorType
+		first     int
+		delta     int
+		expected  int
+	}{
+		{"simétrico sequencial", nattraversal.SimulateSymmetric, 21000, 2, 2},
+		{"simétrico aleatório", nattraversal.SimulateSymmetric, 0, 0, 0},
+		{"port-restricted", nattraversal.SimulatePortRestrictedCone, 0, 0, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			simulator, err := nattraversal.NewNATSimulator(tc.simulated, "127.0.0.3", "10.0.3.0/24")
+			if err != nil {
+				t.Fatalf("Falha ao criar simulador: %v", err)
+			}
+			defer simulator.Stop()
+			if tc.delta != 0 {
+				simulator.SetPortAllocation(tc.first, tc.delta)
+			}
+
+			conn, _ := simulator.ListenPacket()
+			defer conn.Close()
+			prediction, err := client.MeasurePortAllocation(conn)
+			if err != nil {
+				t.Fatalf("Medição falhou: %v", err)
+			}
+			if prediction.IP != "127.0.0.3" || prediction.Delta != tc.expected {
+				t.Fatalf("Previsão %+v, esperado IP 127.0.0.3 e passo %d", prediction, tc.expected)
+			}
+
+			predicted := prediction.Predict(3)
+			if tc.expected == 0 {
+				if len(predicted) != 0 {
+					t.Errorf("Alocação sem padrão não deveria prever portas, obtidas %v", predicted)
+				}
+				return
+			}
+			// Quatro destinos: a última porta observada é a quarta da sequência
+			last := tc.first + 3*tc.delta
+			if prediction.LastPort != last || len(predicted) != 3 || predicted[0] != last+tc.delta || predicted[2] != last+3*tc.delta {
+				t.Errorf("Última porta %d e previsão %v, esperadas %d e a sequência seguinte", prediction.LastPort, predicted, last)
+			}
+		})
+	}
+}
+
+// TestSymmetricPunchingSuccessRate mede a taxa de sucesso do hole punching entre um nó atrás
+// de NAT simétrico com portas aleatórias e um atrás de NAT port-restricted, com o orçamento
+// padrão de sockets e sondas, alternando quem propõe a rodada, e verifica o tráfego WireGuard
+// pelo socket vencedor
+// TestSymmetricPunchingSuccessRate measures the hole punching success rate between a node
+// behind symmetric NAT with random ports and one behind port-restricted NAT, with the default
+// socket and probe budget, and checks WireGuard traffic through the winning socket
+// TestSymmetricPunchingSuccessRate mide la tasa de éxito del hole punching entre un nodo
+// detrás de NAT simétrico con puertos aleatorios y uno detrás de NAT port-restricted, con el
+// presupuesto por defecto, y verifica el tráfico WireGuard por el socket ganador
+func TestSymmetricPunchingSuccessRate(t *testing.T) {
+	service := newRFC5780Service(t)
+
+	// Cada socket é um host interno, e o lado simétrico abre centenas deles
+	natA, err := nattraversal.NewNATSimulator(nattraversal.SimulateSymmetric, "127.0.0.3", "10.3.0.0/16")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natA.Stop()
+	natB, err := nattraversal.NewNATSimulator(nattraversal.SimulatePortRestrictedCone, "127.0.0.4", "10.4.0.0/16")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natB.Stop()
+
+	alice, bob, _, wgB := newPunchPair(t, service, natA, natB, "symmetric", "port-restricted")
+
+	const trials = 10
+	successes := 0
+	for trial := 0; trial < trials; trial++ {
+		// Rodadas pares propostas pelo lado simétrico, ímpares pelo outro
+		var err error
+		if trial%2 == 0 {
+			err = alice.traversal.ConnectPeer("node-b", []string{"127.0.0.4:9"})
+		} else {
+			err = bob.traversal.ConnectPeer("node-a", []string{"127.0.0.3:9"})
+		}
+		if err == nil {
+			successes++
+		} else {
+			t.Logf("Rodada %d falhou: %v", trial, err)
+		}
+	}
+	rate := float64(successes) / trials
+	t.Logf("Taxa de sucesso com NAT simétrico: %.0f%% (%d sockets, %d sondas)", rate*100,
+		nattraversal.DefaultBirthdaySockets, nattraversal.DefaultBirthdayProbes)
+	if rate < 0.8 {
+		t.Fatalf("Taxa de sucesso %.0f%% abaixo do esperado", rate*100)
+	}
+
+	// Depois da janela, os dois lados usam o mesmo par escolhido pelo lado simétrico
+	time.Sleep(nattraversal.DefaultPunchLead + nattraversal.DefaultPunchWindow)
+	proxy, err := net.ResolveUDPAddr("udp4", alice.vpn.activeEndpoint("node-b"))
+	if err != nil || !proxy.IP.IsLoopback() {
+		t.Fatalf("Endpoint de B em A deveria ser o proxy local, obtido %q", alice.vpn.activeEndpoint("node-b"))
+	}
+	endpointA, err := net.ResolveUDPAddr("udp4", bob.vpn.activeEndpoint("node-a"))
+	if err != nil || endpointA.IP.String() != "127.0.0.3" {
+		t.Fatalf("Endpoint de A em B deveria ser um mapeamento do NAT simétrico, obtido %q", bob.vpn.activeEndpoint("node-a"))
+	}
+
+	alice.wg.WriteToUDP([]byte("handshake"), proxy)
+	data, src := readPacket(t, wgB)
+	if string(data) != "handshake" || src.String() != endpointA.String() {
+		t.Fatalf("WireGuard de B recebeu %q de %s, esperado handshake de %s", data, src, endpointA)
+	}
+	wgB.WriteTo([]byte("resposta"), endpointA)
+	data, src = readPacket(t, alice.wg)
+	if string(data) != "resposta" || src.String() != proxy.String() {
+		t.Errorf("WireGuard de A recebeu %q de %s, esperado resposta pelo proxy %s", data, src, proxy)
+	}
+}
+
+// TestSymmetricPunchingWithPredictablePorts verifica que dois nós atrás de NAT simétrico com
+// alocação sequencial se alcançam pelos pares de portas previstas
+// TestSymmetricPunchingWithPredictablePorts checks that two nodes behind symmetric NAT with
+// sequential allocation reach each other through the predicted port pairs
+// TestSymmetricPunchingWithPredictablePorts verifica que dos nodos detrás de NAT simétrico con
+// asignación secuencial se alcanzan por los pares de puertos previstos
+func TestSymmetricPunchingWithPredictablePorts(t *testing.T) {
+	service := newRFC5780Service(t)
+
+	// Cada socket é um host interno, e o lado simétrico abre centenas deles
+	natA, err := nattraversal.NewNATSimulator(nattraversal.SimulateSymmetric, "127.0.0.3", "10.3.0.0/16")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natA.Stop()
+	natB, err := nattraversal.NewNATSimulator(nattraversal.SimulateSymmetric, "127.0.0.4", "10.4.0.0/16")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer natB.Stop()
+	natA.SetPortAllocation(22000, 1)
+	natB.SetPortAllocation(23000, 3)
+
+	alice, bob, _, _ := newPunchPair(t, service, natA, natB, "symmetric", "symmetric")
+
+	if err := alice.traversal.ConnectPeer("node-b", []string{"127.0.0.4:9"}); err != nil {
+		t.Fatalf("Hole punching entre NATs simétricos previsíveis falhou: %v", err)
+	}
+	if !waitFor(2*time.Second, func() bool { return bob.vpn.activeEndpoint("node-a") != "" }) {
+		t.Fatal("B não aplicou o caminho até A")
+	}
+
+	// Cada lado fala com o peer pelo proxy local do seu socket vencedor
+	proxyA, _ := net.ResolveUDPAddr("udp4", alice.vpn.activeEndpoint("node-b"))
+	proxyB, _ := net.ResolveUDPAddr("udp4", bob.vpn.activeEndpoint("node-a"))
+	if proxyA == nil || proxyB == nil || !proxyA.IP.IsLoopback() || !proxyB.IP.IsLoopback() {
+		t.Fatalf("Endpoints deveriam ser proxies locais, obtidos %q e %q",
+			alice.vpn.activeEndpoint("node-b"), bob.vpn.activeEndpoint("node-a"))
+	}
+
+	alice.wg.WriteToUDP([]byte("handshake"), proxyA)
+	data, src := readPacket(t, bob.wg)
+	if string(data) != "handshake" || src.String() != proxyB.String() {
+		t.Fatalf("WireGuard de B recebeu %q de %s, esperado handshake pelo proxy %s", data, src, proxyB)
+	}
+	bob.wg.WriteToUDP([]byte("resposta"), proxyB)
+	data, src = readPacket(t, alice.wg)
+	if string(data) != "resposta" || src.String() != proxyA.String() {
+		t.Errorf("WireGuard de A recebeu %q de %s, esperado resposta pelo proxy %s", data, src, proxyA)
+	}
+}
+
+// newPunchPair liga dois nós, cada um atrás do seu simulador, para rodadas de hole punching
+// combinadas entre eles, e retorna os sockets WireGuard de A e B atrás dos NATs
+func newPunchPair(t *testing.T, service *nattraversal.STUNService, natA, natB *nattraversal.NATSimulator, typeA, typeB string) (*relayNode, *relayNode, net.PacketConn, net.PacketConn) {
+	t.Helper()
+	alice := newRelayNode(t, "node-a", service, natA.ListenPacket)
+	bob := newRelayNode(t, "node-b", service, natB.ListenPacket)
+
+	wgA, _ := natA.ListenPacket()
+	t.Cleanup(func() { wgA.Close() })
+	wgB, _ := natB.ListenPacket()
+	t.Cleanup(func() { wgB.Close() })
+	alice.traversal.SetPunchConn(wgA)
+	bob.traversal.SetPunchConn(wgB)
+
+	link := &punchLink{nodes: make(map[string]*relayNode)}
+	link.connect(alice)
+	link.connect(bob)
+	alice.traversal.SetPeerRelay("node-b", bob.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+	bob.traversal.SetPeerRelay("node-a", alice.vpn.config.PublicKey, nattraversal.PeerRelayInfo{})
+
+	for _, node := range []struct {
+		node    *relayNode
+		natType string
+	}{{alice, typeA}, {bob, typeB}} {
+		if err := node.node.traversal.Start(); err != nil {
+			t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+		}
+		if !waitFor(3*time.Second, func() bool { return node.node.traversal.GetNATInfo().Type == node.natType }) {
+			t.Fatalf("NAT %s esperado, detectado %q", node.natType, node.node.traversal.GetNATInfo().Type)
+		}
+	}
+	// A medição do tempo de vida que segue a detecção abre os seus mapeamentos logo no início;
+	// depois disso, só as rodadas alocam portas, como a previsão supõe
+	time.Sleep(200 * time.Millisecond)
+	return alice, bob, wgA, wgB
+}
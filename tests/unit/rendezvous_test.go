@@ -0,0 +1,352 @@
+package unit_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// freeUDPPort reserva uma porta UDP livre no loopback
+func freeUDPPort(t *testing.T) int {
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao reservar porta: %v", err)
+	}
+	defer probe.Close()
+	return probe.LocalAddr().(*net.UDPAddr).Port
+}
+
+// rendezvousClient simula um nó registrado no servidor de rendezvous
+type rendezvousClient struct {
+	conn         *net.UDPConn
+	key          ed25519.PrivateKey
+	privateKey   string // Chave privada WireGuard, que prova a posse da chave anunciada
+	announcement discovery.Announcement
+}
+
+func newRendezvousClient(t *testing.T, nodeID string) *rendezvousClient {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do cliente: %v", err)
+	}
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	wgKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("Falha ao gerar chave WireGuard: %v", err)
+	}
+
+	return &rendezvousClient{
+		conn:       conn,
+		key:        key,
+		privateKey: wgKey.String(),
+		announcement: discovery.Announcement{
+			NodeID:     nodeID,
+			PublicKey:  wgKey.PublicKey().String(),
+			VirtualIP:  "10.0.0.1",
+			ListenPort: 51820,
+		},
+	}
+}
+
+// register registra o cliente no servidor, com a prova calculada pela chave WireGuard do cliente
+func (c *rendezvousClient) register(t *testing.T, server *discovery.RendezvousServer, addr *net.UDPAddr) {
+	serverKey, err := core.ParseSigningPublicKey(server.PublicKey())
+	if err != nil {
+		t.Fatalf("Chave do servidor inválida: %v", err)
+	}
+	registration, err := discovery.NewRendezvousRegistration(c.announcement, c.privateKey,
+		c.key.Public().(ed25519.PublicKey), serverKey)
+	if err != nil {
+		t.Fatalf("Falha ao criar registro: %v", err)
+	}
+	c.send(t, addr, discovery.MsgRendezvousRegister, registration)
+}
+
+func (c *rendezvousClient) send(t *testing.T, server *net.UDPAddr, msgType discovery.MessageType, payload interface{}) {
+	data, err := discovery.EncodeMessage(msgType, payload, c.key)
+	if err != nil {
+		t.Fatalf("Falha ao codificar mensagem: %v", err)
+	}
+	if _, err := c.conn.WriteToUDP(data, server); err != nil {
+		t.Fatalf("Falha ao enviar mensagem: %v", err)
+	}
+}
+
+func (c *rendezvousClient) receivePeer(t *testing.T) (discovery.RendezvousPeer, *discovery.SignedMessage) {
+	buffer := make([]byte, discovery.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	n, _, err := c.conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("Nó %s não recebeu apresentação: %v", c.announcement.NodeID, err)
+	}
+
+	msg, err := discovery.DecodeMessage(buffer[:n])
+	if err != nil || msg.Type != discovery.MsgRendezvousPeer {
+		t.Fatalf("Resposta inválida do servidor: %v", err)
+	}
+
+	var peer discovery.RendezvousPeer
+	if err := msg.Decode(&peer); err != nil {
+		t.Fatalf("Falha ao decodificar apresentação: %v", err)
+	}
+
+	registration, err := discovery.DecodeMessage(peer.Registration)
+	if err != nil {
+		t.Fatalf("Registro repassado com assinatura inválida: %v", err)
+	}
+
+	return peer, registration
+}
+
+// TestRendezvousIntroducesBothSides verifica se o servidor apresenta os dois nós um ao outro
+// TestRendezvousIntroducesBothSides checks that the server introduces both nodes to each other
+// TestRendezvousIntroducesBothSides verifica que el servidor presenta ambos nodos entre sí
+func TestRendezvousIntroducesBothSides(t *testing.T) {
+	port := freeUDPPort(t)
+
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	server, err := discovery.NewRendezvousServer(port, serverKey)
+	if err != nil {
+		t.Fatalf("Falha ao criar servidor: %v", err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar servidor: %v", err)
+	}
+	defer server.Stop()
+
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	a := newRendezvousClient(t, "node-a")
+	defer a.conn.Close()
+	b := newRendezvousClient(t, "node-b")
+	defer b.conn.Close()
+
+	a.register(t, server, serverAddr)
+	b.register(t, server, serverAddr)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.RegisteredNodes() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.RegisteredNodes() != 2 {
+		t.Fatalf("Esperados 2 nós registrados, obtido: %d", server.RegisteredNodes())
+	}
+
+	a.send(t, serverAddr, discovery.MsgRendezvousConnect, discovery.RendezvousConnect{PublicKey: b.announcement.PublicKey})
+
+	peerForA, registrationForA := a.receivePeer(t)
+	if peerForA.ObservedAddr != b.conn.LocalAddr().String() {
+		t.Errorf("Endereço observado de B incorreto: %s", peerForA.ObservedAddr)
+	}
+	if !registrationForA.SignerKey.Equal(b.key.Public()) {
+		t.Error("Registro entregue a A não foi assinado por B")
+	}
+
+	peerForB, registrationForB := b.receivePeer(t)
+	if peerForB.ObservedAddr != a.conn.LocalAddr().String() {
+		t.Errorf("Endereço observado de A incorreto: %s", peerForB.ObservedAddr)
+	}
+	if !registrationForB.SignerKey.Equal(a.key.Public()) {
+		t.Error("Registro entregue a B não foi assinado por A")
+	}
+}
+
+// TestRendezvousRejectsHijack verifica se um nó sem a chave privada WireGuard não ocupa o
+// registro de outro, mesmo registrando-se antes do dono
+// TestRendezvousRejectsHijack checks that a node without the WireGuard private key cannot squat
+// another node's registration, even when registering before the owner
+// TestRendezvousRejectsHijack verifica que un nodo sin la clave privada WireGuard no ocupa el
+// registro de otro, aunque se registre antes que el dueño
+func TestRendezvousRejectsHijack(t *testing.T) {
+	port := freeUDPPort(t)
+
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	server, _ := discovery.NewRendezvousServer(port, serverKey)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar servidor: %v", err)
+	}
+	defer server.Stop()
+
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	owner := newRendezvousClient(t, "node-a")
+	defer owner.conn.Close()
+	attacker := newRendezvousClient(t, "node-a")
+	defer attacker.conn.Close()
+	requester := newRendezvousClient(t, "node-c")
+	defer requester.conn.Close()
+
+	// O atacante anuncia a chave do dono, com a prova que consegue calcular com a própria chave
+	attacker.announcement.PublicKey = owner.announcement.PublicKey
+	attacker.register(t, server, serverAddr)
+	time.Sleep(50 * time.Millisecond)
+	if server.RegisteredNodes() != 0 {
+		t.Fatal("Registro sem prova de posse da chave WireGuard foi aceito")
+	}
+
+	// Sem prova alguma, o registro também é recusado
+	attacker.send(t, serverAddr, discovery.MsgRendezvousRegister, attacker.announcement)
+
+	owner.register(t, server, serverAddr)
+	requester.register(t, server, serverAddr)
+	if !waitFor(2*time.Second, func() bool { return server.RegisteredNodes() == 2 }) {
+		t.Fatalf("Esperados 2 nós registrados, obtido: %d", server.RegisteredNodes())
+	}
+
+	requester.send(t, serverAddr, discovery.MsgRendezvousConnect, discovery.RendezvousConnect{PublicKey: owner.announcement.PublicKey})
+
+	peer, registration := requester.receivePeer(t)
+	if !registration.SignerKey.Equal(owner.key.Public()) || peer.ObservedAddr != owner.conn.LocalAddr().String() {
+		t.Errorf("Registro sequestrado: apresentado %s", peer.ObservedAddr)
+	}
+}
+
+// TestRendezvousIntroductionRequiresServerKey verifica se um nó só aceita apresentações
+// assinadas pela chave configurada para o servidor de rendezvous
+// TestRendezvousIntroductionRequiresServerKey checks that a node only accepts introductions
+// signed by the key configured for the rendezvous server
+// TestRendezvousIntroductionRequiresServerKey verifica que un nodo solo acepta presentaciones
+// firmadas por la clave configurada para el servidor de rendezvous
+func TestRendezvousIntroductionRequiresServerKey(t *testing.T) {
+	// O servidor é simulado por um socket no endereço configurado
+	serverConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do servidor: %v", err)
+	}
+	defer serverConn.Close()
+	serverPublic, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, impostorKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	alice := newFakeVPN(t, "node-a", "10.0.0.1")
+	bob := newFakeVPN(t, "node-b", "10.0.0.2")
+	alice.config.TrustedPeers = []core.TrustedPeer{bob.trustedPeer()}
+	alice.config.Discovery.RendezvousServers = []string{
+		base64.StdEncoding.EncodeToString(serverPublic) + "@" + serverConn.LocalAddr().String(),
+	}
+
+	alicePort := freeUDPPort(t)
+	peerDiscovery, err := discovery.NewPeerDiscovery(alice.config, alicePort, alice)
+	if err != nil {
+		t.Fatalf("Falha ao criar descoberta: %v", err)
+	}
+	if err := peerDiscovery.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar descoberta: %v", err)
+	}
+	defer peerDiscovery.Stop()
+
+	bobSigningKey, _ := bob.config.SigningKey()
+	aliceAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: alicePort}
+	introduce := func(key ed25519.PrivateKey) {
+		registration, _ := discovery.EncodeMessage(discovery.MsgRendezvousRegister,
+			discovery.Announcement{NodeID: "node-b", PublicKey: bob.config.PublicKey, VirtualIP: "10.0.0.2", ListenPort: 51820},
+			bobSigningKey)
+		data, _ := discovery.EncodeMessage(discovery.MsgRendezvousPeer, discovery.RendezvousPeer{
+			ObservedAddr: "127.0.0.1:40000",
+			Registration: registration,
+		}, key)
+		if _, err := serverConn.WriteToUDP(data, aliceAddr); err != nil {
+			t.Fatalf("Falha ao enviar apresentação: %v", err)
+		}
+	}
+
+	// Um registro verdadeiro de B repassado do endereço do servidor, mas com outra assinatura
+	introduce(impostorKey)
+	if waitFor(500*time.Millisecond, func() bool { return alice.hasPeer(bob.config.PublicKey) }) {
+		t.Fatal("Apresentação sem a assinatura do servidor foi aceita")
+	}
+
+	introduce(serverKey)
+	if !waitFor(2*time.Second, func() bool { return alice.hasPeer(bob.config.PublicKey) }) {
+		t.Error("Apresentação assinada pelo servidor deveria ser aceita")
+	}
+}
+
+// TestRendezvousForwardsPunch verifica se o servidor repassa ao nó registrado apenas rodadas
+// de hole punching assinadas pelo próprio remetente
+// TestRendezvousForwardsPunch checks that the server forwards to the registered node only hole
+// punching rounds signed by the sender itself
+// TestRendezvousForwardsPunch verifica que el servidor reenvía al nodo registrado solo rondas
+// de hole punching firmadas por el propio remitente
+func TestRendezvousForwardsPunch(t *testing.T) {
+	port := freeUDPPort(t)
+
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	server, _ := discovery.NewRendezvousServer(port, serverKey)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar servidor: %v", err)
+	}
+	defer server.Stop()
+
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	a := newRendezvousClient(t, "node-a")
+	defer a.conn.Close()
+	b := newRendezvousClient(t, "node-b")
+	defer b.conn.Close()
+
+	a.register(t, server, serverAddr)
+	b.register(t, server, serverAddr)
+	if !waitFor(2*time.Second, func() bool { return server.RegisteredNodes() == 2 }) {
+		t.Fatalf("Esperados 2 nós registrados, obtido: %d", server.RegisteredNodes())
+	}
+
+	request := discovery.PunchRequest{NodeID: "node-a", Session: "0123456789abcdef", At: time.Now().UnixMilli()}
+
+	// Mensagem assinada por outra chave não é repassada
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := discovery.EncodeMessage(discovery.MsgPunch, request, otherKey)
+	a.send(t, serverAddr, discovery.MsgRendezvousForward, discovery.RendezvousForward{
+		PublicKey: b.announcement.PublicKey,
+		Message:   forged,
+	})
+
+	punch, _ := discovery.EncodeMessage(discovery.MsgPunch, request, a.key)
+	a.send(t, serverAddr, discovery.MsgRendezvousForward, discovery.RendezvousForward{
+		PublicKey: b.announcement.PublicKey,
+		Message:   punch,
+	})
+
+	buffer := make([]byte, discovery.MaxMessageSize)
+	b.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := b.conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("B não recebeu o hole punching: %v", err)
+	}
+	if string(buffer[:n]) != string(punch) {
+		t.Fatal("B deveria receber a mensagem original de A, sem alteração")
+	}
+	msg, err := discovery.DecodeMessage(buffer[:n])
+	if err != nil || msg.Type != discovery.MsgPunch || !msg.SignerKey.Equal(a.key.Public()) {
+		t.Errorf("Mensagem repassada inválida: %v", err)
+	}
+}
+
+// TestParseRendezvousServer verifica se servidores de rendezvous exigem a chave do servidor
+// TestParseRendezvousServer checks that rendezvous servers require the server key
+// TestParseRendezvousServer verifica que los servidores de rendezvous exigen la clave del servidor
+func TestParseRendezvousServer(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	encoded := base64.StdEncoding.EncodeToString(public)
+
+	addr, key, err := discovery.ParseRendezvousServer(encoded + "@rendezvous.example.com")
+	if err != nil {
+		t.Fatalf("Servidor válido rejeitado: %v", err)
+	}
+	if addr != "rendezvous.example.com:51823" || !key.Equal(public) {
+		t.Errorf("Servidor interpretado incorretamente: %s", addr)
+	}
+
+	for _, entry := range []string{"rendezvous.example.com:51823", "@rendezvous.example.com", "chave@rendezvous.example.com"} {
+		if _, _, err := discovery.ParseRendezvousServer(entry); err == nil {
+			t.Errorf("Servidor %q deveria ser rejeitado", entry)
+		}
+	}
+}
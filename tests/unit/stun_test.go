@@ -0,0 +1,364 @@
+package unit_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// stunResponder responde requisições Binding STUN com o endereço de origem observado
+type stunResponder struct {
+	conn     *net.UDPConn
+	drop     int  // Requisições iniciais ignoradas, para exercitar a retransmissão
+	legacy   bool // Responder com MAPPED-ADDRESS em vez de XOR-MAPPED-ADDRESS
+	requests int
+	mutex    sync.Mutex
+}
+
+func newSTUNResponder(t *testing.T, drop int, legacy bool) *stunResponder {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir responder STUN: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	r := &stunResponder{conn: conn, drop: drop, legacy: legacy}
+	go r.serve()
+	return r
+}
+
+func (r *stunResponder) server() nattraversal.STUNServer {
+	addr := r.conn.LocalAddr().(*net.UDPAddr)
+	return nattraversal.STUNServer{Address: addr.IP.String(), Port: addr.Port}
+}
+
+func (r *stunResponder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests
+}
+
+func (r *stunResponder) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 20 || binary.BigEndian.Uint16(buf[0:2]) != 0x0001 || binary.BigEndian.Uint32(buf[4:8]) != 0x2112A442 {
+			continue
+		}
+
+		r.mutex.Lock()
+		r.requests++
+		dropped := r.requests <= r.drop
+		r.mutex.Unlock()
+		if dropped {
+			continue
+		}
+
+		var transactionID [12]byte
+		copy(transactionID[:], buf[8:20])
+
+		// Uma resposta de outra transação chega antes e deve ser descartada pelo cliente
+		stale := transactionID
+		stale[0] ^= 0xFF
+		r.conn.WriteToUDP(stunBindingResponse(stale, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1}, false), addr)
+
+		r.conn.WriteToUDP(stunBindingResponse(transactionID, addr, r.legacy), addr)
+	}
+}
+
+// stunBindingResponse monta uma resposta Binding de sucesso com o endereço mapeado
+func stunBindingResponse(transactionID [12]byte, mapped *net.UDPAddr, legacy bool) []byte {
+	ip := mapped.IP.To4()
+	port := uint16(mapped.Port)
+	attrType := uint16(0x0020)
+	value := make([]byte, 8)
+	if legacy {
+		attrType = 0x0001
+		copy(value[4:], ip)
+	} else {
+		port ^= 0x2112
+		binary.BigEndian.PutUint32(value[4:], binary.BigEndian.Uint32(ip)^0x2112A442)
+	}
+	value[1] = 0x01
+	binary.BigEndian.PutUint16(value[2:4], port)
+
+	msg := make([]byte, 20, 32)
+	binary.BigEndian.PutUint16(msg[0:2], 0x0101)
+	binary.BigEndian.PutUint16(msg[2:4], 12)
+	binary.BigEndian.PutUint32(msg[4:8], 0x2112A442)
+	copy(msg[8:20], transactionID[:])
+	msg = binary.BigEndian.AppendUint16(msg, attrType)
+	msg = binary.BigEndian.AppendUint16(msg, 8)
+	return append(msg, value...)
+}
+
+// TestSTUNClientBinding verifica a descoberta do endereço mapeado, a retransmissão e a troca de servidor
+// TestSTUNClientBinding checks mapped address discovery, retransmission and server failover
+// TestSTUNClientBinding verifica el descubrimiento de la dirección mapeada, la retransmisión y el cambio de servidor
+func TestSTUNClientBinding(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	// Servidor que perde as duas primeiras requisições: a terceira transmissão é respondida
+	lossy := newSTUNResponder(t, 2, false)
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{lossy.server()})
+	client.SetRetransmission(20*time.Millisecond, 4)
+
+	binding, err := client.Bind(conn)
+	if err != nil {
+		t.Fatalf("Binding falhou: %v", err)
+	}
+	if !binding.Mapped.IP.Equal(local.IP) || binding.Mapped.Port != local.Port {
+		t.Errorf("Endereço mapeado incorreto: %s, esperado %s", binding.Mapped, local)
+	}
+	if lossy.count() != 3 {
+		t.Errorf("Esperadas 3 transmissões, obtidas %d", lossy.count())
+	}
+
+	// Servidor mudo seguido de um servidor antigo que só envia MAPPED-ADDRESS
+	silent := newSTUNResponder(t, 1000, false)
+	legacy := newSTUNResponder(t, 0, true)
+	client = nattraversal.NewSTUNClient([]nattraversal.STUNServer{silent.server(), legacy.server()})
+	client.SetRetransmission(20*time.Millisecond, 2)
+
+	binding, err = client.Bind(conn)
+	if err != nil {
+		t.Fatalf("Binding com troca de servidor falhou: %v", err)
+	}
+	if binding.Server != legacy.conn.LocalAddr().String() || binding.Mapped.Port != local.Port {
+		t.Errorf("Resposta inesperada: %+v", binding)
+	}
+	if silent.count() != 2 {
+		t.Errorf("Servidor mudo deveria receber 2 transmissões, recebeu %d", silent.count())
+	}
+
+	// Sem nenhum servidor respondendo, o erro informa o timeout
+	client = nattraversal.NewSTUNClient([]nattraversal.STUNServer{silent.server()})
+	client.SetRetransmission(10*time.Millisecond, 2)
+	if _, err := client.Bind(conn); err == nil {
+		t.Error("Binding sem resposta deveria falhar")
+	}
+}
+
+// TestNATTraversalDetectsPublicEndpoint verifica que o NAT traversal obtém o endpoint público via STUN
+// TestNATTraversalDetectsPublicEndpoint checks that NAT traversal obtains the public endpoint via STUN
+// TestNATTraversalDetectsPublicEndpoint verifica que el NAT traversal obtiene el endpoint público vía STUN
+func TestNATTraversalDetectsPublicEndpoint(t *testing.T) {
+	responder := newSTUNResponder(t, 0, false)
+
+	traversal := nattraversal.NewNATTraversal(0)
+	traversal.SetSTUNServers([]nattraversal.STUNServer{responder.server()})
+	traversal.Refresh()
+
+	ip, port, err := traversal.GetPublicEndpoint()
+	if err != nil {
+		t.Fatalf("Endpoint público não detectado: %v", err)
+	}
+	if ip != "127.0.0.1" || port == 0 {
+		t.Errorf("Endpoint público incorreto: %s:%d", ip, port)
+	}
+}
+
+// newRFC5780Service inicia o servidor STUN embutido com dois IPs de loopback
+func newRFC5780Service(t *testing.T) *nattraversal.STUNService {
+	// As portas cruzadas podem estar ocupadas por acaso; basta tentar de novo
+	for attempt := 0; attempt < 10; attempt++ {
+		service := nattraversal.NewSTUNService("127.0.0.1:0", "127.0.0.2:0")
+		if err := service.Start(); err == nil {
+			t.Cleanup(func() { service.Stop() })
+			return service
+		}
+	}
+	t.Fatal("Falha ao iniciar o servidor STUN RFC 5780")
+	return nil
+}
+
+// TestSTUNServiceModes verifica o servidor STUN embutido com e sem endereço alternativo
+// TestSTUNServiceModes checks the embedded STUN server with and without an alternate address
+// TestSTUNServiceModes verifica el servidor STUN integrado con y sin dirección alternativa
+func TestSTUNServiceModes(t *testing.T) {
+	service := nattraversal.NewSTUNService("127.0.0.1:0", "")
+	if err := service.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar servidor STUN: %v", err)
+	}
+	defer service.Stop()
+	if service.OtherAddress() != nil {
+		t.Error("Servidor sem endereço alternativo não deveria anunciar OTHER-ADDRESS")
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer conn.Close()
+
+	client := nattraversal.NewSTUNClient([]nattraversal.STUNServer{service.Server()})
+	client.SetRetransmission(20*time.Millisecond, 3)
+	binding, err := client.Bind(conn)
+	if err != nil {
+		t.Fatalf("Binding falhou: %v", err)
+	}
+	if binding.Mapped.String() != conn.LocalAddr().String() {
+		t.Errorf("Endereço mapeado incorreto: %s", binding.Mapped)
+	}
+
+	// Sem endereço alternativo os testes RFC 5780 não são possíveis
+	if _, err := client.DiscoverBehavior(nattraversal.ListenUDP, service.Server()); !errors.Is(err, nattraversal.ErrRFC5780Unsupported) {
+		t.Errorf("Esperado ErrRFC5780Unsupported, obtido %v", err)
+	}
+
+	// Atributo de compreensão obrigatória desconhecido gera erro 420 com UNKNOWN-ATTRIBUTES
+	request := make([]byte, 20, 28)
+	binary.BigEndian.PutUint16(request[0:2], 0x0001)
+	binary.BigEndian.PutUint16(request[2:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 0x2112A442)
+	request = append(request, 0x00, 0x7f, 0x00, 0x04, 1, 2, 3, 4)
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: service.Server().Port}
+	if _, err := conn.WriteToUDP(request, serverAddr); err != nil {
+		t.Fatalf("Falha ao enviar requisição: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Sem resposta de erro: %v", err)
+	}
+	if n < 24 || binary.BigEndian.Uint16(buf[0:2]) != 0x0111 || binary.BigEndian.Uint16(buf[20:22]) != 0x0009 || buf[26] != 4 || buf[27] != 20 {
+		t.Errorf("Resposta de erro inesperada: %x", buf[:n])
+	}
+
+	// O modo RFC 5780 exige outro IP
+	sameIP := nattraversal.NewSTUNService("127.0.0.1:0", "127.0.0.1:0")
+	if err := sameIP.Start(); err == nil {
+		sameIP.Stop()
+		t.Error("Endereço alternativo no mesmo IP deveria ser recusado")
+	}
+}
+
+// stunServiceRequest envia ao servidor uma requisição Binding com os atributos informados e
+// retorna o endereço de onde a resposta saiu e os atributos da resposta recebida em reply
+func stunServiceRequest(t *testing.T, conn, reply *net.UDPConn, server *net.UDPAddr, attributes []byte) (*net.UDPAddr, map[uint16][]byte) {
+	request := make([]byte, 20, 20+len(attributes))
+	binary.BigEndian.PutUint16(request[0:2], 0x0001)
+	binary.BigEndian.PutUint16(request[2:4], uint16(len(attributes)))
+	binary.BigEndian.PutUint32(request[4:8], 0x2112A442)
+	request = append(request, attributes...)
+	if _, err := conn.WriteToUDP(request, server); err != nil {
+		t.Fatalf("Falha ao enviar requisição: %v", err)
+	}
+
+	reply.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, from, err := reply.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Sem resposta do servidor: %v", err)
+	}
+	if n < 20 || binary.BigEndian.Uint16(buf[0:2]) != 0x0101 {
+		t.Fatalf("Resposta inesperada: %x", buf[:n])
+	}
+
+	found := make(map[uint16][]byte)
+	for body := buf[20:n]; len(body) >= 4; {
+		attrType := binary.BigEndian.Uint16(body[0:2])
+		attrLen := int(binary.BigEndian.Uint16(body[2:4]))
+		if 4+attrLen > len(body) {
+			break
+		}
+		found[attrType] = body[4 : 4+attrLen]
+		body = body[4+(attrLen+3)&^3:]
+	}
+	return from, found
+}
+
+// stunPlainAddress decodifica um atributo de endereço IPv4 sem XOR
+func stunPlainAddress(value []byte) string {
+	if len(value) != 8 {
+		return ""
+	}
+	addr := &net.UDPAddr{IP: net.IP(value[4:8]), Port: int(binary.BigEndian.Uint16(value[2:4]))}
+	return addr.String()
+}
+
+// TestSTUNServiceRFC5780 verifica diretamente no protocolo os atributos RFC 5780 do servidor
+// STUN embutido, sem passar pelo cliente
+// TestSTUNServiceRFC5780 checks the embedded STUN server's RFC 5780 attributes on the wire,
+// without going through the client
+// TestSTUNServiceRFC5780 verifica directamente en el protocolo los atributos RFC 5780 del
+// servidor STUN integrado, sin pasar por el cliente
+func TestSTUNServiceRFC5780(t *testing.T) {
+	// Antes de Start não há sockets: os endereços ficam vazios em vez de causar pânico
+	idle := nattraversal.NewSTUNService("127.0.0.1:0", "127.0.0.2:0")
+	if server := idle.Server(); server != (nattraversal.STUNServer{}) {
+		t.Errorf("Servidor não iniciado deveria retornar endereço vazio, obtido %+v", server)
+	}
+	if idle.OtherAddress() != nil {
+		t.Error("Servidor não iniciado não deveria ter endereço alternativo")
+	}
+
+	service := newRFC5780Service(t)
+	primary := &net.UDPAddr{IP: net.ParseIP(service.Server().Address), Port: service.Server().Port}
+	other := service.OtherAddress()
+	if other == nil || !other.IP.Equal(net.IPv4(127, 0, 0, 2)) || other.Port == primary.Port {
+		t.Fatalf("Endereço alternativo incorreto: %v", other)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer conn.Close()
+	reply, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket: %v", err)
+	}
+	defer reply.Close()
+
+	changeRequest := func(flags byte) []byte {
+		return []byte{0x00, 0x03, 0x00, 0x04, 0, 0, 0, flags}
+	}
+	crossed := (&net.UDPAddr{IP: primary.IP, Port: other.Port}).String()
+
+	cases := []struct {
+		name   string
+		flags  byte
+		origin string
+	}{
+		{"sem mudança", 0, primary.String()},
+		{"outra porta", 0x02, crossed},
+		{"outro IP e porta", 0x06, other.String()},
+	}
+	for _, c := range cases {
+		from, attributes := stunServiceRequest(t, conn, conn, primary, changeRequest(c.flags))
+		if from.String() != c.origin {
+			t.Errorf("%s: resposta saiu de %s, esperado %s", c.name, from, c.origin)
+		}
+		if origin := stunPlainAddress(attributes[0x802b]); origin != c.origin {
+			t.Errorf("%s: RESPONSE-ORIGIN %s, esperado %s", c.name, origin, c.origin)
+		}
+		if otherAddress := stunPlainAddress(attributes[0x802c]); otherAddress != other.String() {
+			t.Errorf("%s: OTHER-ADDRESS %s, esperado %s", c.name, otherAddress, other)
+		}
+		if _, ok := attributes[0x0020]; !ok {
+			t.Errorf("%s: resposta sem XOR-MAPPED-ADDRESS", c.name)
+		}
+	}
+
+	// RESPONSE-PORT desvia a resposta para outra porta do mesmo IP de origem
+	port := reply.LocalAddr().(*net.UDPAddr).Port
+	responsePort := []byte{0x00, 0x27, 0x00, 0x04, byte(port >> 8), byte(port), 0, 0}
+	if from, _ := stunServiceRequest(t, conn, reply, primary, responsePort); from.String() != primary.String() {
+		t.Errorf("Resposta com RESPONSE-PORT saiu de %s, esperado %s", from, primary)
+	}
+}
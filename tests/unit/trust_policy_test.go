@@ -0,0 +1,116 @@
+package unit_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+)
+
+// TestTrustPolicySources verifica o filtro de IPs de origem
+// TestTrustPolicySources checks the source IP filter
+// TestTrustPolicySources verifica el filtro de IPs de origen
+func TestTrustPolicySources(t *testing.T) {
+	if _, err := discovery.NewTrustPolicy([]string{"10.0.0.0/33"}, false, false); err == nil {
+		t.Error("Rede inválida deveria ser recusada")
+	}
+
+	policy, err := discovery.NewTrustPolicy([]string{"192.168.1.0/24", "203.0.113.7", "fd00::1"}, false, false)
+	if err != nil {
+		t.Fatalf("Falha ao criar política: %v", err)
+	}
+
+	cases := map[string]bool{
+		"192.168.1.42": true,
+		"192.168.2.1":  false,
+		"203.0.113.7":  true,
+		"203.0.113.8":  false,
+		"fd00::1":      true,
+		"fd00::2":      false,
+	}
+	for ip, expected := range cases {
+		if policy.AllowSource(net.ParseIP(ip)) != expected {
+			t.Errorf("AllowSource(%s) deveria ser %v", ip, expected)
+		}
+	}
+
+	// Sem política ou sem lista, qualquer origem é aceita
+	var none *discovery.TrustPolicy
+	if !none.AllowSource(net.ParseIP("198.51.100.1")) {
+		t.Error("Política nula deveria aceitar qualquer origem")
+	}
+	open, _ := discovery.NewTrustPolicy(nil, false, false)
+	if !open.AllowSource(net.ParseIP("198.51.100.1")) {
+		t.Error("Lista vazia deveria aceitar qualquer origem")
+	}
+}
+
+// TestTrustPolicyAuthorize verifica as regras aplicadas antes de configurar um peer
+// TestTrustPolicyAuthorize checks the rules applied before configuring a peer
+// TestTrustPolicyAuthorize verifica las reglas aplicadas antes de configurar un par
+func TestTrustPolicyAuthorize(t *testing.T) {
+	known := newFakeVPN(t, "known", "10.0.0.2").config
+	stranger := newFakeVPN(t, "stranger", "10.0.0.3").config
+
+	trusted := []core.TrustedPeer{{
+		NodeID:     "known",
+		PublicKey:  known.PublicKey,
+		VirtualIP:  "10.0.0.2",
+		SigningKey: "assinatura-conhecida",
+	}}
+
+	peer := func(nodeID, publicKey, virtualIP, signingKey, addr string) *discovery.PeerInfo {
+		return &discovery.PeerInfo{
+			NodeID:        nodeID,
+			PublicKey:     publicKey,
+			VirtualIP:     virtualIP,
+			SigningKey:    signingKey,
+			DiscoveryAddr: addr,
+		}
+	}
+
+	strict, err := discovery.NewTrustPolicy([]string{"192.168.1.0/24"}, true, true)
+	if err != nil {
+		t.Fatalf("Falha ao criar política: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		info    *discovery.PeerInfo
+		allowed bool
+	}{
+		{"peer confiável", peer("known", known.PublicKey, "10.0.0.2", "assinatura-conhecida", "192.168.1.5:51821"), true},
+		{"chave desconhecida", peer("stranger", stranger.PublicKey, "10.0.0.3", "x", "192.168.1.6:51821"), false},
+		{"origem fora da lista", peer("known", known.PublicKey, "10.0.0.2", "assinatura-conhecida", "198.51.100.1:51821"), false},
+		{"assinatura diferente", peer("known", known.PublicKey, "10.0.0.2", "outra", "192.168.1.5:51821"), false},
+		{"IP virtual diferente", peer("known", known.PublicKey, "10.0.0.9", "assinatura-conhecida", "192.168.1.5:51821"), false},
+	}
+	for _, c := range cases {
+		err := strict.Authorize(c.info, trusted)
+		if c.allowed && err != nil {
+			t.Errorf("%s: deveria ser aceito, erro: %v", c.name, err)
+		}
+		if !c.allowed && err == nil {
+			t.Errorf("%s: deveria ser recusado", c.name)
+		}
+	}
+
+	// Sem AuthenticatedOnly, nós novos são aceitos, mas não podem assumir um nó configurado
+	verifying, _ := discovery.NewTrustPolicy(nil, false, true)
+	if err := verifying.Authorize(peer("stranger", stranger.PublicKey, "10.0.0.3", "x", ""), trusted); err != nil {
+		t.Errorf("Nó novo deveria ser aceito: %v", err)
+	}
+	if err := verifying.Authorize(peer("known", stranger.PublicKey, "10.0.0.2", "x", ""), trusted); err == nil {
+		t.Error("Outra chave não deveria assumir um nó configurado")
+	}
+	if err := verifying.Authorize(peer("bogus", "não-é-chave", "10.0.0.4", "x", ""), trusted); err == nil {
+		t.Error("Chave pública inválida deveria ser recusada")
+	}
+
+	// Sem política, tudo é aceito
+	var none *discovery.TrustPolicy
+	if err := none.Authorize(peer("stranger", stranger.PublicKey, "10.0.0.3", "x", "198.51.100.1:1"), trusted); err != nil {
+		t.Errorf("Política nula deveria aceitar: %v", err)
+	}
+}
@@ -0,0 +1,543 @@
+package unit_test
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+// Credenciais aceitas pelo servidor TURN falso
+const (
+	fakeTURNRealm    = "p2p-vpn.test"
+	fakeTURNUser     = "alice"
+	fakeTURNPassword = "segredo"
+)
+
+// turnTestAttr é um atributo de uma mensagem STUN/TURN no servidor falso
+type turnTestAttr struct {
+	typ   uint16
+	value []byte
+}
+
+// turnTestMessage é uma mensagem STUN/TURN decodificada pelo servidor falso
+type turnTestMessage struct {
+	typ       uint16
+	txid      [12]byte
+	attrs     []turnTestAttr
+	integrity int // Posição do MESSAGE-INTEGRITY, -1 se ausente
+	raw       []byte
+}
+
+func (m *turnTestMessage) attr(typ uint16) []byte {
+	for _, attr := range m.attrs {
+		if attr.typ == typ {
+			return attr.value
+		}
+	}
+	return nil
+}
+
+func parseTURNTestMessage(data []byte) (*turnTestMessage, bool) {
+	if len(data) < 20 || binary.BigEndian.Uint32(data[4:8]) != 0x2112a442 {
+		return nil, false
+	}
+	msg := &turnTestMessage{typ: binary.BigEndian.Uint16(data[0:2]), integrity: -1, raw: data}
+	copy(msg.txid[:], data[8:20])
+	for offset := 20; offset+4 <= len(data); {
+		typ := binary.BigEndian.Uint16(data[offset : offset+2])
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if offset+4+length > len(data) {
+			return nil, false
+		}
+		if typ == 0x0008 {
+			msg.integrity = offset
+		}
+		msg.attrs = append(msg.attrs, turnTestAttr{typ, data[offset+4 : offset+4+length]})
+		offset += 4 + (length+3)&^3
+	}
+	return msg, true
+}
+
+// encodeTURNTestMessage serializa a mensagem, com MESSAGE-INTEGRITY quando há chave
+func encodeTURNTestMessage(typ uint16, txid [12]byte, attrs []turnTestAttr, key []byte) []byte {
+	data := binary.BigEndian.AppendUint16(nil, typ)
+	data = append(data, 0, 0, 0x21, 0x12, 0xa4, 0x42)
+	data = append(data, txid[:]...)
+	for _, attr := range attrs {
+		data = binary.BigEndian.AppendUint16(data, attr.typ)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(attr.value)))
+		data = append(data, attr.value...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	if key != nil {
+		binary.BigEndian.PutUint16(data[2:4], uint16(len(data)-20+24))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(data)
+		data = append(data, 0x00, 0x08, 0x00, 0x14)
+		return mac.Sum(data)
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)-20))
+	return data
+}
+
+// validIntegrity confere o MESSAGE-INTEGRITY da mensagem com a chave
+func (m *turnTestMessage) validIntegrity(key []byte) bool {
+	if m.integrity < 0 {
+		return false
+	}
+	signed := append([]byte(nil), m.raw[:m.integrity]...)
+	binary.BigEndian.PutUint16(signed[2:4], uint16(m.integrity-20+24))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(signed)
+	return hmac.Equal(mac.Sum(nil), m.raw[m.integrity+4:m.integrity+24])
+}
+
+func xorTURNTestAddress(addr *net.UDPAddr) []byte {
+	value := []byte{0, 1}
+	value = binary.BigEndian.AppendUint16(value, uint16(addr.Port)^0x2112)
+	ip := addr.IP.To4()
+	return append(value, ip[0]^0x21, ip[1]^0x12, ip[2]^0xa4, ip[3]^0x42)
+}
+
+func parseXORTURNTestAddress(value []byte) *net.UDPAddr {
+	if len(value) != 8 {
+		return nil
+	}
+	ip := net.IPv4(value[4]^0x21, value[5]^0x12, value[6]^0xa4, value[7]^0x42)
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(value[2:4]) ^ 0x2112)}
+}
+
+// fakeTURNAllocation é a alocação de um cliente no servidor falso
+type fakeTURNAllocation struct {
+	client      *net.UDPAddr
+	relay       *net.UDPConn
+	permissions map[string]bool
+	channels    map[uint16]*net.UDPAddr
+	lifetime    time.Duration
+}
+
+// fakeTURNServer é um servidor TURN mínimo com credenciais de longo prazo, permissões,
+// canais e repasse de dados nos dois sentidos
+type fakeTURNServer struct {
+	conn        *net.UDPConn
+	maxLifetime time.Duration // Lifetime máximo concedido
+	staleNonce  bool          // Declara vencido o nonce da próxima requisição autenticada
+
+	nonce       string
+	allocations map[string]*fakeTURNAllocation
+	refreshes   int
+	released    int
+	mutex       sync.Mutex
+}
+
+func newFakeTURNServer(t *testing.T) *fakeTURNServer {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir o servidor TURN falso: %v", err)
+	}
+	server := &fakeTURNServer{
+		conn:        conn,
+		maxLifetime: 10 * time.Minute,
+		nonce:       "nonce-1",
+		allocations: make(map[string]*fakeTURNAllocation),
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		for _, allocation := range server.allocations {
+			allocation.relay.Close()
+		}
+	})
+	go server.serve()
+	return server
+}
+
+func (s *fakeTURNServer) server(password string) nattraversal.TURNServer {
+	addr := s.conn.LocalAddr().(*net.UDPAddr)
+	return nattraversal.TURNServer{Address: "127.0.0.1", Port: addr.Port, Username: fakeTURNUser, Password: password}
+}
+
+// relayAddr retorna o endereço relayed da alocação do cliente, ou nil
+func (s *fakeTURNServer) relayAddr() *net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, allocation := range s.allocations {
+		return allocation.relay.LocalAddr().(*net.UDPAddr)
+	}
+	return nil
+}
+
+func (s *fakeTURNServer) counters() (refreshes, released, allocations int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.refreshes, s.released, len(s.allocations)
+}
+
+func (s *fakeTURNServer) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, src, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := append([]byte(nil), buf[:n]...)
+
+		s.mutex.Lock()
+		allocation := s.allocations[src.String()]
+		s.mutex.Unlock()
+
+		// ChannelData do cliente para o peer do canal
+		if data[0]&0xc0 == 0x40 && allocation != nil {
+			s.mutex.Lock()
+			peer := allocation.channels[binary.BigEndian.Uint16(data[0:2])]
+			s.mutex.Unlock()
+			if peer != nil {
+				allocation.relay.WriteToUDP(data[4:4+int(binary.BigEndian.Uint16(data[2:4]))], peer)
+			}
+			continue
+		}
+
+		msg, ok := parseTURNTestMessage(data)
+		if !ok {
+			continue
+		}
+		if msg.typ == 0x0016 {
+			// Send indication: só com permissão para o IP do peer
+			peer := parseXORTURNTestAddress(msg.attr(0x0012))
+			s.mutex.Lock()
+			allowed := allocation != nil && peer != nil && allocation.permissions[peer.IP.String()]
+			s.mutex.Unlock()
+			if allowed {
+				allocation.relay.WriteToUDP(msg.attr(0x0013), peer)
+			}
+			continue
+		}
+		if response := s.handle(msg, src); response != nil {
+			s.conn.WriteToUDP(response, src)
+		}
+	}
+}
+
+func (s *fakeTURNServer) handle(msg *turnTestMessage, src *net.UDPAddr) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := md5.Sum([]byte(fakeTURNUser + ":" + fakeTURNRealm + ":" + fakeTURNPassword))
+	errorResponse := func(code int, reason string) []byte {
+		value := []byte{0, 0, byte(code / 100), byte(code % 100)}
+		return encodeTURNTestMessage(msg.typ|0x0110, msg.txid, []turnTestAttr{
+			{0x0009, append(value, reason...)},
+			{0x0014, []byte(fakeTURNRealm)},
+			{0x0015, []byte(s.nonce)},
+		}, nil)
+	}
+
+	// Credenciais de longo prazo: sem elas, ou com chave errada, 401 com realm e nonce
+	if msg.integrity < 0 || string(msg.attr(0x0006)) != fakeTURNUser || !msg.validIntegrity(key[:]) {
+		return errorResponse(401, "Unauthorized")
+	}
+	if string(msg.attr(0x0015)) != s.nonce || s.staleNonce {
+		s.staleNonce = false
+		s.nonce += "x"
+		return errorResponse(438, "Stale Nonce")
+	}
+
+	allocation := s.allocations[src.String()]
+	success := func(attrs ...turnTestAttr) []byte {
+		return encodeTURNTestMessage(msg.typ|0x0100, msg.txid, attrs, key[:])
+	}
+	grant := func() (time.Duration, []byte) {
+		lifetime := s.maxLifetime
+		if value := msg.attr(0x000d); len(value) == 4 {
+			if requested := time.Duration(binary.BigEndian.Uint32(value)) * time.Second; requested < lifetime {
+				lifetime = requested
+			}
+		}
+		return lifetime, binary.BigEndian.AppendUint32(nil, uint32(lifetime/time.Second))
+	}
+
+	switch msg.typ {
+	case 0x0003: // Allocate
+		if allocation != nil {
+			return errorResponse(437, "Allocation Mismatch")
+		}
+		relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return errorResponse(508, "Insufficient Capacity")
+		}
+		lifetime, value := grant()
+		allocation = &fakeTURNAllocation{
+			client:      src,
+			relay:       relay,
+			permissions: make(map[string]bool),
+			channels:    make(map[uint16]*net.UDPAddr),
+			lifetime:    lifetime,
+		}
+		s.allocations[src.String()] = allocation
+		go s.relay(allocation)
+		return success(
+			turnTestAttr{0x0016, xorTURNTestAddress(relay.LocalAddr().(*net.UDPAddr))},
+			turnTestAttr{0x0020, xorTURNTestAddress(src)},
+			turnTestAttr{0x000d, value},
+		)
+
+	case 0x0004: // Refresh
+		if allocation == nil {
+			return errorResponse(437, "Allocation Mismatch")
+		}
+		lifetime, value := grant()
+		if lifetime == 0 {
+			allocation.relay.Close()
+			delete(s.allocations, src.String())
+			s.released++
+		} else {
+			s.refreshes++
+		}
+		return success(turnTestAttr{0x000d, value})
+
+	case 0x0008: // CreatePermission
+		if allocation == nil {
+			return errorResponse(437, "Allocation Mismatch")
+		}
+		for _, attr := range msg.attrs {
+			if peer := parseXORTURNTestAddress(attr.value); attr.typ == 0x0012 && peer != nil {
+				allocation.permissions[peer.IP.String()] = true
+			}
+		}
+		return success()
+
+	case 0x0009: // ChannelBind
+		peer := parseXORTURNTestAddress(msg.attr(0x0012))
+		channel := msg.attr(0x000c)
+		if allocation == nil || peer == nil || len(channel) != 4 {
+			return errorResponse(400, "Bad Request")
+		}
+		allocation.channels[binary.BigEndian.Uint16(channel)] = peer
+		allocation.permissions[peer.IP.String()] = true
+		return success()
+	}
+	return errorResponse(400, "Bad Request")
+}
+
+// relay repassa ao cliente os pacotes que os peers com permissão enviam ao endereço relayed
+func (s *fakeTURNServer) relay(allocation *fakeTURNAllocation) {
+	buf := make([]byte, 65535)
+	for {
+		n, src, err := allocation.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		allowed := allocation.permissions[src.IP.String()]
+		channel := uint16(0)
+		for number, peer := range allocation.channels {
+			if peer.String() == src.String() {
+				channel = number
+			}
+		}
+		s.mutex.Unlock()
+		if !allowed {
+			continue
+		}
+
+		if channel != 0 {
+			frame := binary.BigEndian.AppendUint16(nil, channel)
+			frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+			s.conn.WriteToUDP(append(frame, buf[:n]...), allocation.client)
+			continue
+		}
+		var txid [12]byte
+		copy(txid[:], "data-indicat")
+		s.conn.WriteToUDP(encodeTURNTestMessage(0x0017, txid, []turnTestAttr{
+			{0x0012, xorTURNTestAddress(src)},
+			{0x0013, append([]byte(nil), buf[:n]...)},
+		}, nil), allocation.client)
+	}
+}
+
+// readUDP lê um pacote com prazo, retornando o conteúdo e a origem
+func readUDP(t *testing.T, conn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	t.Helper()
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, src, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Pacote não recebido: %v", err)
+	}
+	return buf[:n], src
+}
+
+// TestTURNClient verifica a autenticação de longo prazo (incluindo nonce vencido e senha
+// errada), a renovação da alocação, permissões com Send/Data indications e a remoção
+// TestTURNClient checks long-term authentication (including stale nonce and wrong
+// password), allocation refresh, permissions with Send/Data indications and removal
+// TestTURNClient verifica la autenticación de largo plazo (incluido nonce vencido y
+// contraseña incorrecta), la renovación de la asignación, permisos con indicaciones
+// Send/Data y la eliminación
+func TestTURNClient(t *testing.T) {
+	server := newFakeTURNServer(t)
+	server.mutex.Lock()
+	server.maxLifetime = 2 * time.Second
+	server.mutex.Unlock()
+
+	wrong := nattraversal.NewTURNClient(server.server("errada"))
+	wrong.SetRetransmission(50*time.Millisecond, 3)
+	var turnErr *nattraversal.TURNError
+	if _, err := wrong.Allocate(); !errors.As(err, &turnErr) || turnErr.Code != 401 {
+		t.Fatalf("Senha errada deveria resultar em 401, obtido %v", err)
+	}
+
+	// O nonce vencido no Allocate autenticado é trocado sem falhar a alocação
+	server.mutex.Lock()
+	server.staleNonce = true
+	server.mutex.Unlock()
+	client := nattraversal.NewTURNClient(server.server(fakeTURNPassword))
+	client.SetRetransmission(50*time.Millisecond, 3)
+	received := make(chan []byte, 1)
+	client.SetHandler(func(peer *net.UDPAddr, data []byte) { received <- data })
+
+	relayed, err := client.Allocate()
+	if err != nil {
+		t.Fatalf("Falha na alocação TURN: %v", err)
+	}
+	if !relayed.IP.Equal(net.IPv4(127, 0, 0, 1)) || relayed.Port != server.relayAddr().Port {
+		t.Errorf("Endereço relayed inesperado: %s", relayed)
+	}
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do peer: %v", err)
+	}
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	if err := client.CreatePermission(peerAddr); err != nil {
+		t.Fatalf("Falha ao criar permissão: %v", err)
+	}
+	if err := client.Send(peerAddr, []byte("ping")); err != nil {
+		t.Fatalf("Falha ao enviar pelo relay: %v", err)
+	}
+	if data, src := readUDP(t, peer); string(data) != "ping" || src.Port != relayed.Port {
+		t.Errorf("Peer recebeu %q de %s, esperado ping do endereço relayed", data, src)
+	}
+
+	peer.WriteToUDP([]byte("pong"), relayed)
+	select {
+	case data := <-received:
+		if string(data) != "pong" {
+			t.Errorf("Data indication com conteúdo inesperado: %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Resposta do peer não chegou pelo relay")
+	}
+
+	// O lifetime de 2s é renovado na metade do prazo
+	if !waitFor(3*time.Second, func() bool { refreshes, _, _ := server.counters(); return refreshes > 0 }) {
+		t.Error("Alocação TURN não foi renovada")
+	}
+
+	client.Close()
+	if _, released, allocations := server.counters(); released != 1 || allocations != 0 {
+		t.Errorf("Alocação deveria ser removida no Close: %d removidas, %d ativas", released, allocations)
+	}
+}
+
+// TestTURNRelayFallback verifica que, atrás de NAT simétrico, o peer passa pelo relay TURN:
+// o endpoint WireGuard aponta para o proxy local e os pacotes atravessam o relay nos dois
+// sentidos até a parada, que devolve o endpoint configurado
+// TestTURNRelayFallback checks that behind a symmetric NAT the peer goes through the TURN
+// relay: the WireGuard endpoint points at the local proxy and packets cross the relay in
+// both directions until stop, which restores the configured endpoint
+// TestTURNRelayFallback verifica que, detrás de NAT simétrico, el par pasa por el relay
+// TURN: el endpoint WireGuard apunta al proxy local y los paquetes cruzan el relay en
+// ambos sentidos hasta la parada, que restaura el endpoint configurado
+func TestTURNRelayFallback(t *testing.T) {
+	server := newFakeTURNServer(t)
+	service := newRFC5780Service(t)
+
+	simulator, err := nattraversal.NewNATSimulator(nattraversal.SimulateSymmetric, "127.0.0.3", "10.0.2.0/24")
+	if err != nil {
+		t.Fatalf("Falha ao criar simulador: %v", err)
+	}
+	defer simulator.Stop()
+
+	// Socket que faz o papel do WireGuard local
+	wg, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do WireGuard: %v", err)
+	}
+	defer wg.Close()
+	wgPort := wg.LocalAddr().(*net.UDPAddr).Port
+
+	vpn := newFakeVPN(t, "node-a", "10.0.0.1")
+	traversal := nattraversal.NewNATTraversal(wgPort)
+	traversal.SetPortMappers()
+	traversal.SetSTUNServers([]nattraversal.STUNServer{service.Server()})
+	traversal.SetSTUNRetransmission(20*time.Millisecond, 3)
+	traversal.SetPacketListener(simulator.ListenPacket)
+	traversal.SetTURNServers([]nattraversal.TURNServer{server.server(fakeTURNPassword)})
+	traversal.SetEndpointUpdater(vpn)
+	traversal.Refresh()
+	if info := traversal.GetNATInfo(); info.Type != "symmetric" {
+		t.Fatalf("NAT simétrico esperado, detectado %q", info.Type)
+	}
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+	defer traversal.Stop()
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket do peer: %v", err)
+	}
+	defer peer.Close()
+	peerEndpoint := peer.LocalAddr().String()
+
+	if err := traversal.ConnectPeer("node-b", []string{peerEndpoint}); err != nil {
+		t.Fatalf("Falha ao conectar via relay: %v", err)
+	}
+	relayed := traversal.RelayedPeers()
+	if status := relayed["node-b"]; status.Technique != nattraversal.TechniqueTURN || status.Peer != peerEndpoint {
+		t.Errorf("Peer deveria constar como relayed: %v", relayed)
+	}
+
+	proxy, err := net.ResolveUDPAddr("udp4", vpn.activeEndpoint("node-b"))
+	if err != nil || !proxy.IP.IsLoopback() {
+		t.Fatalf("Endpoint WireGuard deveria ser o proxy local, obtido %q", vpn.activeEndpoint("node-b"))
+	}
+
+	// WireGuard -> proxy -> canal TURN -> peer, saindo do endereço relayed
+	wg.WriteToUDP([]byte("handshake"), proxy)
+	data, src := readUDP(t, peer)
+	if string(data) != "handshake" || src.String() != server.relayAddr().String() {
+		t.Fatalf("Peer recebeu %q de %s, esperado handshake do endereço relayed", data, src)
+	}
+
+	// Peer -> endereço relayed -> canal TURN -> proxy -> WireGuard
+	peer.WriteToUDP([]byte("resposta"), src)
+	data, src = readUDP(t, wg)
+	if string(data) != "resposta" || src.String() != proxy.String() {
+		t.Errorf("WireGuard recebeu %q de %s, esperado resposta do proxy %s", data, src, proxy)
+	}
+
+	// A parada remove a alocação e devolve o peer ao endpoint configurado
+	traversal.Stop()
+	if endpoint := vpn.activeEndpoint("node-b"); endpoint != "" {
+		t.Errorf("Endpoint do relay deveria ser desfeito na parada, obtido %q", endpoint)
+	}
+	if _, released, _ := server.counters(); released != 1 {
+		t.Error("Alocação TURN não foi removida na parada")
+	}
+}
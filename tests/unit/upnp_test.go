@@ -0,0 +1,259 @@
+package unit_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+)
+
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList><device>
+      <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+      <deviceList><device>
+        <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+        <serviceList><service>
+          <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+          <controlURL>/ctl/IPConn</controlURL>
+        </service></serviceList>
+      </device></deviceList>
+    </device></deviceList>
+  </device>
+</root>`
+
+// fakeIGD simula um gateway UPnP: responde ao M-SEARCH e atende as chamadas SOAP de mapeamento
+type fakeIGD struct {
+	ssdp *net.UDPConn
+	http *httptest.Server
+
+	permanentOnly bool // Recusar leases com erro 725
+	taken         int  // Porta externa já ocupada, recusada com erro 718
+
+	adds    []map[string]string
+	deletes []map[string]string
+	mutex   sync.Mutex
+}
+
+func newFakeIGD(t *testing.T) *fakeIGD {
+	igd := &fakeIGD{}
+
+	igd.http = httptest.NewServer(http.HandlerFunc(igd.handleHTTP))
+	t.Cleanup(igd.http.Close)
+
+	ssdp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Falha ao abrir socket SSDP: %v", err)
+	}
+	t.Cleanup(func() { ssdp.Close() })
+	igd.ssdp = ssdp
+	go igd.serveSSDP()
+
+	return igd
+}
+
+func (igd *fakeIGD) client() *nattraversal.UPnPClient {
+	client := nattraversal.NewUPnPClient()
+	client.SetSSDPAddr(igd.ssdp.LocalAddr().String())
+	client.SetTimeout(500 * time.Millisecond)
+	return client
+}
+
+func (igd *fakeIGD) serveSSDP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := igd.ssdp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+			continue
+		}
+		response := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=120\r\n" +
+			"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+			"LOCATION: " + igd.http.URL + "/rootDesc.xml\r\n\r\n"
+		igd.ssdp.WriteToUDP([]byte(response), addr)
+	}
+}
+
+func (igd *fakeIGD) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rootDesc.xml" {
+		w.Write([]byte(fakeIGDDescription))
+		return
+	}
+	if r.URL.Path != "/ctl/IPConn" {
+		http.NotFound(w, r)
+		return
+	}
+
+	action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	action = action[strings.Index(action, "#")+1:]
+	args := soapArguments(r.Body)
+
+	igd.mutex.Lock()
+	defer igd.mutex.Unlock()
+
+	switch action {
+	case "GetExternalIPAddress":
+		soapReply(w, action, "<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>")
+	case "AddPortMapping":
+		if igd.permanentOnly && args["NewLeaseDuration"] != "0" {
+			soapFault(w, 725, "OnlyPermanentLeasesSupported")
+			return
+		}
+		if args["NewExternalPort"] == strconv.Itoa(igd.taken) {
+			soapFault(w, 718, "ConflictInMappingEntry")
+			return
+		}
+		igd.adds = append(igd.adds, args)
+		soapReply(w, action, "")
+	case "DeletePortMapping":
+		igd.deletes = append(igd.deletes, args)
+		soapReply(w, action, "")
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+func (igd *fakeIGD) calls() (adds, deletes []map[string]string) {
+	igd.mutex.Lock()
+	defer igd.mutex.Unlock()
+	return append(adds, igd.adds...), append(deletes, igd.deletes...)
+}
+
+// soapArguments extrai os argumentos de uma chamada SOAP pelo nome local dos elementos
+func soapArguments(body io.Reader) map[string]string {
+	args := make(map[string]string)
+	decoder := xml.NewDecoder(body)
+	var current string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return args
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			current = tok.Name.Local
+			args[current] = ""
+		case xml.CharData:
+			if current != "" {
+				args[current] += string(tok)
+			}
+		case xml.EndElement:
+			current = ""
+		}
+	}
+}
+
+func soapReply(w http.ResponseWriter, action, body string) {
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+		`<u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, body, action)
+}
+
+func soapFault(w http.ResponseWriter, code int, description string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, description)
+}
+
+// newMappingTraversal cria um NAT traversal que usa os protocolos de mapeamento informados
+// e um servidor STUN local
+func newMappingTraversal(t *testing.T, port int, mappers ...nattraversal.PortMapper) *nattraversal.NATTraversal {
+	stun := nattraversal.NewSTUNService("127.0.0.1:0", "")
+	if err := stun.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar servidor STUN: %v", err)
+	}
+	t.Cleanup(func() { stun.Stop() })
+
+	traversal := nattraversal.NewNATTraversal(port)
+	traversal.SetSTUNServers([]nattraversal.STUNServer{stun.Server()})
+	traversal.SetPortMappers(mappers...)
+	return traversal
+}
+
+// TestUPnPPortMapping verifica o mapeamento, a renovação do lease e a remoção no gateway
+// TestUPnPPortMapping checks the mapping, lease renewal and removal on the gateway
+// TestUPnPPortMapping verifica el mapeo, la renovación del lease y la eliminación en el gateway
+func TestUPnPPortMapping(t *testing.T) {
+	igd := newFakeIGD(t)
+	port := freeUDPPort(t)
+
+	traversal := newMappingTraversal(t, port, igd.client())
+	traversal.SetPortMappingLease(2 * time.Second)
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+
+	expected := fmt.Sprintf("203.0.113.7:%d", port)
+	if !waitFor(3*time.Second, func() bool {
+		candidates := traversal.EndpointCandidates()
+		return len(candidates) == 1 && candidates[0] == expected
+	}) {
+		t.Fatalf("Endpoint UPnP não informado: %v", traversal.EndpointCandidates())
+	}
+
+	adds, _ := igd.calls()
+	first := adds[0]
+	if first["NewProtocol"] != "UDP" || first["NewInternalPort"] != strconv.Itoa(port) ||
+		first["NewInternalClient"] != "127.0.0.1" || first["NewLeaseDuration"] != "2" {
+		t.Errorf("AddPortMapping com argumentos inesperados: %v", first)
+	}
+
+	// O lease de 2s é renovado na metade do prazo
+	if !waitFor(3*time.Second, func() bool { adds, _ := igd.calls(); return len(adds) >= 2 }) {
+		t.Error("Mapeamento UPnP não foi renovado antes do fim do lease")
+	}
+
+	traversal.Stop()
+	_, deletes := igd.calls()
+	if len(deletes) != 1 || deletes[0]["NewExternalPort"] != strconv.Itoa(port) {
+		t.Errorf("DeletePortMapping esperado na parada, obtido %v", deletes)
+	}
+	if len(traversal.EndpointCandidates()) != 0 {
+		t.Error("Endpoint UPnP deveria sumir após a remoção do mapeamento")
+	}
+}
+
+// TestUPnPPermanentLeaseAndConflict verifica os gateways que só aceitam leases permanentes
+// e a troca de porta quando a porta externa já está ocupada
+// TestUPnPPermanentLeaseAndConflict checks permanent-only gateways and the port change on conflicts
+// TestUPnPPermanentLeaseAndConflict verifica gateways que solo aceptan leases permanentes y el cambio de puerto
+func TestUPnPPermanentLeaseAndConflict(t *testing.T) {
+	igd := newFakeIGD(t)
+	port := freeUDPPort(t)
+	igd.permanentOnly = true
+	igd.taken = port
+
+	traversal := newMappingTraversal(t, port, igd.client())
+	if err := traversal.Start(); err != nil {
+		t.Fatalf("Falha ao iniciar NAT traversal: %v", err)
+	}
+	defer traversal.Stop()
+
+	if !waitFor(3*time.Second, func() bool { return len(traversal.EndpointCandidates()) == 1 }) {
+		t.Fatal("Mapeamento UPnP não configurado")
+	}
+
+	adds, _ := igd.calls()
+	if len(adds) != 1 || adds[0]["NewLeaseDuration"] != "0" || adds[0]["NewExternalPort"] == strconv.Itoa(port) {
+		t.Errorf("Esperado mapeamento permanente em outra porta, obtido %v", adds)
+	}
+	if candidate := traversal.EndpointCandidates()[0]; candidate != "203.0.113.7:"+adds[0]["NewExternalPort"] {
+		t.Errorf("Endpoint UPnP não corresponde ao mapeamento: %s", candidate)
+	}
+}
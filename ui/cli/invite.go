@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inviteAssignedIP string
+	inviteTTL        time.Duration
+	inviteSingleUse  bool
+	inviteEndpoints  []string
+	joinTimeout      time.Duration
+)
+
+// inviteCmd representa o comando base para convites
+// inviteCmd represents the base command for invites
+// inviteCmd representa el comando base para invitaciones
+var inviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Gerenciar convites para a rede",
+	Long: `Cria convites assinados que permitem a outro nó entrar na rede
+com um único comando, sem copiar chaves manualmente.
+
+Creates signed invites that let another node join the network
+with a single command, without copying keys by hand.
+
+Crea invitaciones firmadas que permiten a otro nodo unirse a la red
+con un solo comando, sin copiar claves manualmente.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var inviteCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Criar um novo convite",
+	Run: func(cmd *cobra.Command, args []string) {
+		absConfigPath, err := filepath.Abs(configPath)
+		if err != nil {
+			fmt.Printf("Erro ao obter caminho absoluto para configuração: %v\n", err)
+			return
+		}
+
+		config, err := core.LoadConfig(absConfigPath)
+		if err != nil {
+			fmt.Printf("Erro ao carregar configuração: %v\n", err)
+			return
+		}
+
+		store := discovery.NewInviteStore(discovery.InviteStorePath(absConfigPath))
+
+		// Atribuir automaticamente um IP livre da rede virtual
+		if inviteAssignedIP == "" {
+			inviteAssignedIP, err = discovery.NextFreeIP(config, store)
+			if err != nil {
+				fmt.Printf("Erro ao escolher IP para o convidado: %v\n", err)
+				return
+			}
+		}
+
+		invite, err := discovery.NewInvite(config, listenPort, discoveryPort, inviteEndpoints,
+			inviteAssignedIP, inviteTTL, inviteSingleUse)
+		if err != nil {
+			fmt.Printf("Erro ao criar convite: %v\n", err)
+			return
+		}
+
+		signingKey, err := config.SigningKey()
+		if err != nil {
+			fmt.Printf("Erro ao obter chave de assinatura: %v\n", err)
+			return
+		}
+
+		token, err := discovery.EncodeInvite(invite, signingKey)
+		if err != nil {
+			fmt.Printf("Erro ao codificar convite: %v\n", err)
+			return
+		}
+
+		if err := store.Add(invite); err != nil {
+			fmt.Printf("Erro ao registrar convite: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Convite criado para o IP %s (válido até %s).\n",
+			invite.AssignedIP, time.Unix(invite.Expires, 0).Format(time.RFC1123))
+		fmt.Println("Envie o comando abaixo ao convidado. O serviço de VPN deste nó precisa estar em execução.")
+		fmt.Println()
+		fmt.Printf("  p2p-vpn join %s\n", token)
+	},
+}
+
+var inviteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Listar convites válidos",
+	Run: func(cmd *cobra.Command, args []string) {
+		absConfigPath, err := filepath.Abs(configPath)
+		if err != nil {
+			fmt.Printf("Erro ao obter caminho absoluto para configuração: %v\n", err)
+			return
+		}
+
+		invites, err := discovery.NewInviteStore(discovery.InviteStorePath(absConfigPath)).List()
+		if err != nil {
+			fmt.Printf("Erro ao carregar convites: %v\n", err)
+			return
+		}
+
+		if len(invites) == 0 {
+			fmt.Println("Nenhum convite válido.")
+			return
+		}
+
+		fmt.Println("Convites válidos:")
+		fmt.Println("--------------------------------------------------")
+		for _, invite := range invites {
+			fmt.Printf("ID: %s\n", invite.ID)
+			fmt.Printf("   IP atribuído: %s\n", invite.AssignedIP)
+			fmt.Printf("   Expira em: %s\n", time.Unix(invite.Expires, 0).Format(time.RFC1123))
+			fmt.Printf("   Uso único: %v (usado %d vezes)\n", invite.SingleUse, len(invite.UsedBy))
+			fmt.Println("--------------------------------------------------")
+		}
+	},
+}
+
+// joinCmd representa o comando para entrar em uma rede com um convite
+// joinCmd represents the command to join a network with an invite
+// joinCmd representa el comando para unirse a una red con una invitación
+var joinCmd = &cobra.Command{
+	Use:   "join <token>",
+	Short: "Entrar em uma rede usando um convite",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		invite, inviterSigningKey, err := discovery.DecodeInvite(args[0])
+		if err != nil {
+			fmt.Printf("Convite inválido: %v\n", err)
+			return
+		}
+
+		absConfigPath, err := filepath.Abs(configPath)
+		if err != nil {
+			fmt.Printf("Erro ao obter caminho absoluto para configuração: %v\n", err)
+			return
+		}
+
+		config, err := core.LoadConfig(absConfigPath)
+		if err != nil {
+			fmt.Println("Criando nova configuração...")
+			config = core.GenerateDefaultConfig(absConfigPath)
+		}
+
+		// Adotar o endereçamento definido pelo anfitrião
+		if invite.AssignedIP != "" {
+			config.VirtualIP = invite.AssignedIP
+		}
+		if invite.VirtualCIDR != "" {
+			config.VirtualCIDR = invite.VirtualCIDR
+		}
+
+		fmt.Printf("Entrando na rede de %s...\n", invite.NodeID)
+
+		if err := discovery.JoinNetwork(invite, inviterSigningKey, config, listenPort, joinTimeout); err != nil {
+			fmt.Printf("Erro ao entrar na rede: %v\n", err)
+			return
+		}
+
+		config.AddTrustedPeer(core.TrustedPeer{
+			NodeID:     invite.NodeID,
+			PublicKey:  invite.PublicKey,
+			VirtualIP:  invite.VirtualIP,
+			Endpoints:  invite.Endpoints,
+			SigningKey: inviterSigningKey,
+		})
+
+		if err := config.SaveConfig(absConfigPath); err != nil {
+			fmt.Printf("Erro ao salvar configuração: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Entrada na rede concluída! Seu IP virtual é %s.\n", config.VirtualIP)
+		fmt.Println("Inicie o serviço com 'p2p-vpn start' para conectar.")
+	},
+}
+
+func init() {
+	inviteCmd.AddCommand(inviteCreateCmd)
+	inviteCmd.AddCommand(inviteListCmd)
+
+	// Flags para o comando create
+	inviteCreateCmd.Flags().StringVar(&inviteAssignedIP, "ip", "", "IP virtual atribuído ao convidado (padrão: próximo IP livre)")
+	inviteCreateCmd.Flags().DurationVar(&inviteTTL, "ttl", 24*time.Hour, "Validade do convite")
+	inviteCreateCmd.Flags().BoolVar(&inviteSingleUse, "single-use", true, "Permitir apenas um uso do convite")
+	inviteCreateCmd.Flags().StringSliceVar(&inviteEndpoints, "endpoint", nil, "Endpoint público deste nó (ex: 123.45.67.89:51820)")
+	inviteCreateCmd.Flags().IntVar(&listenPort, "port", 51820, "Porta local para o serviço WireGuard")
+	inviteCreateCmd.Flags().IntVar(&discoveryPort, "discovery-port", 51821, "Porta para o serviço de descoberta de peers")
+
+	// Flags para o comando join
+	joinCmd.Flags().IntVar(&listenPort, "port", 51820, "Porta local para o serviço WireGuard")
+	joinCmd.Flags().DurationVar(&joinTimeout, "timeout", 15*time.Second, "Tempo máximo de espera pela resposta do anfitrião")
+}
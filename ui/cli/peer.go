@@ -3,17 +3,20 @@ package cli
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
 	"github.com/spf13/cobra"
 )
 
 var (
-	peerNodeID    string
-	peerPublicKey string
-	peerVirtualIP string
-	peerEndpoint  string
-	peerKeepAlive int
+	peerNodeID     string
+	peerPublicKey  string
+	peerVirtualIP  string
+	peerEndpoint   string
+	peerKeepAlive  int
+	peerSigningKey string
 )
 
 // peerCmd representa o comando base para gerenciamento de peers
@@ -48,6 +51,15 @@ var peerAddCmd = &cobra.Command{
 			return
 		}
 
+		// Sem a chave de assinatura, a descoberta recusa os anúncios do peer
+		if peerSigningKey == "" {
+			fmt.Println("Aviso: sem --signing-key, o peer só é alcançado pelos endpoints configurados;")
+			fmt.Println("os anúncios de descoberta dele serão recusados. Obtenha a chave com 'peer identity' no peer.")
+		} else if _, err := core.ParseSigningPublicKey(peerSigningKey); err != nil {
+			fmt.Printf("Erro: %v\n", err)
+			return
+		}
+
 		// Carregar configuração
 		absConfigPath, err := filepath.Abs(configPath)
 		if err != nil {
@@ -68,9 +80,10 @@ var peerAddCmd = &cobra.Command{
 
 		// Criar o peer
 		peer := core.TrustedPeer{
-			NodeID:    peerNodeID,
-			PublicKey: peerPublicKey,
-			VirtualIP: peerVirtualIP,
+			NodeID:     peerNodeID,
+			PublicKey:  peerPublicKey,
+			VirtualIP:  peerVirtualIP,
+			SigningKey: peerSigningKey,
 		}
 
 		// Adicionar endpoint se fornecido
@@ -166,6 +179,11 @@ var peerListCmd = &cobra.Command{
 			fmt.Printf("%d. ID: %s\n", i+1, peer.NodeID)
 			fmt.Printf("   IP virtual: %s\n", peer.VirtualIP)
 			fmt.Printf("   Chave pública: %s\n", peer.PublicKey)
+			if peer.SigningKey != "" {
+				fmt.Printf("   Chave de assinatura: %s\n", peer.SigningKey)
+			} else {
+				fmt.Println("   Chave de assinatura: não registrada (anúncios de descoberta recusados)")
+			}
 			
 			if len(peer.Endpoints) > 0 {
 				fmt.Printf("   Endpoints: %s\n", peer.Endpoints)
@@ -180,11 +198,202 @@ var peerListCmd = &cobra.Command{
 	},
 }
 
+var peerIdentityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Mostrar as chaves deste nó para configurá-lo em outro peer",
+	Run: func(cmd *cobra.Command, args []string) {
+		absConfigPath, err := filepath.Abs(configPath)
+		if err != nil {
+			fmt.Printf("Erro ao obter caminho absoluto para configuração: %v\n", err)
+			return
+		}
+
+		config, err := core.LoadConfig(absConfigPath)
+		if err != nil {
+			fmt.Printf("Erro ao carregar configuração: %v\n", err)
+			return
+		}
+
+		signingKey, err := config.SigningPublicKey()
+		if err != nil {
+			fmt.Printf("Erro ao obter chave de assinatura: %v\n", err)
+			return
+		}
+
+		fmt.Printf("ID: %s\n", config.NodeID)
+		fmt.Printf("IP virtual: %s\n", config.VirtualIP)
+		fmt.Printf("Chave pública: %s\n", config.PublicKey)
+		fmt.Printf("Chave de assinatura: %s\n", signingKey)
+		fmt.Printf("Impressão digital: %s\n", discovery.Fingerprint(config.PublicKey, signingKey))
+		fmt.Println()
+		fmt.Println("Para adicionar este nó em outro peer:")
+		fmt.Printf("  p2p-vpn peer add --id %s --pubkey %s --ip %s --signing-key %s\n",
+			config.NodeID, config.PublicKey, config.VirtualIP, signingKey)
+	},
+}
+
+// pendingPeerStore abre a fila de aprovação ao lado do arquivo de configuração
+func pendingPeerStore() (*discovery.PendingStore, error) {
+	absConfigPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter caminho absoluto para configuração: %w", err)
+	}
+	return discovery.NewPendingStore(discovery.PendingStorePath(absConfigPath)), nil
+}
+
+var peerPendingCmd = &cobra.Command{
+	Use:   "pending",
+	Short: "Listar peers descobertos que aguardam aprovação",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := pendingPeerStore()
+		if err != nil {
+			fmt.Printf("Erro: %v\n", err)
+			return
+		}
+
+		pending, err := store.List()
+		if err != nil {
+			fmt.Printf("Erro ao ler peers pendentes: %v\n", err)
+			return
+		}
+
+		if len(pending) == 0 {
+			fmt.Println("Nenhum peer aguardando aprovação.")
+			return
+		}
+
+		// Anúncios de peers já configurados, mas sem chave de assinatura, são fixados com 'peer pin'
+		unpinned := make(map[string]bool)
+		if absConfigPath, err := filepath.Abs(configPath); err == nil {
+			if config, err := core.LoadConfig(absConfigPath); err == nil {
+				for _, peer := range config.TrustedPeers {
+					if peer.SigningKey == "" {
+						unpinned[peer.PublicKey] = true
+					}
+				}
+			}
+		}
+
+		fmt.Println("Peers aguardando aprovação:")
+		fmt.Println("--------------------------------------------------")
+		for i, peer := range pending {
+			fmt.Printf("%d. ID: %s\n", i+1, peer.NodeID)
+			if peer.Conflict {
+				fmt.Println("   ATENÇÃO: outro pedido usa o mesmo ID ou a mesma chave; confira a impressão digital com o dono do nó")
+			}
+			if unpinned[peer.PublicKey] {
+				fmt.Println("   Peer configurado sem chave de assinatura: use 'peer pin' depois de conferir a impressão digital")
+			}
+			fmt.Printf("   Impressão digital: %s\n", peer.Fingerprint)
+			fmt.Printf("   Chave pública: %s\n", peer.PublicKey)
+			fmt.Printf("   IP virtual: %s\n", peer.VirtualIP)
+			fmt.Printf("   Origem: %s\n", peer.SourceAddr)
+			fmt.Printf("   Visto pela primeira vez: %s\n", time.Unix(peer.FirstSeen, 0).Format(time.RFC3339))
+			fmt.Println("--------------------------------------------------")
+		}
+		fmt.Println("Use 'peer approve <impressão digital>' ou 'peer reject <impressão digital>'.")
+		fmt.Println("O dono do nó vê a impressão digital com 'peer identity'.")
+	},
+}
+
+var peerApproveCmd = &cobra.Command{
+	Use:   "approve <impressão digital>",
+	Short: "Aprovar um peer pendente",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := pendingPeerStore()
+		if err != nil {
+			fmt.Printf("Erro: %v\n", err)
+			return
+		}
+
+		peer, err := store.Approve(args[0])
+		if err != nil {
+			fmt.Printf("Erro ao aprovar peer: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Peer %s (%s) aprovado.\n", peer.NodeID, peer.Fingerprint)
+		fmt.Println("Ele será configurado no próximo anúncio recebido pelo serviço de descoberta.")
+	},
+}
+
+var peerPinCmd = &cobra.Command{
+	Use:   "pin <impressão digital>",
+	Short: "Fixar a chave de assinatura de um peer configurado sem ela",
+	Long: `Fixa a chave de assinatura de um peer confiável configurado sem ela, a partir do anúncio
+pendente com a impressão digital informada (veja 'peer pending'). Confira a impressão
+digital com o dono do nó ('peer identity') antes de fixá-la.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		absConfigPath, err := filepath.Abs(configPath)
+		if err != nil {
+			fmt.Printf("Erro ao obter caminho absoluto para configuração: %v\n", err)
+			return
+		}
+
+		config, err := core.LoadConfig(absConfigPath)
+		if err != nil {
+			fmt.Printf("Erro ao carregar configuração: %v\n", err)
+			return
+		}
+
+		store, err := pendingPeerStore()
+		if err != nil {
+			fmt.Printf("Erro: %v\n", err)
+			return
+		}
+
+		// A configuração é gravada antes de o pedido sair da fila
+		peer, err := store.Pin(args[0], func(pending discovery.PendingPeer) error {
+			pinned, err := discovery.PinTrustedPeer(config.TrustedPeers, pending)
+			if err != nil {
+				return err
+			}
+			config.AddTrustedPeer(pinned)
+			return config.SaveConfig(absConfigPath)
+		})
+		if err != nil {
+			fmt.Printf("Erro ao fixar chave de assinatura: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Chave de assinatura do peer %s (%s) fixada.\n", peer.NodeID, peer.Fingerprint)
+		fmt.Println("Reinicie o serviço de VPN para aplicar as alterações.")
+	},
+}
+
+var peerRejectCmd = &cobra.Command{
+	Use:   "reject <impressão digital>",
+	Short: "Recusar um peer pendente e bloquear sua chave",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := pendingPeerStore()
+		if err != nil {
+			fmt.Printf("Erro: %v\n", err)
+			return
+		}
+
+		peer, err := store.Reject(args[0])
+		if err != nil {
+			fmt.Printf("Erro ao recusar peer: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Peer %s (%s) recusado e bloqueado.\n", peer.NodeID, peer.Fingerprint)
+	},
+}
+
 func init() {
 	// Adicionar subcomandos ao comando peer
 	peerCmd.AddCommand(peerAddCmd)
 	peerCmd.AddCommand(peerRemoveCmd)
 	peerCmd.AddCommand(peerListCmd)
+	peerCmd.AddCommand(peerIdentityCmd)
+	peerCmd.AddCommand(peerPendingCmd)
+	peerCmd.AddCommand(peerApproveCmd)
+	peerCmd.AddCommand(peerPinCmd)
+	peerCmd.AddCommand(peerRejectCmd)
 
 	// Flags para o comando add
 	peerAddCmd.Flags().StringVar(&peerNodeID, "id", "", "ID do peer (opcional)")
@@ -192,6 +401,7 @@ func init() {
 	peerAddCmd.Flags().StringVar(&peerVirtualIP, "ip", "", "IP virtual do peer (obrigatório)")
 	peerAddCmd.Flags().StringVar(&peerEndpoint, "endpoint", "", "Endpoint do peer (ex: 123.45.67.89:51820)")
 	peerAddCmd.Flags().IntVar(&peerKeepAlive, "keepalive", 0, "Intervalo de keepalive em segundos")
+	peerAddCmd.Flags().StringVar(&peerSigningKey, "signing-key", "", "Chave de assinatura da descoberta do peer (veja 'peer identity')")
 
 	// Flags para o comando remove
 	peerRemoveCmd.Flags().StringVar(&peerNodeID, "id", "", "ID do peer a ser removido (obrigatório)")
@@ -58,4 +58,6 @@ func init() {
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(peerCmd)
 	rootCmd.AddCommand(connectCmd)
+	rootCmd.AddCommand(inviteCmd)
+	rootCmd.AddCommand(joinCmd)
 }
@@ -1,11 +1,16 @@
 package cli
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
 	"github.com/p2p-vpn/p2p-vpn/discovery"
+	"github.com/p2p-vpn/p2p-vpn/node"
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +18,11 @@ var (
 	listenPort    int
 	discoveryPort int
 	interfaceName string
+
+	discoveryFlags *node.DiscoveryFlags
+	natFlags       *node.NATFlags
+
+	securityConfigPath string
 )
 
 // startCmd representa o comando para iniciar o serviço de VPN
@@ -55,6 +65,9 @@ red virtual y estableciendo conexiones con pares conocidos.`,
 			config.InterfaceName = interfaceName
 		}
 		
+		discoveryFlags.Apply(&config.Discovery)
+		natFlags.Apply(&config.NAT)
+		
 		// Inicializar o core da VPN
 		vpnCore, err := core.NewVPNCore(config, listenPort)
 		if err != nil {
@@ -69,6 +82,24 @@ red virtual y estableciendo conexiones con pares conocidos.`,
 			return
 		}
 		
+		peerDiscovery.SetWireGuardPort(listenPort)
+		peerDiscovery.SetConfigPath(absConfigPath)
+		peerDiscovery.SetInviteStore(discovery.NewInviteStore(discovery.InviteStorePath(absConfigPath)))
+		peerDiscovery.SetPendingStore(discovery.NewPendingStore(discovery.PendingStorePath(absConfigPath)))
+		
+		// Aplicar o controle de acesso da VPN (vpn.access) aos peers descobertos
+		if _, err := node.ApplyTrustPolicy(peerDiscovery, securityConfigPath); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		
+		// Travessia de NAT, servidor STUN embutido e monitor de rede, validados antes de iniciar os serviços
+		nat, err := node.SetupNAT(config, listenPort, vpnCore, peerDiscovery)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		
 		// Iniciar os serviços
 		if err := vpnCore.Start(); err != nil {
 			fmt.Printf("Erro ao iniciar o core da VPN: %v\n", err)
@@ -81,15 +112,24 @@ red virtual y estableciendo conexiones con pares conocidos.`,
 			return
 		}
 		
+		nat.Start()
+		
 		fmt.Println("VPN P2P iniciada com sucesso!")
 		fmt.Printf("Escutando na porta %d (WireGuard) e %d (Descoberta)\n", listenPort, discoveryPort)
 		fmt.Printf("Seu ID de nó é: %s\n", config.NodeID)
 		fmt.Printf("Seu IP virtual é: %s\n", config.VirtualIP)
 		fmt.Println("Pressione Ctrl+C para encerrar.")
 		
-		// Manter o processo em execução
-		// Em um caso real, isso seria gerenciado como um serviço
-		select {}
+		// Manter o processo em execução até receber um sinal de encerramento
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		
+		fmt.Println("\nEncerrando...")
+		nat.Stop()
+		peerDiscovery.Stop()
+		vpnCore.Stop()
+		fmt.Println("VPN P2P encerrada com sucesso!")
 	},
 }
 
@@ -98,4 +138,11 @@ func init() {
 	startCmd.Flags().IntVar(&listenPort, "port", 51820, "Porta local para o serviço WireGuard")
 	startCmd.Flags().IntVar(&discoveryPort, "discovery-port", 51821, "Porta para o serviço de descoberta de peers")
 	startCmd.Flags().StringVar(&interfaceName, "interface", "", "Nome da interface WireGuard (padrão: wg0)")
+	startCmd.Flags().StringVar(&securityConfigPath, "security-config", node.DefaultSecurityConfigPath, "Caminho para o arquivo de configuração de segurança")
+
+	// Opções de descoberta e de NAT com os mesmos nomes e padrões do executável principal
+	sharedFlagSet := flag.NewFlagSet("start", flag.ContinueOnError)
+	discoveryFlags = node.RegisterDiscoveryFlags(sharedFlagSet)
+	natFlags = node.RegisterNATFlags(sharedFlagSet)
+	startCmd.Flags().AddGoFlagSet(sharedFlagSet)
 }
@@ -80,6 +80,11 @@ type UIConfig struct {
 	// AutoStart define si la aplicación debe iniciarse automáticamente con el sistema
 	AutoStart bool
 
+	// PendingPeersFile arquivo da fila de aprovação de peers (vazio desativa o aviso)
+	// PendingPeersFile approval queue file for peers (empty disables the alert)
+	// PendingPeersFile archivo de la cola de aprobación de pares (vacío desactiva el aviso)
+	PendingPeersFile string
+
 	// Assets contém caminhos para os recursos de UI
 	// Assets contains paths to UI resources
 	// Assets contiene rutas a los recursos de UI
@@ -1,7 +1,11 @@
 package desktop
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
 	"github.com/p2p-vpn/p2p-vpn/ui/desktop/common"
 	"github.com/p2p-vpn/p2p-vpn/ui/desktop/platform"
 )
@@ -14,16 +18,21 @@ type UIManager struct {
 	appUI      common.DesktopUI
 	platformUI platform.PlatformUI
 	config     *common.UIConfig
+	stopChan   chan struct{}
 }
 
+// pendingCheckInterval é o intervalo de verificação da fila de aprovação de peers
+const pendingCheckInterval = 15 * time.Second
+
 // NewUIManager cria uma nova instância do gerenciador de UI de desktop
 // NewUIManager creates a new instance of the desktop UI manager
 // NewUIManager crea una nueva instancia del gestor de UI de escritorio
 func NewUIManager(vpnCore core.VPNProvider, config *common.UIConfig) (*UIManager, error) {
 	// Criar instância do manager
 	manager := &UIManager{
-		vpnCore: vpnCore,
-		config:  config,
+		vpnCore:  vpnCore,
+		config:   config,
+		stopChan: make(chan struct{}),
 	}
 
 	// Inicializar UI específica da plataforma
@@ -53,6 +62,11 @@ func (m *UIManager) Start() error {
 		return err
 	}
 
+	// Avisar sobre peers descobertos que aguardam aprovação
+	if m.config.PendingPeersFile != "" {
+		go m.watchPendingPeers()
+	}
+
 	// Iniciar a UI principal
 	return m.appUI.Run()
 }
@@ -61,6 +75,12 @@ func (m *UIManager) Start() error {
 // Stop stops the desktop UI
 // Stop detiene la UI de escritorio
 func (m *UIManager) Stop() error {
+	select {
+	case <-m.stopChan:
+	default:
+		close(m.stopChan)
+	}
+
 	if m.platformUI != nil {
 		err := m.platformUI.Cleanup()
 		if err != nil {
@@ -70,3 +90,39 @@ func (m *UIManager) Stop() error {
 	
 	return nil
 }
+
+// watchPendingPeers exibe uma notificação para cada novo peer na fila de aprovação
+// watchPendingPeers shows a notification for each new peer in the approval queue
+// watchPendingPeers muestra una notificación por cada nuevo par en la cola de aprobación
+func (m *UIManager) watchPendingPeers() {
+	store := discovery.NewPendingStore(m.config.PendingPeersFile)
+	notified := make(map[string]bool)
+
+	ticker := time.NewTicker(pendingCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		pending, err := store.List()
+		if err == nil {
+			for _, peer := range pending {
+				if notified[peer.Fingerprint] {
+					continue
+				}
+				notified[peer.Fingerprint] = true
+
+				message := fmt.Sprintf("%s (%s) de %s. Aprove com: p2p-vpn peer approve %s",
+					peer.NodeID, peer.Fingerprint, peer.SourceAddr, peer.Fingerprint)
+				if peer.Conflict {
+					message += ". Atenção: outro pedido usa o mesmo ID ou a mesma chave"
+				}
+				m.appUI.ShowNotification("Novo peer aguardando aprovação", message, common.PriorityNormal)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+	}
+}
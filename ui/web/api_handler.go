@@ -2,10 +2,14 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+	"github.com/p2p-vpn/p2p-vpn/platform"
 )
 
 // APIHandler gerencia as requisições API para o frontend
@@ -14,6 +18,9 @@ import (
 type APIHandler struct {
 	vpnCore core.VPNProvider
 	config  *core.Config
+	pending *discovery.PendingStore
+	network *platform.NetworkMonitor
+	nat     *nattraversal.NATTraversal
 }
 
 // NewAPIHandler cria um novo manipulador de API
@@ -26,6 +33,36 @@ func NewAPIHandler(vpnCore core.VPNProvider, config *core.Config) *APIHandler {
 	}
 }
 
+// SetPendingStore define a fila de aprovação de peers descobertos
+// SetPendingStore sets the approval queue for discovered peers
+// SetPendingStore define la cola de aprobación de pares descubiertos
+func (h *APIHandler) SetPendingStore(store *discovery.PendingStore) {
+	h.pending = store
+}
+
+// SetNetworkMonitor define o monitor cujas mudanças de rede são expostas à interface
+// SetNetworkMonitor sets the monitor whose network changes are exposed to the interface
+// SetNetworkMonitor define el monitor cuyos cambios de red se exponen a la interfaz
+func (h *APIHandler) SetNetworkMonitor(monitor *platform.NetworkMonitor) {
+	h.network = monitor
+}
+
+// SetNATTraversal define o NAT traversal que informa quais peers passam por relay
+// SetNATTraversal sets the NAT traversal reporting which peers go through a relay
+// SetNATTraversal define el NAT traversal que informa qué pares pasan por relay
+func (h *APIHandler) SetNATTraversal(nat *nattraversal.NATTraversal) {
+	h.nat = nat
+}
+
+// trustedPeers retorna uma cópia dos peers confiáveis; com a VPN disponível, a cópia é feita
+// sob o mutex do provedor, pois a descoberta adiciona peers em execução
+func (h *APIHandler) trustedPeers() []core.TrustedPeer {
+	if h.vpnCore != nil {
+		return h.vpnCore.TrustedPeers()
+	}
+	return h.config.CopyTrustedPeers()
+}
+
 // ServeHTTP implementa a interface http.Handler
 // ServeHTTP implements the http.Handler interface
 // ServeHTTP implementa la interfaz http.Handler
@@ -53,10 +90,20 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleGetPeers(w, r)
 	case path == "peers" && r.Method == "POST":
 		h.handleAddPeer(w, r)
+	case path == "peers/pending" && r.Method == "GET":
+		h.handleGetPendingPeers(w, r)
+	case path == "peers/pending/approve" && r.Method == "POST":
+		h.handlePendingDecision(w, r, true)
+	case path == "peers/pending/reject" && r.Method == "POST":
+		h.handlePendingDecision(w, r, false)
+	case path == "peers/pending/pin" && r.Method == "POST":
+		h.handlePendingPin(w, r)
 	case strings.HasPrefix(path, "peers/") && r.Method == "DELETE":
 		h.handleRemovePeer(w, r)
 	case path == "config" && r.Method == "GET":
 		h.handleGetConfig(w, r)
+	case path == "network/events" && r.Method == "GET":
+		h.handleGetNetworkEvents(w, r)
 	default:
 		// Rota não encontrada
 		http.Error(w, `{"error": "Endpoint não encontrado"}`, http.StatusNotFound)
@@ -79,7 +126,7 @@ func (h *APIHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
 		"node_id":       h.config.NodeID,
 		"virtual_ip":    h.config.VirtualIP,
 		"virtual_cidr":  h.config.VirtualCIDR,
-		"peers_count":   len(h.config.TrustedPeers),
+		"peers_count":   len(h.trustedPeers()),
 		"interface":     h.config.InterfaceName,
 	}
 
@@ -94,14 +141,21 @@ func (h *APIHandler) handleGetPeers(w http.ResponseWriter, r *http.Request) {
 	if h.vpnCore != nil && h.vpnCore.IsRunning() {
 		// Em uma implementação real, obteríamos isso do WireGuard
 		// Por ora, apenas simulamos
-		for _, peer := range h.config.TrustedPeers {
+		for _, peer := range h.trustedPeers() {
 			activePeers = append(activePeers, peer.NodeID)
 		}
 	}
 
+	// Peers sem caminho direto, com o relay por onde passam
+	var relayed map[string]nattraversal.RelayStatus
+	if h.nat != nil {
+		relayed = h.nat.RelayedPeers()
+	}
+
 	// Construir resposta
-	peersResponse := make([]map[string]interface{}, 0, len(h.config.TrustedPeers))
-	for _, peer := range h.config.TrustedPeers {
+	trusted := h.trustedPeers()
+	peersResponse := make([]map[string]interface{}, 0, len(trusted))
+	for _, peer := range trusted {
 		// Verificar se o peer está ativo
 		isActive := false
 		for _, activeID := range activePeers {
@@ -120,6 +174,14 @@ func (h *APIHandler) handleGetPeers(w http.ResponseWriter, r *http.Request) {
 			"active":      isActive,
 			"keep_alive":  peer.KeepAlive,
 			"allowed_ips": peer.AllowedIPs,
+			"path":        "direct",
+		}
+		if status, ok := relayed[peer.NodeID]; ok {
+			peerInfo["path"] = status.Technique
+			peerInfo["relay"] = status.Relay
+			if status.Transport != "" {
+				peerInfo["relay_transport"] = status.Transport
+			}
 		}
 		
 		peersResponse = append(peersResponse, peerInfo)
@@ -137,6 +199,7 @@ type PeerRequest struct {
 	Endpoints  []string `json:"endpoints"`
 	KeepAlive  int      `json:"keep_alive"`
 	AllowedIPs []string `json:"allowed_ips"`
+	SigningKey string   `json:"signing_key"` // Chave de assinatura da descoberta do peer
 }
 
 // handleAddPeer adiciona um novo peer à configuração
@@ -153,6 +216,12 @@ func (h *APIHandler) handleAddPeer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Chave pública e IP virtual são obrigatórios"}`, http.StatusBadRequest)
 		return
 	}
+	if req.SigningKey != "" {
+		if _, err := core.ParseSigningPublicKey(req.SigningKey); err != nil {
+			http.Error(w, `{"error": "Chave de assinatura inválida"}`, http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Gerar ID para o peer se não fornecido
 	if req.NodeID == "" {
@@ -167,6 +236,7 @@ func (h *APIHandler) handleAddPeer(w http.ResponseWriter, r *http.Request) {
 		Endpoints:  req.Endpoints,
 		KeepAlive:  req.KeepAlive,
 		AllowedIPs: req.AllowedIPs,
+		SigningKey: req.SigningKey,
 	}
 
 	// Adicionar à configuração
@@ -207,15 +277,15 @@ func (h *APIHandler) handleRemovePeer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Encontrar o peer na configuração
-	var targetPeer *core.TrustedPeer
-	for i, peer := range h.config.TrustedPeers {
+	found := false
+	for _, peer := range h.trustedPeers() {
 		if peer.NodeID == nodeID {
-			targetPeer = &h.config.TrustedPeers[i]
+			found = true
 			break
 		}
 	}
 
-	if targetPeer == nil {
+	if !found {
 		http.Error(w, `{"error": "Peer não encontrado"}`, http.StatusNotFound)
 		return
 	}
@@ -242,6 +312,122 @@ func (h *APIHandler) handleRemovePeer(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleGetPendingPeers retorna os peers descobertos que aguardam aprovação
+func (h *APIHandler) handleGetPendingPeers(w http.ResponseWriter, r *http.Request) {
+	if h.pending == nil {
+		json.NewEncoder(w).Encode([]discovery.PendingPeer{})
+		return
+	}
+
+	pending, err := h.pending.List()
+	if err != nil {
+		http.Error(w, `{"error": "Erro ao ler peers pendentes"}`, http.StatusInternalServerError)
+		return
+	}
+	if pending == nil {
+		pending = []discovery.PendingPeer{}
+	}
+
+	json.NewEncoder(w).Encode(pending)
+}
+
+// PendingDecisionRequest identifica o peer pendente pela impressão digital
+type PendingDecisionRequest struct {
+	ID string `json:"id"`
+}
+
+// handlePendingDecision aprova ou recusa um peer pendente; peers recusados são bloqueados
+func (h *APIHandler) handlePendingDecision(w http.ResponseWriter, r *http.Request, approve bool) {
+	if h.pending == nil {
+		http.Error(w, `{"error": "Fila de aprovação não configurada"}`, http.StatusNotFound)
+		return
+	}
+
+	var req PendingDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, `{"error": "Impressão digital do peer não fornecida"}`, http.StatusBadRequest)
+		return
+	}
+
+	var peer *discovery.PendingPeer
+	var err error
+	message := "Peer aprovado; será configurado no próximo anúncio"
+	if approve {
+		peer, err = h.pending.Approve(req.ID)
+	} else {
+		peer, err = h.pending.Reject(req.ID)
+		message = "Peer recusado e bloqueado"
+	}
+
+	if errors.Is(err, discovery.ErrPendingNotFound) {
+		http.Error(w, `{"error": "Peer pendente não encontrado"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Erro ao atualizar peers pendentes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":     true,
+		"message":     message,
+		"peer_id":     peer.NodeID,
+		"fingerprint": peer.Fingerprint,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePendingPin fixa a chave de assinatura de um peer confiável configurado sem ela, a partir
+// do anúncio pendente com a impressão digital informada
+func (h *APIHandler) handlePendingPin(w http.ResponseWriter, r *http.Request) {
+	if h.pending == nil {
+		http.Error(w, `{"error": "Fila de aprovação não configurada"}`, http.StatusNotFound)
+		return
+	}
+
+	var req PendingDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, `{"error": "Impressão digital do peer não fornecida"}`, http.StatusBadRequest)
+		return
+	}
+
+	var pinErr error
+	peer, err := h.pending.Pin(req.ID, func(pending discovery.PendingPeer) error {
+		pinned, err := discovery.PinTrustedPeer(h.trustedPeers(), pending)
+		if err != nil {
+			pinErr = err
+			return err
+		}
+		if h.vpnCore != nil {
+			return h.vpnCore.AddPeer(pinned)
+		}
+		h.config.AddTrustedPeer(pinned)
+		return nil
+	})
+
+	if errors.Is(err, discovery.ErrPendingNotFound) {
+		http.Error(w, `{"error": "Peer pendente não encontrado"}`, http.StatusNotFound)
+		return
+	}
+	if pinErr != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": pinErr.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Erro ao fixar chave de assinatura"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":     true,
+		"message":     "Chave de assinatura fixada",
+		"peer_id":     peer.NodeID,
+		"fingerprint": peer.Fingerprint,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleGetConfig retorna a configuração atual da VPN
 func (h *APIHandler) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	// Ocultar a chave privada por segurança
@@ -253,9 +439,19 @@ func (h *APIHandler) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 		"interface":     h.config.InterfaceName,
 		"mtu":           h.config.MTU,
 		"dns":           h.config.DNS,
-		"peers_count":   len(h.config.TrustedPeers),
+		"peers_count":   len(h.trustedPeers()),
 	}
 
 	// Enviar resposta
 	json.NewEncoder(w).Encode(configResponse)
 }
+
+// handleGetNetworkEvents retorna as mudanças de rede recentes que levaram a uma nova descoberta
+func (h *APIHandler) handleGetNetworkEvents(w http.ResponseWriter, r *http.Request) {
+	events := []platform.NetworkChange{}
+	if h.network != nil {
+		events = append(events, h.network.Events()...)
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/p2p-vpn/p2p-vpn/core"
+	"github.com/p2p-vpn/p2p-vpn/discovery"
+	nattraversal "github.com/p2p-vpn/p2p-vpn/nat-traversal"
+	"github.com/p2p-vpn/p2p-vpn/platform"
 	"github.com/p2p-vpn/p2p-vpn/security"
 )
 
@@ -23,6 +26,9 @@ type Config struct {
 	ListenAddr     string           // Endereço para escutar (ex: localhost:8080)
 	CoreVPN        core.VPNProvider // Referência para o core da VPN
 	Config         *core.Config     // Configuração geral
+	PendingPeers   *discovery.PendingStore // Fila de aprovação de peers descobertos (opcional)
+	NetworkMonitor *platform.NetworkMonitor // Mudanças de rede exibidas na interface (opcional)
+	NATTraversal   *nattraversal.NATTraversal // Caminho (direto ou relay) de cada peer (opcional)
 	TLSConfig      security.TLSConfig // Configuração TLS para HTTPS
 	JWTSecret      string          // Segredo para JWT (opcional, será gerado aleatoriamente se vazio)
 	JWTExpiration  time.Duration   // Tempo de expiração do token JWT (padrão: 24h)
@@ -88,6 +94,9 @@ func StartServer(config Config) error {
 			"/api/config":         security.PermReadOnly,
 			"/api/peers/add":      security.PermReadWrite,
 			"/api/peers/remove":   security.PermReadWrite,
+			"/api/peers/pending":  security.PermReadOnly,
+			"/api/peers/pending/approve": security.PermAdmin,
+			"/api/peers/pending/reject":  security.PermAdmin,
 			"/api/users":          security.PermAdmin,
 			"/api/auth/register":  security.PermAdmin,
 		},
@@ -104,6 +113,9 @@ func StartServer(config Config) error {
 	
 	// API para gerenciamento da VPN (protegida por autenticação)
 	apiHandler := NewAPIHandler(config.CoreVPN, config.Config)
+	apiHandler.SetPendingStore(config.PendingPeers)
+	apiHandler.SetNetworkMonitor(config.NetworkMonitor)
+	apiHandler.SetNATTraversal(config.NATTraversal)
 	mux.Handle("/api/", authMiddleware.Middleware(apiHandler))
 	
 	// Criar servidor com timeout